@@ -171,12 +171,12 @@ func Start(sockPath string, p Params) (*Gateway, error) {
 // resolveUserToken returns a usable API token for user: an existing one if
 // present, else a freshly minted + cache-injected one (persistent, identifiable).
 func resolveUserToken(dbctx *ctx.Context, user *models.User, cache func(string, *models.User)) (string, error) {
-	toks, err := models.GetTokensByUsername(dbctx, user.Username)
+	existing, err := models.UserTokenGetUnrestricted(dbctx, user.Username)
 	if err != nil {
 		return "", err
 	}
-	if len(toks) > 0 {
-		return toks[0].Token, nil
+	if existing != nil {
+		return existing.Token, nil
 	}
 	tok, err := randToken()
 	if err != nil {
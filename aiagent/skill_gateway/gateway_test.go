@@ -90,7 +90,7 @@ func TestCheckAllowed(t *testing.T) {
 func TestPostQueryAllowlistAndBrief(t *testing.T) {
 	g := testGateway("http://x", nil)
 	// POST is allowed ONLY for the read-only data-query allowlist.
-	for _, p := range []string{"/ds-query", "/query-range-batch", "/query-instant-batch", "/logs-query", "/log-query", "/log-query-batch"} {
+	for _, p := range []string{"/ds-query", "/query-range-batch", "/query-instant-batch", "/query-exemplars-batch", "/logs-query", "/log-query", "/log-query-batch"} {
 		if err := g.checkAllowed("POST", p); err != nil {
 			t.Errorf("POST %s should be allowed (data-query): %v", p, err)
 		}
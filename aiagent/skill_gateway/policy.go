@@ -39,12 +39,13 @@ var defaultDenyN9eAPIPaths = []string{
 // to any path NOT in this set is refused, so this is an allowlist, not the GET
 // deny-list model. Matched as exact, normalized paths (relative to /api/n9e).
 var postAllowN9eAPIPaths = map[string]bool{
-	"/ds-query":            true, // unified datasource query (metrics/logs by cate)
-	"/query-range-batch":   true, // Prometheus range query (batch)
-	"/query-instant-batch": true, // Prometheus instant query (batch)
-	"/logs-query":          true, // log query (v2)
-	"/log-query":           true, // log query
-	"/log-query-batch":     true, // log query (batch)
+	"/ds-query":              true, // unified datasource query (metrics/logs by cate)
+	"/query-range-batch":     true, // Prometheus range query (batch)
+	"/query-instant-batch":   true, // Prometheus instant query (batch)
+	"/query-exemplars-batch": true, // Prometheus exemplar query (batch)
+	"/logs-query":            true, // log query (v2)
+	"/log-query":             true, // log query
+	"/log-query-batch":       true, // log query (batch)
 }
 
 // getAllowExceptions are GET paths that must stay reachable even though they sit
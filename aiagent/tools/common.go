@@ -369,7 +369,9 @@ func getDatasourceType(params map[string]string) string {
 // doesn't include "SQL" — the resolver itself doesn't gate on plugin family.
 //
 // Returns a pre-formatted error if neither source yields a usable id, so
-// callers can propagate it straight to the tool Observation.
+// callers can propagate it straight to the tool Observation. The resolved
+// datasource must also pass checkDatasourceAccess, so every tool built on
+// this resolver honours datasource ACLs without repeating the check.
 func resolveDatasource(deps *aiagent.ToolDeps, args map[string]interface{}, params map[string]string) (int64, string, error) {
 	dsId := getArgInt64(args, "datasource_id")
 	if dsId == 0 {
@@ -399,9 +401,39 @@ func resolveDatasource(deps *aiagent.ToolDeps, args map[string]interface{}, para
 	if dsType == "" {
 		return 0, "", fmt.Errorf("datasource_type not resolvable for id=%d: pass datasource_type explicitly or verify the datasource exists", dsId)
 	}
+	if err := checkDatasourceAccess(deps, params, dsId); err != nil {
+		return 0, "", err
+	}
 	return dsId, dsType, nil
 }
 
+// checkDatasourceAccess mirrors the router's datasource ACL check for tools
+// that query data: the chatting user must see the datasource through the
+// same DatasourceFilter hook used by the web UI. Skipped when no filter is
+// wired (unit tests, embedders without datasource visibility rules).
+func checkDatasourceAccess(deps *aiagent.ToolDeps, params map[string]string, dsId int64) error {
+	if deps == nil || deps.FilterDatasources == nil {
+		return nil
+	}
+
+	user, err := getUser(deps, params)
+	if err != nil {
+		return err
+	}
+
+	ds, err := models.DatasourceGet(deps.DBCtx, dsId)
+	if err != nil {
+		return fmt.Errorf("failed to get datasource %d: %v", dsId, err)
+	}
+	if ds == nil {
+		return fmt.Errorf("datasource not found: id=%d", dsId)
+	}
+	if len(deps.FilterDatasources([]*models.Datasource{ds}, user)) == 0 {
+		return fmt.Errorf("forbidden: no access to datasource %d", dsId)
+	}
+	return nil
+}
+
 // =============================================================================
 // Collection helpers
 // =============================================================================
@@ -38,6 +38,9 @@ func queryPrometheusTool(ctx context.Context, deps *aiagent.ToolDeps, args map[s
 	if dsId == 0 {
 		return "", fmt.Errorf("datasource_id is required (use list_datasources to find a Prometheus datasource id)")
 	}
+	if err := checkDatasourceAccess(deps, params, dsId); err != nil {
+		return "", err
+	}
 
 	query := getArgString(args, "query")
 	if query == "" {
@@ -142,7 +142,7 @@ func createNotifyRule(_ context.Context, deps *aiagent.ToolDeps, args map[string
 	rule.UpdateBy = user.Username
 	rule.UpdateAt = now
 
-	if err := models.Insert(deps.DBCtx, &rule); err != nil {
+	if err := models.CreateNotifyRule(deps.DBCtx, &rule); err != nil {
 		return "", fmt.Errorf("failed to create notify rule: %v", err)
 	}
 
@@ -12,6 +12,12 @@ type Alert struct {
 	Heartbeat   HeartbeatConfig
 	Alerting    Alerting
 	EvalLog     evallog.Config
+	Scrape      Scrape
+}
+
+// Scrape 抓取任务相关配置
+type Scrape struct {
+	SdFileDir string // file_sd 文件所在目录，抓取任务的 sd_file 是相对它的路径，默认 <配置目录>/file_sd
 }
 
 type SMTPConfig struct {
@@ -37,6 +43,43 @@ type Alerting struct {
 	NotifyConcurrency int
 	WebhookBatchSend  bool
 	GlobalWebhook     GlobalWebhook
+	NotifyRateLimit   NotifyRateLimit
+	NotifyRetry       NotifyRetry
+}
+
+// NotifyRetry 通知发送失败后的重试，失败的投递持久化到 DB，按指数退避重试，重启后继续。
+// 超过最大次数进入死信，可以在页面上查看并手动重发。边缘告警引擎发送失败的投递转发给中心端入队，
+// 由中心端重试，中心端也需要开启
+type NotifyRetry struct {
+	Enable        bool
+	Interval      int64                  // 扫描到期重试的间隔，秒，默认 5
+	RetentionDays int                    // 成功和死信记录的保留天数，默认 7
+	Default       RetryPolicy            // 每个通知媒介的默认策略
+	Channels      map[string]RetryPolicy // 按媒介 ident 单独配置，如 ali-sms、email
+}
+
+// RetryPolicy 第 n 次重试的间隔为 Backoff*2^(n-1)，不超过 MaxBackoff
+type RetryPolicy struct {
+	MaxAttempts int   // 包含首次发送在内的最大次数，默认 5，1 表示不重试
+	Backoff     int64 // 首次重试间隔，秒，默认 30
+	MaxBackoff  int64 // 最大重试间隔，秒，默认 3600
+}
+
+// NotifyRateLimit 通知限流，避免单条规则在短时间内发出大量短信、电话等收费通知
+// 超出限制的通知不再发送，按通知规则+媒介汇总，每个汇总周期发一条汇总通知
+type NotifyRateLimit struct {
+	Enable          bool
+	SummaryInterval int64                // 汇总周期，秒，默认 300
+	Channel         RateLimit            // 每个通知媒介的默认限制
+	Channels        map[string]RateLimit // 按媒介 ident 单独配置，如 ali-sms、tx-voice
+	Recipient       RateLimit            // 同一媒介下每个接收人的限制
+	Rule            RateLimit            // 每条通知规则的限制
+}
+
+// RateLimit 令牌桶参数，Rate 为 0 表示不限制
+type RateLimit struct {
+	Rate  float64 // 每分钟补充的令牌数
+	Burst int     // 桶容量，默认为 Rate 向上取整
 }
 
 type GlobalWebhook struct {
@@ -69,6 +112,10 @@ func (a *Alert) PreCheck(configDir, logDir string) {
 		a.Alerting.TemplatesDir = path.Join(configDir, "template")
 	}
 
+	if a.Scrape.SdFileDir == "" {
+		a.Scrape.SdFileDir = path.Join(configDir, "file_sd")
+	}
+
 	if a.EvalLog.Dir == "" && logDir != "" {
 		a.EvalLog.Dir = path.Join(logDir, "evallog")
 	}
@@ -77,6 +124,18 @@ func (a *Alert) PreCheck(configDir, logDir string) {
 		a.Alerting.NotifyConcurrency = 10
 	}
 
+	if a.Alerting.NotifyRateLimit.SummaryInterval <= 0 {
+		a.Alerting.NotifyRateLimit.SummaryInterval = 300
+	}
+
+	if a.Alerting.NotifyRetry.Interval <= 0 {
+		a.Alerting.NotifyRetry.Interval = 5
+	}
+
+	if a.Alerting.NotifyRetry.RetentionDays <= 0 {
+		a.Alerting.NotifyRetry.RetentionDays = 7
+	}
+
 	if a.Heartbeat.Interval == 0 {
 		a.Heartbeat.Interval = 1000
 	}
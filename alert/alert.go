@@ -12,10 +12,12 @@ import (
 	"github.com/ccfos/nightingale/v6/alert/dispatch"
 	"github.com/ccfos/nightingale/v6/alert/eval"
 	"github.com/ccfos/nightingale/v6/alert/naming"
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/script"
 	"github.com/ccfos/nightingale/v6/alert/process"
 	"github.com/ccfos/nightingale/v6/alert/queue"
 	"github.com/ccfos/nightingale/v6/alert/record"
 	"github.com/ccfos/nightingale/v6/alert/router"
+	"github.com/ccfos/nightingale/v6/alert/scrape"
 	"github.com/ccfos/nightingale/v6/alert/sender"
 	"github.com/ccfos/nightingale/v6/conf"
 	"github.com/ccfos/nightingale/v6/dumper"
@@ -26,6 +28,7 @@ import (
 	"github.com/ccfos/nightingale/v6/pkg/httpx"
 	"github.com/ccfos/nightingale/v6/pkg/logx"
 	"github.com/ccfos/nightingale/v6/pkg/macros"
+	"github.com/ccfos/nightingale/v6/pkg/sandbox"
 	"github.com/ccfos/nightingale/v6/prom"
 	"github.com/ccfos/nightingale/v6/pushgw/pconf"
 	"github.com/ccfos/nightingale/v6/pushgw/writer"
@@ -75,6 +78,8 @@ func Initialize(configDir string, cryptoKey string) (func(), error) {
 	dispatch.InitRegisterQueryFunc(promClients)
 
 	externalProcessors := process.NewExternalProcessors()
+	// 事件处理 pipeline 的 script 处理器在告警引擎里执行，单独部署 alert 时也要注入 sandbox
+	script.SetSandbox(sandbox.New(config.Center.Sandbox))
 
 	macros.RegisterMacro(macros.ExpandTimeFilter)
 	dscache.Init(ctx, false, config.Alert.Heartbeat.EngineName)
@@ -108,6 +113,7 @@ func Start(alertc aconf.Alert, pushgwc pconf.Pushgw, syncStats *memsto.Stats, al
 	promClients *prom.PromClientMap, userCache *memsto.UserCacheType, userGroupCache *memsto.UserGroupCacheType, notifyRuleCache *memsto.NotifyRuleCacheType, notifyChannelCache *memsto.NotifyChannelCacheType, messageTemplateCache *memsto.MessageTemplateCacheType, configCvalCache *memsto.CvalCache) {
 	alertSubscribeCache := memsto.NewAlertSubscribeCache(ctx, syncStats)
 	recordingRuleCache := memsto.NewRecordingRuleCache(ctx, syncStats)
+	scrapeJobCache := memsto.NewScrapeJobCache(ctx, syncStats)
 	targetsOfAlertRulesCache := memsto.NewTargetOfAlertRuleCache(ctx, alertc.Heartbeat.EngineName, syncStats)
 
 	// 评估执行记录：本地文件存储，支持按规则+时间范围查询评估现场
@@ -128,7 +134,8 @@ func Start(alertc aconf.Alert, pushgwc pconf.Pushgw, syncStats *memsto.Stats, al
 	// notifyChannelCache.SetDingtalkLeaderNaming(naming)
 
 	writers := writer.NewWriters(pushgwc)
-	record.NewScheduler(alertc, recordingRuleCache, promClients, writers, alertStats, datasourceCache)
+	record.NewScheduler(alertc, recordingRuleCache, promClients, writers, alertStats, datasourceCache, busiGroupCache)
+	scrape.NewScheduler(alertc, pushgwc, scrapeJobCache, targetCache, writers)
 
 	eval.NewScheduler(alertc, externalProcessors, alertRuleCache, targetCache, targetsOfAlertRulesCache,
 		busiGroupCache, alertMuteCache, datasourceCache, promClients, naming, ctx, alertStats)
@@ -144,6 +151,8 @@ func Start(alertc aconf.Alert, pushgwc pconf.Pushgw, syncStats *memsto.Stats, al
 
 	go dp.ReloadTpls()
 	go consumer.LoopConsume()
+	go dispatch.NotifyLimiter.LoopSummary()
+	go dispatch.NotifyRetrier.Loop()
 	go notifyRecordConsumer.LoopConsume()
 
 	go queue.ReportQueueSize(alertStats)
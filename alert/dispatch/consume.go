@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/enrich"
 	"github.com/ccfos/nightingale/v6/alert/queue"
 	"github.com/ccfos/nightingale/v6/alert/sender"
 	"github.com/ccfos/nightingale/v6/memsto"
@@ -53,6 +54,15 @@ func InitRegisterQueryFunc(promClients *prom.PromClientMap) {
 		value, _, _ := readerClient.Query(context.Background(), promql, time.Now())
 		return value
 	})
+
+	enrich.RegisterPromQueryFunc(func(ctx context.Context, datasourceID int64, promql string, ts int64) (model.Value, error) {
+		if promClients.IsNil(datasourceID) {
+			return nil, fmt.Errorf("prometheus datasource %d not exists", datasourceID)
+		}
+
+		value, _, err := promClients.GetCli(datasourceID).Query(ctx, promql, time.Unix(ts, 0))
+		return value, err
+	})
 }
 
 // 创建一个 Consumer 实例
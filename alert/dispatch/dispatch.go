@@ -98,10 +98,14 @@ func NewDispatch(alertRuleCache *memsto.AlertRuleCacheType, userCache *memsto.Us
 
 	pipeline.Init()
 	EventProcessorCache = eventProcessorCache
+	NotifyLimiter = NewNotifyRateLimiter(alerting.NotifyRateLimit)
 
 	// 设置通知记录回调函数
 	notifyChannelCache.SetNotifyRecordFunc(sender.NotifyRecord)
 
+	NotifyRetrier = NewNotifyRetryWorker(alerting.NotifyRetry, c, notifyChannelCache, messageTemplateCache, configCvalCache)
+	notifyChannelCache.SetNotifyResultFunc(NotifyRetrier.HandleResult)
+
 	return notify
 }
 
@@ -599,12 +603,19 @@ func BuildNotifyContext(nctx *ctx.Context, userCache *memsto.UserCacheType, user
 			ImGroupRobotCodes:    imGroupRobotCodes,
 			HttpClient:           httpClient,
 			SiteUrl:              siteUrl,
+			TemplateId:           notifyConfig.TemplateID,
 		},
 	}, nil
 }
 
 func SendNotifyRuleMessage(ctx *ctx.Context, userCache *memsto.UserCacheType, userGroupCache *memsto.UserGroupCacheType, notifyChannelCache *memsto.NotifyChannelCacheType, configCvalCache *memsto.CvalCache,
 	events []*models.AlertCurEvent, notifyRuleId int64, notifyConfig *models.NotifyConfig, notifyChannel *models.NotifyChannelConfig, messageTemplate *models.MessageTemplate) {
+	sendNotifyRuleMessage(ctx, userCache, userGroupCache, notifyChannelCache, configCvalCache, events, notifyRuleId, notifyConfig, notifyChannel, messageTemplate, true)
+}
+
+// sendNotifyRuleMessage limit 为 false 时跳过通知限流，用于发送限流汇总通知
+func sendNotifyRuleMessage(ctx *ctx.Context, userCache *memsto.UserCacheType, userGroupCache *memsto.UserGroupCacheType, notifyChannelCache *memsto.NotifyChannelCacheType, configCvalCache *memsto.CvalCache,
+	events []*models.AlertCurEvent, notifyRuleId int64, notifyConfig *models.NotifyConfig, notifyChannel *models.NotifyChannelConfig, messageTemplate *models.MessageTemplate, limit bool) {
 	if len(events) == 0 {
 		logger.Errorf("notify_id: %d events is empty", notifyRuleId)
 		return
@@ -625,7 +636,28 @@ func SendNotifyRuleMessage(ctx *ctx.Context, userCache *memsto.UserCacheType, us
 		return
 	}
 
-	// 传输层路由：根据 request_type 决定同步/异步
+	if limit {
+		// 通知限流：超限的接收人从请求中移除，全部超限时不再发送，由汇总通知补发
+		summary := func(event *models.AlertCurEvent) {
+			sendNotifyRuleMessage(ctx, userCache, userGroupCache, notifyChannelCache, configCvalCache, []*models.AlertCurEvent{event},
+				notifyRuleId, notifyConfig, notifyChannel, messageTemplate, false)
+		}
+		admitted, throttles := NotifyLimiter.Admit(nc.Request, notifyChannel, summary)
+		if len(throttles) > 0 {
+			logger.Warningf("notify_id: %d, channel_name: %v, notification throttled: %+v", notifyRuleId, notifyChannel.Ident, throttles)
+			recordThrottles(ctx, events, notifyRuleId, notifyChannel.Name, throttles)
+		}
+		if !admitted {
+			return
+		}
+	}
+
+	deliverNotify(ctx, notifyChannelCache, events, notifyRuleId, notifyChannel, nc)
+}
+
+// deliverNotify 传输层路由：根据 request_type 决定同步/异步，失败重试时也走这里
+func deliverNotify(ctx *ctx.Context, notifyChannelCache *memsto.NotifyChannelCacheType, events []*models.AlertCurEvent,
+	notifyRuleId int64, notifyChannel *models.NotifyChannelConfig, nc *NotifyContext) {
 	switch notifyChannel.RequestType {
 	case "http":
 		// HTTP 类型走并发队列 (dingtalk/wecom/feishu/通用http 等都走这里)
@@ -636,7 +668,7 @@ func SendNotifyRuleMessage(ctx *ctx.Context, userCache *memsto.UserCacheType, us
 		})
 		if !success {
 			logger.Errorf("failed to enqueue notify task for channel %d, notify_id: %d", notifyChannel.ID, notifyRuleId)
-			sender.NotifyRecord(ctx, events, notifyRuleId, notifyChannel.Name,
+			notifyResult(ctx, events, nc.Request, notifyChannel.Name,
 				getSendTarget(nc.Request.CustomParams, nc.Request.Sendtos), "",
 				errors.New("failed to enqueue notify task, queue is full"))
 		}
@@ -645,7 +677,7 @@ func SendNotifyRuleMessage(ctx *ctx.Context, userCache *memsto.UserCacheType, us
 		nc.Request.SmtpChan = notifyChannelCache.GetSmtpClient(notifyChannel.ID)
 		result := nc.Provider.Notify(ctx.Ctx, nc.Request)
 		if result == nil {
-			sender.NotifyRecord(ctx, events, notifyRuleId, notifyChannel.Name,
+			notifyResult(ctx, events, nc.Request, notifyChannel.Name,
 				getSendTarget(nc.Request.CustomParams, nc.Request.Sendtos), "",
 				errors.New("smtp provider returned nil result"))
 			return
@@ -655,13 +687,21 @@ func SendNotifyRuleMessage(ctx *ctx.Context, userCache *memsto.UserCacheType, us
 			if target == "" {
 				target = getSendTarget(nc.Request.CustomParams, nc.Request.Sendtos)
 			}
-			sender.NotifyRecord(ctx, events, notifyRuleId, notifyChannel.Name, target, result.Response, result.Err)
+			notifyResult(ctx, events, nc.Request, notifyChannel.Name, target, result.Response, result.Err)
 		}
 	default:
 		// flashduty/pagerduty/script 等直接调用
 		result := nc.Provider.Notify(ctx.Ctx, nc.Request)
-		sender.NotifyRecord(ctx, events, notifyRuleId, notifyChannel.Name, result.Target, result.Response, result.Err)
+		notifyResult(ctx, events, nc.Request, notifyChannel.Name, result.Target, result.Response, result.Err)
+	}
+}
+
+// notifyResult 发送结果先交给失败重试处理，未被接管时写通知记录
+func notifyResult(ctx *ctx.Context, events []*models.AlertCurEvent, req *provider.NotifyRequest, channelName, target, resp string, err error) {
+	if NotifyRetrier.HandleResult(ctx, events, req.Retry(req.Sendtos), target, resp, err) {
+		return
 	}
+	sender.NotifyRecord(ctx, events, req.NotifyRuleId, channelName, target, resp, err)
 }
 
 func NeedBatchContacts(requestConfig *models.HTTPRequestConfig) bool {
@@ -0,0 +1,331 @@
+package dispatch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/alert/sender"
+	"github.com/ccfos/nightingale/v6/alert/sender/provider"
+	"github.com/ccfos/nightingale/v6/memsto"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	notifyRetryBatch    = 100
+	notifyRetryLease    = 300 // 抢占后多久未更新结果可以被再次重试，秒
+	notifyRetryErrorLen = 2000
+)
+
+var NotifyRetrier *NotifyRetryWorker
+
+// NotifyRetryWorker 通知发送失败重试：失败的投递写入 notify_retry，定时扫描到期的记录，
+// 按原通知媒介和消息模板重新渲染发送，超过最大次数后进入死信
+type NotifyRetryWorker struct {
+	cfg aconf.NotifyRetry
+	ctx *ctx.Context
+
+	notifyChannelCache   *memsto.NotifyChannelCacheType
+	messageTemplateCache *memsto.MessageTemplateCacheType
+	configCvalCache      *memsto.CvalCache
+
+	now func() time.Time
+}
+
+func NewNotifyRetryWorker(cfg aconf.NotifyRetry, c *ctx.Context, notifyChannelCache *memsto.NotifyChannelCacheType,
+	messageTemplateCache *memsto.MessageTemplateCacheType, configCvalCache *memsto.CvalCache) *NotifyRetryWorker {
+	return &NotifyRetryWorker{
+		cfg:                  cfg,
+		ctx:                  c,
+		notifyChannelCache:   notifyChannelCache,
+		messageTemplateCache: messageTemplateCache,
+		configCvalCache:      configCvalCache,
+		now:                  time.Now,
+	}
+}
+
+func (w *NotifyRetryWorker) enabled(c *ctx.Context) bool {
+	return w != nil && w.cfg.Enable && c != nil
+}
+
+// Policy 返回通知媒介的重试策略，未单独配置的字段使用默认策略
+func (w *NotifyRetryWorker) Policy(ident string) aconf.RetryPolicy {
+	policy := w.cfg.Default
+	if p, has := w.cfg.Channels[ident]; has {
+		if p.MaxAttempts > 0 {
+			policy.MaxAttempts = p.MaxAttempts
+		}
+		if p.Backoff > 0 {
+			policy.Backoff = p.Backoff
+		}
+		if p.MaxBackoff > 0 {
+			policy.MaxBackoff = p.MaxBackoff
+		}
+	}
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 5
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = 30
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 3600
+	}
+	return policy
+}
+
+// RetryBackoff 第 attempts 次发送失败后，距离下次重试的秒数
+func RetryBackoff(policy aconf.RetryPolicy, attempts int) int64 {
+	delay := policy.Backoff
+	for i := 1; i < attempts && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay
+}
+
+func (w *NotifyRetryWorker) channelIdent(channelId int64) string {
+	if channel := w.notifyChannelCache.Get(channelId); channel != nil {
+		return channel.Ident
+	}
+	return ""
+}
+
+// HandleResult 处理一次发送结果，返回 true 表示结果已被接管并写了通知记录。
+// 首次发送失败时加入重试队列；重试或手动重发的结果更新对应的队列记录
+func (w *NotifyRetryWorker) HandleResult(c *ctx.Context, events []*models.AlertCurEvent, retry *models.NotifyRetry,
+	target, resp string, err error) bool {
+	if !w.enabled(c) || retry == nil {
+		return false
+	}
+
+	now := w.now().Unix()
+	if retry.Id == 0 {
+		if err == nil || retry.ChannelId == 0 || len(retry.EventIds) == 0 {
+			return false
+		}
+		for _, id := range retry.EventIds {
+			// 事件还没有落库，无法在重试时恢复
+			if id == 0 {
+				return false
+			}
+		}
+
+		policy := w.Policy(w.channelIdent(retry.ChannelId))
+		if policy.MaxAttempts <= 1 {
+			return false
+		}
+
+		retry.Target = target
+		retry.Status = models.NotifyRetryPending
+		retry.Attempts = 1
+		retry.MaxAttempts = policy.MaxAttempts
+		retry.NextRetryAt = now + RetryBackoff(policy, 1)
+		retry.LastError = truncateError(err)
+		if e := retry.Add(c); e != nil {
+			logger.Errorf("notify_id: %d, channel_name: %s, failed to add notify retry: %v", retry.NotifyRuleId, retry.ChannelName, e)
+			return false
+		}
+
+		w.record(c, events, retry, target, resp, err, fmt.Sprintf("attempt 1/%d failed, next retry at %s",
+			retry.MaxAttempts, time.Unix(retry.NextRetryAt, 0).Format("2006-01-02 15:04:05")))
+		return true
+	}
+
+	// 重试队列只在中心端扫描和重新发送，边缘告警引擎只负责把首次失败的投递转发给中心端
+	if !c.IsCenter {
+		return false
+	}
+
+	row, e := models.NotifyRetryGet(c, retry.Id)
+	if e != nil || row == nil {
+		logger.Errorf("notify retry %d not found: %v", retry.Id, e)
+		return false
+	}
+
+	attempts := row.Attempts + 1
+	fields := map[string]interface{}{"attempts": attempts}
+	var note string
+	switch {
+	case err == nil:
+		fields["status"] = models.NotifyRetrySucceeded
+		note = fmt.Sprintf("attempt %d succeeded", attempts)
+	case attempts >= row.MaxAttempts:
+		fields["status"] = models.NotifyRetryDead
+		fields["last_error"] = truncateError(err)
+		note = fmt.Sprintf("attempt %d/%d failed, moved to dead letter", attempts, row.MaxAttempts)
+	default:
+		next := now + RetryBackoff(w.Policy(w.channelIdent(row.ChannelId)), attempts)
+		fields["next_retry_at"] = next
+		fields["last_error"] = truncateError(err)
+		note = fmt.Sprintf("attempt %d/%d failed, next retry at %s", attempts, row.MaxAttempts,
+			time.Unix(next, 0).Format("2006-01-02 15:04:05"))
+	}
+
+	if e := row.Update(c, fields); e != nil {
+		logger.Errorf("failed to update notify retry %d: %v", row.Id, e)
+	}
+
+	w.record(c, events, row, target, resp, err, note)
+	return true
+}
+
+// record 写通知记录，并通过 retry_id 关联到重试队列
+func (w *NotifyRetryWorker) record(c *ctx.Context, events []*models.AlertCurEvent, retry *models.NotifyRetry,
+	target, resp string, err error, note string) {
+	notis := make([]*models.NotificationRecord, 0, len(events))
+	for _, event := range events {
+		noti := models.NewNotificationRecord(event, retry.NotifyRuleId, retry.ChannelName, target)
+		noti.RetryId = retry.Id
+		if err != nil {
+			noti.SetStatus(models.NotiStatusFailure)
+			noti.SetDetails(fmt.Sprintf("%s; %s", err.Error(), note))
+		} else {
+			noti.SetDetails(fmt.Sprintf("%s; %s", resp, note))
+		}
+		notis = append(notis, noti)
+	}
+	sender.RecordNotifications(c, notis)
+}
+
+func truncateError(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	if len(msg) > notifyRetryErrorLen {
+		msg = msg[:notifyRetryErrorLen]
+	}
+	return msg
+}
+
+// Loop 定时扫描到期的重试，并清理过期的成功记录和死信。重试队列保存在 DB 中，只在中心端扫描
+func (w *NotifyRetryWorker) Loop() {
+	if !w.enabled(w.ctx) {
+		return
+	}
+
+	if !w.ctx.IsCenter {
+		logger.Infof("notify retry: failed deliveries are forwarded to center and retried there, NotifyRetry must be enabled on center too")
+		return
+	}
+
+	duration := time.Duration(w.cfg.Interval) * time.Second
+	var lastClean int64
+	for {
+		time.Sleep(duration)
+		w.RunOnce()
+
+		now := w.now().Unix()
+		if now-lastClean >= 3600 {
+			lastClean = now
+			before := now - int64(w.cfg.RetentionDays)*86400
+			if err := models.NotifyRetryDeleteBefore(w.ctx, before); err != nil {
+				logger.Warningf("failed to clean notify retry: %v", err)
+			}
+		}
+	}
+}
+
+func (w *NotifyRetryWorker) RunOnce() {
+	lst, err := models.NotifyRetryDue(w.ctx, w.now().Unix(), notifyRetryBatch)
+	if err != nil {
+		logger.Warningf("failed to get due notify retry: %v", err)
+		return
+	}
+
+	for _, row := range lst {
+		claimed, err := models.NotifyRetryClaim(w.ctx, row, notifyRetryLease)
+		if err != nil {
+			logger.Warningf("failed to claim notify retry %d: %v", row.Id, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		w.redeliver(row)
+	}
+}
+
+// redeliver 按原通知媒介和消息模板重新发送，结果通过 HandleResult 回写
+func (w *NotifyRetryWorker) redeliver(row *models.NotifyRetry) {
+	channel := w.notifyChannelCache.Get(row.ChannelId)
+	if channel == nil {
+		w.giveUp(row, fmt.Sprintf("notify channel %d not found", row.ChannelId))
+		return
+	}
+
+	var messageTemplate *models.MessageTemplate
+	if channel.RequestType != "flashduty" && channel.RequestType != "pagerduty" {
+		messageTemplate = w.messageTemplateCache.Get(row.TemplateId)
+		if messageTemplate == nil {
+			w.giveUp(row, fmt.Sprintf("message template %d not found", row.TemplateId))
+			return
+		}
+	}
+
+	p, ok := provider.DefaultRegistry.Resolve(channel)
+	if !ok {
+		w.giveUp(row, fmt.Sprintf("unknown channel ident(%s), request_type(%s)", channel.Ident, channel.RequestType))
+		return
+	}
+
+	hisEvents, err := models.AlertHisEventGetByIds(w.ctx, row.EventIds)
+	if err != nil {
+		// 租约到期后会再次重试
+		logger.Warningf("notify retry %d: failed to get events: %v", row.Id, err)
+		return
+	}
+	if len(hisEvents) == 0 {
+		w.giveUp(row, "alert events not found")
+		return
+	}
+
+	events := make([]*models.AlertCurEvent, 0, len(hisEvents))
+	for _, he := range hisEvents {
+		events = append(events, he.ToCur())
+	}
+
+	siteInfo := w.configCvalCache.GetSiteInfo()
+	tplContent := make(map[string]interface{})
+	if messageTemplate != nil {
+		tplContent = messageTemplate.RenderEvent(events, siteInfo.SiteUrl)
+	}
+
+	deliverNotify(w.ctx, w.notifyChannelCache, events, row.NotifyRuleId, channel, &NotifyContext{
+		Provider: p,
+		Request: &provider.NotifyRequest{
+			NotifyRuleId:         row.NotifyRuleId,
+			Config:               channel,
+			Events:               events,
+			TplContent:           tplContent,
+			FlashDutyChannelIDs:  row.FlashDutyChannelIDs,
+			PagerDutyRoutingKeys: row.PagerDutyRoutingKeys,
+			CustomParams:         row.CustomParams,
+			Sendtos:              row.Sendtos,
+			ImGroupIDs:           row.ImGroupIDs,
+			HttpClient:           w.notifyChannelCache.GetHttpClient(channel.ID),
+			SiteUrl:              siteInfo.SiteUrl,
+			TemplateId:           row.TemplateId,
+			RetryId:              row.Id,
+		},
+	})
+}
+
+// giveUp 无法再重新发送（媒介、模板或事件已被删除），直接进入死信
+func (w *NotifyRetryWorker) giveUp(row *models.NotifyRetry, reason string) {
+	logger.Warningf("notify retry %d moved to dead letter: %s", row.Id, reason)
+	err := row.Update(w.ctx, map[string]interface{}{
+		"status":     models.NotifyRetryDead,
+		"last_error": reason,
+	})
+	if err != nil {
+		logger.Errorf("failed to update notify retry %d: %v", row.Id, err)
+	}
+}
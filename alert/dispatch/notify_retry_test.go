@@ -0,0 +1,80 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/conf"
+	"github.com/ccfos/nightingale/v6/memsto"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	w := NewNotifyRetryWorker(aconf.NotifyRetry{
+		Enable:   true,
+		Default:  aconf.RetryPolicy{MaxAttempts: 4},
+		Channels: map[string]aconf.RetryPolicy{"ali-sms": {Backoff: 10, MaxBackoff: 60}},
+	}, nil, nil, nil, nil)
+
+	assert.Equal(t, aconf.RetryPolicy{MaxAttempts: 4, Backoff: 30, MaxBackoff: 3600}, w.Policy("email"))
+
+	policy := w.Policy("ali-sms")
+	assert.Equal(t, aconf.RetryPolicy{MaxAttempts: 4, Backoff: 10, MaxBackoff: 60}, policy)
+
+	var delays []int64
+	for attempts := 1; attempts <= 5; attempts++ {
+		delays = append(delays, RetryBackoff(policy, attempts))
+	}
+	assert.Equal(t, []int64{10, 20, 40, 60, 60}, delays)
+}
+
+func TestHandleResultDisabled(t *testing.T) {
+	// 未开启时不接管结果，由调用方照常写通知记录
+	var w *NotifyRetryWorker
+	assert.False(t, w.HandleResult(nil, nil, nil, "", "", nil))
+
+	w = NewNotifyRetryWorker(aconf.NotifyRetry{}, nil, nil, nil, nil)
+	assert.False(t, w.HandleResult(nil, nil, nil, "", "", nil))
+}
+
+func TestHandleResultForwardToCenter(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded models.NotifyRetry
+	paths := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths[r.URL.Path]++
+		if r.URL.Path == "/v1/n9e/notify-retry-add" {
+			json.NewDecoder(r.Body).Decode(&forwarded)
+			w.Write([]byte(`{"dat":7,"err":""}`))
+			return
+		}
+		w.Write([]byte(`{"dat":"","err":""}`))
+	}))
+	defer server.Close()
+
+	// 边缘告警引擎没有 DB，首次发送失败的投递转发给中心端入队
+	c := &ctx.Context{CenterApi: conf.CenterApi{Addrs: []string{server.URL}}}
+	w := NewNotifyRetryWorker(aconf.NotifyRetry{Enable: true}, c, &memsto.NotifyChannelCacheType{}, nil, nil)
+	retry := &models.NotifyRetry{NotifyRuleId: 1, ChannelId: 2, EventIds: []int64{3}}
+	events := []*models.AlertCurEvent{{Id: 3}}
+	require.True(t, w.HandleResult(c, events, retry, "13800000000", "", errors.New("timeout")))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, int64(7), retry.Id)
+	assert.Equal(t, models.NotifyRetryPending, forwarded.Status)
+	assert.Equal(t, 5, forwarded.MaxAttempts)
+	assert.Equal(t, "13800000000", forwarded.Target)
+	assert.Equal(t, 1, paths["/v1/n9e/notify-retry-add"])
+	assert.Equal(t, 1, paths["/v1/n9e/notify-record"])
+}
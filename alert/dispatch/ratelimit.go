@@ -0,0 +1,435 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/alert/sender"
+	"github.com/ccfos/nightingale/v6/alert/sender/provider"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/toolkits/pkg/logger"
+	"github.com/toolkits/pkg/str"
+)
+
+// 限流维度
+const (
+	LimitScopeChannel   = "channel"
+	LimitScopeRecipient = "recipient"
+	LimitScopeRule      = "rule"
+)
+
+// 汇总通知写入事件注解的 key，消息模板可以通过 $event.AnnotationsJSON 取到
+const RateLimitSummaryAnnotation = "notify_rate_limit_summary"
+
+// 汇总通知的标签，接收方可以据此把汇总和普通告警区分开
+const RateLimitSummaryTag = "__notify_rate_limit_summary__"
+
+// 长时间没有使用且已经回满的桶会被回收，避免接收人维度的桶无限增长
+const bucketIdleTTL = 10 * time.Minute
+
+// NotifyLimiter 通知限流器，在 dispatch 调用 NotifyChannelProvider.Notify 之前生效
+var NotifyLimiter = NewNotifyRateLimiter(aconf.NotifyRateLimit{})
+
+type tokenBucket struct {
+	tokens    float64
+	burst     float64
+	perSec    float64
+	last      time.Time
+	used      time.Time
+	throttled int64
+}
+
+func newTokenBucket(limit aconf.RateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(limit.Rate)
+	}
+	return &tokenBucket{tokens: burst, burst: burst, perSec: limit.Rate / 60, last: now, used: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.perSec
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// suppressedSummary 一个汇总周期内某条通知规则在某个媒介上被限流的通知
+type suppressedSummary struct {
+	NotifyRuleId int64          `json:"notify_rule_id"`
+	ChannelId    int64          `json:"channel_id"`
+	ChannelName  string         `json:"channel_name"`
+	Count        int            `json:"count"`
+	Scopes       map[string]int `json:"scopes"`
+	FirstAt      int64          `json:"first_at"`
+	LastAt       int64          `json:"last_at"`
+
+	event *models.AlertCurEvent
+	send  func(event *models.AlertCurEvent)
+}
+
+type NotifyRateLimiter struct {
+	sync.Mutex
+	cfg       aconf.NotifyRateLimit
+	buckets   map[string]*tokenBucket
+	summaries map[string]*suppressedSummary
+	now       func() time.Time
+}
+
+func NewNotifyRateLimiter(cfg aconf.NotifyRateLimit) *NotifyRateLimiter {
+	return &NotifyRateLimiter{
+		cfg:       cfg,
+		buckets:   make(map[string]*tokenBucket),
+		summaries: make(map[string]*suppressedSummary),
+		now:       time.Now,
+	}
+}
+
+// Throttle 一次限流决策
+type Throttle struct {
+	Scope   string
+	Targets []string // 被限流的接收人，整条通知被限流时为全部接收人
+	Reason  string
+}
+
+// Admit 判断一次通知是否放行，被限流的接收人会从 req 中移除
+// 返回 false 表示整条通知都不再发送。send 用于周期汇总时补发一条汇总通知
+func (l *NotifyRateLimiter) Admit(req *provider.NotifyRequest, notifyChannel *models.NotifyChannelConfig,
+	send func(event *models.AlertCurEvent)) (bool, []Throttle) {
+	if !l.cfg.Enable || req == nil || notifyChannel == nil {
+		return true, nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	ruleKey := fmt.Sprintf("%s:%d", LimitScopeRule, req.NotifyRuleId)
+	channelKey := fmt.Sprintf("%s:%s", LimitScopeChannel, notifyChannel.Ident)
+	recipients := requestRecipients(req)
+
+	var throttles []Throttle
+	allDenied := func(scope, reason string) (bool, []Throttle) {
+		l.suppress(req, notifyChannel, scope, now, send)
+		return false, append(throttles, Throttle{Scope: scope, Targets: recipients, Reason: reason})
+	}
+
+	ruleBucket := l.bucket(ruleKey, l.cfg.Rule, now)
+	if ruleBucket != nil && ruleBucket.tokens < 1 {
+		ruleBucket.throttled++
+		return allDenied(LimitScopeRule, fmt.Sprintf("notify rule exceeds %s", formatLimit(l.cfg.Rule)))
+	}
+
+	channelLimit := l.channelLimit(notifyChannel.Ident)
+	channelBucket := l.bucket(channelKey, channelLimit, now)
+	if channelBucket != nil && channelBucket.tokens < 1 {
+		channelBucket.throttled++
+		return allDenied(LimitScopeChannel, fmt.Sprintf("channel %s exceeds %s", notifyChannel.Ident, formatLimit(channelLimit)))
+	}
+
+	allowed := make(map[string]bool, len(recipients))
+	denied := make([]string, 0)
+	for _, recipient := range recipients {
+		b := l.bucket(fmt.Sprintf("%s:%s:%s", LimitScopeRecipient, notifyChannel.Ident, recipient), l.cfg.Recipient, now)
+		if b != nil && b.tokens < 1 {
+			b.throttled++
+			denied = append(denied, recipient)
+			continue
+		}
+		allowed[recipient] = true
+	}
+
+	if len(denied) > 0 {
+		throttles = append(throttles, Throttle{
+			Scope:   LimitScopeRecipient,
+			Targets: denied,
+			Reason:  fmt.Sprintf("recipient exceeds %s", formatLimit(l.cfg.Recipient)),
+		})
+		if len(allowed) == 0 {
+			l.suppress(req, notifyChannel, LimitScopeRecipient, now, send)
+			return false, throttles
+		}
+	}
+
+	// 所有维度都放行后再扣令牌，避免被其他维度拦下的通知白白消耗额度
+	for _, b := range []*tokenBucket{ruleBucket, channelBucket} {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	for recipient := range allowed {
+		if b := l.buckets[fmt.Sprintf("%s:%s:%s", LimitScopeRecipient, notifyChannel.Ident, recipient)]; b != nil {
+			b.tokens--
+		}
+	}
+
+	if len(denied) > 0 {
+		filterRecipients(req, allowed)
+	}
+
+	return true, throttles
+}
+
+func (l *NotifyRateLimiter) channelLimit(ident string) aconf.RateLimit {
+	if limit, has := l.cfg.Channels[ident]; has {
+		return limit
+	}
+	return l.cfg.Channel
+}
+
+// bucket 返回 key 对应的令牌桶（已按当前时间补充令牌），未配置限制时返回 nil
+func (l *NotifyRateLimiter) bucket(key string, limit aconf.RateLimit, now time.Time) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	b, has := l.buckets[key]
+	if !has {
+		b = newTokenBucket(limit, now)
+		l.buckets[key] = b
+	}
+	b.refill(now)
+	b.used = now
+	return b
+}
+
+func (l *NotifyRateLimiter) suppress(req *provider.NotifyRequest, notifyChannel *models.NotifyChannelConfig, scope string,
+	now time.Time, send func(event *models.AlertCurEvent)) {
+	key := fmt.Sprintf("%d:%d", req.NotifyRuleId, notifyChannel.ID)
+	s, has := l.summaries[key]
+	if !has {
+		s = &suppressedSummary{
+			NotifyRuleId: req.NotifyRuleId,
+			ChannelId:    notifyChannel.ID,
+			ChannelName:  notifyChannel.Name,
+			Scopes:       make(map[string]int),
+			FirstAt:      now.Unix(),
+		}
+		if len(req.Events) > 0 {
+			s.event = req.Events[0]
+		}
+		l.summaries[key] = s
+	}
+
+	s.Count += len(req.Events)
+	s.Scopes[scope] += len(req.Events)
+	s.LastAt = now.Unix()
+	if send != nil {
+		s.send = send
+	}
+}
+
+// LoopSummary 周期性地为被限流的通知发送汇总，并回收空闲的令牌桶
+func (l *NotifyRateLimiter) LoopSummary() {
+	if !l.cfg.Enable {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(l.cfg.SummaryInterval) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.FlushSummaries()
+	}
+}
+
+// FlushSummaries 发送当前周期的汇总通知，每条通知规则在每个媒介上只发一条
+func (l *NotifyRateLimiter) FlushSummaries() {
+	l.Lock()
+	summaries := l.summaries
+	l.summaries = make(map[string]*suppressedSummary)
+
+	now := l.now()
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst && now.Sub(b.used) > bucketIdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+	l.Unlock()
+
+	for _, s := range summaries {
+		logger.Warningf("notify rate limit: notify_id: %d, channel: %s, %d notifications suppressed between %d and %d, scopes: %v",
+			s.NotifyRuleId, s.ChannelName, s.Count, s.FirstAt, s.LastAt, s.Scopes)
+
+		if s.send == nil || s.event == nil {
+			continue
+		}
+
+		s.send(s.summaryEvent())
+	}
+}
+
+// summaryEvent 汇总通知单独成一条消息：沿用被限流事件的规则、业务组等信息以便按原规则发送，
+// 标题、内容、标签和注解换成被限流的数量和时间段，避免接收人看到的是一条重复的告警
+func (s *suppressedSummary) summaryEvent() *models.AlertCurEvent {
+	first := time.Unix(s.FirstAt, 0).Format("2006-01-02 15:04:05")
+	last := time.Unix(s.LastAt, 0).Format("2006-01-02 15:04:05")
+	scopes := make([]string, 0, len(s.Scopes))
+	for scope, count := range s.Scopes {
+		scopes = append(scopes, fmt.Sprintf("%s=%d", scope, count))
+	}
+	sort.Strings(scopes)
+	text := fmt.Sprintf("%d notifications suppressed by notification rate limit between %s and %s", s.Count, first, last)
+
+	event := s.event.DeepCopy()
+	event.Id = 0
+	event.Hash = str.MD5(fmt.Sprintf("%s_%d_%d_%d", RateLimitSummaryTag, s.NotifyRuleId, s.ChannelId, s.FirstAt))
+	event.RuleName = fmt.Sprintf("[Rate limited] %s", event.RuleName)
+	event.RuleNote = text
+	event.TargetIdent = ""
+	event.TriggerValue = fmt.Sprint(s.Count)
+	event.TriggerTime = s.LastAt
+	event.FirstTriggerTime = s.FirstAt
+	event.LastEvalTime = s.LastAt
+	event.IsRecovered = false
+	event.TagsJSON = []string{
+		RateLimitSummaryTag + "=true",
+		fmt.Sprintf("notify_rule_id=%d", s.NotifyRuleId),
+		fmt.Sprintf("channel=%s", s.ChannelName),
+	}
+	event.Tags = strings.Join(event.TagsJSON, ",,")
+	event.OriginalTagsJSON = event.TagsJSON
+	event.OriginalTags = event.Tags
+	event.SetTagsMap()
+	event.AnnotationsJSON = map[string]string{
+		RateLimitSummaryAnnotation: text,
+		"suppressed_count":         fmt.Sprint(s.Count),
+		"suppressed_scopes":        strings.Join(scopes, " "),
+		"first_at":                 fmt.Sprint(s.FirstAt),
+		"last_at":                  fmt.Sprint(s.LastAt),
+	}
+	if bs, err := json.Marshal(event.AnnotationsJSON); err == nil {
+		event.Annotations = string(bs)
+	}
+	return event
+}
+
+// LimiterState 令牌桶的当前状态
+type LimiterState struct {
+	Key       string  `json:"key"`
+	Scope     string  `json:"scope"`
+	Tokens    float64 `json:"tokens"`
+	Burst     float64 `json:"burst"`
+	PerMinute float64 `json:"per_minute"`
+	Throttled int64   `json:"throttled"`
+	LastUsed  int64   `json:"last_used"`
+}
+
+// RateLimitSnapshot 限流器当前状态，用于 API 展示
+type RateLimitSnapshot struct {
+	Enable          bool                  `json:"enable"`
+	SummaryInterval int64                 `json:"summary_interval"`
+	Buckets         []LimiterState        `json:"buckets"`
+	Suppressed      []*suppressedSummary  `json:"suppressed"`
+	Config          aconf.NotifyRateLimit `json:"config"`
+}
+
+// Snapshot 返回所有令牌桶和当前周期内被限流通知的快照，scope 不为空时只返回该维度的桶
+func (l *NotifyRateLimiter) Snapshot(scope string) RateLimitSnapshot {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	snap := RateLimitSnapshot{
+		Enable:          l.cfg.Enable,
+		SummaryInterval: l.cfg.SummaryInterval,
+		Buckets:         make([]LimiterState, 0, len(l.buckets)),
+		Suppressed:      make([]*suppressedSummary, 0, len(l.summaries)),
+		Config:          l.cfg,
+	}
+
+	for key, b := range l.buckets {
+		bucketScope := key[:strings.Index(key, ":")]
+		if scope != "" && scope != bucketScope {
+			continue
+		}
+
+		b.refill(now)
+		snap.Buckets = append(snap.Buckets, LimiterState{
+			Key:       key,
+			Scope:     bucketScope,
+			Tokens:    math.Floor(b.tokens*100) / 100,
+			Burst:     b.burst,
+			PerMinute: b.perSec * 60,
+			Throttled: b.throttled,
+			LastUsed:  b.used.Unix(),
+		})
+	}
+	sort.Slice(snap.Buckets, func(i, j int) bool { return snap.Buckets[i].Key < snap.Buckets[j].Key })
+
+	for _, s := range l.summaries {
+		summary := *s
+		summary.Scopes = make(map[string]int, len(s.Scopes))
+		for scope, count := range s.Scopes {
+			summary.Scopes[scope] = count
+		}
+		snap.Suppressed = append(snap.Suppressed, &summary)
+	}
+	sort.Slice(snap.Suppressed, func(i, j int) bool { return snap.Suppressed[i].FirstAt < snap.Suppressed[j].FirstAt })
+
+	return snap
+}
+
+// requestRecipients 通知的接收人，没有 sendtos 的媒介（如群机器人）以发送目标作为唯一接收人
+func requestRecipients(req *provider.NotifyRequest) []string {
+	if len(req.Sendtos) > 0 {
+		return req.Sendtos
+	}
+
+	if target := getSendTarget(req.CustomParams, req.Sendtos); target != "" {
+		return []string{target}
+	}
+
+	return []string{}
+}
+
+func filterRecipients(req *provider.NotifyRequest, allowed map[string]bool) {
+	sendtos := make([]string, 0, len(req.Sendtos))
+	for _, sendto := range req.Sendtos {
+		if allowed[sendto] {
+			sendtos = append(sendtos, sendto)
+		}
+	}
+	req.Sendtos = sendtos
+}
+
+func formatLimit(limit aconf.RateLimit) string {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(limit.Rate))
+	}
+	return fmt.Sprintf("%g/min (burst %d)", limit.Rate, burst)
+}
+
+// recordThrottles 为被限流的通知写通知记录，每个接收人一条
+func recordThrottles(ctx *ctx.Context, events []*models.AlertCurEvent, notifyRuleId int64, channelName string, throttles []Throttle) {
+	notis := make([]*models.NotificationRecord, 0)
+	now := time.Now().Unix()
+	for _, throttle := range throttles {
+		targets := throttle.Targets
+		if len(targets) == 0 {
+			targets = []string{""}
+		}
+
+		for _, target := range targets {
+			for _, event := range events {
+				noti := models.NewNotificationRecord(event, notifyRuleId, channelName, target)
+				noti.SetStatus(models.NotiStatusThrottled)
+				noti.SetDetails(fmt.Sprintf("throttled by %s rate limit: %s, folded into periodic summary", throttle.Scope, throttle.Reason))
+				noti.CreatedAt = now
+				notis = append(notis, noti)
+			}
+		}
+	}
+
+	sender.RecordNotifications(ctx, notis)
+}
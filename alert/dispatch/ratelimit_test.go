@@ -0,0 +1,111 @@
+package dispatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/alert/sender/provider"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLimiter(cfg aconf.NotifyRateLimit) (*NotifyRateLimiter, *time.Time) {
+	cfg.Enable = true
+	cfg.SummaryInterval = 300
+	l := NewNotifyRateLimiter(cfg)
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func newTestRequest(sendtos ...string) *provider.NotifyRequest {
+	return &provider.NotifyRequest{
+		NotifyRuleId: 1,
+		Events:       []*models.AlertCurEvent{{Id: 10, AnnotationsJSON: map[string]string{}}},
+		Sendtos:      sendtos,
+	}
+}
+
+func TestAdmitChannelLimit(t *testing.T) {
+	l, now := newTestLimiter(aconf.NotifyRateLimit{
+		Channels: map[string]aconf.RateLimit{"ali-sms": {Rate: 2}},
+	})
+	channel := &models.NotifyChannelConfig{ID: 5, Ident: "ali-sms", Name: "SMS"}
+
+	for i := 0; i < 2; i++ {
+		ok, throttles := l.Admit(newTestRequest("13800000000"), channel, nil)
+		assert.True(t, ok)
+		assert.Empty(t, throttles)
+	}
+
+	ok, throttles := l.Admit(newTestRequest("13800000000"), channel, nil)
+	assert.False(t, ok)
+	assert.Equal(t, LimitScopeChannel, throttles[0].Scope)
+
+	// 其他媒介不受影响
+	ok, _ = l.Admit(newTestRequest("13800000000"), &models.NotifyChannelConfig{ID: 6, Ident: "email"}, nil)
+	assert.True(t, ok)
+
+	// 30 秒补充一个令牌
+	*now = now.Add(30 * time.Second)
+	ok, _ = l.Admit(newTestRequest("13800000000"), channel, nil)
+	assert.True(t, ok)
+
+	snap := l.Snapshot(LimitScopeChannel)
+	assert.Len(t, snap.Buckets, 1)
+	assert.Equal(t, int64(1), snap.Buckets[0].Throttled)
+	assert.Equal(t, 1, snap.Suppressed[0].Count)
+}
+
+func TestAdmitRecipientLimit(t *testing.T) {
+	l, _ := newTestLimiter(aconf.NotifyRateLimit{
+		Recipient: aconf.RateLimit{Rate: 1},
+		Rule:      aconf.RateLimit{Rate: 10},
+	})
+	channel := &models.NotifyChannelConfig{ID: 5, Ident: "tx-voice"}
+
+	ok, _ := l.Admit(newTestRequest("a"), channel, nil)
+	assert.True(t, ok)
+
+	// a 超限被移除，b 正常发送
+	req := newTestRequest("a", "b")
+	ok, throttles := l.Admit(req, channel, nil)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"b"}, req.Sendtos)
+	assert.Equal(t, []string{"a"}, throttles[0].Targets)
+
+	// 全部接收人超限时整条通知不发送，且不消耗规则维度的令牌
+	ok, _ = l.Admit(newTestRequest("a", "b"), channel, nil)
+	assert.False(t, ok)
+	for _, b := range l.Snapshot(LimitScopeRule).Buckets {
+		assert.Equal(t, float64(8), b.Tokens)
+	}
+}
+
+func TestFlushSummaries(t *testing.T) {
+	l, _ := newTestLimiter(aconf.NotifyRateLimit{Rule: aconf.RateLimit{Rate: 1}})
+	channel := &models.NotifyChannelConfig{ID: 5, Ident: "ali-sms"}
+
+	var sent []*models.AlertCurEvent
+	send := func(event *models.AlertCurEvent) { sent = append(sent, event) }
+
+	for i := 0; i < 4; i++ {
+		l.Admit(newTestRequest("a"), channel, send)
+	}
+
+	l.FlushSummaries()
+	assert.Len(t, sent, 1)
+	summary := sent[0]
+	assert.Zero(t, summary.Id)
+	assert.Equal(t, "3", summary.TriggerValue)
+	assert.Equal(t, "3", summary.AnnotationsJSON["suppressed_count"])
+	assert.Equal(t, "rule=3", summary.AnnotationsJSON["suppressed_scopes"])
+	assert.Contains(t, summary.RuleNote, "3 notifications suppressed by notification rate limit between")
+	assert.Equal(t, "true", summary.TagsMap[RateLimitSummaryTag])
+	assert.Empty(t, l.Snapshot("").Suppressed)
+
+	// 汇总后清空，下个周期没有被限流的通知时不再发送
+	l.FlushSummaries()
+	assert.Len(t, sent, 1)
+}
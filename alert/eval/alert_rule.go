@@ -93,8 +93,10 @@ func (s *Scheduler) syncAlertRules() {
 		}
 
 		ruleType := rule.GetRuleType()
+		// 只匹配规则所在业务组的租户下的数据源
+		tid := s.busiGroupCache.GetTenantId(rule.GroupId)
 		if rule.IsPrometheusRule() || rule.IsInnerRule() {
-			datasourceIds := s.datasourceCache.GetIDsByDsCateAndQueries(rule.Cate, rule.DatasourceQueries)
+			datasourceIds := s.datasourceCache.GetIDsByDsCateAndQueriesOfTenant(rule.Cate, rule.DatasourceQueries, tid)
 			for _, dsId := range datasourceIds {
 				if !naming.DatasourceHashRing.IsHit(strconv.FormatInt(dsId, 10), fmt.Sprintf("%d", rule.Id), s.aconf.Heartbeat.Endpoint) {
 					continue
@@ -130,7 +132,7 @@ func (s *Scheduler) syncAlertRules() {
 		} else {
 			// 如果 rule 不是通过 prometheus engine 来告警的，则创建为 externalRule
 			// if rule is not processed by prometheus engine, create it as externalRule
-			dsIds := s.datasourceCache.GetIDsByDsCateAndQueries(rule.Cate, rule.DatasourceQueries)
+			dsIds := s.datasourceCache.GetIDsByDsCateAndQueriesOfTenant(rule.Cate, rule.DatasourceQueries, tid)
 			for _, dsId := range dsIds {
 				ds := s.datasourceCache.GetById(dsId)
 				if ds == nil {
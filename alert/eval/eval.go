@@ -1560,7 +1560,7 @@ func (arw *AlertRuleWorker) GetAnomalyPoint(rule *models.AlertRule, dsId int64)
 		for i, query := range ruleQuery.Queries {
 			seriesTagIndex := make(map[uint64][]uint64)
 
-			plug, exists := dscache.DsCache.Get(rule.Cate, dsId)
+			plug, exists := dscache.DsCache.GetOfTenant(rule.Cate, dsId, arw.Processor.BusiGroupCache.GetTenantId(rule.GroupId))
 			if !exists {
 				logger.Warningf("alert_eval_%d datasource_%d not exists", rule.Id, dsId)
 				arw.Processor.Stats.CounterRuleEvalErrorTotal.WithLabelValues(fmt.Sprintf("%v", arw.Processor.DatasourceId()), GET_CLIENT, arw.Processor.BusiGroupCache.GetNameByBusiGroupId(arw.Rule.GroupId), fmt.Sprintf("%v", arw.Rule.Id)).Inc()
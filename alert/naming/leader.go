@@ -3,9 +3,21 @@ package naming
 import (
 	"sort"
 
+	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
 	"github.com/toolkits/pkg/logger"
 )
 
+// NewLeaderChecker 只用于选主判断，不上报心跳（心跳由告警引擎的 Naming 负责），
+// 供 center 上只需单实例执行的定时任务使用
+func NewLeaderChecker(ctx *ctx.Context, heartbeat aconf.HeartbeatConfig) *Naming {
+	return &Naming{
+		ctx:             ctx,
+		heartbeatConfig: heartbeat,
+	}
+}
+
 func (n *Naming) IamLeader() bool {
 	if !n.ctx.IsCenter {
 		return false
@@ -1,7 +1,10 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/ccfos/nightingale/v6/models"
@@ -92,6 +95,8 @@ func (e *WorkflowEngine) initWorkflowContext(pipeline *models.EventPipeline, eve
 }
 
 // executeDAG 使用 Kahn 算法执行 DAG
+// 未命中的分支会沿连接向下游传播：普通节点有任一输入未命中时跳过，
+// merge 节点只要有一路输入被执行就会执行。foreach 节点的循环体由 executeForEach 按元素执行
 func (e *WorkflowEngine) executeDAG(nodeMap map[string]*models.WorkflowNode, connections models.Connections, wfCtx *models.WorkflowContext) *models.WorkflowResult {
 	result := &models.WorkflowResult{
 		Status:      models.ExecutionStatusSuccess,
@@ -133,6 +138,32 @@ func (e *WorkflowEngine) executeDAG(nodeMap map[string]*models.WorkflowNode, con
 	executed := make(map[string]bool)
 	// 记录节点的分支选择结果
 	branchResults := make(map[string]*int)
+	// 尚未结束的输入个数，为 0 时节点入队
+	pending := make(map[string]int, len(inDegree))
+	for nodeID, degree := range inDegree {
+		pending[nodeID] = degree
+	}
+	// 已命中的输入个数
+	activeIn := make(map[string]int)
+	// merge 节点收集到的输入
+	mergeInputs := make(map[string][]*models.MergeInput)
+
+	// resolve 结束目标节点的一路输入
+	resolve := func(target string, active bool, input *models.MergeInput) {
+		if active {
+			activeIn[target]++
+			if node, ok := nodeMap[target]; ok && node.Type == models.NodeTypeMerge && input != nil {
+				if !slices.ContainsFunc(mergeInputs[target], func(in *models.MergeInput) bool { return in.NodeID == input.NodeID }) {
+					mergeInputs[target] = append(mergeInputs[target], input)
+				}
+			}
+		}
+
+		pending[target]--
+		if pending[target] == 0 {
+			queue = append(queue, target)
+		}
+	}
 
 	for len(queue) > 0 {
 		// 取出队首节点
@@ -149,8 +180,19 @@ func (e *WorkflowEngine) executeDAG(nodeMap map[string]*models.WorkflowNode, con
 			continue
 		}
 
+		// 上游分支未命中，跳过本节点，并让下游的输入也随之结束
+		if !e.shouldRunNode(node, inDegree[nodeID], activeIn[nodeID]) {
+			executed[nodeID] = true
+			for _, targets := range connections[nodeID].Main {
+				for _, target := range targets {
+					resolve(target.Node, false, nil)
+				}
+			}
+			continue
+		}
+
 		// 执行节点
-		nodeResult, nodeOutput := e.executeNode(node, wfCtx)
+		nodeResult, nodeOutput := e.executeNode(node, wfCtx, mergeInputs[nodeID])
 		result.NodeResults = append(result.NodeResults, nodeResult)
 
 		if nodeOutput != nil && nodeOutput.Stream && nodeOutput.StreamChan != nil {
@@ -176,6 +218,12 @@ func (e *WorkflowEngine) executeDAG(nodeMap map[string]*models.WorkflowNode, con
 			branchResults[nodeID] = nodeResult.BranchIndex
 		}
 
+		// foreach 失败时不执行循环体，走输出 1
+		if node.Type == models.NodeTypeForEach && nodeResult.Status == "failed" {
+			doneIndex := 1
+			branchResults[nodeID] = &doneIndex
+		}
+
 		// 检查执行状态
 		if nodeResult.Status == "failed" {
 			if !node.ContinueOnFail {
@@ -191,19 +239,41 @@ func (e *WorkflowEngine) executeDAG(nodeMap map[string]*models.WorkflowNode, con
 			return result
 		}
 
+		input := &models.MergeInput{
+			NodeID:   node.ID,
+			NodeName: node.Name,
+			Status:   nodeResult.Status,
+			Message:  nodeResult.Message,
+		}
+
+		// 执行 foreach 循环体，循环体连到外部的输入在所有元素执行完后结束
+		if nodeOutput != nil && nodeOutput.ForEach != nil && nodeResult.Status == "success" {
+			body := forEachBody(nodeID, nodeMap, connections)
+			input.Items = e.executeForEach(nodeOutput.ForEach, body, nodeMap, connections, wfCtx, result)
+			for bodyID := range body {
+				executed[bodyID] = true
+				for _, targets := range connections[bodyID].Main {
+					for _, target := range targets {
+						if !body[target.Node] {
+							resolve(target.Node, true, input)
+						}
+					}
+				}
+			}
+		}
+
 		// 更新后继节点的入度
 		if nodeConns, ok := connections[nodeID]; ok {
 			for outputIndex, targets := range nodeConns.Main {
 				// 检查是否应该走这个分支
-				if !e.shouldFollowBranch(nodeID, outputIndex, branchResults) {
-					continue
-				}
+				active := e.shouldFollowBranch(nodeID, outputIndex, branchResults)
 
 				for _, target := range targets {
-					inDegree[target.Node]--
-					if inDegree[target.Node] == 0 {
-						queue = append(queue, target.Node)
+					// 循环体已经由 foreach 执行过
+					if executed[target.Node] {
+						continue
 					}
+					resolve(target.Node, active, input)
 				}
 			}
 		}
@@ -212,9 +282,182 @@ func (e *WorkflowEngine) executeDAG(nodeMap map[string]*models.WorkflowNode, con
 	return result
 }
 
-// executeNode 执行单个节点
+// shouldRunNode 判断节点的输入是否满足执行条件
+func (e *WorkflowEngine) shouldRunNode(node *models.WorkflowNode, inDegree, activeIn int) bool {
+	if inDegree == 0 {
+		// 起始节点
+		return true
+	}
+
+	if node.Type == models.NodeTypeMerge {
+		return activeIn > 0
+	}
+
+	return activeIn == inDegree
+}
+
+// forEachBody 返回 foreach 节点输出 0 可达的节点（循环体），遇到 merge 节点为止
+func forEachBody(nodeID string, nodeMap map[string]*models.WorkflowNode, connections models.Connections) map[string]bool {
+	body := make(map[string]bool)
+	nodeConns, ok := connections[nodeID]
+	if !ok || len(nodeConns.Main) == 0 {
+		return body
+	}
+
+	queue := make([]string, 0)
+	for _, target := range nodeConns.Main[0] {
+		queue = append(queue, target.Node)
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		node, exists := nodeMap[id]
+		if !exists || id == nodeID || body[id] || node.Type == models.NodeTypeMerge {
+			continue
+		}
+		body[id] = true
+
+		for _, targets := range connections[id].Main {
+			for _, target := range targets {
+				queue = append(queue, target.Node)
+			}
+		}
+	}
+
+	return body
+}
+
+// executeForEach 对每个元素在独立的上下文中执行一遍循环体，返回各元素的执行结果
+// 循环体中各节点的执行结果以 "节点名[下标]" 记录到 result 中
+func (e *WorkflowEngine) executeForEach(spec *models.ForEachSpec, body map[string]bool, nodeMap map[string]*models.WorkflowNode, connections models.Connections, wfCtx *models.WorkflowContext, result *models.WorkflowResult) []*models.ForEachItemResult {
+	bodyNodes := make(map[string]*models.WorkflowNode, len(body))
+	bodyConns := make(models.Connections, len(body))
+	for id := range body {
+		bodyNodes[id] = nodeMap[id]
+
+		nodeConns, ok := connections[id]
+		if !ok {
+			continue
+		}
+		main := make([][]models.ConnectionTarget, len(nodeConns.Main))
+		for i, targets := range nodeConns.Main {
+			for _, target := range targets {
+				if body[target.Node] {
+					main[i] = append(main[i], target)
+				}
+			}
+		}
+		bodyConns[id] = models.NodeConnections{Main: main}
+	}
+
+	concurrency := spec.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	items := make([]*models.ForEachItemResult, len(spec.Items))
+	itemNodeResults := make([][]*models.NodeExecutionResult, len(spec.Items))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range spec.Items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			itemResult := &models.ForEachItemResult{Index: i, Item: item}
+			items[i] = itemResult
+
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Errorf("workflow: foreach item %d panic: %v", i, r)
+					itemResult.Status = models.ExecutionStatusFailed
+					itemResult.Error = fmt.Sprintf("panic: %v", r)
+				}
+			}()
+
+			itemCtx := cloneWorkflowContext(wfCtx)
+			itemCtx.Vars[spec.ItemVar] = item
+			itemCtx.Vars[spec.ItemVar+"_index"] = i
+
+			res := e.executeDAG(bodyNodes, bodyConns, itemCtx)
+			itemNodeResults[i] = res.NodeResults
+
+			itemResult.Status = res.Status
+			if res.Status == models.ExecutionStatusFailed {
+				itemResult.Error = res.Message
+			}
+			if itemCtx.Event == nil {
+				itemResult.Status = "terminated"
+			} else {
+				itemResult.Annotations = itemCtx.Event.AnnotationsJSON
+			}
+
+			// 只保留循环体中新增的变量
+			itemResult.Vars = make(map[string]interface{})
+			for k, v := range itemCtx.Vars {
+				if _, inherited := wfCtx.Vars[k]; !inherited {
+					itemResult.Vars[k] = v
+				}
+			}
+		}(i, item)
+	}
+	wg.Wait()
+
+	for i, nodeResults := range itemNodeResults {
+		for _, nodeResult := range nodeResults {
+			nodeResult.NodeName = fmt.Sprintf("%s[%d]", nodeResult.NodeName, i)
+			result.NodeResults = append(result.NodeResults, nodeResult)
+		}
+	}
+
+	if wfCtx.Vars == nil {
+		wfCtx.Vars = make(map[string]interface{})
+	}
+	wfCtx.Vars[spec.OutputVar] = items
+
+	return items
+}
+
+// cloneWorkflowContext 复制一份互不影响的上下文，事件深拷贝，Vars 等 map 浅拷贝
+func cloneWorkflowContext(wfCtx *models.WorkflowContext) *models.WorkflowContext {
+	clone := *wfCtx
+	if wfCtx.Event != nil {
+		clone.Event = wfCtx.Event.DeepCopy()
+	}
+
+	clone.Inputs = make(map[string]string, len(wfCtx.Inputs))
+	for k, v := range wfCtx.Inputs {
+		clone.Inputs[k] = v
+	}
+
+	clone.Vars = make(map[string]interface{}, len(wfCtx.Vars)+2)
+	for k, v := range wfCtx.Vars {
+		clone.Vars[k] = v
+	}
+
+	if wfCtx.Output != nil {
+		clone.Output = make(map[string]interface{}, len(wfCtx.Output))
+		for k, v := range wfCtx.Output {
+			clone.Output[k] = v
+		}
+	}
+
+	clone.Metadata = make(map[string]string, len(wfCtx.Metadata))
+	for k, v := range wfCtx.Metadata {
+		clone.Metadata[k] = v
+	}
+
+	return &clone
+}
+
+// executeNode 执行单个节点，inputs 为 merge 节点收集到的上游输入
 // 返回：节点执行结果、节点输出（用于流式输出检测）
-func (e *WorkflowEngine) executeNode(node *models.WorkflowNode, wfCtx *models.WorkflowContext) (*models.NodeExecutionResult, *models.NodeOutput) {
+func (e *WorkflowEngine) executeNode(node *models.WorkflowNode, wfCtx *models.WorkflowContext, inputs []*models.MergeInput) (*models.NodeExecutionResult, *models.NodeOutput) {
 	startTime := time.Now()
 	nodeResult := &models.NodeExecutionResult{
 		NodeID:    node.ID,
@@ -260,9 +503,25 @@ func (e *WorkflowEngine) executeNode(node *models.WorkflowNode, wfCtx *models.Wo
 	}
 
 	for retries <= maxRetries {
-		// 检查是否为分支处理器
-		if branchProcessor, ok := processor.(models.BranchProcessor); ok {
-			output, err := branchProcessor.ProcessWithBranch(e.ctx, wfCtx)
+		// 配置了超时的节点在上下文副本上执行，超时后放弃等待，避免与仍在运行的处理器并发修改事件
+		runCtx := wfCtx
+		if node.Timeout > 0 {
+			runCtx = cloneWorkflowContext(wfCtx)
+		}
+
+		// 检查是否为分支处理器或合并处理器
+		mergeProcessor, isMerge := processor.(models.MergeProcessor)
+		branchProcessor, isBranch := processor.(models.BranchProcessor)
+		if isMerge || isBranch {
+			var output *models.NodeOutput
+			err := callWithTimeout(runCtx, node.Timeout, func() (err error) {
+				if isMerge {
+					output, err = mergeProcessor.ProcessMerge(e.ctx, runCtx, inputs)
+				} else {
+					output, err = branchProcessor.ProcessWithBranch(e.ctx, runCtx)
+				}
+				return err
+			})
 			if err != nil {
 				if retries < maxRetries {
 					retries++
@@ -276,7 +535,7 @@ func (e *WorkflowEngine) executeNode(node *models.WorkflowNode, wfCtx *models.Wo
 				if output != nil {
 					nodeOutput = output
 					if output.WfCtx != nil {
-						wfCtx = output.WfCtx
+						wfCtx = adoptWorkflowContext(wfCtx, output.WfCtx, runCtx != wfCtx)
 					}
 					nodeResult.Message = output.Message
 					nodeResult.BranchIndex = output.BranchIndex
@@ -289,7 +548,12 @@ func (e *WorkflowEngine) executeNode(node *models.WorkflowNode, wfCtx *models.Wo
 		}
 
 		// 普通处理器
-		newWfCtx, msg, err := processor.Process(e.ctx, wfCtx)
+		var newWfCtx *models.WorkflowContext
+		var msg string
+		err := callWithTimeout(runCtx, node.Timeout, func() (err error) {
+			newWfCtx, msg, err = processor.Process(e.ctx, runCtx)
+			return err
+		})
 		if err != nil {
 			if retries < maxRetries {
 				retries++
@@ -302,7 +566,7 @@ func (e *WorkflowEngine) executeNode(node *models.WorkflowNode, wfCtx *models.Wo
 			nodeResult.Status = "success"
 			nodeResult.Message = msg
 			if newWfCtx != nil {
-				wfCtx = newWfCtx
+				wfCtx = adoptWorkflowContext(wfCtx, newWfCtx, runCtx != wfCtx)
 
 				// 检测流式输出标记
 				if newWfCtx.Stream && newWfCtx.StreamChan != nil {
@@ -365,6 +629,52 @@ func (e *WorkflowEngine) executeNode(node *models.WorkflowNode, wfCtx *models.Wo
 	return nodeResult, nodeOutput
 }
 
+// callWithTimeout 执行处理器，timeout（秒）大于 0 时给 runCtx 挂上带超时的 ParentCtx，
+// 超时后取消它并直接返回错误，处理器通过 wfCtx.Context() 感知取消，停止查询、请求或脚本
+func callWithTimeout(runCtx *models.WorkflowContext, timeout int, fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	c, cancel := context.WithTimeout(runCtx.Context(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	runCtx.ParentCtx = c
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("processor panic: %v", r)
+			}
+		}()
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-c.Done():
+		if c.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("node execution timeout after %ds", timeout)
+		}
+		return c.Err()
+	}
+}
+
+// adoptWorkflowContext 采用处理器返回的上下文
+// 处理器在副本上执行时，把结果写回原上下文，保证调用方持有的指针能看到改动
+func adoptWorkflowContext(wfCtx, newWfCtx *models.WorkflowContext, cloned bool) *models.WorkflowContext {
+	if !cloned {
+		return newWfCtx
+	}
+
+	// 副本上的 ParentCtx 是本节点带超时的 context，返回后就被取消了，后续节点继续用原来的
+	parent := wfCtx.ParentCtx
+	*wfCtx = *newWfCtx
+	wfCtx.ParentCtx = parent
+	return wfCtx
+}
+
 // shouldFollowBranch 判断是否应该走某个分支
 func (e *WorkflowEngine) shouldFollowBranch(nodeID string, outputIndex int, branchResults map[string]*int) bool {
 	branchIndex, hasBranch := branchResults[nodeID]
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/ccfos/nightingale/v6/alert/pipeline/processor/logic"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/stretchr/testify/assert"
+)
+
+// annotateProcessor 测试用处理器：写入注解，可选睡眠
+type annotateProcessor struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Sleep int    `json:"sleep"` // 毫秒
+}
+
+var running, maxRunning, cancelled int64
+
+func init() {
+	models.RegisterProcessor("test.annotate", &annotateProcessor{})
+}
+
+func (p *annotateProcessor) Init(settings interface{}) (models.Processor, error) {
+	m := settings.(map[string]interface{})
+	result := &annotateProcessor{Key: fmt.Sprint(m["key"]), Value: fmt.Sprint(m["value"])}
+	if sleep, ok := m["sleep"].(int); ok {
+		result.Sleep = sleep
+	}
+	return result, nil
+}
+
+func (p *annotateProcessor) Process(c *ctx.Context, wfCtx *models.WorkflowContext) (*models.WorkflowContext, string, error) {
+	cur := atomic.AddInt64(&running, 1)
+	defer atomic.AddInt64(&running, -1)
+	for {
+		old := atomic.LoadInt64(&maxRunning)
+		if cur <= old || atomic.CompareAndSwapInt64(&maxRunning, old, cur) {
+			break
+		}
+	}
+
+	select {
+	case <-time.After(time.Duration(p.Sleep) * time.Millisecond):
+	case <-wfCtx.Context().Done():
+		atomic.AddInt64(&cancelled, 1)
+		return wfCtx, "", wfCtx.Context().Err()
+	}
+
+	value := p.Value
+	if item, ok := wfCtx.Vars["item"]; ok {
+		value = fmt.Sprintf("%s-%v", value, item)
+	}
+	wfCtx.Event.AnnotationsJSON[p.Key] = value
+	return wfCtx, "", nil
+}
+
+func newTestPipeline(nodes []models.WorkflowNode, connections models.Connections) *models.EventPipeline {
+	return &models.EventPipeline{Nodes: nodes, Connections: connections}
+}
+
+func newTestEvent() *models.AlertCurEvent {
+	return &models.AlertCurEvent{
+		Severity:        2,
+		TagsMap:         map[string]string{},
+		AnnotationsJSON: map[string]string{},
+	}
+}
+
+func target(node string) []models.ConnectionTarget {
+	return []models.ConnectionTarget{{Node: node, Type: "main"}}
+}
+
+func TestMergeAfterIf(t *testing.T) {
+	nodes := []models.WorkflowNode{
+		{ID: "if", Name: "if", Type: "logic.if", Config: map[string]interface{}{"condition": "{{ if eq $event.Severity 1 }}true{{ end }}"}},
+		{ID: "a", Name: "a", Type: "test.annotate", Config: map[string]interface{}{"key": "branch", "value": "true"}},
+		{ID: "b", Name: "b", Type: "test.annotate", Config: map[string]interface{}{"key": "branch", "value": "false"}},
+		{ID: "merge", Name: "merge", Type: models.NodeTypeMerge, Config: map[string]interface{}{}},
+		{ID: "after", Name: "after", Type: "test.annotate", Config: map[string]interface{}{"key": "after", "value": "done"}},
+	}
+	connections := models.Connections{
+		"if":    {Main: [][]models.ConnectionTarget{target("a"), target("b")}},
+		"a":     {Main: [][]models.ConnectionTarget{target("merge")}},
+		"b":     {Main: [][]models.ConnectionTarget{target("merge")}},
+		"merge": {Main: [][]models.ConnectionTarget{target("after")}},
+	}
+
+	event, result, err := NewWorkflowEngine(nil).Execute(newTestPipeline(nodes, connections), newTestEvent(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ExecutionStatusSuccess, result.Status)
+	assert.Equal(t, "false", event.AnnotationsJSON["branch"])
+	assert.Equal(t, "done", event.AnnotationsJSON["after"])
+
+	var merged bool
+	for _, nodeResult := range result.NodeResults {
+		assert.NotEqual(t, "a", nodeResult.NodeID)
+		if nodeResult.NodeID == "merge" {
+			merged = true
+			assert.Contains(t, nodeResult.Message, "merged 1 inputs: b")
+		}
+	}
+	assert.True(t, merged)
+}
+
+func TestForEach(t *testing.T) {
+	atomic.StoreInt64(&maxRunning, 0)
+
+	nodes := []models.WorkflowNode{
+		{ID: "foreach", Name: "foreach", Type: models.NodeTypeForEach, Config: map[string]interface{}{"items": "a,b,c,d", "concurrency": 2}},
+		{ID: "body", Name: "body", Type: "test.annotate", Config: map[string]interface{}{"key": "dir", "value": "v", "sleep": 50}},
+		{ID: "merge", Name: "merge", Type: models.NodeTypeMerge, Config: map[string]interface{}{"merge_annotations": true}},
+		{ID: "done", Name: "done", Type: "test.annotate", Config: map[string]interface{}{"key": "done", "value": "yes"}},
+	}
+	connections := models.Connections{
+		"foreach": {Main: [][]models.ConnectionTarget{target("body"), target("done")}},
+		"body":    {Main: [][]models.ConnectionTarget{target("merge")}},
+	}
+
+	event, result, err := NewWorkflowEngine(nil).Execute(newTestPipeline(nodes, connections), newTestEvent(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ExecutionStatusSuccess, result.Status)
+	assert.Equal(t, "v-a\nv-b\nv-c\nv-d", event.AnnotationsJSON["dir"])
+	assert.Equal(t, "yes", event.AnnotationsJSON["done"])
+	assert.Equal(t, int64(2), atomic.LoadInt64(&maxRunning))
+
+	names := make([]string, 0)
+	for _, nodeResult := range result.NodeResults {
+		names = append(names, nodeResult.NodeName)
+	}
+	assert.Equal(t, []string{"foreach", "body[0]", "body[1]", "body[2]", "body[3]", "merge", "done"}, names)
+}
+
+func TestNodeTimeout(t *testing.T) {
+	atomic.StoreInt64(&cancelled, 0)
+
+	nodes := []models.WorkflowNode{
+		{ID: "slow", Name: "slow", Type: "test.annotate", Timeout: 1, ContinueOnFail: true, Config: map[string]interface{}{"key": "slow", "value": "v", "sleep": 1500}},
+		{ID: "next", Name: "next", Type: "test.annotate", Config: map[string]interface{}{"key": "next", "value": "v"}},
+	}
+	connections := models.Connections{
+		"slow": {Main: [][]models.ConnectionTarget{target("next")}},
+	}
+
+	event, result, err := NewWorkflowEngine(nil).Execute(newTestPipeline(nodes, connections), newTestEvent(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "failed", result.NodeResults[0].Status)
+	assert.Contains(t, result.NodeResults[0].Error, "timeout")
+	assert.Equal(t, "v", event.AnnotationsJSON["next"])
+
+	// 超时的处理器在副本上执行，不影响原事件
+	time.Sleep(700 * time.Millisecond)
+	_, has := event.AnnotationsJSON["slow"]
+	assert.False(t, has)
+
+	// 超时后处理器收到取消，不会在后台一直运行
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cancelled))
+}
+
+func TestForEachTimeoutCancelsItems(t *testing.T) {
+	atomic.StoreInt64(&cancelled, 0)
+
+	nodes := []models.WorkflowNode{
+		{ID: "foreach", Name: "foreach", Type: models.NodeTypeForEach, Config: map[string]interface{}{"items": "a,b,c", "concurrency": 3}},
+		{ID: "body", Name: "body", Type: "test.annotate", Timeout: 1, ContinueOnFail: true, Config: map[string]interface{}{"key": "dir", "value": "v", "sleep": 60000}},
+	}
+	connections := models.Connections{
+		"foreach": {Main: [][]models.ConnectionTarget{target("body")}},
+	}
+
+	start := time.Now()
+	_, _, err := NewWorkflowEngine(nil).Execute(newTestPipeline(nodes, connections), newTestEvent(), nil)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&cancelled) == 3 && atomic.LoadInt64(&running) == 0
+	}, time.Second, 10*time.Millisecond)
+}
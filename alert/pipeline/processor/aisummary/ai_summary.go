@@ -81,14 +81,14 @@ func (c *AISummaryConfig) Process(ctx *ctx.Context, wfCtx *models.WorkflowContex
 	// - 否则：回退到内联的 model/api_key/url 手写 HTTP 调用（向后兼容）
 	var summary string
 	if c.LLMConfigId > 0 {
-		summary, err = c.generateWithLLMConfig(ctx, eventInfo)
+		summary, err = c.generateWithLLMConfig(wfCtx.Context(), ctx, eventInfo)
 	} else {
 		if c.Client == nil {
 			if err := c.initHTTPClient(); err != nil {
 				return wfCtx, "", fmt.Errorf("failed to initialize HTTP client: %v processor: %v", err, c)
 			}
 		}
-		summary, err = c.generateAISummary(eventInfo)
+		summary, err = c.generateAISummary(wfCtx.Context(), eventInfo)
 	}
 	if err != nil {
 		return wfCtx, "", fmt.Errorf("failed to generate AI summary: %v processor: %v", err, c)
@@ -153,7 +153,7 @@ func (c *AISummaryConfig) prepareEventInfo(wfCtx *models.WorkflowContext) (strin
 
 // generateWithLLMConfig 复用集中式 LLM 配置（ai_llm_config）生成总结，
 // 走统一的 aiagent/llm 客户端，从而支持 openai/claude/gemini 等各类 provider。
-func (c *AISummaryConfig) generateWithLLMConfig(dbCtx *ctx.Context, eventInfo string) (string, error) {
+func (c *AISummaryConfig) generateWithLLMConfig(parent context.Context, dbCtx *ctx.Context, eventInfo string) (string, error) {
 	cfg, err := models.AILLMConfigGetById(dbCtx, c.LLMConfigId)
 	if err != nil {
 		return "", fmt.Errorf("failed to load llm config %d: %v", c.LLMConfigId, err)
@@ -170,13 +170,13 @@ func (c *AISummaryConfig) generateWithLLMConfig(dbCtx *ctx.Context, eventInfo st
 		return "", fmt.Errorf("failed to create llm client: %v", err)
 	}
 
-	reqCtx, cancel := context.WithTimeout(context.Background(), llmconfig.ProbeTimeout(cfg.ExtraConfig))
+	reqCtx, cancel := context.WithTimeout(parent, llmconfig.ProbeTimeout(cfg.ExtraConfig))
 	defer cancel()
 
 	return llm.Chat(reqCtx, client, []llm.Message{{Role: llm.RoleUser, Content: eventInfo}})
 }
 
-func (c *AISummaryConfig) generateAISummary(eventInfo string) (string, error) {
+func (c *AISummaryConfig) generateAISummary(parent context.Context, eventInfo string) (string, error) {
 	// 构建基础请求参数
 	reqParams := map[string]interface{}{
 		"model": c.ModelName,
@@ -204,7 +204,7 @@ func (c *AISummaryConfig) generateAISummary(eventInfo string) (string, error) {
 	}
 
 	// 创建HTTP请求
-	req, err := http.NewRequest("POST", c.URL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(parent, "POST", c.URL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
@@ -0,0 +1,474 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/common"
+	"github.com/ccfos/nightingale/v6/dscache"
+	dskittypes "github.com/ccfos/nightingale/v6/dskit/types"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/tplx"
+
+	"github.com/prometheus/common/model"
+)
+
+// 查询方式
+const (
+	QueryModeLog = "log" // QueryLog，返回原始日志/行
+	QueryModeTS  = "ts"  // QueryData，返回时序数据
+	QueryModeMap = "map" // QueryMapData，与告警事件生成时获取额外数据的方式一致
+)
+
+// 结果输出位置
+const (
+	OutputAnnotation = "annotation" // 渲染成文本写入 annotations[key]
+	OutputExtra      = "extra"      // 结构化写入 event.EnrichData[key]
+)
+
+const (
+	defaultRange   = 300 // 单位:秒
+	defaultLimit   = 20
+	defaultTimeout = 10 // 单位:秒
+
+	// 每行结果里时序值对应的字段名
+	ValueField = "__value__"
+)
+
+// QueryConfig 数据源查询富化处理器配置
+// 以事件的标签和属性作为模板变量渲染查询语句，到任意数据源执行查询，并把结果附加到事件上
+type QueryConfig struct {
+	// DatasourceCate 数据源类型，如 prometheus、elasticsearch、loki、mysql，为空时使用事件的 cate
+	DatasourceCate string `json:"datasource_cate"`
+	// DatasourceId 数据源 ID，为 0 时使用事件的数据源
+	DatasourceId int64 `json:"datasource_id"`
+	// Mode 查询方式：log | ts | map，prometheus 固定为在 now 时刻求值的即时查询，
+	// 需要时间窗口时在 promql 里写，如 max_over_time(x[5m])
+	Mode string `json:"mode"`
+	// Query 查询语句，可以是字符串（promql、logql）或对象（与告警规则中 queries 的格式相同）
+	// 其中所有字符串都支持 Go 模板，可用变量：$event $labels $value $inputs $start $end
+	Query interface{} `json:"query"`
+
+	Range   int64 `json:"range"`   // 查询时间范围，单位:秒，查询区间为 [now-range, now]，prometheus 只用于 $start
+	Limit   int   `json:"limit"`   // 最多保留的结果行数
+	Timeout int64 `json:"timeout"` // 查询超时，单位:秒
+
+	// Output 结果输出位置：annotation | extra
+	Output string `json:"output"`
+	// Key 结果写入的 annotation key 或 EnrichData key
+	Key string `json:"key"`
+	// Format 写入 annotation 时的渲染模板，可用变量：$rows（[]map[string]string） $event $labels
+	// 为空时每行渲染为一行 JSON
+	Format string `json:"format"`
+	// IgnoreError 查询失败时不报错，只在 annotation/extra 中记录错误信息
+	IgnoreError bool `json:"ignore_error"`
+}
+
+// PromQueryFunc 对 prometheus 数据源执行在 ts 时刻求值的即时查询
+type PromQueryFunc func(ctx context.Context, dsId int64, promql string, ts int64) (model.Value, error)
+
+var promQuery PromQueryFunc
+
+// RegisterPromQueryFunc prometheus 数据源不在 dscache 中，为了避免循环引用，由外部注入查询方法
+func RegisterPromQueryFunc(f PromQueryFunc) {
+	promQuery = f
+}
+
+func init() {
+	models.RegisterProcessor("enrich.query", &QueryConfig{})
+}
+
+func (c *QueryConfig) Init(settings interface{}) (models.Processor, error) {
+	result, err := common.InitProcessor[*QueryConfig](settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Key == "" {
+		return nil, fmt.Errorf("enrich.query processor: key is required")
+	}
+
+	if result.Query == nil {
+		return nil, fmt.Errorf("enrich.query processor: query is required")
+	}
+
+	switch result.Mode {
+	case "", QueryModeLog, QueryModeTS, QueryModeMap:
+	default:
+		return nil, fmt.Errorf("enrich.query processor: unsupported mode %q", result.Mode)
+	}
+
+	switch result.Output {
+	case "":
+		result.Output = OutputAnnotation
+	case OutputAnnotation, OutputExtra:
+	default:
+		return nil, fmt.Errorf("enrich.query processor: unsupported output %q", result.Output)
+	}
+
+	if result.Range <= 0 {
+		result.Range = defaultRange
+	}
+
+	if result.Limit <= 0 {
+		result.Limit = defaultLimit
+	}
+
+	if result.Timeout <= 0 {
+		result.Timeout = defaultTimeout
+	}
+
+	return result, nil
+}
+
+func (c *QueryConfig) Process(ctx *ctx.Context, wfCtx *models.WorkflowContext) (*models.WorkflowContext, string, error) {
+	event := wfCtx.Event
+	if event == nil {
+		return wfCtx, "", nil
+	}
+
+	cate := c.DatasourceCate
+	if cate == "" {
+		cate = event.Cate
+	}
+
+	dsId := c.DatasourceId
+	if dsId == 0 {
+		dsId = event.DatasourceId
+	}
+
+	end := time.Now().Unix()
+	start := end - c.Range
+
+	rows, err := c.query(wfCtx, cate, dsId, start, end)
+	if err != nil {
+		if !c.IgnoreError {
+			return wfCtx, "", fmt.Errorf("enrich.query processor: %v processor: %v", err, c)
+		}
+		rows = []map[string]string{{"error": err.Error()}}
+	}
+
+	if len(rows) > c.Limit {
+		rows = rows[:c.Limit]
+	}
+
+	switch c.Output {
+	case OutputExtra:
+		if event.EnrichData == nil {
+			event.EnrichData = make(map[string][]map[string]string)
+		}
+		event.EnrichData[c.Key] = rows
+	default:
+		text, err := c.format(wfCtx, rows)
+		if err != nil {
+			return wfCtx, "", fmt.Errorf("enrich.query processor: %v processor: %v", err, c)
+		}
+
+		if event.AnnotationsJSON == nil {
+			event.AnnotationsJSON = make(map[string]string)
+		}
+		event.AnnotationsJSON[c.Key] = text
+
+		b, err := json.Marshal(event.AnnotationsJSON)
+		if err != nil {
+			return wfCtx, "", fmt.Errorf("failed to marshal annotations: %v processor: %v", err, c)
+		}
+		event.Annotations = string(b)
+	}
+
+	return wfCtx, fmt.Sprintf("enrich %s with %d rows from %s datasource %d", c.Key, len(rows), cate, dsId), nil
+}
+
+// query 渲染查询语句并到数据源执行
+func (c *QueryConfig) query(wfCtx *models.WorkflowContext, cate string, dsId int64, start, end int64) ([]map[string]string, error) {
+	query, err := renderQuery(wfCtx, c.Query, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(wfCtx.Context(), time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	if cate == models.PROMETHEUS {
+		promql, ok := query.(string)
+		if !ok {
+			if m, isMap := query.(map[string]interface{}); isMap {
+				promql, ok = m["prom_ql"].(string)
+			}
+		}
+		if !ok || promql == "" {
+			return nil, fmt.Errorf("prometheus query must be a promql string")
+		}
+
+		if promQuery == nil {
+			return nil, fmt.Errorf("prometheus query is not available")
+		}
+
+		// 即时查询只返回 end 时刻的结果，topk 等只包含当前排名内的序列
+		value, err := promQuery(timeoutCtx, dsId, promql, end)
+		if err != nil {
+			return nil, err
+		}
+		return valueToRows(value), nil
+	}
+
+	plug, exists := dscache.DsCache.Get(cate, dsId)
+	if !exists {
+		return nil, fmt.Errorf("datasource %s %d not exists", cate, dsId)
+	}
+
+	qctx := dskittypes.WithCallContext(timeoutCtx, dskittypes.CallContext{
+		DatasourceID:    dsId,
+		Operator:        "event_pipeline",
+		EnforceReadOnly: true,
+	})
+
+	tags := wfCtx.Event.TagsJSON
+
+	switch c.Mode {
+	case QueryModeTS:
+		// 部分数据源（如 SQL 类）没有实现 MakeTSQuery，此时直接使用配置的查询
+		if q, err := plug.MakeTSQuery(qctx, query, tags, start, end); err != nil {
+			return nil, err
+		} else if q != nil {
+			query = q
+		}
+
+		series, err := plug.QueryData(qctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return seriesToRows(series), nil
+	case QueryModeMap:
+		return plug.QueryMapData(qctx, query)
+	default:
+		if q, err := plug.MakeLogQuery(qctx, query, tags, start, end); err != nil {
+			return nil, err
+		} else if q != nil {
+			query = q
+		}
+
+		logs, _, err := plug.QueryLog(qctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return logsToRows(logs), nil
+	}
+}
+
+// format 把结果渲染成 annotation 文本
+func (c *QueryConfig) format(wfCtx *models.WorkflowContext, rows []map[string]string) (string, error) {
+	if c.Format == "" {
+		lines := make([]string, 0, len(rows))
+		for _, row := range rows {
+			b, err := json.Marshal(row)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, string(b))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	var defs = []string{
+		"{{ $event := .Event }}",
+		"{{ $labels := .Event.TagsMap }}",
+		"{{ $inputs := .Inputs }}",
+		"{{ $rows := .Rows }}",
+	}
+
+	tpl, err := template.New("enrich_format").Funcs(tplx.TemplateFuncMap).Parse(strings.Join(append(defs, c.Format), ""))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse format template: %v", err)
+	}
+
+	data := struct {
+		*models.WorkflowContext
+		Rows []map[string]string
+	}{wfCtx, rows}
+
+	var body bytes.Buffer
+	if err = tpl.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("failed to execute format template: %v", err)
+	}
+
+	return strings.TrimSpace(body.String()), nil
+}
+
+// renderQuery 递归渲染查询中的所有字符串
+func renderQuery(wfCtx *models.WorkflowContext, query interface{}, start, end int64) (interface{}, error) {
+	switch q := query.(type) {
+	case string:
+		return renderString(wfCtx, q, start, end)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(q))
+		for k, v := range q {
+			rv, err := renderQuery(wfCtx, v, start, end)
+			if err != nil {
+				return nil, err
+			}
+			rendered[k] = rv
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(q))
+		for i, v := range q {
+			rv, err := renderQuery(wfCtx, v, start, end)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = rv
+		}
+		return rendered, nil
+	default:
+		return query, nil
+	}
+}
+
+func renderString(wfCtx *models.WorkflowContext, content string, start, end int64) (string, error) {
+	if !strings.Contains(content, "{{") {
+		return content, nil
+	}
+
+	var defs = []string{
+		"{{ $event := .Event }}",
+		"{{ $labels := .Event.TagsMap }}",
+		"{{ $value := .Event.TriggerValue }}",
+		"{{ $inputs := .Inputs }}",
+		"{{ $start := .Start }}",
+		"{{ $end := .End }}",
+	}
+
+	tpl, err := template.New("enrich_query").Funcs(tplx.TemplateFuncMap).Parse(strings.Join(append(defs, content), ""))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse query template: %v", err)
+	}
+
+	data := struct {
+		*models.WorkflowContext
+		Start int64
+		End   int64
+	}{wfCtx, start, end}
+
+	var body bytes.Buffer
+	if err = tpl.Execute(&body, data); err != nil {
+		return "", fmt.Errorf("failed to execute query template: %v", err)
+	}
+
+	return body.String(), nil
+}
+
+// valueToRows 把 promql 的查询结果转换成行，每个序列一行，标签作为字段，值写入 __value__
+func valueToRows(value model.Value) []map[string]string {
+	rows := make([]map[string]string, 0)
+	if value == nil {
+		return rows
+	}
+
+	switch v := value.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			if math.IsNaN(float64(sample.Value)) {
+				continue
+			}
+			row := metricToRow(sample.Metric)
+			row[ValueField] = sample.Value.String()
+			rows = append(rows, row)
+		}
+	case model.Matrix:
+		for _, stream := range v {
+			if len(stream.Values) == 0 {
+				continue
+			}
+			row := metricToRow(stream.Metric)
+			row[ValueField] = stream.Values[len(stream.Values)-1].Value.String()
+			rows = append(rows, row)
+		}
+	case *model.Scalar:
+		rows = append(rows, map[string]string{ValueField: v.Value.String()})
+	case *model.String:
+		rows = append(rows, map[string]string{ValueField: v.Value})
+	}
+
+	return rows
+}
+
+func metricToRow(metric model.Metric) map[string]string {
+	row := make(map[string]string, len(metric)+1)
+	for k, v := range metric {
+		row[string(k)] = string(v)
+	}
+	return row
+}
+
+// seriesToRows 把时序数据转换成行，每个序列取最后一个点
+func seriesToRows(series []models.DataResp) []map[string]string {
+	rows := make([]map[string]string, 0, len(series))
+	for i := range series {
+		row := metricToRow(series[i].Metric)
+		if _, v, ok := series[i].Last(); ok {
+			row[ValueField] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// logsToRows 把日志转换成行，嵌套的字段序列化为 JSON
+func logsToRows(logs []interface{}) []map[string]string {
+	rows := make([]map[string]string, 0, len(logs))
+	for _, item := range logs {
+		row := make(map[string]string)
+		switch l := item.(type) {
+		case map[string]interface{}:
+			for k, v := range l {
+				row[k] = stringify(v)
+			}
+		case map[string]string:
+			for k, v := range l {
+				row[k] = v
+			}
+		default:
+			// 各数据源返回的日志结构不同，统一转成 map 处理
+			b, err := json.Marshal(item)
+			if err != nil {
+				continue
+			}
+			var m map[string]interface{}
+			if err := json.Unmarshal(b, &m); err != nil {
+				row["message"] = string(b)
+			} else {
+				for k, v := range m {
+					row[k] = stringify(v)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func stringify(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case nil:
+		return ""
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(b)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
@@ -0,0 +1,120 @@
+package enrich
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWfCtx() *models.WorkflowContext {
+	return &models.WorkflowContext{
+		Event: &models.AlertCurEvent{
+			Cate:            models.PROMETHEUS,
+			DatasourceId:    1,
+			TagsJSON:        []string{"ident=host-1"},
+			TagsMap:         map[string]string{"ident": "host-1"},
+			AnnotationsJSON: map[string]string{},
+		},
+		Inputs: map[string]string{},
+	}
+}
+
+func TestRenderQuery(t *testing.T) {
+	wfCtx := newTestWfCtx()
+	query := map[string]interface{}{
+		"query": `{ident="{{ $labels.ident }}"} |= "error"`,
+		"range": "{{ $start }}-{{ $end }}",
+		"limit": float64(20),
+		"keys":  []interface{}{"{{ $labels.ident }}"},
+	}
+
+	rendered, err := renderQuery(wfCtx, query, 100, 400)
+	assert.NoError(t, err)
+	m := rendered.(map[string]interface{})
+	assert.Equal(t, `{ident="host-1"} |= "error"`, m["query"])
+	assert.Equal(t, "100-400", m["range"])
+	assert.Equal(t, float64(20), m["limit"])
+	assert.Equal(t, []interface{}{"host-1"}, m["keys"])
+}
+
+func TestProcessPrometheus(t *testing.T) {
+	var gotPromql string
+	var gotTs int64
+	var gotTimeout time.Duration
+	RegisterPromQueryFunc(func(ctx context.Context, dsId int64, promql string, ts int64) (model.Value, error) {
+		gotPromql = promql
+		gotTs = ts
+		if deadline, ok := ctx.Deadline(); ok {
+			gotTimeout = time.Until(deadline)
+		}
+		return model.Vector{
+			{Metric: model.Metric{"dir": "/var/log"}, Value: 10},
+			{Metric: model.Metric{"dir": "/data"}, Value: 5},
+		}, nil
+	})
+	defer RegisterPromQueryFunc(nil)
+
+	p, err := (&QueryConfig{}).Init(map[string]interface{}{
+		"query":   `topk(5, dir_size{ident="{{ $labels.ident }}"})`,
+		"key":     "top_dirs",
+		"format":  `{{ range $rows }}{{ .dir }}={{ .__value__ }};{{ end }}`,
+		"range":   600,
+		"timeout": 3,
+	})
+	assert.NoError(t, err)
+
+	wfCtx, _, err := p.Process(nil, newTestWfCtx())
+	assert.NoError(t, err)
+	assert.Equal(t, `topk(5, dir_size{ident="host-1"})`, gotPromql)
+	assert.InDelta(t, time.Now().Unix(), gotTs, 2)
+	assert.True(t, gotTimeout > 2*time.Second && gotTimeout <= 3*time.Second, "timeout %s", gotTimeout)
+	assert.Equal(t, "/var/log=10;/data=5;", wfCtx.Event.AnnotationsJSON["top_dirs"])
+	assert.JSONEq(t, `{"top_dirs": "/var/log=10;/data=5;"}`, wfCtx.Event.Annotations)
+
+	p, err = (&QueryConfig{}).Init(map[string]interface{}{
+		"query":  "dir_size",
+		"key":    "top_dirs",
+		"output": OutputExtra,
+		"limit":  1,
+	})
+	assert.NoError(t, err)
+
+	wfCtx, _, err = p.Process(nil, newTestWfCtx())
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"dir": "/var/log", ValueField: "10"}}, wfCtx.Event.EnrichData["top_dirs"])
+}
+
+func TestProcessMissingDatasource(t *testing.T) {
+	p, err := (&QueryConfig{}).Init(map[string]interface{}{
+		"datasource_cate": "loki",
+		"datasource_id":   99,
+		"query":           `{ident="{{ $labels.ident }}"}`,
+		"key":             "logs",
+	})
+	assert.NoError(t, err)
+
+	_, _, err = p.Process(nil, newTestWfCtx())
+	assert.Error(t, err)
+
+	p.(*QueryConfig).IgnoreError = true
+	wfCtx, _, err := p.Process(nil, newTestWfCtx())
+	assert.NoError(t, err)
+	assert.Contains(t, wfCtx.Event.AnnotationsJSON["logs"], "not exists")
+}
+
+func TestLogsToRows(t *testing.T) {
+	rows := logsToRows([]interface{}{
+		map[string]interface{}{"msg": "boom", "n": float64(3), "nested": map[string]interface{}{"a": "b"}},
+		struct {
+			Line string `json:"line"`
+		}{"hello"},
+	})
+	assert.Equal(t, []map[string]string{
+		{"msg": "boom", "n": "3", "nested": `{"a":"b"}`},
+		{"line": "hello"},
+	}, rows)
+}
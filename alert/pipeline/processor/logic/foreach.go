@@ -0,0 +1,199 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/common"
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/utils"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+)
+
+const (
+	defaultForEachItemVar     = "item"
+	defaultForEachOutputVar   = "foreach_results"
+	defaultForEachConcurrency = 1
+	maxForEachConcurrency     = 20
+	defaultForEachMaxItems    = 100
+)
+
+// ForEachConfig foreach 处理器配置
+// 输出 0 连接循环体（子分支），每个元素在独立的上下文中执行一遍循环体，循环体遇到 merge 节点结束；
+// 输出 1 在所有元素执行完后继续执行。元素来源 ItemsPath 和 Items 二选一
+type ForEachConfig struct {
+	// ItemsPath 从上下文中取列表的路径，以 . 分隔，对应 WorkflowContext 的 JSON 字段
+	// 例如：vars.rows、event.enrich_data.top_dirs、event.tags
+	ItemsPath string `json:"items_path,omitempty"`
+	// Items 模板，渲染结果为 JSON 数组，或者按换行、逗号分隔的字符串
+	Items string `json:"items,omitempty"`
+
+	// ItemVar 当前元素写入子分支 Vars 的 key，默认 item
+	ItemVar string `json:"item_var,omitempty"`
+	// Concurrency 同时执行的元素个数，默认 1，最大 20
+	Concurrency int `json:"concurrency,omitempty"`
+	// MaxItems 最多迭代的元素个数，超出的元素被忽略，默认 100
+	MaxItems int `json:"max_items,omitempty"`
+	// OutputVar 所有元素的执行结果写入 Vars 的 key，默认 foreach_results
+	OutputVar string `json:"output_var,omitempty"`
+}
+
+func init() {
+	models.RegisterProcessor(models.NodeTypeForEach, &ForEachConfig{})
+}
+
+func (c *ForEachConfig) Init(settings interface{}) (models.Processor, error) {
+	result, err := common.InitProcessor[*ForEachConfig](settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ItemsPath == "" && result.Items == "" {
+		return nil, fmt.Errorf("foreach processor: items_path or items is required")
+	}
+
+	if result.ItemVar == "" {
+		result.ItemVar = defaultForEachItemVar
+	}
+
+	if result.OutputVar == "" {
+		result.OutputVar = defaultForEachOutputVar
+	}
+
+	if result.Concurrency <= 0 {
+		result.Concurrency = defaultForEachConcurrency
+	}
+
+	if result.Concurrency > maxForEachConcurrency {
+		result.Concurrency = maxForEachConcurrency
+	}
+
+	if result.MaxItems <= 0 {
+		result.MaxItems = defaultForEachMaxItems
+	}
+
+	return result, nil
+}
+
+// Process 实现 Processor 接口（兼容旧模式），只解析元素，不执行循环体
+func (c *ForEachConfig) Process(ctx *ctx.Context, wfCtx *models.WorkflowContext) (*models.WorkflowContext, string, error) {
+	items, err := c.resolveItems(wfCtx)
+	if err != nil {
+		return wfCtx, "", fmt.Errorf("foreach processor: %v", err)
+	}
+
+	return wfCtx, fmt.Sprintf("resolved %d items", len(items)), nil
+}
+
+// ProcessWithBranch 实现 BranchProcessor 接口
+func (c *ForEachConfig) ProcessWithBranch(ctx *ctx.Context, wfCtx *models.WorkflowContext) (*models.NodeOutput, error) {
+	items, err := c.resolveItems(wfCtx)
+	if err != nil {
+		return nil, fmt.Errorf("foreach processor: %v", err)
+	}
+
+	// 循环体由引擎执行，执行完后走输出 1
+	doneIndex := 1
+	return &models.NodeOutput{
+		WfCtx:       wfCtx,
+		Message:     fmt.Sprintf("iterate over %d items", len(items)),
+		BranchIndex: &doneIndex,
+		ForEach: &models.ForEachSpec{
+			Items:       items,
+			ItemVar:     c.ItemVar,
+			Concurrency: c.Concurrency,
+			OutputVar:   c.OutputVar,
+		},
+	}, nil
+}
+
+// resolveItems 解析待迭代的元素
+func (c *ForEachConfig) resolveItems(wfCtx *models.WorkflowContext) ([]interface{}, error) {
+	var items []interface{}
+	var err error
+	if c.ItemsPath != "" {
+		items, err = itemsFromPath(wfCtx, c.ItemsPath)
+	} else {
+		items, err = itemsFromTemplate(wfCtx, c.Items)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) > c.MaxItems {
+		items = items[:c.MaxItems]
+	}
+
+	return items, nil
+}
+
+func itemsFromPath(wfCtx *models.WorkflowContext, path string) ([]interface{}, error) {
+	b, err := json.Marshal(wfCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal workflow context: %v", err)
+	}
+
+	var cur interface{}
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal workflow context: %v", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("items_path %s: %s is not an object", path, key)
+		}
+		cur = m[key]
+	}
+
+	switch v := cur.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case []interface{}:
+		return v, nil
+	case map[string]interface{}:
+		// 对象按 key 排序后以 key/value 迭代
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		items := make([]interface{}, 0, len(v))
+		for _, k := range keys {
+			items = append(items, map[string]interface{}{"key": k, "value": v[k]})
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("items_path %s is not a list", path)
+	}
+}
+
+func itemsFromTemplate(wfCtx *models.WorkflowContext, content string) ([]interface{}, error) {
+	text, err := utils.TplRender(wfCtx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]interface{}, 0)
+	if text == "" {
+		return items, nil
+	}
+
+	if strings.HasPrefix(text, "[") {
+		if err := json.Unmarshal([]byte(text), &items); err != nil {
+			return nil, fmt.Errorf("failed to parse items as json array: %v", err)
+		}
+		return items, nil
+	}
+
+	for _, item := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
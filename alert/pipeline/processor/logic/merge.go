@@ -0,0 +1,106 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/common"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+)
+
+const defaultMergeOutputVar = "merge_results"
+
+// MergeConfig merge 处理器配置
+// merge 节点等待所有上游分支结束后执行：只要有一路分支被执行就会执行 merge，
+// 未命中的分支（例如 if 的另一侧）不参与合并。foreach 的循环体也以 merge 节点作为结束
+type MergeConfig struct {
+	// OutputVar 合并结果（[]*MergeInput）写入 Vars 的 key，默认 merge_results
+	OutputVar string `json:"output_var,omitempty"`
+	// MergeAnnotations 是否把 foreach 各元素的事件注解合并到当前事件
+	// 同一个 key 在不同元素中的值不同时，按元素顺序以换行拼接
+	MergeAnnotations bool `json:"merge_annotations,omitempty"`
+}
+
+func init() {
+	models.RegisterProcessor(models.NodeTypeMerge, &MergeConfig{})
+}
+
+func (c *MergeConfig) Init(settings interface{}) (models.Processor, error) {
+	result, err := common.InitProcessor[*MergeConfig](settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.OutputVar == "" {
+		result.OutputVar = defaultMergeOutputVar
+	}
+
+	return result, nil
+}
+
+// Process 实现 Processor 接口（兼容旧模式），没有上游输入时什么都不做
+func (c *MergeConfig) Process(ctx *ctx.Context, wfCtx *models.WorkflowContext) (*models.WorkflowContext, string, error) {
+	return wfCtx, "", nil
+}
+
+// ProcessMerge 实现 MergeProcessor 接口
+func (c *MergeConfig) ProcessMerge(ctx *ctx.Context, wfCtx *models.WorkflowContext, inputs []*models.MergeInput) (*models.NodeOutput, error) {
+	if wfCtx.Vars == nil {
+		wfCtx.Vars = make(map[string]interface{})
+	}
+	wfCtx.Vars[c.OutputVar] = inputs
+
+	if c.MergeAnnotations && wfCtx.Event != nil {
+		if err := mergeAnnotations(wfCtx.Event, inputs); err != nil {
+			return nil, fmt.Errorf("merge processor: %v", err)
+		}
+	}
+
+	names := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		names = append(names, input.NodeName)
+	}
+
+	return &models.NodeOutput{
+		WfCtx:   wfCtx,
+		Message: fmt.Sprintf("merged %d inputs: %s", len(inputs), strings.Join(names, ", ")),
+	}, nil
+}
+
+func mergeAnnotations(event *models.AlertCurEvent, inputs []*models.MergeInput) error {
+	merged := make(map[string][]string)
+	for _, input := range inputs {
+		for _, item := range input.Items {
+			for k, v := range item.Annotations {
+				if old, has := event.AnnotationsJSON[k]; has && old == v {
+					continue
+				}
+				if !slices.Contains(merged[k], v) {
+					merged[k] = append(merged[k], v)
+				}
+			}
+		}
+	}
+
+	if len(merged) == 0 {
+		return nil
+	}
+
+	if event.AnnotationsJSON == nil {
+		event.AnnotationsJSON = make(map[string]string)
+	}
+
+	for k, values := range merged {
+		event.AnnotationsJSON[k] = strings.Join(values, "\n")
+	}
+
+	b, err := json.Marshal(event.AnnotationsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to marshal annotations: %v", err)
+	}
+	event.Annotations = string(b)
+	return nil
+}
@@ -0,0 +1,416 @@
+package script
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/common"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/sandbox"
+	"github.com/toolkits/pkg/logger"
+)
+
+// 脚本语言
+const (
+	LanguagePython = "python"
+	LanguageShell  = "shell"
+	LanguageJS     = "javascript"
+)
+
+// 脚本输出的错误信息最多保留的字节数，避免 stderr 过长撑爆执行记录
+const maxErrOutputBytes = 1024
+
+// sb 是脚本处理器共用的 sandbox 控制器，由 center 和 alert 启动时注入（见 SetSandbox）。
+// 未注入时（例如 edge 模式）脚本处理器直接报错，不会退化为在宿主机上裸跑脚本。
+// 只有 unsafe-exec 引擎可用时，除非配置了 AllowUnsafePipelineScript，同样拒绝执行。
+var sb atomic.Pointer[sandbox.Sandbox]
+
+// SetSandbox 注入脚本处理器使用的 sandbox 控制器
+func SetSandbox(s *sandbox.Sandbox) {
+	sb.Store(s)
+}
+
+// ScriptConfig 脚本处理器配置
+// 脚本从 stdin 读取 WorkflowContext 的 JSON（event/inputs/vars/metadata），
+// 向 stdout 输出一个 JSON 对象（见 ScriptOutput），用于修改标签、注解、丢弃事件或选择分支
+type ScriptConfig struct {
+	Language string `json:"language"` // python | shell | javascript
+	Script   string `json:"script"`
+
+	// 资源限制，为 0 时使用 [Sandbox.DefaultPolicy]，并且不会超过 [Sandbox.Skill] 的上限
+	Timeout  int    `json:"timeout,omitempty"`   // 单位:秒
+	MemoryMB int64  `json:"memory_mb,omitempty"` // 单位:MB
+	CPUQuota string `json:"cpu_quota,omitempty"` // cgroup cpu.max，例如 "50000 100000" 表示 0.5 核
+
+	// EgressAllowlist 允许脚本访问的域名，为空时脚本没有网络
+	// 仅在支持网络隔离的引擎（bubblewrap）上生效，其他引擎一律没有网络
+	EgressAllowlist []string `json:"egress_allowlist,omitempty"`
+}
+
+// ScriptOutput 脚本输出
+type ScriptOutput struct {
+	Tags              map[string]string `json:"tags,omitempty"`               // 新增或覆盖的标签
+	DeleteTags        []string          `json:"delete_tags,omitempty"`        // 需要删除的标签
+	Annotations       map[string]string `json:"annotations,omitempty"`        // 新增或覆盖的注解
+	DeleteAnnotations []string          `json:"delete_annotations,omitempty"` // 需要删除的注解
+	Vars              map[string]any    `json:"vars,omitempty"`               // 写入 WorkflowContext.Vars，供后续节点使用
+	Drop              bool              `json:"drop,omitempty"`               // 是否丢弃事件
+	Branch            *int              `json:"branch,omitempty"`             // 选择的输出分支，为空时走输出 0
+	Message           string            `json:"message,omitempty"`
+}
+
+func init() {
+	models.RegisterProcessor("script", &ScriptConfig{})
+}
+
+func (c *ScriptConfig) Init(settings interface{}) (models.Processor, error) {
+	result, err := common.InitProcessor[*ScriptConfig](settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(result.Script) == "" {
+		return nil, fmt.Errorf("script processor: script is blank")
+	}
+
+	if _, _, err := interpreter(result.Language); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Process 实现 Processor 接口
+func (c *ScriptConfig) Process(ctx *ctx.Context, wfCtx *models.WorkflowContext) (*models.WorkflowContext, string, error) {
+	output, err := c.ProcessWithBranch(ctx, wfCtx)
+	if err != nil {
+		return wfCtx, "", err
+	}
+
+	return output.WfCtx, output.Message, nil
+}
+
+// ProcessWithBranch 实现 BranchProcessor 接口
+func (c *ScriptConfig) ProcessWithBranch(ctx *ctx.Context, wfCtx *models.WorkflowContext) (*models.NodeOutput, error) {
+	out, err := c.run(wfCtx)
+	if err != nil {
+		return nil, fmt.Errorf("script processor: %w", err)
+	}
+
+	output := &models.NodeOutput{
+		WfCtx:       wfCtx,
+		Message:     out.Message,
+		BranchIndex: out.Branch,
+	}
+
+	if out.Drop {
+		if wfCtx.Event != nil {
+			logger.Infof("processor script drop event: %s", wfCtx.Event.Hash)
+		}
+		wfCtx.Event = nil
+		output.Terminate = true
+		if output.Message == "" {
+			output.Message = "drop event success"
+		}
+		return output, nil
+	}
+
+	if err := applyOutput(wfCtx, out); err != nil {
+		return nil, fmt.Errorf("script processor: %v", err)
+	}
+
+	return output, nil
+}
+
+// run 在 sandbox 中执行脚本，并解析脚本的输出
+func (c *ScriptConfig) run(wfCtx *models.WorkflowContext) (*ScriptOutput, error) {
+	s := sb.Load()
+	if s == nil || !s.Enabled() {
+		reason := "sandbox not initialized"
+		if s != nil {
+			reason = s.DisabledReason()
+		}
+		return nil, &sandbox.DisabledError{Reason: reason}
+	}
+	if s.EngineName() == sandbox.EngineUnsafe && !s.Config().AllowUnsafePipelineScript {
+		return nil, &sandbox.DisabledError{Reason: "only unsafe-exec is available, set Sandbox.AllowUnsafePipelineScript = true to run pipeline scripts without isolation"}
+	}
+
+	interp, filename, err := interpreter(c.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := json.Marshal(wfCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal workflow context: %v", err)
+	}
+
+	execID := newExecID()
+	ws, err := s.NewWorkspace(execID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %v", err)
+	}
+	defer ws.Cleanup()
+
+	hostEntry := filepath.Join(ws.Input, filename)
+	if err := os.WriteFile(hostEntry, []byte(c.Script), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write script: %v", err)
+	}
+
+	cfg := s.Config()
+	netMode := sandbox.NetworkNone
+	var controlMounts []sandbox.MountSpec
+	env := map[string]string{
+		"PATH":                    "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"LANG":                    "C.UTF-8",
+		"LC_ALL":                  "C.UTF-8",
+		"HOME":                    ws.Workspace,
+		"TMPDIR":                  ws.Workspace,
+		"PYTHONDONTWRITEBYTECODE": "1",
+		"PYTHONUNBUFFERED":        "1",
+	}
+
+	// 只有配置了 EgressAllowlist 并且引擎能强制执行网络隔离时才开放出网，
+	// 出网流量经过宿主机上的 egress proxy，私有网段、回环和元数据地址一律拒绝
+	if len(c.EgressAllowlist) > 0 && s.EngineCaps().Network {
+		dir := filepath.Join(cfg.DataDir, "run", execID)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create control dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		ep, err := sandbox.StartEgressProxy(filepath.Join(dir, "egress.sock"), sandbox.EgressOptions{
+			ExecID:         execID,
+			Allowlist:      c.EgressAllowlist,
+			DenyCIDRs:      cfg.Deny.EgressCIDRs,
+			DenyPrivate:    true,
+			AllowPlainHTTP: !cfg.EgressProxy.DenyPlainHTTP,
+			DialTimeout:    time.Duration(cfg.EgressProxy.DialTimeoutSecs) * time.Second,
+			IdleTimeout:    time.Duration(cfg.EgressProxy.IdleTimeoutSecs) * time.Second,
+			OnAudit:        egressAuditLogger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start egress proxy: %v", err)
+		}
+		defer ep.Close()
+
+		netMode = sandbox.NetworkProxy
+		if s.EngineCaps().Namespaces {
+			controlMounts = append(controlMounts, sandbox.MountSpec{Source: ep.SocketPath(), Target: sandbox.EgressSocketTarget})
+		}
+		proxyURL := "http://" + sandbox.EgressForwarderListen
+		env["HTTP_PROXY"] = proxyURL
+		env["HTTPS_PROXY"] = proxyURL
+		env["http_proxy"] = proxyURL
+		env["https_proxy"] = proxyURL
+	}
+
+	profile := "bash-minimal"
+	if interp == "python3" {
+		profile = "python-minimal"
+	}
+
+	spec := sandbox.ExecSpec{
+		ExecID:  execID,
+		Command: []string{interp, hostEntry},
+		Cwd:     ws.Workspace,
+		Env:     env,
+		Stdin:   stdin,
+		Mounts: []sandbox.MountSpec{
+			{Source: ws.Input, Target: "/input", ReadOnly: true},
+			{Source: ws.Workspace, Target: "/workspace"},
+			{Source: ws.Output, Target: "/output"},
+		},
+		ControlMounts: controlMounts,
+		Resources:     c.resources(cfg),
+		Network:       netMode,
+		Policy:        sandbox.SecurityProfile{Profile: profile, NoNewPrivs: true},
+		TriggerType:   "event_pipeline",
+		Audit:         wfCtx.Metadata,
+	}
+
+	res, err := s.Run(wfCtx.Context(), spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Timeout {
+		return nil, fmt.Errorf("script killed by timeout after %s", spec.Resources.Timeout)
+	}
+
+	if res.KilledBy != "" {
+		return nil, fmt.Errorf("script killed by %s", res.KilledBy)
+	}
+
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("script exited with code %d: %s", res.ExitCode, truncate(res.Stderr))
+	}
+
+	if res.StdoutTruncated {
+		return nil, fmt.Errorf("script output exceeds %d bytes", spec.Resources.StdoutMax)
+	}
+
+	return parseOutput(res.Stdout)
+}
+
+// resources 以 sandbox 的默认策略为基础，用处理器配置覆盖，并限制在 skill 的上限之内
+func (c *ScriptConfig) resources(cfg sandbox.Config) sandbox.ResourceSpec {
+	res := cfg.DefaultResources()
+	if c.Timeout > 0 {
+		res.Timeout = time.Duration(c.Timeout) * time.Second
+	}
+	if c.MemoryMB > 0 {
+		res.MemoryMB = c.MemoryMB
+	}
+	if c.CPUQuota != "" {
+		res.CPUQuota = c.CPUQuota
+	}
+
+	lim := cfg.Skill
+	if lim.MaxTimeoutSeconds > 0 {
+		max := time.Duration(lim.MaxTimeoutSeconds) * time.Second
+		if res.Timeout > max {
+			res.Timeout = max
+		}
+	}
+	if lim.MaxMemoryMB > 0 && res.MemoryMB > lim.MaxMemoryMB {
+		res.MemoryMB = lim.MaxMemoryMB
+	}
+	if lim.MaxPids > 0 && res.Pids > lim.MaxPids {
+		res.Pids = lim.MaxPids
+	}
+	return res
+}
+
+// parseOutput 解析脚本的标准输出，输出为空表示不做任何修改
+func parseOutput(stdout []byte) (*ScriptOutput, error) {
+	out := &ScriptOutput{}
+	stdout = bytes.TrimSpace(stdout)
+	if len(stdout) == 0 {
+		return out, nil
+	}
+
+	if err := json.Unmarshal(stdout, out); err != nil {
+		return nil, fmt.Errorf("failed to parse script output as json: %v output: %s", err, truncate(stdout))
+	}
+
+	return out, nil
+}
+
+// applyOutput 把脚本输出应用到事件和上下文上
+func applyOutput(wfCtx *models.WorkflowContext, out *ScriptOutput) error {
+	if len(out.Vars) > 0 {
+		if wfCtx.Vars == nil {
+			wfCtx.Vars = make(map[string]interface{})
+		}
+		for k, v := range out.Vars {
+			wfCtx.Vars[k] = v
+		}
+	}
+
+	event := wfCtx.Event
+	if event == nil {
+		return nil
+	}
+
+	if len(out.Tags) > 0 || len(out.DeleteTags) > 0 {
+		if event.TagsMap == nil {
+			event.SetTagsMap()
+		}
+		for _, k := range out.DeleteTags {
+			delete(event.TagsMap, k)
+		}
+		for k, v := range out.Tags {
+			if strings.ContainsAny(k, "=") || k == "" {
+				return fmt.Errorf("invalid tag key %q", k)
+			}
+			event.TagsMap[k] = v
+		}
+
+		// 保持原有标签的顺序，新增的标签追加在后面
+		tagsJSON := make([]string, 0, len(event.TagsMap))
+		seen := make(map[string]struct{}, len(event.TagsMap))
+		for _, tag := range event.TagsJSON {
+			k := strings.SplitN(tag, "=", 2)[0]
+			if v, has := event.TagsMap[k]; has {
+				tagsJSON = append(tagsJSON, k+"="+v)
+				seen[k] = struct{}{}
+			}
+		}
+		for k, v := range event.TagsMap {
+			if _, has := seen[k]; !has {
+				tagsJSON = append(tagsJSON, k+"="+v)
+			}
+		}
+		event.TagsJSON = tagsJSON
+		event.Tags = strings.Join(event.TagsJSON, ",,")
+	}
+
+	if len(out.Annotations) > 0 || len(out.DeleteAnnotations) > 0 {
+		if event.AnnotationsJSON == nil {
+			event.AnnotationsJSON = make(map[string]string)
+		}
+		for _, k := range out.DeleteAnnotations {
+			delete(event.AnnotationsJSON, k)
+		}
+		for k, v := range out.Annotations {
+			event.AnnotationsJSON[k] = v
+		}
+
+		b, err := json.Marshal(event.AnnotationsJSON)
+		if err != nil {
+			return fmt.Errorf("failed to marshal annotations: %v", err)
+		}
+		event.Annotations = string(b)
+	}
+
+	return nil
+}
+
+// interpreter 返回脚本语言对应的解释器和脚本文件名
+func interpreter(language string) (string, string, error) {
+	switch language {
+	case LanguagePython, "":
+		return "python3", "main.py", nil
+	case LanguageShell:
+		return "bash", "main.sh", nil
+	case LanguageJS:
+		return "node", "main.js", nil
+	default:
+		return "", "", fmt.Errorf("script processor: unsupported language %q", language)
+	}
+}
+
+func egressAuditLogger(a sandbox.EgressAudit) {
+	if a.Allowed {
+		logger.Infof("processor script egress[%s] ALLOW %s %s:%s ip=%s up=%d down=%d dur=%s",
+			a.ExecID, a.Method, a.Host, a.Port, a.PinnedIP, a.BytesUp, a.BytesDown, a.Duration)
+	} else {
+		logger.Infof("processor script egress[%s] DENY %s %s:%s — %s",
+			a.ExecID, a.Method, a.Host, a.Port, a.Reason)
+	}
+}
+
+func newExecID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return "sp_" + hex.EncodeToString(b[:])
+}
+
+func truncate(b []byte) string {
+	b = bytes.TrimSpace(b)
+	if len(b) > maxErrOutputBytes {
+		return string(b[:maxErrOutputBytes]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,124 @@
+package script
+
+import (
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/sandbox"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWfCtx() *models.WorkflowContext {
+	return &models.WorkflowContext{
+		Event: &models.AlertCurEvent{
+			Hash:            "abc",
+			RuleName:        "disk full",
+			TagsJSON:        []string{"ident=host-1", "mountpoint=/data"},
+			TagsMap:         map[string]string{"ident": "host-1", "mountpoint": "/data"},
+			AnnotationsJSON: map[string]string{"summary": "disk usage high"},
+		},
+		Inputs:   map[string]string{},
+		Vars:     map[string]interface{}{},
+		Metadata: map[string]string{"pipeline_id": "1"},
+	}
+}
+
+func newUnsafeSandbox(t *testing.T) *sandbox.Sandbox {
+	t.Helper()
+	s := sandbox.New(sandbox.Config{Engine: "unsafe", DataDir: t.TempDir(), AllowUnsafePipelineScript: true})
+	if !s.Enabled() {
+		t.Skipf("unsafe sandbox unavailable: %s", s.DisabledReason())
+	}
+	return s
+}
+
+func TestInitValidation(t *testing.T) {
+	_, err := (&ScriptConfig{}).Init(map[string]interface{}{"language": "python", "script": " "})
+	assert.Error(t, err)
+
+	_, err = (&ScriptConfig{}).Init(map[string]interface{}{"language": "ruby", "script": "puts 1"})
+	assert.Error(t, err)
+
+	p, err := (&ScriptConfig{}).Init(map[string]interface{}{"language": "shell", "script": "echo {}", "timeout": 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, p.(*ScriptConfig).Timeout)
+}
+
+func TestApplyOutput(t *testing.T) {
+	wfCtx := newTestWfCtx()
+	out, err := parseOutput([]byte(`{
+		"tags": {"owner": "infra", "mountpoint": "/var"},
+		"delete_tags": ["ident"],
+		"annotations": {"top_dirs": "/var/log 10G"},
+		"delete_annotations": ["summary"],
+		"vars": {"size": 10}
+	}`))
+	assert.NoError(t, err)
+	assert.NoError(t, applyOutput(wfCtx, out))
+
+	event := wfCtx.Event
+	assert.Equal(t, []string{"mountpoint=/var", "owner=infra"}, event.TagsJSON)
+	assert.Equal(t, "mountpoint=/var,,owner=infra", event.Tags)
+	assert.Equal(t, map[string]string{"top_dirs": "/var/log 10G"}, event.AnnotationsJSON)
+	assert.JSONEq(t, `{"top_dirs": "/var/log 10G"}`, event.Annotations)
+	assert.Equal(t, float64(10), wfCtx.Vars["size"])
+
+	_, err = parseOutput([]byte("not json"))
+	assert.Error(t, err)
+
+	empty, err := parseOutput([]byte("\n"))
+	assert.NoError(t, err)
+	assert.False(t, empty.Drop)
+}
+
+func TestProcessDisabledSandbox(t *testing.T) {
+	SetSandbox(sandbox.New(sandbox.Config{Disabled: true}))
+	defer SetSandbox(nil)
+
+	c := &ScriptConfig{Language: LanguageShell, Script: "echo {}"}
+	_, err := c.ProcessWithBranch(nil, newTestWfCtx())
+	assert.True(t, sandbox.IsDisabled(err), "expected DisabledError, got %v", err)
+}
+
+func TestProcessUnsafeNotAllowed(t *testing.T) {
+	s := sandbox.New(sandbox.Config{Engine: "unsafe", DataDir: t.TempDir()})
+	if s.EngineName() != sandbox.EngineUnsafe {
+		t.Skipf("unsafe sandbox unavailable: %s", s.DisabledReason())
+	}
+	SetSandbox(s)
+	defer SetSandbox(nil)
+
+	c := &ScriptConfig{Language: LanguageShell, Script: "echo {}"}
+	_, err := c.ProcessWithBranch(nil, newTestWfCtx())
+	assert.True(t, sandbox.IsDisabled(err), "expected DisabledError, got %v", err)
+}
+
+func TestProcessShell(t *testing.T) {
+	SetSandbox(newUnsafeSandbox(t))
+	defer SetSandbox(nil)
+
+	// 脚本从 stdin 读取上下文，输出标签修改和分支选择
+	c := &ScriptConfig{
+		Language: LanguageShell,
+		Script: `input=$(cat)
+case "$input" in
+  *host-1*) echo '{"tags": {"checked": "yes"}, "branch": 1, "message": "ok"}' ;;
+  *) echo '{}' ;;
+esac`,
+	}
+	output, err := c.ProcessWithBranch(nil, newTestWfCtx())
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", output.Message)
+	assert.Equal(t, 1, *output.BranchIndex)
+	assert.Equal(t, "yes", output.WfCtx.Event.TagsMap["checked"])
+
+	drop := &ScriptConfig{Language: LanguageShell, Script: `echo '{"drop": true}'`}
+	output, err = drop.ProcessWithBranch(nil, newTestWfCtx())
+	assert.NoError(t, err)
+	assert.True(t, output.Terminate)
+	assert.Nil(t, output.WfCtx.Event)
+
+	fail := &ScriptConfig{Language: LanguageShell, Script: `echo boom >&2; exit 3`}
+	_, err = fail.ProcessWithBranch(nil, newTestWfCtx())
+	assert.ErrorContains(t, err, "exited with code 3: boom")
+}
@@ -28,9 +28,11 @@ type Scheduler struct {
 	stats *astats.Stats
 
 	datasourceCache *memsto.DatasourceCacheType
+	busiGroupCache  *memsto.BusiGroupCacheType
 }
 
-func NewScheduler(aconf aconf.Alert, rrc *memsto.RecordingRuleCacheType, promClients *prom.PromClientMap, writers *writer.WritersType, stats *astats.Stats, datasourceCache *memsto.DatasourceCacheType) *Scheduler {
+func NewScheduler(aconf aconf.Alert, rrc *memsto.RecordingRuleCacheType, promClients *prom.PromClientMap, writers *writer.WritersType, stats *astats.Stats,
+	datasourceCache *memsto.DatasourceCacheType, busiGroupCache *memsto.BusiGroupCacheType) *Scheduler {
 	scheduler := &Scheduler{
 		aconf:       aconf,
 		recordRules: make(map[string]*RecordRuleContext),
@@ -43,6 +45,7 @@ func NewScheduler(aconf aconf.Alert, rrc *memsto.RecordingRuleCacheType, promCli
 		stats: stats,
 
 		datasourceCache: datasourceCache,
+		busiGroupCache:  busiGroupCache,
 	}
 
 	go scheduler.LoopSyncRules(context.Background())
@@ -71,7 +74,9 @@ func (s *Scheduler) syncRecordRules() {
 			continue
 		}
 
-		datasourceIds := s.datasourceCache.GetIDsByDsCateAndQueries("prometheus", rule.DatasourceQueries)
+		// 只匹配规则所在业务组的租户下的数据源
+		tid := s.busiGroupCache.GetTenantId(rule.GroupId)
+		datasourceIds := s.datasourceCache.GetIDsByDsCateAndQueriesOfTenant("prometheus", rule.DatasourceQueries, tid)
 		for _, dsId := range datasourceIds {
 			if !naming.DatasourceHashRing.IsHit(strconv.FormatInt(dsId, 10), fmt.Sprintf("%d", rule.Id), s.aconf.Heartbeat.Endpoint) {
 				continue
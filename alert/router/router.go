@@ -56,6 +56,7 @@ func (rt *Router) Config(r *gin.Engine) {
 	service.GET("/alert-eval-detail/:id", rt.alertEvalDetail)
 	service.GET("/eval-records", rt.evalRecordsGet)
 	service.GET("/trace-logs/:traceid", rt.traceLogs)
+	service.GET("/notify-rate-limits", rt.notifyRateLimitGet)
 }
 
 func Render(c *gin.Context, data, msg interface{}) {
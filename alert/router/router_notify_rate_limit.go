@@ -0,0 +1,14 @@
+package router
+
+import (
+	"github.com/ccfos/nightingale/v6/alert/dispatch"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notifyRateLimitGet 查看本引擎节点通知限流器的状态
+// GET /v1/n9e/notify-rate-limits?scope=channel|recipient|rule
+func (rt *Router) notifyRateLimitGet(c *gin.Context) {
+	ginx.NewRender(c).Data(dispatch.NotifyLimiter.Snapshot(ginx.QueryStr(c, "scope", "")), nil)
+}
@@ -0,0 +1,271 @@
+package scrape
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/memsto"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pushgw/writer"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/toolkits/pkg/logger"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	addressLabel     = "__address__"
+	schemeLabel      = "__scheme__"
+	metricsPathLabel = "__metrics_path__"
+	paramLabelPrefix = "__param_"
+	metaTargetPrefix = "__meta_target_"
+
+	// file_sd / http_sd 的刷新间隔
+	sdRefreshInterval = 30 * time.Second
+)
+
+// targetGroup prometheus file_sd / http_sd 格式
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// scrapeTarget 经过 relabel 之后的抓取目标
+type scrapeTarget struct {
+	url    string
+	labels []prompb.Label // 附加到每条 series 上的标签，已排序，不含 __ 开头的标签
+}
+
+// discoverer 缓存一个抓取任务的服务发现结果，file/http 按 sdRefreshInterval 刷新，
+// 刷新失败时沿用上一次的结果
+type discoverer struct {
+	sdFileDir string // file_sd 文件只从这个目录读取
+	groups    []targetGroup
+	refreshAt time.Time
+}
+
+func (d *discoverer) targetGroups(job *models.ScrapeJob, targetCache *memsto.TargetCacheType) []targetGroup {
+	switch job.SdType {
+	case models.ScrapeSdStatic:
+		return []targetGroup{{Targets: job.Targets}}
+	case models.ScrapeSdTarget:
+		return discoverTargets(job, targetCache)
+	}
+
+	if time.Since(d.refreshAt) < sdRefreshInterval {
+		return d.groups
+	}
+	d.refreshAt = time.Now()
+
+	var groups []targetGroup
+	var err error
+	if job.SdType == models.ScrapeSdFile {
+		groups, err = readFileSd(d.sdFileDir, job.SdFile)
+	} else {
+		groups, err = fetchHttpSd(job.SdUrl)
+	}
+
+	if err != nil {
+		logger.Warningf("scrape job:%d service discovery failed: %v", job.Id, err)
+		return d.groups
+	}
+
+	d.groups = groups
+	return groups
+}
+
+// readFileSd 读取 dir 下的 file_sd 文件，name 必须是 dir 内的相对路径
+func readFileSd(dir, name string) ([]targetGroup, error) {
+	if dir == "" || !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("sd_file(%s) is not under the sd file dir", name)
+	}
+
+	path := filepath.Join(dir, name)
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// json 是 yaml 的子集，两种格式都用 yaml 解析
+	var groups []targetGroup
+	if err := yaml.Unmarshal(bs, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return groups, nil
+}
+
+func fetchHttpSd(sdUrl string) ([]targetGroup, error) {
+	req, err := http.NewRequest(http.MethodGet, sdUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Prometheus-Refresh-Interval-Seconds", strconv.Itoa(int(sdRefreshInterval.Seconds())))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, sdUrl)
+	}
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []targetGroup
+	if err := yaml.Unmarshal(bs, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse response of %s: %v", sdUrl, err)
+	}
+	return groups, nil
+}
+
+// discoverTargets 机器列表中属于指定业务组的机器，优先用 host_ip 拼接端口
+func discoverTargets(job *models.ScrapeJob, targetCache *memsto.TargetCacheType) []targetGroup {
+	gids := job.TargetGroupIds
+	if len(gids) == 0 {
+		gids = []int64{job.GroupId}
+	}
+
+	var groups []targetGroup
+	for _, t := range targetCache.GetAll() {
+		if !matchGroup(t.GroupIds, gids) {
+			continue
+		}
+
+		host := t.HostIp
+		if host == "" {
+			host = t.Ident
+		}
+
+		labels := map[string]string{
+			"ident":                      t.Ident,
+			metaTargetPrefix + "ident":   t.Ident,
+			metaTargetPrefix + "host_ip": t.HostIp,
+		}
+		for k, v := range t.TagsMap {
+			if model.LabelNameRE.MatchString(k) {
+				labels[metaTargetPrefix+"tag_"+k] = v
+			}
+		}
+
+		groups = append(groups, targetGroup{
+			Targets: []string{net.JoinHostPort(host, strconv.Itoa(job.TargetPort))},
+			Labels:  labels,
+		})
+	}
+	return groups
+}
+
+func matchGroup(targetGids, gids []int64) bool {
+	for _, tg := range targetGids {
+		for _, g := range gids {
+			if tg == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildTargets 组装 target 标签并执行 relabel_configs，和 prometheus 一致：
+// relabel 之后 __address__ 为空的 target 被丢弃，instance 缺省为 __address__，
+// __scheme__ / __metrics_path__ / __param_xxx 决定抓取地址，其余 __ 开头的标签被去掉
+func buildTargets(job *models.ScrapeJob, groups []targetGroup) []*scrapeTarget {
+	var result []*scrapeTarget
+	for _, g := range groups {
+		for _, addr := range g.Targets {
+			lm := map[string]string{
+				addressLabel:     addr,
+				schemeLabel:      job.Scheme,
+				metricsPathLabel: job.MetricsPath,
+				"job":            job.Name,
+			}
+			for k, v := range job.Labels {
+				lm[k] = v
+			}
+			for k, v := range g.Labels {
+				lm[k] = v
+			}
+
+			lset := make([]prompb.Label, 0, len(lm))
+			for k, v := range lm {
+				lset = append(lset, prompb.Label{Name: k, Value: v})
+			}
+
+			if len(job.RelabelConfigs) > 0 {
+				lset = writer.Process(lset, job.RelabelConfigs...)
+			}
+
+			if t := newScrapeTarget(lset); t != nil {
+				result = append(result, t)
+			}
+		}
+	}
+	return result
+}
+
+func newScrapeTarget(lset []prompb.Label) *scrapeTarget {
+	var address, scheme, path, instance string
+	params := url.Values{}
+	labels := make([]prompb.Label, 0, len(lset))
+
+	for _, l := range lset {
+		switch {
+		case l.Name == addressLabel:
+			address = l.Value
+		case l.Name == schemeLabel:
+			scheme = l.Value
+		case l.Name == metricsPathLabel:
+			path = l.Value
+		case strings.HasPrefix(l.Name, paramLabelPrefix):
+			params.Set(strings.TrimPrefix(l.Name, paramLabelPrefix), l.Value)
+		case strings.HasPrefix(l.Name, "__"):
+		case l.Value == "":
+		default:
+			if l.Name == "instance" {
+				instance = l.Value
+			}
+			labels = append(labels, l)
+		}
+	}
+
+	if address == "" {
+		return nil
+	}
+
+	if instance == "" {
+		labels = append(labels, prompb.Label{Name: "instance", Value: address})
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+	if scheme == "" {
+		scheme = "http"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	u := scheme + "://" + address + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	return &scrapeTarget{url: u, labels: labels}
+}
@@ -0,0 +1,194 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/alert/naming"
+	"github.com/ccfos/nightingale/v6/memsto"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+	"github.com/ccfos/nightingale/v6/pushgw/writer"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/toolkits/pkg/logger"
+	"github.com/toolkits/pkg/str"
+)
+
+// Scheduler 抓取任务调度：每个 target 按 job id + 抓取地址在告警引擎的 hash ring 上分片，
+// 只有命中当前实例的 target 才会被拉取，抓取结果写入 writers，和 remote write 的数据走同一条写入链路
+type Scheduler struct {
+	aconf aconf.Alert
+
+	scrapeJobCache *memsto.ScrapeJobCacheType
+	targetCache    *memsto.TargetCacheType
+
+	writers     *writer.WritersType
+	queueNumber int
+	client      *http.Client
+
+	// key: job id
+	jobs map[int64]*jobState
+	// key: hash
+	loops map[string]*scrapeLoop
+}
+
+type jobState struct {
+	job        *models.ScrapeJob // relabel 配置已编译的副本
+	discoverer *discoverer
+}
+
+func NewScheduler(aconf aconf.Alert, pushgwc pconf.Pushgw, sjc *memsto.ScrapeJobCacheType, targetCache *memsto.TargetCacheType, writers *writer.WritersType) *Scheduler {
+	scheduler := &Scheduler{
+		aconf: aconf,
+
+		scrapeJobCache: sjc,
+		targetCache:    targetCache,
+
+		writers:     writers,
+		queueNumber: pushgwc.WriterOpt.QueueNumber,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: 2,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+
+		jobs:  make(map[int64]*jobState),
+		loops: make(map[string]*scrapeLoop),
+	}
+
+	go scheduler.LoopSyncJobs(context.Background())
+	return scheduler
+}
+
+func (s *Scheduler) LoopSyncJobs(ctx context.Context) {
+	time.Sleep(time.Duration(s.aconf.EngineDelay) * time.Second)
+	duration := 9000 * time.Millisecond
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(duration):
+			s.syncScrapeJobs()
+		}
+	}
+}
+
+func (s *Scheduler) syncScrapeJobs() {
+	jobs := s.scrapeJobCache.GetAll()
+	loops := make(map[string]*scrapeLoop)
+	active := make(map[int64]struct{}, len(jobs))
+
+	for _, job := range jobs {
+		active[job.Id] = struct{}{}
+		state := s.jobState(job)
+
+		groups := state.discoverer.targetGroups(state.job, s.targetCache)
+		for _, target := range buildTargets(state.job, groups) {
+			if !naming.DatasourceHashRing.IsHit(s.aconf.Heartbeat.EngineName, fmt.Sprintf("%d_%s", job.Id, target.url), s.aconf.Heartbeat.Endpoint) {
+				continue
+			}
+
+			hash := str.MD5(fmt.Sprintf("%d_%d_%s_%v", job.Id, job.UpdateAt, target.url, target.labels))
+			if _, has := loops[hash]; has {
+				continue
+			}
+			loops[hash] = newScrapeLoop(state.job, target, s.client, s.pusher(target.url))
+		}
+	}
+
+	for hash, loop := range loops {
+		if _, has := s.loops[hash]; !has {
+			loop.start()
+			s.loops[hash] = loop
+		}
+	}
+
+	for hash, loop := range s.loops {
+		if _, has := loops[hash]; !has {
+			loop.stop()
+			delete(s.loops, hash)
+		}
+	}
+
+	for id := range s.jobs {
+		if _, has := active[id]; !has {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// jobState 任务有变更时重新编译 relabel 配置并重置服务发现缓存
+func (s *Scheduler) jobState(job *models.ScrapeJob) *jobState {
+	if state, has := s.jobs[job.Id]; has && state.job.UpdateAt == job.UpdateAt {
+		return state
+	}
+
+	cp := *job
+	cp.RelabelConfigs = prepareRelabelConfigs(job.Id, job.RelabelConfigs)
+	cp.MetricRelabelConfigs = prepareRelabelConfigs(job.Id, job.MetricRelabelConfigs)
+
+	state := &jobState{job: &cp, discoverer: &discoverer{sdFileDir: s.aconf.Scrape.SdFileDir}}
+	s.jobs[job.Id] = state
+	return state
+}
+
+// pusher 同一个 target 的数据固定写入同一个队列
+func (s *Scheduler) pusher(url string) func([]prompb.TimeSeries) {
+	queueid := "0"
+	if s.queueNumber > 0 {
+		queueid = fmt.Sprint(crc32.ChecksumIEEE([]byte(url)) % uint32(s.queueNumber))
+	}
+
+	return func(series []prompb.TimeSeries) {
+		for i := range series {
+			if err := s.writers.PushSample(queueid, series[i]); err != nil {
+				logger.Warningf("failed to push scraped series: %v", err)
+			}
+		}
+	}
+}
+
+// prepareRelabelConfigs 补齐默认值并编译正则，与 pushgw writer 的 relabel 配置处理一致
+func prepareRelabelConfigs(jobId int64, cfgs []*pconf.RelabelConfig) []*pconf.RelabelConfig {
+	result := make([]*pconf.RelabelConfig, 0, len(cfgs))
+	for _, c := range cfgs {
+		if c == nil {
+			continue
+		}
+
+		cfg := *c
+		if cfg.Regex == "" {
+			cfg.Regex = "(.*)"
+		}
+
+		regex, err := regexp.Compile("^(?:" + cfg.Regex + ")$")
+		if err != nil {
+			logger.Errorf("scrape job:%d failed to compile regexp:%s error:%v", jobId, cfg.Regex, err)
+			continue
+		}
+		cfg.RegexCompiled = regex
+
+		if cfg.Separator == "" {
+			cfg.Separator = ";"
+		}
+
+		if cfg.Action == "" {
+			cfg.Action = "replace"
+		}
+
+		if cfg.Replacement == "" {
+			cfg.Replacement = "$1"
+		}
+
+		result = append(result, &cfg)
+	}
+	return result
+}
@@ -0,0 +1,256 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pushgw/writer"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	acceptHeader = `application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1`
+
+	// 单次抓取的响应体上限，防止异常的 target 撑爆内存
+	maxBodySize = 64 << 20
+)
+
+// scrapeLoop 按任务的抓取周期拉取一个 target
+type scrapeLoop struct {
+	job    *models.ScrapeJob
+	target *scrapeTarget
+	client *http.Client
+	push   func([]prompb.TimeSeries)
+	quit   chan struct{}
+}
+
+func newScrapeLoop(job *models.ScrapeJob, target *scrapeTarget, client *http.Client, push func([]prompb.TimeSeries)) *scrapeLoop {
+	return &scrapeLoop{
+		job:    job,
+		target: target,
+		client: client,
+		push:   push,
+		quit:   make(chan struct{}),
+	}
+}
+
+func (sl *scrapeLoop) key() string {
+	return fmt.Sprintf("scrape-%d-%s", sl.job.Id, sl.target.url)
+}
+
+func (sl *scrapeLoop) start() {
+	logger.Infof("%s started", sl.key())
+	go sl.run()
+}
+
+func (sl *scrapeLoop) stop() {
+	logger.Infof("%s stopped", sl.key())
+	close(sl.quit)
+}
+
+func (sl *scrapeLoop) run() {
+	interval := time.Duration(sl.job.ScrapeInterval) * time.Second
+
+	// 按 target 地址错开首次抓取的时间，避免所有 target 同时被拉取
+	h := fnv.New64a()
+	h.Write([]byte(sl.target.url))
+	offset := time.Duration(h.Sum64() % uint64(interval))
+
+	select {
+	case <-sl.quit:
+		return
+	case <-time.After(offset):
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sl.scrapeAndPush(time.Now())
+
+		select {
+		case <-sl.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sl *scrapeLoop) scrapeAndPush(start time.Time) {
+	series, scraped, err := sl.scrape(start)
+	if err != nil {
+		logger.Warningf("%s failed: %v", sl.key(), err)
+	}
+
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+
+	ts := start.UnixMilli()
+	series = append(series,
+		sl.reportSeries("up", up, ts),
+		sl.reportSeries("scrape_duration_seconds", time.Since(start).Seconds(), ts),
+		sl.reportSeries("scrape_samples_scraped", float64(scraped), ts),
+	)
+
+	sl.push(series)
+}
+
+// scrape 拉取并解析 target，返回附加了 target 标签、执行过 metric_relabel_configs 的
+// series，以及解析出的样本数
+func (sl *scrapeLoop) scrape(start time.Time) ([]prompb.TimeSeries, int, error) {
+	timeout := time.Duration(sl.job.ScrapeTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sl.target.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for k, v := range sl.job.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+
+	resp, err := sl.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(body) > maxBodySize {
+		return nil, 0, fmt.Errorf("body size exceeds the limit %d bytes", maxBodySize)
+	}
+
+	return sl.parse(body, resp.Header.Get("Content-Type"), start.UnixMilli())
+}
+
+func (sl *scrapeLoop) parse(body []byte, contentType string, defTs int64) ([]prompb.TimeSeries, int, error) {
+	p, err := textparse.New(body, contentType, false)
+	if err != nil {
+		// 无法识别的 Content-Type 按 prometheus 文本格式解析
+		logger.Debugf("%s invalid content type %q: %v", sl.key(), contentType, err)
+	}
+
+	var (
+		result  []prompb.TimeSeries
+		scraped int
+		lset    labels.Labels
+		ex      exemplar.Exemplar
+	)
+
+	for {
+		et, err := p.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, scraped, err
+		}
+
+		// 文本格式不会出现原生直方图，只处理普通样本
+		if et != textparse.EntrySeries {
+			continue
+		}
+
+		_, tp, v := p.Series()
+		p.Metric(&lset)
+		scraped++
+
+		t := defTs
+		if tp != nil {
+			t = *tp
+		}
+
+		ls := sl.mutateLabels(lset)
+		if len(ls) == 0 {
+			continue
+		}
+
+		ts := prompb.TimeSeries{
+			Labels:  ls,
+			Samples: []prompb.Sample{{Value: v, Timestamp: t}},
+		}
+
+		if p.Exemplar(&ex) {
+			ets := t
+			if ex.HasTs {
+				ets = ex.Ts
+			}
+			ts.Exemplars = []prompb.Exemplar{{
+				Labels:    labelsToProto(ex.Labels),
+				Value:     ex.Value,
+				Timestamp: ets,
+			}}
+			ex = exemplar.Exemplar{}
+		}
+
+		result = append(result, ts)
+	}
+
+	return result, scraped, nil
+}
+
+// mutateLabels 附加 target 标签并执行 metric_relabel_configs，返回 nil 表示丢弃。
+// 与 prometheus 的 honor_labels 语义一致：为 false 时冲突的抓取标签改名为 exported_xxx
+func (sl *scrapeLoop) mutateLabels(lset labels.Labels) []prompb.Label {
+	lb := labels.NewBuilder(lset)
+	for _, l := range sl.target.labels {
+		if existing := lset.Get(l.Name); existing != "" {
+			if sl.job.HonorLabels {
+				continue
+			}
+			lb.Set("exported_"+l.Name, existing)
+		}
+		lb.Set(l.Name, l.Value)
+	}
+
+	result := labelsToProto(lb.Labels())
+	if len(sl.job.MetricRelabelConfigs) > 0 {
+		result = writer.Process(result, sl.job.MetricRelabelConfigs...)
+	}
+	return result
+}
+
+// reportSeries 每次抓取额外生成的 up / scrape_duration_seconds 等 series，只带 target 标签
+func (sl *scrapeLoop) reportSeries(name string, value float64, ts int64) prompb.TimeSeries {
+	ls := make([]prompb.Label, 0, len(sl.target.labels)+1)
+	ls = append(ls, prompb.Label{Name: "__name__", Value: name})
+	ls = append(ls, sl.target.labels...)
+
+	return prompb.TimeSeries{
+		Labels:  ls,
+		Samples: []prompb.Sample{{Value: value, Timestamp: ts}},
+	}
+}
+
+func labelsToProto(lset labels.Labels) []prompb.Label {
+	result := make([]prompb.Label, 0, lset.Len())
+	lset.Range(func(l labels.Label) {
+		result = append(result, prompb.Label{Name: l.Name, Value: l.Value})
+	})
+	return result
+}
@@ -0,0 +1,197 @@
+package scrape
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func labelMap(ls []prompb.Label) map[string]string {
+	m := make(map[string]string, len(ls))
+	for _, l := range ls {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+func findSeries(series []prompb.TimeSeries, name string) *prompb.TimeSeries {
+	for i := range series {
+		if labelMap(series[i].Labels)["__name__"] == name {
+			return &series[i]
+		}
+	}
+	return nil
+}
+
+func TestScrapeAndPush(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/custom", r.URL.Path)
+		assert.Equal(t, "node", r.URL.Query().Get("module"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		w.Write([]byte(`# TYPE http_requests counter
+http_requests_total{code="200",job="app"} 10 # {trace_id="abc"} 1.5
+# TYPE go_goroutines gauge
+go_goroutines 8
+# TYPE debug_info gauge
+debug_info 1
+# EOF
+`))
+	}))
+	defer srv.Close()
+
+	job := &models.ScrapeJob{
+		Id:             1,
+		Name:           "node",
+		SdType:         models.ScrapeSdStatic,
+		Targets:        []string{strings.TrimPrefix(srv.URL, "http://")},
+		MetricsPath:    "/custom",
+		Headers:        map[string]string{"Authorization": "Bearer token"},
+		Labels:         map[string]string{"region": "bj"},
+		RelabelConfigs: []*pconf.RelabelConfig{{TargetLabel: "__param_module", Replacement: "node"}},
+		MetricRelabelConfigs: []*pconf.RelabelConfig{
+			{SourceLabels: model.LabelNames{"__name__"}, Regex: "debug_.*", Action: "drop"},
+		},
+	}
+	require.NoError(t, job.Verify())
+	job.RelabelConfigs = prepareRelabelConfigs(job.Id, job.RelabelConfigs)
+	job.MetricRelabelConfigs = prepareRelabelConfigs(job.Id, job.MetricRelabelConfigs)
+
+	targets := buildTargets(job, []targetGroup{{Targets: job.Targets}})
+	require.Len(t, targets, 1)
+	assert.Equal(t, srv.URL+"/custom?module=node", targets[0].url)
+
+	var pushed []prompb.TimeSeries
+	sl := newScrapeLoop(job, targets[0], srv.Client(), func(series []prompb.TimeSeries) { pushed = series })
+	sl.scrapeAndPush(time.Now())
+
+	requests := findSeries(pushed, "http_requests_total")
+	require.NotNil(t, requests)
+	lm := labelMap(requests.Labels)
+	assert.Equal(t, "node", lm["job"])
+	assert.Equal(t, "app", lm["exported_job"])
+	assert.Equal(t, "bj", lm["region"])
+	assert.Equal(t, strings.TrimPrefix(srv.URL, "http://"), lm["instance"])
+	assert.Equal(t, float64(10), requests.Samples[0].Value)
+	require.Len(t, requests.Exemplars, 1)
+	assert.Equal(t, "abc", labelMap(requests.Exemplars[0].Labels)["trace_id"])
+
+	assert.NotNil(t, findSeries(pushed, "go_goroutines"))
+	assert.Nil(t, findSeries(pushed, "debug_info"), "dropped by metric relabel")
+
+	up := findSeries(pushed, "up")
+	require.NotNil(t, up)
+	assert.Equal(t, float64(1), up.Samples[0].Value)
+	assert.Equal(t, float64(3), findSeries(pushed, "scrape_samples_scraped").Samples[0].Value)
+
+	// honor_labels 为 true 时保留抓取到的标签
+	job.HonorLabels = true
+	sl.scrapeAndPush(time.Now())
+	lm = labelMap(findSeries(pushed, "http_requests_total").Labels)
+	assert.Equal(t, "app", lm["job"])
+	assert.Empty(t, lm["exported_job"])
+}
+
+func TestScrapeFailedUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	job := &models.ScrapeJob{Id: 2, Name: "broken", SdType: models.ScrapeSdStatic, Targets: []string{strings.TrimPrefix(srv.URL, "http://")}}
+	require.NoError(t, job.Verify())
+
+	targets := buildTargets(job, []targetGroup{{Targets: job.Targets}})
+	require.Len(t, targets, 1)
+
+	var pushed []prompb.TimeSeries
+	sl := newScrapeLoop(job, targets[0], srv.Client(), func(series []prompb.TimeSeries) { pushed = series })
+	sl.scrapeAndPush(time.Now())
+
+	require.Len(t, pushed, 3)
+	up := findSeries(pushed, "up")
+	require.NotNil(t, up)
+	assert.Equal(t, float64(0), up.Samples[0].Value)
+	assert.Equal(t, "broken", labelMap(up.Labels)["job"])
+}
+
+func TestBuildTargetsRelabel(t *testing.T) {
+	job := &models.ScrapeJob{
+		Id:     3,
+		Name:   "file",
+		SdType: models.ScrapeSdFile,
+		SdFile: "unused",
+		RelabelConfigs: []*pconf.RelabelConfig{
+			{SourceLabels: model.LabelNames{"env"}, Regex: "dev", Action: "drop"},
+			{SourceLabels: model.LabelNames{"__meta_zone"}, TargetLabel: "zone"},
+		},
+	}
+	require.NoError(t, job.Verify())
+	job.RelabelConfigs = prepareRelabelConfigs(job.Id, job.RelabelConfigs)
+
+	targets := buildTargets(job, []targetGroup{
+		{Targets: []string{"10.0.0.1:9100", "10.0.0.2:9100"}, Labels: map[string]string{"env": "prod", "__meta_zone": "a"}},
+		{Targets: []string{"10.0.0.3:9100"}, Labels: map[string]string{"env": "dev"}},
+	})
+	require.Len(t, targets, 2)
+	assert.Equal(t, "http://10.0.0.1:9100/metrics", targets[0].url)
+
+	lm := labelMap(targets[0].labels)
+	assert.Equal(t, "a", lm["zone"])
+	assert.Equal(t, "prod", lm["env"])
+	assert.Equal(t, "10.0.0.1:9100", lm["instance"])
+	_, has := lm["__meta_zone"]
+	assert.False(t, has, "meta labels are dropped after relabeling")
+}
+
+func TestFileAndHttpSd(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "targets.yml")
+	require.NoError(t, os.WriteFile(yamlFile, []byte("- targets: ['a:9100', 'b:9100']\n  labels:\n    env: prod\n"), 0o644))
+
+	groups, err := readFileSd(dir, "targets.yml")
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"a:9100", "b:9100"}, groups[0].Targets)
+	assert.Equal(t, "prod", groups[0].Labels["env"])
+
+	// 只能读 sd 目录下的文件
+	_, err = readFileSd(dir, yamlFile)
+	assert.Error(t, err)
+	_, err = readFileSd(filepath.Join(dir, "sub"), "../targets.yml")
+	assert.Error(t, err)
+	assert.Error(t, (&models.ScrapeJob{Name: "file", SdType: models.ScrapeSdFile, SdFile: "/etc/passwd"}).Verify())
+	assert.Error(t, (&models.ScrapeJob{Name: "file", SdType: models.ScrapeSdFile, SdFile: "../passwd"}).Verify())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"targets":["c:9100"],"labels":{"env":"test"}}]`))
+	}))
+	defer srv.Close()
+
+	job := &models.ScrapeJob{Id: 4, Name: "http", SdType: models.ScrapeSdHttp, SdUrl: srv.URL}
+	require.NoError(t, job.Verify())
+
+	d := &discoverer{}
+	groups = d.targetGroups(job, nil)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"c:9100"}, groups[0].Targets)
+
+	// 刷新失败时沿用上一次的结果
+	srv.Close()
+	d.refreshAt = time.Time{}
+	groups = d.targetGroups(job, nil)
+	require.Len(t, groups, 1)
+}
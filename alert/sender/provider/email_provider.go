@@ -57,7 +57,7 @@ func (p *EmailProvider) Notify(ctx context.Context, req *NotifyRequest) *NotifyR
 			}
 		}()
 		select {
-		case req.SmtpChan <- &models.EmailContext{NotifyRuleId: req.NotifyRuleId, Events: req.Events, Mail: m}:
+		case req.SmtpChan <- &models.EmailContext{NotifyRuleId: req.NotifyRuleId, Events: req.Events, Mail: m, Retry: req.Retry(req.Sendtos)}:
 			result = &NotifyResult{Target: target, Response: "queued", Err: nil}
 		case <-ctx.Done():
 			result = &NotifyResult{Target: target, Err: ctx.Err()}
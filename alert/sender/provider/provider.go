@@ -35,6 +35,31 @@ type NotifyRequest struct {
 	HttpClient           *http.Client              // 由 cache 层提供
 	SmtpChan             chan *models.EmailContext // 由 cache 层提供 (仅 smtp 类型)
 	SiteUrl              string
+	TemplateId           int64 // 消息模板 ID，发送失败重试时按它重新渲染
+	RetryId              int64 // 重试或手动重发时对应的 notify_retry ID
+}
+
+// Retry 返回本次投递的重试信息，sendtos 为实际发送失败的接收人
+func (r *NotifyRequest) Retry(sendtos []string) *models.NotifyRetry {
+	retry := &models.NotifyRetry{
+		Id:                   r.RetryId,
+		NotifyRuleId:         r.NotifyRuleId,
+		TemplateId:           r.TemplateId,
+		EventIds:             make([]int64, 0, len(r.Events)),
+		Sendtos:              sendtos,
+		CustomParams:         r.CustomParams,
+		FlashDutyChannelIDs:  r.FlashDutyChannelIDs,
+		PagerDutyRoutingKeys: r.PagerDutyRoutingKeys,
+		ImGroupIDs:           r.ImGroupIDs,
+	}
+	if r.Config != nil {
+		retry.ChannelId = r.Config.ID
+		retry.ChannelName = r.Config.Name
+	}
+	for _, event := range r.Events {
+		retry.EventIds = append(retry.EventIds, event.Id)
+	}
+	return retry
 }
 
 type NotifyResult struct {
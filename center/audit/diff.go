@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxFieldSize before/after/diff 单个字段落库的最大长度，超出截断
+const MaxFieldSize = 60 * 1024
+
+const redacted = "******"
+
+var sensitiveKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private_key|access_key|api_key)`)
+
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+	// Redacted 为 true 表示字段确实有变化，但 before/after 中的敏感值已脱敏
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// Snapshot 把对象转成通用的 json 结构并脱敏，nil 表示对象不存在
+func Snapshot(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return nil
+	}
+
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	return SnapshotJSON(bs)
+}
+
+// SnapshotJSON 解析 json 并脱敏，非法 json 返回 nil
+func SnapshotJSON(bs []byte) interface{} {
+	ret := parseJSON(bs)
+	redact(ret)
+	return ret
+}
+
+func parseJSON(bs []byte) interface{} {
+	var ret interface{}
+	if err := json.Unmarshal(bs, &ret); err != nil {
+		return nil
+	}
+	return ret
+}
+
+// redact 原地脱敏，返回是否有值被替换
+func redact(v interface{}) bool {
+	found := false
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			if s, ok := item.(string); ok && s != "" && sensitiveKey.MatchString(k) {
+				t[k] = redacted
+				found = true
+				continue
+			}
+			if redact(item) {
+				found = true
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			if redact(t[i]) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// DiffJSON 用原文对比两段 json，再对变化的值脱敏。先脱敏再对比时敏感字段的修改会被漏掉
+func DiffJSON(before, after []byte) []Change {
+	changes := Diff(parseJSON(before), parseJSON(after))
+	for i := range changes {
+		ch := &changes[i]
+		var hidden bool
+		ch.Before, hidden = redactField(ch.Field, ch.Before)
+		ch.Redacted = hidden
+		ch.After, hidden = redactField(ch.Field, ch.After)
+		ch.Redacted = ch.Redacted || hidden
+	}
+	return changes
+}
+
+// redactField 脱敏展开后的单个字段，field 的最后一段是字段名
+func redactField(field string, v interface{}) (interface{}, bool) {
+	key := field[strings.LastIndex(field, ".")+1:]
+	if s, ok := v.(string); ok && s != "" && sensitiveKey.MatchString(key) {
+		return redacted, true
+	}
+	return v, redact(v)
+}
+
+// Diff 对比两个快照，嵌套对象展开为以点分隔的字段名，数组整体比较
+func Diff(before, after interface{}) []Change {
+	b := make(map[string]interface{})
+	a := make(map[string]interface{})
+	flatten("", before, b)
+	flatten("", after, a)
+
+	var changes []Change
+	for k, bv := range b {
+		av, has := a[k]
+		if !has {
+			changes = append(changes, Change{Field: k, Before: bv})
+			continue
+		}
+		if !reflect.DeepEqual(bv, av) {
+			changes = append(changes, Change{Field: k, Before: bv, After: av})
+		}
+	}
+	for k, av := range a {
+		if _, has := b[k]; !has {
+			changes = append(changes, Change{Field: k, After: av})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func flatten(prefix string, v interface{}, out map[string]interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		if v != nil || prefix != "" {
+			out[prefix] = v
+		}
+		return
+	}
+
+	for k, item := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		flatten(key, item, out)
+	}
+}
+
+// Marshal 序列化快照，过长时截断并以 ...(truncated) 结尾
+func Marshal(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	return truncate(string(bs))
+}
+
+func truncate(s string) string {
+	if len(s) <= MaxFieldSize {
+		return s
+	}
+
+	const suffix = "...(truncated)"
+	// 截断位置可能落在多字节字符中间，去掉残缺的字节
+	return strings.ToValidUTF8(s[:MaxFieldSize-len(suffix)], "") + suffix
+}
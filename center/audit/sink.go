@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+
+	"github.com/toolkits/pkg/logger"
+)
+
+const queueSize = 1024
+
+type sink interface {
+	send(l *models.AuditLog) error
+}
+
+var queue chan *models.AuditLog
+
+// Init 按配置启动审计记录导出，未配置 Sink 时 Export 不做任何事
+func Init(conf cconf.AuditSink) error {
+	var s sink
+	timeout := time.Duration(conf.Timeout) * time.Second
+
+	switch conf.Type {
+	case "":
+		return nil
+	case "webhook":
+		s = &webhookSink{url: conf.Address, headers: conf.Headers, client: &http.Client{Timeout: timeout}}
+	case "syslog":
+		u, err := url.Parse(conf.Address)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return fmt.Errorf("invalid audit syslog address: %s", conf.Address)
+		}
+		s = &syslogSink{network: u.Scheme, addr: u.Host, timeout: timeout}
+	default:
+		return fmt.Errorf("invalid audit sink type: %s", conf.Type)
+	}
+
+	queue = make(chan *models.AuditLog, queueSize)
+	go func() {
+		for l := range queue {
+			if err := s.send(l); err != nil {
+				logger.Warningf("audit: failed to export audit log %d: %v", l.Id, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Export 异步导出，队列满时丢弃，数据库中的记录不受影响
+func Export(l *models.AuditLog) {
+	if queue == nil {
+		return
+	}
+
+	select {
+	case queue <- l:
+	default:
+		logger.Warningf("audit: export queue is full, audit log %d is not exported", l.Id)
+	}
+}
+
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (s *webhookSink) send(l *models.AuditLog) error {
+	bs, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// syslogSink 按 RFC 5424 格式发送，消息体为审计记录的 json。
+// 不使用 log/syslog，它在 windows 上不可用
+type syslogSink struct {
+	network string
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+// facility local0, severity notice
+const syslogPriority = 16*8 + 5
+
+func (s *syslogSink) send(l *models.AuditLog) error {
+	bs, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s n9e %d audit - %s", syslogPriority,
+		time.Unix(l.CreateAt, 0).UTC().Format(time.RFC3339), hostname, os.Getpid(), bs)
+	if s.network == "tcp" {
+		// octet counting framing, RFC 6587
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	for i := 0; i < 2; i++ {
+		if s.conn == nil {
+			conn, err := net.DialTimeout(s.network, s.addr, s.timeout)
+			if err != nil {
+				return err
+			}
+			s.conn = conn
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		if _, err = s.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+
+		// 连接可能已被对端关闭，重连后再试一次
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return err
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/ccfos/nightingale/v6/pkg/httpx"
 	"github.com/ccfos/nightingale/v6/pkg/sandbox"
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
 )
 
 type Center struct {
@@ -26,6 +27,18 @@ type Center struct {
 	CleanPipelineExecutionDay int
 	// CleanAlertHisEventDay 历史告警事件保留天数，<= 0 表示永久保留不清理
 	CleanAlertHisEventDay int
+	// TargetLifecycle 机器生命周期策略：失联标记与自动下线
+	TargetLifecycle TargetLifecycle
+	// Audit 配置变更审计
+	Audit Audit
+	// EventBus 把对象变更事件推送到外部 webhook 和 kafka
+	EventBus EventBus
+	// SCIM IdP 通过 SCIM 2.0 推送用户和团队
+	SCIM SCIM
+	// TOTP 本地账号的两步验证，是否必须启用按角色配置
+	TOTP TOTP
+	// PasswordPolicy 本地账号的密码策略，默认不限制
+	PasswordPolicy        PasswordPolicy
 	MigrateBusiGroupLabel bool
 	RSA                   httpx.RSAConfig
 	AIAgent               AIAgent
@@ -68,6 +81,83 @@ type AIAgent struct {
 	HideBuiltinSkills bool `toml:"HideBuiltinSkills"`
 }
 
+// TargetLifecycle 按顺序匹配机器 ident，命中第一条策略后不再继续匹配
+type TargetLifecycle struct {
+	Interval int // 巡检周期，单位秒，默认 60
+	Policies []TargetLifecyclePolicy
+}
+
+type TargetLifecyclePolicy struct {
+	IdentPattern string // 正则，为空时匹配所有机器，如只对弹性伸缩节点生效
+	StaleMinutes int    // 超过该时长无心跳则标记失联，默认 10
+	OffboardDays int    // 失联超过该天数后下线，<= 0 表示只标记不下线
+	Action       string // 下线方式：delete 直接删除，archive 把机器快照写入变更历史后删除，默认 archive
+}
+
+type Audit struct {
+	Enable        bool
+	RetentionDays int    // 保留天数，默认 180
+	HashKey       string // 哈希链的 HMAC 密钥，为空时只是普通 sha256，能写库的人可以重算整条链
+	Sink          AuditSink
+}
+
+// AuditSink 审计记录额外导出到 syslog 或 webhook，导出失败不影响落库
+type AuditSink struct {
+	Type    string // syslog 或 webhook，为空表示不导出
+	Address string // syslog: udp://127.0.0.1:514 或 tcp://...；webhook: 完整 url
+	Headers map[string]string
+	Timeout int // 秒，默认 5
+}
+
+// EventBus 推送失败时按 RetryInterval、2*RetryInterval ... 间隔重试，重试用尽后丢弃
+type EventBus struct {
+	RetryCount    int // 重试次数，默认 3
+	RetryInterval int // 首次重试间隔，秒，默认 1
+	Webhooks      []EventBusWebhook
+	Kafkas        []EventBusKafka
+}
+
+// EventBusFilter 订阅过滤条件，为空表示不过滤
+type EventBusFilter struct {
+	ObjectTypes  []string // alert_rule mute target datasource
+	BusiGroupIds []int64  // 只推送这些业务组的事件，数据源等不属于业务组的事件不会推送
+}
+
+type EventBusWebhook struct {
+	EventBusFilter
+	Url     string
+	Headers map[string]string
+	Secret  string // 非空时在 X-N9e-Signature 头中携带 sha256=hmac(Secret, body)
+	Timeout int    // 秒，默认 5
+}
+
+type EventBusKafka struct {
+	EventBusFilter
+	Brokers []string
+	Topic   string
+	Version string
+	Timeout int // 秒，默认 5
+	SASL    *pconf.SASLConfig
+	Secret  string // 非空时在消息头 X-N9e-Signature 中携带签名
+}
+
+type SCIM struct {
+	Enable       bool
+	Token        string   // IdP 调用 /scim/v2 时携带的 Bearer token
+	DefaultRoles []string // 新建用户的角色，默认 Standard
+}
+
+type TOTP struct {
+	Issuer string // 验证器 App 中显示的发行方，默认 Nightingale
+}
+
+type PasswordPolicy struct {
+	MinLength    int // 最小长度
+	MinClasses   int // 至少包含几类字符：大写字母、小写字母、数字、其他字符，最多 4
+	HistoryCount int // 不能和最近几次用过的密码相同，包括当前密码
+	MaxAgeDays   int // 密码有效天数，过期后登录时必须先修改密码
+}
+
 type Plugin struct {
 	Id       int64  `json:"id"`
 	Category string `json:"category"`
@@ -90,6 +180,49 @@ func (c *Center) PreCheck() {
 	if len(c.Plugins) == 0 {
 		c.Plugins = Plugins
 	}
+	if c.TargetLifecycle.Interval <= 0 {
+		c.TargetLifecycle.Interval = 60
+	}
+	for i := range c.TargetLifecycle.Policies {
+		p := &c.TargetLifecycle.Policies[i]
+		if p.StaleMinutes <= 0 {
+			p.StaleMinutes = 10
+		}
+		if p.Action == "" {
+			p.Action = "archive"
+		}
+	}
+	if c.Audit.RetentionDays <= 0 {
+		c.Audit.RetentionDays = 180
+	}
+	if c.Audit.Sink.Timeout <= 0 {
+		c.Audit.Sink.Timeout = 5
+	}
+	if c.EventBus.RetryCount <= 0 {
+		c.EventBus.RetryCount = 3
+	}
+	if c.EventBus.RetryInterval <= 0 {
+		c.EventBus.RetryInterval = 1
+	}
+	for i := range c.EventBus.Webhooks {
+		if c.EventBus.Webhooks[i].Timeout <= 0 {
+			c.EventBus.Webhooks[i].Timeout = 5
+		}
+	}
+	for i := range c.EventBus.Kafkas {
+		if c.EventBus.Kafkas[i].Timeout <= 0 {
+			c.EventBus.Kafkas[i].Timeout = 5
+		}
+	}
+	if len(c.SCIM.DefaultRoles) == 0 {
+		c.SCIM.DefaultRoles = []string{"Standard"}
+	}
+	if c.TOTP.Issuer == "" {
+		c.TOTP.Issuer = "Nightingale"
+	}
+	if c.PasswordPolicy.MinClasses > 4 {
+		c.PasswordPolicy.MinClasses = 4
+	}
 	if c.AgentsDir == "" {
 		// 默认使用项目根路径下的 agents/categraf 目录（与 integrations 同级）
 		c.AgentsDir = "agents/categraf"
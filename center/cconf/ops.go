@@ -96,6 +96,14 @@ ops:
       cname: Host - Delete
     - name: /targets/bind
       cname: Host - Bind Uncategorized
+    - name: /cmdb-syncs
+      cname: CMDB Sync - View
+    - name: /cmdb-syncs/add
+      cname: CMDB Sync - Add
+    - name: /cmdb-syncs/put
+      cname: CMDB Sync - Modify and Run
+    - name: /cmdb-syncs/del
+      cname: CMDB Sync - Delete
 
 - name: Explorer
   cname: Explorer
@@ -120,6 +128,22 @@ ops:
       cname: Recording Rule - Modify
     - name: /recording-rules/del
       cname: Recording Rule - Delete
+    - name: /scrape-jobs
+      cname: Scrape Job - View
+    - name: /scrape-jobs/add
+      cname: Scrape Job - Add
+    - name: /scrape-jobs/put
+      cname: Scrape Job - Modify
+    - name: /scrape-jobs/del
+      cname: Scrape Job - Delete
+    - name: /ingest-tokens
+      cname: Ingest Token - View
+    - name: /ingest-tokens/add
+      cname: Ingest Token - Add
+    - name: /ingest-tokens/put
+      cname: Ingest Token - Modify
+    - name: /ingest-tokens/del
+      cname: Ingest Token - Delete
     - name: /log/explorer
       cname: Logs Explorer
     - name: /log/index-patterns # 前端有个管理索引模式的页面，所以需要一个权限点来控制，后面应该改成侧拉板
@@ -300,6 +324,8 @@ ops:
       cname: View Alerting Engines
     - name: /system/version
       cname: View Product Version
+    - name: /audit-logs
+      cname: View Audit Logs
     - name: /ai-config/llm-configs
       cname: AI Config - LLM Configs
     - name: /ai-config/skills
@@ -14,10 +14,14 @@ import (
 	"github.com/ccfos/nightingale/v6/alert"
 	"github.com/ccfos/nightingale/v6/alert/astats"
 	"github.com/ccfos/nightingale/v6/alert/dispatch"
+	"github.com/ccfos/nightingale/v6/alert/naming"
 	"github.com/ccfos/nightingale/v6/alert/process"
 	alertrt "github.com/ccfos/nightingale/v6/alert/router"
+	"github.com/ccfos/nightingale/v6/center/audit"
 	"github.com/ccfos/nightingale/v6/center/cconf"
 	"github.com/ccfos/nightingale/v6/center/cconf/rsa"
+	"github.com/ccfos/nightingale/v6/center/cmdb"
+	"github.com/ccfos/nightingale/v6/center/eventbus"
 	"github.com/ccfos/nightingale/v6/center/integration"
 	"github.com/ccfos/nightingale/v6/center/metas"
 	centerrt "github.com/ccfos/nightingale/v6/center/router"
@@ -44,6 +48,7 @@ import (
 	"github.com/ccfos/nightingale/v6/tsdb"
 	tsdbrt "github.com/ccfos/nightingale/v6/tsdb/router"
 	"github.com/flashcatcloud/ibex/src/cmd/ibex"
+	"gorm.io/gorm"
 )
 
 func Initialize(configDir string, cryptoKey string) (func(), error) {
@@ -152,6 +157,8 @@ func Initialize(configDir string, cryptoKey string) (func(), error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to open embedded tsdb: %v", err)
 		}
+		go tsdbInstance.SnapshotLoop()
+		go tsdbInstance.DownsampleLoop()
 
 		// 样本经 pushgw 转发链路走 remote write 写入本实例的
 		// /prometheus/api/v1/write（writer 配置在 conf.InitConfig 注入），
@@ -189,6 +196,20 @@ func Initialize(configDir string, cryptoKey string) (func(), error) {
 	go cron.CleanNotifyRecord(ctx, config.Center.CleanNotifyRecordDay)
 	go cron.CleanPipelineExecution(ctx, config.Center.CleanPipelineExecutionDay)
 	go cron.CleanAlertHisEvent(ctx, config.Center.CleanAlertHisEventDay)
+	if config.Center.Audit.Enable {
+		if config.Center.Audit.HashKey == "" {
+			logger.Warning("audit: HashKey is blank, audit logs are not tamper-evident against database writers")
+		}
+		models.SetAuditLogKey(config.Center.Audit.HashKey)
+		go cron.CleanAuditLog(ctx, config.Center.Audit.RetentionDays)
+		if err := audit.Init(config.Center.Audit.Sink); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := eventbus.Init(config.Center.EventBus); err != nil {
+		return nil, err
+	}
 
 	alertrtRouter := alertrt.New(config.HTTP, config.Alert, alertMuteCache, targetCache, busiGroupCache, alertStats, ctx, externalProcessors, config.Log.Dir)
 	centerRouter := centerrt.New(config.HTTP, config.Center, config.Alert, config.Ibex,
@@ -197,7 +218,15 @@ func Initialize(configDir string, cryptoKey string) (func(), error) {
 	// categrafMeta 反查「机器指标写进了哪个数据源」要读 writer 地址；单独赋值而非
 	// 加进 New 的参数表，避免动到嵌入方（企业版）调用的函数签名。
 	centerRouter.Pushgw = config.Pushgw
+	go cron.TargetLifecycle(ctx, redis, config.Center.TargetLifecycle, naming.NewLeaderChecker(ctx, config.Alert.Heartbeat),
+		func(tx *gorm.DB, idents []string, force bool) error {
+			return centerRouter.TargetDeleteHook(tx, idents, force)
+		})
+	go cmdb.LoopSync(ctx, naming.NewLeaderChecker(ctx, config.Alert.Heartbeat))
+	go eventbus.LoopMuteExpiry(ctx, naming.NewLeaderChecker(ctx, config.Alert.Heartbeat))
 	pushgwRouter := pushgwrt.New(config.HTTP, config.Pushgw, config.Alert, targetCache, busiGroupCache, idents, metas, writers, ctx)
+	centerRouter.Cardinality = pushgwRouter.Cardinality
+	pushgwRouter.IngestTokenCache = memsto.NewIngestTokenCache(ctx, syncStats)
 
 	r := httpx.GinEngine(config.Global.RunMode, config.HTTP, configCvalCache.PrintBodyPaths, configCvalCache.PrintAccessLog)
 
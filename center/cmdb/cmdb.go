@@ -0,0 +1,529 @@
+package cmdb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/naming"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/tidwall/gjson"
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	maxBodySize  = 32 * 1024 * 1024
+	maxErrors    = 100
+	syncInterval = time.Minute
+)
+
+// 同一个同步任务同时只允许一个实际执行（演练不受限制）
+var running sync.Map
+
+// FetchRecords 请求 CMDB 的 HTTP 接口，按 ItemsPath 取出记录数组
+func FetchRecords(s *models.CmdbSync) ([]gjson.Result, error) {
+	req, err := http.NewRequest(http.MethodGet, s.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: time.Duration(s.Timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > 256 {
+			body = body[:256]
+		}
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	if len(body) > maxBodySize {
+		return nil, fmt.Errorf("response body exceeds %d bytes", maxBodySize)
+	}
+
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("response body is not valid json")
+	}
+
+	items := gjson.ParseBytes(body)
+	if s.ItemsPath != "" {
+		items = getField(items, s.ItemsPath)
+	}
+
+	if !items.IsArray() {
+		return nil, fmt.Errorf("items_path %q is not an array", s.ItemsPath)
+	}
+
+	return items.Array(), nil
+}
+
+// ParseCSV 第一行为表头，每一行转成以列名为 key 的 json 对象，字段映射直接填列名
+func ParseCSV(r io.Reader) ([]gjson.Result, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("csv is empty")
+	}
+
+	header := rows[0]
+	records := make([]gjson.Result, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		m := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(row) {
+				m[strings.TrimSpace(col)] = row[i]
+			}
+		}
+
+		bs, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, gjson.ParseBytes(bs))
+	}
+
+	return records, nil
+}
+
+// getField 按字段路径取值，以 $ 开头的按 JSONPath 处理，其余按 gjson 语法
+func getField(record gjson.Result, path string) gjson.Result {
+	return record.Get(gjsonPath(path))
+}
+
+// gjsonPath 把 JSONPath 转成 gjson 路径，支持 $.a.b、$['a.b']、[0] 和 [*]，不支持过滤表达式和递归查找
+func gjsonPath(path string) string {
+	if !strings.HasPrefix(path, "$") {
+		return path
+	}
+
+	var parts []string
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			parts = append(parts, escapeKey(rest[:end]))
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return escapeKey(path)
+			}
+			key := strings.TrimSpace(rest[1:end])
+			switch {
+			case key == "*":
+				parts = append(parts, "#")
+			case len(key) >= 2 && (key[0] == '\'' || key[0] == '"') && key[len(key)-1] == key[0]:
+				parts = append(parts, escapeKey(key[1:len(key)-1]))
+			default:
+				parts = append(parts, key)
+			}
+			rest = rest[end+1:]
+		default:
+			return escapeKey(path)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func escapeKey(key string) string {
+	var b strings.Builder
+	for _, r := range key {
+		switch r {
+		case '.', '*', '?', '|', '#', '@', '!', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Plan 对比 CMDB 记录与机器现状，生成变更列表，不修改任何数据。applied 为同步任务之前写到机器上的值，key: ident
+func Plan(s *models.CmdbSync, records []gjson.Result, targets []*models.Target, groups []*models.BusiGroup,
+	applied map[string]*models.CmdbSyncApplied) *models.CmdbSyncReport {
+	report := &models.CmdbSyncReport{Records: len(records)}
+
+	targetIndex := make(map[string][]*models.Target, len(targets))
+	for _, t := range targets {
+		key := t.Ident
+		if s.MatchBy == models.CmdbMatchByHostIp {
+			key = t.HostIp
+		}
+		if key != "" {
+			targetIndex[key] = append(targetIndex[key], t)
+		}
+	}
+
+	groupIndex := make(map[string]int64, len(groups))
+	for _, bg := range groups {
+		switch s.GroupMatchBy {
+		case models.CmdbGroupMatchById:
+			groupIndex[strconv.FormatInt(bg.Id, 10)] = bg.Id
+		case models.CmdbGroupMatchByLabelValue:
+			if bg.LabelValue != "" {
+				groupIndex[bg.LabelValue] = bg.Id
+			}
+		default:
+			groupIndex[bg.Name] = bg.Id
+		}
+	}
+
+	seen := make(map[string]struct{})
+	for i, record := range records {
+		key := strings.TrimSpace(getField(record, s.IdentField).String())
+		if key == "" {
+			addError(report, "record %d: %s is empty", i, s.IdentField)
+			continue
+		}
+
+		matched := targetIndex[key]
+		if len(matched) == 0 {
+			report.Unmatched = append(report.Unmatched, key)
+			continue
+		}
+		report.Matched++
+
+		for _, t := range matched {
+			if _, has := seen[t.Ident]; has {
+				addError(report, "record %d: target %s is matched by more than one record", i, t.Ident)
+				continue
+			}
+			seen[t.Ident] = struct{}{}
+
+			change, next := planTarget(s, t, record, groupIndex, applied[t.Ident], report)
+			if !next.Same(applied[t.Ident]) {
+				report.Applied = append(report.Applied, next)
+			}
+			if change.Changed() || len(change.Conflicts) > 0 {
+				report.Changes = append(report.Changes, change)
+			}
+			if change.Changed() {
+				report.Changed++
+			}
+			report.Conflicts += len(change.Conflicts)
+		}
+	}
+
+	return report
+}
+
+// planTarget 生成单台机器的变更以及同步后 CMDB 写在机器上的值。manual_wins 策略下，
+// 机器上的值与 prev 中 CMDB 上次写入的值相同时仍以 CMDB 为准，不同才算冲突
+func planTarget(s *models.CmdbSync, t *models.Target, record gjson.Result, groupIndex map[string]int64,
+	prev *models.CmdbSyncApplied, report *models.CmdbSyncReport) (*models.CmdbTargetChange, *models.CmdbSyncApplied) {
+	cmdbWins := s.ConflictPolicy == models.CmdbConflictCmdbWins
+	if prev == nil {
+		prev = &models.CmdbSyncApplied{}
+	}
+	next := &models.CmdbSyncApplied{Ident: t.Ident, Tags: make(map[string]string)}
+
+	change := &models.CmdbTargetChange{
+		Ident:       t.Ident,
+		OldTags:     sortedTags(strings.Fields(t.Tags)),
+		OldNote:     t.Note,
+		NewNote:     t.Note,
+		OldGroupIds: sortedIds(t.GroupIds),
+		NewGroupIds: sortedIds(t.GroupIds),
+	}
+
+	// 标签：只处理映射了的标签名，其余标签原样保留
+	tags := make(map[string]string)
+	var others []string
+	for _, tag := range change.OldTags {
+		if arr := strings.SplitN(tag, "=", 2); len(arr) == 2 {
+			if _, mapped := s.TagFields[arr[0]]; mapped {
+				tags[arr[0]] = arr[1]
+				continue
+			}
+		}
+		others = append(others, tag)
+	}
+
+	keys := make([]string, 0, len(s.TagFields))
+	for key := range s.TagFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := tagValue(getField(record, s.TagFields[key]).String())
+		old, has := tags[key]
+		prevValue, prevHas := prev.Tags[key]
+		bySync := has && prevHas && prevValue == old
+		switch {
+		case value == "":
+			// CMDB 中该字段为空：以 CMDB 为准或标签是 CMDB 写入的时删除标签，否则保留
+			if has && (cmdbWins || bySync) {
+				delete(tags, key)
+			}
+		case !has, old == value, cmdbWins, bySync:
+			tags[key] = value
+			next.Tags[key] = value
+		default:
+			change.Conflicts = append(change.Conflicts, "tag:"+key)
+		}
+	}
+
+	newTags := others
+	for key, value := range tags {
+		newTags = append(newTags, key+"="+value)
+	}
+	change.NewTags = sortedTags(newTags)
+
+	if s.NoteField != "" {
+		note := strings.TrimSpace(getField(record, s.NoteField).String())
+		switch {
+		case note == "":
+		case note == t.Note, t.Note == "", cmdbWins, prev.Note == t.Note:
+			change.NewNote = note
+			next.Note = note
+		default:
+			change.Conflicts = append(change.Conflicts, "note")
+		}
+	}
+
+	if s.GroupField != "" {
+		var bgids []int64
+		for _, name := range groupValues(getField(record, s.GroupField)) {
+			bgid, has := groupIndex[name]
+			if !has {
+				addError(report, "target %s: busi group %s not found", t.Ident, name)
+				continue
+			}
+			bgids = append(bgids, bgid)
+		}
+		bgids = sortedIds(bgids)
+
+		// CMDB 没有给出业务组时不做调整，避免接口异常时把机器从业务组里全部移除
+		switch {
+		case len(bgids) == 0:
+		case len(change.OldGroupIds) == 0, cmdbWins, sameIds(bgids, change.OldGroupIds),
+			len(prev.GroupIds) > 0 && sameIds(sortedIds(prev.GroupIds), change.OldGroupIds):
+			change.NewGroupIds = bgids
+			next.GroupIds = bgids
+		default:
+			change.Conflicts = append(change.Conflicts, "busi_group")
+		}
+	}
+
+	return change, next
+}
+
+// tagValue 标签以空格分隔、以等号切分 key value，值里的这两类字符替换成下划线
+func tagValue(s string) string {
+	s = strings.TrimSpace(s)
+	return strings.Map(func(r rune) rune {
+		if r == '=' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// groupValues 业务组字段可以是数组，也可以是逗号分隔的字符串
+func groupValues(res gjson.Result) []string {
+	var raw []string
+	if res.IsArray() {
+		for _, item := range res.Array() {
+			raw = append(raw, item.String())
+		}
+	} else {
+		raw = strings.Split(res.String(), ",")
+	}
+
+	var lst []string
+	for _, v := range raw {
+		if v = strings.TrimSpace(v); v != "" {
+			lst = append(lst, v)
+		}
+	}
+	return lst
+}
+
+func sortedTags(tags []string) []string {
+	lst := make([]string, 0, len(tags))
+	lst = append(lst, tags...)
+	sort.Strings(lst)
+	return lst
+}
+
+func sortedIds(ids []int64) []int64 {
+	m := make(map[int64]struct{}, len(ids))
+	lst := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if _, has := m[id]; !has {
+			m[id] = struct{}{}
+			lst = append(lst, id)
+		}
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i] < lst[j] })
+	return lst
+}
+
+func sameIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func addError(report *models.CmdbSyncReport, format string, args ...interface{}) {
+	if len(report.Errors) < maxErrors {
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// Run 执行一次同步。dryRun 时只返回变更，不修改机器也不记录报告
+func Run(ctx *ctx.Context, s *models.CmdbSync, records []gjson.Result, dryRun bool) (*models.CmdbSyncReport, error) {
+	if !dryRun {
+		if _, loaded := running.LoadOrStore(s.Id, struct{}{}); loaded {
+			return nil, fmt.Errorf("cmdb sync %s is running", s.Name)
+		}
+		defer running.Delete(s.Id)
+	}
+
+	start := time.Now()
+
+	targets, err := models.TargetGetsAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := models.BusiGroupGetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := models.CmdbSyncAppliedGets(ctx, s.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	report := Plan(s, records, targets, groups, applied)
+	report.DryRun = dryRun
+	report.StartAt = start.Unix()
+
+	if !dryRun {
+		var history []*models.TargetHistory
+		failed := make(map[string]struct{})
+		for _, change := range report.Changes {
+			if !change.Changed() {
+				continue
+			}
+
+			if err := change.Apply(ctx); err != nil {
+				addError(report, "target %s: failed to apply: %v", change.Ident, err)
+				failed[change.Ident] = struct{}{}
+				continue
+			}
+			history = append(history, change.History(models.TargetOperatorCmdb)...)
+		}
+
+		if err := models.TargetHistoryAdd(ctx, history); err != nil {
+			logger.Errorf("cmdb sync %s: failed to add target history: %v", s.Name, err)
+		}
+
+		// 写入失败的机器保留之前的记录
+		lst := make([]*models.CmdbSyncApplied, 0, len(report.Applied))
+		for _, a := range report.Applied {
+			if _, has := failed[a.Ident]; !has {
+				lst = append(lst, a)
+			}
+		}
+		if err := models.CmdbSyncAppliedSave(ctx, s.Id, lst); err != nil {
+			logger.Errorf("cmdb sync %s: failed to save applied values: %v", s.Name, err)
+		}
+	}
+
+	report.Duration = time.Since(start).Milliseconds()
+
+	if !dryRun {
+		if err := s.SaveReport(ctx, report); err != nil {
+			logger.Errorf("cmdb sync %s: failed to save report: %v", s.Name, err)
+		}
+	}
+
+	return report, nil
+}
+
+// RunHTTP 从 HTTP 接口拉取记录后执行同步，拉取失败也记录到报告中
+func RunHTTP(ctx *ctx.Context, s *models.CmdbSync, dryRun bool) (*models.CmdbSyncReport, error) {
+	records, err := FetchRecords(s)
+	if err != nil {
+		if !dryRun {
+			report := &models.CmdbSyncReport{StartAt: time.Now().Unix(), Errors: []string{err.Error()}}
+			if e := s.SaveReport(ctx, report); e != nil {
+				logger.Errorf("cmdb sync %s: failed to save report: %v", s.Name, e)
+			}
+		}
+		return nil, fmt.Errorf("failed to fetch records: %v", err)
+	}
+
+	return Run(ctx, s, records, dryRun)
+}
+
+// LoopSync 定时执行到期的 HTTP 同步任务，多实例部署时只在 leader 上执行
+func LoopSync(ctx *ctx.Context, leader *naming.Naming) {
+	for {
+		time.Sleep(syncInterval)
+
+		if !leader.IamLeader() {
+			continue
+		}
+
+		lst, err := models.CmdbSyncDueGets(ctx, time.Now().Unix())
+		if err != nil {
+			logger.Errorf("cmdb sync: failed to get sync jobs: %v", err)
+			continue
+		}
+
+		for _, s := range lst {
+			report, err := RunHTTP(ctx, s, false)
+			if err != nil {
+				logger.Errorf("cmdb sync %s: %v", s.Name, err)
+				continue
+			}
+
+			logger.Infof("cmdb sync %s: records: %d, matched: %d, changed: %d, conflicts: %d, errors: %d",
+				s.Name, report.Records, report.Matched, report.Changed, report.Conflicts, len(report.Errors))
+		}
+	}
+}
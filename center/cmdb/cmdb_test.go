@@ -0,0 +1,203 @@
+package cmdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestCtx(t *testing.T) *ctx.Context {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Target{}, &models.TargetBusiGroup{}, &models.TargetHistory{},
+		&models.BusiGroup{}, &models.CmdbSync{}, &models.CmdbSyncApplied{}))
+
+	c := &ctx.Context{DB: db, IsCenter: true}
+	require.NoError(t, db.Create(&models.BusiGroup{Id: 1, Name: "ops"}).Error)
+	require.NoError(t, db.Create(&models.BusiGroup{Id: 2, Name: "db"}).Error)
+	require.NoError(t, db.Create(&models.Target{Ident: "host-1", HostIp: "10.0.0.1", Tags: "env=test owner=alice "}).Error)
+	require.NoError(t, db.Create(&models.Target{Ident: "host-2", HostIp: "10.0.0.2", Note: "manual"}).Error)
+	require.NoError(t, db.Create(&models.TargetBusiGroup{TargetIdent: "host-1", GroupId: 1}).Error)
+	return c
+}
+
+func newTestSync(policy string) *models.CmdbSync {
+	s := &models.CmdbSync{
+		Name:           "cmdb",
+		SourceType:     models.CmdbSourceCSV,
+		MatchBy:        models.CmdbMatchByHostIp,
+		IdentField:     "ip",
+		NoteField:      "desc",
+		TagFields:      map[string]string{"env": "env", "idc": "idc"},
+		GroupField:     "group",
+		ConflictPolicy: policy,
+	}
+	return s
+}
+
+const testCSV = `ip,env,idc,desc,group
+10.0.0.1,prod,bj,web server,db
+10.0.0.2,prod,sh,mysql,"ops,db"
+10.0.0.9,prod,bj,unknown,ops
+`
+
+func loadTarget(t *testing.T, c *ctx.Context, ident string) (*models.Target, []int64) {
+	target, err := models.TargetGetByIdent(c, ident)
+	require.NoError(t, err)
+	bgids, err := models.TargetGroupIdsGetByIdent(c, ident)
+	require.NoError(t, err)
+	return target, bgids
+}
+
+func TestRunManualWins(t *testing.T) {
+	c := newTestCtx(t)
+	s := newTestSync(models.CmdbConflictManualWins)
+	require.NoError(t, s.Add(c))
+
+	records, err := ParseCSV(strings.NewReader(testCSV))
+	require.NoError(t, err)
+
+	// 演练不修改数据
+	report, err := Run(c, s, records, true)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Records)
+	assert.Equal(t, 2, report.Matched)
+	assert.Equal(t, []string{"10.0.0.9"}, report.Unmatched)
+	assert.Equal(t, 2, report.Changed)
+	target, _ := loadTarget(t, c, "host-1")
+	assert.Equal(t, "env=test owner=alice ", target.Tags)
+
+	report, err = Run(c, s, records, false)
+	require.NoError(t, err)
+	// host-1: env 和业务组保留人工值；host-2: 备注保留人工值
+	assert.Equal(t, 3, report.Conflicts)
+
+	target, bgids := loadTarget(t, c, "host-1")
+	assert.Equal(t, "env=test idc=bj owner=alice ", target.Tags)
+	assert.Equal(t, "web server", target.Note)
+	assert.Equal(t, []int64{1}, bgids)
+
+	target, bgids = loadTarget(t, c, "host-2")
+	assert.Equal(t, "env=prod idc=sh ", target.Tags)
+	assert.Equal(t, "manual", target.Note)
+	assert.ElementsMatch(t, []int64{1, 2}, bgids)
+
+	var history []*models.TargetHistory
+	require.NoError(t, c.DB.Where("operator = ?", models.TargetOperatorCmdb).Find(&history).Error)
+	assert.Len(t, history, 4) // host-1: tags note; host-2: tags busi_group
+
+	saved, err := models.CmdbSyncGetById(c, s.Id)
+	require.NoError(t, err)
+	require.NotNil(t, saved.LastReport)
+	assert.Equal(t, 2, saved.LastReport.Changed)
+	assert.NotZero(t, saved.LastSyncAt)
+
+	// 再次同步没有新的变更
+	report, err = Run(c, s, records, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Changed)
+
+	// CMDB 自己写入的值变化后直接更新，人工维护的值仍然算冲突
+	records, err = ParseCSV(strings.NewReader(`ip,env,idc,desc,group
+10.0.0.1,prod,gz,api server,db
+10.0.0.2,prod,sh,mysql,ops
+`))
+	require.NoError(t, err)
+	report, err = Run(c, s, records, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Changed)
+	assert.Equal(t, 3, report.Conflicts)
+
+	target, _ = loadTarget(t, c, "host-1")
+	assert.Equal(t, "env=test idc=gz owner=alice ", target.Tags)
+	assert.Equal(t, "api server", target.Note)
+	_, bgids = loadTarget(t, c, "host-2")
+	assert.Equal(t, []int64{1}, bgids)
+
+	// 人工改过 CMDB 写入的值之后不再覆盖
+	require.NoError(t, c.DB.Model(&models.Target{}).Where("ident = ?", "host-1").Update("tags", "env=test idc=manual owner=alice ").Error)
+	records, err = ParseCSV(strings.NewReader("ip,env,idc,desc,group\n10.0.0.1,prod,sz,api server,db\n"))
+	require.NoError(t, err)
+	report, err = Run(c, s, records, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Changed)
+	require.Len(t, report.Changes, 1)
+	assert.Equal(t, []string{"tag:env", "tag:idc", "busi_group"}, report.Changes[0].Conflicts)
+}
+
+func TestGjsonPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"data.hosts":             "data.hosts",
+		"$.data.hosts":           "data.hosts",
+		"$.data.hosts[0].ip":     "data.hosts.0.ip",
+		"$.data.hosts[*].ip":     "data.hosts.#.ip",
+		"$['data']['host.name']": `data.host\.name`,
+		`$.attrs["idc"]`:         "attrs.idc",
+	} {
+		assert.Equal(t, want, gjsonPath(path), path)
+	}
+}
+
+func TestRunCmdbWins(t *testing.T) {
+	c := newTestCtx(t)
+	s := newTestSync(models.CmdbConflictCmdbWins)
+	require.NoError(t, s.Add(c))
+
+	records, err := ParseCSV(strings.NewReader(testCSV + "10.0.0.1,,,,ghost\n"))
+	require.NoError(t, err)
+
+	report, err := Run(c, s, records, false)
+	require.NoError(t, err)
+	assert.Zero(t, report.Conflicts)
+	assert.Contains(t, report.Errors, "record 3: target host-1 is matched by more than one record")
+
+	target, bgids := loadTarget(t, c, "host-1")
+	assert.Equal(t, "env=prod idc=bj owner=alice ", target.Tags)
+	assert.Equal(t, []int64{2}, bgids)
+
+	target, _ = loadTarget(t, c, "host-2")
+	assert.Equal(t, "mysql", target.Note)
+}
+
+func TestFetchRecords(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"hosts":[{"hostname":"host-1","attrs":{"env":"prod"}},{"hostname":"host-2"}]}}`))
+	}))
+	defer srv.Close()
+
+	s := &models.CmdbSync{SourceType: models.CmdbSourceHTTP, Url: srv.URL, ItemsPath: "data.hosts", Timeout: 5}
+	_, err := FetchRecords(s)
+	assert.Error(t, err)
+
+	s.Headers = map[string]string{"X-Token": "secret"}
+	records, err := FetchRecords(s)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "prod", records[0].Get("attrs.env").String())
+	assert.Equal(t, "prod", getField(records[0], "$.attrs.env").String())
+
+	s.ItemsPath = "$.data.hosts"
+	records, err = FetchRecords(s)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	s.ItemsPath = "data"
+	_, err = FetchRecords(s)
+	assert.Error(t, err)
+}
@@ -0,0 +1,201 @@
+package eventbus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+
+	"github.com/google/uuid"
+	"github.com/toolkits/pkg/logger"
+)
+
+const queueSize = 1024
+
+const (
+	ObjectAlertRule  = "alert_rule"
+	ObjectMute       = "mute"
+	ObjectTarget     = "target"
+	ObjectDatasource = "datasource"
+
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+	ActionExpired = "expired"
+	ActionJoined  = "joined"
+	ActionLeft    = "left"
+)
+
+const SignatureHeader = "X-N9e-Signature"
+
+// Event 对象变更事件，Type 形如 alert_rule.created
+type Event struct {
+	Id         string      `json:"id"`
+	Type       string      `json:"type"`
+	ObjectType string      `json:"object_type"`
+	Action     string      `json:"action"`
+	ObjectId   string      `json:"object_id"`
+	GroupId    int64       `json:"group_id"`
+	Operator   string      `json:"operator"`
+	Timestamp  int64       `json:"timestamp"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+func NewEvent(objectType, action string, objectId interface{}, groupId int64, operator string, data interface{}) *Event {
+	return &Event{
+		Id:         uuid.NewString(),
+		Type:       objectType + "." + action,
+		ObjectType: objectType,
+		Action:     action,
+		ObjectId:   fmt.Sprint(objectId),
+		GroupId:    groupId,
+		Operator:   operator,
+		Timestamp:  time.Now().Unix(),
+		Data:       data,
+	}
+}
+
+type sink interface {
+	send(e *Event, body []byte, signature string) error
+}
+
+type subscription struct {
+	name        string
+	objectTypes map[string]struct{}
+	groupIds    map[int64]struct{}
+	secret      string
+	sink        sink
+	queue       chan *Event
+}
+
+func newSubscription(name string, f cconf.EventBusFilter, secret string, s sink) *subscription {
+	sub := &subscription{name: name, secret: secret, sink: s, queue: make(chan *Event, queueSize)}
+	if len(f.ObjectTypes) > 0 {
+		sub.objectTypes = make(map[string]struct{}, len(f.ObjectTypes))
+		for _, t := range f.ObjectTypes {
+			sub.objectTypes[t] = struct{}{}
+		}
+	}
+	if len(f.BusiGroupIds) > 0 {
+		sub.groupIds = make(map[int64]struct{}, len(f.BusiGroupIds))
+		for _, id := range f.BusiGroupIds {
+			sub.groupIds[id] = struct{}{}
+		}
+	}
+	return sub
+}
+
+func (s *subscription) match(e *Event) bool {
+	if s.objectTypes != nil {
+		if _, has := s.objectTypes[e.ObjectType]; !has {
+			return false
+		}
+	}
+	if s.groupIds != nil {
+		if _, has := s.groupIds[e.GroupId]; !has {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *subscription) run(retryCount int, retryInterval time.Duration) {
+	for e := range s.queue {
+		body, err := json.Marshal(e)
+		if err != nil {
+			logger.Warningf("eventbus: failed to marshal event %s: %v", e.Id, err)
+			continue
+		}
+
+		signature := ""
+		if s.secret != "" {
+			signature = Sign(s.secret, body)
+		}
+
+		interval := retryInterval
+		for i := 0; ; i++ {
+			err = s.sink.send(e, body, signature)
+			if err == nil || i >= retryCount {
+				break
+			}
+			time.Sleep(interval)
+			interval *= 2
+		}
+
+		if err != nil {
+			logger.Warningf("eventbus: failed to deliver event %s %s to %s: %v", e.Type, e.Id, s.name, err)
+		}
+	}
+}
+
+var subs []*subscription
+
+// Init 按配置建立订阅，未配置任何订阅时 Publish 不做任何事
+func Init(conf cconf.EventBus) error {
+	var lst []*subscription
+
+	for _, w := range conf.Webhooks {
+		if w.Url == "" {
+			return fmt.Errorf("eventbus webhook url is blank")
+		}
+		s := &webhookSink{url: w.Url, headers: w.Headers, timeout: time.Duration(w.Timeout) * time.Second}
+		lst = append(lst, newSubscription(w.Url, w.EventBusFilter, w.Secret, s))
+	}
+
+	for _, k := range conf.Kafkas {
+		s, err := newKafkaSink(k)
+		if err != nil {
+			return fmt.Errorf("failed to init eventbus kafka %v: %v", k.Brokers, err)
+		}
+		lst = append(lst, newSubscription(fmt.Sprintf("%v_%s", k.Brokers, k.Topic), k.EventBusFilter, k.Secret, s))
+	}
+
+	retryInterval := time.Duration(conf.RetryInterval) * time.Second
+	for _, s := range lst {
+		go s.run(conf.RetryCount, retryInterval)
+	}
+
+	subs = lst
+
+	// 机器业务组和屏蔽规则有多个写入入口，统一在 models 里落库后回调发布
+	if len(lst) > 0 {
+		models.TargetHistoryAddedHook = publishTargetHistory
+		models.AlertMuteChangedHook = publishMuteChanges
+	} else {
+		models.TargetHistoryAddedHook = nil
+		models.AlertMuteChangedHook = nil
+	}
+	return nil
+}
+
+// Enabled 没有订阅时调用方可以省去组装事件的开销
+func Enabled() bool {
+	return len(subs) > 0
+}
+
+// Publish 异步投递到所有匹配的订阅，某个订阅的队列满时只丢弃该订阅的事件
+func Publish(e *Event) {
+	for _, s := range subs {
+		if !s.match(e) {
+			continue
+		}
+
+		select {
+		case s.queue <- e:
+		default:
+			logger.Warningf("eventbus: queue of %s is full, event %s %s is dropped", s.name, e.Type, e.Id)
+		}
+	}
+}
+
+// Sign 返回 sha256=hex(hmac_sha256(secret, body))，接收方用同样的方式计算后比对
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
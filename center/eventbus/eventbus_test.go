@@ -0,0 +1,106 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+)
+
+func TestSubscriptionMatch(t *testing.T) {
+	all := newSubscription("all", cconf.EventBusFilter{}, "", nil)
+	rules := newSubscription("rules", cconf.EventBusFilter{ObjectTypes: []string{ObjectAlertRule}, BusiGroupIds: []int64{1}}, "", nil)
+
+	for _, tc := range []struct {
+		sub  *subscription
+		e    *Event
+		want bool
+	}{
+		{all, NewEvent(ObjectDatasource, ActionDeleted, 1, 0, "root", nil), true},
+		{rules, NewEvent(ObjectAlertRule, ActionCreated, 1, 1, "root", nil), true},
+		{rules, NewEvent(ObjectAlertRule, ActionCreated, 2, 2, "root", nil), false},
+		{rules, NewEvent(ObjectMute, ActionCreated, 1, 1, "root", nil), false},
+		// 数据源不属于任何业务组
+		{rules, NewEvent(ObjectDatasource, ActionCreated, 1, 0, "root", nil), false},
+	} {
+		if got := tc.sub.match(tc.e); got != tc.want {
+			t.Fatalf("%s match %s of group %d: expected %v, got %v", tc.sub.name, tc.e.Type, tc.e.GroupId, tc.want, got)
+		}
+	}
+}
+
+func TestWebhookDeliveryRetry(t *testing.T) {
+	var calls int32
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		bs, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- bs
+	}))
+	defer server.Close()
+
+	s := newSubscription(server.URL, cconf.EventBusFilter{}, "secret",
+		&webhookSink{url: server.URL, headers: map[string]string{"Authorization": "Bearer x"}, timeout: time.Second})
+	go s.run(3, 10*time.Millisecond)
+	subs = []*subscription{s}
+	defer func() { subs = nil }()
+
+	Publish(NewEvent(ObjectAlertRule, ActionUpdated, 7, 1, "root", map[string]string{"name": "cpu"}))
+
+	select {
+	case r := <-got:
+		body := <-bodies
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Fatalf("bad signature %q", r.Header.Get(SignatureHeader))
+		}
+		if r.Header.Get("Authorization") != "Bearer x" || r.Header.Get("X-N9e-Event") != "alert_rule.updated" {
+			t.Fatalf("unexpected headers: %v", r.Header)
+		}
+
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ObjectId != "7" || e.Action != ActionUpdated || e.Operator != "root" {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event not delivered after %d calls", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestPublishTargetHistory(t *testing.T) {
+	s := newSubscription("targets", cconf.EventBusFilter{ObjectTypes: []string{ObjectTarget}}, "", nil)
+	subs = []*subscription{s}
+	defer func() { subs = nil }()
+
+	publishTargetHistory([]*models.TargetHistory{
+		models.NewTargetHistory("host-1", models.TargetHistoryFieldBusiGroup, "1,2", "2,3", models.TargetOperatorCmdb),
+		models.NewTargetHistory("host-1", models.TargetHistoryFieldNote, "", "db", "root"),
+		// 删除机器时新值为空
+		models.NewTargetHistory("host-2", models.TargetHistoryFieldBusiGroup, "4", "", "root"),
+	})
+
+	var got []string
+	for len(s.queue) > 0 {
+		e := <-s.queue
+		got = append(got, fmt.Sprintf("%s %s %d %s", e.ObjectId, e.Action, e.GroupId, e.Operator))
+	}
+	want := []string{"host-1 left 1 cmdb", "host-1 joined 3 cmdb", "host-2 left 4 root"}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,55 @@
+package eventbus
+
+import (
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/naming"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/toolkits/pkg/logger"
+)
+
+const muteExpiryInterval = 30 * time.Second
+
+// LoopMuteExpiry 屏蔽规则到期没有对应的接口调用，只能由 leader 定期扫描结束时间发出 mute.expired。
+// 成为 leader 之前到期的屏蔽规则不再补发
+func LoopMuteExpiry(ctx *ctx.Context, leader *naming.Naming) {
+	if !Enabled() {
+		return
+	}
+
+	last := time.Now().Unix()
+	for {
+		time.Sleep(muteExpiryInterval)
+
+		now := time.Now().Unix()
+		if !leader.IamLeader() {
+			last = now
+			continue
+		}
+
+		lst, err := models.AlertMuteGetsExpiredBetween(ctx, last, now)
+		if err != nil {
+			logger.Errorf("eventbus: failed to get expired mutes: %v", err)
+			continue
+		}
+		last = now
+
+		for i := range lst {
+			Publish(NewEvent(ObjectMute, ActionExpired, lst[i].Id, lst[i].GroupId, "system", lst[i]))
+		}
+	}
+}
+
+// publishMuteChanges 屏蔽规则新增、修改、删除的事件，由 models.AlertMuteChangedHook 回调
+func publishMuteChanges(action string, lst []models.AlertMute, operator string) {
+	if operator == "" {
+		// 通过服务接口创建的屏蔽规则没有操作人
+		operator = "service"
+	}
+
+	for i := range lst {
+		Publish(NewEvent(ObjectMute, action, lst[i].Id, lst[i].GroupId, operator, lst[i]))
+	}
+}
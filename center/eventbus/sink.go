@@ -0,0 +1,101 @@
+package eventbus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/pkg/poster"
+	"github.com/ccfos/nightingale/v6/pushgw/kafka"
+
+	"github.com/IBM/sarama"
+	"github.com/toolkits/pkg/logger"
+)
+
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	timeout time.Duration
+}
+
+func (s *webhookSink) send(e *Event, body []byte, signature string) error {
+	headers := make(map[string]string, len(s.headers)+3)
+	for k, v := range s.headers {
+		headers[k] = v
+	}
+	headers["X-N9e-Event"] = e.Type
+	headers["X-N9e-Event-Id"] = e.Id
+	if signature != "" {
+		headers[SignatureHeader] = signature
+	}
+
+	// 重试由 subscription 按退避间隔处理，这里只发一次
+	_, code, err := poster.PostJSONBytes(s.url, s.timeout, body, headers)
+	if err != nil {
+		return err
+	}
+
+	if code < 200 || code >= 300 {
+		return fmt.Errorf("unexpected status code %d", code)
+	}
+	return nil
+}
+
+type kafkaSink struct {
+	topic    string
+	producer kafka.Producer
+}
+
+func newKafkaSink(opt cconf.EventBusKafka) (*kafkaSink, error) {
+	if len(opt.Brokers) == 0 || opt.Topic == "" {
+		return nil, fmt.Errorf("brokers and topic are required")
+	}
+
+	cfg := sarama.NewConfig()
+	if opt.SASL != nil && opt.SASL.Enable {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = opt.SASL.User
+		cfg.Net.SASL.Password = opt.SASL.Password
+		cfg.Net.SASL.Mechanism = sarama.SASLMechanism(opt.SASL.Mechanism)
+		cfg.Net.SASL.Version = opt.SASL.Version
+		cfg.Net.SASL.Handshake = opt.SASL.Handshake
+		cfg.Net.SASL.AuthIdentity = opt.SASL.AuthIdentity
+	}
+	cfg.Producer.Timeout = time.Duration(opt.Timeout) * time.Second
+	// 消息头需要 0.11 及以上版本
+	cfg.Version = sarama.V0_11_0_0
+	if opt.Version != "" {
+		kafkaVersion, err := sarama.ParseKafkaVersion(opt.Version)
+		if err != nil {
+			logger.Warningf("parse kafka version got error: %v", err)
+		} else {
+			cfg.Version = kafkaVersion
+		}
+	}
+
+	// 同步发送才能拿到投递结果，用于失败重试
+	producer, err := kafka.New(kafka.SyncProducer, opt.Brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaSink{topic: opt.Topic, producer: producer}, nil
+}
+
+func (s *kafkaSink) send(e *Event, body []byte, signature string) error {
+	headers := []sarama.RecordHeader{
+		{Key: []byte("X-N9e-Event"), Value: []byte(e.Type)},
+		{Key: []byte("X-N9e-Event-Id"), Value: []byte(e.Id)},
+	}
+	if signature != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(SignatureHeader), Value: []byte(signature)})
+	}
+
+	// 同一对象的事件落在同一分区，保证消费顺序
+	return s.producer.Send(&sarama.ProducerMessage{
+		Topic:   s.topic,
+		Key:     sarama.StringEncoder(e.ObjectType + "/" + e.ObjectId),
+		Value:   sarama.ByteEncoder(body),
+		Headers: headers,
+	})
+}
@@ -0,0 +1,43 @@
+package eventbus
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ccfos/nightingale/v6/models"
+
+	"github.com/toolkits/pkg/slice"
+)
+
+// publishTargetHistory 对比 busi_group 变更历史前后的业务组，加入发 target.joined，移出发 target.left。
+// 由 models.TargetHistoryAddedHook 回调，页面、心跳、CMDB 同步、生命周期清理的调整都会经过这里
+func publishTargetHistory(lst []*models.TargetHistory) {
+	for _, h := range lst {
+		if h.Field != models.TargetHistoryFieldBusiGroup {
+			continue
+		}
+
+		olds, news := splitGroupIds(h.OldValue), splitGroupIds(h.NewValue)
+		for _, bgid := range olds {
+			if !slice.ContainsInt64(news, bgid) {
+				Publish(NewEvent(ObjectTarget, ActionLeft, h.Ident, bgid, h.Operator, nil))
+			}
+		}
+		for _, bgid := range news {
+			if !slice.ContainsInt64(olds, bgid) {
+				Publish(NewEvent(ObjectTarget, ActionJoined, h.Ident, bgid, h.Operator, nil))
+			}
+		}
+	}
+}
+
+// splitGroupIds 解析变更历史里逗号分隔的业务组 id
+func splitGroupIds(value string) []int64 {
+	var ids []int64
+	for _, s := range strings.Split(value, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
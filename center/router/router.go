@@ -16,6 +16,7 @@ import (
 	"github.com/ccfos/nightingale/v6/aiagent/skill"
 	aitools "github.com/ccfos/nightingale/v6/aiagent/tools"
 	"github.com/ccfos/nightingale/v6/alert/aconf"
+	"github.com/ccfos/nightingale/v6/alert/pipeline/processor/script"
 	"github.com/ccfos/nightingale/v6/center/cconf"
 	"github.com/ccfos/nightingale/v6/center/cstats"
 	"github.com/ccfos/nightingale/v6/center/metas"
@@ -31,6 +32,7 @@ import (
 	"github.com/ccfos/nightingale/v6/pkg/sandbox"
 	"github.com/ccfos/nightingale/v6/pkg/version"
 	"github.com/ccfos/nightingale/v6/prom"
+	"github.com/ccfos/nightingale/v6/pushgw/cardinality"
 	"github.com/ccfos/nightingale/v6/pushgw/idents"
 	"github.com/ccfos/nightingale/v6/pushgw/pconf"
 	"github.com/ccfos/nightingale/v6/storage"
@@ -69,6 +71,10 @@ type Router struct {
 	// the user pick the datasource.
 	Pushgw pconf.Pushgw
 
+	// Cardinality is the in-process pushgw's series tracker, set after New() the
+	// same way as Pushgw; nil-safe (the report is simply empty).
+	Cardinality *cardinality.Tracker
+
 	// Sandbox is the Skill script-execution isolation controller (pkg/sandbox).
 	// Built once at New() from the configured capabilities; nil-safe (a disabled
 	// sandbox simply makes run_skill_script report "execution unavailable").
@@ -152,9 +158,14 @@ func New(httpConfig httpx.Config, center cconf.Center, alert aconf.Alert, ibex c
 	// models 包级权威值，供 DB 写入(ai_skill_file) 与归档解压(aiagent/skill) 共用。
 	models.MaxFilesPerSkill = rt.Center.AIAgent.MaxFilesPerSkill
 
+	// 用户被删除或停用（包括 SCIM、LDAP 同步）时踢掉其登录会话
+	models.UserRevokeHook = rt.revokeUsersSessions
+
 	// Skill 脚本执行的隔离 sandbox：启动期探测宿主能力、选定引擎（或在能力不足/
 	// 非 Linux 时禁用），全程只构建一次。run_skill_script 工具经 ToolDeps.Sandbox 用它。
 	rt.Sandbox = sandbox.New(rt.Center.Sandbox)
+	// 事件处理 pipeline 的 script 处理器复用同一个 sandbox 执行用户脚本
+	script.SetSandbox(rt.Sandbox)
 
 	// 内置 skill 的磁盘解压只在进程启动时做一次——之前是在每条 assistant
 	// 消息的 InitSkills 里 destructive re-extract，多 chat 并发时 Step 1 删目录
@@ -279,12 +290,14 @@ func (rt *Router) Config(r *gin.Engine) {
 
 	pagesPrefix := "/api/n9e"
 	pages := r.Group(pagesPrefix)
+	pages.Use(rt.auditLog(false))
 	{
 
 		pages.DELETE("/datasource/series", rt.auth(), rt.admin(), rt.deleteDatasourceSeries)
 		if rt.Center.AnonymousAccess.PromQuerier {
 			pages.Any("/proxy/:id/*url", rt.dsProxy)
 			pages.POST("/query-range-batch", rt.promBatchQueryRange)
+			pages.POST("/query-exemplars-batch", rt.promBatchQueryExemplars)
 			pages.POST("/query-instant-batch", rt.promBatchQueryInstant)
 			pages.GET("/datasource/brief", rt.datasourceBriefs)
 			pages.POST("/datasource/query", rt.datasourceQuery)
@@ -327,6 +340,7 @@ func (rt *Router) Config(r *gin.Engine) {
 			// 仪表盘限时分享：带有效 board 分享 token 的匿名请求可走以下查询接口，
 			// 数据源被收敛到板内引用集合（见 router_board_share.go），其余照常登录鉴权
 			pages.POST("/query-range-batch", rt.boardTokenDetect(), skipIfBoardToken(rt.auth()), rt.promBatchQueryRange)
+			pages.POST("/query-exemplars-batch", rt.boardTokenDetect(), skipIfBoardToken(rt.auth()), rt.promBatchQueryExemplars)
 			pages.POST("/query-instant-batch", rt.boardTokenDetect(), skipIfBoardToken(rt.auth()), rt.promBatchQueryInstant)
 			pages.GET("/datasource/brief", rt.boardTokenDetect(), skipIfBoardToken(rt.auth()), skipIfBoardToken(rt.user()), rt.datasourceBriefs)
 			pages.POST("/datasource/query", rt.auth(), rt.user(), rt.datasourceQuery)
@@ -383,11 +397,18 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.GET("/auth/redirect/oauth", rt.loginRedirectOAuth)
 		pages.GET("/auth/redirect/dingtalk", rt.loginRedirectDingTalk)
 		pages.GET("/auth/redirect/feishu", rt.loginRedirectFeiShu)
+		pages.GET("/auth/redirect/saml", rt.loginRedirectSaml)
 		pages.GET("/auth/callback", rt.loginCallback)
 		pages.GET("/auth/callback/cas", rt.loginCallbackCas)
 		pages.GET("/auth/callback/oauth", rt.loginCallbackOAuth)
 		pages.GET("/auth/callback/dingtalk", rt.loginCallbackDingTalk)
 		pages.GET("/auth/callback/feishu", rt.loginCallbackFeiShu)
+		pages.POST("/auth/callback/saml", rt.loginAcsSaml)
+		pages.GET("/auth/callback/saml", rt.loginCallbackSaml)
+		pages.GET("/auth/saml/metadata", rt.samlMetadata)
+		pages.POST("/auth/login/totp", rt.loginTotp)
+		pages.POST("/auth/login/totp/enroll", rt.loginTotpEnroll)
+		pages.POST("/auth/login/password", rt.loginPassword)
 		pages.GET("/auth/perms", rt.auth(), rt.user(), rt.allPerms)
 
 		// Built-in MCP OAuth Authorization Server — consent decision endpoint.
@@ -407,10 +428,19 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.GET("/self/perms", rt.auth(), rt.user(), rt.permsGets)
 		pages.GET("/self/profile", rt.auth(), rt.user(), rt.selfProfileGet)
 		pages.PUT("/self/profile", rt.auth(), rt.user(), rt.selfProfilePut)
-		pages.PUT("/self/password", rt.auth(), rt.user(), rt.selfPasswordPut)
-		pages.GET("/self/token", rt.auth(), rt.user(), rt.getToken)
-		pages.POST("/self/token", rt.auth(), rt.user(), rt.addToken)
-		pages.DELETE("/self/token/:id", rt.auth(), rt.user(), rt.deleteToken)
+		pages.PUT("/self/password", rt.auth(), rt.user(), rt.noUserToken(), rt.selfPasswordPut)
+		pages.GET("/self/token", rt.auth(), rt.user(), rt.noUserToken(), rt.getToken)
+		pages.POST("/self/token", rt.auth(), rt.user(), rt.noUserToken(), rt.addToken)
+		pages.PUT("/self/token/:id", rt.auth(), rt.user(), rt.noUserToken(), rt.putToken)
+		pages.DELETE("/self/token/:id", rt.auth(), rt.user(), rt.noUserToken(), rt.deleteToken)
+		pages.GET("/self/totp", rt.auth(), rt.user(), rt.noUserToken(), rt.selfTotpGet)
+		pages.POST("/self/totp/enroll", rt.auth(), rt.user(), rt.noUserToken(), rt.selfTotpEnroll)
+		pages.POST("/self/totp/activate", rt.auth(), rt.user(), rt.noUserToken(), rt.selfTotpActivate)
+		pages.POST("/self/totp/recovery-codes", rt.auth(), rt.user(), rt.noUserToken(), rt.selfTotpRecoveryCodes)
+		pages.DELETE("/self/totp", rt.auth(), rt.user(), rt.noUserToken(), rt.selfTotpDel)
+		pages.GET("/self/sessions", rt.auth(), rt.user(), rt.noUserToken(), rt.selfSessionGets)
+		pages.DELETE("/self/sessions", rt.auth(), rt.user(), rt.noUserToken(), rt.selfSessionsDel)
+		pages.DELETE("/self/session/:sid", rt.auth(), rt.user(), rt.noUserToken(), rt.selfSessionDel)
 
 		pages.GET("/users", rt.auth(), rt.user(), rt.perm("/users"), rt.userGets)
 		pages.POST("/users", rt.auth(), rt.user(), rt.perm("/users/add"), rt.userAddPost)
@@ -418,6 +448,17 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.PUT("/user/:id/profile", rt.auth(), rt.user(), rt.perm("/users/put"), rt.userProfilePut)
 		pages.PUT("/user/:id/password", rt.auth(), rt.user(), rt.perm("/users/put"), rt.userPasswordPut)
 		pages.DELETE("/user/:id", rt.auth(), rt.user(), rt.perm("/users/del"), rt.userDel)
+		pages.DELETE("/user/:id/totp", rt.auth(), rt.user(), rt.perm("/users/put"), rt.userTotpDel)
+		pages.GET("/user/:id/sessions", rt.auth(), rt.user(), rt.perm("/users"), rt.userSessionGets)
+		pages.DELETE("/user/:id/sessions", rt.auth(), rt.user(), rt.perm("/users/put"), rt.userSessionsDel)
+		pages.DELETE("/user/:id/session/:sid", rt.auth(), rt.user(), rt.perm("/users/put"), rt.userSessionDel)
+
+		pages.GET("/tenants", rt.auth(), rt.user(), rt.superAdmin(), rt.tenantGets)
+		pages.POST("/tenants", rt.auth(), rt.user(), rt.superAdmin(), rt.tenantAdd)
+		pages.GET("/tenant/:id", rt.auth(), rt.user(), rt.superAdmin(), rt.tenantGet)
+		pages.PUT("/tenant/:id", rt.auth(), rt.user(), rt.superAdmin(), rt.tenantPut)
+		pages.DELETE("/tenant/:id", rt.auth(), rt.user(), rt.superAdmin(), rt.tenantDel)
+		pages.PUT("/tenant/:id/resources", rt.auth(), rt.user(), rt.superAdmin(), rt.tenantAssign)
 
 		pages.GET("/metric-views", rt.auth(), rt.metricViewGets)
 		pages.DELETE("/metric-views", rt.auth(), rt.user(), rt.metricViewDel)
@@ -430,9 +471,9 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.PUT("/builtin-metric-filters", rt.auth(), rt.user(), rt.metricFilterPut)
 		pages.POST("/builtin-metric-promql", rt.auth(), rt.user(), rt.getMetricPromql)
 
-		pages.POST("/builtin-metrics", rt.auth(), rt.user(), rt.perm("/builtin-metrics/add"), rt.builtinMetricsAdd)
-		pages.PUT("/builtin-metrics", rt.auth(), rt.user(), rt.perm("/builtin-metrics/put"), rt.builtinMetricsPut)
-		pages.DELETE("/builtin-metrics", rt.auth(), rt.user(), rt.perm("/builtin-metrics/del"), rt.builtinMetricsDel)
+		pages.POST("/builtin-metrics", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/builtin-metrics/add"), rt.builtinMetricsAdd)
+		pages.PUT("/builtin-metrics", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/builtin-metrics/put"), rt.builtinMetricsPut)
+		pages.DELETE("/builtin-metrics", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/builtin-metrics/del"), rt.builtinMetricsDel)
 		pages.GET("/builtin-metrics", rt.auth(), rt.user(), rt.builtinMetricsGets)
 		pages.GET("/builtin-metrics/types", rt.auth(), rt.user(), rt.builtinMetricsTypes)
 		pages.GET("/builtin-metrics/types/default", rt.auth(), rt.user(), rt.builtinMetricsDefaultTypes)
@@ -461,6 +502,25 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.GET("/targets/stats", rt.auth(), rt.user(), rt.targetStats)
 		pages.POST("/target-update", rt.auth(), rt.targetUpdate)
 		pages.GET("/target/extra-meta", rt.auth(), rt.user(), rt.targetExtendInfoByIdent)
+		pages.GET("/target/history", rt.auth(), rt.user(), rt.targetHistoryGets)
+
+		pages.GET("/cmdb-syncs", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/cmdb-syncs"), rt.cmdbSyncGets)
+		pages.POST("/cmdb-syncs", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/cmdb-syncs/add"), rt.cmdbSyncAdd)
+		pages.DELETE("/cmdb-syncs", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/cmdb-syncs/del"), rt.cmdbSyncDel)
+		pages.GET("/cmdb-sync/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/cmdb-syncs"), rt.cmdbSyncGet)
+		pages.PUT("/cmdb-sync/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/cmdb-syncs/put"), rt.cmdbSyncPut)
+		pages.POST("/cmdb-sync/:id/run", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/cmdb-syncs/put"), rt.cmdbSyncRun)
+		pages.POST("/cmdb-sync/:id/csv", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/cmdb-syncs/put"), rt.cmdbSyncUploadCSV)
+
+		pages.GET("/audit-logs", rt.auth(), rt.user(), rt.perm("/audit-logs"), rt.auditLogGets)
+		pages.GET("/audit-logs/verify", rt.auth(), rt.user(), rt.perm("/audit-logs"), rt.auditLogVerify)
+
+		// 对象级权限在 handler 中按 resource_type 检查
+		pages.GET("/config-revisions", rt.auth(), rt.user(), rt.configRevisionGets)
+		pages.GET("/config-revisions/diff", rt.auth(), rt.user(), rt.configRevisionDiff)
+		pages.GET("/config-revision/:id", rt.auth(), rt.user(), rt.configRevisionGet)
+		pages.POST("/config-revision/:id/restore", rt.auth(), rt.user(), rt.configRevisionRestore)
+
 		pages.POST("/target/list", rt.auth(), rt.user(), rt.targetGetsByHostFilter)
 		pages.DELETE("/targets", rt.auth(), rt.user(), rt.perm("/targets/del"), rt.targetDel)
 		pages.GET("/targets/tags", rt.auth(), rt.user(), rt.targetGetTags)
@@ -545,6 +605,17 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.PUT("/recording-rule/:rrid", rt.auth(), rt.user(), rt.perm("/recording-rules"), rt.recordingRulePutByFE)
 		pages.PUT("/busi-group/:id/recording-rules/fields", rt.auth(), rt.user(), rt.perm("/recording-rules/put"), rt.recordingRulePutFields)
 
+		pages.GET("/busi-group/:id/scrape-jobs", rt.auth(), rt.user(), rt.perm("/scrape-jobs"), rt.bgro(), rt.scrapeJobGets)
+		pages.POST("/busi-group/:id/scrape-jobs", rt.auth(), rt.user(), rt.perm("/scrape-jobs/add"), rt.bgrw(), rt.scrapeJobAdd)
+		pages.DELETE("/busi-group/:id/scrape-jobs", rt.auth(), rt.user(), rt.perm("/scrape-jobs/del"), rt.bgrw(), rt.scrapeJobDel)
+		pages.GET("/scrape-job/:sjid", rt.auth(), rt.user(), rt.perm("/scrape-jobs"), rt.scrapeJobGet)
+		pages.PUT("/scrape-job/:sjid", rt.auth(), rt.user(), rt.perm("/scrape-jobs/put"), rt.scrapeJobPut)
+
+		pages.GET("/busi-group/:id/ingest-tokens", rt.auth(), rt.user(), rt.perm("/ingest-tokens"), rt.bgrw(), rt.ingestTokenGets)
+		pages.POST("/busi-group/:id/ingest-tokens", rt.auth(), rt.user(), rt.perm("/ingest-tokens/add"), rt.bgrw(), rt.ingestTokenAdd)
+		pages.DELETE("/busi-group/:id/ingest-tokens", rt.auth(), rt.user(), rt.perm("/ingest-tokens/del"), rt.bgrw(), rt.ingestTokenDel)
+		pages.PUT("/ingest-token/:itid", rt.auth(), rt.user(), rt.perm("/ingest-tokens/put"), rt.ingestTokenPut)
+
 		pages.GET("/busi-groups/alert-mutes", rt.auth(), rt.user(), rt.perm("/alert-mutes"), rt.alertMuteGetsByGids)
 		pages.GET("/busi-group/:id/alert-mutes", rt.auth(), rt.user(), rt.perm("/alert-mutes"), rt.bgro(), rt.alertMuteGetsByBG)
 		pages.POST("/busi-group/:id/alert-mutes/preview", rt.auth(), rt.user(), rt.perm("/alert-mutes/add"), rt.bgrw(), rt.alertMutePreview)
@@ -609,29 +680,32 @@ func (rt *Router) Config(r *gin.Engine) {
 
 		pages.POST("/datasource/list", rt.auth(), rt.user(), rt.datasourceList)
 		pages.POST("/datasource/plugin/list", rt.auth(), rt.pluginList)
-		pages.POST("/datasource/upsert", rt.auth(), rt.admin(), rt.datasourceUpsert)
+		pages.POST("/datasource/upsert", rt.auth(), rt.user(), rt.datasourceUpsert)
 		pages.POST("/datasource/grafana/fetch", rt.auth(), rt.admin(), rt.datasourceGrafanaFetch)
 		pages.POST("/datasource/grafana/import", rt.auth(), rt.admin(), rt.datasourceGrafanaImport)
-		pages.POST("/datasource/desc", rt.auth(), rt.admin(), rt.datasourceGet)
-		pages.POST("/datasource/status/update", rt.auth(), rt.admin(), rt.datasourceUpdataStatus)
-		pages.DELETE("/datasource/", rt.auth(), rt.admin(), rt.datasourceDel)
+		pages.POST("/datasource/desc", rt.auth(), rt.user(), rt.datasourceGet)
+		pages.POST("/datasource/status/update", rt.auth(), rt.user(), rt.datasourceUpdataStatus)
+		// 数据源授权：管理员或有该数据源 admin 授权的用户可以管理
+		pages.GET("/datasource/:id/acls", rt.auth(), rt.user(), rt.datasourceAclGets)
+		pages.PUT("/datasource/:id/acls", rt.auth(), rt.user(), rt.datasourceAclPut)
+		pages.DELETE("/datasource/", rt.auth(), rt.user(), rt.datasourceDel)
 		// 模板匹配是只读探测，普通用户可用；导入动作的权限由业务组/payload 接口各自把关
 		pages.POST("/datasource/template-match", rt.auth(), rt.user(), rt.datasourceTemplateMatch)
 
 		pages.GET("/roles", rt.auth(), rt.user(), rt.roleGets)
-		pages.POST("/roles", rt.auth(), rt.user(), rt.perm("/roles/add"), rt.roleAdd)
-		pages.PUT("/roles", rt.auth(), rt.user(), rt.perm("/roles/put"), rt.rolePut)
-		pages.DELETE("/role/:id", rt.auth(), rt.user(), rt.perm("/roles/del"), rt.roleDel)
+		pages.POST("/roles", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/roles/add"), rt.roleAdd)
+		pages.PUT("/roles", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/roles/put"), rt.rolePut)
+		pages.DELETE("/role/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/roles/del"), rt.roleDel)
 
 		pages.GET("/role/:id/ops", rt.auth(), rt.user(), rt.perm("/roles"), rt.operationOfRole)
-		pages.PUT("/role/:id/ops", rt.auth(), rt.user(), rt.perm("/roles/put"), rt.roleBindOperation)
+		pages.PUT("/role/:id/ops", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/roles/put"), rt.roleBindOperation)
 		pages.GET("/operation", rt.auth(), rt.user(), rt.operations)
 
 		pages.GET("/notify-tpls", rt.auth(), rt.user(), rt.notifyTplGets)
-		pages.PUT("/notify-tpl/content", rt.auth(), rt.user(), rt.notifyTplUpdateContent)
-		pages.PUT("/notify-tpl", rt.auth(), rt.user(), rt.notifyTplUpdate)
-		pages.POST("/notify-tpl", rt.auth(), rt.user(), rt.notifyTplAdd)
-		pages.DELETE("/notify-tpl/:id", rt.auth(), rt.user(), rt.notifyTplDel)
+		pages.PUT("/notify-tpl/content", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/notification-templates/put"), rt.notifyTplUpdateContent)
+		pages.PUT("/notify-tpl", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/notification-templates/put"), rt.notifyTplUpdate)
+		pages.POST("/notify-tpl", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/notification-templates/add"), rt.notifyTplAdd)
+		pages.DELETE("/notify-tpl/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/notification-templates/del"), rt.notifyTplDel)
 		pages.POST("/notify-tpl/preview", rt.auth(), rt.user(), rt.notifyTplPreview)
 
 		pages.GET("/sso-configs", rt.auth(), rt.admin(), rt.ssoConfigGets)
@@ -652,13 +726,19 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.GET("/notify-config", rt.auth(), rt.user(), rt.perm("/help/notification-settings"), rt.notifyConfigGet)
 		pages.PUT("/notify-config", rt.auth(), rt.admin(), rt.notifyConfigPut)
 		pages.PUT("/smtp-config-test", rt.auth(), rt.admin(), rt.attemptSendEmail)
+		pages.GET("/notify-rate-limits", rt.auth(), rt.admin(), rt.notifyRateLimitGet)
+		pages.GET("/notify-retries", rt.auth(), rt.admin(), rt.notifyRetryGets)
+		pages.GET("/notify-retry/:id/records", rt.auth(), rt.admin(), rt.notifyRetryRecords)
+		pages.POST("/notify-retries/resend", rt.auth(), rt.admin(), rt.notifyRetryResend)
+		pages.DELETE("/notify-retries", rt.auth(), rt.admin(), rt.notifyRetryDel)
+		pages.GET("/cardinality", rt.auth(), rt.admin(), rt.cardinalityReport)
 
 		pages.GET("/es-index-pattern", rt.auth(), rt.esIndexPatternGet)
 		pages.GET("/es-index-pattern-list", rt.auth(), rt.esIndexPatternGetList)
-		pages.POST("/es-index-pattern", rt.auth(), rt.user(), rt.perm("/log/index-patterns/add"), rt.esIndexPatternAdd)
-		pages.PUT("/es-index-pattern", rt.auth(), rt.user(), rt.perm("/log/index-patterns/put"), rt.esIndexPatternPut)
-		pages.PUT("/es-index-patterns/weights", rt.auth(), rt.user(), rt.perm("/log/index-patterns/put"), rt.esIndexPatternUpdateWeights)
-		pages.DELETE("/es-index-pattern", rt.auth(), rt.user(), rt.perm("/log/index-patterns/del"), rt.esIndexPatternDel)
+		pages.POST("/es-index-pattern", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/log/index-patterns/add"), rt.esIndexPatternAdd)
+		pages.PUT("/es-index-pattern", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/log/index-patterns/put"), rt.esIndexPatternPut)
+		pages.PUT("/es-index-patterns/weights", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/log/index-patterns/put"), rt.esIndexPatternUpdateWeights)
+		pages.DELETE("/es-index-pattern", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/log/index-patterns/del"), rt.esIndexPatternDel)
 
 		pages.GET("/embedded-dashboards", rt.auth(), rt.user(), rt.perm("/embedded-dashboards"), rt.embeddedDashboardsGet)
 		pages.PUT("/embedded-dashboards", rt.auth(), rt.user(), rt.perm("/embedded-dashboards/put"), rt.embeddedDashboardsPut)
@@ -666,11 +746,11 @@ func (rt *Router) Config(r *gin.Engine) {
 		// 获取 embedded-product 列表
 		pages.GET("/embedded-product", rt.auth(), rt.user(), rt.embeddedProductGets)
 		pages.GET("/embedded-product/:id", rt.auth(), rt.user(), rt.embeddedProductGet)
-		pages.POST("/embedded-product", rt.auth(), rt.user(), rt.perm("/embedded-product/add"), rt.embeddedProductAdd)
-		pages.PUT("/embedded-products/weights", rt.auth(), rt.user(), rt.perm("/embedded-product/put"), rt.embeddedProductWeightsPut)
-		pages.PUT("/embedded-product/:id/hide", rt.auth(), rt.user(), rt.perm("/embedded-product/put"), rt.embeddedProductHidePut)
-		pages.PUT("/embedded-product/:id", rt.auth(), rt.user(), rt.perm("/embedded-product/put"), rt.embeddedProductPut)
-		pages.DELETE("/embedded-product/:id", rt.auth(), rt.user(), rt.perm("/embedded-product/delete"), rt.embeddedProductDelete)
+		pages.POST("/embedded-product", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/embedded-product/add"), rt.embeddedProductAdd)
+		pages.PUT("/embedded-products/weights", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/embedded-product/put"), rt.embeddedProductWeightsPut)
+		pages.PUT("/embedded-product/:id/hide", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/embedded-product/put"), rt.embeddedProductHidePut)
+		pages.PUT("/embedded-product/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/embedded-product/put"), rt.embeddedProductPut)
+		pages.DELETE("/embedded-product/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/embedded-product/delete"), rt.embeddedProductDelete)
 
 		pages.GET("/user-variable-configs", rt.auth(), rt.user(), rt.perm("/system/variable-settings"), rt.userVariableConfigGets)
 		pages.POST("/user-variable-config", rt.auth(), rt.user(), rt.perm("/system/variable-settings"), rt.userVariableConfigAdd)
@@ -688,25 +768,25 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.PUT("/ai-agent/:id", rt.auth(), rt.admin(), rt.aiAgentPut)
 		pages.DELETE("/ai-agent/:id", rt.auth(), rt.admin(), rt.aiAgentDel)
 
-		pages.GET("/ai-llm-configs", rt.auth(), rt.user(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigGets)
-		pages.GET("/ai-llm-config/:id", rt.auth(), rt.user(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigGet)
-		pages.POST("/ai-llm-configs", rt.auth(), rt.user(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigAdd)
-		pages.PUT("/ai-llm-config/:id", rt.auth(), rt.user(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigPut)
-		pages.DELETE("/ai-llm-config/:id", rt.auth(), rt.user(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigDel)
-		pages.POST("/ai-llm-config/test", rt.auth(), rt.user(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigTest)
-
-		pages.GET("/ai-skills", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillGets)
-		pages.GET("/ai-skill/:id", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillGet)
-		pages.POST("/ai-skills", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillAdd)
-		pages.PUT("/ai-skill/:id", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillPut)
-		pages.DELETE("/ai-skill/:id", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillDel)
-		pages.POST("/ai-skills/import", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillImport)
-		pages.PUT("/ai-skill/:id/import", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillImportUpdate)
-		pages.POST("/ai-skills/git/install", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillGitInstall)
-		pages.PUT("/ai-skill/:id/git/install", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillGitInstallPut)
-		pages.POST("/ai-skill/:id/git/update", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillGitUpdate)
-		pages.GET("/ai-skill-file/:fileId", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillFileGet)
-		pages.DELETE("/ai-skill-file/:fileId", rt.auth(), rt.user(), rt.perm("/ai-config/skills"), rt.aiSkillFileDel)
+		pages.GET("/ai-llm-configs", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigGets)
+		pages.GET("/ai-llm-config/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigGet)
+		pages.POST("/ai-llm-configs", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigAdd)
+		pages.PUT("/ai-llm-config/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigPut)
+		pages.DELETE("/ai-llm-config/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigDel)
+		pages.POST("/ai-llm-config/test", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/llm-configs"), rt.aiLLMConfigTest)
+
+		pages.GET("/ai-skills", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillGets)
+		pages.GET("/ai-skill/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillGet)
+		pages.POST("/ai-skills", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillAdd)
+		pages.PUT("/ai-skill/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillPut)
+		pages.DELETE("/ai-skill/:id", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillDel)
+		pages.POST("/ai-skills/import", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillImport)
+		pages.PUT("/ai-skill/:id/import", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillImportUpdate)
+		pages.POST("/ai-skills/git/install", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillGitInstall)
+		pages.PUT("/ai-skill/:id/git/install", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillGitInstallPut)
+		pages.POST("/ai-skill/:id/git/update", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillGitUpdate)
+		pages.GET("/ai-skill-file/:fileId", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillFileGet)
+		pages.DELETE("/ai-skill-file/:fileId", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/ai-config/skills"), rt.aiSkillFileDel)
 
 		// AI Assistant Chat
 		pages.POST("/assistant/chat/new", rt.auth(), rt.user(), rt.assistantChatNew)
@@ -732,15 +812,15 @@ func (rt *Router) Config(r *gin.Engine) {
 		pages.GET("/user/busi-groups", rt.auth(), rt.admin(), rt.userBusiGroupsGets)
 
 		pages.GET("/builtin-components", rt.auth(), rt.user(), rt.builtinComponentsGets)
-		pages.POST("/builtin-components", rt.auth(), rt.user(), rt.perm("/components/add"), rt.builtinComponentsAdd)
-		pages.PUT("/builtin-components", rt.auth(), rt.user(), rt.perm("/components/put"), rt.builtinComponentsPut)
-		pages.DELETE("/builtin-components", rt.auth(), rt.user(), rt.perm("/components/del"), rt.builtinComponentsDel)
+		pages.POST("/builtin-components", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/components/add"), rt.builtinComponentsAdd)
+		pages.PUT("/builtin-components", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/components/put"), rt.builtinComponentsPut)
+		pages.DELETE("/builtin-components", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/components/del"), rt.builtinComponentsDel)
 
 		pages.GET("/builtin-payloads", rt.auth(), rt.user(), rt.builtinPayloadsGets)
 		pages.GET("/builtin-payloads/cates", rt.auth(), rt.user(), rt.builtinPayloadcatesGet)
-		pages.POST("/builtin-payloads", rt.auth(), rt.user(), rt.perm("/components/add"), rt.builtinPayloadsAdd)
-		pages.PUT("/builtin-payloads", rt.auth(), rt.user(), rt.perm("/components/put"), rt.builtinPayloadsPut)
-		pages.DELETE("/builtin-payloads", rt.auth(), rt.user(), rt.perm("/components/del"), rt.builtinPayloadsDel)
+		pages.POST("/builtin-payloads", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/components/add"), rt.builtinPayloadsAdd)
+		pages.PUT("/builtin-payloads", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/components/put"), rt.builtinPayloadsPut)
+		pages.DELETE("/builtin-payloads", rt.auth(), rt.user(), rt.platformUser(), rt.perm("/components/del"), rt.builtinPayloadsDel)
 		pages.GET("/builtin-payload", rt.auth(), rt.user(), rt.builtinPayloadsGetByUUID)
 
 		pages.POST("/message-templates", rt.auth(), rt.user(), rt.perm("/notification-templates/add"), rt.messageTemplatesAdd)
@@ -832,6 +912,7 @@ func (rt *Router) Config(r *gin.Engine) {
 		if len(rt.HTTP.APIForService.BasicAuth) > 0 {
 			service.Use(gin.BasicAuth(rt.HTTP.APIForService.BasicAuth))
 		}
+		service.Use(rt.auditLog(true))
 		{
 			service.Any("/prometheus/*url", rt.dsProxy)
 			service.POST("/users", rt.userAddPost)
@@ -873,6 +954,8 @@ func (rt *Router) Config(r *gin.Engine) {
 			service.GET("/servers-active", rt.serversActive)
 
 			service.GET("/recording-rules", rt.recordingRuleGetsByService)
+			service.GET("/scrape-jobs", rt.scrapeJobGetsByService)
+			service.GET("/ingest-tokens", rt.ingestTokenGetsByService)
 
 			service.GET("/alert-mutes", rt.alertMuteGets)
 			service.GET("/active-alert-mutes", rt.activeAlertMuteGets)
@@ -910,6 +993,7 @@ func (rt *Router) Config(r *gin.Engine) {
 			service.GET("/targets-of-alert-rule", rt.targetsOfAlertRule)
 
 			service.POST("/notify-record", rt.notificationRecordAdd)
+			service.POST("/notify-retry-add", rt.notifyRetryAddByService)
 
 			service.GET("/alert-cur-events-del-by-hash", rt.alertCurEventDelByHash)
 
@@ -972,6 +1056,7 @@ func (rt *Router) Config(r *gin.Engine) {
 	}
 
 	rt.configRegisterA2A(r)
+	rt.configSCIM(r)
 
 	rt.configNoRoute(r, &statikFS)
 
@@ -239,6 +239,38 @@ func (rt *Router) alertHisEventGet(c *gin.Context) {
 }
 
 func GetBusinessGroupIds(c *gin.Context, ctx *ctx.Context, onlySelfGroupView bool, myGroups bool) ([]int64, error) {
+	bgids, err := getBusinessGroupIds(c, ctx, onlySelfGroupView, myGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	// token 限定了业务组时，只能查到这些业务组的数据
+	ut := requestUserToken(c)
+	if ut == nil || len(ut.BusiGroupIds) == 0 {
+		return bgids, nil
+	}
+
+	if len(bgids) == 0 {
+		return ut.BusiGroupIds, nil
+	}
+
+	var allowed []int64
+	for _, id := range bgids {
+		if ut.BusiGroupAllowed(id) {
+			allowed = append(allowed, id)
+		}
+	}
+	if len(allowed) == 0 {
+		if ginx.QueryInt64(c, "bgid", 0) > 0 {
+			return nil, fmt.Errorf("business group ID not allowed")
+		}
+		// 没有交集时返回 0，否则会查到全部数据
+		return []int64{0}, nil
+	}
+	return allowed, nil
+}
+
+func getBusinessGroupIds(c *gin.Context, ctx *ctx.Context, onlySelfGroupView bool, myGroups bool) ([]int64, error) {
 	bgid := ginx.QueryInt64(c, "bgid", 0)
 	var bgids []int64
 
@@ -252,10 +284,19 @@ func GetBusinessGroupIds(c *gin.Context, ctx *ctx.Context, onlySelfGroupView boo
 	}
 
 	user := c.MustGet("user").(*models.User)
-	if myGroups || (onlySelfGroupView && !user.IsAdmin()) {
+	if myGroups || (onlySelfGroupView && !user.IsAdmin()) || user.TenantId > 0 {
 		// 1. 页面上勾选了我的业务组，需要查询用户所属的业务组
 		// 2. 如果 onlySelfGroupView 为 true，表示只允许查询用户所属的业务组
-		bussGroupIds, err := models.MyBusiGroupIds(ctx, user.Id)
+		// 3. 租户用户只能查询本租户的业务组
+		var (
+			bussGroupIds []int64
+			err          error
+		)
+		if myGroups {
+			bussGroupIds, err = models.MyBusiGroupIds(ctx, user.Id)
+		} else {
+			bussGroupIds, err = user.VisibleBusiGroupIds(ctx)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -266,7 +307,7 @@ func GetBusinessGroupIds(c *gin.Context, ctx *ctx.Context, onlySelfGroupView boo
 		}
 
 		if bgid > 0 {
-			if !slices.Contains(bussGroupIds, bgid) && !user.IsAdmin() {
+			if !slices.Contains(bussGroupIds, bgid) && !user.IsSuperAdmin() {
 				return nil, fmt.Errorf("business group ID not allowed")
 			}
 
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/ccfos/nightingale/v6/alert/mute"
+	"github.com/ccfos/nightingale/v6/center/eventbus"
 	"github.com/ccfos/nightingale/v6/models"
 	"github.com/ccfos/nightingale/v6/pkg/ginx"
 	"github.com/ccfos/nightingale/v6/pkg/strx"
@@ -59,9 +60,9 @@ func (rt *Router) alertRuleGetsByGids(c *gin.Context) {
 		}
 	} else {
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			var err error
-			gids, err = models.MyBusiGroupIds(rt.Ctx, me.Id)
+			gids, err = me.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 
 			if len(gids) == 0 {
@@ -71,6 +72,12 @@ func (rt *Router) alertRuleGetsByGids(c *gin.Context) {
 		}
 	}
 
+	gids, visible := tokenBusiGroupFilter(c, gids)
+	if !visible {
+		ginx.NewRender(c).Data([]int{}, nil)
+		return
+	}
+
 	ars, err := models.AlertRuleGetsByBGIds(rt.Ctx, gids)
 	if err == nil {
 		cache := make(map[int64]*models.UserGroup)
@@ -357,6 +364,9 @@ func (rt *Router) alertRuleAddOneByService(c *gin.Context) {
 	ginx.Dangerous(err)
 
 	err = f.Add(rt.Ctx)
+	if err == nil {
+		rt.publishAlertRules(eventbus.ActionCreated, "service", []int64{f.Id})
+	}
 	ginx.NewRender(c).Data(f.Id, err)
 }
 
@@ -380,6 +390,7 @@ func (rt *Router) alertRuleAddForService(lst []models.AlertRule, username string
 			reterr[lst[i].Name] = err.Error()
 		} else {
 			reterr[lst[i].Name] = ""
+			rt.publishAlertRules(eventbus.ActionCreated, "service", []int64{lst[i].Id})
 		}
 	}
 	return reterr
@@ -402,15 +413,26 @@ func (rt *Router) alertRuleAdd(c *gin.Context, lst []models.AlertRule, username
 			continue
 		}
 
+		if err := rt.checkRuleDatasources(c, &lst[i]); err != nil {
+			reterr[lst[i].Name] = translateText(lang, err.Error())
+			continue
+		}
+
 		if err := lst[i].FE2DB(); err != nil {
 			reterr[lst[i].Name] = translateText(lang, err.Error())
 			continue
 		}
 
+		if err := rt.checkRuleQuota(bgid, 1); err != nil {
+			reterr[lst[i].Name] = translateText(lang, err.Error())
+			continue
+		}
+
 		if err := lst[i].Add(rt.Ctx); err != nil {
 			reterr[lst[i].Name] = translateText(lang, err.Error())
 		} else {
 			reterr[lst[i].Name] = ""
+			rt.publishAlertRules(eventbus.ActionCreated, username, []int64{lst[i].Id})
 		}
 	}
 	return reterr
@@ -434,10 +456,30 @@ func (rt *Router) alertRuleUpsert(c *gin.Context, lst []models.AlertRule, userna
 			continue
 		}
 
+		if err := rt.checkRuleDatasources(c, &lst[i]); err != nil {
+			reterr[lst[i].Name] = translateText(lang, err.Error())
+			continue
+		}
+
+		// 覆盖同名规则不占用新的配额
+		exists, err := models.AlertRuleExists(rt.Ctx, 0, bgid, lst[i].Name)
+		if err == nil && !exists {
+			err = rt.checkRuleQuota(bgid, 1)
+		}
+		if err != nil {
+			reterr[lst[i].Name] = translateText(lang, err.Error())
+			continue
+		}
+
 		if err := lst[i].Upsert(rt.Ctx); err != nil {
 			reterr[lst[i].Name] = translateText(lang, err.Error())
 		} else {
 			reterr[lst[i].Name] = ""
+			action := eventbus.ActionCreated
+			if exists {
+				action = eventbus.ActionUpdated
+			}
+			rt.publishAlertRules(action, username, []int64{lst[i].Id})
 		}
 	}
 	return reterr
@@ -449,14 +491,25 @@ func (rt *Router) alertRuleDel(c *gin.Context) {
 	f.Verify()
 
 	// param(busiGroupId) for protect
-	ginx.NewRender(c).Message(models.AlertRuleDels(rt.Ctx, f.Ids, ginx.UrlParamInt64(c, "id")))
+	bgid := ginx.UrlParamInt64(c, "id")
+	deleted := rt.alertRulesBeforeDel(f.Ids, bgid)
+	err := models.AlertRuleDels(rt.Ctx, f.Ids, bgid)
+	if err == nil {
+		publishAlertRuleLst(eventbus.ActionDeleted, c.GetString("username"), deleted)
+	}
+	ginx.NewRender(c).Message(err)
 }
 
 func (rt *Router) alertRuleDelByService(c *gin.Context) {
 	var f idsForm
 	ginx.BindJSON(c, &f)
 	f.Verify()
-	ginx.NewRender(c).Message(models.AlertRuleDels(rt.Ctx, f.Ids))
+	deleted := rt.alertRulesBeforeDel(f.Ids)
+	err := models.AlertRuleDels(rt.Ctx, f.Ids)
+	if err == nil {
+		publishAlertRuleLst(eventbus.ActionDeleted, "service", deleted)
+	}
+	ginx.NewRender(c).Message(err)
 }
 
 func (rt *Router) alertRulePutByFE(c *gin.Context) {
@@ -478,8 +531,16 @@ func (rt *Router) alertRulePutByFE(c *gin.Context) {
 		ginx.Bomb(http.StatusForbidden, "%s", err.Error())
 	}
 
+	if err := rt.checkRuleDatasources(c, &f); err != nil {
+		ginx.Bomb(http.StatusForbidden, "%s", err.Error())
+	}
+
 	f.UpdateBy = c.MustGet("username").(string)
-	ginx.NewRender(c).Message(ar.Update(rt.Ctx, f))
+	err = ar.Update(rt.Ctx, f)
+	if err == nil {
+		rt.publishAlertRules(eventbus.ActionUpdated, f.UpdateBy, []int64{ar.Id})
+	}
+	ginx.NewRender(c).Message(err)
 }
 
 func (rt *Router) alertRulePutByService(c *gin.Context) {
@@ -495,7 +556,11 @@ func (rt *Router) alertRulePutByService(c *gin.Context) {
 		return
 	}
 
-	ginx.NewRender(c).Message(ar.Update(rt.Ctx, f))
+	err = ar.Update(rt.Ctx, f)
+	if err == nil {
+		rt.publishAlertRules(eventbus.ActionUpdated, "service", []int64{ar.Id})
+	}
+	ginx.NewRender(c).Message(err)
 }
 
 type alertRuleFieldForm struct {
@@ -524,6 +589,16 @@ func (rt *Router) alertRulePutFields(c *gin.Context) {
 			continue
 		}
 
+		if v, has := f.Fields["datasource_queries"]; has {
+			var queries []models.DatasourceQuery
+			bs, err := json.Marshal(v)
+			ginx.Dangerous(err)
+			ginx.Dangerous(json.Unmarshal(bs, &queries))
+			if err := rt.checkRuleDatasources(c, &models.AlertRule{Cate: ar.Cate, DatasourceQueries: queries}); err != nil {
+				ginx.Bomb(http.StatusForbidden, "%s", err.Error())
+			}
+		}
+
 		// 特殊 action 会在原有内容基础上做合并/追加/删除，处理完后必须跳过下面的通用字段写入，
 		// 否则通用流程会用本次提交的原始内容再覆盖一次，导致上面的合并结果丢失（新增变覆盖、删除变只留删除项）。
 		switch f.Action {
@@ -603,6 +678,8 @@ func (rt *Router) alertRulePutFields(c *gin.Context) {
 			"update_by": updateBy,
 			"update_at": updateAt,
 		}))
+		models.ConfigRevisionRecord(rt.Ctx, models.RevisionAlertRule, ar.Id)
+		rt.publishAlertRules(eventbus.ActionUpdated, updateBy, []int64{ar.Id})
 	}
 
 	ginx.NewRender(c).Message(nil)
@@ -697,9 +774,15 @@ func (rt *Router) alertRuleValidation(c *gin.Context) {
 
 func (rt *Router) alertRuleCallbacks(c *gin.Context) {
 	user := c.MustGet("user").(*models.User)
-	bussGroupIds, err := models.MyBusiGroupIds(rt.Ctx, user.Id)
+	bussGroupIds, err := user.VisibleBusiGroupIds(rt.Ctx)
 	ginx.Dangerous(err)
 
+	bussGroupIds, visible := tokenBusiGroupFilter(c, bussGroupIds)
+	if !visible {
+		ginx.NewRender(c).Data([]string{}, nil)
+		return
+	}
+
 	ars, err := models.AlertRuleGetsByBGIds(rt.Ctx, bussGroupIds)
 	ginx.Dangerous(err)
 
@@ -789,6 +872,11 @@ func (rt *Router) cloneToMachine(c *gin.Context) {
 	alertRules, err := models.AlertRuleGetsByIds(rt.Ctx, f.Ids)
 	ginx.Dangerous(err)
 
+	// 克隆出的规则留在源规则所在的业务组，ids 可以是任意规则，逐个检查源业务组的写权限
+	for i := range alertRules {
+		rt.bgrwCheck(c, alertRules[i].GroupId)
+	}
+
 	re := regexp.MustCompile(`ident\s*=\s*\\".*?\\"`)
 
 	user := c.MustGet("username").(string)
@@ -813,6 +901,12 @@ func (rt *Router) cloneToMachine(c *gin.Context) {
 			continue
 		}
 
+		if err := rt.checkRuleDatasources(c, &alertRules[i]); err != nil {
+			errMsg["all"] = err.Error()
+			reterr[alertRules[i].Name] = errMsg
+			continue
+		}
+
 		for j := range f.IdentList {
 			alertRules[i].RuleConfig = re.ReplaceAllString(alertRules[i].RuleConfig, fmt.Sprintf(`ident=\"%s\"`, f.IdentList[j]))
 
@@ -849,7 +943,23 @@ func (rt *Router) cloneToMachine(c *gin.Context) {
 		}
 	}
 
-	ginx.NewRender(c).Data(reterr, models.InsertAlertRule(rt.Ctx, newRules))
+	counts := make(map[int64]int)
+	for _, r := range newRules {
+		counts[r.GroupId]++
+	}
+	for bgid, n := range counts {
+		ginx.Dangerous(rt.checkRuleQuota(bgid, n))
+	}
+
+	err = models.InsertAlertRule(rt.Ctx, newRules)
+	if err == nil {
+		ids := make([]int64, 0, len(newRules))
+		for _, r := range newRules {
+			ids = append(ids, r.Id)
+		}
+		rt.publishAlertRules(eventbus.ActionCreated, c.GetString("username"), ids)
+	}
+	ginx.NewRender(c).Data(reterr, err)
 }
 
 type alertBatchCloneForm struct {
@@ -874,6 +984,10 @@ func (rt *Router) batchAlertRuleClone(c *gin.Context) {
 
 	for _, arid := range f.RuleIds {
 		ar, err := models.AlertRuleGetById(rt.Ctx, arid)
+		// 没有源规则所在业务组读权限的，不能克隆出来查看
+		if err == nil && ar != nil {
+			rt.bgroCheck(c, ar.GroupId)
+		}
 		for _, bgid := range f.Bgids {
 			// 为了让 bgid 和 arid 对应，将上面的 err 放到这里处理
 			if err != nil {
@@ -886,12 +1000,23 @@ func (rt *Router) batchAlertRuleClone(c *gin.Context) {
 				continue
 			}
 
+			if derr := rt.checkRuleDatasources(c, ar); derr != nil {
+				reterr[fmt.Sprintf("%d-%d", arid, bgid)] = translateText(lang, derr.Error())
+				continue
+			}
+
+			if qerr := rt.checkRuleQuota(bgid, 1); qerr != nil {
+				reterr[fmt.Sprintf("%d-%d", arid, bgid)] = translateText(lang, qerr.Error())
+				continue
+			}
+
 			newAr := ar.Clone(me.Username, bgid)
 			err = newAr.Add(rt.Ctx)
 			if err != nil {
 				reterr[fmt.Sprintf("%d-%d", arid, bgid)] = translateText(lang, err.Error())
 				continue
 			}
+			rt.publishAlertRules(eventbus.ActionCreated, me.Username, []int64{newAr.Id})
 		}
 	}
 
@@ -42,9 +42,9 @@ func (rt *Router) alertSubscribeGetsByGids(c *gin.Context) {
 		}
 	} else {
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			var err error
-			gids, err = models.MyBusiGroupIds(rt.Ctx, me.Id)
+			gids, err = me.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 
 			if len(gids) == 0 {
@@ -54,6 +54,12 @@ func (rt *Router) alertSubscribeGetsByGids(c *gin.Context) {
 		}
 	}
 
+	gids, visible := tokenBusiGroupFilter(c, gids)
+	if !visible {
+		ginx.NewRender(c).Data([]int{}, nil)
+		return
+	}
+
 	lst, err := models.AlertSubscribeGetsByBGIds(rt.Ctx, gids)
 	ginx.Dangerous(err)
 
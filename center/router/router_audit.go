@@ -0,0 +1,413 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/audit"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/errorx"
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	// 请求体超过该大小不解析，只记录路径
+	maxAuditBodySize = 4 * 1024 * 1024
+	// 批量操作最多记录的对象快照数
+	maxAuditSnapshots = 20
+)
+
+// readOnlyRoutes 用 POST/PUT 传参、但只做查询、校验或试运行的接口，按 "方法 完整路径" 精确匹配。
+// 审计日志和只读 token 都以它为准，没有登记的非 GET 接口一律当作写操作；发通知、执行处理器的试运行有副作用，不在此列
+var readOnlyRoutes = map[string]struct{}{
+	// 数据源查询
+	"POST /api/n9e/proxy/:id/*url":            {},
+	"POST /api/n9e/query-range-batch":         {},
+	"POST /api/n9e/query-exemplars-batch":     {},
+	"POST /api/n9e/query-instant-batch":       {},
+	"POST /api/n9e/datasource/query":          {},
+	"POST /api/n9e/ds-query":                  {},
+	"POST /api/n9e/logs-query":                {},
+	"POST /api/n9e/tdengine-databases":        {},
+	"POST /api/n9e/tdengine-tables":           {},
+	"POST /api/n9e/tdengine-columns":          {},
+	"POST /api/n9e/iotdb-databases":           {},
+	"POST /api/n9e/iotdb-tables":              {},
+	"POST /api/n9e/iotdb-columns":             {},
+	"POST /api/n9e/victorialogs-histogram":    {},
+	"POST /api/n9e/victorialogs-field-names":  {},
+	"POST /api/n9e/victorialogs-field-values": {},
+	"POST /api/n9e/loki-label-names":          {},
+	"POST /api/n9e/loki-label-values":         {},
+	"POST /api/n9e/loki-parsed-fields":        {},
+	"POST /api/n9e/loki-histogram":            {},
+	"POST /api/n9e/log-query-batch":           {},
+	"POST /api/n9e/db-databases":              {},
+	"POST /api/n9e/db-tables":                 {},
+	"POST /api/n9e/db-desc-table":             {},
+	"POST /api/n9e/indices":                   {},
+	"POST /api/n9e/es-variable":               {},
+	"POST /api/n9e/fields":                    {},
+	"POST /api/n9e/log-query":                 {},
+	"POST /api/n9e/es-cluster-info":           {},
+	"POST /api/n9e/os-indices":                {},
+	"POST /api/n9e/os-variable":               {},
+	"POST /api/n9e/os-fields":                 {},
+	// 列表、详情、预览
+	"POST /api/n9e/metrics/desc":                         {},
+	"POST /api/n9e/target/list":                          {},
+	"POST /api/n9e/alert-cur-events/list":                {},
+	"POST /api/n9e/datasource/list":                      {},
+	"POST /api/n9e/datasource/plugin/list":               {},
+	"POST /api/n9e/datasource/desc":                      {},
+	"POST /api/n9e/datasource/grafana/fetch":             {},
+	"POST /api/n9e/feishu-visible-chats/:id":             {},
+	"POST /api/n9e/relabel-test":                         {},
+	"POST /api/n9e/busi-group/alert-rules/enable-tryrun": {},
+	"POST /api/n9e/busi-group/:id/alert-mutes/preview":   {},
+	"POST /api/n9e/alert-mute-tryrun":                    {},
+	"POST /api/n9e/notify-tpl/preview":                   {},
+	"POST /api/n9e/assistant/message/detail":             {},
+	"POST /api/n9e/assistant/message/history":            {},
+	"PUT /api/n9e/busi-group/alert-rule/validate":        {},
+	// 登录会话，不修改配置，而且请求体里是凭证
+	"POST /api/n9e/auth/login":          {},
+	"POST /api/n9e/auth/logout":         {},
+	"POST /api/n9e/auth/refresh":        {},
+	"POST /api/n9e/auth/captcha":        {},
+	"POST /api/n9e/auth/captcha-verify": {},
+	"POST /api/n9e/auth/callback/saml":  {},
+	"POST /api/n9e/auth/login/totp":     {},
+	// service 接口
+	"POST /v1/n9e/prometheus/*url":           {},
+	"POST /v1/n9e/target/list":               {},
+	"POST /v1/n9e/targets-of-host-query":     {},
+	"POST /v1/n9e/assistant/message/detail":  {},
+	"POST /v1/n9e/assistant/message/history": {},
+}
+
+// isReadOnlyRoute 判断当前请求是否命中 readOnlyRoutes
+func isReadOnlyRoute(c *gin.Context) bool {
+	_, has := readOnlyRoutes[c.Request.Method+" "+c.FullPath()]
+	return has
+}
+
+type auditResource struct {
+	typ      string
+	segments []string
+	load     func(ctx *ctx.Context, id string) (interface{}, error)
+}
+
+func loadById[T any](get func(*ctx.Context, int64) (*T, error)) func(*ctx.Context, string) (interface{}, error) {
+	return func(c *ctx.Context, id string) (interface{}, error) {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, nil
+		}
+		return get(c, n)
+	}
+}
+
+// auditResources 需要记录变更前后快照的对象，路径中命中 segments 即认为操作该对象
+var auditResources = []auditResource{
+	{typ: "alert_rule", segments: []string{"alert-rule", "alert-rules"}, load: loadById(models.AlertRuleGetById)},
+	{typ: "alert_mute", segments: []string{"alert-mute", "alert-mutes"}, load: loadById(models.AlertMuteGetById)},
+	{typ: "notify_rule", segments: []string{"notify-rule", "notify-rules"}, load: loadById(models.GetNotifyRule)},
+	{typ: "datasource", segments: []string{"datasource"}, load: loadById(models.DatasourceGet)},
+	{typ: "user", segments: []string{"user", "users"}, load: loadById(models.UserGetById)},
+	{typ: "user_group", segments: []string{"user-group", "user-groups"}, load: loadById(models.UserGroupGetById)},
+	{typ: "busi_group", segments: []string{"busi-group", "busi-groups"}, load: loadById(models.BusiGroupGetById)},
+	{typ: "board", segments: []string{"board", "boards"}, load: loadById(models.BoardGetByID)},
+	{typ: "recording_rule", segments: []string{"recording-rule", "recording-rules"}, load: loadById(models.RecordingRuleGetById)},
+	{typ: "task_tpl", segments: []string{"task-tpl", "task-tpls"}, load: loadById(models.TaskTplGetById)},
+	{typ: "target", segments: []string{"target", "targets"}, load: func(c *ctx.Context, ident string) (interface{}, error) {
+		return models.TargetGetByIdent(c, ident)
+	}},
+}
+
+var auditResourceBySegment = func() map[string]*auditResource {
+	m := make(map[string]*auditResource)
+	for i := range auditResources {
+		for _, seg := range auditResources[i].segments {
+			m[seg] = &auditResources[i]
+		}
+	}
+	return m
+}()
+
+// resolveAuditResource 从路由中找出操作的对象类型，以及对象 id 所在的路径参数。
+// 从后往前找第一个登记过的对象，找不到时以路由前缀之后的第一段作为对象类型
+func resolveAuditResource(fullPath string) (typ string, res *auditResource, idParam string) {
+	segs := strings.Split(strings.Trim(fullPath, "/"), "/")
+	// 去掉 /api/n9e 或 /v1/n9e 前缀
+	if len(segs) > 2 {
+		segs = segs[2:]
+	}
+
+	for i := len(segs) - 1; i >= 0; i-- {
+		r, has := auditResourceBySegment[segs[i]]
+		if !has {
+			continue
+		}
+		if i+1 < len(segs) && strings.HasPrefix(segs[i+1], ":") {
+			idParam = segs[i+1][1:]
+		}
+		return r.typ, r, idParam
+	}
+
+	for _, seg := range segs {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			idParam = seg[1:]
+		} else if typ == "" {
+			typ = strings.ReplaceAll(seg, "-", "_")
+		}
+	}
+	return typ, nil, idParam
+}
+
+// auditBodyIds 请求体中的对象 id，兼容 {"ids":[]}、{"id":1}、{"idents":[]} 等写法
+func auditBodyIds(body []byte) []string {
+	var f struct {
+		Id     json.Number   `json:"id"`
+		Ids    []json.Number `json:"ids"`
+		Ident  string        `json:"ident"`
+		Idents []string      `json:"idents"`
+	}
+	if err := json.Unmarshal(body, &f); err != nil {
+		return nil
+	}
+
+	var ids []string
+	for _, id := range f.Ids {
+		ids = append(ids, id.String())
+	}
+	if f.Id != "" && f.Id != "0" {
+		ids = append(ids, f.Id.String())
+	}
+	ids = append(ids, f.Idents...)
+	if f.Ident != "" {
+		ids = append(ids, f.Ident)
+	}
+	return ids
+}
+
+func (rt *Router) auditSnapshots(res *auditResource, ids []string) interface{} {
+	if res == nil || len(ids) == 0 {
+		return nil
+	}
+
+	if len(ids) == 1 {
+		obj, err := res.load(rt.Ctx, ids[0])
+		if err != nil {
+			logger.Warningf("audit: failed to load %s %s: %v", res.typ, ids[0], err)
+			return nil
+		}
+		return audit.Snapshot(obj)
+	}
+
+	m := make(map[string]interface{})
+	for i, id := range ids {
+		if i >= maxAuditSnapshots {
+			break
+		}
+		obj, err := res.load(rt.Ctx, id)
+		if err != nil {
+			logger.Warningf("audit: failed to load %s %s: %v", res.typ, id, err)
+			continue
+		}
+		if snapshot := audit.Snapshot(obj); snapshot != nil {
+			m[id] = snapshot
+		}
+	}
+
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// auditLog 记录修改类接口的操作人、来源、认证方式以及对象变更前后的快照。
+// strict 为 true 时只记录登记过的对象，用于 service 接口，避免记录大量机器写入
+func (rt *Router) auditLog(strict bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rt.Center.Audit.Enable || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead ||
+			c.Request.Method == http.MethodOptions || isReadOnlyRoute(c) {
+			c.Next()
+			return
+		}
+
+		typ, res, idParam := resolveAuditResource(c.FullPath())
+		if strict && res == nil {
+			c.Next()
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil && !strings.HasPrefix(c.ContentType(), "multipart/") {
+			buf, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAuditBodySize+1))
+			if err == nil {
+				// 超过限制时不解析，但要把完整的请求体还给后面的 handler
+				c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), c.Request.Body))
+				if len(buf) <= maxAuditBodySize {
+					body = buf
+				}
+			}
+		}
+
+		var ids []string
+		if idParam != "" && c.Param(idParam) != "" {
+			ids = []string{c.Param(idParam)}
+		} else {
+			ids = auditBodyIds(body)
+		}
+
+		action := models.AuditActionUpdate
+		switch {
+		case c.Request.Method == http.MethodDelete:
+			action = models.AuditActionDelete
+		case c.Request.Method == http.MethodPost && len(ids) == 0:
+			action = models.AuditActionCreate
+		}
+
+		var before interface{}
+		if action != models.AuditActionCreate {
+			before = rt.auditSnapshots(res, ids)
+		}
+
+		l := &models.AuditLog{
+			SourceIp:     c.ClientIP(),
+			Method:       c.Request.Method,
+			Path:         c.Request.URL.Path,
+			ResourceType: typ,
+			ResourceId:   strings.Join(ids, ","),
+			Action:       action,
+		}
+		if len(l.ResourceId) > 191 {
+			l.ResourceId = l.ResourceId[:191]
+		}
+
+		// handler 通过 panic 返回错误（ginx.Bomb），在 defer 中记录后继续向上抛出
+		defer func() {
+			p := recover()
+
+			l.Status = c.Writer.Status()
+			if p != nil {
+				l.Status = http.StatusInternalServerError
+				if pe, ok := p.(errorx.PageError); ok {
+					l.Status = pe.Code
+					l.Error = pe.Message
+				} else {
+					l.Error = fmt.Sprint(p)
+				}
+				if len(l.Error) > 512 {
+					l.Error = l.Error[:512]
+				}
+			}
+
+			// 认证失败的请求不记录，避免被刷量
+			if l.Status != http.StatusUnauthorized {
+				var after interface{}
+				if p == nil && l.Status < http.StatusBadRequest {
+					switch {
+					case action == models.AuditActionDelete:
+					case action == models.AuditActionUpdate && res != nil && len(ids) > 0:
+						after = rt.auditSnapshots(res, ids)
+					case body != nil:
+						after = audit.SnapshotJSON(body)
+					}
+				} else {
+					after = before
+				}
+
+				rt.writeAuditLog(c, l, before, after)
+			}
+
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+func (rt *Router) writeAuditLog(c *gin.Context, l *models.AuditLog, before, after interface{}) {
+	l.Actor = c.GetString("username")
+	l.AuthMethod = c.GetString(authMethodKey)
+	if user := c.GetString(gin.AuthUserKey); user != "" {
+		if l.Actor == "" {
+			l.Actor = user
+		}
+		if l.AuthMethod == "" {
+			l.AuthMethod = "basic:" + user
+		}
+	}
+
+	l.Before = audit.Marshal(before)
+	l.After = audit.Marshal(after)
+	if changes := audit.Diff(before, after); len(changes) > 0 {
+		l.Diff = audit.Marshal(changes)
+	}
+	l.CreateAt = time.Now().Unix()
+
+	if err := models.AuditLogAdd(rt.Ctx, l); err != nil {
+		logger.Errorf("audit: failed to add audit log: %v, path: %s, actor: %s", err, l.Path, l.Actor)
+		return
+	}
+
+	audit.Export(l)
+}
+
+// maskToken 只保留末尾 4 位，用于在审计日志中区分不同的 token
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+	return "****" + token[len(token)-4:]
+}
+
+func (rt *Router) auditLogGets(c *gin.Context) {
+	q := models.AuditLogQuery{
+		Actor:        ginx.QueryStr(c, "actor", ""),
+		ResourceType: ginx.QueryStr(c, "resource_type", ""),
+		ResourceId:   ginx.QueryStr(c, "resource_id", ""),
+		Action:       ginx.QueryStr(c, "action", ""),
+		Stime:        ginx.QueryInt64(c, "stime", 0),
+		Etime:        ginx.QueryInt64(c, "etime", 0),
+		Query:        ginx.QueryStr(c, "query", ""),
+	}
+	limit := ginx.QueryInt(c, "limit", 20)
+
+	total, err := models.AuditLogTotal(rt.Ctx, q)
+	ginx.Dangerous(err)
+
+	lst, err := models.AuditLogGets(rt.Ctx, q, limit, ginx.Offset(c, limit))
+	ginx.Dangerous(err)
+
+	ginx.NewRender(c).Data(gin.H{
+		"list":  lst,
+		"total": total,
+	}, nil)
+}
+
+// auditLogVerify 校验哈希链，返回被修改的记录和链条断开（中间有记录被删除）的位置。
+// anchor 为 sink 中最近导出的记录的 hash，用来发现末尾的记录被删除
+func (rt *Router) auditLogVerify(c *gin.Context) {
+	limit := ginx.QueryInt(c, "limit", 100000)
+	if limit <= 0 || limit > 1000000 {
+		ginx.Bomb(http.StatusBadRequest, "limit should be between 1 and 1000000")
+	}
+
+	ret, err := models.AuditLogVerify(rt.Ctx, ginx.QueryInt64(c, "start_id", 0), limit, ginx.QueryStr(c, "anchor", ""))
+	ginx.NewRender(c).Data(ret, err)
+}
@@ -0,0 +1,177 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/center/audit"
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestResolveAuditResource(t *testing.T) {
+	cases := []struct {
+		path, typ, idParam string
+	}{
+		{"/api/n9e/busi-group/:id/alert-rule/:arid", "alert_rule", "arid"},
+		{"/api/n9e/busi-group/:id/alert-rules/fields", "alert_rule", ""},
+		{"/api/n9e/user-group/:id/members", "user_group", "id"},
+		{"/api/n9e/datasource/upsert", "datasource", ""},
+		{"/api/n9e/notify-channel-config/:id", "notify_channel_config", "id"},
+		{"/v1/n9e/user/:id", "user", "id"},
+	}
+
+	for _, tc := range cases {
+		typ, _, idParam := resolveAuditResource(tc.path)
+		if typ != tc.typ || idParam != tc.idParam {
+			t.Fatalf("%s: got type=%s idParam=%s", tc.path, typ, idParam)
+		}
+	}
+
+	ids := auditBodyIds([]byte(`{"ids":[1,2],"fields":{"disabled":1}}`))
+	if strings.Join(ids, ",") != "1,2" {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+}
+
+func TestAuditLogMiddleware(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.AuditLog{}, &models.BusiGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.BusiGroup{Id: 1, Name: "old"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	rt := &Router{Center: cconf.Center{Audit: cconf.Audit{Enable: true}}, Ctx: &ctx.Context{DB: db, IsCenter: true}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.CustomRecovery(func(c *gin.Context, err interface{}) {
+		c.AbortWithStatus(http.StatusBadRequest)
+	}))
+	pages := r.Group("/api/n9e")
+	pages.Use(rt.auditLog(false))
+
+	login := func(c *gin.Context) {
+		c.Set("username", "root")
+		c.Set(authMethodKey, "jwt")
+	}
+	pages.PUT("/busi-group/:id", login, func(c *gin.Context) {
+		var f struct {
+			Name   string `json:"name"`
+			Secret string `json:"secret"`
+		}
+		ginx.BindJSON(c, &f)
+		db.Model(&models.BusiGroup{}).Where("id = ?", ginx.UrlParamInt64(c, "id")).Update("name", f.Name)
+		c.String(http.StatusOK, "")
+	})
+	pages.POST("/busi-groups", login, func(c *gin.Context) {
+		ginx.Bomb(http.StatusBadRequest, "name is blank")
+	})
+	pages.POST("/user-groups", login, func(c *gin.Context) {
+		c.String(http.StatusOK, "")
+	})
+	pages.POST("/target/list", login, func(c *gin.Context) {
+		c.String(http.StatusOK, "")
+	})
+	// 路径里带 test、query 之类字样的写接口同样要记录
+	pages.POST("/mcp-servers/test-query", login, func(c *gin.Context) {
+		c.String(http.StatusOK, "")
+	})
+
+	do := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do(http.MethodPut, "/api/n9e/busi-group/1", `{"name":"new","secret":"xxx"}`); code != http.StatusOK {
+		t.Fatalf("put busi group: code=%d", code)
+	}
+	if code := do(http.MethodPost, "/api/n9e/busi-groups", `{"name":"","secret":"xxx"}`); code != http.StatusBadRequest {
+		t.Fatalf("add busi group: code=%d", code)
+	}
+	do(http.MethodPost, "/api/n9e/user-groups", `{"name":"ops","token":"xxx"}`)
+	do(http.MethodPost, "/api/n9e/target/list", `{}`)
+	do(http.MethodPost, "/api/n9e/mcp-servers/test-query", `{}`)
+
+	var lst []*models.AuditLog
+	if err := db.Order("id").Find(&lst).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(lst) != 4 {
+		t.Fatalf("expected 4 audit logs, got %d", len(lst))
+	}
+
+	l := lst[0]
+	if l.Actor != "root" || l.AuthMethod != "jwt" || l.ResourceType != "busi_group" || l.ResourceId != "1" ||
+		l.Action != models.AuditActionUpdate || l.Status != http.StatusOK {
+		t.Fatalf("unexpected audit log: %+v", l)
+	}
+
+	var changes []audit.Change
+	if err := json.Unmarshal([]byte(l.Diff), &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "name" || changes[0].Before != "old" || changes[0].After != "new" {
+		t.Fatalf("unexpected diff: %s", l.Diff)
+	}
+
+	l = lst[1]
+	if l.Action != models.AuditActionCreate || l.Status != http.StatusBadRequest || l.Error != "name is blank" {
+		t.Fatalf("unexpected audit log of failed request: %+v", l)
+	}
+	if l.PrevHash != lst[0].Hash {
+		t.Fatalf("audit logs are not chained")
+	}
+
+	l = lst[2]
+	if l.ResourceType != "user_group" || l.Action != models.AuditActionCreate || !strings.Contains(l.After, `"name":"ops"`) ||
+		strings.Contains(l.After, "xxx") {
+		t.Fatalf("unexpected audit log of create: %+v", l)
+	}
+
+	if l = lst[3]; l.Path != "/api/n9e/mcp-servers/test-query" {
+		t.Fatalf("unexpected audit log: %+v", l)
+	}
+}
+
+// 敏感字段在脱敏后看起来相同，仍要作为变化返回
+func TestAuditDiffJSONRedacted(t *testing.T) {
+	before := `{"name":"a","token":"t1","password":"p","http":{"api_key":"k1"},"headers":[{"secret":"s1"}]}`
+	after := `{"name":"a","token":"t2","password":"p","http":{"api_key":"k1"},"headers":[{"secret":"s2"}]}`
+
+	changes := audit.DiffJSON([]byte(before), []byte(after))
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+
+	if changes[0].Field != "headers" || !changes[0].Redacted || strings.Contains(audit.Marshal(changes[0]), "s1") ||
+		strings.Contains(audit.Marshal(changes[0]), "s2") {
+		t.Fatalf("unexpected headers change: %+v", changes[0])
+	}
+	if changes[1].Field != "token" || !changes[1].Redacted || changes[1].Before != "******" || changes[1].After != "******" {
+		t.Fatalf("unexpected token change: %+v", changes[1])
+	}
+
+	changes = audit.DiffJSON([]byte(`{"name":"a","token":""}`), []byte(`{"name":"b","token":""}`))
+	if len(changes) != 1 || changes[0].Field != "name" || changes[0].Redacted {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+}
@@ -78,7 +78,7 @@ func (rt *Router) boardGet(c *gin.Context) {
 		rt.user()(c)
 
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			// check permission
 			rt.bgroCheck(c, board.GroupId)
 		}
@@ -91,8 +91,8 @@ func (rt *Router) boardGet(c *gin.Context) {
 		rt.user()(c)
 
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
-			bgids, err := models.MyBusiGroupIds(rt.Ctx, me.Id)
+		if !me.IsSuperAdmin() {
+			bgids, err := me.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 			if len(bgids) == 0 {
 				ginx.Bomb(http.StatusForbidden, "forbidden")
@@ -149,7 +149,7 @@ func (rt *Router) boardDel(c *gin.Context) {
 		}
 
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			// check permission
 			rt.bgrwCheck(c, board.GroupId)
 		}
@@ -179,7 +179,7 @@ func (rt *Router) boardPut(c *gin.Context) {
 	me := c.MustGet("user").(*models.User)
 	bo := rt.Board(ginx.UrlParamInt64(c, "bid"))
 
-	if !me.IsAdmin() {
+	if !me.IsSuperAdmin() {
 		// check permission
 		rt.bgrwCheck(c, bo.GroupId)
 	}
@@ -218,7 +218,7 @@ func (rt *Router) boardPutConfigs(c *gin.Context) {
 	}
 
 	// check permission
-	if !me.IsAdmin() {
+	if !me.IsSuperAdmin() {
 		rt.bgrwCheck(c, bo.GroupId)
 	}
 
@@ -241,7 +241,7 @@ func (rt *Router) boardPutPublic(c *gin.Context) {
 	bo := rt.Board(ginx.UrlParamInt64(c, "bid"))
 
 	// check permission
-	if !me.IsAdmin() {
+	if !me.IsSuperAdmin() {
 		rt.bgrwCheck(c, bo.GroupId)
 	}
 
@@ -277,13 +277,38 @@ func (rt *Router) boardGets(c *gin.Context) {
 func (rt *Router) publicBoardGets(c *gin.Context) {
 	me := c.MustGet("user").(*models.User)
 
-	bgids, err := models.MyBusiGroupIds(rt.Ctx, me.Id)
+	bgids, err := me.VisibleBusiGroupIds(rt.Ctx)
 	ginx.Dangerous(err)
 
+	if ut := requestUserToken(c); ut != nil && len(ut.BusiGroupIds) > 0 {
+		// token 限定了业务组时，只返回这些业务组里公开的仪表盘
+		if len(bgids) > 0 {
+			bgids, _ = tokenBusiGroupFilter(c, bgids)
+		}
+		if len(bgids) == 0 {
+			ginx.NewRender(c).Data([]models.Board{}, nil)
+			return
+		}
+		boards, err := models.BoardGets(rt.Ctx, "", "public=1 and group_id in (?)", bgids)
+		if err == nil {
+			models.FillUpdateByNicknames(rt.Ctx, boards)
+		}
+		ginx.NewRender(c).Data(boards, err)
+		return
+	}
+
 	boardIds, err := models.BoardIdsByBusiGroupIds(rt.Ctx, bgids)
 	ginx.Dangerous(err)
 
-	boards, err := models.BoardGets(rt.Ctx, "", "public=1 and (public_cate in (?) or id in (?))", []int64{0, 1}, boardIds)
+	var boards []models.Board
+	if me.TenantId > 0 {
+		// 租户用户只能看到本租户业务组下公开的仪表盘
+		tbgids, err := models.TenantBusiGroupIds(rt.Ctx, me.TenantId)
+		ginx.Dangerous(err)
+		boards, err = models.BoardGets(rt.Ctx, "", "public=1 and group_id in (?) and (public_cate in (?) or id in (?))", tbgids, []int64{0, 1}, boardIds)
+	} else {
+		boards, err = models.BoardGets(rt.Ctx, "", "public=1 and (public_cate in (?) or id in (?))", []int64{0, 1}, boardIds)
+	}
 	if err == nil {
 		models.FillUpdateByNicknames(rt.Ctx, boards)
 	}
@@ -300,9 +325,9 @@ func (rt *Router) boardGetsByGids(c *gin.Context) {
 		}
 	} else {
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			var err error
-			gids, err = models.MyBusiGroupIds(rt.Ctx, me.Id)
+			gids, err = me.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 
 			if len(gids) == 0 {
@@ -312,6 +337,12 @@ func (rt *Router) boardGetsByGids(c *gin.Context) {
 		}
 	}
 
+	gids, visible := tokenBusiGroupFilter(c, gids)
+	if !visible {
+		ginx.NewRender(c).Data([]int{}, nil)
+		return
+	}
+
 	boardBusigroups, err := models.BoardBusigroupGets(rt.Ctx)
 	ginx.Dangerous(err)
 	m := make(map[int64][]int64)
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/toolkits/pkg/logger"
+	"github.com/toolkits/pkg/slice"
 )
 
 type busiGroupForm struct {
@@ -38,8 +39,8 @@ func (rt *Router) busiGroupAdd(c *gin.Context) {
 		ginx.Bomb(http.StatusBadRequest, "At least one team have rw permission")
 	}
 
-	username := c.MustGet("username").(string)
-	ginx.Dangerous(models.BusiGroupAdd(rt.Ctx, f.Name, f.LabelEnable, f.LabelValue, f.Members, username))
+	me := c.MustGet("user").(*models.User)
+	ginx.Dangerous(models.BusiGroupAdd(rt.Ctx, f.Name, f.LabelEnable, f.LabelValue, f.Members, me.Username, me.TenantId))
 
 	// 如果创建成功，拿着name去查，应该可以查到
 	newbg, err := models.BusiGroupGet(rt.Ctx, "name=?", f.Name)
@@ -69,12 +70,17 @@ func (rt *Router) busiGroupMemberAdd(c *gin.Context) {
 	username := c.MustGet("username").(string)
 	targetbg := c.MustGet("busi_group").(*models.BusiGroup)
 
+	ugids := make([]int64, 0, len(members))
 	for i := 0; i < len(members); i++ {
 		if members[i].BusiGroupId != targetbg.Id {
 			ginx.Bomb(http.StatusBadRequest, "business group id invalid")
 		}
+		ugids = append(ugids, members[i].UserGroupId)
 	}
 
+	// 只能授权给同一租户的团队
+	ginx.Dangerous(models.TenantCheckOwner(rt.Ctx, &models.UserGroup{}, targetbg.TenantId, ugids))
+
 	ginx.NewRender(c).Message(targetbg.AddMembers(rt.Ctx, members, username))
 }
 
@@ -133,8 +139,25 @@ func (rt *Router) busiGroupGetsByService(c *gin.Context) {
 
 // 这个接口只有在活跃告警页面才调用，获取各个BG的活跃告警数量
 func (rt *Router) busiGroupAlertingsGets(c *gin.Context) {
-	ids := ginx.QueryStr(c, "ids", "")
-	ret, err := models.AlertNumbers(rt.Ctx, strx.IdsInt64ForAPI(ids))
+	ids := strx.IdsInt64ForAPI(ginx.QueryStr(c, "ids", ""))
+	if me := rt.aclUser(c); me != nil && me.TenantId > 0 {
+		bgids, err := models.TenantBusiGroupIds(rt.Ctx, me.TenantId)
+		ginx.Dangerous(err)
+
+		visible := make([]int64, 0, len(ids))
+		for _, id := range ids {
+			if slice.ContainsInt64(bgids, id) {
+				visible = append(visible, id)
+			}
+		}
+		ids = visible
+	}
+
+	if len(ids) > 0 {
+		ids, _ = tokenBusiGroupFilter(c, ids)
+	}
+
+	ret, err := models.AlertNumbers(rt.Ctx, ids)
 	ginx.NewRender(c).Data(ret, err)
 }
 
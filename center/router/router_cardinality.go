@@ -0,0 +1,12 @@
+package router
+
+import (
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cardinalityReport 本进程内置 pushgw 的基数报告，独立部署的 pushgw 通过 /v1/n9e/cardinality 查看
+func (rt *Router) cardinalityReport(c *gin.Context) {
+	ginx.NewRender(c).Data(rt.Cardinality.Report(ginx.QueryInt(c, "topk", 20)), nil)
+}
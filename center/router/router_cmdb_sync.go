@@ -0,0 +1,102 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/ccfos/nightingale/v6/center/cmdb"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxCmdbCSVSize = 32 * 1024 * 1024
+
+func (rt *Router) cmdbSyncGets(c *gin.Context) {
+	lst, err := models.CmdbSyncGets(rt.Ctx)
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func (rt *Router) cmdbSyncGet(c *gin.Context) {
+	ginx.NewRender(c).Data(rt.cmdbSyncCheck(c), nil)
+}
+
+func (rt *Router) cmdbSyncCheck(c *gin.Context) *models.CmdbSync {
+	s, err := models.CmdbSyncGetById(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+	ginx.Dangerous(err)
+
+	if s == nil {
+		ginx.Bomb(http.StatusNotFound, "No such cmdb sync")
+	}
+
+	return s
+}
+
+func (rt *Router) cmdbSyncAdd(c *gin.Context) {
+	var f models.CmdbSync
+	ginx.BindJSON(c, &f)
+
+	username := c.MustGet("username").(string)
+	f.Id = 0
+	f.CreateBy = username
+	f.UpdateBy = username
+
+	ginx.Dangerous(f.Add(rt.Ctx))
+	ginx.NewRender(c).Data(f, nil)
+}
+
+func (rt *Router) cmdbSyncPut(c *gin.Context) {
+	var f models.CmdbSync
+	ginx.BindJSON(c, &f)
+
+	s := rt.cmdbSyncCheck(c)
+	f.UpdateBy = c.MustGet("username").(string)
+	ginx.NewRender(c).Message(s.Update(rt.Ctx, f))
+}
+
+func (rt *Router) cmdbSyncDel(c *gin.Context) {
+	var f idsForm
+	ginx.BindJSON(c, &f)
+	f.Verify()
+
+	ginx.NewRender(c).Message(models.CmdbSyncDels(rt.Ctx, f.Ids))
+}
+
+// cmdbSyncRun 立即从 HTTP 接口同步，dry_run=true 时只返回变更
+func (rt *Router) cmdbSyncRun(c *gin.Context) {
+	s := rt.cmdbSyncCheck(c)
+	if s.SourceType != models.CmdbSourceHTTP {
+		ginx.Bomb(http.StatusBadRequest, "csv source should be synced by uploading a file")
+	}
+
+	report, err := cmdb.RunHTTP(rt.Ctx, s, ginx.QueryBool(c, "dry_run", false))
+	ginx.NewRender(c).Data(report, err)
+}
+
+// cmdbSyncUploadCSV 上传 CSV 文件并同步，dry_run=true 时只返回变更
+func (rt *Router) cmdbSyncUploadCSV(c *gin.Context) {
+	s := rt.cmdbSyncCheck(c)
+	if s.SourceType != models.CmdbSourceCSV {
+		ginx.Bomb(http.StatusBadRequest, "only csv source accepts file upload")
+	}
+
+	file, _, err := c.Request.FormFile("file")
+	ginx.Dangerous(err)
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxCmdbCSVSize+1))
+	ginx.Dangerous(err)
+	if len(data) > maxCmdbCSVSize {
+		ginx.Bomb(http.StatusBadRequest, "csv size exceeds 32MB limit")
+	}
+
+	records, err := cmdb.ParseCSV(bytes.NewReader(data))
+	if err != nil {
+		ginx.Bomb(http.StatusBadRequest, "invalid csv: %v", err)
+	}
+
+	report, err := cmdb.Run(rt.Ctx, s, records, ginx.QueryBool(c, "dry_run", false))
+	ginx.NewRender(c).Data(report, err)
+}
@@ -0,0 +1,164 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ccfos/nightingale/v6/center/audit"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+	"github.com/ccfos/nightingale/v6/pkg/slice"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 查看版本需要对象的查看权限，回滚需要对象的修改权限
+var revisionPerms = map[string][2]string{
+	models.RevisionAlertRule:       {"/alert-rules", "/alert-rules/put"},
+	models.RevisionNotifyRule:      {"/notification-rules", "/notification-rules/put"},
+	models.RevisionEventPipeline:   {"/event-pipelines", "/event-pipelines/put"},
+	models.RevisionMessageTemplate: {"/notification-templates", "/notification-templates/put"},
+	models.RevisionBoard:           {"/dashboards", "/dashboards/put"},
+}
+
+func (rt *Router) checkRevisionPermission(c *gin.Context, typ string, id int64, write bool) {
+	perms, has := revisionPerms[typ]
+	if !has {
+		ginx.Bomb(http.StatusBadRequest, "invalid resource_type: %s", typ)
+	}
+
+	me := c.MustGet("user").(*models.User)
+	op := perms[0]
+	if write {
+		op = perms[1]
+	}
+	can, err := me.CheckPerm(rt.Ctx, op)
+	ginx.Dangerous(err)
+	if !can {
+		ginx.Bomb(http.StatusForbidden, "forbidden")
+	}
+
+	bgCheck := rt.bgroCheck
+	if write {
+		bgCheck = rt.bgrwCheck
+	}
+
+	switch typ {
+	case models.RevisionAlertRule:
+		ar, err := models.AlertRuleGetById(rt.Ctx, id)
+		ginx.Dangerous(err)
+		if ar == nil {
+			ginx.Bomb(http.StatusNotFound, "No such AlertRule")
+		}
+		bgCheck(c, ar.GroupId)
+	case models.RevisionNotifyRule:
+		nr, err := models.NotifyRuleGet(rt.Ctx, "id = ?", id)
+		ginx.Dangerous(err)
+		if nr == nil {
+			ginx.Bomb(http.StatusNotFound, "notify rule not found")
+		}
+		checkTenant(c, nr.TenantId)
+		rt.checkTeamsPermission(c, me, nr.UserGroupIds)
+	case models.RevisionEventPipeline:
+		pipeline, err := models.GetEventPipeline(rt.Ctx, id)
+		if err != nil {
+			ginx.Bomb(http.StatusNotFound, "No such event pipeline")
+		}
+		flag := "ro"
+		if write {
+			flag = "rw"
+		}
+		rt.checkEventPipelinePermission(c, pipeline, flag)
+	case models.RevisionMessageTemplate:
+		mt, err := models.MessageTemplateGet(rt.Ctx, "id = ?", id)
+		ginx.Dangerous(err)
+		if mt == nil {
+			ginx.Bomb(http.StatusNotFound, "message template not found")
+		}
+		checkTenantShared(c, mt.TenantId, write)
+		if write || mt.Private == 1 {
+			rt.checkTeamsPermission(c, me, mt.UserGroupIds)
+		}
+	case models.RevisionBoard:
+		bo, err := models.BoardGetByID(rt.Ctx, id)
+		ginx.Dangerous(err)
+		if bo == nil {
+			ginx.Bomb(http.StatusNotFound, "No such dashboard")
+		}
+		if !me.IsSuperAdmin() {
+			bgCheck(c, bo.GroupId)
+		}
+	}
+}
+
+func (rt *Router) checkTeamsPermission(c *gin.Context, me *models.User, teamIds []int64) {
+	if me.IsAdmin() {
+		return
+	}
+
+	gids, err := models.MyGroupIds(rt.Ctx, me.Id)
+	ginx.Dangerous(err)
+	if !slice.HaveIntersection(gids, teamIds) {
+		ginx.Bomb(http.StatusForbidden, "forbidden")
+	}
+}
+
+func (rt *Router) configRevisionGets(c *gin.Context) {
+	typ := ginx.QueryStr(c, "resource_type")
+	id := ginx.QueryInt64(c, "resource_id")
+	rt.checkRevisionPermission(c, typ, id, false)
+
+	lst, err := models.ConfigRevisionGets(rt.Ctx, typ, id)
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func (rt *Router) configRevisionGetAndCheck(c *gin.Context, id int64, write bool) *models.ConfigRevision {
+	r, err := models.ConfigRevisionGetById(rt.Ctx, id)
+	ginx.Dangerous(err)
+	if r == nil {
+		ginx.Bomb(http.StatusNotFound, "No such revision")
+	}
+
+	rt.checkRevisionPermission(c, r.ResourceType, r.ResourceId, write)
+	return r
+}
+
+func (rt *Router) configRevisionGet(c *gin.Context) {
+	r := rt.configRevisionGetAndCheck(c, ginx.UrlParamInt64(c, "id"), false)
+	ginx.NewRender(c).Data(r, nil)
+}
+
+// configRevisionDiff 按字段对比两个版本，嵌套字段以点分隔，数组整体比较。
+// 敏感字段有变化时值会脱敏，并标记 redacted
+func (rt *Router) configRevisionDiff(c *gin.Context) {
+	from := rt.configRevisionGetAndCheck(c, ginx.QueryInt64(c, "from"), false)
+	to := rt.configRevisionGetAndCheck(c, ginx.QueryInt64(c, "to"), false)
+	if from.ResourceType != to.ResourceType || from.ResourceId != to.ResourceId {
+		ginx.Bomb(http.StatusBadRequest, "revisions belong to different objects")
+	}
+
+	ginx.NewRender(c).Data(gin.H{
+		"from":    from.Version,
+		"to":      to.Version,
+		"changes": audit.DiffJSON([]byte(from.Content), []byte(to.Content)),
+	}, nil)
+}
+
+// configRevisionRestore 用指定版本覆盖当前配置，生成一个新版本，原有版本保持不变
+func (rt *Router) configRevisionRestore(c *gin.Context) {
+	r := rt.configRevisionGetAndCheck(c, ginx.UrlParamInt64(c, "id"), true)
+
+	// 旧版本可能指向当前用户没有查询权限的数据源，和保存规则一样检查。
+	// 回滚只覆盖已有的规则且不改变业务组，规则数量不变，不需要检查配额
+	if r.ResourceType == models.RevisionAlertRule {
+		var ar models.AlertRule
+		ginx.Dangerous(json.Unmarshal([]byte(r.Content), &ar))
+		if err := rt.checkRuleDatasources(c, &ar); err != nil {
+			ginx.Bomb(http.StatusForbidden, "%s", err.Error())
+		}
+	}
+
+	me := c.MustGet("user").(*models.User)
+	ret, err := models.ConfigRevisionRestore(rt.Ctx, r, me.Username)
+	ginx.NewRender(c).Data(ret, err)
+}
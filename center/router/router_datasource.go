@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ccfos/nightingale/v6/center/eventbus"
 	"github.com/ccfos/nightingale/v6/datasource/opensearch"
 	"github.com/ccfos/nightingale/v6/dskit/clickhouse"
 	"github.com/ccfos/nightingale/v6/models"
@@ -186,6 +187,16 @@ func (rt *Router) datasourceUpsert(c *gin.Context) {
 
 	var req models.Datasource
 	ginx.BindJSON(c, &req)
+	// 新建数据源只有管理员可以，修改已有的数据源需要 admin 授权
+	me := c.MustGet("user").(*models.User)
+	if req.Id == 0 {
+		if !me.IsAdmin() {
+			ginx.Bomb(http.StatusForbidden, "forbidden")
+		}
+		req.TenantId = me.TenantId
+	} else {
+		rt.checkDsAdminPerm(c, req.Id)
+	}
 	username := Username(c)
 	req.UpdatedBy = username
 
@@ -351,7 +362,9 @@ func (rt *Router) datasourceUpsert(c *gin.Context) {
 		}
 	}
 
+	action := eventbus.ActionUpdated
 	if req.Id == 0 {
+		action = eventbus.ActionCreated
 		req.CreatedBy = username
 		req.Status = "enabled"
 		count, err = models.GetDatasourcesCountBy(rt.Ctx, "", "", req.Name)
@@ -379,6 +392,7 @@ func (rt *Router) datasourceUpsert(c *gin.Context) {
 	if syncErr := rt.DatasourceCache.SyncOnce(); syncErr != nil {
 		logger.Warningf("sync datasource cache after upsert failed: %v", syncErr)
 	}
+	publishDatasource(action, username, &req)
 
 	// req.Add 经 gorm Create 回填自增 Id；前端保存结果页依赖 id 与 verification 建立上下文
 	Render(c, gin.H{
@@ -505,6 +519,7 @@ func (rt *Router) datasourceGet(c *gin.Context) {
 
 	var req models.Datasource
 	ginx.BindJSON(c, &req)
+	rt.checkDsAdminPerm(c, req.Id)
 	err := req.Get(rt.Ctx)
 	Render(c, req, err)
 }
@@ -517,9 +532,17 @@ func (rt *Router) datasourceUpdataStatus(c *gin.Context) {
 
 	var req models.Datasource
 	ginx.BindJSON(c, &req)
+	rt.checkDsAdminPerm(c, req.Id)
 	username := Username(c)
 	req.UpdatedBy = username
 	err := req.Update(rt.Ctx, "status", "updated_by", "updated_at")
+	if err == nil {
+		if ds := rt.DatasourceCache.GetById(req.Id); ds != nil {
+			dsc := *ds
+			dsc.Status = req.Status
+			publishDatasource(eventbus.ActionUpdated, username, &dsc)
+		}
+	}
 	Render(c, req, err)
 }
 
@@ -531,7 +554,30 @@ func (rt *Router) datasourceDel(c *gin.Context) {
 
 	var ids []int64
 	ginx.BindJSON(c, &ids)
+
+	// 租户管理员只能删除本租户的数据源
+	if !c.MustGet("user").(*models.User).IsAdmin() {
+		ginx.Bomb(http.StatusForbidden, "forbidden")
+	}
+	for _, id := range ids {
+		rt.checkDsAdminPerm(c, id)
+	}
+
+	deleted := make([]*models.Datasource, 0, len(ids))
+	for _, id := range ids {
+		if ds := rt.DatasourceCache.GetById(id); ds != nil {
+			deleted = append(deleted, ds)
+		} else {
+			deleted = append(deleted, &models.Datasource{Id: id})
+		}
+	}
+
 	err := models.DatasourceDel(rt.Ctx, ids)
+	if err == nil {
+		for _, ds := range deleted {
+			publishDatasource(eventbus.ActionDeleted, Username(c), ds)
+		}
+	}
 	Render(c, nil, err)
 }
 
@@ -558,9 +604,14 @@ func (rt *Router) datasourceQuery(c *gin.Context) {
 	datasources, err := models.GetDatasourcesGetsByTypes(rt.Ctx, []string{dsf.Cate})
 	ginx.Dangerous(err)
 
+	me := rt.aclUser(c)
+
 	nameToID := make(map[string]int64)
 	IDToName := make(map[int64]string)
 	for _, ds := range datasources {
+		if me != nil && !me.TenantVisible(ds.TenantId) {
+			continue
+		}
 		nameToID[ds.Name] = ds.Id
 		IDToName[ds.Id] = ds.Name
 	}
@@ -0,0 +1,170 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+// aclUser 返回当前请求的用户，只挂了 auth() 的接口里从 userid 查。匿名访问返回 nil
+func (rt *Router) aclUser(c *gin.Context) *models.User {
+	if v, has := c.Get("user"); has {
+		if user, ok := v.(*models.User); ok && user != nil {
+			return user
+		}
+	}
+
+	userid := c.GetInt64("userid")
+	if userid == 0 {
+		return nil
+	}
+
+	user, err := models.UserGetById(rt.Ctx, userid)
+	ginx.Dangerous(err)
+	if user == nil {
+		ginx.Bomb(http.StatusUnauthorized, "unauthorized")
+	}
+	c.Set("user", user)
+	return user
+}
+
+// checkDsQueryPerm 校验用户对数据源的查询权限。分享 token 由自己的逻辑校验，这里跳过
+func (rt *Router) checkDsQueryPerm(c *gin.Context, dsIds ...int64) {
+	if _, ok := boardTokenBid(c); ok {
+		return
+	}
+
+	user := rt.aclUser(c)
+	for _, dsId := range dsIds {
+		if user == nil {
+			// 匿名访问（AnonymousAccess.PromQuerier）只能查询没有配置授权、也不属于任何租户的数据源
+			if len(rt.DatasourceCache.GetAcls(dsId)) > 0 {
+				ginx.Bomb(http.StatusForbidden, "no permission to query datasource %d", dsId)
+			}
+			if ds := rt.DatasourceCache.GetById(dsId); ds != nil && ds.TenantId != 0 {
+				ginx.Bomb(http.StatusForbidden, "no permission to query datasource %d", dsId)
+			}
+			continue
+		}
+
+		// 没有授权的数据源对本租户的用户都开放，租户用户还要检查数据源的归属
+		if len(rt.DatasourceCache.GetAcls(dsId)) == 0 && user.TenantId == 0 {
+			continue
+		}
+
+		if !rt.DatasourceCache.AclAllowed(user, dsId, models.DsAclPermQuery) {
+			ginx.Bomb(http.StatusForbidden, "no permission to query datasource %d", dsId)
+		}
+	}
+}
+
+// checkDsAdminPerm 管理员或者有数据源 admin 授权的用户才能修改数据源
+func (rt *Router) checkDsAdminPerm(c *gin.Context, dsId int64) {
+	user := c.MustGet("user").(*models.User)
+	if !rt.DatasourceCache.AclAllowed(user, dsId, models.DsAclPermAdmin) {
+		ginx.Bomb(http.StatusForbidden, "forbidden")
+	}
+}
+
+// checkRuleDatasources 保存告警规则、记录规则时，规则关联的数据源都要有查询权限
+func (rt *Router) checkRuleDatasources(c *gin.Context, rule interface{}) error {
+	var (
+		cate    string
+		ids     []int64
+		queries []models.DatasourceQuery
+	)
+
+	switch r := rule.(type) {
+	case *models.AlertRule:
+		cate, ids, queries = r.Cate, r.DatasourceIdsJson, r.DatasourceQueries
+	case *models.RecordingRule:
+		cate, ids, queries = models.PROMETHEUS, r.DatasourceIdsJson, r.DatasourceQueries
+	default:
+		return nil
+	}
+
+	// 老的前端只传 datasource_ids，按 FillDatasourceQueries 的方式转换
+	if len(queries) == 0 && len(ids) > 0 {
+		q := models.DatasourceQuery{MatchType: 0, Op: "in"}
+		if models.IsAllDatasource(ids) {
+			q.Values = []interface{}{models.DatasourceIdAll}
+		} else {
+			for _, id := range ids {
+				q.Values = append(q.Values, id)
+			}
+		}
+		queries = []models.DatasourceQuery{q}
+	}
+
+	if len(queries) == 0 {
+		return nil
+	}
+
+	user := rt.aclUser(c)
+	if user == nil {
+		return nil
+	}
+
+	// 租户用户的通配规则只会匹配到本租户的数据源，和告警引擎的行为保持一致
+	for _, dsId := range rt.DatasourceCache.GetIDsByDsCateAndQueriesOfTenant(cate, queries, user.TenantId) {
+		if len(rt.DatasourceCache.GetAcls(dsId)) == 0 && user.TenantId == 0 {
+			continue
+		}
+
+		if !rt.DatasourceCache.AclAllowed(user, dsId, models.DsAclPermQuery) {
+			name := fmt.Sprint(dsId)
+			if ds := rt.DatasourceCache.GetById(dsId); ds != nil {
+				name = ds.Name
+			}
+			return fmt.Errorf("no permission to query datasource %s", name)
+		}
+	}
+	return nil
+}
+
+func (rt *Router) datasourceAclGets(c *gin.Context) {
+	dsId := ginx.UrlParamInt64(c, "id")
+	rt.checkDsAdminPerm(c, dsId)
+
+	lst, err := models.DatasourceAclGets(rt.Ctx, dsId)
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func (rt *Router) datasourceAclPut(c *gin.Context) {
+	dsId := ginx.UrlParamInt64(c, "id")
+	rt.checkDsAdminPerm(c, dsId)
+
+	ds, err := models.DatasourceGet(rt.Ctx, dsId)
+	ginx.Dangerous(err)
+	if ds == nil {
+		ginx.Bomb(http.StatusNotFound, "no such datasource")
+	}
+
+	var acls []*models.DatasourceAcl
+	ginx.BindJSON(c, &acls)
+
+	user := c.MustGet("user").(*models.User)
+	if !user.IsAdmin() {
+		// 非管理员不能去掉自己的 admin 授权，否则改完之后就管不了了，也不能清空授权把数据源开放给所有人
+		ugids, err := models.MyGroupIds(rt.Ctx, user.Id)
+		ginx.Dangerous(err)
+		bgids, err := models.BusiGroupIds(rt.Ctx, ugids)
+		ginx.Dangerous(err)
+		if !models.DatasourceAclAllowed(acls, ugids, bgids, models.DsAclPermAdmin) {
+			ginx.Bomb(http.StatusBadRequest, "you can not remove your own admin permission")
+		}
+	}
+
+	ginx.Dangerous(models.DatasourceAclReplace(rt.Ctx, dsId, acls, user.Username))
+
+	// 授权变更在本实例上立即生效，不等下一个同步周期
+	all, err := models.DatasourceAclGetMap(rt.Ctx)
+	ginx.Dangerous(err)
+	rt.DatasourceCache.SetAcls(all)
+
+	ginx.NewRender(c).Message(nil)
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/memsto"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/aop"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDatasourceAcl(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Datasource{}, &models.DatasourceAcl{}, &models.User{},
+		&models.UserGroupMember{}, &models.BusiGroupMember{}, &models.BusiGroup{}, &models.AlertRule{}, &models.Tenant{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ds := range []*models.Datasource{
+		{Id: 1, Name: "billing", PluginType: models.PROMETHEUS, Settings: "{}", HTTP: "{}", Auth: "{}", UpdatedAt: 1},
+		{Id: 2, Name: "public", PluginType: models.PROMETHEUS, Settings: "{}", HTTP: "{}", Auth: "{}", UpdatedAt: 1},
+	} {
+		if err := db.Create(ds).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Create(&models.User{Id: 1, Username: "root", Roles: models.AdminRole, Contacts: []byte("{}")})
+	db.Create(&models.User{Id: 2, Username: "alice", Roles: "Standard", Contacts: []byte("{}")})
+	db.Create(&models.User{Id: 3, Username: "bob", Roles: "Standard", Contacts: []byte("{}")})
+	// alice 在团队 10，团队 10 属于业务组 100；bob 不在任何团队
+	db.Create(&models.UserGroupMember{GroupId: 10, UserId: 2})
+	db.Create(&models.BusiGroupMember{BusiGroupId: 100, UserGroupId: 10, PermFlag: "ro"})
+
+	c := &ctx.Context{DB: db, IsCenter: true}
+	if err := models.DatasourceAclReplace(c, 1, []*models.DatasourceAcl{
+		{SubjectType: models.DsAclSubjectBusiGroup, SubjectId: 100, Perm: models.DsAclPermQuery},
+	}, "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := &memsto.Stats{
+		GaugeCronDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "duration"}, []string{"name"}),
+		GaugeSyncNumber:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "sync_number"}, []string{"name"}),
+	}
+	rt := &Router{Ctx: c, DatasourceCache: memsto.NewDatasourceCache(c, stats)}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(aop.Recovery())
+	r.GET("/query/:uid/:ds", func(c *gin.Context) {
+		c.Set("userid", ginx.UrlParamInt64(c, "uid"))
+		rt.checkDsQueryPerm(c, ginx.UrlParamInt64(c, "ds"))
+		c.String(http.StatusOK, "ok")
+	})
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/query/1/1", http.StatusOK},        // 管理员不受限制
+		{"/query/2/1", http.StatusOK},        // 通过业务组授权
+		{"/query/3/1", http.StatusForbidden}, // 没有授权
+		{"/query/3/2", http.StatusOK},        // 没有配置授权的数据源对所有人开放
+		{"/query/0/1", http.StatusForbidden}, // 匿名访问不能查询配置了授权的数据源
+		{"/query/0/2", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d %s", tc.path, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	bob, _ := models.UserGetById(c, 3)
+	if lst := rt.DatasourceCache.DatasourceFilter([]*models.Datasource{{Id: 1}, {Id: 2}}, bob); len(lst) != 1 || lst[0].Id != 2 {
+		t.Fatalf("unexpected filter result: %+v", lst)
+	}
+
+	// 保存规则时，匹配到的数据源都要有查询权限
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Set("user", bob)
+	rule := &models.AlertRule{Cate: models.PROMETHEUS, DatasourceQueries: []models.DatasourceQuery{models.DataSourceQueryAll}}
+	if err := rt.checkRuleDatasources(gc, rule); err == nil {
+		t.Fatal("rule on all datasources should be rejected")
+	}
+	rule = &models.AlertRule{Cate: models.PROMETHEUS, DatasourceIdsJson: []int64{2}}
+	if err := rt.checkRuleDatasources(gc, rule); err != nil {
+		t.Fatalf("rule on an open datasource: %v", err)
+	}
+
+	// 授权同步到缓存后生效
+	if err := models.DatasourceAclReplace(c, 1, nil, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.DatasourceCache.SyncOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.checkRuleDatasources(gc, &models.AlertRule{Cate: models.PROMETHEUS, DatasourceIdsJson: []int64{0}}); err != nil {
+		t.Fatalf("acl removed but still rejected: %v", err)
+	}
+
+	// 克隆规则时也要有源规则数据源的查询权限
+	db.Create(&models.BusiGroup{Id: 100, Name: "billing"})
+	db.Create(&models.BusiGroup{Id: 200, Name: "sandbox"})
+	db.Create(&models.BusiGroupMember{BusiGroupId: 200, UserGroupId: 10, PermFlag: "rw"})
+	if err := models.DatasourceAclReplace(c, 2, []*models.DatasourceAcl{
+		{SubjectType: models.DsAclSubjectBusiGroup, SubjectId: 300, Perm: models.DsAclPermQuery},
+	}, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.DatasourceCache.SyncOnce(); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.AlertRule{Id: 1, GroupId: 100, Name: "billing-rule", Cate: models.PROMETHEUS, DatasourceIds: "[2]",
+		RuleConfig: `{"queries":[{"prom_ql":"up == 0","severity":2}]}`})
+
+	alice, _ := models.UserGetById(c, 2)
+	r.POST("/clones", func(c *gin.Context) {
+		c.Set("user", alice)
+		c.Set("username", alice.Username)
+		c.Next()
+	}, rt.batchAlertRuleClone)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/clones", strings.NewReader(`{"rule_ids":[1],"bgids":[200]}`)))
+	var ret struct {
+		Dat map[string]string `json:"dat"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("clone: %d %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(ret.Dat["1-200"], "no permission to query datasource") {
+		t.Fatalf("clone of a rule on a protected datasource should be rejected: %s", w.Body.String())
+	}
+}
@@ -32,6 +32,7 @@ func (rt *Router) ShowDatabases(c *gin.Context) {
 	var f models.QueryParam
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logx.Warningf(c.Request.Context(), "cluster:%d not exists", f.DatasourceId)
@@ -63,6 +64,7 @@ func (rt *Router) ShowTables(c *gin.Context) {
 	var f models.QueryParam
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logx.Warningf(c.Request.Context(), "cluster:%d not exists", f.DatasourceId)
@@ -106,6 +108,7 @@ func (rt *Router) DescribeTable(c *gin.Context) {
 	var f models.QueryParam
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logx.Warningf(c.Request.Context(), "cluster:%d not exists", f.DatasourceId)
@@ -32,6 +32,7 @@ func (rt *Router) QueryIndices(c *gin.Context) {
 	var f IndexReq
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logx.Warningf(c.Request.Context(), "cluster:%d not exists", f.DatasourceId)
@@ -48,6 +49,7 @@ func (rt *Router) QueryFields(c *gin.Context) {
 	var f IndexReq
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logx.Warningf(c.Request.Context(), "cluster:%d not exists", f.DatasourceId)
@@ -64,6 +66,7 @@ func (rt *Router) QueryESVariable(c *gin.Context) {
 	var f FieldValueReq
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logx.Warningf(c.Request.Context(), "cluster:%d not exists", f.DatasourceId)
@@ -84,6 +87,7 @@ func (rt *Router) ESClusterInfo(c *gin.Context) {
 	}
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logx.Warningf(c.Request.Context(), "cluster:%d not exists", f.DatasourceId)
@@ -72,7 +72,7 @@ func (rt *Router) eventPipelinesList(c *gin.Context) {
 
 	// 业务组场景：使用业务组鉴权，admin 或有权限的用户可见
 	if groupId > 0 {
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			bg := BusiGroup(rt.Ctx, groupId)
 			can, err := me.CanDoBusiGroup(rt.Ctx, bg)
 			ginx.Dangerous(err)
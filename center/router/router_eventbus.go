@@ -0,0 +1,71 @@
+package router
+
+import (
+	"github.com/ccfos/nightingale/v6/center/eventbus"
+	"github.com/ccfos/nightingale/v6/models"
+
+	"github.com/toolkits/pkg/logger"
+)
+
+// publishAlertRules 重新读取规则再发布，保证事件里是落库后的完整内容
+func (rt *Router) publishAlertRules(action, operator string, ids []int64) {
+	if !eventbus.Enabled() || len(ids) == 0 {
+		return
+	}
+
+	lst, err := models.AlertRuleGetsByIds(rt.Ctx, ids)
+	if err != nil {
+		logger.Warningf("eventbus: failed to get alert rules %v: %v", ids, err)
+		return
+	}
+
+	publishAlertRuleLst(action, operator, lst)
+}
+
+func publishAlertRuleLst(action, operator string, lst []models.AlertRule) {
+	for i := range lst {
+		eventbus.Publish(eventbus.NewEvent(eventbus.ObjectAlertRule, action, lst[i].Id, lst[i].GroupId, operator, lst[i]))
+	}
+}
+
+// alertRulesBeforeDel 删除之后就查不到了，先把要删的规则读出来
+func (rt *Router) alertRulesBeforeDel(ids []int64, bgid ...int64) []models.AlertRule {
+	if !eventbus.Enabled() {
+		return nil
+	}
+
+	lst, err := models.AlertRuleGetsByIds(rt.Ctx, ids)
+	if err != nil {
+		logger.Warningf("eventbus: failed to get alert rules %v: %v", ids, err)
+		return nil
+	}
+
+	if len(bgid) == 0 {
+		return lst
+	}
+
+	ret := make([]models.AlertRule, 0, len(lst))
+	for i := range lst {
+		if lst[i].GroupId == bgid[0] {
+			ret = append(ret, lst[i])
+		}
+	}
+	return ret
+}
+
+func publishDatasource(action, operator string, ds *models.Datasource) {
+	if !eventbus.Enabled() {
+		return
+	}
+
+	// 不带 auth 等敏感配置
+	data := map[string]interface{}{
+		"id":           ds.Id,
+		"name":         ds.Name,
+		"plugin_type":  ds.PluginType,
+		"category":     ds.Category,
+		"cluster_name": ds.ClusterName,
+		"status":       ds.Status,
+	}
+	eventbus.Publish(eventbus.NewEvent(eventbus.ObjectDatasource, action, ds.Id, 0, operator, data))
+}
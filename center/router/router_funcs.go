@@ -30,6 +30,10 @@ func (rt *Router) statistic(c *gin.Context) {
 		model = models.BusiGroup{}
 	case "recording_rule":
 		model = models.RecordingRule{}
+	case "scrape_job":
+		model = models.ScrapeJob{}
+	case "ingest_token":
+		model = models.IngestToken{}
 	case "target":
 		model = models.Target{}
 	case "user":
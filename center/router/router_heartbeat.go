@@ -85,6 +85,7 @@ func HandleHeartbeat(c *gin.Context, ctx *ctx.Context, engineName string, metaSe
 		overwriteGids := ginx.QueryBool(c, "overwrite_gids", false)
 		hostIp := strings.TrimSpace(req.HostIp)
 		gids := strings.Split(gidsStr, ",")
+		bgChanged := false
 
 		if overwriteGids {
 			groupIds := make([]int64, 0)
@@ -103,6 +104,8 @@ func HandleHeartbeat(c *gin.Context, ctx *ctx.Context, engineName string, metaSe
 			err := models.TargetOverrideBgids(ctx, []string{target.Ident}, groupIds, nil)
 			if err != nil {
 				logger.Warningf("update target:%s group ids failed, err: %v", target.Ident, err)
+			} else {
+				bgChanged = true
 			}
 		} else if gidsStr != "" {
 			for i := range gids {
@@ -116,16 +119,31 @@ func HandleHeartbeat(c *gin.Context, ctx *ctx.Context, engineName string, metaSe
 					err := models.TargetBindBgids(ctx, []string{target.Ident}, []int64{groupId}, nil)
 					if err != nil {
 						logger.Warningf("update target:%s group ids failed, err: %v", target.Ident, err)
+					} else {
+						bgChanged = true
 					}
 				}
 			}
 		}
 
+		var history []*models.TargetHistory
+		if bgChanged {
+			after, err := models.TargetGroupIdsGetByIdent(ctx, target.Ident)
+			if err != nil {
+				logger.Warningf("get target:%s group ids failed, err: %v", target.Ident, err)
+			} else {
+				history = models.TargetHistoryOfBgids(map[string][]int64{target.Ident: target.GroupIds},
+					map[string][]int64{target.Ident: after}, []string{target.Ident}, models.TargetOperatorHeartbeat)
+			}
+		}
+
 		newTarget := models.Target{}
 		targetNeedUpdate := false
 		if hostIp != "" && hostIp != target.HostIp {
 			newTarget.HostIp = hostIp
 			targetNeedUpdate = true
+			history = append(history, models.NewTargetHistory(target.Ident, models.TargetHistoryFieldHostIp,
+				target.HostIp, hostIp, models.TargetOperatorHeartbeat))
 		}
 
 		hostTagsMap := target.GetHostTagsMap()
@@ -153,6 +171,11 @@ func HandleHeartbeat(c *gin.Context, ctx *ctx.Context, engineName string, metaSe
 			sort.Strings(lst)
 			newTarget.HostTags = lst
 			targetNeedUpdate = true
+
+			oldTags := append([]string{}, target.HostTags...)
+			sort.Strings(oldTags)
+			history = append(history, models.NewTargetHistory(target.Ident, models.TargetHistoryFieldHostTags,
+				strings.Join(oldTags, " "), strings.Join(lst, " "), models.TargetOperatorHeartbeat))
 		}
 
 		userTagsMap := target.GetTagsMap()
@@ -178,16 +201,22 @@ func HandleHeartbeat(c *gin.Context, ctx *ctx.Context, engineName string, metaSe
 		if req.EngineName != "" && req.EngineName != target.EngineName {
 			newTarget.EngineName = req.EngineName
 			targetNeedUpdate = true
+			history = append(history, models.NewTargetHistory(target.Ident, models.TargetHistoryFieldEngineName,
+				target.EngineName, req.EngineName, models.TargetOperatorHeartbeat))
 		}
 
 		if req.AgentVersion != "" && req.AgentVersion != target.AgentVersion {
 			newTarget.AgentVersion = req.AgentVersion
 			targetNeedUpdate = true
+			history = append(history, models.NewTargetHistory(target.Ident, models.TargetHistoryFieldAgentVersion,
+				target.AgentVersion, req.AgentVersion, models.TargetOperatorHeartbeat))
 		}
 
 		if req.OS != "" && req.OS != target.OS {
 			newTarget.OS = req.OS
 			targetNeedUpdate = true
+			history = append(history, models.NewTargetHistory(target.Ident, models.TargetHistoryFieldOS,
+				target.OS, req.OS, models.TargetOperatorHeartbeat))
 		}
 
 		if targetNeedUpdate {
@@ -197,6 +226,10 @@ func HandleHeartbeat(c *gin.Context, ctx *ctx.Context, engineName string, metaSe
 				logger.Errorf("update target fields failed, err: %v", err)
 			}
 		}
+
+		if err := models.TargetHistoryAdd(ctx, history); err != nil {
+			logger.Warningf("add target:%s history failed, err: %v", target.Ident, err)
+		}
 		logger.Debugf("heartbeat field:%+v target: %v", newTarget, *target)
 	}
 
@@ -0,0 +1,60 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingestTokenGets 返回明文 token，拿到就能以业务组的身份写入数据，路由上要求业务组读写权限
+func (rt *Router) ingestTokenGets(c *gin.Context) {
+	lst, err := models.IngestTokenGets(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func (rt *Router) ingestTokenGetsByService(c *gin.Context) {
+	lst, err := models.IngestTokenEnabledGets(rt.Ctx)
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func (rt *Router) ingestTokenAdd(c *gin.Context) {
+	var f models.IngestToken
+	ginx.BindJSON(c, &f)
+
+	username := c.MustGet("username").(string)
+	f.Id = 0
+	f.GroupId = ginx.UrlParamInt64(c, "id")
+	f.CreateBy = username
+	f.UpdateBy = username
+
+	ginx.Dangerous(f.Add(rt.Ctx))
+	ginx.NewRender(c).Data(f, nil)
+}
+
+func (rt *Router) ingestTokenPut(c *gin.Context) {
+	var f models.IngestToken
+	ginx.BindJSON(c, &f)
+
+	tk, err := models.IngestTokenGetById(rt.Ctx, ginx.UrlParamInt64(c, "itid"))
+	ginx.Dangerous(err)
+
+	if tk == nil {
+		ginx.Bomb(http.StatusNotFound, "No such ingest token")
+	}
+
+	rt.bgrwCheck(c, tk.GroupId)
+
+	f.UpdateBy = c.MustGet("username").(string)
+	ginx.NewRender(c).Message(tk.Update(rt.Ctx, f))
+}
+
+func (rt *Router) ingestTokenDel(c *gin.Context) {
+	var f idsForm
+	ginx.BindJSON(c, &f)
+	f.Verify()
+
+	ginx.NewRender(c).Message(models.IngestTokenDels(rt.Ctx, f.Ids, ginx.UrlParamInt64(c, "id")))
+}
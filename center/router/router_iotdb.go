@@ -13,6 +13,7 @@ func (rt *Router) iotdbDatabases(c *gin.Context) {
 	var f databasesQueryForm
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	datasource, hit := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if _, ok := datasource.(*iotdb.IoTDB); !hit || !ok {
 		ginx.NewRender(c, http.StatusNotFound).Message("No such datasource")
@@ -27,6 +28,7 @@ func (rt *Router) iotdbTables(c *gin.Context) {
 	var f tablesQueryForm
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	datasource, hit := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if _, ok := datasource.(*iotdb.IoTDB); !hit || !ok {
 		ginx.NewRender(c, http.StatusNotFound).Message("No such datasource")
@@ -41,6 +43,7 @@ func (rt *Router) iotdbColumns(c *gin.Context) {
 	var f columnsQueryForm
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	datasource, hit := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if _, ok := datasource.(*iotdb.IoTDB); !hit || !ok {
 		ginx.NewRender(c, http.StatusNotFound).Message("No such datasource")
@@ -17,11 +17,13 @@ import (
 	"github.com/ccfos/nightingale/v6/pkg/logx"
 	"github.com/ccfos/nightingale/v6/pkg/oauth2x"
 	"github.com/ccfos/nightingale/v6/pkg/oidcx"
+	"github.com/ccfos/nightingale/v6/pkg/samlx"
 	"github.com/ccfos/nightingale/v6/pkg/secu"
 	"github.com/ccfos/nightingale/v6/pkg/ginx"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/pelletier/go-toml/v2"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -88,11 +90,20 @@ func (rt *Router) loginPost(c *gin.Context) {
 		return
 	}
 
+	if user.Disabled == 1 {
+		ginx.NewRender(c).Message("User is disabled")
+		return
+	}
+
+	if rt.loginChallenge(c, user) {
+		return
+	}
+
 	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
 
 	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
 	ginx.Dangerous(err)
-	ginx.Dangerous(rt.createAuth(c.Request.Context(), userIdentity, ts))
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
 
 	ginx.NewRender(c).Data(gin.H{
 		"user":          user,
@@ -136,6 +147,8 @@ func (rt *Router) logoutPost(c *gin.Context) {
 		logoutAddr = rt.Sso.CAS.GetSsoLogoutAddr()
 	case "oauth2":
 		logoutAddr = rt.Sso.OAuth2.GetSsoLogoutAddr()
+	case "saml":
+		logoutAddr = rt.Sso.SAML.GetSsoLogoutAddr()
 	}
 
 	ginx.NewRender(c).Data(logoutAddr, nil)
@@ -206,6 +219,11 @@ func (rt *Router) refreshPost(c *gin.Context) {
 			return
 		}
 
+		if u.Disabled == 1 {
+			ginx.NewRender(c, http.StatusUnauthorized).Message("User is disabled")
+			return
+		}
+
 		// Delete the previous Refresh Token
 		err = rt.deleteAuth(c.Request.Context(), refreshUuid)
 		if err != nil {
@@ -216,10 +234,14 @@ func (rt *Router) refreshPost(c *gin.Context) {
 		// Delete previous Access Token
 		rt.deleteAuth(c.Request.Context(), strings.Split(refreshUuid, "++")[0])
 
-		// Create new pairs of refresh and access tokens
-		ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
+		// Create new pairs of refresh and access tokens, 沿用原来的会话
+		sessionId, _ := claims["session_id"].(string)
+		if sessionId == "" {
+			sessionId = uuid.NewString()
+		}
+		ts, err := rt.createSessionTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity, sessionId)
 		ginx.Dangerous(err)
-		ginx.Dangerous(rt.createAuth(c.Request.Context(), userIdentity, ts))
+		ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
 
 		// 延长 id_token 的过期时间，使其与新的 refresh token 生命周期保持一致
 		// 注意：这里不会获取新的 id_token，只是延长 Redis 中现有 id_token 的 TTL
@@ -315,7 +337,7 @@ func (rt *Router) loginCallback(c *gin.Context) {
 	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
 	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
 	ginx.Dangerous(err)
-	ginx.Dangerous(rt.createAuth(rctx, userIdentity, ts))
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
 
 	// 保存 id_token 到 Redis，用于登出时使用
 	if ret.IdToken != "" {
@@ -409,7 +431,7 @@ func (rt *Router) loginCallbackCas(c *gin.Context) {
 		logx.Errorf(rctx, "createTokens: %s", err)
 	}
 	ginx.Dangerous(err)
-	ginx.Dangerous(rt.createAuth(rctx, userIdentity, ts))
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
 
 	redirect := "/"
 	if ret.Redirect != "/login" {
@@ -512,7 +534,7 @@ func (rt *Router) loginCallbackDingTalk(c *gin.Context) {
 	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
 	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
 	ginx.Dangerous(err)
-	ginx.Dangerous(rt.createAuth(c.Request.Context(), userIdentity, ts))
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
 
 	redirect := "/"
 	if ret.Redirect != "/login" {
@@ -602,7 +624,7 @@ func (rt *Router) loginCallbackFeiShu(c *gin.Context) {
 	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
 	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
 	ginx.Dangerous(err)
-	ginx.Dangerous(rt.createAuth(c.Request.Context(), userIdentity, ts))
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
 
 	redirect := "/"
 	if ret.Redirect != "/login" {
@@ -649,7 +671,7 @@ func (rt *Router) loginCallbackOAuth(c *gin.Context) {
 	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
 	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
 	ginx.Dangerous(err)
-	ginx.Dangerous(rt.createAuth(c.Request.Context(), userIdentity, ts))
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
 
 	redirect := "/"
 	if ret.Redirect != "/login" {
@@ -664,16 +686,152 @@ func (rt *Router) loginCallbackOAuth(c *gin.Context) {
 	}, nil)
 }
 
+func (rt *Router) samlMetadata(c *gin.Context) {
+	bs, err := rt.Sso.SAML.Metadata()
+	if err != nil {
+		ginx.NewRender(c, http.StatusNotFound).Message(err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", bs)
+}
+
+func (rt *Router) loginRedirectSaml(c *gin.Context) {
+	redirect := ginx.QueryStr(c, "redirect", "/")
+
+	v, exists := c.Get("userid")
+	if exists {
+		userid := v.(int64)
+		user, err := models.UserGetById(rt.Ctx, userid)
+		ginx.Dangerous(err)
+		if user == nil {
+			ginx.Bomb(200, "user not found")
+		}
+
+		if user.Username != "" { // already login
+			ginx.NewRender(c).Data(redirect, nil)
+			return
+		}
+	}
+
+	if !rt.Sso.SAML.Enable {
+		ginx.NewRender(c).Data("", nil)
+		return
+	}
+
+	redirect, err := rt.Sso.SAML.Authorize(rt.Redis, redirect)
+	ginx.Dangerous(err)
+
+	ginx.NewRender(c).Data(redirect, err)
+}
+
+// loginAcsSaml 是 SP 的 Assertion Consumer Service，IdP 通过浏览器 POST SAMLResponse 到这里。
+// 校验通过后带一次性 code 跳转到前端页面，前端再用 code 调用 loginCallbackSaml 完成登录
+func (rt *Router) loginAcsSaml(c *gin.Context) {
+	rctx := c.Request.Context()
+
+	code, err := rt.Sso.SAML.Callback(rt.Redis, rctx, c.PostForm("SAMLResponse"))
+	if err != nil {
+		logx.Errorf(rctx, "sso_callback saml fail. error: %v", err)
+		c.Redirect(http.StatusFound, rt.Sso.SAML.RedirectURL("error", err.Error()))
+		return
+	}
+
+	c.Redirect(http.StatusFound, rt.Sso.SAML.RedirectURL("code", code))
+}
+
+func (rt *Router) loginCallbackSaml(c *gin.Context) {
+	rctx := c.Request.Context()
+	code := ginx.QueryStr(c, "code", "")
+
+	ret, err := rt.Sso.SAML.Exchange(rt.Redis, rctx, code)
+	if err != nil {
+		logx.Errorf(rctx, "sso_callback saml fail. code:%s, error: %v", code, err)
+		ginx.NewRender(c).Data(CallbackOutput{}, err)
+		return
+	}
+
+	if ret.Username == "" {
+		ginx.Bomb(http.StatusBadRequest, "username is empty in saml assertion")
+	}
+
+	user, err := models.UserGet(rt.Ctx, "username=?", ret.Username)
+	ginx.Dangerous(err)
+
+	sc := rt.Sso.SAML
+	if user != nil {
+		if sc.CoverAttributes {
+			var rolesForUpdate []string
+			if sc.CoverRoles {
+				rolesForUpdate = ret.Roles
+				if len(rolesForUpdate) == 0 {
+					rolesForUpdate = sc.DefaultRoles
+				}
+			}
+			updatedFields := user.UpdateSsoFieldsWithRoles("saml", ret.Nickname, ret.Phone, ret.Email, rolesForUpdate)
+			ginx.Dangerous(user.Update(rt.Ctx, "update_at", updatedFields...))
+		}
+
+		teams := ret.Teams
+		if len(teams) == 0 {
+			teams = sc.DefaultTeams
+		}
+		if err := models.UserGroupMemberSync(rt.Ctx, teams, user.Id, sc.CoverTeams); err != nil {
+			logx.Errorf(rctx, "user:%v UserGroupMemberSync: %s", user, err)
+		}
+	} else {
+		roles := ret.Roles
+		if len(roles) == 0 {
+			roles = sc.DefaultRoles
+		}
+
+		user = new(models.User)
+		user.FullSsoFields("saml", ret.Username, ret.Nickname, ret.Phone, ret.Email, roles)
+		// create user from saml
+		ginx.Dangerous(user.Add(rt.Ctx))
+
+		teams := ret.Teams
+		if len(teams) == 0 {
+			teams = sc.DefaultTeams
+		}
+		for _, gid := range teams {
+			err = models.UserGroupMemberAdd(rt.Ctx, gid, user.Id)
+			if err != nil {
+				logx.Errorf(rctx, "user:%v UserGroupMemberAdd: %s", user, err)
+			}
+		}
+	}
+
+	// set user login state
+	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
+	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
+	ginx.Dangerous(err)
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
+
+	redirect := "/"
+	if ret.Redirect != "/login" && ret.Redirect != "" {
+		redirect = ret.Redirect
+	}
+
+	ginx.NewRender(c).Data(CallbackOutput{
+		Redirect:     redirect,
+		User:         user,
+		AccessToken:  ts.AccessToken,
+		RefreshToken: ts.RefreshToken,
+	}, nil)
+}
+
 type SsoConfigOutput struct {
 	OidcDisplayName     string `json:"oidcDisplayName"`
 	CasDisplayName      string `json:"casDisplayName"`
 	OauthDisplayName    string `json:"oauthDisplayName"`
 	DingTalkDisplayName string `json:"dingTalkDisplayName"`
 	FeiShuDisplayName   string `json:"feishuDisplayName"`
+	SamlDisplayName     string `json:"samlDisplayName"`
 }
 
 func (rt *Router) ssoConfigNameGet(c *gin.Context) {
-	var oidcDisplayName, casDisplayName, oauthDisplayName, dingTalkDisplayName, feiShuDisplayName string
+	var oidcDisplayName, casDisplayName, oauthDisplayName, dingTalkDisplayName, feiShuDisplayName, samlDisplayName string
 	if rt.Sso.OIDC != nil {
 		oidcDisplayName = rt.Sso.OIDC.GetDisplayName()
 	}
@@ -694,12 +852,17 @@ func (rt *Router) ssoConfigNameGet(c *gin.Context) {
 		feiShuDisplayName = rt.Sso.FeiShu.GetDisplayName()
 	}
 
+	if rt.Sso.SAML != nil {
+		samlDisplayName = rt.Sso.SAML.GetDisplayName()
+	}
+
 	ginx.NewRender(c).Data(SsoConfigOutput{
 		OidcDisplayName:     oidcDisplayName,
 		CasDisplayName:      casDisplayName,
 		OauthDisplayName:    oauthDisplayName,
 		DingTalkDisplayName: dingTalkDisplayName,
 		FeiShuDisplayName:   feiShuDisplayName,
+		SamlDisplayName:     samlDisplayName,
 	}, nil)
 }
 
@@ -820,6 +983,18 @@ func (rt *Router) ssoConfigUpdate(c *gin.Context) {
 		err := toml.Unmarshal([]byte(f.Content), &config)
 		ginx.Dangerous(err)
 		rt.Sso.OAuth2.Reload(config)
+	case "SAML":
+		var config samlx.Config
+		err := toml.Unmarshal([]byte(f.Content), &config)
+		ginx.Dangerous(err)
+		// Reload 先构建好新配置再在锁内整体替换，失败时保留原配置，不影响正在进行的登录
+		if rt.Sso.SAML == nil {
+			sc, err := samlx.New(config)
+			ginx.Dangerous(err)
+			rt.Sso.SAML = sc
+		} else {
+			ginx.Dangerous(rt.Sso.SAML.Reload(config))
+		}
 	case dingtalk.SsoTypeName:
 		var config dingtalk.Config
 		err := json.Unmarshal([]byte(f.Content), &config)
@@ -66,6 +66,7 @@ func (rt *Router) QueryLokiLabelNames(c *gin.Context) {
 		ginx.Bomb(403, "no permission")
 	}
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	vl := getLoki(c, f.Cate, f.DatasourceId)
 	ctx := withCallContext(c.Request.Context(), f.DatasourceId, ginUser(c))
 	ret, err := vl.QueryLabelNames(ctx, f.Query, f.Start, f.End, f.Filter, f.Limit)
@@ -86,6 +87,7 @@ func (rt *Router) QueryLokiLabelValues(c *gin.Context) {
 		ginx.Bomb(403, "no permission")
 	}
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	vl := getLoki(c, f.Cate, f.DatasourceId)
 	ctx := withCallContext(c.Request.Context(), f.DatasourceId, ginUser(c))
 	ret, err := vl.QueryLabelValues(ctx, f.Query, f.Start, f.End, f.Label, f.Filter, f.Limit)
@@ -110,6 +112,7 @@ func (rt *Router) QueryLokiParsedFields(c *gin.Context) {
 		ginx.Bomb(403, "no permission")
 	}
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	vl := getLoki(c, f.Cate, f.DatasourceId)
 	ctx := withCallContext(c.Request.Context(), f.DatasourceId, ginUser(c))
 	ret, err := vl.QueryParsedFields(ctx, f.Query, f.Start, f.End, f.Limit)
@@ -129,6 +132,7 @@ func (rt *Router) QueryLokiHistogram(c *gin.Context) {
 		ginx.Bomb(http.StatusBadRequest, "query is required")
 	}
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	vl := getLoki(c, f.Cate, f.DatasourceId)
 	ret := make([]loki.HistogramValues, 0)
 	for _, q := range f.Query {
@@ -43,8 +43,10 @@ func (rt *Router) messageTemplatesAdd(c *gin.Context) {
 		if !isAdmin && !slice.HaveIntersection(gids, tpl.UserGroupIds) {
 			ginx.Bomb(http.StatusForbidden, "forbidden")
 		}
+		ginx.Dangerous(models.TenantCheckOwner(rt.Ctx, &models.UserGroup{}, me.TenantId, tpl.UserGroupIds))
 		idents = append(idents, tpl.Ident)
 
+		tpl.TenantId = me.TenantId
 		tpl.CreateBy = me.Username
 		tpl.CreateAt = now
 		tpl.UpdateBy = me.Username
@@ -61,6 +63,7 @@ func (rt *Router) messageTemplatesAdd(c *gin.Context) {
 	for _, tpl := range lst {
 		err := models.Insert(rt.Ctx, tpl)
 		ginx.Dangerous(err)
+		models.ConfigRevisionRecord(rt.Ctx, models.RevisionMessageTemplate, tpl.ID)
 
 		ids = append(ids, tpl.ID)
 	}
@@ -74,6 +77,10 @@ func (rt *Router) messageTemplatesDel(c *gin.Context) {
 
 	lst, err := models.MessageTemplatesGet(rt.Ctx, "id in (?)", f.Ids)
 	ginx.Dangerous(err)
+	for _, t := range lst {
+		checkTenantShared(c, t.TenantId, true)
+	}
+
 	notifyRuleIds, err := models.UsedByNotifyRule(rt.Ctx, models.MsgTplList(lst))
 	ginx.Dangerous(err)
 	if len(notifyRuleIds) > 0 {
@@ -109,6 +116,7 @@ func (rt *Router) messageTemplatePut(c *gin.Context) {
 	if mt == nil {
 		ginx.Bomb(http.StatusNotFound, "message template not found")
 	}
+	checkTenantShared(c, mt.TenantId, true)
 
 	me := c.MustGet("user").(*models.User)
 	if !me.IsAdmin() {
@@ -119,6 +127,8 @@ func (rt *Router) messageTemplatePut(c *gin.Context) {
 		}
 	}
 
+	ginx.Dangerous(models.TenantCheckOwner(rt.Ctx, &models.UserGroup{}, mt.TenantId, f.UserGroupIds))
+
 	// 前端编辑时不回传 lang，模板语言保持不变
 	f.Lang = mt.Lang
 	f.UpdateBy = me.Username
@@ -134,6 +144,7 @@ func (rt *Router) messageTemplateGet(c *gin.Context) {
 	if mt == nil {
 		ginx.Bomb(http.StatusNotFound, "message template not found")
 	}
+	checkTenantShared(c, mt.TenantId, false)
 
 	if !me.IsAdmin() && mt.Private == 1 {
 		gids, err := models.MyGroupIds(rt.Ctx, me.Id)
@@ -165,6 +176,15 @@ func (rt *Router) messageTemplatesGet(c *gin.Context) {
 	ginx.Dangerous(err)
 	// 仅对内置模板按语言过滤，用户自建模板始终保留（避免跨语言/存量自建模板被隐藏）
 	lst = models.FilterMsgTplsByLang(lst, c.GetHeader("X-Language"))
+	if me.TenantId > 0 {
+		shared := make([]*models.MessageTemplate, 0, len(lst))
+		for _, t := range lst {
+			if me.TenantShared(t.TenantId) {
+				shared = append(shared, t)
+			}
+		}
+		lst = shared
+	}
 	models.FillUpdateByNicknames(rt.Ctx, lst)
 
 	if me.IsAdmin() {
@@ -37,9 +37,9 @@ func (rt *Router) alertMuteGetsByGids(c *gin.Context) {
 		}
 	} else {
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			var err error
-			gids, err = models.MyBusiGroupIds(rt.Ctx, me.Id)
+			gids, err = me.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 
 			if len(gids) == 0 {
@@ -49,6 +49,12 @@ func (rt *Router) alertMuteGetsByGids(c *gin.Context) {
 		}
 	}
 
+	gids, visible := tokenBusiGroupFilter(c, gids)
+	if !visible {
+		ginx.NewRender(c).Data([]int{}, nil)
+		return
+	}
+
 	lst, err := models.AlertMuteGetsByBGIds(rt.Ctx, gids)
 	if err == nil {
 		models.FillUpdateByNicknames(rt.Ctx, lst)
@@ -173,7 +179,7 @@ func (rt *Router) alertMuteDel(c *gin.Context) {
 	ginx.BindJSON(c, &f)
 	f.Verify()
 
-	ginx.NewRender(c).Message(models.AlertMuteDel(rt.Ctx, f.Ids))
+	ginx.NewRender(c).Message(models.AlertMuteDel(rt.Ctx, f.Ids, c.MustGet("username").(string)))
 }
 
 // alertMuteGet returns the alert mute by ID
@@ -227,7 +233,7 @@ func (rt *Router) alertMuteBatchDelete(c *gin.Context) {
 	go func() {
 		limit := 1000
 		for {
-			n, err := models.AlertMuteBatchDelete(rt.Ctx, f.Timestamp, f.GroupIds, limit)
+			n, err := models.AlertMuteBatchDelete(rt.Ctx, f.Timestamp, f.GroupIds, limit, user.Username)
 			if err != nil {
 				logger.Errorf("Failed to delete alert mutes: operator=%s, timestamp=%d, group_ids=%v, error=%v",
 					user.Username, f.Timestamp, f.GroupIds, err)
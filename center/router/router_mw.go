@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -22,11 +24,21 @@ import (
 
 const (
 	DefaultTokenKey = "X-User-Token"
+
+	// authMethodKey 记录本次请求的认证方式，写入审计日志
+	authMethodKey = "auth_method"
+
+	// userTokenKey 通过 user token 认证时存放 token 记录，用于检查权限范围和业务组
+	userTokenKey = "user_token"
+
+	// sessionIdKey 通过 jwt 认证时存放当前会话 id
+	sessionIdKey = "session_id"
 )
 
 type AccessDetails struct {
 	AccessUuid   string
 	UserIdentity string
+	SessionId    string
 }
 
 func (rt *Router) handleProxyUser(c *gin.Context) *models.User {
@@ -65,6 +77,7 @@ func (rt *Router) proxyAuth() gin.HandlerFunc {
 		user := rt.handleProxyUser(c)
 		c.Set("userid", user.Id)
 		c.Set("username", user.Username)
+		c.Set(authMethodKey, "proxy")
 		c.Next()
 	}
 }
@@ -97,10 +110,19 @@ func (rt *Router) tokenAuth() gin.HandlerFunc {
 			}
 			token := c.GetHeader(tokenKey)
 			if token != "" {
-				user := rt.UserTokenCache.GetByToken(token)
+				user, ut := rt.UserTokenCache.Get(token)
 				if user != nil && user.Username != "" {
+					if ut != nil {
+						if code, msg := checkUserToken(c, ut); code != 0 {
+							rt.UserTokenCache.RecordUsage(token, c.RemoteIP(), true)
+							ginx.Bomb(code, "%v", msg)
+						}
+						c.Set(userTokenKey, ut)
+					}
+					rt.UserTokenCache.RecordUsage(token, c.RemoteIP(), false)
 					c.Set("userid", user.Id)
 					c.Set("username", user.Username)
+					c.Set(authMethodKey, "user_token:"+maskToken(token))
 					c.Next()
 					return
 				}
@@ -124,6 +146,7 @@ func (rt *Router) tokenAuth() gin.HandlerFunc {
 				if uid, uname, ok := rt.mcpVerifyAccessToken(raw); ok {
 					c.Set("userid", uid)
 					c.Set("username", uname)
+					c.Set(authMethodKey, "oauth")
 					c.Next()
 					return
 				}
@@ -144,6 +167,7 @@ func (rt *Router) tokenAuth() gin.HandlerFunc {
 				}
 				c.Set("userid", user.Id)
 				c.Set("username", user.Username)
+				c.Set(authMethodKey, "idp_token")
 				c.Next()
 				return
 			}
@@ -173,11 +197,107 @@ func (rt *Router) tokenAuth() gin.HandlerFunc {
 
 		c.Set("userid", userid)
 		c.Set("username", arr[1])
+		c.Set(authMethodKey, "jwt")
+
+		if metadata.SessionId != "" {
+			c.Set(sessionIdKey, metadata.SessionId)
+			rt.touchSession(c, userid, metadata.SessionId)
+		}
 
 		c.Next()
 	}
 }
 
+// checkUserToken 检查 token 的过期时间、来源 IP 和只读限制，不通过时返回状态码和原因
+func checkUserToken(c *gin.Context, ut *models.UserToken) (int, string) {
+	if ut.Expired(time.Now().Unix()) {
+		return http.StatusUnauthorized, "token expired"
+	}
+
+	// 用直连地址而不是 ClientIP，engine 没有配置可信代理，X-Forwarded-For 可以被客户端伪造
+	if !ut.IpAllowed(c.RemoteIP()) {
+		return http.StatusForbidden, "token is not allowed from this ip"
+	}
+
+	if ut.ReadOnly == 1 {
+		method := c.Request.Method
+		// 除 GET 外只能调用 readOnlyRoutes 里登记的查询接口
+		if method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions && !isReadOnlyRoute(c) {
+			return http.StatusForbidden, "token is read only"
+		}
+	}
+
+	return 0, ""
+}
+
+// requestUserToken 返回本次请求使用的 user token，不是通过 user token 认证时返回 nil
+func requestUserToken(c *gin.Context) *models.UserToken {
+	if v, has := c.Get(userTokenKey); has {
+		return v.(*models.UserToken)
+	}
+	return nil
+}
+
+// permHandlerName perm 返回的中间件在 HandlerNames 里的名字。从函数本身取，
+// 不写死字符串，perm 改名或调整实现之后依然能认出来
+var permHandlerName = runtime.FuncForPC(reflect.ValueOf((*Router)(nil).perm("")).Pointer()).Name()
+
+// checkTokenScope 限定了权限点的 token 只能调用挂了 perm 的接口，其余接口默认拒绝，
+// 权限点本身在 perm 里检查。user 在 perm 之前执行，只能看后面的中间件里有没有 perm
+func checkTokenScope(c *gin.Context) {
+	ut := requestUserToken(c)
+	if ut == nil || len(ut.Scopes) == 0 {
+		return
+	}
+
+	for _, name := range c.HandlerNames() {
+		if name == permHandlerName {
+			return
+		}
+	}
+
+	ginx.Bomb(http.StatusForbidden, "token scope does not cover this api")
+}
+
+// noUserToken 密码、两步验证、会话、token 管理等凭证类接口只接受登录会话，
+// 泄露的 user token 不能用来修改凭证或签发新 token
+func (rt *Router) noUserToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.GetString(authMethodKey), "user_token:") {
+			ginx.Bomb(http.StatusForbidden, "user token can not access this api")
+		}
+		c.Next()
+	}
+}
+
+// checkTokenBusiGroup token 限定了业务组时，只能访问这些业务组
+func checkTokenBusiGroup(c *gin.Context, bgid int64) {
+	if ut := requestUserToken(c); ut != nil && !ut.BusiGroupAllowed(bgid) {
+		ginx.Bomb(http.StatusForbidden, "token is not allowed to access this business group")
+	}
+}
+
+// tokenBusiGroupFilter 列表接口自己算出要查的业务组之后，再收窄到 token 允许的业务组。
+// bgids 为空表示不限制业务组，此时返回 token 允许的全部业务组；没有交集时 visible 为 false
+func tokenBusiGroupFilter(c *gin.Context, bgids []int64) ([]int64, bool) {
+	ut := requestUserToken(c)
+	if ut == nil || len(ut.BusiGroupIds) == 0 {
+		return bgids, true
+	}
+
+	if len(bgids) == 0 {
+		return ut.BusiGroupIds, true
+	}
+
+	allowed := make([]int64, 0, len(bgids))
+	for _, id := range bgids {
+		if ut.BusiGroupAllowed(id) {
+			allowed = append(allowed, id)
+		}
+	}
+	return allowed, len(allowed) > 0
+}
+
 func (rt *Router) Auth() gin.HandlerFunc {
 	return rt.auth()
 }
@@ -227,6 +347,12 @@ func (rt *Router) user() gin.HandlerFunc {
 			ginx.Bomb(http.StatusUnauthorized, "unauthorized")
 		}
 
+		if user.Disabled == 1 {
+			ginx.Bomb(http.StatusUnauthorized, "user is disabled")
+		}
+
+		checkTokenScope(c)
+
 		c.Set("user", user)
 		c.Set("isadmin", user.IsAdmin())
 		// Update user.LastActiveTime
@@ -256,6 +382,7 @@ func (rt *Router) bgro() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		me := c.MustGet("user").(*models.User)
 		bg := BusiGroup(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+		checkTokenBusiGroup(c, bg.Id)
 
 		can, err := me.CanDoBusiGroup(rt.Ctx, bg)
 		ginx.Dangerous(err)
@@ -278,6 +405,7 @@ func (rt *Router) bgrw() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		me := c.MustGet("user").(*models.User)
 		bg := BusiGroup(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+		checkTokenBusiGroup(c, bg.Id)
 
 		can, err := me.CanDoBusiGroup(rt.Ctx, bg, "rw")
 		ginx.Dangerous(err)
@@ -294,6 +422,7 @@ func (rt *Router) bgrw() gin.HandlerFunc {
 // bgrwCheck 要逐渐替换掉bgrw方法，更安全
 func (rt *Router) bgrwCheck(c *gin.Context, bgid int64) {
 	me := c.MustGet("user").(*models.User)
+	checkTokenBusiGroup(c, bgid)
 	bg := BusiGroup(rt.Ctx, bgid)
 
 	can, err := me.CanDoBusiGroup(rt.Ctx, bg, "rw")
@@ -321,6 +450,7 @@ func (rt *Router) bgrwChecks(c *gin.Context, bgids []int64) {
 
 func (rt *Router) bgroCheck(c *gin.Context, bgid int64) {
 	me := c.MustGet("user").(*models.User)
+	checkTokenBusiGroup(c, bgid)
 	bg := BusiGroup(rt.Ctx, bgid)
 
 	can, err := me.CanDoBusiGroup(rt.Ctx, bg)
@@ -343,12 +473,13 @@ func (rt *Router) bgroCheck(c *gin.Context, bgid int64) {
 // plain 403. A bgid of 0 lands in the same branch for the same reason.
 func (rt *Router) bgroCheckAllowMissing(c *gin.Context, bgid int64) {
 	me := c.MustGet("user").(*models.User)
+	checkTokenBusiGroup(c, bgid)
 
 	bg, err := models.BusiGroupGetById(rt.Ctx, bgid)
 	ginx.Dangerous(err)
 
 	if bg == nil {
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			ginx.Bomb(http.StatusForbidden, "forbidden")
 		}
 		return
@@ -372,6 +503,10 @@ func (rt *Router) perm(operation string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		me := c.MustGet("user").(*models.User)
 
+		if ut := requestUserToken(c); ut != nil && !ut.ScopeAllowed(operation) {
+			ginx.Bomb(http.StatusForbidden, "token scope does not include %s", operation)
+		}
+
 		can, err := me.CheckPerm(rt.Ctx, operation)
 		ginx.Dangerous(err)
 
@@ -405,7 +540,8 @@ func (rt *Router) admin() gin.HandlerFunc {
 			}
 		}
 
-		if !found {
+		// 这里都是平台级的配置，租户管理员不能修改
+		if !found || user.TenantId > 0 {
 			ginx.Bomb(http.StatusForbidden, "forbidden")
 		}
 
@@ -433,9 +569,13 @@ func (rt *Router) extractTokenMetadata(r *http.Request) (*AccessDetails, error)
 			return nil, errors.New("unauthorized")
 		}
 
+		// 升级前签发的 token 没有 session_id
+		sessionId, _ := claims["session_id"].(string)
+
 		return &AccessDetails{
 			AccessUuid:   accessUuid,
 			UserIdentity: claims["user_identity"].(string),
+			SessionId:    sessionId,
 		}, nil
 	}
 
@@ -452,26 +592,18 @@ func (rt *Router) extractToken(r *http.Request) string {
 	return ""
 }
 
-func (rt *Router) createAuth(ctx context.Context, userIdentity string, td *TokenDetails) error {
-	username := strings.Split(userIdentity, "-")[1]
+func (rt *Router) createAuth(c *gin.Context, userIdentity string, td *TokenDetails) error {
+	ctx := c.Request.Context()
+	userId, err := strconv.ParseInt(strings.Split(userIdentity, "-")[0], 10, 64)
+	if err != nil {
+		return err
+	}
 
-	// 如果只能有一个账号登录，那么就删除之前的 token
+	// 如果只能有一个账号登录，那么就踢掉其他会话。刷新 token 时 td.SessionId 是当前会话，保留
 	if rt.HTTP.JWTAuth.SingleLogin {
-		delKeys, err := rt.Redis.SMembers(ctx, rt.wrapJwtKey(username)).Result()
-		if err != nil {
+		if err := rt.revokeSessions(ctx, userId, td.SessionId); err != nil {
 			return err
 		}
-
-		if len(delKeys) > 0 {
-			errDel := rt.Redis.Del(ctx, delKeys...).Err()
-			if errDel != nil {
-				return errDel
-			}
-		}
-
-		if errDel := rt.Redis.Del(ctx, rt.wrapJwtKey(username)).Err(); errDel != nil {
-			return errDel
-		}
 	}
 
 	at := time.Unix(td.AtExpires, 0)
@@ -490,13 +622,7 @@ func (rt *Router) createAuth(ctx context.Context, userIdentity string, td *Token
 
 	cstats.RedisOperationLatency.WithLabelValues("set_token", "success").Observe(time.Since(now).Seconds())
 
-	if rt.HTTP.JWTAuth.SingleLogin {
-		if err := rt.Redis.SAdd(ctx, rt.wrapJwtKey(username), rt.wrapJwtKey(td.AccessUuid), rt.wrapJwtKey(td.RefreshUuid)).Err(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return rt.saveSession(c, userId, td)
 }
 
 func (rt *Router) fetchAuth(ctx context.Context, givenUuid string) (string, error) {
@@ -537,6 +663,11 @@ func (rt *Router) deleteTokens(ctx context.Context, authD *AccessDetails) error
 		return err
 	}
 
+	if authD.SessionId != "" {
+		userId, _ := strconv.ParseInt(strings.Split(authD.UserIdentity, "-")[0], 10, 64)
+		return rt.Redis.HDel(ctx, rt.wrapSessionKey(userId), authD.SessionId).Err()
+	}
+
 	return nil
 }
 
@@ -573,12 +704,19 @@ type TokenDetails struct {
 	RefreshToken string
 	AccessUuid   string
 	RefreshUuid  string
+	SessionId    string
 	AtExpires    int64
 	RtExpires    int64
 }
 
+// createTokens 登录时调用，每次登录是一个新的会话
 func (rt *Router) createTokens(signingKey, userIdentity string) (*TokenDetails, error) {
-	td := &TokenDetails{}
+	return rt.createSessionTokens(signingKey, userIdentity, uuid.NewString())
+}
+
+// createSessionTokens 为会话 sessionId 签发 token，刷新 token 时沿用原来的会话
+func (rt *Router) createSessionTokens(signingKey, userIdentity, sessionId string) (*TokenDetails, error) {
+	td := &TokenDetails{SessionId: sessionId}
 	td.AtExpires = time.Now().Add(time.Minute * time.Duration(rt.HTTP.JWTAuth.AccessExpired)).Unix()
 	td.AccessUuid = uuid.NewString()
 
@@ -591,6 +729,7 @@ func (rt *Router) createTokens(signingKey, userIdentity string) (*TokenDetails,
 	atClaims["authorized"] = true
 	atClaims["access_uuid"] = td.AccessUuid
 	atClaims["user_identity"] = userIdentity
+	atClaims["session_id"] = td.SessionId
 	atClaims["exp"] = td.AtExpires
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
 	td.AccessToken, err = at.SignedString([]byte(signingKey))
@@ -602,6 +741,7 @@ func (rt *Router) createTokens(signingKey, userIdentity string) (*TokenDetails,
 	rtClaims := jwt.MapClaims{}
 	rtClaims["refresh_uuid"] = td.RefreshUuid
 	rtClaims["user_identity"] = userIdentity
+	rtClaims["session_id"] = td.SessionId
 	rtClaims["exp"] = td.RtExpires
 	jrt := jwt.NewWithClaims(jwt.SigningMethodHS256, rtClaims)
 	td.RefreshToken, err = jrt.SignedString([]byte(signingKey))
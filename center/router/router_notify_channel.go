@@ -102,6 +102,7 @@ func (rt *Router) notifyChannelsAdd(c *gin.Context) {
 		ginx.Dangerous(lst[i].Verify())
 		names = append(names, lst[i].Name)
 
+		lst[i].TenantId = me.TenantId
 		lst[i].CreateBy = me.Username
 		lst[i].CreateAt = time.Now().Unix()
 		lst[i].UpdateBy = me.Username
@@ -131,6 +132,10 @@ func (rt *Router) notifyChannelsDel(c *gin.Context) {
 
 	lst, err := models.NotifyChannelsGet(rt.Ctx, "id in (?)", f.Ids)
 	ginx.Dangerous(err)
+	for _, nc := range lst {
+		checkTenantShared(c, nc.TenantId, true)
+	}
+
 	notifyRuleIds, err := models.UsedByNotifyRule(rt.Ctx, models.NotiChList(lst))
 	ginx.Dangerous(err)
 	if len(notifyRuleIds) > 0 {
@@ -159,6 +164,7 @@ func (rt *Router) notifyChannelPut(c *gin.Context) {
 	if nc == nil {
 		ginx.Bomb(http.StatusNotFound, "notify channel not found")
 	}
+	checkTenantShared(c, nc.TenantId, true)
 
 	f.UpdateBy = me.Username
 	ginx.NewRender(c).Message(nc.Update(rt.Ctx, f))
@@ -171,6 +177,7 @@ func (rt *Router) notifyChannelGet(c *gin.Context) {
 	if nc == nil {
 		ginx.Bomb(http.StatusNotFound, "notify channel not found")
 	}
+	checkTenantShared(c, nc.TenantId, false)
 
 	ginx.NewRender(c).Data(nc, nil)
 }
@@ -190,15 +197,36 @@ func (rt *Router) notifyChannelGetBy(c *gin.Context) {
 }
 
 func (rt *Router) notifyChannelsGet(c *gin.Context) {
-	lst, err := models.NotifyChannelsGet(rt.Ctx, "", nil)
+	lst, err := rt.tenantNotifyChannels(c)
 	if err == nil {
 		models.FillUpdateByNicknames(rt.Ctx, lst)
 	}
 	ginx.NewRender(c).Data(lst, err)
 }
 
+// tenantNotifyChannels 返回本租户和平台共用的通知媒介
+func (rt *Router) tenantNotifyChannels(c *gin.Context) ([]*models.NotifyChannelConfig, error) {
+	lst, err := models.NotifyChannelsGet(rt.Ctx, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	me := c.MustGet("user").(*models.User)
+	if me.TenantId == 0 {
+		return lst, nil
+	}
+
+	ret := make([]*models.NotifyChannelConfig, 0, len(lst))
+	for _, nc := range lst {
+		if me.TenantShared(nc.TenantId) {
+			ret = append(ret, nc)
+		}
+	}
+	return ret, nil
+}
+
 func (rt *Router) notifyChannelsGetForNormalUser(c *gin.Context) {
-	lst, err := models.NotifyChannelsGet(rt.Ctx, "")
+	lst, err := rt.tenantNotifyChannels(c)
 	ginx.Dangerous(err)
 
 	newLst := make([]*models.NotifyChannelConfig, 0, len(lst))
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/dispatch"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/logger"
+)
+
+const notifyRateLimitNodeTimeout = 5 * time.Second
+
+// notifyRateLimitNode 单个告警引擎节点的限流器状态，节点查询失败时只返回 Error
+type notifyRateLimitNode struct {
+	Instance string                      `json:"instance"`
+	Snapshot *dispatch.RateLimitSnapshot `json:"snapshot,omitempty"`
+	Error    string                      `json:"error,omitempty"`
+}
+
+// notifyRateLimitGet 查看所有存活告警引擎节点的通知限流器状态，限流在各节点本地进行，按节点分别返回
+// GET /api/n9e/notify-rate-limits?scope=channel|recipient|rule
+func (rt *Router) notifyRateLimitGet(c *gin.Context) {
+	scope := ginx.QueryStr(c, "scope", "")
+	local := fmt.Sprintf("%s:%d", rt.Alert.Heartbeat.IP, rt.HTTP.Port)
+
+	instances := []string{local}
+	engines, err := models.AlertingEngineGetsAlive(rt.Ctx, time.Now().Unix()-30)
+	if err != nil {
+		logger.Warningf("notify rate limit: failed to get alive engines: %v", err)
+	}
+	seen := map[string]struct{}{local: {}}
+	for _, e := range engines {
+		if _, has := seen[e.Instance]; !has {
+			seen[e.Instance] = struct{}{}
+			instances = append(instances, e.Instance)
+		}
+	}
+
+	nodes := make([]notifyRateLimitNode, len(instances))
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		nodes[i].Instance = instance
+		if instance == local {
+			snap := dispatch.NotifyLimiter.Snapshot(scope)
+			nodes[i].Snapshot = &snap
+			continue
+		}
+
+		wg.Add(1)
+		go func(node *notifyRateLimitNode) {
+			defer wg.Done()
+			snap, err := rt.forwardNotifyRateLimit(c.Request.Context(), node.Instance, scope)
+			if err != nil {
+				node.Error = err.Error()
+				return
+			}
+			node.Snapshot = snap
+		}(&nodes[i])
+	}
+	wg.Wait()
+
+	ginx.NewRender(c).Data(nodes, nil)
+}
+
+// forwardNotifyRateLimit 查询其他引擎节点 /v1/n9e/notify-rate-limits
+func (rt *Router) forwardNotifyRateLimit(ctx context.Context, node, scope string) (*dispatch.RateLimitSnapshot, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, notifyRateLimitNodeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet,
+		fmt.Sprintf("http://%s/v1/n9e/notify-rate-limits?scope=%s", node, url.QueryEscape(scope)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for user, pass := range rt.HTTP.APIForService.BasicAuth {
+		req.SetBasicAuth(user, pass)
+		break
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("forward to %s failed: %v", node, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Dat dispatch.RateLimitSnapshot `json:"dat"`
+		Err string                     `json:"err"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10*1024*1024)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response of %s: %v", node, err)
+	}
+	if result.Err != "" {
+		return nil, fmt.Errorf("%s", result.Err)
+	}
+	return &result.Dat, nil
+}
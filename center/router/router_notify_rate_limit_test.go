@@ -0,0 +1,40 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/pkg/httpx"
+)
+
+func TestForwardNotifyRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "n9e" || pass != "secret" {
+			w.Write([]byte(`{"err":"unauthorized"}`))
+			return
+		}
+		if r.URL.Path != "/v1/n9e/notify-rate-limits" || r.URL.Query().Get("scope") != "rule" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		w.Write([]byte(`{"dat":{"enable":true,"buckets":[{"key":"rule:1","throttled":3}],"suppressed":[{"notify_rule_id":1,"count":3}]},"err":""}`))
+	}))
+	defer srv.Close()
+
+	node := strings.TrimPrefix(srv.URL, "http://")
+	rt := &Router{HTTP: httpx.Config{APIForService: httpx.BasicAuths{BasicAuth: map[string]string{"n9e": "secret"}}}}
+	snap, err := rt.forwardNotifyRateLimit(context.Background(), node, "rule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !snap.Enable || len(snap.Buckets) != 1 || snap.Buckets[0].Throttled != 3 || len(snap.Suppressed) != 1 || snap.Suppressed[0].Count != 3 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+
+	rt.HTTP.APIForService.BasicAuth = nil
+	if _, err := rt.forwardNotifyRateLimit(context.Background(), node, "rule"); err == nil || err.Error() != "unauthorized" {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notifyRetryGets 查看发送失败重试队列，status=dead 即死信列表
+func (rt *Router) notifyRetryGets(c *gin.Context) {
+	status := ginx.QueryStr(c, "status", "")
+	notifyRuleId := ginx.QueryInt64(c, "notify_rule_id", 0)
+	channelId := ginx.QueryInt64(c, "channel_id", 0)
+	limit := ginx.QueryInt(c, "limit", 20)
+
+	total, err := models.NotifyRetryTotal(rt.Ctx, status, notifyRuleId, channelId)
+	ginx.Dangerous(err)
+
+	list, err := models.NotifyRetryGets(rt.Ctx, status, notifyRuleId, channelId, limit, ginx.Offset(c, limit))
+	ginx.Dangerous(err)
+
+	ginx.NewRender(c).Data(gin.H{
+		"list":  list,
+		"total": total,
+	}, nil)
+}
+
+// notifyRetryRecords 一次投递的原始通知记录以及所有重试、手动重发的记录
+func (rt *Router) notifyRetryRecords(c *gin.Context) {
+	id := ginx.UrlParamInt64(c, "id")
+	retry, err := models.NotifyRetryGet(rt.Ctx, id)
+	ginx.Dangerous(err)
+	if retry == nil {
+		ginx.Bomb(http.StatusNotFound, "No such notify retry")
+	}
+
+	lst, err := models.NotificationRecordsGetByRetry(rt.Ctx, retry)
+	ginx.NewRender(c).Data(gin.H{
+		"retry":   retry,
+		"records": lst,
+	}, err)
+}
+
+// notifyRetryResend 通过原通知媒介和消息模板重新发送死信
+func (rt *Router) notifyRetryResend(c *gin.Context) {
+	var f idsForm
+	ginx.BindJSON(c, &f)
+	f.Verify()
+
+	count, err := models.NotifyRetryResend(rt.Ctx, f.Ids, c.MustGet("username").(string))
+	ginx.NewRender(c).Data(count, err)
+}
+
+// notifyRetryAddByService 边缘告警引擎发送失败的投递，由中心端入队重试
+func (rt *Router) notifyRetryAddByService(c *gin.Context) {
+	var f models.NotifyRetry
+	ginx.BindJSON(c, &f)
+
+	f.Id = 0
+	ginx.Dangerous(f.Add(rt.Ctx))
+	ginx.NewRender(c).Data(f.Id, nil)
+}
+
+func (rt *Router) notifyRetryDel(c *gin.Context) {
+	var f idsForm
+	ginx.BindJSON(c, &f)
+	f.Verify()
+
+	ginx.NewRender(c).Message(models.NotifyRetryDel(rt.Ctx, f.Ids))
+}
@@ -36,14 +36,16 @@ func (rt *Router) notifyRulesAdd(c *gin.Context) {
 		if !isAdmin && !slice.HaveIntersection(gids, nr.UserGroupIds) {
 			ginx.Bomb(http.StatusForbidden, "forbidden")
 		}
+		ginx.Dangerous(models.TenantCheckOwner(rt.Ctx, &models.UserGroup{}, me.TenantId, nr.UserGroupIds))
 
+		nr.TenantId = me.TenantId
 		nr.CreateBy = me.Username
 		nr.CreateAt = now
 		nr.UpdateBy = me.Username
 		nr.UpdateAt = now
 		nr.CleanFEFields()
 
-		err := models.Insert(rt.Ctx, nr)
+		err := models.CreateNotifyRule(rt.Ctx, nr)
 		ginx.Dangerous(err)
 	}
 	ginx.NewRender(c).Data(lst, nil)
@@ -54,13 +56,15 @@ func (rt *Router) notifyRulesDel(c *gin.Context) {
 	ginx.BindJSON(c, &f)
 	f.Verify()
 
-	if me := c.MustGet("user").(*models.User); !me.IsAdmin() {
+	me := c.MustGet("user").(*models.User)
+	if !me.IsSuperAdmin() {
 		lst, err := models.NotifyRulesGet(rt.Ctx, "id in (?)", f.Ids)
 		ginx.Dangerous(err)
 		gids, err := models.MyGroupIds(rt.Ctx, me.Id)
 		ginx.Dangerous(err)
 		for _, t := range lst {
-			if !slice.HaveIntersection(gids, t.UserGroupIds) {
+			checkTenant(c, t.TenantId)
+			if !me.IsAdmin() && !slice.HaveIntersection(gids, t.UserGroupIds) {
 				ginx.Bomb(http.StatusForbidden, "forbidden")
 			}
 		}
@@ -79,6 +83,7 @@ func (rt *Router) notifyRulePut(c *gin.Context) {
 	if nr == nil {
 		ginx.Bomb(http.StatusNotFound, "notify rule not found")
 	}
+	checkTenant(c, nr.TenantId)
 
 	me := c.MustGet("user").(*models.User)
 	gids, err := models.MyGroupIds(rt.Ctx, me.Id)
@@ -86,6 +91,7 @@ func (rt *Router) notifyRulePut(c *gin.Context) {
 	if !slice.HaveIntersection(gids, nr.UserGroupIds) && !me.IsAdmin() {
 		ginx.Bomb(http.StatusForbidden, "forbidden")
 	}
+	ginx.Dangerous(models.TenantCheckOwner(rt.Ctx, &models.UserGroup{}, nr.TenantId, f.UserGroupIds))
 
 	f.UpdateBy = me.Username
 	f.CleanFEFields()
@@ -103,6 +109,7 @@ func (rt *Router) notifyRuleGet(c *gin.Context) {
 	if nr == nil {
 		ginx.Bomb(http.StatusNotFound, "notify rule not found")
 	}
+	checkTenant(c, nr.TenantId)
 
 	if !slice.HaveIntersection(gids, nr.UserGroupIds) && !me.IsAdmin() {
 		ginx.Bomb(http.StatusForbidden, "forbidden")
@@ -180,6 +187,15 @@ func (rt *Router) notifyRulesGet(c *gin.Context) {
 
 	lst, err := models.NotifyRulesGet(rt.Ctx, "", nil)
 	ginx.Dangerous(err)
+	if me.TenantId > 0 {
+		visible := make([]*models.NotifyRule, 0, len(lst))
+		for _, nr := range lst {
+			if me.TenantVisible(nr.TenantId) {
+				visible = append(visible, nr)
+			}
+		}
+		lst = visible
+	}
 	models.FillUpdateByNicknames(rt.Ctx, lst)
 	if me.IsAdmin() {
 		rt.fillNotifyConfigNames(lst)
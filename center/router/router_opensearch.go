@@ -13,6 +13,7 @@ func (rt *Router) QueryOSIndices(c *gin.Context) {
 	var f IndexReq
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logger.Warningf("cluster:%d not exists", f.DatasourceId)
@@ -29,6 +30,7 @@ func (rt *Router) QueryOSFields(c *gin.Context) {
 	var f IndexReq
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logger.Warningf("cluster:%d not exists", f.DatasourceId)
@@ -45,6 +47,7 @@ func (rt *Router) QueryOSVariable(c *gin.Context) {
 	var f FieldValueReq
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	plug, exists := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if !exists {
 		logger.Warningf("cluster:%d not exists", f.DatasourceId)
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+	"github.com/ccfos/nightingale/v6/pkg/secu"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	passwordTicketRedisPrefix = "/password/ticket/"
+	passwordTicketTTL         = 5 * time.Minute
+)
+
+// checkPasswordPolicy 按配置的密码策略检查新密码。user 为 nil 表示新建用户，不检查历史密码
+func (rt *Router) checkPasswordPolicy(user *models.User, plain string) error {
+	p := rt.Center.PasswordPolicy
+	if err := models.PasswordCheck(plain, p.MinLength, p.MinClasses); err != nil {
+		return err
+	}
+
+	if user == nil {
+		return nil
+	}
+
+	reused, err := user.PasswordReused(rt.Ctx, plain, p.HistoryCount)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return errors.New("password has been used recently, please choose another one")
+	}
+	return nil
+}
+
+// loginChallenge 密码校验通过后调用，返回 true 表示已经返回了下一步需要的凭据。
+// 两步验证必须在密码过期之前检查，否则只知道密码就能改掉密码并让用户的所有会话失效
+func (rt *Router) loginChallenge(c *gin.Context, user *models.User) bool {
+	return rt.totpChallenge(c, user) || rt.passwordExpiredChallenge(c, user)
+}
+
+// passwordExpiredChallenge 密码和两步验证都通过后调用。密码过期时不签发 token，
+// 返回一个短期凭据，由前端带着新密码调用 /auth/login/password。
+// recoveryCodes 为登录过程中刚启用两步验证时生成的恢复码，需要一并返回
+func (rt *Router) passwordExpiredChallenge(c *gin.Context, user *models.User, recoveryCodes ...string) bool {
+	if !user.PasswordExpired(rt.Center.PasswordPolicy.MaxAgeDays) {
+		return false
+	}
+
+	ticket := uuid.NewString()
+	ginx.Dangerous(rt.Redis.Set(c.Request.Context(), passwordTicketRedisPrefix+ticket, user.Id, passwordTicketTTL).Err())
+
+	ginx.NewRender(c).Data(gin.H{
+		"password_expired": true,
+		"password_ticket":  ticket,
+		"recovery_codes":   recoveryCodes,
+	}, nil)
+	return true
+}
+
+type passwordLoginForm struct {
+	Ticket   string `json:"ticket" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// loginPassword 密码过期时登录的最后一步，修改密码后签发 token。
+// 凭据只在两步验证通过（或不需要两步验证）之后签发，这里不再检查验证码
+func (rt *Router) loginPassword(c *gin.Context) {
+	var f passwordLoginForm
+	ginx.BindJSON(c, &f)
+
+	key := passwordTicketRedisPrefix + f.Ticket
+	val, err := rt.Redis.Get(c.Request.Context(), key).Result()
+	if err != nil {
+		ginx.Bomb(http.StatusUnauthorized, "login expired, please login again")
+	}
+
+	id, _ := strconv.ParseInt(val, 10, 64)
+	user, err := models.UserGetById(rt.Ctx, id)
+	ginx.Dangerous(err)
+	if user == nil || user.Disabled == 1 {
+		ginx.Bomb(http.StatusUnauthorized, "login expired, please login again")
+	}
+
+	newPassWord := f.Password
+	if rt.HTTP.RSA.OpenRSA {
+		newPassWord, err = secu.Decrypt(f.Password, rt.HTTP.RSA.RSAPrivateKey, rt.HTTP.RSA.RSAPassWord)
+		if err != nil {
+			logger.Errorf("RSA Decrypt failed: %v username: %s", err, user.Username)
+			ginx.Bomb(http.StatusBadRequest, "%v", err)
+		}
+	}
+
+	ginx.Dangerous(rt.checkPasswordPolicy(user, newPassWord), http.StatusBadRequest)
+
+	cryptoPass, err := models.CryptoPass(rt.Ctx, newPassWord)
+	ginx.Dangerous(err)
+	ginx.Dangerous(user.UpdatePassword(rt.Ctx, cryptoPass, user.Username))
+	rt.Redis.Del(c.Request.Context(), key)
+	logger.Infof("user %s changed expired password at login", user.Username)
+
+	// 密码过期之前签发的会话一并失效
+	if err := rt.revokeSessions(c.Request.Context(), user.Id, ""); err != nil {
+		logger.Warningf("revoke sessions of user %s failed: %v", user.Username, err)
+	}
+
+	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
+	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
+	ginx.Dangerous(err)
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
+
+	ginx.NewRender(c).Data(gin.H{
+		"user":          user,
+		"access_token":  ts.AccessToken,
+		"refresh_token": ts.RefreshToken,
+	}, nil)
+}
@@ -41,6 +41,7 @@ func (rt *Router) promBatchQueryRange(c *gin.Context) {
 	var f BatchQueryForm
 	ginx.Dangerous(c.BindJSON(&f))
 	rt.checkBoardTokenDsPerm(c, f.DatasourceId)
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 
 	lst, err := PromBatchQueryRange(c.Request.Context(), rt.PromClients, f)
 	ginx.NewRender(c).Data(lst, err)
@@ -73,6 +74,38 @@ func PromBatchQueryRange(ctx context.Context, pc *prom.PromClientMap, f BatchQue
 	return lst, nil
 }
 
+// promBatchQueryExemplars 查询 exemplar（如 trace id），结果与 queries 一一对应
+func (rt *Router) promBatchQueryExemplars(c *gin.Context) {
+	var f BatchQueryForm
+	ginx.Dangerous(c.BindJSON(&f))
+	rt.checkBoardTokenDsPerm(c, f.DatasourceId)
+	rt.checkDsQueryPerm(c, f.DatasourceId)
+
+	lst, err := PromBatchQueryExemplars(c.Request.Context(), rt.PromClients, f)
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func PromBatchQueryExemplars(ctx context.Context, pc *prom.PromClientMap, f BatchQueryForm) ([][]pkgprom.ExemplarQueryResult, error) {
+	var lst [][]pkgprom.ExemplarQueryResult
+
+	cli := pc.GetCli(f.DatasourceId)
+	if cli == nil {
+		logx.Warningf(ctx, "no such datasource id: %d", f.DatasourceId)
+		return lst, fmt.Errorf("no such datasource id: %d", f.DatasourceId)
+	}
+
+	for _, item := range f.Queries {
+		resp, err := cli.QueryExemplars(ctx, item.Query, time.Unix(item.Start, 0), time.Unix(item.End, 0))
+		if err != nil {
+			logx.Warningf(ctx, "query exemplars error: query:%s err:%v", item.Query, err)
+			return lst, err
+		}
+
+		lst = append(lst, resp)
+	}
+	return lst, nil
+}
+
 type BatchInstantForm struct {
 	DatasourceId int64             `json:"datasource_id" binding:"required"`
 	Queries      []InstantFormItem `json:"queries" binding:"required"`
@@ -87,6 +120,7 @@ func (rt *Router) promBatchQueryInstant(c *gin.Context) {
 	var f BatchInstantForm
 	ginx.Dangerous(c.BindJSON(&f))
 	rt.checkBoardTokenDsPerm(c, f.DatasourceId)
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 
 	lst, err := PromBatchQueryInstant(c.Request.Context(), rt.PromClients, f)
 	ginx.NewRender(c).Data(lst, err)
@@ -129,6 +163,7 @@ func (rt *Router) dsProxy(c *gin.Context) {
 			c.Request.URL.RawQuery = q.Encode()
 		}
 	}
+	rt.checkDsQueryPerm(c, dsId)
 
 	ds := rt.DatasourceCache.GetById(dsId)
 
@@ -213,6 +213,7 @@ func (rt *Router) QueryLogBatch(c *gin.Context) {
 	if rt.boardTokenQueryContext(c, dsIds...) {
 		anonymousAccess = true
 	}
+	rt.checkDsQueryPerm(c, dsIds...)
 
 	resp, err := QueryLogBatchConcurrently(anonymousAccess, c, f)
 	if err != nil {
@@ -292,6 +293,7 @@ func (rt *Router) QueryData(c *gin.Context) {
 	if rt.boardTokenQueryContext(c, f.DatasourceId) {
 		anonymousAccess = true
 	}
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 
 	resp, err := QueryDataConcurrently(anonymousAccess, c, f)
 	if err != nil {
@@ -367,6 +369,7 @@ func (rt *Router) QueryLogV2(c *gin.Context) {
 	if rt.boardTokenQueryContext(c, f.DatasourceId) {
 		anonymousAccess = true
 	}
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 
 	resp, err := QueryLogConcurrently(anonymousAccess, c, f)
 	ginx.NewRender(c).Data(resp, err)
@@ -376,6 +379,7 @@ func (rt *Router) QueryLog(c *gin.Context) {
 	var f models.QueryParam
 	ginx.BindJSON(c, &f)
 	logQueryAccess(c, f.Cate, f.DatasourceId, f.Queries)
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	rctx := c.Request.Context()
 
 	var resp []interface{}
@@ -29,9 +29,9 @@ func (rt *Router) recordingRuleGetsByGids(c *gin.Context) {
 		}
 	} else {
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			var err error
-			gids, err = models.MyBusiGroupIds(rt.Ctx, me.Id)
+			gids, err = me.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 
 			if len(gids) == 0 {
@@ -41,6 +41,12 @@ func (rt *Router) recordingRuleGetsByGids(c *gin.Context) {
 		}
 	}
 
+	gids, visible := tokenBusiGroupFilter(c, gids)
+	if !visible {
+		ginx.NewRender(c).Data([]int{}, nil)
+		return
+	}
+
 	ars, err := models.RecordingRuleGetsByBGIds(rt.Ctx, gids)
 	if err == nil {
 		models.FillUpdateByNicknames(rt.Ctx, ars)
@@ -99,6 +105,11 @@ func (rt *Router) recordingRuleAddByFE(c *gin.Context) {
 			continue
 		}
 
+		if err := rt.checkRuleDatasources(c, &lst[i]); err != nil {
+			reterr[lst[i].Name] = err.Error()
+			continue
+		}
+
 		lst[i].FE2DB()
 
 		if err := lst[i].Add(rt.Ctx); err != nil {
@@ -130,6 +141,10 @@ func (rt *Router) recordingRulePutByFE(c *gin.Context) {
 		ginx.Bomb(http.StatusForbidden, "%s", err.Error())
 	}
 
+	if err := rt.checkRuleDatasources(c, &f); err != nil {
+		ginx.Bomb(http.StatusForbidden, "%s", err.Error())
+	}
+
 	f.UpdateBy = c.MustGet("username").(string)
 	ginx.NewRender(c).Message(ar.Update(rt.Ctx, f))
 
@@ -54,7 +54,13 @@ func (rt *Router) rolePut(c *gin.Context) {
 	}
 
 	if oldRule.Name == "Admin" {
-		ginx.Bomb(http.StatusOK, "admin role can not be modified")
+		// 管理员角色只允许调整两步验证策略
+		if f.Name != oldRule.Name || f.Note != oldRule.Note {
+			ginx.Bomb(http.StatusOK, "admin role can not be modified")
+		}
+		oldRule.TotpRequired = f.TotpRequired
+		ginx.NewRender(c).Message(oldRule.Update(rt.Ctx, "totp_required"))
+		return
 	}
 
 	if oldRule.Name != f.Name {
@@ -69,8 +75,9 @@ func (rt *Router) rolePut(c *gin.Context) {
 
 	oldRule.Name = f.Name
 	oldRule.Note = f.Note
+	oldRule.TotpRequired = f.TotpRequired
 
-	ginx.NewRender(c).Message(oldRule.Update(rt.Ctx, "name", "note"))
+	ginx.NewRender(c).Message(oldRule.Update(rt.Ctx, "name", "note", "totp_required"))
 }
 
 func (rt *Router) roleDel(c *gin.Context) {
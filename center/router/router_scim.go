@@ -0,0 +1,940 @@
+package router
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/errorx"
+	"github.com/toolkits/pkg/logger"
+	"github.com/toolkits/pkg/str"
+)
+
+const (
+	scimSchemaUser  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaGroup = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimSchemaList  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaError = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSchemaSPC   = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	scimOperator     = "scim"
+	scimDefaultCount = 100
+	scimMaxCount     = 1000
+)
+
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+type scimValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimUser struct {
+	Schemas      []string    `json:"schemas"`
+	Id           string      `json:"id,omitempty"`
+	ExternalId   string      `json:"externalId,omitempty"`
+	UserName     string      `json:"userName"`
+	Name         *scimName   `json:"name,omitempty"`
+	DisplayName  string      `json:"displayName,omitempty"`
+	Active       *bool       `json:"active,omitempty"`
+	Emails       []scimValue `json:"emails,omitempty"`
+	PhoneNumbers []scimValue `json:"phoneNumbers,omitempty"`
+	Groups       []scimValue `json:"groups,omitempty"`
+	Meta         *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimGroup struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id,omitempty"`
+	ExternalId  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []scimValue `json:"members"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type scimPatchRequest struct {
+	Operations []scimPatchOp `json:"Operations"`
+}
+
+type scimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (rt *Router) configSCIM(r *gin.Engine) {
+	if !rt.Center.SCIM.Enable {
+		return
+	}
+
+	if rt.Center.SCIM.Token == "" {
+		logger.Warning("scim is enabled but Center.SCIM.Token is empty, all scim requests will be rejected")
+	}
+
+	scim := r.Group("/scim/v2")
+	scim.Use(scimRecovery(), rt.scimAuth())
+	{
+		scim.GET("/ServiceProviderConfig", rt.scimServiceProviderConfig)
+		scim.GET("/Users", rt.scimUserGets)
+		scim.GET("/Users/:id", rt.scimUserGet)
+		scim.POST("/Users", rt.scimUserAdd)
+		scim.PUT("/Users/:id", rt.scimUserPut)
+		scim.PATCH("/Users/:id", rt.scimUserPatch)
+		scim.DELETE("/Users/:id", rt.scimUserDel)
+		scim.GET("/Groups", rt.scimGroupGets)
+		scim.GET("/Groups/:id", rt.scimGroupGet)
+		scim.POST("/Groups", rt.scimGroupAdd)
+		scim.PUT("/Groups/:id", rt.scimGroupPut)
+		scim.PATCH("/Groups/:id", rt.scimGroupPatch)
+		scim.DELETE("/Groups/:id", rt.scimGroupDel)
+	}
+}
+
+// scimRecovery 把 ginx.Bomb 抛出的错误转成 SCIM 规定的错误格式
+func scimRecovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+
+			pe, ok := p.(errorx.PageError)
+			if !ok {
+				panic(p)
+			}
+
+			code := pe.Code
+			if code < http.StatusBadRequest {
+				code = http.StatusInternalServerError
+			}
+			body := gin.H{"schemas": []string{scimSchemaError}, "status": strconv.Itoa(code), "detail": pe.Message}
+			if code == http.StatusConflict {
+				body["scimType"] = "uniqueness"
+			}
+			scimRender(c, code, body)
+			c.Abort()
+		}()
+
+		c.Next()
+	}
+}
+
+func (rt *Router) scimAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+		expected := rt.Center.SCIM.Token
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			ginx.Bomb(http.StatusUnauthorized, "unauthorized")
+		}
+		c.Next()
+	}
+}
+
+func scimRender(c *gin.Context, code int, obj interface{}) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		code = http.StatusInternalServerError
+		bs = []byte(`{"schemas":["` + scimSchemaError + `"],"status":"500","detail":"failed to marshal response"}`)
+	}
+	c.Data(code, "application/scim+json", bs)
+}
+
+func scimTime(ts int64) string {
+	if ts <= 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+func scimLocation(c *gin.Context, resource string, id int64) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%d", scheme, c.Request.Host, resource, id)
+}
+
+func scimBindJSON(c *gin.Context, ptr interface{}) {
+	if err := json.NewDecoder(c.Request.Body).Decode(ptr); err != nil {
+		ginx.Bomb(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+}
+
+// scimPage 解析 startIndex、count，startIndex 从 1 开始
+func scimPage(c *gin.Context) (startIndex, count int) {
+	startIndex = ginx.QueryInt(c, "startIndex", 1)
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count = ginx.QueryInt(c, "count", scimDefaultCount)
+	if count < 0 {
+		count = 0
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+	return startIndex, count
+}
+
+func scimParseId(s string) int64 {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		ginx.Bomb(http.StatusNotFound, "resource %s not found", s)
+	}
+	return id
+}
+
+var scimFilterExpr = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*"|[\w.@+-]+)\s*`)
+var scimFilterAnd = regexp.MustCompile(`^(?i:and)\s+`)
+
+// scimFilter 把过滤表达式转成 sql 条件，只支持 eq 和 and，IdP 查找用户和团队用的都是这种形式，
+// 如 userName eq "alice"。columns 的 key 为小写的属性名
+func scimFilter(filter string, columns map[string]string) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+
+	rest := strings.TrimSpace(filter)
+	for rest != "" {
+		m := scimFilterExpr.FindStringSubmatch(rest)
+		if m == nil {
+			return "", nil, fmt.Errorf("unsupported filter: %s", filter)
+		}
+		rest = rest[len(m[0]):]
+		if rest != "" {
+			and := scimFilterAnd.FindString(rest)
+			if and == "" {
+				return "", nil, fmt.Errorf("unsupported filter: %s", filter)
+			}
+			rest = rest[len(and):]
+		}
+
+		attr := strings.ToLower(m[1])
+		value := m[2]
+		if strings.HasPrefix(value, `"`) {
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid filter value: %s", value)
+			}
+			value = v
+		}
+
+		column, has := columns[attr]
+		if !has {
+			return "", nil, fmt.Errorf("unsupported filter attribute: %s", m[1])
+		}
+
+		switch attr {
+		case "active":
+			active, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid filter value: %s", value)
+			}
+			disabled := 0
+			if !active {
+				disabled = 1
+			}
+			conds = append(conds, column+" = ?")
+			args = append(args, disabled)
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				// 不存在的 id
+				id = -1
+			}
+			conds = append(conds, column+" = ?")
+			args = append(args, id)
+		default:
+			conds = append(conds, column+" = ?")
+			args = append(args, value)
+		}
+	}
+
+	if len(conds) == 0 {
+		return "1 = 1", nil, nil
+	}
+	return strings.Join(conds, " and "), args, nil
+}
+
+// scimPatchPath 把 emails[type eq "work"].value 这类路径简化为小写的属性名 emails
+func scimPatchPath(path string) string {
+	path = strings.ToLower(strings.TrimSpace(path))
+	path = strings.TrimPrefix(path, strings.ToLower(scimSchemaUser)+":")
+	if i := strings.Index(path, "["); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+func scimString(raw json.RawMessage) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case bool, float64:
+		return fmt.Sprint(t), nil
+	case []interface{}:
+		// 多值属性只取第一个
+		if len(t) == 0 {
+			return "", nil
+		}
+		if m, ok := t[0].(map[string]interface{}); ok {
+			return fmt.Sprint(m["value"]), nil
+		}
+		return fmt.Sprint(t[0]), nil
+	case map[string]interface{}:
+		if val, has := t["value"]; has {
+			return fmt.Sprint(val), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported value: %s", string(raw))
+}
+
+func scimPhone(s string) string {
+	var sb strings.Builder
+	for i, r := range strings.TrimSpace(s) {
+		if (r >= '0' && r <= '9') || (i == 0 && r == '+') {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// applyScimUserPatch 在当前用户的 SCIM 表示上执行 PATCH 操作
+func applyScimUserPatch(u *scimUser, ops []scimPatchOp) error {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path == "" {
+				var attrs map[string]json.RawMessage
+				if err := json.Unmarshal(op.Value, &attrs); err != nil {
+					return fmt.Errorf("invalid patch value: %v", err)
+				}
+				for k, v := range attrs {
+					if err := setScimUserAttr(u, k, v); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := setScimUserAttr(u, op.Path, op.Value); err != nil {
+				return err
+			}
+		case "remove":
+			if err := setScimUserAttr(u, op.Path, json.RawMessage("null")); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported patch op: %s", op.Op)
+		}
+	}
+	return nil
+}
+
+func setScimUserAttr(u *scimUser, path string, raw json.RawMessage) error {
+	if u.Name == nil {
+		u.Name = &scimName{}
+	}
+
+	attr := scimPatchPath(path)
+	if attr == "name" {
+		var name scimName
+		if string(raw) != "null" {
+			if err := json.Unmarshal(raw, &name); err != nil {
+				return fmt.Errorf("invalid name: %v", err)
+			}
+		}
+		u.Name = &name
+		return nil
+	}
+
+	val, err := scimString(raw)
+	if err != nil {
+		return fmt.Errorf("invalid value of %s: %v", path, err)
+	}
+
+	switch attr {
+	case "active":
+		active := true
+		if val != "" {
+			active, err = strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("invalid value of active: %s", val)
+			}
+		}
+		u.Active = &active
+	case "username":
+		u.UserName = val
+	case "externalid":
+		u.ExternalId = val
+	case "displayname":
+		u.DisplayName = val
+	case "name.formatted":
+		u.Name.Formatted = val
+	case "name.givenname":
+		u.Name.GivenName = val
+	case "name.familyname":
+		u.Name.FamilyName = val
+	case "emails":
+		u.Emails = nil
+		if val != "" {
+			u.Emails = []scimValue{{Value: val, Primary: true}}
+		}
+	case "phonenumbers":
+		u.PhoneNumbers = nil
+		if val != "" {
+			u.PhoneNumbers = []scimValue{{Value: val, Primary: true}}
+		}
+	default:
+		// 其余属性 n9e 中没有对应字段，忽略
+	}
+	return nil
+}
+
+func scimPrimary(values []scimValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+// apply 把 SCIM 用户写到 n9e 用户上，返回用户是否启用
+func (su *scimUser) apply(u *models.User) bool {
+	u.Username = strings.TrimSpace(su.UserName)
+
+	nickname := su.DisplayName
+	if nickname == "" && su.Name != nil {
+		nickname = su.Name.Formatted
+		if nickname == "" {
+			nickname = strings.TrimSpace(su.Name.GivenName + " " + su.Name.FamilyName)
+		}
+	}
+	if nickname == "" {
+		nickname = u.Username
+	}
+	u.Nickname = nickname
+	u.Email = scimPrimary(su.Emails)
+	if su.ExternalId != "" {
+		u.ScimExternalId = su.ExternalId
+	}
+
+	// n9e 只接受纯数字的手机号，格式不符时不同步
+	u.Phone = scimPhone(scimPrimary(su.PhoneNumbers))
+	if u.Phone != "" && !str.IsPhone(u.Phone) {
+		u.Phone = ""
+	}
+
+	return su.Active == nil || *su.Active
+}
+
+func (rt *Router) scimUserResource(c *gin.Context, u *models.User) *scimUser {
+	active := u.Disabled == 0
+	su := &scimUser{
+		Schemas:     []string{scimSchemaUser},
+		Id:          strconv.FormatInt(u.Id, 10),
+		ExternalId:  u.ScimExternalId,
+		UserName:    u.Username,
+		Name:        &scimName{Formatted: u.Nickname},
+		DisplayName: u.Nickname,
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      scimTime(u.CreateAt),
+			LastModified: scimTime(u.UpdateAt),
+			Location:     scimLocation(c, "Users", u.Id),
+		},
+	}
+	if u.Email != "" {
+		su.Emails = []scimValue{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	if u.Phone != "" {
+		su.PhoneNumbers = []scimValue{{Value: u.Phone, Type: "work", Primary: true}}
+	}
+
+	gids, err := models.MyGroupIds(rt.Ctx, u.Id)
+	ginx.Dangerous(err)
+	names, err := models.UserGroupIdAndNameMap(rt.Ctx, gids)
+	ginx.Dangerous(err)
+	for _, gid := range gids {
+		su.Groups = append(su.Groups, scimValue{Value: strconv.FormatInt(gid, 10), Display: names[gid]})
+	}
+
+	return su
+}
+
+// scimUserById 只能操作由 SCIM 管理的用户，其他用户要先通过 POST /Users 按 userName 关联
+func (rt *Router) scimUserById(c *gin.Context) *models.User {
+	u, err := models.UserGetById(rt.Ctx, scimParseId(c.Param("id")))
+	ginx.Dangerous(err)
+	if u == nil || u.ScimExternalId == "" {
+		ginx.Bomb(http.StatusNotFound, "user %s not found", c.Param("id"))
+	}
+	return u
+}
+
+// scimUserSave 保存用户，启用状态变化时同步停用或启用
+func (rt *Router) scimUserSave(u *models.User, active bool) {
+	if u.Username == "" {
+		ginx.Bomb(http.StatusBadRequest, "userName is required")
+	}
+	if u.ScimExternalId == "" {
+		u.ScimExternalId = u.Username
+	}
+
+	if u.Id == 0 {
+		u.Password = "******"
+		u.Roles = strings.Join(rt.Center.SCIM.DefaultRoles, " ")
+		u.RolesLst = rt.Center.SCIM.DefaultRoles
+		u.Contacts = []byte("{}")
+		u.Belong = scimOperator
+		u.CreateBy = scimOperator
+		u.UpdateBy = scimOperator
+		ginx.Dangerous(u.Verify(), http.StatusBadRequest)
+		ginx.Dangerous(u.Add(rt.Ctx))
+		logger.Infof("scim: user %s created", u.Username)
+	} else {
+		u.UpdateBy = scimOperator
+		ginx.Dangerous(u.UpdateAllFields(rt.Ctx), http.StatusBadRequest)
+	}
+	// Verify 可能加密了手机号，返回给 IdP 的应是明文
+	u.DecryptPhone()
+
+	if active != (u.Disabled == 0) {
+		ginx.Dangerous(u.SetDisabled(rt.Ctx, !active, scimOperator))
+		logger.Infof("scim: user %s active=%v", u.Username, active)
+	}
+}
+
+var scimUserColumns = map[string]string{
+	"id":           "id",
+	"username":     "username",
+	"displayname":  "nickname",
+	"emails":       "email",
+	"emails.value": "email",
+	"active":       "disabled",
+	"externalid":   "scim_external_id",
+}
+
+func (rt *Router) scimUserGets(c *gin.Context) {
+	where, args, err := scimFilter(ginx.QueryStr(c, "filter", ""), scimUserColumns)
+	if err != nil {
+		ginx.Bomb(http.StatusBadRequest, "%v", err)
+	}
+
+	startIndex, count := scimPage(c)
+	lst, total, err := models.UsersGetByPage(rt.Ctx, count, startIndex-1, "scim_external_id <> '' and ("+where+")", args...)
+	ginx.Dangerous(err)
+
+	ret := scimListResponse{Schemas: []string{scimSchemaList}, TotalResults: total, StartIndex: startIndex,
+		Resources: make([]interface{}, 0, len(lst))}
+	for _, u := range lst {
+		ret.Resources = append(ret.Resources, rt.scimUserResource(c, u))
+	}
+	ret.ItemsPerPage = len(ret.Resources)
+	scimRender(c, http.StatusOK, ret)
+}
+
+func (rt *Router) scimUserGet(c *gin.Context) {
+	scimRender(c, http.StatusOK, rt.scimUserResource(c, rt.scimUserById(c)))
+}
+
+func (rt *Router) scimUserAdd(c *gin.Context) {
+	var su scimUser
+	scimBindJSON(c, &su)
+
+	u, err := models.UserGetByUsername(rt.Ctx, strings.TrimSpace(su.UserName))
+	ginx.Dangerous(err)
+	if u != nil {
+		// 已有的本地、LDAP 或 SSO 自动创建的用户按 userName 关联，之后由 IdP 管理。
+		// 管理员不交给 IdP，避免持有 SCIM token 就能停用或删除管理员
+		if u.ScimExternalId != "" || u.IsAdmin() {
+			ginx.Bomb(http.StatusConflict, "user %s already exists", su.UserName)
+		}
+		logger.Infof("scim: existing user %s is linked", u.Username)
+	} else {
+		u = new(models.User)
+	}
+
+	active := su.apply(u)
+	rt.scimUserSave(u, active)
+	scimRender(c, http.StatusCreated, rt.scimUserResource(c, u))
+}
+
+func (rt *Router) scimUserPut(c *gin.Context) {
+	u := rt.scimUserById(c)
+
+	var su scimUser
+	scimBindJSON(c, &su)
+	if su.UserName == "" {
+		su.UserName = u.Username
+	}
+	if su.UserName != u.Username {
+		ginx.Bomb(http.StatusBadRequest, "userName can not be changed")
+	}
+
+	active := su.apply(u)
+	rt.scimUserSave(u, active)
+	scimRender(c, http.StatusOK, rt.scimUserResource(c, u))
+}
+
+func (rt *Router) scimUserPatch(c *gin.Context) {
+	u := rt.scimUserById(c)
+
+	var req scimPatchRequest
+	scimBindJSON(c, &req)
+
+	su := rt.scimUserResource(c, u)
+	if err := applyScimUserPatch(su, req.Operations); err != nil {
+		ginx.Bomb(http.StatusBadRequest, "%v", err)
+	}
+	if su.UserName != u.Username {
+		ginx.Bomb(http.StatusBadRequest, "userName can not be changed")
+	}
+
+	active := su.apply(u)
+	rt.scimUserSave(u, active)
+	scimRender(c, http.StatusOK, rt.scimUserResource(c, u))
+}
+
+func (rt *Router) scimUserDel(c *gin.Context) {
+	u := rt.scimUserById(c)
+	ginx.Dangerous(u.Del(rt.Ctx))
+	logger.Infof("scim: user %s deleted", u.Username)
+	c.Status(http.StatusNoContent)
+}
+
+// applyScimGroupPatch 在当前团队的 SCIM 表示上执行 PATCH 操作
+func applyScimGroupPatch(g *scimGroup, ops []scimPatchOp) error {
+	for _, op := range ops {
+		path := strings.TrimSpace(op.Path)
+		attr := strings.ToLower(path)
+		if i := strings.Index(attr, "["); i >= 0 {
+			attr = attr[:i]
+		}
+
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if attr == "" {
+				var attrs struct {
+					DisplayName *string     `json:"displayName"`
+					Members     []scimValue `json:"members"`
+				}
+				if err := json.Unmarshal(op.Value, &attrs); err != nil {
+					return fmt.Errorf("invalid patch value: %v", err)
+				}
+				if attrs.DisplayName != nil {
+					g.DisplayName = *attrs.DisplayName
+				}
+				if attrs.Members != nil {
+					g.Members = scimMergeMembers(g.Members, attrs.Members, strings.EqualFold(op.Op, "replace"))
+				}
+				continue
+			}
+
+			switch attr {
+			case "displayname":
+				val, err := scimString(op.Value)
+				if err != nil {
+					return fmt.Errorf("invalid value of displayName: %v", err)
+				}
+				g.DisplayName = val
+			case "members":
+				var members []scimValue
+				if err := json.Unmarshal(op.Value, &members); err != nil {
+					return fmt.Errorf("invalid value of members: %v", err)
+				}
+				g.Members = scimMergeMembers(g.Members, members, strings.EqualFold(op.Op, "replace"))
+			}
+		case "remove":
+			if attr != "members" {
+				continue
+			}
+
+			var remove []scimValue
+			if i := strings.Index(path, "["); i >= 0 {
+				// members[value eq "12"]
+				m := scimFilterExpr.FindStringSubmatch(strings.TrimSuffix(path[i+1:], "]"))
+				if m == nil || !strings.EqualFold(m[1], "value") {
+					return fmt.Errorf("unsupported path: %s", path)
+				}
+				val, err := strconv.Unquote(m[2])
+				if err != nil {
+					val = m[2]
+				}
+				remove = []scimValue{{Value: val}}
+			} else if len(op.Value) > 0 && string(op.Value) != "null" {
+				if err := json.Unmarshal(op.Value, &remove); err != nil {
+					return fmt.Errorf("invalid value of members: %v", err)
+				}
+			} else {
+				g.Members = nil
+				continue
+			}
+
+			removed := make(map[string]struct{}, len(remove))
+			for _, m := range remove {
+				removed[m.Value] = struct{}{}
+			}
+			var members []scimValue
+			for _, m := range g.Members {
+				if _, has := removed[m.Value]; !has {
+					members = append(members, m)
+				}
+			}
+			g.Members = members
+		default:
+			return fmt.Errorf("unsupported patch op: %s", op.Op)
+		}
+	}
+	return nil
+}
+
+func scimMergeMembers(cur, members []scimValue, replace bool) []scimValue {
+	if replace {
+		cur = nil
+	}
+	seen := make(map[string]struct{}, len(cur))
+	for _, m := range cur {
+		seen[m.Value] = struct{}{}
+	}
+	for _, m := range members {
+		if _, has := seen[m.Value]; !has {
+			seen[m.Value] = struct{}{}
+			cur = append(cur, m)
+		}
+	}
+	return cur
+}
+
+func (rt *Router) scimGroupResource(c *gin.Context, ug *models.UserGroup, withMembers bool) *scimGroup {
+	g := &scimGroup{
+		Schemas:     []string{scimSchemaGroup},
+		Id:          strconv.FormatInt(ug.Id, 10),
+		DisplayName: ug.Name,
+		Members:     []scimValue{},
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      scimTime(ug.CreateAt),
+			LastModified: scimTime(ug.UpdateAt),
+			Location:     scimLocation(c, "Groups", ug.Id),
+		},
+	}
+
+	if !withMembers {
+		return g
+	}
+
+	ids, err := models.MemberIds(rt.Ctx, ug.Id)
+	ginx.Dangerous(err)
+	users, err := models.UserGetsByIds(rt.Ctx, ids)
+	ginx.Dangerous(err)
+	for _, u := range users {
+		g.Members = append(g.Members, scimValue{Value: strconv.FormatInt(u.Id, 10), Display: u.Username})
+	}
+	return g
+}
+
+func (rt *Router) scimGroupById(c *gin.Context) *models.UserGroup {
+	ug, err := models.UserGroupGetById(rt.Ctx, scimParseId(c.Param("id")))
+	ginx.Dangerous(err)
+	if ug == nil {
+		ginx.Bomb(http.StatusNotFound, "group %s not found", c.Param("id"))
+	}
+	return ug
+}
+
+// scimGroupSave 保存团队名称，并把成员调整为 g.Members
+func (rt *Router) scimGroupSave(ug *models.UserGroup, g *scimGroup) {
+	name := strings.TrimSpace(g.DisplayName)
+	if name == "" {
+		ginx.Bomb(http.StatusBadRequest, "displayName is required")
+	}
+
+	if name != ug.Name {
+		num, err := models.UserGroupCount(rt.Ctx, "name = ? and id <> ?", name, ug.Id)
+		ginx.Dangerous(err)
+		if num > 0 {
+			ginx.Bomb(http.StatusConflict, "group %s already exists", name)
+		}
+	}
+
+	ug.Name = name
+	ug.UpdateAt = time.Now().Unix()
+	ug.UpdateBy = scimOperator
+	if ug.Id == 0 {
+		ug.CreateBy = scimOperator
+		ginx.Dangerous(ug.Verify(), http.StatusBadRequest)
+		ginx.Dangerous(ug.Add(rt.Ctx))
+		logger.Infof("scim: group %s created", ug.Name)
+	} else {
+		ginx.Dangerous(ug.Update(rt.Ctx, "name", "update_at", "update_by"), http.StatusBadRequest)
+	}
+
+	want := make(map[int64]struct{}, len(g.Members))
+	for _, m := range g.Members {
+		id, err := strconv.ParseInt(m.Value, 10, 64)
+		if err != nil {
+			ginx.Bomb(http.StatusBadRequest, "invalid member %s", m.Value)
+		}
+		want[id] = struct{}{}
+	}
+
+	cur, err := models.MemberIds(rt.Ctx, ug.Id)
+	ginx.Dangerous(err)
+
+	var toDel []int64
+	for _, id := range cur {
+		if _, has := want[id]; has {
+			delete(want, id)
+		} else {
+			toDel = append(toDel, id)
+		}
+	}
+	toAdd := make([]int64, 0, len(want))
+	for id := range want {
+		toAdd = append(toAdd, id)
+	}
+
+	if len(toDel) > 0 {
+		ginx.Dangerous(ug.DelMembers(rt.Ctx, toDel))
+	}
+	if len(toAdd) > 0 {
+		ginx.Dangerous(ug.AddMembers(rt.Ctx, toAdd))
+	}
+}
+
+var scimGroupColumns = map[string]string{
+	"id":          "id",
+	"displayname": "name",
+}
+
+func scimWithMembers(c *gin.Context) bool {
+	for _, attr := range strings.Split(ginx.QueryStr(c, "excludedAttributes", ""), ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return false
+		}
+	}
+	return true
+}
+
+func (rt *Router) scimGroupGets(c *gin.Context) {
+	where, args, err := scimFilter(ginx.QueryStr(c, "filter", ""), scimGroupColumns)
+	if err != nil {
+		ginx.Bomb(http.StatusBadRequest, "%v", err)
+	}
+
+	startIndex, count := scimPage(c)
+	lst, total, err := models.UserGroupGetsByPage(rt.Ctx, count, startIndex-1, where, args...)
+	ginx.Dangerous(err)
+
+	withMembers := scimWithMembers(c)
+	ret := scimListResponse{Schemas: []string{scimSchemaList}, TotalResults: total, StartIndex: startIndex,
+		Resources: make([]interface{}, 0, len(lst))}
+	for _, ug := range lst {
+		ret.Resources = append(ret.Resources, rt.scimGroupResource(c, ug, withMembers))
+	}
+	ret.ItemsPerPage = len(ret.Resources)
+	scimRender(c, http.StatusOK, ret)
+}
+
+func (rt *Router) scimGroupGet(c *gin.Context) {
+	scimRender(c, http.StatusOK, rt.scimGroupResource(c, rt.scimGroupById(c), scimWithMembers(c)))
+}
+
+func (rt *Router) scimGroupAdd(c *gin.Context) {
+	var g scimGroup
+	scimBindJSON(c, &g)
+
+	ug := new(models.UserGroup)
+	rt.scimGroupSave(ug, &g)
+	scimRender(c, http.StatusCreated, rt.scimGroupResource(c, ug, true))
+}
+
+func (rt *Router) scimGroupPut(c *gin.Context) {
+	ug := rt.scimGroupById(c)
+
+	var g scimGroup
+	scimBindJSON(c, &g)
+
+	rt.scimGroupSave(ug, &g)
+	scimRender(c, http.StatusOK, rt.scimGroupResource(c, ug, true))
+}
+
+func (rt *Router) scimGroupPatch(c *gin.Context) {
+	ug := rt.scimGroupById(c)
+
+	var req scimPatchRequest
+	scimBindJSON(c, &req)
+
+	g := rt.scimGroupResource(c, ug, true)
+	if err := applyScimGroupPatch(g, req.Operations); err != nil {
+		ginx.Bomb(http.StatusBadRequest, "%v", err)
+	}
+
+	rt.scimGroupSave(ug, g)
+	scimRender(c, http.StatusOK, rt.scimGroupResource(c, ug, true))
+}
+
+func (rt *Router) scimGroupDel(c *gin.Context) {
+	ug := rt.scimGroupById(c)
+	ginx.Dangerous(ug.Del(rt.Ctx))
+	logger.Infof("scim: group %s deleted", ug.Name)
+	c.Status(http.StatusNoContent)
+}
+
+func (rt *Router) scimServiceProviderConfig(c *gin.Context) {
+	scimRender(c, http.StatusOK, gin.H{
+		"schemas":        []string{scimSchemaSPC},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with the token configured in Center.SCIM.Token",
+		}},
+	})
+}
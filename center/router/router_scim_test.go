@@ -0,0 +1,243 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestScimFilter(t *testing.T) {
+	where, args, err := scimFilter(`userName eq "alice" and active EQ false`, scimUserColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if where != "username = ? and disabled = ?" || len(args) != 2 || args[0] != "alice" || args[1] != 1 {
+		t.Fatalf("unexpected where=%q args=%v", where, args)
+	}
+
+	where, _, err = scimFilter("", scimUserColumns)
+	if err != nil || where != "1 = 1" {
+		t.Fatalf("empty filter: where=%q err=%v", where, err)
+	}
+
+	// 带引号的值里可以出现 and
+	_, args, err = scimFilter(`displayName eq "ops and sre"`, scimGroupColumns)
+	if err != nil || args[0] != "ops and sre" {
+		t.Fatalf("quoted value: args=%v err=%v", args, err)
+	}
+
+	for _, f := range []string{`userName co "a"`, `title eq "x"`, `userName eq "a" or userName eq "b"`} {
+		if _, _, err := scimFilter(f, scimUserColumns); err == nil {
+			t.Fatalf("filter %q should be rejected", f)
+		}
+	}
+}
+
+func TestApplyScimUserPatch(t *testing.T) {
+	active := true
+	u := &scimUser{UserName: "alice", DisplayName: "Alice", Active: &active}
+
+	// Azure AD 的写法：op 首字母大写，布尔值是字符串
+	ops := []scimPatchOp{
+		{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)},
+		{Op: "replace", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"alice@example.com"`)},
+		{Op: "add", Value: json.RawMessage(`{"displayName":"Alice L","phoneNumbers":[{"value":"138-0013-8000"}]}`)},
+	}
+	if err := applyScimUserPatch(u, ops); err != nil {
+		t.Fatal(err)
+	}
+	if *u.Active || u.DisplayName != "Alice L" || scimPrimary(u.Emails) != "alice@example.com" {
+		t.Fatalf("unexpected user after patch: %+v", u)
+	}
+
+	var m models.User
+	if enabled := u.apply(&m); enabled {
+		t.Fatal("user should be inactive")
+	}
+	if m.Phone != "13800138000" || m.Nickname != "Alice L" {
+		t.Fatalf("unexpected model: phone=%q nickname=%q", m.Phone, m.Nickname)
+	}
+
+	if err := applyScimUserPatch(u, []scimPatchOp{{Op: "move", Path: "active"}}); err == nil {
+		t.Fatal("unknown op should be rejected")
+	}
+}
+
+func TestApplyScimGroupPatch(t *testing.T) {
+	g := &scimGroup{DisplayName: "ops", Members: []scimValue{{Value: "1"}, {Value: "2"}}}
+	ops := []scimPatchOp{
+		{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"3"},{"value":"1"}]`)},
+		{Op: "remove", Path: `members[value eq "2"]`},
+		{Op: "replace", Path: "displayName", Value: json.RawMessage(`"sre"`)},
+	}
+	if err := applyScimGroupPatch(g, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, m := range g.Members {
+		ids = append(ids, m.Value)
+	}
+	if g.DisplayName != "sre" || strings.Join(ids, ",") != "1,3" {
+		t.Fatalf("unexpected group after patch: %s %v", g.DisplayName, ids)
+	}
+}
+
+func TestScimProvisioning(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.User{}, &models.UserGroup{}, &models.UserGroupMember{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := &Router{
+		Center: cconf.Center{SCIM: cconf.SCIM{Enable: true, Token: "secret", DefaultRoles: []string{"Standard"}}},
+		Ctx:    &ctx.Context{DB: db, IsCenter: true},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	rt.configSCIM(r)
+
+	do := func(method, path, token, body string, ret interface{}) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/scim+json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if ret != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), ret); err != nil {
+				t.Fatalf("%s %s: %v, body: %s", method, path, err, w.Body.String())
+			}
+		}
+		return w.Code
+	}
+
+	if code := do(http.MethodGet, "/scim/v2/Users", "wrong", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: code=%d", code)
+	}
+
+	var alice scimUser
+	body := `{"schemas":["` + scimSchemaUser + `"],"userName":"alice","name":{"givenName":"Alice","familyName":"Liu"},
+		"emails":[{"value":"alice@example.com","primary":true}],"active":true}`
+	if code := do(http.MethodPost, "/scim/v2/Users", "secret", body, &alice); code != http.StatusCreated {
+		t.Fatalf("create user: code=%d", code)
+	}
+	if alice.Id == "" || alice.DisplayName != "Alice Liu" {
+		t.Fatalf("unexpected created user: %+v", alice)
+	}
+
+	var e struct {
+		Status   string `json:"status"`
+		ScimType string `json:"scimType"`
+	}
+	if code := do(http.MethodPost, "/scim/v2/Users", "secret", body, &e); code != http.StatusConflict || e.ScimType != "uniqueness" {
+		t.Fatalf("duplicate user: code=%d err=%+v", code, e)
+	}
+
+	var group scimGroup
+	body = `{"schemas":["` + scimSchemaGroup + `"],"displayName":"oncall","members":[{"value":"` + alice.Id + `"}]}`
+	if code := do(http.MethodPost, "/scim/v2/Groups", "secret", body, &group); code != http.StatusCreated {
+		t.Fatalf("create group: code=%d", code)
+	}
+	if len(group.Members) != 1 || group.Members[0].Display != "alice" {
+		t.Fatalf("unexpected group members: %+v", group.Members)
+	}
+
+	var lst struct {
+		TotalResults int64       `json:"totalResults"`
+		Resources    []*scimUser `json:"Resources"`
+	}
+	if code := do(http.MethodGet, `/scim/v2/Users?filter=userName+eq+%22alice%22`, "secret", "", &lst); code != http.StatusOK {
+		t.Fatalf("list users: code=%d", code)
+	}
+	if lst.TotalResults != 1 || len(lst.Resources[0].Groups) != 1 {
+		t.Fatalf("unexpected list: %+v", lst)
+	}
+
+	// 离职：IdP 把用户置为 inactive，用户被停用并移出所有团队
+	body = `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"Replace","path":"active","value":"False"}]}`
+	if code := do(http.MethodPatch, "/scim/v2/Users/"+alice.Id, "secret", body, &alice); code != http.StatusOK {
+		t.Fatalf("deactivate user: code=%d", code)
+	}
+	if *alice.Active || len(alice.Groups) != 0 {
+		t.Fatalf("user should be inactive without groups: %+v", alice)
+	}
+
+	u, err := models.UserGetByUsername(rt.Ctx, "alice")
+	if err != nil || u == nil || u.Disabled != 1 {
+		t.Fatalf("user not disabled in db: %+v %v", u, err)
+	}
+	if u.Roles != "Standard" || u.ScimExternalId != "alice" {
+		t.Fatalf("unexpected roles=%q scim_external_id=%q", u.Roles, u.ScimExternalId)
+	}
+
+	// SSO 登录改写 belong 后仍由 SCIM 管理
+	fields := u.UpdateSsoFields("oidc", "", "", "")
+	if err := u.Update(rt.Ctx, "update_at", fields...); err != nil {
+		t.Fatal(err)
+	}
+	if code := do(http.MethodGet, "/scim/v2/Users/"+alice.Id, "secret", "", nil); code != http.StatusOK {
+		t.Fatalf("get user after sso login: code=%d", code)
+	}
+
+	// SSO 自动创建的用户按 userName 关联后可以被停用
+	if err := db.Create(&models.User{Username: "bob", Roles: "Standard", Belong: "saml", Contacts: []byte("{}")}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var bob scimUser
+	body = `{"schemas":["` + scimSchemaUser + `"],"userName":"bob","externalId":"00u-bob","active":true}`
+	if code := do(http.MethodPost, "/scim/v2/Users", "secret", body, &bob); code != http.StatusCreated || bob.ExternalId != "00u-bob" {
+		t.Fatalf("link existing user: code=%d user=%+v", code, bob)
+	}
+	body = `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"Replace","path":"active","value":"False"}]}`
+	if code := do(http.MethodPatch, "/scim/v2/Users/"+bob.Id, "secret", body, &bob); code != http.StatusOK || *bob.Active {
+		t.Fatalf("deactivate linked user: code=%d user=%+v", code, bob)
+	}
+
+	// 没有关联的用户和管理员对 IdP 不可见
+	if err := db.Create(&models.User{Username: "root", Roles: models.AdminRole, Contacts: []byte("{}")}).Error; err != nil {
+		t.Fatal(err)
+	}
+	root, _ := models.UserGetByUsername(rt.Ctx, "root")
+	rootPath := "/scim/v2/Users/" + strconv.FormatInt(root.Id, 10)
+	if code := do(http.MethodDelete, rootPath, "secret", "", nil); code != http.StatusNotFound {
+		t.Fatalf("delete root: code=%d", code)
+	}
+	if code := do(http.MethodPatch, rootPath, "secret", body, nil); code != http.StatusNotFound {
+		t.Fatalf("deactivate root: code=%d", code)
+	}
+	if code := do(http.MethodGet, `/scim/v2/Users?filter=userName+eq+%22root%22`, "secret", "", &lst); code != http.StatusOK || lst.TotalResults != 0 {
+		t.Fatalf("list root: code=%d total=%d", code, lst.TotalResults)
+	}
+	rootBody := `{"schemas":["` + scimSchemaUser + `"],"userName":"root","active":false}`
+	if code := do(http.MethodPost, "/scim/v2/Users", "secret", rootBody, nil); code != http.StatusConflict {
+		t.Fatalf("link root: code=%d", code)
+	}
+
+	if code := do(http.MethodGet, "/scim/v2/Users?filter=nickname+eq+%22x%22", "secret", "", &e); code != http.StatusBadRequest {
+		t.Fatalf("bad filter: code=%d", code)
+	}
+	if code := do(http.MethodGet, "/scim/v2/Groups/999", "secret", "", &e); code != http.StatusNotFound || e.Status != "404" {
+		t.Fatalf("missing group: code=%d err=%+v", code, e)
+	}
+	if code := do(http.MethodDelete, "/scim/v2/Groups/"+group.Id, "secret", "", nil); code != http.StatusNoContent {
+		t.Fatalf("delete group: code=%d", code)
+	}
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (rt *Router) scrapeJobGets(c *gin.Context) {
+	lst, err := models.ScrapeJobGets(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+	if err == nil {
+		models.FillUpdateByNicknames(rt.Ctx, lst)
+	}
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func (rt *Router) scrapeJobGetsByService(c *gin.Context) {
+	lst, err := models.ScrapeJobEnabledGets(rt.Ctx)
+	ginx.NewRender(c).Data(lst, err)
+}
+
+func (rt *Router) scrapeJobGet(c *gin.Context) {
+	job, err := models.ScrapeJobGetById(rt.Ctx, ginx.UrlParamInt64(c, "sjid"))
+	ginx.Dangerous(err)
+
+	if job == nil {
+		ginx.Bomb(http.StatusNotFound, "No such scrape job")
+	}
+
+	rt.bgroCheck(c, job.GroupId)
+	ginx.NewRender(c).Data(job, nil)
+}
+
+func (rt *Router) scrapeJobAdd(c *gin.Context) {
+	var f models.ScrapeJob
+	ginx.BindJSON(c, &f)
+
+	username := c.MustGet("username").(string)
+	f.Id = 0
+	f.GroupId = ginx.UrlParamInt64(c, "id")
+	f.CreateBy = username
+	f.UpdateBy = username
+	rt.scrapeTargetGroupCheck(c, f)
+
+	ginx.Dangerous(f.Add(rt.Ctx))
+	ginx.NewRender(c).Data(f.Id, nil)
+}
+
+func (rt *Router) scrapeJobPut(c *gin.Context) {
+	var f models.ScrapeJob
+	ginx.BindJSON(c, &f)
+
+	job, err := models.ScrapeJobGetById(rt.Ctx, ginx.UrlParamInt64(c, "sjid"))
+	ginx.Dangerous(err)
+
+	if job == nil {
+		ginx.Bomb(http.StatusNotFound, "No such scrape job")
+	}
+
+	rt.bgrwCheck(c, job.GroupId)
+	rt.scrapeTargetGroupCheck(c, f)
+
+	f.UpdateBy = c.MustGet("username").(string)
+	ginx.NewRender(c).Message(job.Update(rt.Ctx, f))
+}
+
+func (rt *Router) scrapeJobDel(c *gin.Context) {
+	var f idsForm
+	ginx.BindJSON(c, &f)
+	f.Verify()
+
+	ginx.NewRender(c).Message(models.ScrapeJobDels(rt.Ctx, f.Ids, ginx.UrlParamInt64(c, "id")))
+}
+
+// scrapeTargetGroupCheck 按机器发现时会抓取 target_group_ids 里的机器，要求对这些业务组都有读权限
+func (rt *Router) scrapeTargetGroupCheck(c *gin.Context, f models.ScrapeJob) {
+	for _, bgid := range f.TargetGroupIds {
+		rt.bgroCheck(c, bgid)
+	}
+}
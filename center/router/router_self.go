@@ -1,6 +1,11 @@
 package router
 
 import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
 	"github.com/ccfos/nightingale/v6/models"
 	"github.com/ccfos/nightingale/v6/pkg/flashduty"
 	"github.com/ccfos/nightingale/v6/pkg/ormx"
@@ -81,12 +86,70 @@ func (rt *Router) selfPasswordPut(c *gin.Context) {
 		}
 	}
 
-	ginx.NewRender(c).Message(user.ChangePassword(rt.Ctx, oldPassWord, newPassWord))
+	ginx.Dangerous(rt.checkPasswordPolicy(user, newPassWord), http.StatusBadRequest)
+	ginx.Dangerous(user.ChangePassword(rt.Ctx, oldPassWord, newPassWord))
+
+	// 修改密码后其他设备上的登录失效
+	if err := rt.revokeSessions(c.Request.Context(), user.Id, c.GetString(sessionIdKey)); err != nil {
+		logger.Warningf("revoke sessions of user %s failed: %v", user.Username, err)
+	}
+
+	ginx.NewRender(c).Message(nil)
 }
 
 type tokenForm struct {
-	TokenName string `json:"token_name"`
-	Token     string `json:"token"`
+	TokenName    string   `json:"token_name"`
+	Token        string   `json:"token"`
+	ExpireAt     int64    `json:"expire_at"`
+	IpAllowlist  []string `json:"ip_allowlist"`
+	Scopes       []string `json:"scopes"`
+	BusiGroupIds []int64  `json:"busi_group_ids"`
+	ReadOnly     int      `json:"read_only"`
+}
+
+// verify 检查 token 的限制条件，业务组必须是当前用户有权限的
+func (f *tokenForm) verify(rt *Router, user *models.User) {
+	if f.ExpireAt != 0 && f.ExpireAt <= time.Now().Unix() {
+		ginx.Bomb(http.StatusBadRequest, "expire_at must be in the future")
+	}
+
+	for _, item := range f.IpAllowlist {
+		if net.ParseIP(item) == nil {
+			if _, _, err := net.ParseCIDR(item); err != nil {
+				ginx.Bomb(http.StatusBadRequest, "invalid ip or cidr: %s", item)
+			}
+		}
+	}
+
+	if len(f.Scopes) > 0 {
+		ops := make(map[string]struct{})
+		for _, op := range cconf.GetAllOps(cconf.Operations.Ops) {
+			ops[op.Name] = struct{}{}
+		}
+		for _, scope := range f.Scopes {
+			if _, has := ops[scope]; !has {
+				ginx.Bomb(http.StatusBadRequest, "unknown scope: %s", scope)
+			}
+		}
+	}
+
+	for _, bgid := range f.BusiGroupIds {
+		bg := BusiGroup(rt.Ctx, bgid)
+		can, err := user.CanDoBusiGroup(rt.Ctx, bg)
+		ginx.Dangerous(err)
+		if !can {
+			ginx.Bomb(http.StatusForbidden, "no permission to business group %s", bg.Name)
+		}
+	}
+}
+
+func (f *tokenForm) apply(t *models.UserToken) {
+	t.TokenName = f.TokenName
+	t.ExpireAt = f.ExpireAt
+	t.IpAllowlist = f.IpAllowlist
+	t.Scopes = f.Scopes
+	t.BusiGroupIds = f.BusiGroupIds
+	t.ReadOnly = f.ReadOnly
 }
 
 func (rt *Router) getToken(c *gin.Context) {
@@ -99,7 +162,9 @@ func (rt *Router) addToken(c *gin.Context) {
 	var f tokenForm
 	ginx.BindJSON(c, &f)
 
-	username := c.MustGet("username").(string)
+	user := c.MustGet("user").(*models.User)
+	f.verify(rt, user)
+	username := user.Username
 
 	tokens, err := models.GetTokensByUsername(rt.Ctx, username)
 	ginx.Dangerous(err)
@@ -111,12 +176,47 @@ func (rt *Router) addToken(c *gin.Context) {
 		}
 	}
 
-	token, err := models.AddToken(rt.Ctx, username, uuid.New().String(), f.TokenName)
+	token := &models.UserToken{Username: username, Token: uuid.New().String()}
+	f.apply(token)
+	token, err = models.AddTokenWithLimits(rt.Ctx, token)
 	ginx.NewRender(c).Data(token, err)
 }
 
+// selfToken 取当前用户自己的 token
+func (rt *Router) selfToken(c *gin.Context) *models.UserToken {
+	token, err := models.UserTokenGetById(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+	ginx.Dangerous(err)
+
+	if token == nil || token.Username != c.MustGet("username").(string) {
+		ginx.Bomb(http.StatusNotFound, "token not found")
+	}
+	return token
+}
+
+func (rt *Router) putToken(c *gin.Context) {
+	var f tokenForm
+	ginx.BindJSON(c, &f)
+
+	user := c.MustGet("user").(*models.User)
+	token := rt.selfToken(c)
+	f.verify(rt, user)
+
+	if f.TokenName != token.TokenName {
+		tokens, err := models.GetTokensByUsername(rt.Ctx, user.Username)
+		ginx.Dangerous(err)
+		for _, t := range tokens {
+			if t.TokenName == f.TokenName {
+				ginx.Bomb(http.StatusOK, "token name already exists")
+			}
+		}
+	}
+
+	f.apply(token)
+	ginx.NewRender(c).Data(token, token.UpdateLimits(rt.Ctx))
+}
+
 func (rt *Router) deleteToken(c *gin.Context) {
-	id := ginx.UrlParamInt64(c, "id")
+	id := rt.selfToken(c).Id
 	username := c.MustGet("username").(string)
 	tokenCount, err := models.CountToken(rt.Ctx, username)
 	ginx.Dangerous(err)
@@ -0,0 +1,224 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/logger"
+)
+
+// 会话的最后活跃时间最多这么久写一次 redis
+const sessionTouchInterval = time.Minute
+
+// sessionTouched 记录本实例上各会话最后一次写入活跃时间的时间
+var sessionTouched sync.Map
+
+// userSession 一次登录产生的会话，刷新 token 时沿用同一个会话。
+// 同一个用户的会话存在一个 redis hash 里，field 是会话 id
+type userSession struct {
+	Id          string `json:"id"`
+	Ip          string `json:"ip"`
+	UserAgent   string `json:"user_agent"`
+	CreateAt    int64  `json:"create_at"`
+	LastSeen    int64  `json:"last_seen"`
+	ExpireAt    int64  `json:"expire_at"` // refresh token 的过期时间
+	AccessUuid  string `json:"access_uuid,omitempty"`
+	RefreshUuid string `json:"refresh_uuid,omitempty"`
+	Current     bool   `json:"current"`
+}
+
+func (rt *Router) wrapSessionKey(userId int64) string {
+	return rt.wrapJwtKey(fmt.Sprintf("sessions/%d", userId))
+}
+
+func (rt *Router) getSession(ctx context.Context, userId int64, sessionId string) (*userSession, error) {
+	val, err := rt.Redis.HGet(ctx, rt.wrapSessionKey(userId), sessionId).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var s userSession
+	if err := json.Unmarshal([]byte(val), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (rt *Router) putSession(ctx context.Context, userId int64, s *userSession) error {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	key := rt.wrapSessionKey(userId)
+	if err := rt.Redis.HSet(ctx, key, s.Id, string(bs)).Err(); err != nil {
+		return err
+	}
+
+	// 整个 hash 跟着最晚过期的会话过期，单个过期的会话在列出时清理
+	if ttl, err := rt.Redis.TTL(ctx, key).Result(); err == nil && ttl < time.Until(time.Unix(s.ExpireAt, 0)) {
+		return rt.Redis.ExpireAt(ctx, key, time.Unix(s.ExpireAt, 0)).Err()
+	}
+	return nil
+}
+
+// saveSession 签发 token 之后记录会话，刷新 token 时保留会话的创建时间
+func (rt *Router) saveSession(c *gin.Context, userId int64, td *TokenDetails) error {
+	now := time.Now().Unix()
+	s := &userSession{Id: td.SessionId, CreateAt: now}
+	if old, err := rt.getSession(c.Request.Context(), userId, td.SessionId); err == nil {
+		s.CreateAt = old.CreateAt
+	}
+
+	s.Ip = c.ClientIP()
+	s.UserAgent = c.Request.UserAgent()
+	s.LastSeen = now
+	s.ExpireAt = td.RtExpires
+	s.AccessUuid = td.AccessUuid
+	s.RefreshUuid = td.RefreshUuid
+	return rt.putSession(c.Request.Context(), userId, s)
+}
+
+// touchSession 更新会话的最后活跃时间和 ip，失败不影响请求
+func (rt *Router) touchSession(c *gin.Context, userId int64, sessionId string) {
+	now := time.Now()
+	if last, ok := sessionTouched.Load(sessionId); ok && now.Sub(last.(time.Time)) < sessionTouchInterval {
+		return
+	}
+	sessionTouched.Store(sessionId, now)
+
+	s, err := rt.getSession(c.Request.Context(), userId, sessionId)
+	if err != nil {
+		return
+	}
+
+	s.LastSeen = now.Unix()
+	s.Ip = c.ClientIP()
+	if err := rt.putSession(c.Request.Context(), userId, s); err != nil {
+		logger.Warningf("update session %s of user %d failed: %v", sessionId, userId, err)
+	}
+}
+
+// userSessions 返回用户未过期的会话，最近活跃的在前
+func (rt *Router) userSessions(ctx context.Context, userId int64) ([]*userSession, error) {
+	key := rt.wrapSessionKey(userId)
+	m, err := rt.Redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	lst := make([]*userSession, 0, len(m))
+	for id, val := range m {
+		var s userSession
+		if err := json.Unmarshal([]byte(val), &s); err != nil || s.ExpireAt < now {
+			rt.Redis.HDel(ctx, key, id)
+			sessionTouched.Delete(id)
+			continue
+		}
+		lst = append(lst, &s)
+	}
+
+	sort.Slice(lst, func(i, j int) bool {
+		return lst[i].LastSeen > lst[j].LastSeen
+	})
+	return lst, nil
+}
+
+// revokeSession 删除会话的 access token 和 refresh token，会话立即失效
+func (rt *Router) revokeSession(ctx context.Context, userId int64, s *userSession) error {
+	keys := []string{rt.wrapJwtKey(s.AccessUuid), rt.wrapJwtKey(s.RefreshUuid)}
+	if err := rt.Redis.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+
+	sessionTouched.Delete(s.Id)
+	return rt.Redis.HDel(ctx, rt.wrapSessionKey(userId), s.Id).Err()
+}
+
+// revokeSessions 让用户除 keep 之外的所有会话失效，keep 为空表示全部失效
+func (rt *Router) revokeSessions(ctx context.Context, userId int64, keep string) error {
+	lst, err := rt.userSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range lst {
+		if s.Id == keep {
+			continue
+		}
+		if err := rt.revokeSession(ctx, userId, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeUsersSessions 注册为 models.UserRevokeHook，用户被删除或停用时调用
+func (rt *Router) revokeUsersSessions(c *ctx.Context, userIds []int64) {
+	for _, id := range userIds {
+		if err := rt.revokeSessions(c.GetContext(), id, ""); err != nil {
+			logger.Errorf("revoke sessions of user %d failed: %v", id, err)
+		}
+	}
+}
+
+func (rt *Router) renderSessions(c *gin.Context, userId int64) {
+	lst, err := rt.userSessions(c.Request.Context(), userId)
+	ginx.Dangerous(err)
+
+	current := c.GetString(sessionIdKey)
+	for _, s := range lst {
+		s.AccessUuid = ""
+		s.RefreshUuid = ""
+		s.Current = s.Id == current
+	}
+
+	ginx.NewRender(c).Data(lst, nil)
+}
+
+func (rt *Router) delSession(c *gin.Context, userId int64, sessionId string) {
+	s, err := rt.getSession(c.Request.Context(), userId, sessionId)
+	if err != nil {
+		ginx.Bomb(http.StatusNotFound, "no such session")
+	}
+
+	ginx.NewRender(c).Message(rt.revokeSession(c.Request.Context(), userId, s))
+}
+
+func (rt *Router) selfSessionGets(c *gin.Context) {
+	rt.renderSessions(c, c.MustGet("userid").(int64))
+}
+
+func (rt *Router) selfSessionDel(c *gin.Context) {
+	rt.delSession(c, c.MustGet("userid").(int64), ginx.UrlParamStr(c, "sid"))
+}
+
+// selfSessionsDel 退出其他所有设备上的登录，保留当前会话
+func (rt *Router) selfSessionsDel(c *gin.Context) {
+	ginx.NewRender(c).Message(rt.revokeSessions(c.Request.Context(), c.MustGet("userid").(int64), c.GetString(sessionIdKey)))
+}
+
+func (rt *Router) userSessionGets(c *gin.Context) {
+	rt.renderSessions(c, rt.tenantUser(c, ginx.UrlParamInt64(c, "id")).Id)
+}
+
+func (rt *Router) userSessionDel(c *gin.Context) {
+	rt.delSession(c, rt.tenantUser(c, ginx.UrlParamInt64(c, "id")).Id, ginx.UrlParamStr(c, "sid"))
+}
+
+// userSessionsDel 强制用户退出所有登录
+func (rt *Router) userSessionsDel(c *gin.Context) {
+	target := rt.tenantUser(c, ginx.UrlParamInt64(c, "id"))
+	logger.Infof("user %s revoked all sessions of user %s", c.GetString("username"), target.Username)
+	ginx.NewRender(c).Message(rt.revokeSessions(c.Request.Context(), target.Id, ""))
+}
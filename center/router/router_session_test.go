@@ -0,0 +1,265 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/aop"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/httpx"
+	"github.com/ccfos/nightingale/v6/pkg/totp"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newSessionTestRouter(t *testing.T) *Router {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Role{}, &models.UserTotp{}, &models.Configs{},
+		&models.UserPasswordHistory{}, &models.UserGroupMember{}, &models.UserToken{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.Role{Name: "Standard"})
+
+	mr := miniredis.RunT(t)
+	rt := &Router{
+		Ctx:   &ctx.Context{DB: db, IsCenter: true, Ctx: context.Background()},
+		Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+	}
+	rt.HTTP.JWTAuth = httpx.JWTAuth{SigningKey: "signing-key", AccessExpired: 60, RefreshExpired: 120, RedisKeyPrefix: "/jwt/"}
+
+	gin.SetMode(gin.TestMode)
+	return rt
+}
+
+func TestUserSessions(t *testing.T) {
+	rt := newSessionTestRouter(t)
+	c := rt.Ctx
+	pass, _ := models.CryptoPass(c, "pass")
+	c.DB.Create(&models.User{Id: 1, Username: "alice", Password: pass, Roles: "Standard", Contacts: []byte("{}")})
+
+	r := gin.New()
+	r.Use(aop.Recovery())
+	tokens := map[string]*TokenDetails{}
+	r.POST("/login", func(c *gin.Context) {
+		ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, "1-alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rt.createAuth(c, "1-alice", ts); err != nil {
+			t.Fatal(err)
+		}
+		tokens[c.Request.UserAgent()] = ts
+	})
+	r.POST("/refresh", func(c *gin.Context) {
+		old, _ := rt.getSession(c.Request.Context(), 1, tokens["laptop"].SessionId)
+		ts, _ := rt.createSessionTokens(rt.HTTP.JWTAuth.SigningKey, "1-alice", tokens["laptop"].SessionId)
+		if err := rt.createAuth(c, "1-alice", ts); err != nil {
+			t.Fatal(err)
+		}
+		s, _ := rt.getSession(c.Request.Context(), 1, tokens["laptop"].SessionId)
+		if s.CreateAt != old.CreateAt || s.AccessUuid != ts.AccessUuid {
+			t.Fatalf("session not reused: %+v", s)
+		}
+		tokens["laptop"] = ts
+	})
+	r.DELETE("/user/:id/session/:sid", func(c *gin.Context) {
+		c.Set(sessionIdKey, tokens["laptop"].SessionId)
+		rt.userSessionDel(c)
+	})
+
+	for _, ua := range []string{"laptop", "phone"} {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.Header.Set("User-Agent", ua)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lst, err := rt.userSessions(c.GetContext(), 1)
+	if err != nil || len(lst) != 2 {
+		t.Fatalf("expected 2 sessions, got %d %v", len(lst), err)
+	}
+
+	// token 里带着会话 id
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["laptop"].AccessToken)
+	md, err := rt.extractTokenMetadata(req)
+	if err != nil || md.SessionId != tokens["laptop"].SessionId {
+		t.Fatalf("unexpected metadata: %+v %v", md, err)
+	}
+
+	// 刷新 token 沿用原来的会话
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/refresh", nil))
+	if lst, _ := rt.userSessions(c.GetContext(), 1); len(lst) != 2 {
+		t.Fatalf("expected 2 sessions after refresh, got %d", len(lst))
+	}
+
+	// 踢掉一个会话，其他会话不受影响
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/user/1/session/"+tokens["phone"].SessionId, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke session: %d %s", w.Code, w.Body.String())
+	}
+	if _, err := rt.fetchAuth(c.GetContext(), tokens["phone"].AccessUuid); err == nil {
+		t.Fatal("revoked access token still valid")
+	}
+	if _, err := rt.fetchAuth(c.GetContext(), tokens["laptop"].AccessUuid); err != nil {
+		t.Fatalf("other session revoked: %v", err)
+	}
+
+	// 删除用户立即让所有会话失效
+	models.UserRevokeHook = rt.revokeUsersSessions
+	defer func() { models.UserRevokeHook = func(*ctx.Context, []int64) {} }()
+	u, _ := models.UserGetById(c, 1)
+	if err := u.Del(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.fetchAuth(c.GetContext(), tokens["laptop"].AccessUuid); err == nil {
+		t.Fatal("token of deleted user still valid")
+	}
+	if lst, _ := rt.userSessions(c.GetContext(), 1); len(lst) != 0 {
+		t.Fatalf("sessions of deleted user: %d", len(lst))
+	}
+}
+
+func TestLoginPasswordExpired(t *testing.T) {
+	rt := newSessionTestRouter(t)
+	rt.Center.PasswordPolicy = cconf.PasswordPolicy{MinLength: 8, MinClasses: 3, HistoryCount: 2, MaxAgeDays: 90}
+	c := rt.Ctx
+	pass, _ := models.CryptoPass(c, "Old-pass1")
+	c.DB.Create(&models.User{Id: 1, Username: "alice", Password: pass, Roles: "Standard", Contacts: []byte("{}"),
+		CreateAt: time.Now().Unix() - 100*86400})
+
+	r := gin.New()
+	r.Use(aop.Recovery())
+	// 模拟 loginPost 中密码校验通过之后的部分
+	r.POST("/login", func(c *gin.Context) {
+		user, _ := models.UserGetById(rt.Ctx, 1)
+		if !rt.passwordExpiredChallenge(c, user) {
+			c.String(http.StatusOK, "tokens issued with expired password")
+		}
+	})
+	r.POST("/auth/login/password", rt.loginPassword)
+
+	type result struct {
+		Dat struct {
+			PasswordExpired bool   `json:"password_expired"`
+			PasswordTicket  string `json:"password_ticket"`
+			AccessToken     string `json:"access_token"`
+		} `json:"dat"`
+		Err string `json:"err"`
+	}
+	do := func(path, body string) (int, result) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var ret result
+		json.Unmarshal(w.Body.Bytes(), &ret)
+		return w.Code, ret
+	}
+
+	_, ret := do("/login", "{}")
+	if !ret.Dat.PasswordExpired || ret.Dat.PasswordTicket == "" {
+		t.Fatalf("expected password change challenge, got %+v", ret)
+	}
+	ticket := ret.Dat.PasswordTicket
+
+	for _, weak := range []string{"short", "alllowercase1", "Old-pass1"} {
+		if code, ret := do("/auth/login/password", `{"ticket":"`+ticket+`","password":"`+weak+`"}`); code != http.StatusBadRequest {
+			t.Fatalf("%s should be rejected, got %d %+v", weak, code, ret)
+		}
+	}
+
+	code, ret := do("/auth/login/password", `{"ticket":"`+ticket+`","password":"New-pass1"}`)
+	if code != http.StatusOK || ret.Dat.AccessToken == "" {
+		t.Fatalf("expected tokens, got %d %+v", code, ret)
+	}
+
+	// 凭据只能用一次，新密码不再过期
+	if code, _ := do("/auth/login/password", `{"ticket":"`+ticket+`","password":"New-pass2"}`); code != http.StatusUnauthorized {
+		t.Fatalf("ticket reused: %d", code)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if !strings.Contains(w.Body.String(), "tokens issued") {
+		t.Fatalf("password still expired: %s", w.Body.String())
+	}
+}
+
+func TestLoginTotpBeforePasswordExpired(t *testing.T) {
+	rt := newSessionTestRouter(t)
+	rt.Center.PasswordPolicy = cconf.PasswordPolicy{MinLength: 8, MinClasses: 3, MaxAgeDays: 90}
+	c := rt.Ctx
+	pass, _ := models.CryptoPass(c, "Old-pass1")
+	c.DB.Create(&models.User{Id: 1, Username: "alice", Password: pass, Roles: "Standard", Contacts: []byte("{}"),
+		CreateAt: time.Now().Unix() - 100*86400})
+	ut, _ := models.UserTotpEnroll(c, 1)
+	code, _ := totp.CodeAt(ut.Secret, totp.Step(time.Now()))
+	if _, err := ut.Activate(c, code); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(aop.Recovery())
+	// 模拟 loginPost 中密码校验通过之后的部分
+	r.POST("/login", func(c *gin.Context) {
+		user, _ := models.UserGetById(rt.Ctx, 1)
+		if !rt.loginChallenge(c, user) {
+			c.String(http.StatusOK, "tokens issued without challenge")
+		}
+	})
+	r.POST("/auth/login/totp", rt.loginTotp)
+	r.POST("/auth/login/password", rt.loginPassword)
+
+	type result struct {
+		Dat struct {
+			TotpTicket     string `json:"totp_ticket"`
+			PasswordTicket string `json:"password_ticket"`
+			AccessToken    string `json:"access_token"`
+		} `json:"dat"`
+	}
+	do := func(path, body string) (int, result) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var ret result
+		json.Unmarshal(w.Body.Bytes(), &ret)
+		return w.Code, ret
+	}
+
+	// 只知道密码时拿不到修改密码的凭据
+	_, ret := do("/login", "{}")
+	if ret.Dat.TotpTicket == "" || ret.Dat.PasswordTicket != "" {
+		t.Fatalf("expected totp challenge first, got %+v", ret)
+	}
+	ticket := ret.Dat.TotpTicket
+	if status, ret := do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"000000"}`); status != http.StatusBadRequest || ret.Dat.PasswordTicket != "" {
+		t.Fatalf("wrong code: status=%d %+v", status, ret)
+	}
+
+	code, _ = totp.CodeAt(ut.Secret, totp.Step(time.Now())+1)
+	_, ret = do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"`+code+`"}`)
+	if ret.Dat.PasswordTicket == "" || ret.Dat.AccessToken != "" {
+		t.Fatalf("expected password change after totp, got %+v", ret)
+	}
+
+	status, ret := do("/auth/login/password", `{"ticket":"`+ret.Dat.PasswordTicket+`","password":"New-pass1"}`)
+	if status != http.StatusOK || ret.Dat.AccessToken == "" {
+		t.Fatalf("expected tokens, got %d %+v", status, ret)
+	}
+}
@@ -76,17 +76,28 @@ func (rt *Router) targetGets(c *gin.Context) {
 		}
 	} else {
 		user := c.MustGet("user").(*models.User)
-		if !user.IsAdmin() {
+		if !user.IsSuperAdmin() {
 			// 如果是非 admin 用户，全部对象的情况，找到用户有权限的业务组
 			var err error
-			bgids, err = models.MyBusiGroupIds(rt.Ctx, user.Id)
+			bgids, err = user.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 
-			// 将未分配业务组的对象也加入到列表中
-			bgids = append(bgids, 0)
+			// 将未分配业务组的对象也加入到列表中，这些对象属于平台，租户用户看不到
+			if user.TenantId == 0 {
+				bgids = append(bgids, 0)
+			}
 		}
 	}
 
+	bgids, visible := tokenBusiGroupFilter(c, bgids)
+	if !visible {
+		ginx.NewRender(c).Data(gin.H{
+			"list":  []*models.Target{},
+			"total": 0,
+		}, nil)
+		return
+	}
+
 	options := []models.BuildTargetWhereOption{
 		models.BuildTargetWhereWithBgids(bgids),
 		models.BuildTargetWhereWithDsIds(dsIds),
@@ -506,8 +517,8 @@ func (rt *Router) targetBindBgids(c *gin.Context) {
 	}
 
 	user := c.MustGet("user").(*models.User)
-	if !user.IsAdmin() {
-		// 普通用户，检查用户是否有权限操作所有请求的业务组
+	if !user.IsSuperAdmin() {
+		// 普通用户和租户管理员，检查用户是否有权限操作所有请求的业务组
 		existing, _, err := models.SeparateTargetIdents(rt.Ctx, f.Idents)
 		ginx.Dangerous(err)
 		rt.checkTargetPerm(c, existing)
@@ -545,7 +556,9 @@ func (rt *Router) targetBindBgids(c *gin.Context) {
 
 	switch f.Action {
 	case "add":
-		ginx.NewRender(c).Data(failedResults, models.TargetBindBgids(rt.Ctx, f.Idents, f.Bgids, f.Tags))
+		ginx.NewRender(c).Data(failedResults, rt.recordBgidChanges(f.Idents, user.Username, func() error {
+			return models.TargetBindBgids(rt.Ctx, f.Idents, f.Bgids, f.Tags)
+		}))
 	case "del":
 		// 非强制时逐台校验是否满足移出条件，有机器不满足则原样返回且不执行，前端确认后带 force=true 重试。
 		if !f.Force {
@@ -559,7 +572,9 @@ func (rt *Router) targetBindBgids(c *gin.Context) {
 				return
 			}
 		}
-		ginx.NewRender(c).Data(failedResults, models.TargetUnbindBgids(rt.Ctx, f.Idents, f.Bgids))
+		ginx.NewRender(c).Data(failedResults, rt.recordBgidChanges(f.Idents, user.Username, func() error {
+			return models.TargetUnbindBgids(rt.Ctx, f.Idents, f.Bgids)
+		}))
 	case "reset":
 		if !f.Force {
 			blocked, err := rt.TargetBgidChangeCheck(f.Idents, "reset", f.Bgids)
@@ -572,7 +587,9 @@ func (rt *Router) targetBindBgids(c *gin.Context) {
 				return
 			}
 		}
-		ginx.NewRender(c).Data(failedResults, models.TargetOverrideBgids(rt.Ctx, f.Idents, f.Bgids, f.Tags))
+		ginx.NewRender(c).Data(failedResults, rt.recordBgidChanges(f.Idents, user.Username, func() error {
+			return models.TargetOverrideBgids(rt.Ctx, f.Idents, f.Bgids, f.Tags)
+		}))
 	default:
 		ginx.Bomb(http.StatusBadRequest, "invalid action")
 	}
@@ -594,7 +611,65 @@ func (rt *Router) targetUpdateBgidByService(c *gin.Context) {
 		bombErr(http.StatusBadRequest, err)
 	}
 
-	ginx.NewRender(c).Data(failedResults, models.TargetOverrideBgids(rt.Ctx, f.Idents, []int64{f.Bgid}, nil))
+	ginx.NewRender(c).Data(failedResults, rt.recordBgidChanges(f.Idents, "service", func() error {
+		return models.TargetOverrideBgids(rt.Ctx, f.Idents, []int64{f.Bgid}, nil)
+	}))
+}
+
+// recordBgidChanges 执行业务组调整，并把每台机器调整前后的业务组写入变更历史
+func (rt *Router) recordBgidChanges(idents []string, operator string, change func() error) error {
+	before, err := models.TargetGroupIdsMapByIdents(rt.Ctx, idents)
+	if err != nil {
+		return err
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	after, err := models.TargetGroupIdsMapByIdents(rt.Ctx, idents)
+	if err != nil {
+		logger.Warningf("failed to get target group ids after change: %v", err)
+		return nil
+	}
+
+	if err := models.TargetHistoryAdd(rt.Ctx, models.TargetHistoryOfBgids(before, after, idents, operator)); err != nil {
+		logger.Warningf("failed to add target history: %v", err)
+	}
+
+	return nil
+}
+
+// recordTargetDeleted 手动删除机器后写入变更历史，before 是删除前机器所属的业务组
+func (rt *Router) recordTargetDeleted(idents []string, before map[string][]int64, operator string) {
+	lst := models.TargetHistoryOfBgids(before, nil, idents, operator)
+	for _, ident := range idents {
+		lst = append(lst, models.NewTargetHistory(ident, models.TargetHistoryFieldLifecycle, "",
+			models.TargetLifecycleDeleted, operator))
+	}
+
+	if err := models.TargetHistoryAdd(rt.Ctx, lst); err != nil {
+		logger.Warningf("failed to add target history: %v", err)
+	}
+}
+
+func (rt *Router) targetHistoryGets(c *gin.Context) {
+	ident := ginx.QueryStr(c, "ident")
+	field := ginx.QueryStr(c, "field", "")
+	limit := ginx.QueryInt(c, "limit", 20)
+
+	rt.checkTargetPerm(c, []string{ident})
+
+	total, err := models.TargetHistoryTotal(rt.Ctx, ident, field)
+	ginx.Dangerous(err)
+
+	lst, err := models.TargetHistoryGets(rt.Ctx, ident, field, limit, ginx.Offset(c, limit))
+	ginx.Dangerous(err)
+
+	ginx.NewRender(c).Data(gin.H{
+		"list":  lst,
+		"total": total,
+	}, nil)
 }
 
 type identsForm struct {
@@ -631,7 +706,12 @@ func (rt *Router) targetDel(c *gin.Context) {
 		}
 	}
 
-	ginx.NewRender(c).Data(failedResults, models.TargetDel(rt.Ctx, f.Idents, f.Force, rt.TargetDeleteHook))
+	before, _ := models.TargetGroupIdsMapByIdents(rt.Ctx, f.Idents)
+	err = models.TargetDel(rt.Ctx, f.Idents, f.Force, rt.TargetDeleteHook)
+	if err == nil {
+		rt.recordTargetDeleted(f.Idents, before, c.MustGet("username").(string))
+	}
+	ginx.NewRender(c).Data(failedResults, err)
 }
 
 func (rt *Router) targetDelByService(c *gin.Context) {
@@ -650,7 +730,12 @@ func (rt *Router) targetDelByService(c *gin.Context) {
 		bombErr(http.StatusBadRequest, err)
 	}
 
-	ginx.NewRender(c).Data(failedResults, models.TargetDel(rt.Ctx, f.Idents, true, rt.TargetDeleteHook))
+	before, _ := models.TargetGroupIdsMapByIdents(rt.Ctx, f.Idents)
+	err = models.TargetDel(rt.Ctx, f.Idents, true, rt.TargetDeleteHook)
+	if err == nil {
+		rt.recordTargetDeleted(f.Idents, before, "service")
+	}
+	ginx.NewRender(c).Data(failedResults, err)
 }
 
 func (rt *Router) checkTargetPerm(c *gin.Context, idents []string) {
@@ -730,13 +815,21 @@ func (rt *Router) targetStats(c *gin.Context) {
 		}
 	} else {
 		user := c.MustGet("user").(*models.User)
-		if !user.IsAdmin() {
-			bgids, err = models.MyBusiGroupIds(rt.Ctx, user.Id)
+		if !user.IsSuperAdmin() {
+			bgids, err = user.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
-			bgids = append(bgids, 0)
+			if user.TenantId == 0 {
+				bgids = append(bgids, 0)
+			}
 		}
 	}
 
+	bgids, visible := tokenBusiGroupFilter(c, bgids)
+	if !visible {
+		// 没有可见的业务组，用一个不存在的业务组过滤，统计结果为空
+		bgids = []int64{-1}
+	}
+
 	targets := rt.TargetCache.GetAll()
 	now := time.Now().Unix()
 
@@ -44,9 +44,9 @@ func (rt *Router) taskTplGetsByGids(c *gin.Context) {
 		}
 	} else {
 		me := c.MustGet("user").(*models.User)
-		if !me.IsAdmin() {
+		if !me.IsSuperAdmin() {
 			var err error
-			gids, err = models.MyBusiGroupIds(rt.Ctx, me.Id)
+			gids, err = me.VisibleBusiGroupIds(rt.Ctx)
 			ginx.Dangerous(err)
 
 			if len(gids) == 0 {
@@ -56,6 +56,12 @@ func (rt *Router) taskTplGetsByGids(c *gin.Context) {
 		}
 	}
 
+	gids, visible := tokenBusiGroupFilter(c, gids)
+	if !visible {
+		ginx.NewRender(c).Data([]int{}, nil)
+		return
+	}
+
 	authLevels := parseAuthLevels(ginx.QueryStr(c, "auth_level", ""))
 
 	total, err := models.TaskTplTotal(rt.Ctx, gids, query, authLevels)
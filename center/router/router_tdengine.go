@@ -21,6 +21,7 @@ func (rt *Router) tdengineDatabases(c *gin.Context) {
 	var f databasesQueryForm
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	datasource, hit := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if _, ok := datasource.(*tdengine.TDengine); !hit || !ok {
 		ginx.NewRender(c, http.StatusNotFound).Message("No such datasource")
@@ -49,6 +50,7 @@ func (rt *Router) tdengineTables(c *gin.Context) {
 	var f tablesQueryForm
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	datasource, hit := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if _, ok := datasource.(*tdengine.TDengine); !hit || !ok {
 		ginx.NewRender(c, http.StatusNotFound).Message("No such datasource")
@@ -75,6 +77,7 @@ func (rt *Router) tdengineColumns(c *gin.Context) {
 	var f columnsQueryForm
 	ginx.BindJSON(c, &f)
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	datasource, hit := dscache.DsCache.Get(f.Cate, f.DatasourceId)
 	if _, ok := datasource.(*tdengine.TDengine); !hit || !ok {
 		ginx.NewRender(c, http.StatusNotFound).Message("No such datasource")
@@ -431,6 +431,7 @@ func (rt *Router) datasourceTemplateMatch(c *gin.Context) {
 	if ds == nil {
 		ginx.Bomb(http.StatusNotFound, "datasource not found")
 	}
+	rt.checkDsQueryPerm(c, req.Id)
 	if ds.PluginType != models.PROMETHEUS {
 		ginx.Bomb(http.StatusBadRequest, "only prometheus-like datasource is supported")
 	}
@@ -0,0 +1,165 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/logger"
+)
+
+// superAdmin 租户的增删改查只有平台管理员可以操作
+func (rt *Router) superAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		me := c.MustGet("user").(*models.User)
+		if !me.IsSuperAdmin() {
+			ginx.Bomb(http.StatusForbidden, "forbidden")
+		}
+		c.Next()
+	}
+}
+
+// platformUser CMDB 同步这类全局配置会改动所有机器，只对平台用户开放，租户管理员也不行
+func (rt *Router) platformUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		me := c.MustGet("user").(*models.User)
+		if me.TenantId != 0 {
+			ginx.Bomb(http.StatusForbidden, "forbidden")
+		}
+		c.Next()
+	}
+}
+
+// checkTenant 资源属于其他租户时当作不存在
+func checkTenant(c *gin.Context, tid int64) {
+	if me, ok := c.Get("user"); ok && !me.(*models.User).TenantVisible(tid) {
+		ginx.Bomb(http.StatusNotFound, "not found")
+	}
+}
+
+// checkTenantShared 通知媒介、消息模板这类公共资源，租户用户可以读取平台的，只能修改本租户的
+func checkTenantShared(c *gin.Context, tid int64, write bool) {
+	me, ok := c.Get("user")
+	if !ok {
+		return
+	}
+
+	user := me.(*models.User)
+	if !user.TenantShared(tid) {
+		ginx.Bomb(http.StatusNotFound, "not found")
+	}
+
+	if write && tid == 0 && user.TenantId > 0 {
+		ginx.Bomb(http.StatusForbidden, "shared resources can only be modified by platform admins")
+	}
+}
+
+// tenantUser 按 id 取用户，其他租户的用户当作不存在
+func (rt *Router) tenantUser(c *gin.Context, id int64) *models.User {
+	target := User(rt.Ctx, id)
+	if me := rt.aclUser(c); me != nil && !me.TenantVisible(target.TenantId) {
+		ginx.Bomb(http.StatusNotFound, "No such user")
+	}
+	return target
+}
+
+// checkRuleQuota 在业务组 bgid 下新增 n 条告警规则之前，检查业务组所属租户的配额
+func (rt *Router) checkRuleQuota(bgid int64, n int) error {
+	bg, err := models.BusiGroupGetById(rt.Ctx, bgid)
+	if err != nil || bg == nil {
+		return err
+	}
+	return models.TenantCheckRuleQuota(rt.Ctx, bg.TenantId, n)
+}
+
+func (rt *Router) tenantGets(c *gin.Context) {
+	lst, err := models.TenantGets(rt.Ctx, ginx.QueryStr(c, "query", ""))
+	ginx.Dangerous(err)
+
+	for _, t := range lst {
+		ginx.Dangerous(t.FillCount(rt.Ctx))
+	}
+
+	ginx.NewRender(c).Data(lst, nil)
+}
+
+func (rt *Router) tenantGet(c *gin.Context) {
+	t := rt.tenant(ginx.UrlParamInt64(c, "id"))
+	ginx.Dangerous(t.FillCount(rt.Ctx))
+	ginx.NewRender(c).Data(t, nil)
+}
+
+func (rt *Router) tenant(id int64) *models.Tenant {
+	t, err := models.TenantGetById(rt.Ctx, id)
+	ginx.Dangerous(err)
+
+	if t == nil {
+		ginx.Bomb(http.StatusNotFound, "No such tenant")
+	}
+	return t
+}
+
+type tenantForm struct {
+	Name        string `json:"name" binding:"required"`
+	Note        string `json:"note"`
+	RuleQuota   int64  `json:"rule_quota"`
+	TargetQuota int64  `json:"target_quota"`
+}
+
+func (rt *Router) tenantAdd(c *gin.Context) {
+	var f tenantForm
+	ginx.BindJSON(c, &f)
+
+	username := Username(c)
+	t := models.Tenant{
+		Name:        f.Name,
+		Note:        f.Note,
+		RuleQuota:   f.RuleQuota,
+		TargetQuota: f.TargetQuota,
+		CreateBy:    username,
+		UpdateBy:    username,
+	}
+
+	ginx.Dangerous(t.Add(rt.Ctx))
+	ginx.NewRender(c).Data(t.Id, nil)
+}
+
+func (rt *Router) tenantPut(c *gin.Context) {
+	var f tenantForm
+	ginx.BindJSON(c, &f)
+
+	t := rt.tenant(ginx.UrlParamInt64(c, "id"))
+	ginx.NewRender(c).Message(t.Update(rt.Ctx, models.Tenant{
+		Name:        f.Name,
+		Note:        f.Note,
+		RuleQuota:   f.RuleQuota,
+		TargetQuota: f.TargetQuota,
+		UpdateBy:    Username(c),
+	}))
+}
+
+func (rt *Router) tenantDel(c *gin.Context) {
+	t := rt.tenant(ginx.UrlParamInt64(c, "id"))
+	ginx.NewRender(c).Message(t.Del(rt.Ctx))
+}
+
+type tenantAssignForm struct {
+	Resource string  `json:"resource" binding:"required"`
+	Ids      []int64 `json:"ids" binding:"required"`
+}
+
+// tenantAssign 把资源移动到租户下，id 为 0 表示移回平台。新建的资源归属创建者所在的租户，
+// 存量资源由平台管理员通过这个接口分配
+func (rt *Router) tenantAssign(c *gin.Context) {
+	var f tenantAssignForm
+	ginx.BindJSON(c, &f)
+
+	tid := ginx.UrlParamInt64(c, "id")
+	err := models.TenantAssign(rt.Ctx, tid, f.Resource, f.Ids)
+	if err == nil {
+		logger.Infof("user %s moved %s %v to tenant %d", Username(c), f.Resource, f.Ids, tid)
+	}
+	ginx.NewRender(c).Message(err)
+}
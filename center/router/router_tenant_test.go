@@ -0,0 +1,161 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/memsto"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/aop"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Tenant{}, &models.Datasource{}, &models.DatasourceAcl{}, &models.User{},
+		&models.UserGroupMember{}, &models.BusiGroup{}, &models.BusiGroupMember{}, &models.AlertRule{},
+		&models.TargetBusiGroup{}, &models.ScrapeJob{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.Tenant{Id: 1, Name: "sub-a"})
+	db.Create(&models.Tenant{Id: 2, Name: "sub-b"})
+	for _, ds := range []*models.Datasource{
+		{Id: 1, Name: "prom-a", PluginType: models.PROMETHEUS, Settings: "{}", HTTP: "{}", Auth: "{}", UpdatedAt: 1, TenantId: 1},
+		{Id: 2, Name: "prom-b", PluginType: models.PROMETHEUS, Settings: "{}", HTTP: "{}", Auth: "{}", UpdatedAt: 1, TenantId: 2},
+	} {
+		if err := db.Create(ds).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Create(&models.User{Id: 1, Username: "root", Roles: models.AdminRole, Contacts: []byte("{}")})
+	db.Create(&models.User{Id: 2, Username: "admin-a", Roles: models.AdminRole, Contacts: []byte("{}"), TenantId: 1})
+	db.Create(&models.User{Id: 3, Username: "alice", Roles: "Standard", Contacts: []byte("{}"), TenantId: 1})
+	db.Create(&models.User{Id: 4, Username: "bob", Roles: "Standard", Contacts: []byte("{}"), TenantId: 2})
+
+	c := &ctx.Context{DB: db, IsCenter: true}
+	stats := &memsto.Stats{
+		GaugeCronDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "duration"}, []string{"name"}),
+		GaugeSyncNumber:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "sync_number"}, []string{"name"}),
+	}
+	rt := &Router{Ctx: c, DatasourceCache: memsto.NewDatasourceCache(c, stats)}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(aop.Recovery())
+	asUser := func(c *gin.Context) {
+		u, err := models.UserGetById(rt.Ctx, ginx.UrlParamInt64(c, "uid"))
+		ginx.Dangerous(err)
+		c.Set("user", u)
+		c.Set("username", u.Username)
+		c.Next()
+	}
+	r.GET("/:uid/query/:ds", asUser, func(c *gin.Context) {
+		rt.checkDsQueryPerm(c, ginx.UrlParamInt64(c, "ds"))
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/anonymous/query/:ds", func(c *gin.Context) {
+		rt.checkDsQueryPerm(c, ginx.UrlParamInt64(c, "ds"))
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/:uid/user/:id/profile", asUser, rt.userProfileGet)
+	r.GET("/:uid/tenants", asUser, rt.superAdmin(), rt.tenantGets)
+	r.GET("/:uid/cmdb-syncs", asUser, rt.platformUser(), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/1/query/2", http.StatusOK},        // 超级管理员可以跨租户
+		{"/2/query/1", http.StatusOK},        // 本租户的数据源
+		{"/2/query/2", http.StatusForbidden}, // 租户管理员也不能查其他租户的数据源
+		{"/3/query/1", http.StatusOK},        // 没有配置授权的数据源对本租户用户开放
+		{"/4/query/1", http.StatusForbidden},
+		{"/anonymous/query/1", http.StatusForbidden}, // 租户的数据源不能匿名查询
+		{"/2/user/3/profile", http.StatusOK},
+		{"/2/user/4/profile", http.StatusNotFound}, // 其他租户的用户当作不存在
+		{"/1/user/4/profile", http.StatusOK},
+		{"/1/tenants", http.StatusOK},
+		{"/2/tenants", http.StatusForbidden}, // 租户管理员不能管理租户
+		{"/1/cmdb-syncs", http.StatusOK},
+		{"/2/cmdb-syncs", http.StatusForbidden}, // CMDB 同步会改动所有机器，租户管理员不能使用
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d %s", tc.path, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	// 不能把其他租户的告警规则克隆到自己的业务组里
+	db.Create(&models.BusiGroup{Id: 1, Name: "bg-a", TenantId: 1})
+	db.Create(&models.BusiGroup{Id: 2, Name: "bg-b", TenantId: 2})
+	db.Create(&models.AlertRule{Id: 1, GroupId: 2, Name: "rule-b", Cate: models.PROMETHEUS})
+	r.POST("/:uid/clones", asUser, rt.batchAlertRuleClone)
+	r.POST("/:uid/busi-group/:id/clone", asUser, rt.cloneToMachine)
+	r.POST("/:uid/busi-group/:id/scrape-jobs", asUser, rt.bgrw(), rt.scrapeJobAdd)
+	for _, tc := range []struct {
+		path string
+		body string
+	}{
+		{"/2/clones", `{"rule_ids":[1],"bgids":[1]}`},
+		{"/2/busi-group/1/clone", `{"ids":[1],"ident_list":["host-1"]}`},
+		// 抓取任务不能发现其他租户业务组里的机器
+		{"/2/busi-group/1/scrape-jobs", `{"name":"node","sd_type":"target","target_port":9100,"target_group_ids":[2]}`},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected %d, got %d %s", tc.path, http.StatusForbidden, w.Code, w.Body.String())
+		}
+	}
+
+	// 角色、内置组件等是平台全局对象，租户管理员即使是 Admin 也不能改
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	r.POST("/:uid/roles", asUser, rt.platformUser(), rt.perm("/roles/add"), ok)
+	r.POST("/:uid/builtin-payloads", asUser, rt.platformUser(), rt.perm("/components/add"), ok)
+	r.PUT("/:uid/notify-tpl", asUser, rt.platformUser(), rt.perm("/notification-templates/put"), ok)
+	for _, tc := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodPost, "/1/roles", http.StatusOK},
+		{http.MethodPost, "/2/roles", http.StatusForbidden},
+		{http.MethodPost, "/1/builtin-payloads", http.StatusOK},
+		{http.MethodPost, "/2/builtin-payloads", http.StatusForbidden},
+		{http.MethodPut, "/2/notify-tpl", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s %s: expected %d, got %d %s", tc.method, tc.path, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	alice, _ := models.UserGetById(c, 3)
+	if lst := rt.DatasourceCache.DatasourceFilter([]*models.Datasource{{Id: 1, TenantId: 1}, {Id: 2, TenantId: 2}}, alice); len(lst) != 1 || lst[0].Id != 1 {
+		t.Fatalf("unexpected filter result: %+v", lst)
+	}
+
+	// 租户用户的通配规则只匹配本租户的数据源
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Set("user", alice)
+	rule := &models.AlertRule{Cate: models.PROMETHEUS, DatasourceQueries: []models.DatasourceQuery{models.DataSourceQueryAll}}
+	if err := rt.checkRuleDatasources(gc, rule); err != nil {
+		t.Fatalf("rule on all datasources of the tenant: %v", err)
+	}
+}
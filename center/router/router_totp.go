@@ -0,0 +1,247 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+	"github.com/ccfos/nightingale/v6/pkg/totp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	totpTicketRedisPrefix = "/totp/ticket/"
+	totpTicketTTL         = 5 * time.Minute
+	// 一个登录凭据最多尝试的次数，超过后需要重新输入密码
+	totpTicketMaxAttempts = 5
+)
+
+// totpChallenge 密码校验通过后调用。用户已启用两步验证，或者角色要求启用时，
+// 不直接签发 token，而是返回一个短期凭据，由前端带着验证码调用 /auth/login/totp
+func (rt *Router) totpChallenge(c *gin.Context, user *models.User) bool {
+	t, err := models.UserTotpGet(rt.Ctx, user.Id)
+	ginx.Dangerous(err)
+
+	enrolled := t != nil && t.Enabled == 1
+	if !enrolled {
+		required, err := models.TotpRequiredByRoles(rt.Ctx, user.RolesLst)
+		ginx.Dangerous(err)
+		if !required {
+			return false
+		}
+	}
+
+	ticket := uuid.NewString()
+	ginx.Dangerous(rt.Redis.Set(c.Request.Context(), totpTicketRedisPrefix+ticket, user.Id, totpTicketTTL).Err())
+
+	ginx.NewRender(c).Data(gin.H{
+		"totp_required": true,
+		"totp_enrolled": enrolled,
+		"totp_ticket":   ticket,
+	}, nil)
+	return true
+}
+
+// totpTicketUser 根据登录凭据找到用户，attempt 为 true 时计入尝试次数
+func (rt *Router) totpTicketUser(c *gin.Context, ticket string, attempt bool) *models.User {
+	key := totpTicketRedisPrefix + ticket
+	val, err := rt.Redis.Get(c.Request.Context(), key).Result()
+	if err != nil || ticket == "" {
+		ginx.Bomb(http.StatusUnauthorized, "login expired, please login again")
+	}
+
+	if attempt {
+		n, err := rt.Redis.Incr(c.Request.Context(), key+"/attempts").Result()
+		ginx.Dangerous(err)
+		if n == 1 {
+			rt.Redis.Expire(c.Request.Context(), key+"/attempts", totpTicketTTL)
+		}
+		if n > totpTicketMaxAttempts {
+			rt.Redis.Del(c.Request.Context(), key, key+"/attempts")
+			ginx.Bomb(http.StatusUnauthorized, "too many attempts, please login again")
+		}
+	}
+
+	id, _ := strconv.ParseInt(val, 10, 64)
+	user, err := models.UserGetById(rt.Ctx, id)
+	ginx.Dangerous(err)
+	if user == nil || user.Disabled == 1 {
+		ginx.Bomb(http.StatusUnauthorized, "login expired, please login again")
+	}
+	return user
+}
+
+type totpLoginForm struct {
+	Ticket string `json:"ticket" binding:"required"`
+	Code   string `json:"code"`
+}
+
+// loginTotpEnroll 角色要求两步验证但用户还没有启用时，登录过程中先生成密钥
+func (rt *Router) loginTotpEnroll(c *gin.Context) {
+	var f totpLoginForm
+	ginx.BindJSON(c, &f)
+
+	user := rt.totpTicketUser(c, f.Ticket, false)
+	t, err := models.UserTotpEnroll(rt.Ctx, user.Id)
+	ginx.Dangerous(err)
+
+	ginx.NewRender(c).Data(rt.totpEnrollOutput(user, t), nil)
+}
+
+// loginTotp 登录的第二步，校验验证码或恢复码后签发 token。用户还没启用时，这一步同时完成启用
+func (rt *Router) loginTotp(c *gin.Context) {
+	var f totpLoginForm
+	ginx.BindJSON(c, &f)
+
+	user := rt.totpTicketUser(c, f.Ticket, true)
+	t, err := models.UserTotpGet(rt.Ctx, user.Id)
+	ginx.Dangerous(err)
+	if t == nil {
+		ginx.Bomb(http.StatusBadRequest, "two-factor authentication is not enrolled")
+	}
+
+	var recoveryCodes []string
+	if t.Enabled == 1 {
+		ok, err := t.Verify(rt.Ctx, f.Code)
+		ginx.Dangerous(err)
+		if !ok {
+			ginx.Bomb(http.StatusBadRequest, "invalid verification code")
+		}
+	} else {
+		recoveryCodes, err = t.Activate(rt.Ctx, f.Code)
+		ginx.Dangerous(err, http.StatusBadRequest)
+		logger.Infof("user %s enabled two-factor authentication at login", user.Username)
+	}
+
+	key := totpTicketRedisPrefix + f.Ticket
+	rt.Redis.Del(c.Request.Context(), key, key+"/attempts")
+
+	if rt.passwordExpiredChallenge(c, user, recoveryCodes...) {
+		return
+	}
+
+	userIdentity := fmt.Sprintf("%d-%s", user.Id, user.Username)
+	ts, err := rt.createTokens(rt.HTTP.JWTAuth.SigningKey, userIdentity)
+	ginx.Dangerous(err)
+	ginx.Dangerous(rt.createAuth(c, userIdentity, ts))
+
+	ginx.NewRender(c).Data(gin.H{
+		"user":           user,
+		"access_token":   ts.AccessToken,
+		"refresh_token":  ts.RefreshToken,
+		"recovery_codes": recoveryCodes,
+	}, nil)
+}
+
+func (rt *Router) totpEnrollOutput(user *models.User, t *models.UserTotp) gin.H {
+	return gin.H{
+		"secret": t.Secret,
+		"uri":    totp.URI(rt.Center.TOTP.Issuer, user.Username, t.Secret),
+	}
+}
+
+func (rt *Router) selfTotpGet(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	t, err := models.UserTotpGet(rt.Ctx, user.Id)
+	ginx.Dangerous(err)
+	required, err := models.TotpRequiredByRoles(rt.Ctx, user.RolesLst)
+	ginx.Dangerous(err)
+
+	ret := gin.H{"enabled": false, "required": required, "recovery_codes_left": 0}
+	if t != nil && t.Enabled == 1 {
+		ret["enabled"] = true
+		ret["recovery_codes_left"] = t.RecoveryCodesLeft()
+	}
+	ginx.NewRender(c).Data(ret, nil)
+}
+
+func (rt *Router) selfTotpEnroll(c *gin.Context) {
+	user := c.MustGet("user").(*models.User)
+
+	t, err := models.UserTotpEnroll(rt.Ctx, user.Id)
+	ginx.Dangerous(err)
+
+	ginx.NewRender(c).Data(rt.totpEnrollOutput(user, t), nil)
+}
+
+type totpCodeForm struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// selfTotp 取当前用户的两步验证记录，未启用时报错
+func (rt *Router) selfTotp(c *gin.Context, user *models.User, enabled bool) *models.UserTotp {
+	t, err := models.UserTotpGet(rt.Ctx, user.Id)
+	ginx.Dangerous(err)
+	if t == nil || (enabled && t.Enabled != 1) {
+		ginx.Bomb(http.StatusBadRequest, "two-factor authentication is not enabled")
+	}
+	return t
+}
+
+func (rt *Router) selfTotpActivate(c *gin.Context) {
+	var f totpCodeForm
+	ginx.BindJSON(c, &f)
+
+	user := c.MustGet("user").(*models.User)
+	t := rt.selfTotp(c, user, false)
+
+	codes, err := t.Activate(rt.Ctx, f.Code)
+	ginx.Dangerous(err, http.StatusBadRequest)
+	logger.Infof("user %s enabled two-factor authentication", user.Username)
+
+	ginx.NewRender(c).Data(gin.H{"recovery_codes": codes}, nil)
+}
+
+func (rt *Router) selfTotpRecoveryCodes(c *gin.Context) {
+	var f totpCodeForm
+	ginx.BindJSON(c, &f)
+
+	user := c.MustGet("user").(*models.User)
+	t := rt.selfTotp(c, user, true)
+
+	ok, err := t.Verify(rt.Ctx, f.Code)
+	ginx.Dangerous(err)
+	if !ok {
+		ginx.Bomb(http.StatusBadRequest, "invalid verification code")
+	}
+
+	codes, err := t.ResetRecoveryCodes(rt.Ctx)
+	ginx.NewRender(c).Data(gin.H{"recovery_codes": codes}, err)
+}
+
+func (rt *Router) selfTotpDel(c *gin.Context) {
+	var f totpCodeForm
+	ginx.BindJSON(c, &f)
+
+	user := c.MustGet("user").(*models.User)
+	required, err := models.TotpRequiredByRoles(rt.Ctx, user.RolesLst)
+	ginx.Dangerous(err)
+	if required {
+		ginx.Bomb(http.StatusForbidden, "two-factor authentication is required by your role")
+	}
+
+	t := rt.selfTotp(c, user, true)
+	ok, err := t.Verify(rt.Ctx, f.Code)
+	ginx.Dangerous(err)
+	if !ok {
+		ginx.Bomb(http.StatusBadRequest, "invalid verification code")
+	}
+
+	logger.Infof("user %s disabled two-factor authentication", user.Username)
+	ginx.NewRender(c).Message(models.UserTotpDel(rt.Ctx, user.Id))
+}
+
+// userTotpDel 管理员重置用户的两步验证，用于用户丢失设备且恢复码用完的情况。
+// 角色要求两步验证的用户下次登录时会重新绑定
+func (rt *Router) userTotpDel(c *gin.Context) {
+	target := rt.tenantUser(c, ginx.UrlParamInt64(c, "id"))
+	logger.Infof("user %s reset two-factor authentication of %s", c.MustGet("username"), target.Username)
+	ginx.NewRender(c).Message(models.UserTotpDel(rt.Ctx, target.Id))
+}
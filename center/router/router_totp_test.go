@@ -0,0 +1,141 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/aop"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/httpx"
+	"github.com/ccfos/nightingale/v6/pkg/totp"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLoginTotp(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Role{}, &models.UserTotp{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.Role{Name: "Admin", TotpRequired: 1})
+	db.Create(&models.User{Id: 1, Username: "root", Roles: "Admin", Contacts: []byte("{}")})
+
+	mr := miniredis.RunT(t)
+	rt := &Router{
+		Center: cconf.Center{TOTP: cconf.TOTP{Issuer: "n9e"}},
+		Ctx:    &ctx.Context{DB: db, IsCenter: true},
+		Redis:  redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+	}
+	rt.HTTP.JWTAuth = httpx.JWTAuth{SigningKey: "signing-key", AccessExpired: 60, RefreshExpired: 120}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(aop.Recovery())
+	// 模拟 loginPost 中密码校验通过之后的部分
+	r.POST("/login", func(c *gin.Context) {
+		user, _ := models.UserGetById(rt.Ctx, 1)
+		if !rt.totpChallenge(c, user) {
+			c.String(http.StatusOK, "tokens issued without totp")
+		}
+	})
+	r.POST("/auth/login/totp", rt.loginTotp)
+	r.POST("/auth/login/totp/enroll", rt.loginTotpEnroll)
+
+	type result struct {
+		Dat struct {
+			TotpTicket    string   `json:"totp_ticket"`
+			TotpEnrolled  bool     `json:"totp_enrolled"`
+			Secret        string   `json:"secret"`
+			Uri           string   `json:"uri"`
+			AccessToken   string   `json:"access_token"`
+			RecoveryCodes []string `json:"recovery_codes"`
+		} `json:"dat"`
+		Err string `json:"err"`
+	}
+	do := func(path, body string) (int, result) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var ret result
+		json.Unmarshal(w.Body.Bytes(), &ret)
+		return w.Code, ret
+	}
+
+	// 角色要求两步验证，还没有绑定时先绑定
+	_, ret := do("/login", "{}")
+	ticket := ret.Dat.TotpTicket
+	if ticket == "" || ret.Dat.TotpEnrolled {
+		t.Fatalf("expected a totp challenge for an unenrolled user: %+v", ret)
+	}
+
+	_, ret = do("/auth/login/totp/enroll", `{"ticket":"`+ticket+`"}`)
+	secret := ret.Dat.Secret
+	if secret == "" || !strings.HasPrefix(ret.Dat.Uri, "otpauth://totp/n9e:root?") {
+		t.Fatalf("unexpected enroll output: %+v", ret)
+	}
+
+	if code, _ := do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"000000"}`); code != http.StatusBadRequest {
+		t.Fatalf("wrong code: status=%d", code)
+	}
+
+	code, _ := totp.CodeAt(secret, totp.Step(time.Now()))
+	_, ret = do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"`+code+`"}`)
+	if ret.Dat.AccessToken == "" || len(ret.Dat.RecoveryCodes) != 10 {
+		t.Fatalf("expected tokens and recovery codes: %+v", ret)
+	}
+	recovery := ret.Dat.RecoveryCodes[0]
+
+	// 凭据只能用一次
+	if status, _ := do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"`+recovery+`"}`); status != http.StatusUnauthorized {
+		t.Fatalf("reused ticket: status=%d", status)
+	}
+
+	// 已绑定的用户可以用恢复码登录，但不能重新绑定
+	_, ret = do("/login", "{}")
+	ticket = ret.Dat.TotpTicket
+	if !ret.Dat.TotpEnrolled {
+		t.Fatalf("user should be enrolled: %+v", ret)
+	}
+	if _, ret = do("/auth/login/totp/enroll", `{"ticket":"`+ticket+`"}`); ret.Err == "" {
+		t.Fatal("enrolled user should not enroll again during login")
+	}
+	if _, ret = do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"`+recovery+`"}`); ret.Dat.AccessToken == "" {
+		t.Fatalf("login with recovery code failed: %+v", ret)
+	}
+
+	// 超过尝试次数后凭据作废
+	_, ret = do("/login", "{}")
+	ticket = ret.Dat.TotpTicket
+	for i := 0; i < totpTicketMaxAttempts; i++ {
+		do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"000000"}`)
+	}
+	code, _ = totp.CodeAt(secret, totp.Step(time.Now())+1)
+	if status, _ := do("/auth/login/totp", `{"ticket":"`+ticket+`","code":"`+code+`"}`); status != http.StatusUnauthorized {
+		t.Fatalf("too many attempts: status=%d", status)
+	}
+
+	// 角色不再要求且用户重置后，直接签发 token
+	db.Model(&models.Role{}).Where("name = ?", "Admin").Update("totp_required", 0)
+	models.UserTotpDel(rt.Ctx, 1)
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Body.String() != "tokens issued without totp" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+}
@@ -66,15 +66,15 @@ func (rt *Router) userGets(c *gin.Context) {
 		emails = []string{}
 	}
 
+	user := c.MustGet("user").(*models.User)
+
 	go rt.UserCache.UpdateUsersLastActiveTime()
-	total, err := models.UserTotal(rt.Ctx, query, stime, etime)
+	total, err := models.UserTotal(rt.Ctx, query, stime, etime, user.TenantId)
 	ginx.Dangerous(err)
 
-	list, err := models.UserGets(rt.Ctx, query, limit, ginx.Offset(c, limit), stime, etime, order, desc, usernames, phones, emails)
+	list, err := models.UserGets(rt.Ctx, query, limit, ginx.Offset(c, limit), stime, etime, order, desc, usernames, phones, emails, user.TenantId)
 	ginx.Dangerous(err)
 
-	user := c.MustGet("user").(*models.User)
-
 	ginx.NewRender(c).Data(gin.H{
 		"list":  list,
 		"total": total,
@@ -108,6 +108,8 @@ func (rt *Router) userAddPost(c *gin.Context) {
 		authPassWord = decPassWord
 	}
 
+	ginx.Dangerous(rt.checkPasswordPolicy(nil, authPassWord), http.StatusBadRequest)
+
 	password, err := models.CryptoPass(rt.Ctx, authPassWord)
 	ginx.Dangerous(err)
 
@@ -128,6 +130,7 @@ func (rt *Router) userAddPost(c *gin.Context) {
 		Contacts: f.Contacts,
 		CreateBy: username,
 		UpdateBy: username,
+		TenantId: c.MustGet("user").(*models.User).TenantId,
 	}
 
 	ginx.Dangerous(u.Verify())
@@ -135,7 +138,7 @@ func (rt *Router) userAddPost(c *gin.Context) {
 }
 
 func (rt *Router) userProfileGet(c *gin.Context) {
-	user := User(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+	user := rt.tenantUser(c, ginx.UrlParamInt64(c, "id"))
 	ginx.NewRender(c).Data(user, nil)
 }
 
@@ -179,7 +182,7 @@ func (rt *Router) userProfilePut(c *gin.Context) {
 		ginx.Bomb(http.StatusBadRequest, "roles empty")
 	}
 
-	target := User(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+	target := rt.tenantUser(c, ginx.UrlParamInt64(c, "id"))
 	oldInfo := models.User{
 		Username: target.Username,
 		Phone:    target.Phone,
@@ -207,7 +210,7 @@ func (rt *Router) userPasswordPut(c *gin.Context) {
 	var f userPasswordForm
 	ginx.BindJSON(c, &f)
 
-	target := User(rt.Ctx, ginx.UrlParamInt64(c, "id"))
+	target := rt.tenantUser(c, ginx.UrlParamInt64(c, "id"))
 
 	authPassWord := f.Password
 	if rt.HTTP.RSA.OpenRSA {
@@ -220,10 +223,18 @@ func (rt *Router) userPasswordPut(c *gin.Context) {
 		authPassWord = decPassWord
 	}
 
+	ginx.Dangerous(rt.checkPasswordPolicy(target, authPassWord), http.StatusBadRequest)
+
 	cryptoPass, err := models.CryptoPass(rt.Ctx, authPassWord)
 	ginx.Dangerous(err)
+	ginx.Dangerous(target.UpdatePassword(rt.Ctx, cryptoPass, c.MustGet("username").(string)))
 
-	ginx.NewRender(c).Message(target.UpdatePassword(rt.Ctx, cryptoPass, c.MustGet("username").(string)))
+	// 管理员重置密码后，用户需要用新密码重新登录
+	if err := rt.revokeSessions(c.Request.Context(), target.Id, ""); err != nil {
+		logger.Warningf("revoke sessions of user %s failed: %v", target.Username, err)
+	}
+
+	ginx.NewRender(c).Message(nil)
 }
 
 func (rt *Router) userDel(c *gin.Context) {
@@ -235,6 +246,7 @@ func (rt *Router) userDel(c *gin.Context) {
 		ginx.NewRender(c).Message(nil)
 		return
 	}
+	checkTenant(c, target.TenantId)
 
 	// 如果要删除的用户是 admin 角色，检查是否是最后一个 admin
 	if target.IsAdmin() {
@@ -534,14 +546,14 @@ func (rt *Router) getXUserToken(c *gin.Context) {
 		}
 	}
 
-	userTokens, err := models.GetTokensByUsername(rt.Ctx, username)
+	userToken, err := models.UserTokenGetUnrestricted(rt.Ctx, username)
 	ginx.Dangerous(err)
-	if len(userTokens) > 0 {
-		ginx.NewRender(c).Data(userTokens[0], nil)
+	if userToken != nil {
+		ginx.NewRender(c).Data(userToken, nil)
 		return
 	}
 
-	userToken, err := models.AddToken(rt.Ctx, username, uuid.New().String(), "user-token")
+	userToken, err = models.AddToken(rt.Ctx, username, uuid.New().String(), "user-token")
 	ginx.Dangerous(err)
 	ginx.NewRender(c).Data(userToken, nil)
 }
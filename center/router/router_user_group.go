@@ -90,6 +90,7 @@ func (rt *Router) userGroupAdd(c *gin.Context) {
 		Note:     f.Note,
 		CreateBy: me.Username,
 		UpdateBy: me.Username,
+		TenantId: me.TenantId,
 	}
 
 	err := ug.Add(rt.Ctx)
@@ -179,6 +180,9 @@ func (rt *Router) userGroupMemberAdd(c *gin.Context) {
 	me := c.MustGet("user").(*models.User)
 	ug := c.MustGet("user_group").(*models.UserGroup)
 
+	// 团队成员只能是同一租户的用户
+	ginx.Dangerous(models.TenantCheckOwner(rt.Ctx, &models.User{}, ug.TenantId, f.Ids))
+
 	err := ug.AddMembers(rt.Ctx, f.Ids)
 	ginx.Dangerous(err)
 	if err == nil {
@@ -62,6 +62,7 @@ func (rt *Router) QueryVictoriaLogsFieldNames(c *gin.Context) {
 		ginx.Bomb(403, "no permission")
 	}
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	vl := getVictoriaLogs(c, f.Cate, f.DatasourceId)
 	if f.Scope == "stream_field" {
 		fields, err := vl.StreamFieldNames(c.Request.Context(), f.Query, f.Start, f.End, f.Filter)
@@ -110,6 +111,7 @@ func (rt *Router) QueryVictoriaLogsFieldValues(c *gin.Context) {
 		ginx.Bomb(403, "no permission")
 	}
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	vl := getVictoriaLogs(c, f.Cate, f.DatasourceId)
 	if f.Scope == "stream_field" {
 		values, err := vl.StreamFieldValues(c.Request.Context(), f.Query, f.Start, f.End, f.Field, f.Limit, f.Filter)
@@ -150,6 +152,7 @@ func (rt *Router) QueryVictoriaLogsHistogram(c *gin.Context) {
 		validateVictoriaLogsTimeRange(q.Start, q.End)
 	}
 
+	rt.checkDsQueryPerm(c, f.DatasourceId)
 	vl := getVictoriaLogs(c, f.Cate, f.DatasourceId)
 	ret := make([]victorialogs.HistogramValues, 0)
 
@@ -16,6 +16,7 @@ import (
 	"github.com/ccfos/nightingale/v6/pkg/ldapx"
 	"github.com/ccfos/nightingale/v6/pkg/oauth2x"
 	"github.com/ccfos/nightingale/v6/pkg/oidcx"
+	"github.com/ccfos/nightingale/v6/pkg/samlx"
 	"github.com/ccfos/nightingale/v6/pkg/tplx"
 
 	"github.com/BurntSushi/toml"
@@ -27,6 +28,7 @@ type SsoClient struct {
 	LDAP                 *ldapx.SsoClient
 	CAS                  *cas.SsoClient
 	OAuth2               *oauth2x.SsoClient
+	SAML                 *samlx.SsoClient
 	DingTalk             *dingtalk.SsoClient
 	FeiShu               *feishu.SsoClient
 	LastUpdateTime       int64
@@ -134,6 +136,51 @@ Phone = 'phone_number'
 Email = 'email'
 `
 
+const SAML = `
+Enable = false
+DisplayName = 'Sign in with SAML'
+# 前端完成登录的页面
+RedirectURL = 'http://n9e.com/callback/saml'
+# SP 的 entity id 和 ACS 地址，需要在 IdP 上登记，SP metadata 见 /api/n9e/auth/saml/metadata
+EntityId = 'http://n9e.com/api/n9e/auth/saml/metadata'
+AcsURL = 'http://n9e.com/api/n9e/auth/callback/saml'
+# 配置了 IdpMetadataURL 时自动读取 IdP 的 entity id、登录地址和签名证书
+IdpMetadataURL = ''
+IdpEntityId = ''
+IdpSsoURL = ''
+# IdP 签名证书（PEM），证书轮换期间可以同时填写新旧两个
+IdpCertificate = ''
+# 签名 AuthnRequest 使用的 SP 证书和私钥（PEM）
+SpCertificate = ''
+SpPrivateKey = ''
+SignAuthnRequest = false
+NameIdFormat = 'urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified'
+# unit: s
+AllowedClockSkew = 180
+SsoLogoutAddr = ''
+SkipTlsVerify = false
+CoverAttributes = true
+# Whether to overwrite roles with mapping (or DefaultRoles on miss) on each login. Requires CoverAttributes = true.
+CoverRoles = false
+CoverTeams = false
+DefaultRoles = ['Standard']
+DefaultTeams = []
+
+# 属性名，Username 为空时使用 NameID
+[Attributes]
+Username = ''
+Nickname = 'http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name'
+Phone = 'http://schemas.xmlsoap.org/ws/2005/05/identity/claims/mobilephone'
+Email = 'http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress'
+Groups = 'http://schemas.microsoft.com/ws/2008/06/identity/claims/groups'
+
+# 按 Groups 属性的值映射角色和团队
+# [[RoleTeamMapping]]
+# Group = 'n9e-admins'
+# Roles = ['Admin']
+# Teams = [1]
+`
+
 func Init(center cconf.Center, ctx *ctx.Context, configCache *memsto.ConfigCache) *SsoClient {
 	ssoClient := new(SsoClient)
 	m := make(map[string]string)
@@ -141,6 +188,7 @@ func Init(center cconf.Center, ctx *ctx.Context, configCache *memsto.ConfigCache
 	m["CAS"] = CAS
 	m["OIDC"] = OIDC
 	m["OAuth2"] = OAuth2
+	m["SAML"] = SAML
 
 	for name, config := range m {
 		count, err := models.SsoConfigCountByName(ctx, name)
@@ -211,6 +259,17 @@ func Init(center cconf.Center, ctx *ctx.Context, configCache *memsto.ConfigCache
 				log.Fatalln("init oauth2 failed:", err)
 			}
 			ssoClient.OAuth2 = oauth2x.New(config)
+		case "SAML":
+			var config samlx.Config
+			err := toml.Unmarshal([]byte(cfg.Content), &config)
+			if err != nil {
+				log.Fatalln("init saml failed:", err)
+			}
+			samlClient, err := samlx.New(config)
+			if err != nil {
+				logger.Error("init saml failed:", err)
+			}
+			ssoClient.SAML = samlClient
 		case dingtalk.SsoTypeName:
 			var config dingtalk.Config
 			err := json.Unmarshal([]byte(cfg.Content), &config)
@@ -293,7 +352,19 @@ func (s *SsoClient) reload(ctx *ctx.Context) error {
 				continue
 			}
 			s.OAuth2.Reload(config)
+		case "SAML":
+			var config samlx.Config
+			err := toml.Unmarshal([]byte(cfg.Content), &config)
+			if err != nil {
+				logger.Warning("reload saml failed:", err)
+				continue
+			}
 
+			err = s.SAML.Reload(config)
+			if err != nil {
+				logger.Error("reload saml failed:", err)
+				continue
+			}
 		}
 	}
 
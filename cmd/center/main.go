@@ -23,6 +23,11 @@ var (
 )
 
 func main() {
+	// 子命令：n9e tsdb-restore，需在进程停止时执行
+	if len(os.Args) > 1 && os.Args[1] == "tsdb-restore" {
+		os.Exit(tsdbRestore(os.Args[2:]))
+	}
+
 	flag.Parse()
 
 	if *showVersion {
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ccfos/nightingale/v6/conf"
+	"github.com/ccfos/nightingale/v6/pkg/osx"
+	"github.com/ccfos/nightingale/v6/tsdb"
+)
+
+// tsdbRestore 把内置 tsdb 的快照（快照目录或下载的 tar 包）恢复到 EmbeddedTSDB.Dir，
+// 校验所有 block 后才替换数据，原数据保留为 <Dir>.bak-<时间>
+func tsdbRestore(args []string) int {
+	fs := flag.NewFlagSet("tsdb-restore", flag.ExitOnError)
+	configDir := fs.String("configs", osx.GetEnv("N9E_CONFIGS", "etc"), "Specify configuration directory.(env:N9E_CONFIGS)")
+	cryptoKey := fs.String("crypto-key", "", "Specify the secret key for configuration file field encryption.")
+	dir := fs.String("dir", "", "Embedded tsdb data directory, default EmbeddedTSDB.Dir of the configuration.")
+	snapshot := fs.String("snapshot", "", "Snapshot directory or tar archive to restore.")
+	verifyOnly := fs.Bool("verify", false, "Only verify the blocks of the snapshot directory, don't restore.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: n9e tsdb-restore -snapshot <dir|tar> [-configs etc] [-dir data/tsdb]")
+		fmt.Fprintln(fs.Output(), "Stop n9e before restoring, the current data is kept as <dir>.bak-<time>.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *snapshot == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if *verifyOnly {
+		count, err := tsdb.VerifyBlocks(*snapshot)
+		if err != nil {
+			fmt.Println("verify failed:", err)
+			return 1
+		}
+		fmt.Printf("%d blocks verified\n", count)
+		return 0
+	}
+
+	if *dir == "" {
+		config, err := conf.InitCenterConfig(*configDir, *cryptoKey)
+		if err != nil {
+			fmt.Println("failed to load configs:", err)
+			return 1
+		}
+		if !config.EmbeddedTSDB.Enable {
+			fmt.Println("[EmbeddedTSDB] is not enabled, specify the data directory with -dir")
+			return 1
+		}
+		*dir = config.EmbeddedTSDB.Dir
+	}
+
+	count, err := tsdb.Restore(*snapshot, *dir)
+	if err != nil {
+		fmt.Println("restore failed:", err)
+		return 1
+	}
+
+	fmt.Printf("%d blocks restored into %s\n", count, *dir)
+	return 0
+}
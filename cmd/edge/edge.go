@@ -59,6 +59,7 @@ func Initialize(configDir string, cryptoKey string) (func(), error) {
 	metas := metas.New(redis)
 	writers := writer.NewWriters(config.Pushgw)
 	pushgwRouter := pushgwrt.New(config.HTTP, config.Pushgw, config.Alert, targetCache, busiGroupCache, idents, metas, writers, ctx)
+	pushgwRouter.IngestTokenCache = memsto.NewIngestTokenCache(ctx, syncStats)
 	r := httpx.GinEngine(config.Global.RunMode, config.HTTP, configCvalCache.PrintBodyPaths, configCvalCache.PrintAccessLog)
 
 	pushgwRouter.Config(r)
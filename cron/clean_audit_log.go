@@ -0,0 +1,57 @@
+package cron
+
+import (
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/robfig/cron/v3"
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	cleanAuditLogBatchSize  = 2000
+	cleanAuditLogBatchPause = 100 * time.Millisecond
+	cleanAuditLogMaxBatch   = 5000
+)
+
+func cleanAuditLog(ctx *ctx.Context, day int) {
+	before := time.Now().Unix() - 86400*int64(day)
+
+	var total int64
+	for i := 0; i < cleanAuditLogMaxBatch; i++ {
+		deleted, err := models.AuditLogDeleteBefore(ctx, before, cleanAuditLogBatchSize)
+		if err != nil {
+			logger.Errorf("Failed to clean audit log: %v", err)
+			return
+		}
+
+		total += deleted
+		if deleted < cleanAuditLogBatchSize {
+			break
+		}
+
+		time.Sleep(cleanAuditLogBatchPause)
+	}
+
+	if total > 0 {
+		logger.Infof("cleaned %d audit logs created before %d", total, before)
+	}
+}
+
+// CleanAuditLog 每天凌晨 2 点清理超过保留期的审计记录。清理完成后剩余记录中
+// 最早的一条作为哈希链的新起点，不影响校验
+func CleanAuditLog(ctx *ctx.Context, day int) {
+	c := cron.New()
+	_, err := c.AddFunc("0 2 * * *", func() {
+		cleanAuditLog(ctx, day)
+	})
+
+	if err != nil {
+		logger.Errorf("Failed to add clean audit log cron job: %v", err)
+		return
+	}
+
+	c.Start()
+}
@@ -0,0 +1,201 @@
+package cron
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/alert/naming"
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/storage"
+
+	"github.com/robfig/cron/v3"
+	"github.com/toolkits/pkg/logger"
+)
+
+type lifecyclePolicy struct {
+	cconf.TargetLifecyclePolicy
+	identRegexp *regexp.Regexp
+}
+
+func compileLifecyclePolicies(policies []cconf.TargetLifecyclePolicy) []*lifecyclePolicy {
+	lst := make([]*lifecyclePolicy, 0, len(policies))
+	for _, p := range policies {
+		lp := &lifecyclePolicy{TargetLifecyclePolicy: p}
+		if p.IdentPattern != "" {
+			re, err := regexp.Compile(p.IdentPattern)
+			if err != nil {
+				logger.Errorf("target lifecycle: invalid ident pattern %s: %v", p.IdentPattern, err)
+				continue
+			}
+			lp.identRegexp = re
+		}
+
+		if p.Action != "delete" && p.Action != "archive" {
+			logger.Errorf("target lifecycle: invalid action %s", p.Action)
+			continue
+		}
+
+		lst = append(lst, lp)
+	}
+	return lst
+}
+
+func matchLifecyclePolicy(policies []*lifecyclePolicy, ident string) *lifecyclePolicy {
+	for _, p := range policies {
+		if p.identRegexp == nil || p.identRegexp.MatchString(ident) {
+			return p
+		}
+	}
+	return nil
+}
+
+// checkTargetLifecycle 超过 StaleMinutes 没有心跳的机器标记失联，恢复心跳后清除标记；
+// 失联超过 OffboardDays 的机器按策略删除或归档。beatTimes 为 ident 到最近心跳时间的映射
+func checkTargetLifecycle(ctx *ctx.Context, policies []*lifecyclePolicy, targets []*models.Target, beatTimes map[string]int64,
+	now int64, deleteHook models.TargetDeleteHookFunc) {
+	var (
+		history  []*models.TargetHistory
+		offboard = make(map[string][]*models.Target) // key: action
+	)
+
+	for _, t := range targets {
+		p := matchLifecyclePolicy(policies, t.Ident)
+		if p == nil {
+			continue
+		}
+
+		beat, has := beatTimes[t.Ident]
+		if has && now-beat < int64(p.StaleMinutes)*60 {
+			if t.StaleSince > 0 {
+				cleared, err := models.TargetClearStale(ctx, t.Ident)
+				if err != nil {
+					logger.Errorf("target lifecycle: failed to clear stale of %s: %v", t.Ident, err)
+				} else if cleared {
+					history = append(history, models.NewTargetHistory(t.Ident, models.TargetHistoryFieldLifecycle,
+						models.TargetLifecycleStale, models.TargetLifecycleRecovered, models.TargetOperatorLifecycle))
+				}
+			}
+			continue
+		}
+
+		if t.StaleSince == 0 {
+			// 心跳时间在 redis 中会过期，取不到时从当前时间开始计算失联时长
+			since := now
+			if has {
+				since = beat
+			}
+
+			marked, err := models.TargetMarkStale(ctx, t.Ident, since)
+			if err != nil {
+				logger.Errorf("target lifecycle: failed to mark %s stale: %v", t.Ident, err)
+				continue
+			}
+
+			if marked {
+				history = append(history, models.NewTargetHistory(t.Ident, models.TargetHistoryFieldLifecycle,
+					"", models.TargetLifecycleStale, models.TargetOperatorLifecycle))
+			}
+			t.StaleSince = since
+		}
+
+		if p.OffboardDays > 0 && now-t.StaleSince >= int64(p.OffboardDays)*86400 {
+			offboard[p.Action] = append(offboard[p.Action], t)
+		}
+	}
+
+	for action, lst := range offboard {
+		idents := make([]string, 0, len(lst))
+		for _, t := range lst {
+			idents = append(idents, t.Ident)
+		}
+
+		before, err := models.TargetGroupIdsMapByIdents(ctx, idents)
+		if err != nil {
+			logger.Errorf("target lifecycle: failed to get group ids of targets %v: %v", idents, err)
+			continue
+		}
+
+		if err := models.TargetDel(ctx, idents, true, deleteHook); err != nil {
+			logger.Errorf("target lifecycle: failed to %s targets %v: %v", action, idents, err)
+			continue
+		}
+
+		// 删除前所属的业务组也记下来，和手动删除一样会发出 target.left
+		history = append(history, models.TargetHistoryOfBgids(before, nil, idents, models.TargetOperatorLifecycle)...)
+
+		for _, t := range lst {
+			newValue := models.TargetLifecycleDeleted
+			if action == "archive" {
+				newValue = models.TargetLifecycleArchived
+				if bs, err := json.Marshal(t); err == nil {
+					newValue = string(bs)
+				}
+			}
+			history = append(history, models.NewTargetHistory(t.Ident, models.TargetHistoryFieldLifecycle,
+				models.TargetLifecycleStale, newValue, models.TargetOperatorLifecycle))
+		}
+
+		logger.Infof("target lifecycle: %s %d stale targets: %v", action, len(idents), idents)
+	}
+
+	if err := models.TargetHistoryAdd(ctx, history); err != nil {
+		logger.Errorf("target lifecycle: failed to add target history: %v", err)
+	}
+}
+
+// TargetLifecycle 按策略定期巡检机器心跳，未配置策略时不启动。多实例部署时只在 leader 上执行
+func TargetLifecycle(ctx *ctx.Context, redis storage.Redis, conf cconf.TargetLifecycle, leader *naming.Naming,
+	deleteHook models.TargetDeleteHookFunc) {
+	if len(conf.Policies) == 0 {
+		return
+	}
+
+	if redis == nil {
+		logger.Warning("target lifecycle: redis is nil, heartbeat time is unavailable")
+		return
+	}
+
+	policies := compileLifecyclePolicies(conf.Policies)
+	if len(policies) == 0 {
+		return
+	}
+
+	c := cron.New()
+	_, err := c.AddFunc(fmt.Sprintf("@every %ds", conf.Interval), func() {
+		if !leader.IamLeader() {
+			return
+		}
+
+		targets, err := models.TargetGetsAll(ctx)
+		if err != nil {
+			logger.Errorf("target lifecycle: failed to get targets: %v", err)
+			return
+		}
+
+		idents := make([]string, 0, len(targets))
+		for _, t := range targets {
+			idents = append(idents, t.Ident)
+		}
+
+		beatTimes := models.FetchBeatTimesFromRedis(redis, idents)
+		if len(targets) > 0 && len(beatTimes) == 0 {
+			// 一条心跳都取不到大概率是 redis 异常，跳过本轮避免误判
+			logger.Warning("target lifecycle: no heartbeat found in redis, skip")
+			return
+		}
+
+		checkTargetLifecycle(ctx, policies, targets, beatTimes, time.Now().Unix(), deleteHook)
+	})
+
+	if err != nil {
+		logger.Errorf("Failed to add target lifecycle cron job: %v", err)
+		return
+	}
+
+	c.Start()
+	logger.Infof("Target lifecycle cron started, interval: %ds, policies: %d", conf.Interval, len(policies))
+}
@@ -0,0 +1,93 @@
+package cron
+
+import (
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/center/cconf"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newLifecycleTestCtx(t *testing.T) *ctx.Context {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Target{}, &models.TargetBusiGroup{}, &models.TargetHistory{}))
+	return &ctx.Context{DB: db, IsCenter: true}
+}
+
+func loadTargets(t *testing.T, c *ctx.Context) map[string]*models.Target {
+	var lst []*models.Target
+	require.NoError(t, c.DB.Find(&lst).Error)
+
+	m := make(map[string]*models.Target, len(lst))
+	for _, target := range lst {
+		m[target.Ident] = target
+	}
+	return m
+}
+
+func lifecycleHistory(t *testing.T, c *ctx.Context, ident string) []string {
+	var lst []string
+	require.NoError(t, c.DB.Model(&models.TargetHistory{}).Where("ident = ? and field = ?", ident, models.TargetHistoryFieldLifecycle).
+		Order("id").Pluck("new_value", &lst).Error)
+	return lst
+}
+
+func TestCheckTargetLifecycle(t *testing.T) {
+	c := newLifecycleTestCtx(t)
+	for _, ident := range []string{"asg-1", "asg-2", "db-1"} {
+		require.NoError(t, c.DB.Create(&models.Target{Ident: ident}).Error)
+	}
+
+	policies := compileLifecyclePolicies([]cconf.TargetLifecyclePolicy{
+		{IdentPattern: "^asg-", StaleMinutes: 10, OffboardDays: 1, Action: "delete"},
+		{IdentPattern: "(", StaleMinutes: 10, Action: "delete"}, // 非法正则被忽略
+		{StaleMinutes: 5, Action: "archive"},
+	})
+	require.Len(t, policies, 2)
+
+	noHook := func(tx *gorm.DB, idents []string, force bool) error { return nil }
+	run := func(now int64, beats map[string]int64) {
+		var targets []*models.Target
+		for _, target := range loadTargets(t, c) {
+			targets = append(targets, target)
+		}
+		checkTargetLifecycle(c, policies, targets, beats, now, noHook)
+	}
+
+	now := int64(1_000_000)
+
+	// asg-1 心跳正常；asg-2 11 分钟无心跳；db-1 redis 中无心跳
+	run(now, map[string]int64{"asg-1": now - 60, "asg-2": now - 660})
+	targets := loadTargets(t, c)
+	assert.Zero(t, targets["asg-1"].StaleSince)
+	assert.Equal(t, now-660, targets["asg-2"].StaleSince)
+	assert.Equal(t, now, targets["db-1"].StaleSince)
+	assert.Equal(t, []string{models.TargetLifecycleStale}, lifecycleHistory(t, c, "asg-2"))
+
+	// 重复巡检不会重复记录
+	run(now+60, map[string]int64{"asg-1": now, "asg-2": now - 660})
+	assert.Len(t, lifecycleHistory(t, c, "asg-2"), 1)
+
+	// db-1 恢复心跳
+	run(now+120, map[string]int64{"asg-1": now + 100, "asg-2": now - 660, "db-1": now + 100})
+	assert.Zero(t, loadTargets(t, c)["db-1"].StaleSince)
+	assert.Equal(t, []string{models.TargetLifecycleStale, models.TargetLifecycleRecovered}, lifecycleHistory(t, c, "db-1"))
+
+	// asg-2 失联超过 1 天后被删除，db-1 的策略不下线
+	later := now + 86400
+	run(later, map[string]int64{"asg-1": later, "asg-2": now - 660})
+	targets = loadTargets(t, c)
+	assert.NotContains(t, targets, "asg-2")
+	assert.Contains(t, targets, "asg-1")
+	assert.Contains(t, targets, "db-1")
+	assert.Equal(t, []string{models.TargetLifecycleStale, models.TargetLifecycleDeleted}, lifecycleHistory(t, c, "asg-2"))
+}
@@ -154,4 +154,5 @@ type DatasourceInfo struct {
 	UpdatedAt      int64                  `json:"updated_at"`
 	IsDefault      bool                   `json:"is_default"`
 	Weight         int                    `json:"weight"`
+	TenantId       int64                  `json:"tenant_id"`
 }
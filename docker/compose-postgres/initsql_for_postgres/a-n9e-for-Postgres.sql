@@ -11,6 +11,10 @@ CREATE TABLE users (
     maintainer int not null default 0,
     belong varchar(16) not null default '',
     last_active_time bigint not null default 0,
+    disabled int not null default 0,
+    password_update_at bigint not null default 0,
+    tenant_id bigint not null default 0,
+    scim_external_id varchar(255) not null default '',
     create_at bigint not null default 0,
     create_by varchar(64) not null default '',
     update_at bigint not null default 0,
@@ -26,6 +30,10 @@ COMMENT ON COLUMN users.portrait IS 'portrait image url';
 COMMENT ON COLUMN users.roles IS 'Admin | Standard | Guest, split by space';
 COMMENT ON COLUMN users.contacts IS 'json e.g. {wecom:xx, dingtalk_robot_token:yy}';
 COMMENT ON COLUMN users.belong IS 'belong';
+COMMENT ON COLUMN users.disabled IS '1 means disabled';
+COMMENT ON COLUMN users.password_update_at IS 'last time the password was changed';
+COMMENT ON COLUMN users.tenant_id IS 'tenant id, 0 means platform';
+COMMENT ON COLUMN users.scim_external_id IS 'scim externalId, not empty means managed by scim';
 
 insert into users(id, username, nickname, password, roles, create_at, create_by, update_at, update_by) values(1, 'root', 'Admin', 'root.2020', 'Admin', date_part('epoch',current_timestamp)::int, 'system', date_part('epoch',current_timestamp)::int, 'system');
 
@@ -33,6 +41,7 @@ CREATE TABLE user_group (
     id bigserial,
     name varchar(128) not null default '',
     note varchar(255) not null default '',
+    tenant_id bigint not null default 0,
     create_at bigint not null default 0,
     create_by varchar(64) not null default '',
     update_at bigint not null default 0,
@@ -74,9 +83,11 @@ CREATE TABLE role (
     id bigserial,
     name varchar(191) not null default '',
     note varchar(255) not null default '',
+    totp_required int not null default 0,
     PRIMARY KEY (id),
     UNIQUE (name)
 ) ;
+COMMENT ON COLUMN role.totp_required IS '1 means two-factor authentication is required';
 
 insert into role(name, note) values('Admin', 'Administrator role');
 insert into role(name, note) values('Standard', 'Ordinary user role');
@@ -160,6 +171,14 @@ insert into role_operation(role_name, operation) values('Standard', '/recording-
 insert into role_operation(role_name, operation) values('Standard', '/recording-rules/add');
 insert into role_operation(role_name, operation) values('Standard', '/recording-rules/put');
 insert into role_operation(role_name, operation) values('Standard', '/recording-rules/del');
+insert into role_operation(role_name, operation) values('Standard', '/scrape-jobs');
+insert into role_operation(role_name, operation) values('Standard', '/scrape-jobs/add');
+insert into role_operation(role_name, operation) values('Standard', '/scrape-jobs/put');
+insert into role_operation(role_name, operation) values('Standard', '/scrape-jobs/del');
+insert into role_operation(role_name, operation) values('Standard', '/ingest-tokens');
+insert into role_operation(role_name, operation) values('Standard', '/ingest-tokens/add');
+insert into role_operation(role_name, operation) values('Standard', '/ingest-tokens/put');
+insert into role_operation(role_name, operation) values('Standard', '/ingest-tokens/del');
 
 -- for alert_rule | collect_rule | mute | dashboard grouping
 CREATE TABLE busi_group (
@@ -167,6 +186,7 @@ CREATE TABLE busi_group (
     name varchar(191) not null,
     label_enable smallint not null default 0,
     label_value varchar(191) not null default '' ,
+    tenant_id bigint not null default 0,
     create_at bigint not null default 0,
     create_by varchar(64) not null default '',
     update_at bigint not null default 0,
@@ -175,6 +195,7 @@ CREATE TABLE busi_group (
     UNIQUE (name)
 ) ;
 COMMENT ON COLUMN busi_group.label_value IS 'if label_enable: label_value can not be blank';
+COMMENT ON COLUMN busi_group.tenant_id IS 'tenant id, 0 means platform';
 
 insert into busi_group(id, name, create_at, create_by, update_at, update_by) values(1, 'Default Busi Group', date_part('epoch',current_timestamp)::int, 'root', date_part('epoch',current_timestamp)::int, 'root');
 
@@ -441,6 +462,7 @@ CREATE TABLE target (
     agent_version varchar(255) default '',
     engine_name varchar(255) default '',
     os varchar(31) default '',
+    stale_since bigint not null default 0,
     update_at bigint not null default 0,
     PRIMARY KEY (id),
     UNIQUE (ident)
@@ -743,6 +765,7 @@ CREATE TABLE datasource
     auth varchar(8192) not null default '',
     is_default boolean not null default false,
     weight int not null default 0,
+    tenant_id bigint not null default 0,
     created_at bigint not null default 0,
     created_by varchar(64) not null default '',
     updated_at bigint not null default 0,
@@ -751,6 +774,21 @@ CREATE TABLE datasource
     PRIMARY KEY (id)
 ) ;
 
+CREATE TABLE datasource_acl (
+    id bigserial,
+    datasource_id bigint not null default 0,
+    subject_type varchar(32) not null default '',
+    subject_id bigint not null default 0,
+    perm varchar(32) not null default '',
+    create_at bigint not null default 0,
+    create_by varchar(64) not null default '',
+    update_at bigint not null default 0,
+    PRIMARY KEY (id)
+) ;
+CREATE INDEX idx_datasource_acl_datasource_id ON datasource_acl (datasource_id);
+COMMENT ON COLUMN datasource_acl.subject_type IS 'user_group or busi_group';
+COMMENT ON COLUMN datasource_acl.perm IS 'query or admin';
+
 CREATE TABLE builtin_cate (
     id bigserial,
     name varchar(191) not null,
@@ -938,7 +976,8 @@ CREATE TABLE notification_record (
     status bigint DEFAULT NULL,
     target varchar(1024) NOT NULL,
     details varchar(2048) DEFAULT '',
-    created_at bigint NOT NULL
+    created_at bigint NOT NULL,
+    retry_id bigint NOT NULL DEFAULT 0
 );
 
 CREATE INDEX idx_evt ON notification_record (event_id);
@@ -952,6 +991,203 @@ COMMENT ON COLUMN notification_record.status IS 'notification status';
 COMMENT ON COLUMN notification_record.target IS 'notification target';
 COMMENT ON COLUMN notification_record.details IS 'notification other info';
 COMMENT ON COLUMN notification_record.created_at IS 'create time';
+COMMENT ON COLUMN notification_record.retry_id IS 'notify retry id';
+
+CREATE TABLE notify_retry (
+    id BIGSERIAL PRIMARY KEY,
+    notify_rule_id bigint NOT NULL DEFAULT 0,
+    channel_id bigint NOT NULL DEFAULT 0,
+    channel_name varchar(255) NOT NULL DEFAULT '',
+    template_id bigint NOT NULL DEFAULT 0,
+    event_ids text,
+    sendtos text,
+    custom_params text,
+    flashduty_channel_ids text,
+    pagerduty_routing_keys text,
+    im_group_ids text,
+    target varchar(1024) NOT NULL DEFAULT '',
+    status varchar(32) NOT NULL DEFAULT '',
+    attempts int NOT NULL DEFAULT 0,
+    max_attempts int NOT NULL DEFAULT 0,
+    resends int NOT NULL DEFAULT 0,
+    next_retry_at bigint NOT NULL DEFAULT 0,
+    last_error varchar(2048) NOT NULL DEFAULT '',
+    created_at bigint NOT NULL DEFAULT 0,
+    updated_at bigint NOT NULL DEFAULT 0,
+    updated_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_nrt_status_next ON notify_retry (status, next_retry_at);
+
+CREATE TABLE scrape_job (
+    id BIGSERIAL PRIMARY KEY,
+    group_id bigint NOT NULL DEFAULT 0,
+    name varchar(255) NOT NULL DEFAULT '',
+    disabled int NOT NULL DEFAULT 0,
+    sd_type varchar(32) NOT NULL DEFAULT '',
+    targets text,
+    sd_file varchar(1024) NOT NULL DEFAULT '',
+    sd_url varchar(1024) NOT NULL DEFAULT '',
+    target_group_ids text,
+    target_port int NOT NULL DEFAULT 0,
+    scheme varchar(16) NOT NULL DEFAULT '',
+    metrics_path varchar(255) NOT NULL DEFAULT '',
+    headers text,
+    scrape_interval int NOT NULL DEFAULT 0,
+    scrape_timeout int NOT NULL DEFAULT 0,
+    honor_labels boolean NOT NULL DEFAULT false,
+    labels text,
+    relabel_configs text,
+    metric_relabel_configs text,
+    note varchar(1024) NOT NULL DEFAULT '',
+    create_at bigint NOT NULL DEFAULT 0,
+    create_by varchar(64) NOT NULL DEFAULT '',
+    update_at bigint NOT NULL DEFAULT 0,
+    update_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_scrape_job_group_id ON scrape_job (group_id);
+
+CREATE TABLE ingest_token (
+    id BIGSERIAL PRIMARY KEY,
+    group_id bigint NOT NULL DEFAULT 0,
+    token varchar(255) NOT NULL DEFAULT '',
+    note varchar(255) NOT NULL DEFAULT '',
+    metric_prefixes text,
+    rate_limit int NOT NULL DEFAULT 0,
+    disabled int NOT NULL DEFAULT 0,
+    expire_at bigint NOT NULL DEFAULT 0,
+    create_at bigint NOT NULL DEFAULT 0,
+    create_by varchar(64) NOT NULL DEFAULT '',
+    update_at bigint NOT NULL DEFAULT 0,
+    update_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_ingest_token_group_id ON ingest_token (group_id);
+CREATE INDEX idx_ingest_token_token ON ingest_token (token);
+
+CREATE TABLE target_history (
+    id BIGSERIAL PRIMARY KEY,
+    ident varchar(191) NOT NULL DEFAULT '',
+    field varchar(64) NOT NULL DEFAULT '',
+    old_value text,
+    new_value text,
+    operator varchar(64) NOT NULL DEFAULT '',
+    create_at bigint NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_target_history_ident ON target_history (ident);
+CREATE INDEX idx_target_history_create_at ON target_history (create_at);
+
+CREATE TABLE cmdb_sync (
+    id BIGSERIAL PRIMARY KEY,
+    name varchar(255) NOT NULL DEFAULT '',
+    source_type varchar(32) NOT NULL DEFAULT '',
+    url varchar(1024) NOT NULL DEFAULT '',
+    headers text,
+    timeout int NOT NULL DEFAULT 0,
+    items_path varchar(255) NOT NULL DEFAULT '',
+    match_by varchar(32) NOT NULL DEFAULT '',
+    ident_field varchar(255) NOT NULL DEFAULT '',
+    note_field varchar(255) NOT NULL DEFAULT '',
+    tag_fields text,
+    group_field varchar(255) NOT NULL DEFAULT '',
+    group_match_by varchar(32) NOT NULL DEFAULT '',
+    conflict_policy varchar(32) NOT NULL DEFAULT '',
+    sync_interval bigint NOT NULL DEFAULT 0,
+    disabled int NOT NULL DEFAULT 0,
+    last_sync_at bigint NOT NULL DEFAULT 0,
+    last_report text,
+    create_at bigint NOT NULL DEFAULT 0,
+    create_by varchar(64) NOT NULL DEFAULT '',
+    update_at bigint NOT NULL DEFAULT 0,
+    update_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE cmdb_sync_applied (
+    id BIGSERIAL PRIMARY KEY,
+    sync_id bigint NOT NULL DEFAULT 0,
+    ident varchar(191) NOT NULL DEFAULT '',
+    tags text,
+    note varchar(255) NOT NULL DEFAULT '',
+    group_ids text,
+    update_at bigint NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX idx_cmdb_sync_applied ON cmdb_sync_applied (sync_id, ident);
+
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor varchar(64) NOT NULL DEFAULT '',
+    source_ip varchar(64) NOT NULL DEFAULT '',
+    auth_method varchar(128) NOT NULL DEFAULT '',
+    method varchar(16) NOT NULL DEFAULT '',
+    path varchar(255) NOT NULL DEFAULT '',
+    resource_type varchar(64) NOT NULL DEFAULT '',
+    resource_id varchar(191) NOT NULL DEFAULT '',
+    action varchar(32) NOT NULL DEFAULT '',
+    status int NOT NULL DEFAULT 0,
+    error varchar(512) NOT NULL DEFAULT '',
+    before text,
+    after text,
+    diff text,
+    create_at bigint NOT NULL DEFAULT 0,
+    prev_hash varchar(64) NOT NULL DEFAULT '',
+    hash varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_audit_log_actor ON audit_log (actor);
+CREATE INDEX idx_audit_log_resource ON audit_log (resource_type, resource_id);
+CREATE INDEX idx_audit_log_create_at ON audit_log (create_at);
+
+CREATE TABLE config_revision (
+    id BIGSERIAL PRIMARY KEY,
+    resource_type varchar(64) NOT NULL DEFAULT '',
+    resource_id bigint NOT NULL DEFAULT 0,
+    version int NOT NULL DEFAULT 0,
+    content text,
+    restored_from int NOT NULL DEFAULT 0,
+    create_at bigint NOT NULL DEFAULT 0,
+    create_by varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX idx_config_revision_version ON config_revision (resource_type, resource_id, version);
+
+CREATE TABLE user_totp (
+    user_id bigint NOT NULL,
+    secret varchar(64) NOT NULL DEFAULT '',
+    enabled int NOT NULL DEFAULT 0,
+    recovery_codes text,
+    last_step bigint NOT NULL DEFAULT 0,
+    create_at bigint NOT NULL DEFAULT 0,
+    update_at bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id)
+);
+COMMENT ON COLUMN user_totp.enabled IS '0 pending 1 enabled';
+COMMENT ON COLUMN user_totp.recovery_codes IS 'sha256 of unused recovery codes, comma separated';
+
+CREATE TABLE user_password_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id bigint NOT NULL DEFAULT 0,
+    password varchar(128) NOT NULL DEFAULT '',
+    create_at bigint NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_user_password_history_user_id ON user_password_history (user_id);
+COMMENT ON COLUMN user_password_history.password IS 'hash of a previously used password';
+
+CREATE TABLE tenant (
+    id BIGSERIAL PRIMARY KEY,
+    name varchar(191) NOT NULL DEFAULT '',
+    note varchar(1024) NOT NULL DEFAULT '',
+    rule_quota bigint NOT NULL DEFAULT 0,
+    target_quota bigint NOT NULL DEFAULT 0,
+    create_at bigint NOT NULL DEFAULT 0,
+    create_by varchar(64) NOT NULL DEFAULT '',
+    update_at bigint NOT NULL DEFAULT 0,
+    update_by varchar(64) NOT NULL DEFAULT '',
+    UNIQUE (name)
+);
+COMMENT ON COLUMN tenant.rule_quota IS 'max number of alert rules, 0 means unlimited';
+COMMENT ON COLUMN tenant.target_quota IS 'max number of targets, 0 means unlimited';
 
 CREATE TABLE target_busi_group (
     id BIGSERIAL PRIMARY KEY,
@@ -968,8 +1204,20 @@ CREATE TABLE user_token (
     token_name varchar(255) NOT NULL DEFAULT '',
     token varchar(255) NOT NULL DEFAULT '',
     create_at bigint NOT NULL DEFAULT 0,
-    last_used bigint NOT NULL DEFAULT 0
+    last_used bigint NOT NULL DEFAULT 0,
+    expire_at bigint NOT NULL DEFAULT 0,
+    ip_allowlist text,
+    scopes text,
+    busi_group_ids text,
+    read_only int NOT NULL DEFAULT 0,
+    use_count bigint NOT NULL DEFAULT 0,
+    denied_count bigint NOT NULL DEFAULT 0,
+    last_used_ip varchar(64) NOT NULL DEFAULT '',
+    update_at bigint NOT NULL DEFAULT 0
 );
+COMMENT ON COLUMN user_token.expire_at IS '0 means never expire';
+COMMENT ON COLUMN user_token.ip_allowlist IS 'ip or cidr list, json';
+COMMENT ON COLUMN user_token.scopes IS 'operations of ops.yaml, json';
 
 CREATE TABLE notify_rule (
     id bigserial PRIMARY KEY,
@@ -979,6 +1227,7 @@ CREATE TABLE notify_rule (
     user_group_ids varchar(255) NOT NULL DEFAULT '',
     notify_configs text,
     pipeline_configs text,
+    tenant_id bigint NOT NULL DEFAULT 0,
     create_at bigint NOT NULL DEFAULT 0,
     create_by varchar(64) NOT NULL DEFAULT '',
     update_at bigint NOT NULL DEFAULT 0,
@@ -995,6 +1244,7 @@ CREATE TABLE notify_channel (
     request_type varchar(50) NOT NULL,
     request_config text,
     weight int NOT NULL DEFAULT 0,
+    tenant_id bigint NOT NULL DEFAULT 0,
     create_at bigint NOT NULL DEFAULT 0,
     create_by varchar(64) NOT NULL DEFAULT '',
     update_at bigint NOT NULL DEFAULT 0,
@@ -1011,6 +1261,7 @@ CREATE TABLE message_template (
     private int NOT NULL DEFAULT 0,
     weight int NOT NULL DEFAULT 0,
     lang varchar(32) NOT NULL DEFAULT '',
+    tenant_id bigint NOT NULL DEFAULT 0,
     create_at bigint NOT NULL DEFAULT 0,
     create_by varchar(64) NOT NULL DEFAULT '',
     update_at bigint NOT NULL DEFAULT 0,
@@ -17,6 +17,10 @@ CREATE TABLE `users` (
     `maintainer` tinyint(1) not null default 0,
     `belong` varchar(191) DEFAULT '' COMMENT 'belong',
     `last_active_time` bigint DEFAULT 0 COMMENT 'last_active_time',
+    `disabled` int not null default 0 comment '1 means disabled',
+    `password_update_at` bigint not null default 0 comment 'last time the password was changed',
+    `tenant_id` bigint not null default 0 comment 'tenant id, 0 means platform',
+    `scim_external_id` varchar(255) not null default '' comment 'scim externalId, not empty means managed by scim',
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -31,6 +35,7 @@ CREATE TABLE `user_group` (
     `id` bigint unsigned not null auto_increment,
     `name` varchar(128) not null default '',
     `note` varchar(255) not null default '',
+    `tenant_id` bigint not null default 0 comment 'tenant id, 0 means platform',
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -71,6 +76,7 @@ CREATE TABLE `role` (
     `id` bigint unsigned not null auto_increment,
     `name` varchar(191) not null default '',
     `note` varchar(255) not null default '',
+    `totp_required` int not null default 0 comment '1 means two-factor authentication is required',
     PRIMARY KEY (`id`),
     UNIQUE KEY (`name`)
 ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -149,6 +155,14 @@ insert into `role_operation`(role_name, operation) values('Standard', '/recordin
 insert into `role_operation`(role_name, operation) values('Standard', '/recording-rules/add');
 insert into `role_operation`(role_name, operation) values('Standard', '/recording-rules/put');
 insert into `role_operation`(role_name, operation) values('Standard', '/recording-rules/del');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/add');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/put');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/del');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/add');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/put');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/del');
 
 -- for alert_rule | collect_rule | mute | dashboard grouping
 CREATE TABLE `busi_group` (
@@ -156,6 +170,7 @@ CREATE TABLE `busi_group` (
     `name` varchar(191) not null,
     `label_enable` tinyint(1) not null default 0,
     `label_value` varchar(191) not null default '' comment 'if label_enable: label_value can not be blank',
+    `tenant_id` bigint not null default 0 comment 'tenant id, 0 means platform',
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -372,6 +387,7 @@ CREATE TABLE `target` (
     `agent_version` varchar(255) default '' COMMENT 'agent version',
     `engine_name` varchar(255) DEFAULT '' COMMENT 'engine name',
     `os` VARCHAR(31) DEFAULT '' COMMENT 'os type',
+    `stale_since` bigint not null default 0 COMMENT 'stale since, set by lifecycle policy',
     `update_at` bigint not null default 0,
     PRIMARY KEY (`id`),
     UNIQUE KEY (`ident`),
@@ -573,11 +589,207 @@ CREATE TABLE notification_record (
     `target` varchar(1024) NOT NULL COMMENT 'notification target',
     `details` varchar(2048) DEFAULT '' COMMENT 'notification other info',
     `created_at` bigint NOT NULL COMMENT 'create time',
+    `retry_id` bigint NOT NULL DEFAULT 0 COMMENT 'notify retry id',
     INDEX idx_evt (event_id),
     INDEX idx_nr_rule_created_evt (notify_rule_id, created_at, event_id),
     INDEX idx_nr_created_at (created_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+CREATE TABLE `notify_retry` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `notify_rule_id` bigint NOT NULL DEFAULT 0,
+    `channel_id` bigint NOT NULL DEFAULT 0,
+    `channel_name` varchar(255) NOT NULL DEFAULT '',
+    `template_id` bigint NOT NULL DEFAULT 0,
+    `event_ids` text,
+    `sendtos` text,
+    `custom_params` text,
+    `flashduty_channel_ids` text,
+    `pagerduty_routing_keys` text,
+    `im_group_ids` text,
+    `target` varchar(1024) NOT NULL DEFAULT '',
+    `status` varchar(32) NOT NULL DEFAULT '',
+    `attempts` int NOT NULL DEFAULT 0,
+    `max_attempts` int NOT NULL DEFAULT 0,
+    `resends` int NOT NULL DEFAULT 0,
+    `next_retry_at` bigint NOT NULL DEFAULT 0,
+    `last_error` varchar(2048) NOT NULL DEFAULT '',
+    `created_at` bigint NOT NULL DEFAULT 0,
+    `updated_at` bigint NOT NULL DEFAULT 0,
+    `updated_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    KEY `idx_nrt_status_next` (`status`, `next_retry_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `scrape_job` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `group_id` bigint NOT NULL DEFAULT 0 COMMENT 'busi group id',
+    `name` varchar(255) NOT NULL DEFAULT '' COMMENT 'job label',
+    `disabled` int NOT NULL DEFAULT 0,
+    `sd_type` varchar(32) NOT NULL DEFAULT '' COMMENT 'static file http target',
+    `targets` text,
+    `sd_file` varchar(1024) NOT NULL DEFAULT '',
+    `sd_url` varchar(1024) NOT NULL DEFAULT '',
+    `target_group_ids` text,
+    `target_port` int NOT NULL DEFAULT 0,
+    `scheme` varchar(16) NOT NULL DEFAULT '',
+    `metrics_path` varchar(255) NOT NULL DEFAULT '',
+    `headers` text,
+    `scrape_interval` int NOT NULL DEFAULT 0 COMMENT 'unit: s',
+    `scrape_timeout` int NOT NULL DEFAULT 0 COMMENT 'unit: s',
+    `honor_labels` tinyint(1) NOT NULL DEFAULT 0,
+    `labels` text,
+    `relabel_configs` text,
+    `metric_relabel_configs` text,
+    `note` varchar(1024) NOT NULL DEFAULT '',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
+    `update_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    KEY `idx_scrape_job_group_id` (`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `ingest_token` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `group_id` bigint NOT NULL DEFAULT 0 COMMENT 'busi group id',
+    `token` varchar(255) NOT NULL DEFAULT '',
+    `note` varchar(255) NOT NULL DEFAULT '',
+    `metric_prefixes` text,
+    `rate_limit` int NOT NULL DEFAULT 0 COMMENT 'samples per second, 0 means unlimited',
+    `disabled` int NOT NULL DEFAULT 0,
+    `expire_at` bigint NOT NULL DEFAULT 0,
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
+    `update_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    KEY `idx_ingest_token_group_id` (`group_id`),
+    KEY `idx_ingest_token_token` (`token`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `target_history` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `ident` varchar(191) NOT NULL DEFAULT '',
+    `field` varchar(64) NOT NULL DEFAULT '' COMMENT 'host_ip os agent_version engine_name host_tags busi_group lifecycle tags note',
+    `old_value` text,
+    `new_value` text,
+    `operator` varchar(64) NOT NULL DEFAULT '' COMMENT 'username, heartbeat, lifecycle or cmdb',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    KEY `idx_target_history_ident` (`ident`),
+    KEY `idx_target_history_create_at` (`create_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `cmdb_sync` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `name` varchar(255) NOT NULL DEFAULT '',
+    `source_type` varchar(32) NOT NULL DEFAULT '' COMMENT 'http or csv',
+    `url` varchar(1024) NOT NULL DEFAULT '',
+    `headers` text,
+    `timeout` int NOT NULL DEFAULT 0 COMMENT 'seconds',
+    `items_path` varchar(255) NOT NULL DEFAULT '',
+    `match_by` varchar(32) NOT NULL DEFAULT '' COMMENT 'ident or host_ip',
+    `ident_field` varchar(255) NOT NULL DEFAULT '',
+    `note_field` varchar(255) NOT NULL DEFAULT '',
+    `tag_fields` text,
+    `group_field` varchar(255) NOT NULL DEFAULT '',
+    `group_match_by` varchar(32) NOT NULL DEFAULT '' COMMENT 'name, id or label_value',
+    `conflict_policy` varchar(32) NOT NULL DEFAULT '' COMMENT 'cmdb_wins or manual_wins',
+    `sync_interval` bigint NOT NULL DEFAULT 0 COMMENT 'seconds, 0 means manual only',
+    `disabled` int NOT NULL DEFAULT 0,
+    `last_sync_at` bigint NOT NULL DEFAULT 0,
+    `last_report` text,
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
+    `update_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `cmdb_sync_applied` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `sync_id` bigint NOT NULL DEFAULT 0,
+    `ident` varchar(191) NOT NULL DEFAULT '',
+    `tags` text,
+    `note` varchar(255) NOT NULL DEFAULT '',
+    `group_ids` text,
+    `update_at` bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_cmdb_sync_applied` (`sync_id`, `ident`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `audit_log` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `actor` varchar(64) NOT NULL DEFAULT '',
+    `source_ip` varchar(64) NOT NULL DEFAULT '',
+    `auth_method` varchar(128) NOT NULL DEFAULT '' COMMENT 'jwt, user_token:****abcd, proxy, basic:xxx',
+    `method` varchar(16) NOT NULL DEFAULT '',
+    `path` varchar(255) NOT NULL DEFAULT '',
+    `resource_type` varchar(64) NOT NULL DEFAULT '',
+    `resource_id` varchar(191) NOT NULL DEFAULT '',
+    `action` varchar(32) NOT NULL DEFAULT '' COMMENT 'create, update or delete',
+    `status` int NOT NULL DEFAULT 0,
+    `error` varchar(512) NOT NULL DEFAULT '',
+    `before` text,
+    `after` text,
+    `diff` text,
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `prev_hash` varchar(64) NOT NULL DEFAULT '',
+    `hash` varchar(64) NOT NULL DEFAULT '' COMMENT 'sha256 of prev_hash and this record',
+    PRIMARY KEY (`id`),
+    KEY `idx_audit_log_actor` (`actor`),
+    KEY `idx_audit_log_resource` (`resource_type`, `resource_id`),
+    KEY `idx_audit_log_create_at` (`create_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `config_revision` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `resource_type` varchar(64) NOT NULL DEFAULT '' COMMENT 'alert_rule, notify_rule, event_pipeline, message_template, board',
+    `resource_id` bigint NOT NULL DEFAULT 0,
+    `version` int NOT NULL DEFAULT 0,
+    `content` mediumtext,
+    `restored_from` int NOT NULL DEFAULT 0 COMMENT 'version restored from, 0 for normal save',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_config_revision_version` (`resource_type`, `resource_id`, `version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `user_totp` (
+    `user_id` bigint NOT NULL,
+    `secret` varchar(64) NOT NULL DEFAULT '',
+    `enabled` int NOT NULL DEFAULT 0 COMMENT '0 pending 1 enabled',
+    `recovery_codes` text COMMENT 'sha256 of unused recovery codes, comma separated',
+    `last_step` bigint NOT NULL DEFAULT 0 COMMENT 'time step of the last accepted code',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `update_at` bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `user_password_history` (
+    `id` bigint unsigned not null auto_increment,
+    `user_id` bigint not null default 0,
+    `password` varchar(128) not null default '' comment 'hash of a previously used password',
+    `create_at` bigint not null default 0,
+    PRIMARY KEY (`id`),
+    KEY (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tenant` (
+    `id` bigint unsigned not null auto_increment,
+    `name` varchar(191) not null default '',
+    `note` varchar(1024) not null default '',
+    `rule_quota` bigint not null default 0 comment 'max number of alert rules, 0 means unlimited',
+    `target_quota` bigint not null default 0 comment 'max number of targets, 0 means unlimited',
+    `create_at` bigint not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` bigint not null default 0,
+    `update_by` varchar(64) not null default '',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 CREATE TABLE `task_tpl`
 (
     `id`        int unsigned NOT NULL AUTO_INCREMENT,
@@ -661,6 +873,7 @@ CREATE TABLE `datasource`
     `auth` varchar(8192) not null default '',
     `is_default` boolean COMMENT 'is default datasource',
     `weight` int not null default 0,
+    `tenant_id` bigint not null default 0 comment 'tenant id, 0 means platform',
     `created_at` bigint not null default 0,
     `created_by` varchar(64) not null default '',
     `updated_at` bigint not null default 0,
@@ -669,6 +882,19 @@ CREATE TABLE `datasource`
     PRIMARY KEY (`id`)
 ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
 
+CREATE TABLE `datasource_acl` (
+    `id` bigint unsigned not null auto_increment,
+    `datasource_id` bigint not null default 0,
+    `subject_type` varchar(32) not null default '' COMMENT 'user_group or busi_group',
+    `subject_id` bigint not null default 0,
+    `perm` varchar(32) not null default '' COMMENT 'query or admin',
+    `create_at` bigint not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` bigint not null default 0,
+    PRIMARY KEY (`id`),
+    KEY (`datasource_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
 CREATE TABLE `builtin_cate` (
     `id` bigint unsigned not null auto_increment,
     `name` varchar(191) not null,
@@ -789,6 +1015,15 @@ CREATE TABLE `user_token` (
     `token` varchar(255) NOT NULL DEFAULT '',
     `create_at` bigint NOT NULL DEFAULT 0,
     `last_used` bigint NOT NULL DEFAULT 0,
+    `expire_at` bigint NOT NULL DEFAULT 0 COMMENT '0 means never expire',
+    `ip_allowlist` text COMMENT 'ip or cidr list, json',
+    `scopes` text COMMENT 'operations of ops.yaml, json',
+    `busi_group_ids` text COMMENT 'json',
+    `read_only` int NOT NULL DEFAULT 0,
+    `use_count` bigint NOT NULL DEFAULT 0,
+    `denied_count` bigint NOT NULL DEFAULT 0,
+    `last_used_ip` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
     PRIMARY KEY (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
@@ -801,6 +1036,7 @@ CREATE TABLE `notify_rule` (
     `user_group_ids` varchar(255) not null default '',
     `notify_configs` text,
     `pipeline_configs` text,
+    `tenant_id` bigint not null default 0 comment 'tenant id, 0 means platform',
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -818,6 +1054,7 @@ CREATE TABLE `notify_channel` (
     `request_type` varchar(50) not null,
     `request_config` text,
     `weight` int not null default 0,
+    `tenant_id` bigint not null default 0 comment 'tenant id, 0 means platform',
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -835,6 +1072,7 @@ CREATE TABLE `message_template` (
     `private` int not null default 0,
     `weight` int not null default 0,
     `lang` varchar(32) not null default '',
+    `tenant_id` bigint not null default 0 comment 'tenant id, 0 means platform',
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
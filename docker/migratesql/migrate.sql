@@ -515,3 +515,256 @@ ALTER TABLE `source_token` ADD COLUMN `note` varchar(255) NOT NULL DEFAULT '' CO
    已有的 idx_source_type_id_token 跳过了中间列 source_id 用不上，会退化成扫全部
    source_type='board' 的行。该查询在鉴权之前、每个带 __token 的请求都要打一次 */
 ALTER TABLE `source_token` ADD KEY `idx_source_token_token` (`token`);
+
+/* v9 2026-10-19 notify_retry: 发送失败的通知投递，按指数退避重试，超过最大次数后进入死信；
+   notification_record.retry_id 把原始记录、重试和手动重发的记录关联到同一条投递 */
+CREATE TABLE `notify_retry` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `notify_rule_id` bigint NOT NULL DEFAULT 0,
+    `channel_id` bigint NOT NULL DEFAULT 0,
+    `channel_name` varchar(255) NOT NULL DEFAULT '',
+    `template_id` bigint NOT NULL DEFAULT 0,
+    `event_ids` text,
+    `sendtos` text,
+    `custom_params` text,
+    `flashduty_channel_ids` text,
+    `pagerduty_routing_keys` text,
+    `im_group_ids` text,
+    `target` varchar(1024) NOT NULL DEFAULT '',
+    `status` varchar(32) NOT NULL DEFAULT '',
+    `attempts` int NOT NULL DEFAULT 0,
+    `max_attempts` int NOT NULL DEFAULT 0,
+    `resends` int NOT NULL DEFAULT 0,
+    `next_retry_at` bigint NOT NULL DEFAULT 0,
+    `last_error` varchar(2048) NOT NULL DEFAULT '',
+    `created_at` bigint NOT NULL DEFAULT 0,
+    `updated_at` bigint NOT NULL DEFAULT 0,
+    `updated_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    KEY `idx_nrt_status_next` (`status`, `next_retry_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+ALTER TABLE `notification_record` ADD COLUMN `retry_id` bigint NOT NULL DEFAULT 0 COMMENT 'notify retry id';
+
+/* v9 2026-10-19 scrape_job: 告警引擎按 hash ring 分片拉取 /metrics 的抓取任务 */
+CREATE TABLE `scrape_job` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `group_id` bigint NOT NULL DEFAULT 0 COMMENT 'busi group id',
+    `name` varchar(255) NOT NULL DEFAULT '' COMMENT 'job label',
+    `disabled` int NOT NULL DEFAULT 0,
+    `sd_type` varchar(32) NOT NULL DEFAULT '' COMMENT 'static file http target',
+    `targets` text,
+    `sd_file` varchar(1024) NOT NULL DEFAULT '',
+    `sd_url` varchar(1024) NOT NULL DEFAULT '',
+    `target_group_ids` text,
+    `target_port` int NOT NULL DEFAULT 0,
+    `scheme` varchar(16) NOT NULL DEFAULT '',
+    `metrics_path` varchar(255) NOT NULL DEFAULT '',
+    `headers` text,
+    `scrape_interval` int NOT NULL DEFAULT 0 COMMENT 'unit: s',
+    `scrape_timeout` int NOT NULL DEFAULT 0 COMMENT 'unit: s',
+    `honor_labels` tinyint(1) NOT NULL DEFAULT 0,
+    `labels` text,
+    `relabel_configs` text,
+    `metric_relabel_configs` text,
+    `note` varchar(1024) NOT NULL DEFAULT '',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
+    `update_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    KEY `idx_scrape_job_group_id` (`group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/add');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/put');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/del');
+
+/* v9 2026-10-19 ingest_token: 业务组写入令牌，pushgw 按令牌强制业务组标签 */
+CREATE TABLE `ingest_token` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `group_id` bigint NOT NULL DEFAULT 0 COMMENT 'busi group id',
+    `token` varchar(255) NOT NULL DEFAULT '',
+    `note` varchar(255) NOT NULL DEFAULT '',
+    `metric_prefixes` text,
+    `rate_limit` int NOT NULL DEFAULT 0 COMMENT 'samples per second, 0 means unlimited',
+    `disabled` int NOT NULL DEFAULT 0,
+    `expire_at` bigint NOT NULL DEFAULT 0,
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
+    `update_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    KEY `idx_ingest_token_group_id` (`group_id`),
+    KEY `idx_ingest_token_token` (`token`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/add');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/put');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/del');
+
+/* v9 2026-10-19 target lifecycle: 失联标记与机器变更历史 */
+ALTER TABLE `target` ADD COLUMN `stale_since` bigint NOT NULL DEFAULT 0 COMMENT 'stale since, set by lifecycle policy';
+CREATE TABLE `target_history` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `ident` varchar(191) NOT NULL DEFAULT '',
+    `field` varchar(64) NOT NULL DEFAULT '' COMMENT 'host_ip os agent_version engine_name host_tags busi_group lifecycle tags note',
+    `old_value` text,
+    `new_value` text,
+    `operator` varchar(64) NOT NULL DEFAULT '' COMMENT 'username, heartbeat, lifecycle or cmdb',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    KEY `idx_target_history_ident` (`ident`),
+    KEY `idx_target_history_create_at` (`create_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/* v9 2026-10-19 cmdb_sync: 从 CMDB 同步机器标签、备注和业务组 */
+CREATE TABLE `cmdb_sync` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `name` varchar(255) NOT NULL DEFAULT '',
+    `source_type` varchar(32) NOT NULL DEFAULT '' COMMENT 'http or csv',
+    `url` varchar(1024) NOT NULL DEFAULT '',
+    `headers` text,
+    `timeout` int NOT NULL DEFAULT 0 COMMENT 'seconds',
+    `items_path` varchar(255) NOT NULL DEFAULT '',
+    `match_by` varchar(32) NOT NULL DEFAULT '' COMMENT 'ident or host_ip',
+    `ident_field` varchar(255) NOT NULL DEFAULT '',
+    `note_field` varchar(255) NOT NULL DEFAULT '',
+    `tag_fields` text,
+    `group_field` varchar(255) NOT NULL DEFAULT '',
+    `group_match_by` varchar(32) NOT NULL DEFAULT '' COMMENT 'name, id or label_value',
+    `conflict_policy` varchar(32) NOT NULL DEFAULT '' COMMENT 'cmdb_wins or manual_wins',
+    `sync_interval` bigint NOT NULL DEFAULT 0 COMMENT 'seconds, 0 means manual only',
+    `disabled` int NOT NULL DEFAULT 0,
+    `last_sync_at` bigint NOT NULL DEFAULT 0,
+    `last_report` text,
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
+    `update_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `cmdb_sync_applied` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `sync_id` bigint NOT NULL DEFAULT 0,
+    `ident` varchar(191) NOT NULL DEFAULT '',
+    `tags` text,
+    `note` varchar(255) NOT NULL DEFAULT '',
+    `group_ids` text,
+    `update_at` bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_cmdb_sync_applied` (`sync_id`, `ident`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/* v9 2026-10-19 audit_log: 配置变更审计，记录带哈希链 */
+CREATE TABLE `audit_log` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `actor` varchar(64) NOT NULL DEFAULT '',
+    `source_ip` varchar(64) NOT NULL DEFAULT '',
+    `auth_method` varchar(128) NOT NULL DEFAULT '' COMMENT 'jwt, user_token:****abcd, proxy, basic:xxx',
+    `method` varchar(16) NOT NULL DEFAULT '',
+    `path` varchar(255) NOT NULL DEFAULT '',
+    `resource_type` varchar(64) NOT NULL DEFAULT '',
+    `resource_id` varchar(191) NOT NULL DEFAULT '',
+    `action` varchar(32) NOT NULL DEFAULT '' COMMENT 'create, update or delete',
+    `status` int NOT NULL DEFAULT 0,
+    `error` varchar(512) NOT NULL DEFAULT '',
+    `before` text,
+    `after` text,
+    `diff` text,
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `prev_hash` varchar(64) NOT NULL DEFAULT '',
+    `hash` varchar(64) NOT NULL DEFAULT '' COMMENT 'sha256 of prev_hash and this record',
+    PRIMARY KEY (`id`),
+    KEY `idx_audit_log_actor` (`actor`),
+    KEY `idx_audit_log_resource` (`resource_type`, `resource_id`),
+    KEY `idx_audit_log_create_at` (`create_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/* v9 2026-10-19 config_revision: 告警规则、通知规则、事件处理、消息模板、仪表盘的历史版本 */
+CREATE TABLE `config_revision` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `resource_type` varchar(64) NOT NULL DEFAULT '' COMMENT 'alert_rule, notify_rule, event_pipeline, message_template, board',
+    `resource_id` bigint NOT NULL DEFAULT 0,
+    `version` int NOT NULL DEFAULT 0,
+    `content` mediumtext,
+    `restored_from` int NOT NULL DEFAULT 0 COMMENT 'version restored from, 0 for normal save',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `idx_config_revision_version` (`resource_type`, `resource_id`, `version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/* v9 2026-10-19 users.disabled: 停用的用户不能登录，由 SCIM 同步 */
+ALTER TABLE `users` ADD COLUMN `disabled` int NOT NULL DEFAULT 0 COMMENT '1 means disabled';
+
+/* v9 2026-10-19 user_totp, role.totp_required: 本地账号的 TOTP 两步验证 */
+ALTER TABLE `role` ADD COLUMN `totp_required` int NOT NULL DEFAULT 0 COMMENT '1 means two-factor authentication is required';
+CREATE TABLE `user_totp` (
+    `user_id` bigint NOT NULL,
+    `secret` varchar(64) NOT NULL DEFAULT '',
+    `enabled` int NOT NULL DEFAULT 0 COMMENT '0 pending 1 enabled',
+    `recovery_codes` text COMMENT 'sha256 of unused recovery codes, comma separated',
+    `last_step` bigint NOT NULL DEFAULT 0 COMMENT 'time step of the last accepted code',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `update_at` bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/* v9 2026-10-19 user_token: 过期时间、IP 白名单、权限范围和调用统计 */
+ALTER TABLE `user_token` ADD COLUMN `expire_at` bigint NOT NULL DEFAULT 0 COMMENT '0 means never expire';
+ALTER TABLE `user_token` ADD COLUMN `ip_allowlist` text COMMENT 'ip or cidr list, json';
+ALTER TABLE `user_token` ADD COLUMN `scopes` text COMMENT 'operations of ops.yaml, json';
+ALTER TABLE `user_token` ADD COLUMN `busi_group_ids` text COMMENT 'json';
+ALTER TABLE `user_token` ADD COLUMN `read_only` int NOT NULL DEFAULT 0;
+ALTER TABLE `user_token` ADD COLUMN `use_count` bigint NOT NULL DEFAULT 0;
+ALTER TABLE `user_token` ADD COLUMN `denied_count` bigint NOT NULL DEFAULT 0;
+ALTER TABLE `user_token` ADD COLUMN `last_used_ip` varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE `user_token` ADD COLUMN `update_at` bigint NOT NULL DEFAULT 0;
+
+/* v9 2026-10-19 datasource_acl: 数据源级别的查询、管理授权 */
+CREATE TABLE `datasource_acl` (
+    `id` bigint unsigned not null auto_increment,
+    `datasource_id` bigint not null default 0,
+    `subject_type` varchar(32) not null default '' COMMENT 'user_group or busi_group',
+    `subject_id` bigint not null default 0,
+    `perm` varchar(32) not null default '' COMMENT 'query or admin',
+    `create_at` bigint not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` bigint not null default 0,
+    PRIMARY KEY (`id`),
+    KEY (`datasource_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+/* v9 2026-10-19 users.password_update_at, user_password_history: 本地账号的密码策略 */
+ALTER TABLE `users` ADD COLUMN `password_update_at` bigint NOT NULL DEFAULT 0 COMMENT 'last time the password was changed';
+CREATE TABLE `user_password_history` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+    `user_id` bigint NOT NULL DEFAULT 0,
+    `password` varchar(128) NOT NULL DEFAULT '' COMMENT 'hash of a previously used password',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    KEY (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/* v9 2026-10-19 tenant: 多租户，用户、团队、业务组、数据源和通知相关配置归属到租户 */
+CREATE TABLE `tenant` (
+    `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+    `name` varchar(191) NOT NULL DEFAULT '',
+    `note` varchar(1024) NOT NULL DEFAULT '',
+    `rule_quota` bigint NOT NULL DEFAULT 0 COMMENT 'max number of alert rules, 0 means unlimited',
+    `target_quota` bigint NOT NULL DEFAULT 0 COMMENT 'max number of targets, 0 means unlimited',
+    `create_at` bigint NOT NULL DEFAULT 0,
+    `create_by` varchar(64) NOT NULL DEFAULT '',
+    `update_at` bigint NOT NULL DEFAULT 0,
+    `update_by` varchar(64) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+ALTER TABLE `users` ADD COLUMN `tenant_id` bigint NOT NULL DEFAULT 0 COMMENT 'tenant id, 0 means platform';
+ALTER TABLE `user_group` ADD COLUMN `tenant_id` bigint NOT NULL DEFAULT 0 COMMENT 'tenant id, 0 means platform';
+ALTER TABLE `busi_group` ADD COLUMN `tenant_id` bigint NOT NULL DEFAULT 0 COMMENT 'tenant id, 0 means platform';
+ALTER TABLE `datasource` ADD COLUMN `tenant_id` bigint NOT NULL DEFAULT 0 COMMENT 'tenant id, 0 means platform';
+ALTER TABLE `notify_rule` ADD COLUMN `tenant_id` bigint NOT NULL DEFAULT 0 COMMENT 'tenant id, 0 means platform';
+ALTER TABLE `notify_channel` ADD COLUMN `tenant_id` bigint NOT NULL DEFAULT 0 COMMENT 'tenant id, 0 means platform';
+ALTER TABLE `message_template` ADD COLUMN `tenant_id` bigint NOT NULL DEFAULT 0 COMMENT 'tenant id, 0 means platform';
@@ -11,6 +11,10 @@ CREATE TABLE `users` (
     `maintainer` tinyint(1) not null default 0,
     `belong` varchar(16) not null default '',
     `last_active_time` bigint not null default 0,
+    `disabled` int not null default 0,
+    `password_update_at` bigint not null default 0,
+    `tenant_id` bigint not null default 0,
+    `scim_external_id` varchar(255) not null default '',
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -25,6 +29,7 @@ CREATE TABLE `user_group` (
     `id` integer primary key autoincrement,
     `name` varchar(128) not null default '',
     `note` varchar(255) not null default '',
+    `tenant_id` bigint not null default 0,
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -61,7 +66,8 @@ CREATE TABLE `configs` (
 CREATE TABLE `role` (
     `id` integer primary key autoincrement,
     `name` varchar(191) not null unique default '',
-    `note` varchar(255) not null default ''
+    `note` varchar(255) not null default '',
+    `totp_required` int not null default 0
 );
 
 insert into `role`(name, note) values('Admin', 'Administrator role');
@@ -144,6 +150,14 @@ insert into `role_operation`(role_name, operation) values('Standard', '/recordin
 insert into `role_operation`(role_name, operation) values('Standard', '/recording-rules/add');
 insert into `role_operation`(role_name, operation) values('Standard', '/recording-rules/put');
 insert into `role_operation`(role_name, operation) values('Standard', '/recording-rules/del');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/add');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/put');
+insert into `role_operation`(role_name, operation) values('Standard', '/scrape-jobs/del');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/add');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/put');
+insert into `role_operation`(role_name, operation) values('Standard', '/ingest-tokens/del');
 
 -- for alert_rule | collect_rule | mute | dashboard grouping
 CREATE TABLE `busi_group` (
@@ -151,6 +165,7 @@ CREATE TABLE `busi_group` (
     `name` varchar(191) not null unique,
     `label_enable` tinyint(1) not null default 0,
     `label_value` varchar(191) not null default '',
+    `tenant_id` bigint not null default 0,
     `create_at` bigint not null default 0,
     `create_by` varchar(64) not null default '',
     `update_at` bigint not null default 0,
@@ -328,6 +343,7 @@ CREATE TABLE `target` (
     `host_tags` text,
     `engine_name` varchar(255) default '',
     `os` varchar(31) default '',
+    `stale_since` bigint not null default 0,
     `update_at` bigint not null default 0
 );
 
@@ -517,12 +533,196 @@ CREATE TABLE `notification_record` (
     `status` integer,
     `target` varchar(1024) not null,
     `details` varchar(2048) default '',
-    `created_at` integer not null
+    `created_at` integer not null,
+    `retry_id` integer not null default 0
 );
 CREATE INDEX idx_evt ON notification_record (event_id);
 CREATE INDEX idx_nr_rule_created_evt ON notification_record (notify_rule_id, created_at, event_id);
 CREATE INDEX idx_nr_created_at ON notification_record (created_at);
 
+CREATE TABLE `notify_retry` (
+    `id` integer primary key autoincrement,
+    `notify_rule_id` integer not null default 0,
+    `channel_id` integer not null default 0,
+    `channel_name` varchar(255) not null default '',
+    `template_id` integer not null default 0,
+    `event_ids` text,
+    `sendtos` text,
+    `custom_params` text,
+    `flashduty_channel_ids` text,
+    `pagerduty_routing_keys` text,
+    `im_group_ids` text,
+    `target` varchar(1024) not null default '',
+    `status` varchar(32) not null default '',
+    `attempts` integer not null default 0,
+    `max_attempts` integer not null default 0,
+    `resends` integer not null default 0,
+    `next_retry_at` integer not null default 0,
+    `last_error` varchar(2048) not null default '',
+    `created_at` integer not null default 0,
+    `updated_at` integer not null default 0,
+    `updated_by` varchar(64) not null default ''
+);
+CREATE INDEX idx_nrt_status_next ON notify_retry (status, next_retry_at);
+
+CREATE TABLE `scrape_job` (
+    `id` integer primary key autoincrement,
+    `group_id` integer not null default 0,
+    `name` varchar(255) not null default '',
+    `disabled` integer not null default 0,
+    `sd_type` varchar(32) not null default '',
+    `targets` text,
+    `sd_file` varchar(1024) not null default '',
+    `sd_url` varchar(1024) not null default '',
+    `target_group_ids` text,
+    `target_port` integer not null default 0,
+    `scheme` varchar(16) not null default '',
+    `metrics_path` varchar(255) not null default '',
+    `headers` text,
+    `scrape_interval` integer not null default 0,
+    `scrape_timeout` integer not null default 0,
+    `honor_labels` numeric not null default false,
+    `labels` text,
+    `relabel_configs` text,
+    `metric_relabel_configs` text,
+    `note` varchar(1024) not null default '',
+    `create_at` integer not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` integer not null default 0,
+    `update_by` varchar(64) not null default ''
+);
+CREATE INDEX idx_scrape_job_group_id ON scrape_job (group_id);
+
+CREATE TABLE `ingest_token` (
+    `id` integer primary key autoincrement,
+    `group_id` integer not null default 0,
+    `token` varchar(255) not null default '',
+    `note` varchar(255) not null default '',
+    `metric_prefixes` text,
+    `rate_limit` integer not null default 0,
+    `disabled` integer not null default 0,
+    `expire_at` integer not null default 0,
+    `create_at` integer not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` integer not null default 0,
+    `update_by` varchar(64) not null default ''
+);
+CREATE INDEX idx_ingest_token_group_id ON ingest_token (group_id);
+CREATE INDEX idx_ingest_token_token ON ingest_token (token);
+
+CREATE TABLE `target_history` (
+    `id` integer primary key autoincrement,
+    `ident` varchar(191) not null default '',
+    `field` varchar(64) not null default '',
+    `old_value` text,
+    `new_value` text,
+    `operator` varchar(64) not null default '',
+    `create_at` integer not null default 0
+);
+CREATE INDEX idx_target_history_ident ON target_history (ident);
+CREATE INDEX idx_target_history_create_at ON target_history (create_at);
+
+CREATE TABLE `cmdb_sync` (
+    `id` integer primary key autoincrement,
+    `name` varchar(255) not null default '',
+    `source_type` varchar(32) not null default '',
+    `url` varchar(1024) not null default '',
+    `headers` text,
+    `timeout` int not null default 0,
+    `items_path` varchar(255) not null default '',
+    `match_by` varchar(32) not null default '',
+    `ident_field` varchar(255) not null default '',
+    `note_field` varchar(255) not null default '',
+    `tag_fields` text,
+    `group_field` varchar(255) not null default '',
+    `group_match_by` varchar(32) not null default '',
+    `conflict_policy` varchar(32) not null default '',
+    `sync_interval` integer not null default 0,
+    `disabled` int not null default 0,
+    `last_sync_at` integer not null default 0,
+    `last_report` text,
+    `create_at` integer not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` integer not null default 0,
+    `update_by` varchar(64) not null default ''
+);
+
+CREATE TABLE `cmdb_sync_applied` (
+    `id` integer primary key autoincrement,
+    `sync_id` integer not null default 0,
+    `ident` varchar(191) not null default '',
+    `tags` text,
+    `note` varchar(255) not null default '',
+    `group_ids` text,
+    `update_at` integer not null default 0
+);
+CREATE UNIQUE INDEX idx_cmdb_sync_applied ON `cmdb_sync_applied` (sync_id, ident);
+
+CREATE TABLE `audit_log` (
+    `id` integer primary key autoincrement,
+    `actor` varchar(64) not null default '',
+    `source_ip` varchar(64) not null default '',
+    `auth_method` varchar(128) not null default '',
+    `method` varchar(16) not null default '',
+    `path` varchar(255) not null default '',
+    `resource_type` varchar(64) not null default '',
+    `resource_id` varchar(191) not null default '',
+    `action` varchar(32) not null default '',
+    `status` int not null default 0,
+    `error` varchar(512) not null default '',
+    `before` text,
+    `after` text,
+    `diff` text,
+    `create_at` integer not null default 0,
+    `prev_hash` varchar(64) not null default '',
+    `hash` varchar(64) not null default ''
+);
+CREATE INDEX idx_audit_log_actor ON audit_log (actor);
+CREATE INDEX idx_audit_log_resource ON audit_log (resource_type, resource_id);
+CREATE INDEX idx_audit_log_create_at ON audit_log (create_at);
+
+CREATE TABLE `config_revision` (
+    `id` integer primary key autoincrement,
+    `resource_type` varchar(64) not null default '',
+    `resource_id` integer not null default 0,
+    `version` int not null default 0,
+    `content` mediumtext,
+    `restored_from` int not null default 0,
+    `create_at` integer not null default 0,
+    `create_by` varchar(64) not null default ''
+);
+CREATE UNIQUE INDEX idx_config_revision_version ON config_revision (resource_type, resource_id, version);
+
+CREATE TABLE `user_totp` (
+    `user_id` integer primary key,
+    `secret` varchar(64) not null default '',
+    `enabled` int not null default 0,
+    `recovery_codes` text,
+    `last_step` integer not null default 0,
+    `create_at` integer not null default 0,
+    `update_at` integer not null default 0
+);
+
+CREATE TABLE `user_password_history` (
+    `id` integer primary key autoincrement,
+    `user_id` integer not null default 0,
+    `password` varchar(128) not null default '',
+    `create_at` integer not null default 0
+);
+CREATE INDEX idx_user_password_history_user_id ON user_password_history (user_id);
+
+CREATE TABLE `tenant` (
+    `id` integer primary key autoincrement,
+    `name` varchar(191) not null default '' unique,
+    `note` varchar(1024) not null default '',
+    `rule_quota` integer not null default 0,
+    `target_quota` integer not null default 0,
+    `create_at` integer not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` integer not null default 0,
+    `update_by` varchar(64) not null default ''
+);
+
 CREATE TABLE `task_tpl` (
     `id`        integer primary key autoincrement,
     `group_id`  int unsigned not null,
@@ -596,6 +796,7 @@ CREATE TABLE `datasource`
     `auth` varchar(8192) not null default '',
     `is_default` tinyint not null default 0,
     `weight` int not null default 0,
+    `tenant_id` bigint not null default 0,
     `created_at` bigint not null default 0,
     `created_by` varchar(64) not null default '',
     `updated_at` bigint not null default 0,
@@ -604,6 +805,19 @@ CREATE TABLE `datasource`
 
 CREATE UNIQUE INDEX idx_datasource_name ON datasource (name);
 
+CREATE TABLE `datasource_acl` (
+    `id` integer primary key autoincrement,
+    `datasource_id` integer not null default 0,
+    `subject_type` varchar(32) not null default '',
+    `subject_id` integer not null default 0,
+    `perm` varchar(32) not null default '',
+    `create_at` integer not null default 0,
+    `create_by` varchar(64) not null default '',
+    `update_at` integer not null default 0
+);
+
+CREATE INDEX idx_datasource_acl_datasource_id ON datasource_acl (datasource_id);
+
 CREATE TABLE `builtin_cate` (
     `id` integer primary key autoincrement,
     `name` varchar(191) not null,
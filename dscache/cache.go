@@ -24,13 +24,15 @@ func closeIfPossible(cate string, dsId int64, ds datasource.Datasource, reason s
 }
 
 type Cache struct {
-	datas map[string]map[int64]datasource.Datasource
-	mutex *sync.RWMutex
+	datas   map[string]map[int64]datasource.Datasource
+	tenants map[int64]int64 // key: datasource id value: tenant id
+	mutex   *sync.RWMutex
 }
 
 var DsCache = Cache{
-	datas: make(map[string]map[int64]datasource.Datasource),
-	mutex: new(sync.RWMutex),
+	datas:   make(map[string]map[int64]datasource.Datasource),
+	tenants: make(map[int64]int64),
+	mutex:   new(sync.RWMutex),
 }
 
 func (cs *Cache) Put(cate string, dsId int64, ds datasource.Datasource) {
@@ -91,6 +93,28 @@ func (cs *Cache) Get(cate string, dsId int64) (datasource.Datasource, bool) {
 	return cs.datas[cate][dsId], true
 }
 
+// SetTenants 替换数据源所属租户的快照
+func (cs *Cache) SetTenants(tenants map[int64]int64) {
+	cs.mutex.Lock()
+	cs.tenants = tenants
+	cs.mutex.Unlock()
+}
+
+// GetOfTenant 与 Get 相同，tenantId 大于 0 时其他租户的数据源视为不存在
+func (cs *Cache) GetOfTenant(cate string, dsId, tenantId int64) (datasource.Datasource, bool) {
+	if tenantId > 0 {
+		cs.mutex.RLock()
+		tid := cs.tenants[dsId]
+		cs.mutex.RUnlock()
+
+		if tid != tenantId {
+			return nil, false
+		}
+	}
+
+	return cs.Get(cate, dsId)
+}
+
 func (cs *Cache) Delete(cate string, dsId int64) {
 	cs.mutex.Lock()
 	if _, found := cs.datas[cate]; !found {
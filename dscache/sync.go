@@ -144,6 +144,7 @@ func getDatasourcesFromDBLoop(ctx *ctx.Context, fromAPI bool) {
 					Status:         item.Status,
 					IsDefault:      item.IsDefault,
 					Weight:         item.Weight,
+					TenantId:       item.TenantId,
 				}
 
 				if item.PluginType == "elasticsearch" {
@@ -252,6 +253,7 @@ func PutDatasources(items []datasource.DatasourceInfo, isCenter bool) {
 	// 记录当前有效的数据源 ID，按类型分组
 	validIds := make(map[string]map[int64]struct{})
 	ids := make([]int64, 0)
+	tenants := make(map[int64]int64, len(items))
 
 	for _, item := range items {
 		if item.Type == "prometheus" {
@@ -278,6 +280,7 @@ func PutDatasources(items []datasource.DatasourceInfo, isCenter bool) {
 			continue
 		}
 		ids = append(ids, item.Id)
+		tenants[item.Id] = item.TenantId
 
 		// 记录有效的数据源 ID
 		if _, ok := validIds[typ]; !ok {
@@ -296,6 +299,8 @@ func PutDatasources(items []datasource.DatasourceInfo, isCenter bool) {
 		}()
 	}
 
+	DsCache.SetTenants(tenants)
+
 	// 删除 items 中不存在但 DsCache 中存在的数据源
 	cachedIds := DsCache.GetAllIds()
 	for cate, dsIds := range cachedIds {
@@ -60,6 +60,17 @@ func (c *BusiGroupCacheType) GetByBusiGroupId(id int64) *models.BusiGroup {
 	return c.ugs[id]
 }
 
+// GetTenantId 业务组所属的租户，业务组不存在时返回 0
+func (c *BusiGroupCacheType) GetTenantId(id int64) int64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	if bg, has := c.ugs[id]; has {
+		return bg.TenantId
+	}
+	return 0
+}
+
 func (c *BusiGroupCacheType) GetNamesByBusiGroupIds(ids []int64) []string {
 	c.RLock()
 	defer c.RUnlock()
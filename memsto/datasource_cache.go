@@ -33,6 +33,11 @@ type DatasourceCacheType struct {
 	ds          map[int64]*models.Datasource            // key: id value: datasource
 	CateToIDs   map[string]map[int64]*models.Datasource // key1: cate key2: id value: datasource
 	CateToNames map[string]map[string]int64             // key1: cate key2: name value: id
+
+	// 数据源授权只在 center 上同步，edge 和告警引擎用不到
+	aclStatTotal       int64
+	aclStatLastUpdated int64
+	acls               map[int64][]*models.DatasourceAcl // key: datasource id
 }
 
 func NewDatasourceCache(ctx *ctx.Context, stats *Stats) *DatasourceCacheType {
@@ -45,8 +50,11 @@ func NewDatasourceCache(ctx *ctx.Context, stats *Stats) *DatasourceCacheType {
 		CateToIDs:           make(map[string]map[int64]*models.Datasource),
 		CateToNames:         make(map[string]map[string]int64),
 		DatasourceCheckHook: func(ctx *gin.Context) bool { return false },
-		DatasourceFilter:    func(ds []*models.Datasource, user *models.User) []*models.Datasource { return ds },
+		aclStatTotal:        -1,
+		aclStatLastUpdated:  -1,
+		acls:                make(map[int64][]*models.DatasourceAcl),
 	}
+	ds.DatasourceFilter = ds.AclFilter
 	ds.SyncDatasources()
 	return ds
 }
@@ -148,6 +156,12 @@ func (d *DatasourceCacheType) syncDatasources() error {
 	d.syncMu.Lock()
 	defer d.syncMu.Unlock()
 
+	if d.ctx.IsCenter {
+		if err := d.syncAcls(); err != nil {
+			logger.Warning("failed to sync datasource acls:", err)
+		}
+	}
+
 	start := time.Now()
 
 	stat, err := models.DatasourceStatistics(d.ctx)
@@ -178,3 +192,152 @@ func (d *DatasourceCacheType) syncDatasources() error {
 
 	return nil
 }
+
+func (d *DatasourceCacheType) syncAcls() error {
+	stat, err := models.DatasourceAclStatistics(d.ctx)
+	if err != nil {
+		return errors.WithMessage(err, "failed to call DatasourceAclStatistics")
+	}
+
+	d.RLock()
+	changed := d.aclStatTotal != stat.Total || d.aclStatLastUpdated != stat.LastUpdated
+	d.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	acls, err := models.DatasourceAclGetMap(d.ctx)
+	if err != nil {
+		return errors.WithMessage(err, "failed to call DatasourceAclGetMap")
+	}
+
+	d.Lock()
+	d.acls = acls
+	d.aclStatTotal = stat.Total
+	d.aclStatLastUpdated = stat.LastUpdated
+	d.Unlock()
+	return nil
+}
+
+// SetAcls 直接替换授权快照，不经过数据库
+func (d *DatasourceCacheType) SetAcls(acls map[int64][]*models.DatasourceAcl) {
+	d.Lock()
+	d.acls = acls
+	d.Unlock()
+}
+
+func (d *DatasourceCacheType) GetAcls(dsId int64) []*models.DatasourceAcl {
+	d.RLock()
+	defer d.RUnlock()
+	return d.acls[dsId]
+}
+
+// AclAllowed 判断用户对数据源是否有 perm 权限。其他租户的数据源一律不可见，本租户内管理员不受限制
+func (d *DatasourceCacheType) AclAllowed(user *models.User, dsId int64, perm string) bool {
+	if user != nil && !d.TenantVisible(user, dsId) {
+		return false
+	}
+
+	if user != nil && user.IsAdmin() {
+		return true
+	}
+
+	acls := d.GetAcls(dsId)
+	if len(acls) == 0 || user == nil {
+		return models.DatasourceAclAllowed(acls, nil, nil, perm)
+	}
+
+	ugids, bgids, err := d.aclSubjects(user)
+	if err != nil {
+		logger.Errorf("failed to get groups of user %s: %v", user.Username, err)
+		return false
+	}
+	return models.DatasourceAclAllowed(acls, ugids, bgids, perm)
+}
+
+// AclFilter 过滤掉用户没有查询权限的数据源，是 DatasourceFilter 的默认实现
+func (d *DatasourceCacheType) AclFilter(list []*models.Datasource, user *models.User) []*models.Datasource {
+	if user != nil && user.TenantId > 0 {
+		list = FilterByTenant(list, user.TenantId)
+	}
+
+	if user != nil && user.IsAdmin() {
+		return list
+	}
+
+	var (
+		ugids, bgids []int64
+		loaded       bool
+	)
+	ret := make([]*models.Datasource, 0, len(list))
+	for _, ds := range list {
+		acls := d.GetAcls(ds.Id)
+		if len(acls) > 0 && user != nil && !loaded {
+			var err error
+			ugids, bgids, err = d.aclSubjects(user)
+			if err != nil {
+				logger.Errorf("failed to get groups of user %s: %v", user.Username, err)
+			}
+			loaded = true
+		}
+
+		if models.DatasourceAclAllowed(acls, ugids, bgids, models.DsAclPermQuery) {
+			ret = append(ret, ds)
+		}
+	}
+	return ret
+}
+
+// TenantVisible 数据源是否对用户所在的租户可见，缓存里没有的数据源按租户 0 处理
+func (d *DatasourceCacheType) TenantVisible(user *models.User, dsId int64) bool {
+	if user.TenantId == 0 {
+		return true
+	}
+
+	ds := d.GetById(dsId)
+	return ds != nil && ds.TenantId == user.TenantId
+}
+
+// FilterByTenant 只保留属于租户 tid 的数据源
+func FilterByTenant(list []*models.Datasource, tid int64) []*models.Datasource {
+	ret := make([]*models.Datasource, 0, len(list))
+	for _, ds := range list {
+		if ds.TenantId == tid {
+			ret = append(ret, ds)
+		}
+	}
+	return ret
+}
+
+// GetIDsByDsCateAndQueriesOfTenant 与 GetIDsByDsCateAndQueries 相同，tid 大于 0 时只返回该租户的数据源。
+// 告警规则和记录规则按所在业务组的租户匹配数据源，避免通配规则查到其他租户的数据
+func (d *DatasourceCacheType) GetIDsByDsCateAndQueriesOfTenant(cate string, datasourceQueries []models.DatasourceQuery, tid int64) []int64 {
+	ids := d.GetIDsByDsCateAndQueries(cate, datasourceQueries)
+	if tid == 0 {
+		return ids
+	}
+
+	d.RLock()
+	defer d.RUnlock()
+	ret := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if ds, has := d.ds[id]; has && ds.TenantId == tid {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// aclSubjects 返回用户所在的团队和业务组
+func (d *DatasourceCacheType) aclSubjects(user *models.User) ([]int64, []int64, error) {
+	ugids, err := models.MyGroupIds(d.ctx, user.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bgids, err := models.BusiGroupIds(d.ctx, ugids)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ugids, bgids, nil
+}
@@ -0,0 +1,117 @@
+package memsto
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/dumper"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/pkg/errors"
+	"github.com/toolkits/pkg/logger"
+)
+
+type IngestTokenCacheType struct {
+	statTotal       int64
+	statLastUpdated int64
+	ctx             *ctx.Context
+	stats           *Stats
+
+	sync.RWMutex
+	tokens map[string]*models.IngestToken // key: token
+}
+
+func NewIngestTokenCache(ctx *ctx.Context, stats *Stats) *IngestTokenCacheType {
+	itc := &IngestTokenCacheType{
+		statTotal:       -1,
+		statLastUpdated: -1,
+		ctx:             ctx,
+		stats:           stats,
+		tokens:          make(map[string]*models.IngestToken),
+	}
+	itc.SyncIngestTokens()
+	return itc
+}
+
+func (itc *IngestTokenCacheType) StatChanged(total, lastUpdated int64) bool {
+	if itc.statTotal == total && itc.statLastUpdated == lastUpdated {
+		return false
+	}
+
+	return true
+}
+
+func (itc *IngestTokenCacheType) Set(m map[string]*models.IngestToken, total, lastUpdated int64) {
+	itc.Lock()
+	itc.tokens = m
+	itc.Unlock()
+
+	// only one goroutine used, so no need lock
+	itc.statTotal = total
+	itc.statLastUpdated = lastUpdated
+}
+
+func (itc *IngestTokenCacheType) Get(token string) *models.IngestToken {
+	itc.RLock()
+	defer itc.RUnlock()
+	return itc.tokens[token]
+}
+
+func (itc *IngestTokenCacheType) SyncIngestTokens() {
+	err := itc.syncIngestTokens()
+	if err != nil {
+		fmt.Println("failed to sync ingest tokens:", err)
+		exit(1)
+	}
+
+	go itc.loopSyncIngestTokens()
+}
+
+func (itc *IngestTokenCacheType) loopSyncIngestTokens() {
+	duration := time.Duration(9000) * time.Millisecond
+	for {
+		time.Sleep(duration)
+		if err := itc.syncIngestTokens(); err != nil {
+			logger.Warning("failed to sync ingest tokens:", err)
+		}
+	}
+}
+
+func (itc *IngestTokenCacheType) syncIngestTokens() error {
+	start := time.Now()
+
+	stat, err := models.IngestTokenStatistics(itc.ctx)
+	if err != nil {
+		dumper.PutSyncRecord("ingest_tokens", start.Unix(), -1, -1, "failed to query statistics: "+err.Error())
+		return errors.WithMessage(err, "failed to exec IngestTokenStatistics")
+	}
+
+	if !itc.StatChanged(stat.Total, stat.LastUpdated) {
+		itc.stats.GaugeCronDuration.WithLabelValues("sync_ingest_tokens").Set(0)
+		itc.stats.GaugeSyncNumber.WithLabelValues("sync_ingest_tokens").Set(0)
+		dumper.PutSyncRecord("ingest_tokens", start.Unix(), -1, -1, "not changed")
+		return nil
+	}
+
+	lst, err := models.IngestTokenEnabledGets(itc.ctx)
+	if err != nil {
+		dumper.PutSyncRecord("ingest_tokens", start.Unix(), -1, -1, "failed to query records: "+err.Error())
+		return errors.WithMessage(err, "failed to exec IngestTokenEnabledGets")
+	}
+
+	m := make(map[string]*models.IngestToken)
+	for i := 0; i < len(lst); i++ {
+		m[lst[i].Token] = lst[i]
+	}
+
+	itc.Set(m, stat.Total, stat.LastUpdated)
+
+	ms := time.Since(start).Milliseconds()
+	itc.stats.GaugeCronDuration.WithLabelValues("sync_ingest_tokens").Set(float64(ms))
+	itc.stats.GaugeSyncNumber.WithLabelValues("sync_ingest_tokens").Set(float64(len(m)))
+	dumper.PutSyncRecord("ingest_tokens", start.Unix(), ms, len(m), "success")
+
+	return nil
+}
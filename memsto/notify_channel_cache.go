@@ -45,6 +45,9 @@ type NotifyTask struct {
 // NotifyRecordFunc 通知记录函数类型
 type NotifyRecordFunc func(ctx *ctx.Context, events []*models.AlertCurEvent, notifyRuleId int64, channelName, target, resp string, err error)
 
+// NotifyResultFunc 发送结果回调，返回 true 表示结果已被接管（加入重试队列或更新重试状态，并已写通知记录）
+type NotifyResultFunc func(ctx *ctx.Context, events []*models.AlertCurEvent, retry *models.NotifyRetry, target, resp string, err error) bool
+
 type NotifyChannelCacheType struct {
 	statTotal       int64
 	statLastUpdated int64
@@ -64,6 +67,8 @@ type NotifyChannelCacheType struct {
 
 	// 通知记录回调函数
 	notifyRecordFunc NotifyRecordFunc
+	// 发送结果回调函数，用于失败重试
+	notifyResultFunc NotifyResultFunc
 
 	// TODO(dingtalkapp): 钉钉应用本次不上线，Stream 相关字段先注释；上线时恢复下列四个字段。
 	// dingtalkLeaderNaming  *naming.Naming
@@ -123,6 +128,22 @@ func (ncc *NotifyChannelCacheType) SetNotifyRecordFunc(fn NotifyRecordFunc) {
 	ncc.notifyRecordFunc = fn
 }
 
+// SetNotifyResultFunc 设置发送结果回调函数
+func (ncc *NotifyChannelCacheType) SetNotifyResultFunc(fn NotifyResultFunc) {
+	ncc.notifyResultFunc = fn
+}
+
+// record 记录发送结果，结果先交给 notifyResultFunc，未被接管时再写通知记录
+func (ncc *NotifyChannelCacheType) record(events []*models.AlertCurEvent, notifyRuleId int64, channelName, target, resp string, err error, retry *models.NotifyRetry) {
+	if ncc.notifyResultFunc != nil && retry != nil && ncc.notifyResultFunc(ncc.ctx, events, retry, target, resp, err) {
+		return
+	}
+
+	if ncc.notifyRecordFunc != nil {
+		ncc.notifyRecordFunc(ncc.ctx, events, notifyRuleId, channelName, target, resp, err)
+	}
+}
+
 // TODO(dingtalkapp): 钉钉应用本次不上线，SetDingtalkLeaderNaming 入口先注释；调用方 alert/alert.go 也已注释。
 // func (ncc *NotifyChannelCacheType) SetDingtalkLeaderNaming(nm *naming.Naming) {
 // 	ncc.dingtalkLeaderNaming = nm
@@ -338,9 +359,8 @@ func (ncc *NotifyChannelCacheType) processNotifyTask(task *NotifyTask) {
 				task.NotifyRuleId, task.Request.Config.Name, task.Request.Events[0].Hash, task.Request.TplContent, task.Request.CustomParams, task.Request.Sendtos, resp, resut.Err)
 
 			// 调用通知记录回调函数
-			if ncc.notifyRecordFunc != nil {
-				ncc.notifyRecordFunc(ncc.ctx, task.Request.Events, task.NotifyRuleId, task.Request.Config.Name, ncc.getSendTarget(task.Request.CustomParams, task.Request.Sendtos), resp, resut.Err)
-			}
+			ncc.record(task.Request.Events, task.NotifyRuleId, task.Request.Config.Name, ncc.getSendTarget(task.Request.CustomParams, task.Request.Sendtos), resp, resut.Err,
+				task.Request.Retry(task.Request.Sendtos))
 		} else {
 			for i := range task.Request.Sendtos {
 				// 单人发送模式下，逐个 sendto 渲染并发送，避免在 Provider 内使用全量 Sendtos 造成重复发送。
@@ -353,9 +373,8 @@ func (ncc *NotifyChannelCacheType) processNotifyTask(task *NotifyTask) {
 					task.NotifyRuleId, task.Request.Config.Name, task.Request.Events[0].Hash, task.Request.TplContent, task.Request.CustomParams, task.Request.Sendtos[i], resp, result.Err)
 
 				// 调用通知记录回调函数
-				if ncc.notifyRecordFunc != nil {
-					ncc.notifyRecordFunc(ncc.ctx, task.Request.Events, task.NotifyRuleId, task.Request.Config.Name, ncc.getSendTarget(task.Request.CustomParams, []string{task.Request.Sendtos[i]}), resp, result.Err)
-				}
+				ncc.record(task.Request.Events, task.NotifyRuleId, task.Request.Config.Name, ncc.getSendTarget(task.Request.CustomParams, []string{task.Request.Sendtos[i]}), resp, result.Err,
+					task.Request.Retry([]string{task.Request.Sendtos[i]}))
 			}
 		}
 	}
@@ -587,10 +606,8 @@ func (ncc *NotifyChannelCacheType) startEmailSender(chID int64, smtp *models.SMT
 			}
 
 			// 记录通知详情
-			if ncc.notifyRecordFunc != nil {
-				target := strings.Join(m.Mail.GetHeader("To"), ",")
-				ncc.notifyRecordFunc(ncc.ctx, m.Events, m.NotifyRuleId, "Email", target, "success", err)
-			}
+			target := strings.Join(m.Mail.GetHeader("To"), ",")
+			ncc.record(m.Events, m.NotifyRuleId, "Email", target, "success", err, m.Retry)
 			size++
 
 			if size >= conf.Batch {
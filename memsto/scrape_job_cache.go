@@ -0,0 +1,123 @@
+package memsto
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/dumper"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/pkg/errors"
+	"github.com/toolkits/pkg/logger"
+)
+
+type ScrapeJobCacheType struct {
+	statTotal       int64
+	statLastUpdated int64
+	ctx             *ctx.Context
+	stats           *Stats
+
+	sync.RWMutex
+	jobs map[int64]*models.ScrapeJob // key: job id
+}
+
+func NewScrapeJobCache(ctx *ctx.Context, stats *Stats) *ScrapeJobCacheType {
+	sjc := &ScrapeJobCacheType{
+		statTotal:       -1,
+		statLastUpdated: -1,
+		ctx:             ctx,
+		stats:           stats,
+		jobs:            make(map[int64]*models.ScrapeJob),
+	}
+	sjc.SyncScrapeJobs()
+	return sjc
+}
+
+func (sjc *ScrapeJobCacheType) StatChanged(total, lastUpdated int64) bool {
+	if sjc.statTotal == total && sjc.statLastUpdated == lastUpdated {
+		return false
+	}
+
+	return true
+}
+
+func (sjc *ScrapeJobCacheType) Set(m map[int64]*models.ScrapeJob, total, lastUpdated int64) {
+	sjc.Lock()
+	sjc.jobs = m
+	sjc.Unlock()
+
+	// only one goroutine used, so no need lock
+	sjc.statTotal = total
+	sjc.statLastUpdated = lastUpdated
+}
+
+func (sjc *ScrapeJobCacheType) GetAll() []*models.ScrapeJob {
+	sjc.RLock()
+	defer sjc.RUnlock()
+
+	list := make([]*models.ScrapeJob, 0, len(sjc.jobs))
+	for _, job := range sjc.jobs {
+		list = append(list, job)
+	}
+
+	return list
+}
+
+func (sjc *ScrapeJobCacheType) SyncScrapeJobs() {
+	err := sjc.syncScrapeJobs()
+	if err != nil {
+		fmt.Println("failed to sync scrape jobs:", err)
+		exit(1)
+	}
+
+	go sjc.loopSyncScrapeJobs()
+}
+
+func (sjc *ScrapeJobCacheType) loopSyncScrapeJobs() {
+	duration := time.Duration(9000) * time.Millisecond
+	for {
+		time.Sleep(duration)
+		if err := sjc.syncScrapeJobs(); err != nil {
+			logger.Warning("failed to sync scrape jobs:", err)
+		}
+	}
+}
+
+func (sjc *ScrapeJobCacheType) syncScrapeJobs() error {
+	start := time.Now()
+
+	stat, err := models.ScrapeJobStatistics(sjc.ctx)
+	if err != nil {
+		dumper.PutSyncRecord("scrape_jobs", start.Unix(), -1, -1, "failed to query statistics: "+err.Error())
+		return errors.WithMessage(err, "failed to exec ScrapeJobStatistics")
+	}
+
+	if !sjc.StatChanged(stat.Total, stat.LastUpdated) {
+		sjc.stats.GaugeCronDuration.WithLabelValues("sync_scrape_jobs").Set(0)
+		sjc.stats.GaugeSyncNumber.WithLabelValues("sync_scrape_jobs").Set(0)
+		dumper.PutSyncRecord("scrape_jobs", start.Unix(), -1, -1, "not changed")
+		return nil
+	}
+
+	lst, err := models.ScrapeJobEnabledGets(sjc.ctx)
+	if err != nil {
+		dumper.PutSyncRecord("scrape_jobs", start.Unix(), -1, -1, "failed to query records: "+err.Error())
+		return errors.WithMessage(err, "failed to exec ScrapeJobEnabledGets")
+	}
+
+	m := make(map[int64]*models.ScrapeJob)
+	for i := 0; i < len(lst); i++ {
+		m[lst[i].Id] = lst[i]
+	}
+
+	sjc.Set(m, stat.Total, stat.LastUpdated)
+
+	ms := time.Since(start).Milliseconds()
+	sjc.stats.GaugeCronDuration.WithLabelValues("sync_scrape_jobs").Set(float64(ms))
+	sjc.stats.GaugeSyncNumber.WithLabelValues("sync_scrape_jobs").Set(float64(len(m)))
+	dumper.PutSyncRecord("scrape_jobs", start.Unix(), ms, len(m), "success")
+
+	return nil
+}
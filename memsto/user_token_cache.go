@@ -14,22 +14,34 @@ import (
 )
 
 type UserTokenCacheType struct {
-	statTotal int64
-	ctx       *ctx.Context
-	stats     *Stats
+	statTotal       int64
+	statLastUpdated int64
+	ctx             *ctx.Context
+	stats           *Stats
 
 	sync.RWMutex
-	tokens         map[string]*models.User
-	tokensLastUsed map[string]int64
+	tokens     map[string]*models.User
+	tokenInfos map[string]*models.UserToken
+	usages     map[string]*tokenUsage
+}
+
+// tokenUsage 两次落库之间的调用统计
+type tokenUsage struct {
+	lastUsed int64
+	lastIp   string
+	used     int64
+	denied   int64
 }
 
 func NewUserTokenCache(ctx *ctx.Context, stats *Stats) *UserTokenCacheType {
 	utc := &UserTokenCacheType{
-		statTotal:      -1,
-		ctx:            ctx,
-		stats:          stats,
-		tokens:         make(map[string]*models.User),
-		tokensLastUsed: make(map[string]int64),
+		statTotal:       -1,
+		statLastUpdated: -1,
+		ctx:             ctx,
+		stats:           stats,
+		tokens:          make(map[string]*models.User),
+		tokenInfos:      make(map[string]*models.UserToken),
+		usages:          make(map[string]*tokenUsage),
 	}
 	utc.SyncUserTokens()
 	return utc
@@ -49,27 +61,53 @@ func (utc *UserTokenCacheType) Inject(token string, user *models.User) {
 	utc.Unlock()
 }
 
-func (utc *UserTokenCacheType) StatChanged(total int64) bool {
-	if utc.statTotal == total {
+func (utc *UserTokenCacheType) StatChanged(total, lastUpdated int64) bool {
+	if utc.statTotal == total && utc.statLastUpdated == lastUpdated {
 		return false
 	}
 	return true
 }
 
-func (utc *UserTokenCacheType) Set(tokenUsers map[string]*models.User, total int64) {
+func (utc *UserTokenCacheType) Set(tokenUsers map[string]*models.User, tokenInfos map[string]*models.UserToken, total, lastUpdated int64) {
 	utc.Lock()
 	utc.tokens = tokenUsers
+	utc.tokenInfos = tokenInfos
 	utc.Unlock()
 
 	utc.statTotal = total
+	utc.statLastUpdated = lastUpdated
 }
 
 func (utc *UserTokenCacheType) GetByToken(token string) *models.User {
+	user, _ := utc.Get(token)
+	return user
+}
+
+// Get 返回 token 对应的用户和 token 记录。Inject 进来的 token 还没有同步到记录，返回的记录为 nil，视为不受限
+func (utc *UserTokenCacheType) Get(token string) (*models.User, *models.UserToken) {
+	utc.RLock()
+	defer utc.RUnlock()
+
+	return utc.tokens[token], utc.tokenInfos[token]
+}
+
+// RecordUsage 记录一次调用，denied 表示 token 有效但因过期、IP 等限制被拒绝
+func (utc *UserTokenCacheType) RecordUsage(token, ip string, denied bool) {
 	utc.Lock()
 	defer utc.Unlock()
-	utc.tokensLastUsed[token] = time.Now().Unix()
 
-	return utc.tokens[token]
+	u, has := utc.usages[token]
+	if !has {
+		u = &tokenUsage{}
+		utc.usages[token] = u
+	}
+	if denied {
+		u.denied++
+		return
+	}
+	u.used++
+	u.lastUsed = time.Now().Unix()
+	u.lastIp = ip
 }
 
 func (utc *UserTokenCacheType) SyncUserTokens() {
@@ -80,16 +118,14 @@ func (utc *UserTokenCacheType) SyncUserTokens() {
 	}
 
 	go utc.loopSyncUserTokens()
-	go utc.loopUpdateUserTokenLastUsedTime()
+	go utc.loopUpdateUserTokenUsage()
 }
 
-func (utc *UserTokenCacheType) loopUpdateUserTokenLastUsedTime() {
+func (utc *UserTokenCacheType) loopUpdateUserTokenUsage() {
 	duration := time.Duration(10) * time.Minute
 	for {
 		time.Sleep(duration)
-		if err := utc.updateUserTokenLastUsedTime(); err != nil {
-			logger.Warning("failed to update user token last used time:", err)
-		}
+		utc.updateUserTokenUsage()
 	}
 }
 
@@ -103,47 +139,31 @@ func (utc *UserTokenCacheType) loopSyncUserTokens() {
 	}
 }
 
-func (utc *UserTokenCacheType) updateUserTokenLastUsedTime() error {
-	tokenLastUsedMap := make(map[string]int64)
-	now := time.Now().Unix()
-
+// updateUserTokenUsage 把累积的调用统计写入数据库，只处理有调用的 token
+func (utc *UserTokenCacheType) updateUserTokenUsage() {
 	utc.Lock()
-	for token, lastUsedTime := range utc.tokensLastUsed {
-		if lastUsedTime == 0 {
-			continue
-		}
-
-		if now-lastUsedTime > 1800 {
-			// 如果 token 已经 30 分钟没有使用，不再更新数据库
-			delete(utc.tokensLastUsed, token)
-			continue
-		}
-
-		tokenLastUsedMap[token] = lastUsedTime
-	}
+	usages := utc.usages
+	utc.usages = make(map[string]*tokenUsage)
 	utc.Unlock()
 
-	for token, lastUsedTime := range tokenLastUsedMap {
-		err := models.UserTokenUpdateLastUsedTime(utc.ctx, token, lastUsedTime)
+	for token, u := range usages {
+		err := models.UserTokenUpdateUsage(utc.ctx, token, u.lastUsed, u.lastIp, u.used, u.denied)
 		if err != nil {
-			logger.Warning("failed to update user token last used time:", err)
-			continue
+			logger.Warning("failed to update user token usage:", err)
 		}
 	}
-
-	return nil
 }
 
 func (utc *UserTokenCacheType) syncUserTokens() error {
 	start := time.Now()
 
-	total, err := models.UserTokenTotal(utc.ctx)
+	stat, err := models.UserTokenStatistics(utc.ctx)
 	if err != nil {
 		dumper.PutSyncRecord("user_tokens", start.Unix(), -1, -1, "failed to query statistics: "+err.Error())
 		return errors.WithMessage(err, "failed to exec UserTokenStatistics")
 	}
 
-	if !utc.StatChanged(total) {
+	if !utc.StatChanged(stat.Total, stat.LastUpdated) {
 		utc.stats.GaugeCronDuration.WithLabelValues("sync_user_tokens").Set(0)
 		utc.stats.GaugeSyncNumber.WithLabelValues("sync_user_tokens").Set(0)
 		dumper.PutSyncRecord("user_tokens", start.Unix(), -1, -1, "not changed")
@@ -168,6 +188,7 @@ func (utc *UserTokenCacheType) syncUserTokens() error {
 	}
 
 	tokenUsers := make(map[string]*models.User)
+	tokenInfos := make(map[string]*models.UserToken)
 	for _, token := range lst {
 		user, ok := userMap[token.Username]
 		if !ok {
@@ -175,9 +196,10 @@ func (utc *UserTokenCacheType) syncUserTokens() error {
 		}
 
 		tokenUsers[token.Token] = user
+		tokenInfos[token.Token] = token
 	}
 
-	utc.Set(tokenUsers, total)
+	utc.Set(tokenUsers, tokenInfos, stat.Total, stat.LastUpdated)
 
 	ms := time.Since(start).Milliseconds()
 	utc.stats.GaugeCronDuration.WithLabelValues("sync_user_tokens").Set(float64(ms))
@@ -93,6 +93,10 @@ type AlertCurEvent struct {
 	NotifyVersion int                `json:"notify_version"  gorm:"-"` // 0: old, 1: new
 	NotifyRules   []*EventNotifyRule `json:"notify_rules" gorm:"-"`
 	RecoverTime   int64              `json:"recover_time" gorm:"-"`
+
+	// EnrichData 由 pipeline 的 enrich.query 处理器附加的结构化数据，key 为处理器配置的 key，
+	// 消息模板和 AI 总结可以通过 $event.EnrichData 渲染
+	EnrichData map[string][]map[string]string `json:"enrich_data,omitempty" gorm:"-"`
 }
 
 type EventNotifyRule struct {
@@ -1076,6 +1080,22 @@ func (e *AlertCurEvent) DeepCopy() *AlertCurEvent {
 		}
 	}
 
+	if e.EnrichData != nil {
+		eventCopy.EnrichData = make(map[string][]map[string]string, len(e.EnrichData))
+		for key, rows := range e.EnrichData {
+			rowsCopy := make([]map[string]string, len(rows))
+			for i, row := range rows {
+				if row != nil {
+					rowsCopy[i] = make(map[string]string, len(row))
+					for k, v := range row {
+						rowsCopy[i][k] = v
+					}
+				}
+			}
+			eventCopy.EnrichData[key] = rowsCopy
+		}
+	}
+
 	if e.NotifyRuleIds != nil {
 		eventCopy.NotifyRuleIds = make([]int64, len(e.NotifyRuleIds))
 		copy(eventCopy.NotifyRuleIds, e.NotifyRuleIds)
@@ -304,6 +304,12 @@ func AlertMuteGetsByBGIds(ctx *ctx.Context, bgids []int64) (lst []AlertMute, err
 	return
 }
 
+// AlertMuteGetsExpiredBetween 按时间段屏蔽中结束时间落在 (start, end] 的规则
+func AlertMuteGetsExpiredBetween(ctx *ctx.Context, start, end int64) (lst []AlertMute, err error) {
+	err = DB(ctx).Where("mute_time_type = ? AND etime > ? AND etime <= ?", TimeRange, start, end).Order("id").Find(&lst).Error
+	return
+}
+
 func (m *AlertMute) Verify() error {
 	if m.GroupId < 0 {
 		return errors.New("group_id invalid")
@@ -334,6 +340,16 @@ func (m *AlertMute) Parse() error {
 	return nil
 }
 
+// AlertMuteChangedHook 屏蔽规则新增、修改、删除后调用，action 为 created / updated / deleted，
+// center 据此发布屏蔽规则变更事件
+var AlertMuteChangedHook func(action string, lst []AlertMute, operator string)
+
+func alertMuteChanged(action string, lst []AlertMute, operator string) {
+	if AlertMuteChangedHook != nil && len(lst) > 0 {
+		AlertMuteChangedHook(action, lst, operator)
+	}
+}
+
 func (m *AlertMute) Add(ctx *ctx.Context) error {
 	if err := m.Verify(); err != nil {
 		return err
@@ -346,7 +362,12 @@ func (m *AlertMute) Add(ctx *ctx.Context) error {
 	now := time.Now().Unix()
 	m.CreateAt = now
 	m.UpdateAt = now
-	return Insert(ctx, m)
+	if err := Insert(ctx, m); err != nil {
+		return err
+	}
+
+	alertMuteChanged("created", []AlertMute{*m}, m.CreateBy)
+	return nil
 }
 
 func (m *AlertMute) Update(ctx *ctx.Context, arm AlertMute) error {
@@ -366,7 +387,12 @@ func (m *AlertMute) Update(ctx *ctx.Context, arm AlertMute) error {
 		return err
 	}
 
-	return DB(ctx).Model(m).Select("*").Updates(arm).Error
+	if err := DB(ctx).Model(m).Select("*").Updates(arm).Error; err != nil {
+		return err
+	}
+
+	alertMuteChanged("updated", []AlertMute{arm}, arm.UpdateBy)
+	return nil
 }
 
 func (m *AlertMute) FE2DB() error {
@@ -435,7 +461,21 @@ func (m *AlertMute) DB2FE() error {
 }
 
 func (m *AlertMute) UpdateFieldsMap(ctx *ctx.Context, fields map[string]interface{}) error {
-	return DB(ctx).Model(m).Updates(fields).Error
+	if err := DB(ctx).Model(m).Updates(fields).Error; err != nil {
+		return err
+	}
+
+	if AlertMuteChangedHook != nil {
+		// 只改了部分字段，重新读出完整的规则
+		am, err := AlertMuteGetById(ctx, m.Id)
+		if err != nil || am == nil {
+			logger.Warningf("failed to reload alert mute:%d after update: %v", m.Id, err)
+			return nil
+		}
+		operator, _ := fields["update_by"].(string)
+		alertMuteChanged("updated", []AlertMute{*am}, operator)
+	}
+	return nil
 }
 
 func (m *AlertMute) IsWithinTimeRange(checkTime int64) bool {
@@ -469,24 +509,69 @@ func (m *AlertMute) IsWithinPeriodicMute(checkTime int64) bool {
 	return false
 }
 
-func AlertMuteDel(ctx *ctx.Context, ids []int64) error {
+func AlertMuteDel(ctx *ctx.Context, ids []int64, operator string) error {
 	if len(ids) == 0 {
 		return nil
 	}
-	return DB(ctx).Where("id in ?", ids).Delete(new(AlertMute)).Error
+
+	var lst []AlertMute
+	if AlertMuteChangedHook != nil {
+		// 删除之后就查不到了，先把要删的规则读出来
+		if err := DB(ctx).Where("id in ?", ids).Find(&lst).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := DB(ctx).Where("id in ?", ids).Delete(new(AlertMute)).Error; err != nil {
+		return err
+	}
+
+	alertMuteChanged("deleted", alertMutesDB2FE(lst), operator)
+	return nil
+}
+
+func alertMutesDB2FE(lst []AlertMute) []AlertMute {
+	for i := range lst {
+		lst[i].DB2FE()
+	}
+	return lst
 }
 
 // AlertMuteBatchDelete deletes time-range alert mutes that expired before the
 // given timestamp (etime > 0 AND etime < timestamp) and were created before
 // the timestamp. Periodic mutes are skipped. Optionally restrict to the
 // provided group IDs. Returns the number of rows deleted in this batch.
-func AlertMuteBatchDelete(ctx *ctx.Context, timestamp int64, groupIds []int64, limit int) (int64, error) {
+func AlertMuteBatchDelete(ctx *ctx.Context, timestamp int64, groupIds []int64, limit int, operator string) (int64, error) {
 	db := DB(ctx).Where("mute_time_type = ? AND etime > 0 AND etime < ? AND create_at < ?", TimeRange, timestamp, timestamp)
 	if len(groupIds) > 0 {
 		db = db.Where("group_id IN (?)", groupIds)
 	}
-	res := db.Limit(limit).Delete(&AlertMute{})
-	return res.RowsAffected, res.Error
+
+	if AlertMuteChangedHook == nil {
+		res := db.Limit(limit).Delete(&AlertMute{})
+		return res.RowsAffected, res.Error
+	}
+
+	// 需要发布删除事件时先读出本批要删的规则，再按 id 删除
+	var lst []AlertMute
+	if err := db.Order("id").Limit(limit).Find(&lst).Error; err != nil {
+		return 0, err
+	}
+	if len(lst) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(lst))
+	for i := range lst {
+		ids = append(ids, lst[i].Id)
+	}
+	res := DB(ctx).Where("id in ?", ids).Delete(&AlertMute{})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	alertMuteChanged("deleted", alertMutesDB2FE(lst), operator)
+	return res.RowsAffected, nil
 }
 
 func AlertMuteStatistics(ctx *ctx.Context) (*Statistics, error) {
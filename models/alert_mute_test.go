@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -44,7 +45,7 @@ func TestAlertMuteBatchDelete(t *testing.T) {
 	insertMute(t, c, &AlertMute{GroupId: 1, MuteTimeType: Periodic, Etime: threshold - 3600, CreateAt: threshold - 7200})
 
 	// Restrict to group 1: only the first row should match.
-	n, err := AlertMuteBatchDelete(c, threshold, []int64{1}, 100)
+	n, err := AlertMuteBatchDelete(c, threshold, []int64{1}, 100, "root")
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), n)
 
@@ -54,7 +55,7 @@ func TestAlertMuteBatchDelete(t *testing.T) {
 	assert.Equal(t, 5, len(remaining))
 
 	// Now sweep with no group filter: should delete the group 2 expired+old row.
-	n, err = AlertMuteBatchDelete(c, threshold, nil, 100)
+	n, err = AlertMuteBatchDelete(c, threshold, nil, 100, "root")
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), n)
 
@@ -83,7 +84,7 @@ func TestAlertMuteBatchDelete_NoMatch(t *testing.T) {
 	// not expired yet
 	insertMute(t, c, &AlertMute{GroupId: 1, Etime: now + 3600, CreateAt: threshold - 100})
 
-	n, err := AlertMuteBatchDelete(c, threshold, nil, 1000)
+	n, err := AlertMuteBatchDelete(c, threshold, nil, 1000, "root")
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), n)
 
@@ -91,3 +92,34 @@ func TestAlertMuteBatchDelete_NoMatch(t *testing.T) {
 	require.NoError(t, DB(c).Find(&remaining).Error)
 	assert.Equal(t, 2, len(remaining))
 }
+
+func TestAlertMuteChangedHook(t *testing.T) {
+	c := newAlertMuteTestCtx(t)
+	now := time.Now().Unix()
+	threshold := now - 30*24*3600
+
+	var got []string
+	AlertMuteChangedHook = func(action string, lst []AlertMute, operator string) {
+		for _, m := range lst {
+			got = append(got, fmt.Sprintf("%s %d %s", action, m.GroupId, operator))
+		}
+	}
+	defer func() { AlertMuteChangedHook = nil }()
+
+	insertMute(t, c, &AlertMute{Id: 1, GroupId: 1, MuteTimeType: TimeRange, Etime: threshold - 3600, CreateAt: threshold - 7200})
+	insertMute(t, c, &AlertMute{Id: 2, GroupId: 2, MuteTimeType: TimeRange, Etime: threshold - 3600, CreateAt: threshold - 7200})
+	insertMute(t, c, &AlertMute{Id: 3, GroupId: 3, MuteTimeType: TimeRange, Etime: now + 3600, CreateAt: now,
+		DatasourceIds: "[]", PeriodicMutes: "[]"})
+
+	am, err := AlertMuteGetById(c, 3)
+	require.NoError(t, err)
+	require.NoError(t, am.UpdateFieldsMap(c, map[string]interface{}{"disabled": 1, "update_by": "alice"}))
+
+	require.NoError(t, AlertMuteDel(c, []int64{1}, "root"))
+
+	n, err := AlertMuteBatchDelete(c, threshold, nil, 100, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	assert.Equal(t, []string{"updated 3 alice", "deleted 1 root", "deleted 2 bob"}, got)
+}
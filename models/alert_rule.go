@@ -787,7 +787,12 @@ func (ar *AlertRule) Add(ctx *ctx.Context) error {
 	ar.CreateAt = now
 	ar.UpdateAt = now
 
-	return Insert(ctx, ar)
+	if err := Insert(ctx, ar); err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionAlertRule, ar.Id)
+	return nil
 }
 
 // Upsert: 同 group 内若存在同名规则则覆盖（保留原 id/create_at/create_by，下游引用不破），否则插入。
@@ -810,6 +815,15 @@ func (ar *AlertRule) Upsert(ctx *ctx.Context) error {
 }
 
 func (ar *AlertRule) Update(ctx *ctx.Context, arf AlertRule) error {
+	if err := ar.update(ctx, arf); err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionAlertRule, ar.Id)
+	return nil
+}
+
+func (ar *AlertRule) update(ctx *ctx.Context, arf AlertRule) error {
 	if ar.Name != arf.Name {
 		exists, err := AlertRuleExists(ctx, ar.Id, ar.GroupId, arf.Name)
 		if err != nil {
@@ -1742,7 +1756,15 @@ func InsertAlertRule(ctx *ctx.Context, ars []*AlertRule) error {
 	if len(ars) == 0 {
 		return nil
 	}
-	return DB(ctx).Create(ars).Error
+
+	if err := DB(ctx).Create(ars).Error; err != nil {
+		return err
+	}
+
+	for _, ar := range ars {
+		ConfigRevisionRecord(ctx, RevisionAlertRule, ar.Id)
+	}
+	return nil
 }
 
 func (ar *AlertRule) Hash() string {
@@ -0,0 +1,247 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"sync"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// AuditLog 配置变更审计记录。每条记录的 Hash 覆盖本条内容和上一条的 Hash，
+// 中间有记录被删除或修改时链条会断开，可以通过 AuditLogVerify 检查
+type AuditLog struct {
+	Id           int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	Actor        string `json:"actor" gorm:"type:varchar(64);not null;default:'';index:idx_audit_log_actor"`
+	SourceIp     string `json:"source_ip" gorm:"type:varchar(64);not null;default:''"`
+	AuthMethod   string `json:"auth_method" gorm:"type:varchar(128);not null;default:''"` // jwt、user_token:****abcd、proxy、basic:xxx 等
+	Method       string `json:"method" gorm:"type:varchar(16);not null;default:''"`
+	Path         string `json:"path" gorm:"type:varchar(255);not null;default:''"`
+	ResourceType string `json:"resource_type" gorm:"type:varchar(64);not null;default:'';index:idx_audit_log_resource"`
+	ResourceId   string `json:"resource_id" gorm:"type:varchar(191);not null;default:'';index:idx_audit_log_resource"`
+	Action       string `json:"action" gorm:"type:varchar(32);not null;default:''"`
+	Status       int    `json:"status" gorm:"type:int;not null;default:0"`
+	Error        string `json:"error" gorm:"type:varchar(512);not null;default:''"`
+	Before       string `json:"before" gorm:"type:text"` // json
+	After        string `json:"after" gorm:"type:text"`  // json
+	Diff         string `json:"diff" gorm:"type:text"`   // json: [{"field":"","before":..,"after":..}]
+	CreateAt     int64  `json:"create_at" gorm:"type:bigint;not null;default:0;index:idx_audit_log_create_at"`
+	PrevHash     string `json:"prev_hash" gorm:"type:varchar(64);not null;default:''"`
+	Hash         string `json:"hash" gorm:"type:varchar(64);not null;default:''"`
+}
+
+func (l *AuditLog) TableName() string {
+	return "audit_log"
+}
+
+var auditLogKey []byte
+
+// SetAuditLogKey 设置哈希链的 HMAC 密钥。没有密钥时能写库的人可以改完记录再把哈希整条重算一遍，
+// 更换密钥后之前的记录都会校验失败，需要从更换后的第一条记录开始校验
+func SetAuditLogKey(key string) {
+	auditLogKey = []byte(key)
+}
+
+// ComputeHash 不包含自增 id，链条只依赖记录内容和先后顺序
+func (l *AuditLog) ComputeHash() string {
+	var h hash.Hash
+	if len(auditLogKey) > 0 {
+		h = hmac.New(sha256.New, auditLogKey)
+	} else {
+		h = sha256.New()
+	}
+	for _, s := range []string{l.PrevHash, l.Actor, l.SourceIp, l.AuthMethod, l.Method, l.Path,
+		l.ResourceType, l.ResourceId, l.Action, strconv.Itoa(l.Status), l.Error, l.Before, l.After, l.Diff,
+		strconv.FormatInt(l.CreateAt, 10)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 同一进程内串行写入，多实例之间靠锁住最后一行保证链条不分叉
+var auditLogMu sync.Mutex
+
+func AuditLogAdd(ctx *ctx.Context, l *AuditLog) error {
+	auditLogMu.Lock()
+	defer auditLogMu.Unlock()
+
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		session := tx.Model(&AuditLog{}).Select("id", "hash").Order("id desc").Limit(1)
+		if tx.Dialector.Name() != "sqlite" {
+			session = session.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+
+		var last []*AuditLog
+		if err := session.Find(&last).Error; err != nil {
+			return err
+		}
+
+		l.PrevHash = ""
+		if len(last) > 0 {
+			l.PrevHash = last[0].Hash
+		}
+		l.Hash = l.ComputeHash()
+
+		return tx.Create(l).Error
+	})
+}
+
+type AuditLogQuery struct {
+	Actor        string
+	ResourceType string
+	ResourceId   string
+	Action       string
+	Stime        int64
+	Etime        int64
+	Query        string // 模糊匹配 path
+}
+
+func (q AuditLogQuery) where(ctx *ctx.Context) *gorm.DB {
+	session := DB(ctx).Model(&AuditLog{})
+	if q.Actor != "" {
+		session = session.Where("actor = ?", q.Actor)
+	}
+	if q.ResourceType != "" {
+		session = session.Where("resource_type = ?", q.ResourceType)
+	}
+	if q.ResourceId != "" {
+		session = session.Where("resource_id = ?", q.ResourceId)
+	}
+	if q.Action != "" {
+		session = session.Where("action = ?", q.Action)
+	}
+	if q.Stime > 0 {
+		session = session.Where("create_at >= ?", q.Stime)
+	}
+	if q.Etime > 0 {
+		session = session.Where("create_at <= ?", q.Etime)
+	}
+	if q.Query != "" {
+		session = session.Where("path like ?", "%"+q.Query+"%")
+	}
+	return session
+}
+
+func AuditLogTotal(ctx *ctx.Context, q AuditLogQuery) (int64, error) {
+	return Count(q.where(ctx))
+}
+
+func AuditLogGets(ctx *ctx.Context, q AuditLogQuery, limit, offset int) ([]*AuditLog, error) {
+	var lst []*AuditLog
+	err := q.where(ctx).Order("id desc").Limit(limit).Offset(offset).Find(&lst).Error
+	return lst, err
+}
+
+func AuditLogDeleteBefore(ctx *ctx.Context, before int64, batchSize int) (int64, error) {
+	var ids []int64
+	err := DB(ctx).Model(&AuditLog{}).Where("create_at < ?", before).Limit(batchSize).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result := DB(ctx).Where("id in ?", ids).Delete(&AuditLog{})
+	return result.RowsAffected, result.Error
+}
+
+type AuditLogBroken struct {
+	Id     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type AuditLogVerifyResult struct {
+	Checked  int              `json:"checked"`
+	LastId   int64            `json:"last_id"` // 最后校验的记录，下次从 last_id+1 继续
+	HeadId   int64            `json:"head_id"` // 当前最新的记录，和外部导出的记录比对可以发现末尾被删除
+	HeadHash string           `json:"head_hash"`
+	Broken   []AuditLogBroken `json:"broken"`
+}
+
+// AuditLogVerify 从 startId 开始按顺序校验最多 limit 条记录。startId 为 0 时从现存最早的
+// 记录开始，它的 PrevHash 无从校验（更早的记录可能已按保留期清理），作为链条起点。
+// 删除最新的若干条记录不会让链条断开，anchor 传入已导出到 sink 的某条记录的 hash，
+// 库里找不到这条记录说明末尾的记录被删除了
+func AuditLogVerify(ctx *ctx.Context, startId int64, limit int, anchor string) (*AuditLogVerifyResult, error) {
+	const batchSize = 1000
+
+	ret := &AuditLogVerifyResult{LastId: startId}
+
+	var head []*AuditLog
+	if err := DB(ctx).Select("id", "hash").Order("id desc").Limit(1).Find(&head).Error; err != nil {
+		return nil, err
+	}
+	if len(head) > 0 {
+		ret.HeadId = head[0].Id
+		ret.HeadHash = head[0].Hash
+	}
+
+	if anchor != "" {
+		cnt, err := Count(DB(ctx).Model(&AuditLog{}).Where("hash = ?", anchor))
+		if err != nil {
+			return nil, err
+		}
+		if cnt == 0 {
+			ret.Broken = append(ret.Broken, AuditLogBroken{Reason: "anchor hash not found, newest records may have been deleted"})
+		}
+	}
+
+	var prev *AuditLog
+	if startId > 0 {
+		var lst []*AuditLog
+		if err := DB(ctx).Where("id < ?", startId).Order("id desc").Limit(1).Find(&lst).Error; err != nil {
+			return nil, err
+		}
+		if len(lst) > 0 {
+			prev = lst[0]
+		}
+	}
+
+	cursor := startId - 1
+	for ret.Checked < limit {
+		var lst []*AuditLog
+		err := DB(ctx).Where("id > ?", cursor).Order("id").Limit(batchSize).Find(&lst).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range lst {
+			if l.ComputeHash() != l.Hash {
+				ret.Broken = append(ret.Broken, AuditLogBroken{Id: l.Id, Reason: "content does not match hash"})
+			}
+			if prev != nil && l.PrevHash != prev.Hash {
+				ret.Broken = append(ret.Broken, AuditLogBroken{Id: l.Id,
+					Reason: "prev_hash does not match record " + strconv.FormatInt(prev.Id, 10)})
+			}
+
+			prev = l
+			cursor = l.Id
+			ret.LastId = l.Id
+			ret.Checked++
+			if ret.Checked >= limit {
+				break
+			}
+		}
+
+		if len(lst) < batchSize {
+			break
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,107 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAuditLogHashChain(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.AuditLog{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, models.AuditLogAdd(c, &models.AuditLog{
+			Actor:        "root",
+			ResourceType: "alert_rule",
+			ResourceId:   fmt.Sprint(i),
+			Action:       models.AuditActionUpdate,
+			CreateAt:     int64(i),
+		}))
+	}
+
+	ret, err := models.AuditLogVerify(c, 0, 100, "")
+	require.NoError(t, err)
+	assert.Equal(t, 5, ret.Checked)
+	assert.Equal(t, int64(5), ret.LastId)
+	assert.Empty(t, ret.Broken)
+
+	// 分段校验时，起点记录也要和前一条衔接
+	ret, err = models.AuditLogVerify(c, 3, 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, ret.Checked)
+	assert.Equal(t, int64(4), ret.LastId)
+	assert.Empty(t, ret.Broken)
+
+	// 篡改内容
+	require.NoError(t, db.Model(&models.AuditLog{}).Where("id = ?", 2).Update("actor", "someone").Error)
+	// 删除中间的记录
+	require.NoError(t, db.Where("id = ?", 4).Delete(&models.AuditLog{}).Error)
+
+	ret, err = models.AuditLogVerify(c, 0, 100, "")
+	require.NoError(t, err)
+	assert.Equal(t, 4, ret.Checked)
+	assert.Equal(t, []models.AuditLogBroken{
+		{Id: 2, Reason: "content does not match hash"},
+		{Id: 5, Reason: "prev_hash does not match record 3"},
+	}, ret.Broken)
+
+	// 按保留期清理最早的记录后，剩余记录仍然可以通过校验
+	require.NoError(t, db.Where("id < ?", 5).Delete(&models.AuditLog{}).Error)
+	ret, err = models.AuditLogVerify(c, 0, 100, "")
+	require.NoError(t, err)
+	assert.Empty(t, ret.Broken)
+}
+
+func TestAuditLogKeyAndAnchor(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.AuditLog{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	models.SetAuditLogKey("secret")
+	defer models.SetAuditLogKey("")
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, models.AuditLogAdd(c, &models.AuditLog{Actor: "root", ResourceId: fmt.Sprint(i), CreateAt: int64(i)}))
+	}
+
+	ret, err := models.AuditLogVerify(c, 0, 100, "")
+	require.NoError(t, err)
+	assert.Empty(t, ret.Broken)
+	assert.Equal(t, int64(3), ret.HeadId)
+	anchor := ret.HeadHash
+
+	// 不知道密钥时，改完内容再按 sha256 重算哈希仍然校验失败
+	var l models.AuditLog
+	require.NoError(t, db.First(&l, 2).Error)
+	l.Actor = "someone"
+	models.SetAuditLogKey("")
+	l.Hash = l.ComputeHash()
+	models.SetAuditLogKey("secret")
+	require.NoError(t, db.Model(&models.AuditLog{}).Where("id = ?", 2).Updates(map[string]interface{}{"actor": l.Actor, "hash": l.Hash}).Error)
+
+	ret, err = models.AuditLogVerify(c, 0, 100, anchor)
+	require.NoError(t, err)
+	assert.Equal(t, []models.AuditLogBroken{
+		{Id: 2, Reason: "content does not match hash"},
+		{Id: 3, Reason: "prev_hash does not match record 2"},
+	}, ret.Broken)
+
+	// 删除最新的记录，链条本身不断，只能通过导出的 anchor 发现
+	require.NoError(t, db.Where("id = ?", 3).Delete(&models.AuditLog{}).Error)
+	ret, err = models.AuditLogVerify(c, 0, 100, anchor)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), ret.HeadId)
+	assert.Contains(t, ret.Broken, models.AuditLogBroken{Reason: "anchor hash not found, newest records may have been deleted"})
+}
@@ -122,7 +122,7 @@ func (b *Board) Add(ctx *ctx.Context) error {
 }
 
 func (b *Board) AtomicAdd(c *ctx.Context, payload string) error {
-	return DB(c).Transaction(func(tx *gorm.DB) error {
+	err := DB(c).Transaction(func(tx *gorm.DB) error {
 		tCtx := &ctx.Context{
 			DB: tx,
 		}
@@ -132,12 +132,20 @@ func (b *Board) AtomicAdd(c *ctx.Context, payload string) error {
 		}
 
 		if payload != "" {
-			if err := BoardPayloadSave(tCtx, b.Id, payload); err != nil {
+			if err := boardPayloadSave(tCtx, b.Id, payload); err != nil {
 				return err
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	if payload != "" {
+		ConfigRevisionRecord(c, RevisionBoard, b.Id)
+	}
+	return nil
 }
 
 func (b *Board) Update(ctx *ctx.Context, selectField interface{}, selectFields ...interface{}) error {
@@ -43,6 +43,15 @@ func BoardPayloadGet(ctx *ctx.Context, id int64) (string, error) {
 }
 
 func BoardPayloadSave(ctx *ctx.Context, id int64, payload string) error {
+	if err := boardPayloadSave(ctx, id, payload); err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionBoard, id)
+	return nil
+}
+
+func boardPayloadSave(ctx *ctx.Context, id int64, payload string) error {
 	var bp BoardPayload
 	err := DB(ctx).Where("id = ?", id).Find(&bp).Error
 	if err != nil {
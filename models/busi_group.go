@@ -16,6 +16,7 @@ type BusiGroup struct {
 	Name             string                  `json:"name"`
 	LabelEnable      int                     `json:"label_enable"`
 	LabelValue       string                  `json:"label_value"`
+	TenantId         int64                   `json:"tenant_id"`
 	CreateAt         int64                   `json:"create_at"`
 	CreateBy         string                  `json:"create_by"`
 	UpdateAt         int64                   `json:"update_at"`
@@ -282,7 +283,7 @@ func (bg *BusiGroup) Update(ctx *ctx.Context, name string, labelEnable int, labe
 	}).Error
 }
 
-func BusiGroupAdd(ctx *ctx.Context, name string, labelEnable int, labelValue string, members []BusiGroupMember, creator string) error {
+func BusiGroupAdd(ctx *ctx.Context, name string, labelEnable int, labelValue string, members []BusiGroupMember, creator string, tenantId int64) error {
 	exists, err := BusiGroupExists(ctx, "name=?", name)
 	if err != nil {
 		return errors.WithMessage(err, "failed to count BusiGroup")
@@ -315,6 +316,10 @@ func BusiGroupAdd(ctx *ctx.Context, name string, labelEnable int, labelValue str
 		if ug == nil {
 			return errors.New("Some UserGroup id not exists")
 		}
+
+		if ug.TenantId != tenantId {
+			return errors.New("cannot reference resources of another tenant")
+		}
 	}
 
 	now := time.Now().Unix()
@@ -326,6 +331,7 @@ func BusiGroupAdd(ctx *ctx.Context, name string, labelEnable int, labelValue str
 		CreateBy:    creator,
 		UpdateAt:    now,
 		UpdateBy:    creator,
+		TenantId:    tenantId,
 	}
 
 	return DB(ctx).Transaction(func(tx *gorm.DB) error {
@@ -0,0 +1,390 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"gorm.io/gorm"
+)
+
+const (
+	CmdbSourceHTTP = "http"
+	CmdbSourceCSV  = "csv"
+
+	CmdbMatchByIdent  = "ident"
+	CmdbMatchByHostIp = "host_ip"
+
+	CmdbGroupMatchByName       = "name"
+	CmdbGroupMatchById         = "id"
+	CmdbGroupMatchByLabelValue = "label_value"
+
+	// cmdb_wins: CMDB 的值覆盖机器上已有的值；manual_wins: 只补齐机器上缺失的值
+	CmdbConflictCmdbWins   = "cmdb_wins"
+	CmdbConflictManualWins = "manual_wins"
+
+	// 落库的同步报告最多保留的变更明细条数
+	CmdbSyncReportMaxChanges = 50
+)
+
+// CmdbSync CMDB 同步任务：从 HTTP 接口或上传的 CSV 中读取主机记录，
+// 按字段映射更新机器的标签、备注和业务组。字段路径支持 JSONPath（以 $ 开头，如 $.data.hosts[*].ip）
+// 和 gjson 语法（如 data.hosts.#.ip），CSV 的字段路径即列名
+type CmdbSync struct {
+	Id             int64             `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name           string            `json:"name" gorm:"type:varchar(255);not null;default:''"`
+	SourceType     string            `json:"source_type" gorm:"type:varchar(32);not null;default:''"`
+	Url            string            `json:"url" gorm:"type:varchar(1024);not null;default:''"`
+	Headers        map[string]string `json:"headers" gorm:"type:text;serializer:json"`
+	Timeout        int               `json:"timeout" gorm:"type:int;not null;default:0"`                 // 秒
+	ItemsPath      string            `json:"items_path" gorm:"type:varchar(255);not null;default:''"`    // 记录数组的路径，为空表示响应本身是数组
+	MatchBy        string            `json:"match_by" gorm:"type:varchar(32);not null;default:''"`       // ident 或 host_ip
+	IdentField     string            `json:"ident_field" gorm:"type:varchar(255);not null;default:''"`   // 记录中用于匹配机器的字段
+	NoteField      string            `json:"note_field" gorm:"type:varchar(255);not null;default:''"`    // 为空表示不同步备注
+	TagFields      map[string]string `json:"tag_fields" gorm:"type:text;serializer:json"`                // 标签名 -> 字段路径
+	GroupField     string            `json:"group_field" gorm:"type:varchar(255);not null;default:''"`   // 为空表示不同步业务组
+	GroupMatchBy   string            `json:"group_match_by" gorm:"type:varchar(32);not null;default:''"` // name、id 或 label_value
+	ConflictPolicy string            `json:"conflict_policy" gorm:"type:varchar(32);not null;default:''"`
+	Interval       int64             `json:"interval" gorm:"column:sync_interval;type:bigint;not null;default:0"` // 秒，0 表示只手动执行
+	Disabled       int               `json:"disabled" gorm:"type:int;not null;default:0"`
+	LastSyncAt     int64             `json:"last_sync_at" gorm:"type:bigint;not null;default:0"`
+	LastReport     *CmdbSyncReport   `json:"last_report" gorm:"type:text;serializer:json"`
+	CreateAt       int64             `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	CreateBy       string            `json:"create_by" gorm:"type:varchar(64);not null;default:''"`
+	UpdateAt       int64             `json:"update_at" gorm:"type:bigint;not null;default:0"`
+	UpdateBy       string            `json:"update_by" gorm:"type:varchar(64);not null;default:''"`
+}
+
+// CmdbSyncApplied 同步任务最近一次写到机器上的值。manual_wins 策略下机器的当前值与之相同，
+// 说明是 CMDB 写入的，CMDB 后续变化时直接更新；不同才是人工修改，算作冲突
+type CmdbSyncApplied struct {
+	Id       int64             `json:"id" gorm:"primaryKey;autoIncrement"`
+	SyncId   int64             `json:"sync_id" gorm:"type:bigint;not null;default:0;uniqueIndex:idx_cmdb_sync_applied,priority:1"`
+	Ident    string            `json:"ident" gorm:"type:varchar(191);not null;default:'';uniqueIndex:idx_cmdb_sync_applied,priority:2"`
+	Tags     map[string]string `json:"tags" gorm:"type:text;serializer:json"`             // 标签名 -> 值
+	Note     string            `json:"note" gorm:"type:varchar(255);not null;default:''"` // 为空表示没有写过备注
+	GroupIds []int64           `json:"group_ids" gorm:"type:text;serializer:json"`        // 为空表示没有写过业务组
+	UpdateAt int64             `json:"update_at" gorm:"type:bigint;not null;default:0"`
+}
+
+func (a *CmdbSyncApplied) TableName() string {
+	return "cmdb_sync_applied"
+}
+
+// Same 与之前记录的值相同时不需要重新保存
+func (a *CmdbSyncApplied) Same(b *CmdbSyncApplied) bool {
+	if b == nil || a.Note != b.Note || joinInt64s(a.GroupIds) != joinInt64s(b.GroupIds) || len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	for k, v := range a.Tags {
+		if bv, has := b.Tags[k]; !has || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// CmdbSyncAppliedGets 同步任务写过的值，key: ident
+func CmdbSyncAppliedGets(ctx *ctx.Context, syncId int64) (map[string]*CmdbSyncApplied, error) {
+	var lst []*CmdbSyncApplied
+	err := DB(ctx).Where("sync_id = ?", syncId).Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]*CmdbSyncApplied, len(lst))
+	for _, a := range lst {
+		ret[a.Ident] = a
+	}
+	return ret, nil
+}
+
+// CmdbSyncAppliedSave 覆盖保存这些机器上同步任务写过的值
+func CmdbSyncAppliedSave(ctx *ctx.Context, syncId int64, lst []*CmdbSyncApplied) error {
+	if len(lst) == 0 {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	idents := make([]string, 0, len(lst))
+	for _, a := range lst {
+		a.Id = 0
+		a.SyncId = syncId
+		a.UpdateAt = now
+		idents = append(idents, a.Ident)
+	}
+
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < len(idents); i += 500 {
+			end := i + 500
+			if end > len(idents) {
+				end = len(idents)
+			}
+			if err := tx.Where("sync_id = ? and ident in ?", syncId, idents[i:end]).Delete(&CmdbSyncApplied{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.CreateInBatches(lst, 100).Error
+	})
+}
+
+// CmdbSyncReport 一次同步（或演练）的结果
+type CmdbSyncReport struct {
+	DryRun    bool                `json:"dry_run"`
+	StartAt   int64               `json:"start_at"`
+	Duration  int64               `json:"duration"` // 毫秒
+	Records   int                 `json:"records"`  // CMDB 记录数
+	Matched   int                 `json:"matched"`  // 匹配到机器的记录数
+	Changed   int                 `json:"changed"`  // 有变更的机器数
+	Conflicts int                 `json:"conflicts"`
+	Unmatched []string            `json:"unmatched"` // 未匹配到机器的记录
+	Changes   []*CmdbTargetChange `json:"changes"`
+	Errors    []string            `json:"errors"`
+
+	Applied []*CmdbSyncApplied `json:"-"` // 写入值有变化的机器，同步完成后保存
+}
+
+// CmdbTargetChange 单台机器的变更，Conflicts 为 manual_wins 策略下保留机器原值的字段
+type CmdbTargetChange struct {
+	Ident       string   `json:"ident"`
+	OldTags     []string `json:"old_tags"`
+	NewTags     []string `json:"new_tags"`
+	OldNote     string   `json:"old_note"`
+	NewNote     string   `json:"new_note"`
+	OldGroupIds []int64  `json:"old_group_ids"`
+	NewGroupIds []int64  `json:"new_group_ids"`
+	Conflicts   []string `json:"conflicts"`
+}
+
+func (c *CmdbTargetChange) TagsChanged() bool {
+	return strings.Join(c.OldTags, " ") != strings.Join(c.NewTags, " ")
+}
+
+func (c *CmdbTargetChange) NoteChanged() bool {
+	return c.OldNote != c.NewNote
+}
+
+func (c *CmdbTargetChange) GroupsChanged() bool {
+	return joinInt64s(c.OldGroupIds) != joinInt64s(c.NewGroupIds)
+}
+
+func (c *CmdbTargetChange) Changed() bool {
+	return c.TagsChanged() || c.NoteChanged() || c.GroupsChanged()
+}
+
+func (s *CmdbSync) TableName() string {
+	return "cmdb_sync"
+}
+
+func (s *CmdbSync) Verify() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return errors.New("name is blank")
+	}
+
+	switch s.SourceType {
+	case CmdbSourceHTTP:
+		u, err := url.Parse(s.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid url: %s", s.Url)
+		}
+	case CmdbSourceCSV:
+		s.Url = ""
+		s.Interval = 0 // CSV 只能上传后手动执行
+	default:
+		return errors.Errorf("invalid source_type: %s", s.SourceType)
+	}
+
+	if s.MatchBy == "" {
+		s.MatchBy = CmdbMatchByIdent
+	}
+	if s.MatchBy != CmdbMatchByIdent && s.MatchBy != CmdbMatchByHostIp {
+		return errors.Errorf("invalid match_by: %s", s.MatchBy)
+	}
+
+	if s.IdentField == "" {
+		return errors.New("ident_field is blank")
+	}
+
+	for key := range s.TagFields {
+		if !model.LabelNameRE.MatchString(key) {
+			return errors.Errorf("invalid tag key: %s", key)
+		}
+	}
+
+	if s.NoteField == "" && s.GroupField == "" && len(s.TagFields) == 0 {
+		return errors.New("at least one of note_field, group_field and tag_fields is required")
+	}
+
+	if s.GroupMatchBy == "" {
+		s.GroupMatchBy = CmdbGroupMatchByName
+	}
+	switch s.GroupMatchBy {
+	case CmdbGroupMatchByName, CmdbGroupMatchById, CmdbGroupMatchByLabelValue:
+	default:
+		return errors.Errorf("invalid group_match_by: %s", s.GroupMatchBy)
+	}
+
+	if s.ConflictPolicy == "" {
+		s.ConflictPolicy = CmdbConflictManualWins
+	}
+	if s.ConflictPolicy != CmdbConflictCmdbWins && s.ConflictPolicy != CmdbConflictManualWins {
+		return errors.Errorf("invalid conflict_policy: %s", s.ConflictPolicy)
+	}
+
+	if s.Interval < 0 {
+		return errors.New("interval should not be negative")
+	}
+	if s.Interval > 0 && s.Interval < 60 {
+		return errors.New("interval should be at least 60 seconds")
+	}
+
+	if s.Timeout <= 0 {
+		s.Timeout = 10
+	}
+
+	return nil
+}
+
+func (s *CmdbSync) Add(ctx *ctx.Context) error {
+	if err := s.Verify(); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	s.CreateAt = now
+	s.UpdateAt = now
+	s.LastSyncAt = 0
+	s.LastReport = nil
+
+	return Insert(ctx, s)
+}
+
+func (s *CmdbSync) Update(ctx *ctx.Context, ref CmdbSync) error {
+	if err := ref.Verify(); err != nil {
+		return err
+	}
+
+	ref.Id = s.Id
+	ref.CreateAt = s.CreateAt
+	ref.CreateBy = s.CreateBy
+	ref.LastSyncAt = s.LastSyncAt
+	ref.LastReport = s.LastReport
+	ref.UpdateAt = time.Now().Unix()
+
+	return DB(ctx).Model(s).Select("*").Updates(&ref).Error
+}
+
+// SaveReport 记录最近一次同步结果，明细过多时只保留前若干条
+func (s *CmdbSync) SaveReport(ctx *ctx.Context, report *CmdbSyncReport) error {
+	saved := *report
+	if len(saved.Changes) > CmdbSyncReportMaxChanges {
+		saved.Changes = saved.Changes[:CmdbSyncReportMaxChanges]
+	}
+	if len(saved.Unmatched) > CmdbSyncReportMaxChanges {
+		saved.Unmatched = saved.Unmatched[:CmdbSyncReportMaxChanges]
+	}
+
+	s.LastSyncAt = report.StartAt
+	s.LastReport = &saved
+	return DB(ctx).Model(s).Select("last_sync_at", "last_report").Updates(s).Error
+}
+
+func CmdbSyncDels(ctx *ctx.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("sync_id in ?", ids).Delete(&CmdbSyncApplied{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id in ?", ids).Delete(&CmdbSync{}).Error
+	})
+}
+
+func CmdbSyncGets(ctx *ctx.Context) ([]*CmdbSync, error) {
+	var lst []*CmdbSync
+	err := DB(ctx).Order("id").Find(&lst).Error
+	return lst, err
+}
+
+func CmdbSyncGetById(ctx *ctx.Context, id int64) (*CmdbSync, error) {
+	var lst []*CmdbSync
+	err := DB(ctx).Where("id = ?", id).Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lst) == 0 {
+		return nil, nil
+	}
+
+	return lst[0], nil
+}
+
+// CmdbSyncDueGets 到期需要定时执行的 HTTP 同步任务
+func CmdbSyncDueGets(ctx *ctx.Context, now int64) ([]*CmdbSync, error) {
+	var lst []*CmdbSync
+	err := DB(ctx).Where("disabled = ? and source_type = ? and sync_interval > 0", 0, CmdbSourceHTTP).Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]*CmdbSync, 0, len(lst))
+	for _, s := range lst {
+		if now-s.LastSyncAt >= s.Interval {
+			due = append(due, s)
+		}
+	}
+	return due, nil
+}
+
+// History 变更对应的机器变更历史
+func (c *CmdbTargetChange) History(operator string) []*TargetHistory {
+	var lst []*TargetHistory
+	if c.TagsChanged() {
+		lst = append(lst, NewTargetHistory(c.Ident, TargetHistoryFieldTags,
+			strings.Join(c.OldTags, " "), strings.Join(c.NewTags, " "), operator))
+	}
+	if c.NoteChanged() {
+		lst = append(lst, NewTargetHistory(c.Ident, TargetHistoryFieldNote, c.OldNote, c.NewNote, operator))
+	}
+	if c.GroupsChanged() {
+		lst = append(lst, NewTargetHistory(c.Ident, TargetHistoryFieldBusiGroup,
+			joinInt64s(c.OldGroupIds), joinInt64s(c.NewGroupIds), operator))
+	}
+	return lst
+}
+
+// Apply 把变更写入机器表和机器业务组关联表
+func (c *CmdbTargetChange) Apply(ctx *ctx.Context) error {
+	if c.TagsChanged() || c.NoteChanged() {
+		tags := ""
+		if len(c.NewTags) > 0 {
+			tags = strings.Join(c.NewTags, " ") + " "
+		}
+
+		err := DB(ctx).Model(&Target{}).Where("ident = ?", c.Ident).Updates(map[string]interface{}{
+			"tags":      tags,
+			"note":      c.NewNote,
+			"update_at": time.Now().Unix(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.GroupsChanged() {
+		if len(c.NewGroupIds) == 0 {
+			return TargetUnbindBgids(ctx, []string{c.Ident}, c.OldGroupIds)
+		}
+		return TargetOverrideBgids(ctx, []string{c.Ident}, c.NewGroupIds, nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/pkg/errors"
+	"github.com/toolkits/pkg/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	RevisionAlertRule       = "alert_rule"
+	RevisionNotifyRule      = "notify_rule"
+	RevisionEventPipeline   = "event_pipeline"
+	RevisionMessageTemplate = "message_template"
+	RevisionBoard           = "board"
+)
+
+// ConfigRevision 配置的历史版本，只增不改。每次保存后记录完整内容，回滚时用旧版本的内容
+// 覆盖当前配置并生成一个新版本，不会删除回滚点之后的版本
+type ConfigRevision struct {
+	Id           int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	ResourceType string `json:"resource_type" gorm:"type:varchar(64);not null;default:'';uniqueIndex:idx_config_revision_version,priority:1"`
+	ResourceId   int64  `json:"resource_id" gorm:"type:bigint;not null;default:0;uniqueIndex:idx_config_revision_version,priority:2"`
+	Version      int    `json:"version" gorm:"type:int;not null;default:0;uniqueIndex:idx_config_revision_version,priority:3"` // 同一对象内从 1 开始递增
+	Content      string `json:"content,omitempty" gorm:"type:mediumtext"`                                                      // json，仪表盘为 board_payload 原文
+	RestoredFrom int    `json:"restored_from" gorm:"type:int;not null;default:0"`                                              // 由哪个版本回滚而来，0 表示正常保存
+	CreateAt     int64  `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	CreateBy     string `json:"create_by" gorm:"type:varchar(64);not null;default:''"`
+}
+
+func (r *ConfigRevision) TableName() string {
+	return "config_revision"
+}
+
+// PostgresConfigRevision is the PostgreSQL-compatible variant of ConfigRevision.
+// PostgreSQL does not support mediumtext; its text type is unlimited.
+type PostgresConfigRevision struct {
+	Id           int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	ResourceType string `json:"resource_type" gorm:"type:varchar(64);not null;default:'';uniqueIndex:idx_config_revision_version,priority:1"`
+	ResourceId   int64  `json:"resource_id" gorm:"type:bigint;not null;default:0;uniqueIndex:idx_config_revision_version,priority:2"`
+	Version      int    `json:"version" gorm:"type:int;not null;default:0;uniqueIndex:idx_config_revision_version,priority:3"`
+	Content      string `json:"content,omitempty" gorm:"type:text"`
+	RestoredFrom int    `json:"restored_from" gorm:"type:int;not null;default:0"`
+	CreateAt     int64  `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	CreateBy     string `json:"create_by" gorm:"type:varchar(64);not null;default:''"`
+}
+
+func (r *PostgresConfigRevision) TableName() string {
+	return "config_revision"
+}
+
+type revisionResource struct {
+	// load 返回当前内容和最后修改人，对象不存在时 content 为空
+	load    func(ctx *ctx.Context, id int64) (content, operator string, err error)
+	restore func(ctx *ctx.Context, id int64, content, operator string) error
+}
+
+var revisionResources = map[string]revisionResource{
+	RevisionAlertRule: {
+		load: func(c *ctx.Context, id int64) (string, string, error) {
+			ar, err := AlertRuleGetById(c, id)
+			if err != nil || ar == nil {
+				return "", "", err
+			}
+			return revisionContent(ar, ar.UpdateBy)
+		},
+		restore: func(c *ctx.Context, id int64, content, operator string) error {
+			ar, err := AlertRuleGetById(c, id)
+			if err != nil {
+				return err
+			}
+			if ar == nil {
+				return errors.New("alert rule not found")
+			}
+			var arf AlertRule
+			if err := json.Unmarshal([]byte(content), &arf); err != nil {
+				return err
+			}
+			arf.UpdateBy = operator
+			return ar.update(c, arf)
+		},
+	},
+	RevisionNotifyRule: {
+		load: func(c *ctx.Context, id int64) (string, string, error) {
+			var lst []*NotifyRule
+			if err := DB(c).Where("id = ?", id).Find(&lst).Error; err != nil || len(lst) == 0 {
+				return "", "", err
+			}
+			return revisionContent(lst[0], lst[0].UpdateBy)
+		},
+		restore: func(c *ctx.Context, id int64, content, operator string) error {
+			r, err := GetNotifyRule(c, id)
+			if err != nil {
+				return err
+			}
+			var ref NotifyRule
+			if err := json.Unmarshal([]byte(content), &ref); err != nil {
+				return err
+			}
+			ref.UpdateBy = operator
+			return r.update(c, ref)
+		},
+	},
+	RevisionEventPipeline: {
+		load: func(c *ctx.Context, id int64) (string, string, error) {
+			var lst []*EventPipeline
+			if err := DB(c).Where("id = ?", id).Find(&lst).Error; err != nil || len(lst) == 0 {
+				return "", "", err
+			}
+			return revisionContent(lst[0], lst[0].UpdateBy)
+		},
+		restore: func(c *ctx.Context, id int64, content, operator string) error {
+			e, err := GetEventPipeline(c, id)
+			if err != nil {
+				return err
+			}
+			var ref EventPipeline
+			if err := json.Unmarshal([]byte(content), &ref); err != nil {
+				return err
+			}
+			ref.UpdateBy = operator
+			return e.update(c, &ref)
+		},
+	},
+	RevisionMessageTemplate: {
+		load: func(c *ctx.Context, id int64) (string, string, error) {
+			t, err := MessageTemplateGet(c, "id = ?", id)
+			if err != nil || t == nil {
+				return "", "", err
+			}
+			return revisionContent(t, t.UpdateBy)
+		},
+		restore: func(c *ctx.Context, id int64, content, operator string) error {
+			t, err := MessageTemplateGet(c, "id = ?", id)
+			if err != nil {
+				return err
+			}
+			if t == nil {
+				return errors.New("message template not found")
+			}
+			var ref MessageTemplate
+			if err := json.Unmarshal([]byte(content), &ref); err != nil {
+				return err
+			}
+			ref.UpdateBy = operator
+			return t.update(c, ref)
+		},
+	},
+	RevisionBoard: {
+		load: func(c *ctx.Context, id int64) (string, string, error) {
+			b, err := BoardGetByID(c, id)
+			if err != nil || b == nil {
+				return "", "", err
+			}
+			payload, err := BoardPayloadGet(c, id)
+			return payload, b.UpdateBy, err
+		},
+		restore: func(c *ctx.Context, id int64, content, operator string) error {
+			b, err := BoardGetByID(c, id)
+			if err != nil {
+				return err
+			}
+			if b == nil {
+				return errors.New("dashboard not found")
+			}
+			b.UpdateBy = operator
+			b.UpdateAt = time.Now().Unix()
+			if err := b.Update(c, "update_by", "update_at"); err != nil {
+				return err
+			}
+			return boardPayloadSave(c, id, content)
+		},
+	},
+}
+
+// 每次保存都会变化或者是展示用的字段，不纳入版本内容
+var revisionVolatileKeys = []string{"update_at", "update_by", "update_by_nickname", "cur_event_count",
+	"notify_groups_obj", "team_names"}
+
+func revisionContent(obj interface{}, operator string) (string, string, error) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		return "", "", err
+	}
+
+	var m map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber()
+	if err := decoder.Decode(&m); err != nil {
+		return "", "", err
+	}
+
+	for _, k := range revisionVolatileKeys {
+		delete(m, k)
+	}
+
+	// 不转义 <、>、&，便于直接查看 promql 等内容
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(m); err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), operator, nil
+}
+
+func IsRevisionResource(typ string) bool {
+	_, has := revisionResources[typ]
+	return has
+}
+
+// ConfigRevisionRecord 在保存配置后调用，内容与最新版本相同时不产生新版本。
+// 记录失败只打日志，不影响配置本身的保存
+func ConfigRevisionRecord(ctx *ctx.Context, typ string, id int64) {
+	if !ctx.IsCenter {
+		return
+	}
+
+	if _, err := configRevisionAdd(ctx, typ, id, 0); err != nil {
+		logger.Errorf("failed to record revision of %s %d: %v", typ, id, err)
+	}
+}
+
+// 版本号冲突时的最大重试次数
+const configRevisionRetries = 3
+
+func configRevisionAdd(ctx *ctx.Context, typ string, id int64, restoredFrom int) (*ConfigRevision, error) {
+	res, has := revisionResources[typ]
+	if !has {
+		return nil, fmt.Errorf("unsupported resource type: %s", typ)
+	}
+
+	content, operator, err := res.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if content == "" {
+		return nil, nil
+	}
+
+	// 并发保存可能算出相同的版本号，由唯一索引拦下，确认版本号已被占用后重新读取最新版本再试
+	for i := 0; ; i++ {
+		last, err := ConfigRevisionLatest(ctx, typ, id)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &ConfigRevision{
+			ResourceType: typ,
+			ResourceId:   id,
+			Version:      1,
+			Content:      content,
+			RestoredFrom: restoredFrom,
+			CreateAt:     time.Now().Unix(),
+			CreateBy:     operator,
+		}
+
+		if last != nil {
+			if last.Content == content && restoredFrom == 0 {
+				return last, nil
+			}
+			r.Version = last.Version + 1
+		}
+
+		// 在事务中执行时用 savepoint 隔开，插入失败不影响外层事务继续重试
+		err = DB(ctx).Transaction(func(tx *gorm.DB) error {
+			return tx.Create(r).Error
+		})
+		if err == nil {
+			return r, nil
+		}
+
+		if i >= configRevisionRetries {
+			return nil, err
+		}
+		latest, lerr := ConfigRevisionLatest(ctx, typ, id)
+		if lerr != nil || latest == nil || latest.Version < r.Version {
+			return nil, err
+		}
+	}
+}
+
+func ConfigRevisionLatest(ctx *ctx.Context, typ string, id int64) (*ConfigRevision, error) {
+	var lst []*ConfigRevision
+	err := DB(ctx).Where("resource_type = ? and resource_id = ?", typ, id).Order("version desc").Limit(1).Find(&lst).Error
+	if err != nil || len(lst) == 0 {
+		return nil, err
+	}
+	return lst[0], nil
+}
+
+// ConfigRevisionGets 列表不返回内容，内容可能很大
+func ConfigRevisionGets(ctx *ctx.Context, typ string, id int64) ([]*ConfigRevision, error) {
+	lst := make([]*ConfigRevision, 0)
+	err := DB(ctx).Omit("content").Where("resource_type = ? and resource_id = ?", typ, id).
+		Order("version desc").Find(&lst).Error
+	return lst, err
+}
+
+func ConfigRevisionGetById(ctx *ctx.Context, id int64) (*ConfigRevision, error) {
+	var lst []*ConfigRevision
+	err := DB(ctx).Where("id = ?", id).Find(&lst).Error
+	if err != nil || len(lst) == 0 {
+		return nil, err
+	}
+	return lst[0], nil
+}
+
+// ConfigRevisionRestore 用 r 的内容覆盖当前配置，并记录一个 restored_from 为 r.Version 的新版本
+func ConfigRevisionRestore(c *ctx.Context, r *ConfigRevision, operator string) (*ConfigRevision, error) {
+	res, has := revisionResources[r.ResourceType]
+	if !has {
+		return nil, fmt.Errorf("unsupported resource type: %s", r.ResourceType)
+	}
+
+	var ret *ConfigRevision
+	err := DB(c).Transaction(func(tx *gorm.DB) error {
+		tCtx := &ctx.Context{DB: tx, CenterApi: c.CenterApi, Ctx: c.Ctx, IsCenter: c.IsCenter}
+
+		if err := res.restore(tCtx, r.ResourceId, r.Content, operator); err != nil {
+			return err
+		}
+
+		var err error
+		ret, err = configRevisionAdd(tCtx, r.ResourceType, r.ResourceId, r.Version)
+		return err
+	})
+
+	return ret, err
+}
@@ -0,0 +1,153 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newRevisionCtx(t *testing.T) *ctx.Context {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.ConfigRevision{}, &models.AlertRule{}, &models.NotifyRule{},
+		&models.Board{}, &models.BoardPayload{}))
+	return &ctx.Context{DB: db, IsCenter: true}
+}
+
+func TestConfigRevisionAlertRule(t *testing.T) {
+	c := newRevisionCtx(t)
+
+	ar := &models.AlertRule{
+		GroupId:        1,
+		Name:           "cpu high",
+		Severities:     []int{2},
+		RuleConfigJson: map[string]interface{}{"queries": []map[string]interface{}{{"prom_ql": "cpu_usage_active", "severity": 2}}},
+		UpdateBy:       "alice",
+	}
+	require.NoError(t, ar.FE2DB())
+	require.NoError(t, ar.Add(c))
+
+	old, err := models.AlertRuleGetById(c, ar.Id)
+	require.NoError(t, err)
+	arf := *old
+	arf.RuleConfigJson = map[string]interface{}{"queries": []map[string]interface{}{{"prom_ql": "cpu_usage_idle", "severity": 2}}}
+	arf.UpdateBy = "bob"
+	require.NoError(t, old.Update(c, arf))
+
+	// 内容没有变化时不产生新版本
+	cur, err := models.AlertRuleGetById(c, ar.Id)
+	require.NoError(t, err)
+	require.NoError(t, cur.Update(c, *cur))
+
+	lst, err := models.ConfigRevisionGets(c, models.RevisionAlertRule, ar.Id)
+	require.NoError(t, err)
+	require.Len(t, lst, 2)
+	assert.Equal(t, 2, lst[0].Version)
+	assert.Equal(t, "bob", lst[0].CreateBy)
+	assert.Empty(t, lst[0].Content)
+	assert.Equal(t, "alice", lst[1].CreateBy)
+
+	v1, err := models.ConfigRevisionGetById(c, lst[1].Id)
+	require.NoError(t, err)
+	assert.Contains(t, v1.Content, "cpu_usage_active")
+
+	restored, err := models.ConfigRevisionRestore(c, v1, "carol")
+	require.NoError(t, err)
+	assert.Equal(t, 3, restored.Version)
+	assert.Equal(t, 1, restored.RestoredFrom)
+	assert.Equal(t, "carol", restored.CreateBy)
+	assert.Equal(t, v1.Content, restored.Content)
+
+	cur, err = models.AlertRuleGetById(c, ar.Id)
+	require.NoError(t, err)
+	assert.Contains(t, cur.RuleConfig, "cpu_usage_active")
+	assert.Equal(t, "carol", cur.UpdateBy)
+	assert.Equal(t, int64(1), cur.GroupId)
+}
+
+func TestConfigRevisionNotifyRuleAndBoard(t *testing.T) {
+	c := newRevisionCtx(t)
+
+	nr := &models.NotifyRule{Name: "ops", UserGroupIds: []int64{1}, UpdateBy: "alice"}
+	require.NoError(t, models.CreateNotifyRule(c, nr))
+
+	ref := *nr
+	ref.UserGroupIds = []int64{1, 2}
+	ref.UpdateBy = "bob"
+	require.NoError(t, nr.Update(c, ref))
+
+	latest, err := models.ConfigRevisionLatest(c, models.RevisionNotifyRule, nr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, latest.Version)
+	assert.Contains(t, latest.Content, `"user_group_ids":[1,2]`)
+	assert.NotContains(t, latest.Content, "update_at")
+
+	b := &models.Board{GroupId: 1, Name: "overview", UpdateBy: "alice"}
+	require.NoError(t, b.AtomicAdd(c, `{"panels":[]}`))
+	require.NoError(t, models.BoardPayloadSave(c, b.Id, `{"panels":[{"id":"a"}]}`))
+
+	lst, err := models.ConfigRevisionGets(c, models.RevisionBoard, b.Id)
+	require.NoError(t, err)
+	require.Len(t, lst, 2)
+
+	v1, err := models.ConfigRevisionGetById(c, lst[1].Id)
+	require.NoError(t, err)
+	assert.Equal(t, `{"panels":[]}`, v1.Content)
+
+	_, err = models.ConfigRevisionRestore(c, v1, "bob")
+	require.NoError(t, err)
+	payload, err := models.BoardPayloadGet(c, b.Id)
+	require.NoError(t, err)
+	assert.Equal(t, `{"panels":[]}`, payload)
+
+	// 回滚到与当前相同的内容也会留下记录
+	_, err = models.ConfigRevisionRestore(c, v1, "bob")
+	require.NoError(t, err)
+	lst, err = models.ConfigRevisionGets(c, models.RevisionBoard, b.Id)
+	require.NoError(t, err)
+	assert.Len(t, lst, 4)
+}
+
+func TestConfigRevisionVersionConflict(t *testing.T) {
+	// 抢先写入的版本要从另一个连接提交，需要共享缓存的内存库
+	db, err := gorm.Open(sqlite.Open("file:revision_conflict?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.ConfigRevision{}, &models.Board{}, &models.BoardPayload{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	b := &models.Board{GroupId: 1, Name: "overview", UpdateBy: "alice"}
+	require.NoError(t, b.AtomicAdd(c, `{"panels":[]}`))
+
+	// 模拟并发保存：下一次写入版本前，另一个请求抢先写入了同一个版本号
+	raced := false
+	require.NoError(t, c.DB.Callback().Create().Before("gorm:create").Register("test:race", func(tx *gorm.DB) {
+		if raced || tx.Statement.Table != "config_revision" {
+			return
+		}
+		raced = true
+		db.Exec("insert into config_revision (resource_type, resource_id, version, content) values (?, ?, ?, ?)",
+			models.RevisionBoard, b.Id, 2, `{"panels":[{"id":"other"}]}`)
+	}))
+
+	require.NoError(t, models.BoardPayloadSave(c, b.Id, `{"panels":[{"id":"a"}]}`))
+	require.True(t, raced)
+
+	lst, err := models.ConfigRevisionGets(c, models.RevisionBoard, b.Id)
+	require.NoError(t, err)
+	require.Len(t, lst, 3)
+	assert.Equal(t, 3, lst[0].Version)
+
+	latest, err := models.ConfigRevisionLatest(c, models.RevisionBoard, b.Id)
+	require.NoError(t, err)
+	assert.Equal(t, `{"panels":[{"id":"a"}]}`, latest.Content)
+
+	// 唯一索引拒绝重复的版本号
+	assert.Error(t, c.DB.Create(&models.ConfigRevision{ResourceType: models.RevisionBoard, ResourceId: b.Id, Version: 3}).Error)
+}
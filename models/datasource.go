@@ -46,6 +46,7 @@ type Datasource struct {
 	UpdatedBy       string                 `json:"updated_by"`
 	IsDefault       bool                   `json:"is_default"`
 	Weight          int                    `json:"weight"`
+	TenantId        int64                  `json:"tenant_id"`
 	Transport       *http.Transport        `json:"-" gorm:"-"`
 	ForceSave       bool                   `json:"force_save" gorm:"-"`
 }
@@ -255,7 +256,10 @@ func DatasourceDel(ctx *ctx.Context, ids []int64) error {
 	if len(ids) == 0 {
 		return nil
 	}
-	return DB(ctx).Where("id in ?", ids).Delete(new(Datasource)).Error
+	if err := DB(ctx).Where("id in ?", ids).Delete(new(Datasource)).Error; err != nil {
+		return err
+	}
+	return DatasourceAclDelByDatasourceIds(ctx, ids)
 }
 
 func DatasourceGet(ctx *ctx.Context, id int64) (*Datasource, error) {
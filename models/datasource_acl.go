@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DsAclSubjectUserGroup = "user_group"
+	DsAclSubjectBusiGroup = "busi_group"
+
+	DsAclPermQuery = "query"
+	DsAclPermAdmin = "admin"
+)
+
+// DatasourceAcl 数据源级别的授权。数据源没有配置任何授权时对所有人开放查询；
+// 配置之后只有管理员和被授权的团队、业务组成员可以查询。admin 包含 query，另外可以修改数据源配置和授权
+type DatasourceAcl struct {
+	Id           int64  `json:"id" gorm:"primaryKey"`
+	DatasourceId int64  `json:"datasource_id" gorm:"type:bigint;not null;default:0;index"`
+	SubjectType  string `json:"subject_type" gorm:"type:varchar(32);not null;default:''"` // user_group 或 busi_group
+	SubjectId    int64  `json:"subject_id" gorm:"type:bigint;not null;default:0"`
+	Perm         string `json:"perm" gorm:"type:varchar(32);not null;default:''"` // query 或 admin
+	CreateAt     int64  `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	CreateBy     string `json:"create_by" gorm:"type:varchar(64);not null;default:''"`
+	UpdateAt     int64  `json:"update_at" gorm:"type:bigint;not null;default:0"`
+}
+
+func (a *DatasourceAcl) TableName() string {
+	return "datasource_acl"
+}
+
+func (a *DatasourceAcl) Verify() error {
+	if a.SubjectType != DsAclSubjectUserGroup && a.SubjectType != DsAclSubjectBusiGroup {
+		return fmt.Errorf("invalid subject_type: %s", a.SubjectType)
+	}
+
+	if a.SubjectId <= 0 {
+		return fmt.Errorf("subject_id is required")
+	}
+
+	if a.Perm != DsAclPermQuery && a.Perm != DsAclPermAdmin {
+		return fmt.Errorf("invalid perm: %s", a.Perm)
+	}
+
+	return nil
+}
+
+func DatasourceAclGets(ctx *ctx.Context, datasourceId int64) ([]*DatasourceAcl, error) {
+	var lst []*DatasourceAcl
+	err := DB(ctx).Where("datasource_id = ?", datasourceId).Order("id").Find(&lst).Error
+	return lst, err
+}
+
+// DatasourceAclGetMap 返回全部授权，key 是数据源 id
+func DatasourceAclGetMap(ctx *ctx.Context) (map[int64][]*DatasourceAcl, error) {
+	var lst []*DatasourceAcl
+	err := DB(ctx).Order("id").Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int64][]*DatasourceAcl)
+	for _, a := range lst {
+		m[a.DatasourceId] = append(m[a.DatasourceId], a)
+	}
+	return m, nil
+}
+
+func DatasourceAclStatistics(ctx *ctx.Context) (*Statistics, error) {
+	return StatisticsGet(ctx, &DatasourceAcl{})
+}
+
+// DatasourceAclReplace 用 acls 整体替换数据源的授权，acls 为空表示取消授权限制
+func DatasourceAclReplace(ctx *ctx.Context, datasourceId int64, acls []*DatasourceAcl, username string) error {
+	for _, a := range acls {
+		if err := a.Verify(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().Unix()
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("datasource_id = ?", datasourceId).Delete(&DatasourceAcl{}).Error; err != nil {
+			return err
+		}
+
+		for _, a := range acls {
+			a.Id = 0
+			a.DatasourceId = datasourceId
+			a.CreateAt = now
+			a.CreateBy = username
+			a.UpdateAt = now
+			if err := tx.Create(a).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func DatasourceAclDelByDatasourceIds(ctx *ctx.Context, datasourceIds []int64) error {
+	if len(datasourceIds) == 0 {
+		return nil
+	}
+	return DB(ctx).Where("datasource_id in ?", datasourceIds).Delete(&DatasourceAcl{}).Error
+}
+
+// DatasourceAclAllowed 判断团队 userGroupIds、业务组 busiGroupIds 的成员是否有 perm 权限。acls 为空时不做限制
+func DatasourceAclAllowed(acls []*DatasourceAcl, userGroupIds, busiGroupIds []int64, perm string) bool {
+	if len(acls) == 0 {
+		return perm == DsAclPermQuery
+	}
+
+	for _, a := range acls {
+		if perm == DsAclPermAdmin && a.Perm != DsAclPermAdmin {
+			continue
+		}
+
+		switch a.SubjectType {
+		case DsAclSubjectUserGroup:
+			if slices.Contains(userGroupIds, a.SubjectId) {
+				return true
+			}
+		case DsAclSubjectBusiGroup:
+			if slices.Contains(busiGroupIds, a.SubjectId) {
+				return true
+			}
+		}
+	}
+	return false
+}
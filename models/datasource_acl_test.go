@@ -0,0 +1,74 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDatasourceAclAllowed(t *testing.T) {
+	// 没有授权时所有人都能查询，但只有管理员能管理
+	assert.True(t, models.DatasourceAclAllowed(nil, nil, nil, models.DsAclPermQuery))
+	assert.False(t, models.DatasourceAclAllowed(nil, nil, nil, models.DsAclPermAdmin))
+
+	acls := []*models.DatasourceAcl{
+		{SubjectType: models.DsAclSubjectUserGroup, SubjectId: 1, Perm: models.DsAclPermQuery},
+		{SubjectType: models.DsAclSubjectBusiGroup, SubjectId: 10, Perm: models.DsAclPermAdmin},
+	}
+
+	assert.True(t, models.DatasourceAclAllowed(acls, []int64{1}, nil, models.DsAclPermQuery))
+	assert.False(t, models.DatasourceAclAllowed(acls, []int64{1}, nil, models.DsAclPermAdmin))
+	assert.True(t, models.DatasourceAclAllowed(acls, nil, []int64{10}, models.DsAclPermQuery))
+	assert.True(t, models.DatasourceAclAllowed(acls, nil, []int64{10}, models.DsAclPermAdmin))
+	assert.False(t, models.DatasourceAclAllowed(acls, []int64{10}, []int64{1}, models.DsAclPermQuery))
+}
+
+func TestDatasourceAclReplace(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.DatasourceAcl{}, &models.Datasource{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	err = models.DatasourceAclReplace(c, 1, []*models.DatasourceAcl{{SubjectType: "user", SubjectId: 1, Perm: models.DsAclPermQuery}}, "root")
+	assert.Error(t, err)
+	err = models.DatasourceAclReplace(c, 1, []*models.DatasourceAcl{{SubjectType: models.DsAclSubjectUserGroup, SubjectId: 1, Perm: "write"}}, "root")
+	assert.Error(t, err)
+
+	require.NoError(t, models.DatasourceAclReplace(c, 1, []*models.DatasourceAcl{
+		{SubjectType: models.DsAclSubjectUserGroup, SubjectId: 1, Perm: models.DsAclPermQuery},
+		{SubjectType: models.DsAclSubjectBusiGroup, SubjectId: 2, Perm: models.DsAclPermAdmin},
+	}, "root"))
+	require.NoError(t, models.DatasourceAclReplace(c, 2, []*models.DatasourceAcl{
+		{SubjectType: models.DsAclSubjectUserGroup, SubjectId: 3, Perm: models.DsAclPermQuery},
+	}, "root"))
+
+	// 整体替换，旧的授权不保留
+	require.NoError(t, models.DatasourceAclReplace(c, 1, []*models.DatasourceAcl{
+		{SubjectType: models.DsAclSubjectUserGroup, SubjectId: 4, Perm: models.DsAclPermAdmin},
+	}, "admin"))
+
+	lst, err := models.DatasourceAclGets(c, 1)
+	require.NoError(t, err)
+	require.Len(t, lst, 1)
+	assert.Equal(t, int64(4), lst[0].SubjectId)
+	assert.Equal(t, "admin", lst[0].CreateBy)
+
+	m, err := models.DatasourceAclGetMap(c)
+	require.NoError(t, err)
+	assert.Len(t, m, 2)
+	assert.Len(t, m[2], 1)
+
+	// 删除数据源时一起删除授权
+	require.NoError(t, models.DatasourceDel(c, []int64{1}))
+	m, err = models.DatasourceAclGetMap(c)
+	require.NoError(t, err)
+	assert.Len(t, m, 1)
+	assert.Empty(t, m[1])
+}
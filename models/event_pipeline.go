@@ -84,7 +84,12 @@ func (e *EventPipeline) Verify() error {
 
 // CreateEventPipeline 创建事件Pipeline
 func CreateEventPipeline(ctx *ctx.Context, pipeline *EventPipeline) error {
-	return DB(ctx).Create(pipeline).Error
+	if err := DB(ctx).Create(pipeline).Error; err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionEventPipeline, pipeline.ID)
+	return nil
 }
 
 // GetEventPipeline 获取单个事件Pipeline
@@ -106,7 +111,12 @@ func GetEventPipelinesByIds(ctx *ctx.Context, ids []int64) ([]*EventPipeline, er
 
 // UpdateEventPipeline 更新事件Pipeline
 func UpdateEventPipeline(ctx *ctx.Context, pipeline *EventPipeline) error {
-	return DB(ctx).Save(pipeline).Error
+	if err := DB(ctx).Save(pipeline).Error; err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionEventPipeline, pipeline.ID)
+	return nil
 }
 
 // DeleteEventPipeline 删除事件Pipeline
@@ -167,15 +177,32 @@ func UpdateEventPipelinesDisabled(ctx *ctx.Context, ids []int64, disabled bool,
 		return nil
 	}
 
-	return DB(ctx).Model(&EventPipeline{}).Where("id in ?", ids).Updates(map[string]interface{}{
+	err := DB(ctx).Model(&EventPipeline{}).Where("id in ?", ids).Updates(map[string]interface{}{
 		"disabled":  disabled,
 		"update_at": time.Now().Unix(),
 		"update_by": updateBy,
 	}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		ConfigRevisionRecord(ctx, RevisionEventPipeline, id)
+	}
+	return nil
 }
 
 // Update 更新事件Pipeline
 func (e *EventPipeline) Update(ctx *ctx.Context, ref *EventPipeline) error {
+	if err := e.update(ctx, ref); err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionEventPipeline, e.ID)
+	return nil
+}
+
+func (e *EventPipeline) update(ctx *ctx.Context, ref *EventPipeline) error {
 	ref.ID = e.ID
 	ref.CreateAt = e.CreateAt
 	ref.CreateBy = e.CreateBy
@@ -26,6 +26,14 @@ type BranchProcessor interface {
 	ProcessWithBranch(ctx *ctx.Context, wfCtx *WorkflowContext) (*NodeOutput, error)
 }
 
+// MergeProcessor 合并处理器接口
+// 用于 merge 等需要等待多路分支结束、合并各路输出的处理器
+type MergeProcessor interface {
+	Processor
+	// ProcessMerge 合并所有已执行分支的输出，未执行（分支未命中）的输入不包含在 inputs 中
+	ProcessMerge(ctx *ctx.Context, wfCtx *WorkflowContext, inputs []*MergeInput) (*NodeOutput, error)
+}
+
 type NewProcessorFn func(settings interface{}) (Processor, error)
 
 var processorRegister = map[string]NewProcessorFn{}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/poster"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// IngestToken 业务组的写入令牌。pushgw 校验令牌后，强制把业务组标签写成令牌所属的业务组，
+// 并可限制允许写入的指标名前缀和每秒样本数
+type IngestToken struct {
+	Id             int64    `json:"id" gorm:"primaryKey;type:bigint;autoIncrement"`
+	GroupId        int64    `json:"group_id" gorm:"type:bigint;not null;default:0;index:idx_ingest_token_group_id"`
+	Token          string   `json:"token" gorm:"type:varchar(255);not null;default:'';index:idx_ingest_token_token"`
+	Note           string   `json:"note" gorm:"type:varchar(255);not null;default:''"`
+	MetricPrefixes []string `json:"metric_prefixes" gorm:"type:text;serializer:json"` // 为空表示不限制
+	RateLimit      int      `json:"rate_limit" gorm:"type:int;not null;default:0"`    // 每秒样本数，0 表示不限制
+	Disabled       int      `json:"disabled" gorm:"type:int;not null;default:0"`
+	ExpireAt       int64    `json:"expire_at" gorm:"type:bigint;not null;default:0"` // 0 表示永不过期
+	CreateAt       int64    `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	CreateBy       string   `json:"create_by" gorm:"type:varchar(64);not null;default:''"`
+	UpdateAt       int64    `json:"update_at" gorm:"type:bigint;not null;default:0"`
+	UpdateBy       string   `json:"update_by" gorm:"type:varchar(64);not null;default:''"`
+}
+
+func (t *IngestToken) TableName() string {
+	return "ingest_token"
+}
+
+func (t *IngestToken) IsExpired() bool {
+	return t.ExpireAt > 0 && t.ExpireAt < time.Now().Unix()
+}
+
+// MetricAllowed 指标名是否命中允许的前缀
+func (t *IngestToken) MetricAllowed(metric string) bool {
+	if len(t.MetricPrefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range t.MetricPrefixes {
+		if strings.HasPrefix(metric, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *IngestToken) Verify() error {
+	if t.RateLimit < 0 {
+		return errors.New("rate_limit should not be negative")
+	}
+
+	if t.ExpireAt < 0 {
+		return errors.New("expire_at should not be negative")
+	}
+
+	prefixes := make([]string, 0, len(t.MetricPrefixes))
+	for _, prefix := range t.MetricPrefixes {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	t.MetricPrefixes = prefixes
+
+	return nil
+}
+
+func (t *IngestToken) Add(ctx *ctx.Context) error {
+	if err := t.Verify(); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	t.Token = uuid.New().String()
+	t.CreateAt = now
+	t.UpdateAt = now
+
+	return Insert(ctx, t)
+}
+
+// Update 令牌本身不可修改，只能调整限制条件
+func (t *IngestToken) Update(ctx *ctx.Context, ref IngestToken) error {
+	if err := ref.Verify(); err != nil {
+		return err
+	}
+
+	return DB(ctx).Model(t).Select("note", "metric_prefixes", "rate_limit", "disabled", "expire_at", "update_at", "update_by").Updates(IngestToken{
+		Note:           ref.Note,
+		MetricPrefixes: ref.MetricPrefixes,
+		RateLimit:      ref.RateLimit,
+		Disabled:       ref.Disabled,
+		ExpireAt:       ref.ExpireAt,
+		UpdateAt:       time.Now().Unix(),
+		UpdateBy:       ref.UpdateBy,
+	}).Error
+}
+
+func IngestTokenDels(ctx *ctx.Context, ids []int64, groupId int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return DB(ctx).Where("id in ? and group_id = ?", ids, groupId).Delete(&IngestToken{}).Error
+}
+
+func IngestTokenGets(ctx *ctx.Context, groupId int64) ([]IngestToken, error) {
+	var lst []IngestToken
+	err := DB(ctx).Where("group_id = ?", groupId).Order("create_at desc").Find(&lst).Error
+	return lst, err
+}
+
+func IngestTokenGetById(ctx *ctx.Context, id int64) (*IngestToken, error) {
+	var lst []*IngestToken
+	err := DB(ctx).Where("id = ?", id).Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lst) == 0 {
+		return nil, nil
+	}
+
+	return lst[0], nil
+}
+
+// IngestTokenEnabledGets pushgw 同步写入令牌，边缘模式下从中心端获取
+func IngestTokenEnabledGets(ctx *ctx.Context) ([]*IngestToken, error) {
+	if !ctx.IsCenter {
+		return poster.GetByUrls[[]*IngestToken](ctx, "/v1/n9e/ingest-tokens")
+	}
+
+	var lst []*IngestToken
+	err := DB(ctx).Where("disabled = ?", 0).Find(&lst).Error
+	return lst, err
+}
+
+func IngestTokenStatistics(ctx *ctx.Context) (*Statistics, error) {
+	if !ctx.IsCenter {
+		return poster.GetByUrls[*Statistics](ctx, "/v1/n9e/statistic?name=ingest_token")
+	}
+
+	return StatisticsGet(ctx, &IngestToken{})
+}
@@ -29,6 +29,7 @@ type MessageTemplate struct {
 	Private            int               `json:"private"`              // 0-公开 1-私有
 	Weight             int               `json:"weight"`               // 权重，根据此字段对内置模板进行排序
 	Lang               string            `json:"lang"`                 // 模板语言，为空视为中文（兼容存量数据）
+	TenantId           int64             `json:"tenant_id"`            // 0 表示所有租户共用
 	CreateAt           int64             `json:"create_at"`
 	CreateBy           string            `json:"create_by"`
 	UpdateAt           int64             `json:"update_at"`
@@ -124,6 +125,15 @@ func (t *MessageTemplate) Verify() error {
 }
 
 func (t *MessageTemplate) Update(ctx *ctx.Context, ref MessageTemplate) error {
+	if err := t.update(ctx, ref); err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionMessageTemplate, t.ID)
+	return nil
+}
+
+func (t *MessageTemplate) update(ctx *ctx.Context, ref MessageTemplate) error {
 	// ref.FE2DB()
 	if t.Ident != ref.Ident {
 		return errors.New("cannot update ident")
@@ -132,6 +142,7 @@ func (t *MessageTemplate) Update(ctx *ctx.Context, ref MessageTemplate) error {
 	ref.ID = t.ID
 	ref.CreateAt = t.CreateAt
 	ref.CreateBy = t.CreateBy
+	ref.TenantId = t.TenantId
 	ref.UpdateAt = time.Now().Unix()
 
 	err := ref.Verify()
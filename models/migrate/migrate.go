@@ -250,25 +250,27 @@ func MigrateTables(db *gorm.DB) error {
 	db = migrationDB(db, tableOptions)
 	dts := []interface{}{&RecordingRule{}, &AlertRule{}, &AlertSubscribe{}, &AlertMute{},
 		&TaskRecord{}, &TaskTpl{}, &ChartShare{}, &Target{}, &Configs{}, &Datasource{}, &NotifyTpl{},
-		&Board{}, &BoardBusigroup{}, &Users{}, &SsoConfig{}, &models.BuiltinMetric{},
+		&Board{}, &BoardBusigroup{}, &Users{}, &UserGroup{}, &BusiGroup{}, &SsoConfig{}, &models.BuiltinMetric{},
 		&models.MetricFilter{}, &models.NotificationRecord{}, &models.TargetBusiGroup{},
 		&models.UserToken{}, &models.DashAnnotation{}, MessageTemplate{}, NotifyRule{}, NotifyChannelConfig{}, &EsIndexPatternMigrate{},
 		&models.EventPipeline{}, &models.EmbeddedProduct{}, &models.SourceToken{},
 		&models.SavedView{}, &models.UserViewFavorite{},
 		&models.AILLMConfig{}, &models.AIAgent{}, &models.AISkill{},
-		&models.AssistantChatRow{}}
+		&models.AssistantChatRow{}, &models.NotifyRetry{}, &models.ScrapeJob{}, &models.IngestToken{}, &models.TargetHistory{}, &models.CmdbSync{}, &models.CmdbSyncApplied{}, &models.AuditLog{}, &Role{}, &models.UserTotp{}, &models.DatasourceAcl{}, &models.UserPasswordHistory{}, &models.Tenant{}}
 
 	if isPostgres(db) {
 		dts = append(dts, &models.AssistantMessageRow{}) // PostgreSQL: text is unlimited
 		dts = append(dts, &models.PostgresBuiltinComponent{})
 		dts = append(dts, &models.PostgresAISkillFile{})
 		dts = append(dts, &models.PostgresEventPipelineExecution{})
+		dts = append(dts, &models.PostgresConfigRevision{})
 		DropUniqueFiledLimit(db, &models.PostgresBuiltinComponent{}, "idx_ident", "idx_ident")
 	} else {
 		dts = append(dts, &models.MysqlAssistantMessageRow{}) // MySQL: mediumtext; SQLite: treated as text
 		dts = append(dts, &models.BuiltinComponent{})
 		dts = append(dts, &models.AISkillFile{})
 		dts = append(dts, &models.EventPipelineExecution{})
+		dts = append(dts, &models.ConfigRevision{})
 		DropUniqueFiledLimit(db, &models.BuiltinComponent{}, "idx_ident", "idx_ident")
 	}
 
@@ -449,12 +451,14 @@ type Target struct {
 	EngineName   string   `gorm:"column:engine_name;type:varchar(255);default:'';comment:engine name;index:idx_engine_name"`
 	OS           string   `gorm:"column:os;type:varchar(31);default:'';comment:os type;index:idx_os"`
 	HostTags     []string `gorm:"column:host_tags;type:text;comment:global labels set in conf file;serializer:json"`
+	StaleSince   int64    `gorm:"column:stale_since;type:bigint;not null;default:0;comment:stale since, set by lifecycle policy"`
 }
 
 type Datasource struct {
 	IsDefault  bool   `gorm:"column:is_default;type:boolean;comment:is default datasource"`
 	Identifier string `gorm:"column:identifier;type:varchar(255);default:'';comment:identifier"`
 	Weight     int    `gorm:"column:weight;type:int;default:0;comment:weight for sorting"`
+	TenantId   int64  `gorm:"column:tenant_id;type:bigint;not null;default:0;comment:tenant id, 0 means platform"`
 }
 
 type Configs struct {
@@ -487,9 +491,33 @@ type BoardBusigroup struct {
 }
 
 type Users struct {
-	Belong         string `gorm:"column:belong;type:varchar(16);default:'';comment:belong"`
-	LastActiveTime int64  `gorm:"column:last_active_time;type:int;default:0;comment:last_active_time"`
-	Phone          string `gorm:"column:phone;type:varchar(1024);not null;default:''"`
+	Belong           string `gorm:"column:belong;type:varchar(16);default:'';comment:belong"`
+	LastActiveTime   int64  `gorm:"column:last_active_time;type:int;default:0;comment:last_active_time"`
+	Phone            string `gorm:"column:phone;type:varchar(1024);not null;default:''"`
+	Disabled         int    `gorm:"column:disabled;type:int;not null;default:0;comment:1 means disabled"`
+	PasswordUpdateAt int64  `gorm:"column:password_update_at;type:bigint;not null;default:0;comment:last time the password was changed"`
+	TenantId         int64  `gorm:"column:tenant_id;type:bigint;not null;default:0;comment:tenant id, 0 means platform"`
+	ScimExternalId   string `gorm:"column:scim_external_id;type:varchar(255);not null;default:'';comment:scim externalId, not empty means managed by scim"`
+}
+
+type UserGroup struct {
+	TenantId int64 `gorm:"column:tenant_id;type:bigint;not null;default:0;comment:tenant id, 0 means platform"`
+}
+
+func (UserGroup) TableName() string {
+	return "user_group"
+}
+
+type BusiGroup struct {
+	TenantId int64 `gorm:"column:tenant_id;type:bigint;not null;default:0;comment:tenant id, 0 means platform"`
+}
+
+func (BusiGroup) TableName() string {
+	return "busi_group"
+}
+
+type Role struct {
+	TotpRequired int `gorm:"column:totp_required;type:int;not null;default:0;comment:1 means two-factor authentication is required"`
 }
 
 type SsoConfig struct {
@@ -557,6 +585,7 @@ type MessageTemplate struct {
 	Private            int               `gorm:"column:private;type:int;not null;default:0"`
 	Weight             int               `gorm:"column:weight;type:int;not null;default:0"`
 	Lang               string            `gorm:"column:lang;type:varchar(32);not null;default:''"`
+	TenantId           int64             `gorm:"column:tenant_id;type:bigint;not null;default:0"`
 	CreateAt           int64             `gorm:"column:create_at;not null;default:0"`
 	CreateBy           string            `gorm:"column:create_by;type:varchar(64);not null;default:''"`
 	UpdateAt           int64             `gorm:"column:update_at;not null;default:0"`
@@ -576,6 +605,7 @@ type NotifyRule struct {
 	NotifyConfigs   []models.NotifyConfig   `gorm:"column:notify_configs;type:text"`
 	PipelineConfigs []models.PipelineConfig `gorm:"column:pipeline_configs;type:text"`
 	ExtraConfig     interface{}             `gorm:"column:extra_config;type:text"`
+	TenantId        int64                   `gorm:"column:tenant_id;type:bigint;not null;default:0"`
 	CreateAt        int64                   `gorm:"column:create_at;not null;default:0"`
 	CreateBy        string                  `gorm:"column:create_by;type:varchar(64);not null;default:''"`
 	UpdateAt        int64                   `gorm:"column:update_at;not null;default:0"`
@@ -596,6 +626,7 @@ type NotifyChannelConfig struct {
 	RequestType   string                   `gorm:"column:request_type;type:varchar(50);not null"`
 	RequestConfig *models.RequestConfig    `gorm:"column:request_config;type:text"`
 	Weight        int                      `gorm:"column:weight;type:int;not null;default:0"`
+	TenantId      int64                    `gorm:"column:tenant_id;type:bigint;not null;default:0"`
 	CreateAt      int64                    `gorm:"column:create_at;not null;default:0"`
 	CreateBy      string                   `gorm:"column:create_by;type:varchar(64);not null;default:''"`
 	UpdateAt      int64                    `gorm:"column:update_at;not null;default:0"`
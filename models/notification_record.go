@@ -10,7 +10,8 @@ import (
 const (
 	NotiStatusSuccess = iota + 1
 	NotiStatusFailure
-	NotiStatusMuted // 命中「只屏蔽通知」规则，事件已产生但通知被抑制
+	NotiStatusMuted     // 命中「只屏蔽通知」规则，事件已产生但通知被抑制
+	NotiStatusThrottled // 超出通知限流，通知未发送，计入周期汇总
 )
 
 // NotiChannelMuted 「只屏蔽通知」通知记录的伪渠道标识：稳定英文 key，
@@ -31,10 +32,11 @@ type NotificationRecord struct {
 	EventId      int64  `json:"event_id" gorm:"type:bigint;not null;index:idx_evt,priority:1;comment:event history id"`
 	SubId        int64  `json:"sub_id" gorm:"type:bigint;comment:subscribed rule id"`
 	Channel      string `json:"channel" gorm:"type:varchar(255);not null;comment:notification channel name"`
-	Status       int    `json:"status" gorm:"type:int;comment:notification status"` // 1-成功，2-失败
+	Status       int    `json:"status" gorm:"type:int;comment:notification status"` // 1-成功，2-失败，3-屏蔽，4-限流
 	Target       string `json:"target" gorm:"type:varchar(1024);not null;comment:notification target"`
 	Details      string `json:"details" gorm:"type:varchar(2048);default:'';comment:notification other info"`
 	CreatedAt    int64  `json:"created_at" gorm:"type:bigint;not null;comment:create time"`
+	RetryId      int64  `json:"retry_id" gorm:"type:bigint;not null;default:0;comment:notify retry id"` // 发送失败进入重试后，原始记录、重试和手动重发的记录都关联到同一个 notify_retry
 }
 
 func NewNotificationRecord(event *AlertCurEvent, notifyRuleID int64, channel, target string) *NotificationRecord {
@@ -92,6 +94,14 @@ func (n *NotificationRecord) GetGroupIds(ctx *ctx.Context) (groupIds []int64) {
 	return
 }
 
+// NotificationRecordsGetByRetry 取一次投递关联的所有通知记录，带上 event_id 条件以使用 idx_evt 索引
+func NotificationRecordsGetByRetry(ctx *ctx.Context, retry *NotifyRetry) ([]*NotificationRecord, error) {
+	if len(retry.EventIds) == 0 {
+		return []*NotificationRecord{}, nil
+	}
+	return NotificationRecordsGet(ctx, "event_id in ? and retry_id = ?", retry.EventIds, retry.Id)
+}
+
 func NotificationRecordsGetByEventId(ctx *ctx.Context, eid int64) ([]*NotificationRecord, error) {
 	return NotificationRecordsGet(ctx, "event_id=?", eid)
 }
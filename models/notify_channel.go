@@ -22,6 +22,7 @@ type EmailContext struct {
 	NotifyRuleId int64
 	Events       []*AlertCurEvent
 	Mail         *gomail.Message
+	Retry        *NotifyRetry // 发送失败时据此加入重试队列
 }
 
 // NotifyChannelConfig 通知媒介
@@ -40,7 +41,8 @@ type NotifyChannelConfig struct {
 	RequestType   string         `json:"request_type"` // http, stmp, script, flashduty
 	RequestConfig *RequestConfig `json:"request_config,omitempty" gorm:"serializer:json"`
 
-	Weight           int    `json:"weight"` // 权重，根据此字段对内置模板进行排序
+	Weight           int    `json:"weight"`    // 权重，根据此字段对内置模板进行排序
+	TenantId         int64  `json:"tenant_id"` // 0 表示所有租户共用
 	CreateAt         int64  `json:"create_at"`
 	CreateBy         string `json:"create_by"`
 	UpdateAt         int64  `json:"update_at"`
@@ -503,6 +505,7 @@ func (ncc *NotifyChannelConfig) Update(ctx *ctx.Context, ref NotifyChannelConfig
 	ref.ID = ncc.ID
 	ref.CreateAt = ncc.CreateAt
 	ref.CreateBy = ncc.CreateBy
+	ref.TenantId = ncc.TenantId
 	ref.UpdateAt = time.Now().Unix()
 
 	err := ref.Verify()
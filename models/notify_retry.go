@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/poster"
+
+	"gorm.io/gorm"
+)
+
+const (
+	NotifyRetryPending   = "pending"   // 等待重试
+	NotifyRetryDead      = "dead"      // 超过最大次数，进入死信
+	NotifyRetrySucceeded = "succeeded" // 重试或重发成功
+)
+
+// NotifyRetry 发送失败的通知投递，持久化在 DB 中，重启后继续重试。
+// 只保存重新发送所需的定位信息：事件从 alert_his_event 读取，内容按通知媒介和消息模板重新渲染，
+// 这次投递产生的所有通知记录通过 notification_record.retry_id 关联到这里
+type NotifyRetry struct {
+	Id                   int64             `json:"id" gorm:"primaryKey;type:bigint;autoIncrement"`
+	NotifyRuleId         int64             `json:"notify_rule_id" gorm:"type:bigint;not null;default:0"`
+	ChannelId            int64             `json:"channel_id" gorm:"type:bigint;not null;default:0"`
+	ChannelName          string            `json:"channel_name" gorm:"type:varchar(255);not null;default:''"`
+	TemplateId           int64             `json:"template_id" gorm:"type:bigint;not null;default:0"`
+	EventIds             []int64           `json:"event_ids" gorm:"type:text;serializer:json"`
+	Sendtos              []string          `json:"sendtos" gorm:"type:text;serializer:json"`
+	CustomParams         map[string]string `json:"custom_params" gorm:"type:text;serializer:json"`
+	FlashDutyChannelIDs  []int64           `json:"flashduty_channel_ids" gorm:"column:flashduty_channel_ids;type:text;serializer:json"`
+	PagerDutyRoutingKeys []string          `json:"pagerduty_routing_keys" gorm:"column:pagerduty_routing_keys;type:text;serializer:json"`
+	ImGroupIDs           []string          `json:"im_group_ids" gorm:"type:text;serializer:json"`
+	Target               string            `json:"target" gorm:"type:varchar(1024);not null;default:''"`
+	Status               string            `json:"status" gorm:"type:varchar(32);not null;default:'';index:idx_nrt_status_next,priority:1"`
+	Attempts             int               `json:"attempts" gorm:"type:int;not null;default:0"`
+	MaxAttempts          int               `json:"max_attempts" gorm:"type:int;not null;default:0"`
+	Resends              int               `json:"resends" gorm:"type:int;not null;default:0"` // 手动重发次数
+	NextRetryAt          int64             `json:"next_retry_at" gorm:"type:bigint;not null;default:0;index:idx_nrt_status_next,priority:2"`
+	LastError            string            `json:"last_error" gorm:"type:varchar(2048);not null;default:''"`
+	CreatedAt            int64             `json:"created_at" gorm:"type:bigint;not null;default:0"`
+	UpdatedAt            int64             `json:"updated_at" gorm:"type:bigint;not null;default:0"`
+	UpdatedBy            string            `json:"updated_by" gorm:"type:varchar(64);not null;default:''"`
+}
+
+func (n *NotifyRetry) TableName() string {
+	return "notify_retry"
+}
+
+// Add 边缘告警引擎没有 DB，转发给中心端入队，由中心端重试发送
+func (n *NotifyRetry) Add(ctx *ctx.Context) error {
+	if !ctx.IsCenter {
+		id, err := poster.PostByUrlsWithResp[int64](ctx, "/v1/n9e/notify-retry-add", n)
+		if err != nil {
+			return err
+		}
+		n.Id = id
+		return nil
+	}
+
+	now := time.Now().Unix()
+	n.CreatedAt = now
+	n.UpdatedAt = now
+	return Insert(ctx, n)
+}
+
+func (n *NotifyRetry) Update(ctx *ctx.Context, fields map[string]interface{}) error {
+	fields["updated_at"] = time.Now().Unix()
+	return DB(ctx).Model(n).Updates(fields).Error
+}
+
+// NotifyRetryClaim 抢占一条到期的重试：把 next_retry_at 推后 lease 秒，多个告警引擎同时扫描时只有一个能抢到
+func NotifyRetryClaim(ctx *ctx.Context, n *NotifyRetry, lease int64) (bool, error) {
+	result := DB(ctx).Model(&NotifyRetry{}).
+		Where("id = ? and status = ? and next_retry_at = ?", n.Id, NotifyRetryPending, n.NextRetryAt).
+		Update("next_retry_at", time.Now().Unix()+lease)
+	return result.RowsAffected == 1, result.Error
+}
+
+// NotifyRetryDue 取到期待重试的投递
+func NotifyRetryDue(ctx *ctx.Context, now int64, limit int) ([]*NotifyRetry, error) {
+	var lst []*NotifyRetry
+	err := DB(ctx).Where("status = ? and next_retry_at <= ?", NotifyRetryPending, now).
+		Order("next_retry_at").Limit(limit).Find(&lst).Error
+	return lst, err
+}
+
+func NotifyRetryGet(ctx *ctx.Context, id int64) (*NotifyRetry, error) {
+	var lst []*NotifyRetry
+	err := DB(ctx).Where("id = ?", id).Find(&lst).Error
+	if err != nil || len(lst) == 0 {
+		return nil, err
+	}
+	return lst[0], nil
+}
+
+func notifyRetrySession(ctx *ctx.Context, status string, notifyRuleId, channelId int64) *gorm.DB {
+	session := DB(ctx).Model(&NotifyRetry{})
+	if status != "" {
+		session = session.Where("status = ?", status)
+	}
+	if notifyRuleId > 0 {
+		session = session.Where("notify_rule_id = ?", notifyRuleId)
+	}
+	if channelId > 0 {
+		session = session.Where("channel_id = ?", channelId)
+	}
+	return session
+}
+
+func NotifyRetryTotal(ctx *ctx.Context, status string, notifyRuleId, channelId int64) (int64, error) {
+	return Count(notifyRetrySession(ctx, status, notifyRuleId, channelId))
+}
+
+func NotifyRetryGets(ctx *ctx.Context, status string, notifyRuleId, channelId int64, limit, offset int) ([]*NotifyRetry, error) {
+	var lst []*NotifyRetry
+	err := notifyRetrySession(ctx, status, notifyRuleId, channelId).
+		Order("id desc").Limit(limit).Offset(offset).Find(&lst).Error
+	return lst, err
+}
+
+// NotifyRetryResend 把死信重新放回队列，只再发送一次，失败后重新进入死信
+func NotifyRetryResend(ctx *ctx.Context, ids []int64, username string) (int64, error) {
+	now := time.Now().Unix()
+	result := DB(ctx).Model(&NotifyRetry{}).Where("id in ? and status = ?", ids, NotifyRetryDead).
+		Updates(map[string]interface{}{
+			"status":        NotifyRetryPending,
+			"max_attempts":  gorm.Expr("attempts + 1"),
+			"resends":       gorm.Expr("resends + 1"),
+			"next_retry_at": now,
+			"updated_at":    now,
+			"updated_by":    username,
+		})
+	return result.RowsAffected, result.Error
+}
+
+func NotifyRetryDel(ctx *ctx.Context, ids []int64) error {
+	return DB(ctx).Where("id in ? and status <> ?", ids, NotifyRetryPending).Delete(&NotifyRetry{}).Error
+}
+
+// NotifyRetryDeleteBefore 清理 updated_at 早于 before 的已成功记录和死信
+func NotifyRetryDeleteBefore(ctx *ctx.Context, before int64) error {
+	return DB(ctx).Where("status <> ? and updated_at < ?", NotifyRetryPending, before).Delete(&NotifyRetry{}).Error
+}
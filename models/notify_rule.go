@@ -24,6 +24,7 @@ type NotifyRule struct {
 	// 通知配置
 	NotifyConfigs []NotifyConfig `json:"notify_configs" gorm:"serializer:json"`
 	ExtraConfig   interface{}    `json:"extra_config,omitempty" gorm:"serializer:json"`
+	TenantId      int64          `json:"tenant_id"`
 
 	CreateAt         int64  `json:"create_at"`
 	CreateBy         string `json:"create_by"`
@@ -119,7 +120,12 @@ var NotifyRuleCache struct {
 
 // 创建 NotifyRule
 func CreateNotifyRule(c *ctx.Context, rule *NotifyRule) error {
-	return DB(c).Create(rule).Error
+	if err := DB(c).Create(rule).Error; err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(c, RevisionNotifyRule, rule.ID)
+	return nil
 }
 
 // 读取 NotifyRule
@@ -230,11 +236,21 @@ func (t *TimeRanges) Verify() error {
 }
 
 func (r *NotifyRule) Update(ctx *ctx.Context, ref NotifyRule) error {
+	if err := r.update(ctx, ref); err != nil {
+		return err
+	}
+
+	ConfigRevisionRecord(ctx, RevisionNotifyRule, r.ID)
+	return nil
+}
+
+func (r *NotifyRule) update(ctx *ctx.Context, ref NotifyRule) error {
 	// ref.FE2DB()
 
 	ref.ID = r.ID
 	ref.CreateAt = r.CreateAt
 	ref.CreateBy = r.CreateBy
+	ref.TenantId = r.TenantId
 	ref.UpdateAt = time.Now().Unix()
 
 	err := ref.Verify()
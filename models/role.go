@@ -6,9 +6,10 @@ import (
 )
 
 type Role struct {
-	Id   int64  `json:"id" gorm:"primaryKey"`
-	Name string `json:"name"`
-	Note string `json:"note"`
+	Id           int64  `json:"id" gorm:"primaryKey"`
+	Name         string `json:"name"`
+	Note         string `json:"note"`
+	TotpRequired int    `json:"totp_required"` // 1 表示该角色的用户必须启用两步验证
 }
 
 func (Role) TableName() string {
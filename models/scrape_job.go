@@ -0,0 +1,232 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/poster"
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	ScrapeSdStatic = "static" // 静态地址列表
+	ScrapeSdFile   = "file"   // prometheus file_sd 格式的文件，相对告警引擎 [Alert.Scrape] SdFileDir 的路径
+	ScrapeSdHttp   = "http"   // prometheus http_sd 格式的接口
+	ScrapeSdTarget = "target" // 机器列表中的机器 + 端口
+)
+
+// ScrapeJob 抓取任务，由告警引擎按 hash ring 分片拉取 /metrics，写入 pushgw 的 writers
+type ScrapeJob struct {
+	Id                   int64                  `json:"id" gorm:"primaryKey;type:bigint;autoIncrement"`
+	GroupId              int64                  `json:"group_id" gorm:"type:bigint;not null;default:0;index:idx_scrape_job_group_id"`
+	Name                 string                 `json:"name" gorm:"type:varchar(255);not null;default:''"` // 作为 job 标签
+	Disabled             int                    `json:"disabled" gorm:"type:int;not null;default:0"`
+	SdType               string                 `json:"sd_type" gorm:"type:varchar(32);not null;default:''"`
+	Targets              []string               `json:"targets" gorm:"type:text;serializer:json"` // static: host:port
+	SdFile               string                 `json:"sd_file" gorm:"type:varchar(1024);not null;default:''"`
+	SdUrl                string                 `json:"sd_url" gorm:"type:varchar(1024);not null;default:''"`
+	TargetGroupIds       []int64                `json:"target_group_ids" gorm:"type:text;serializer:json"` // target: 机器所属业务组，为空时取任务所在业务组
+	TargetPort           int                    `json:"target_port" gorm:"type:int;not null;default:0"`
+	Scheme               string                 `json:"scheme" gorm:"type:varchar(16);not null;default:''"`
+	MetricsPath          string                 `json:"metrics_path" gorm:"type:varchar(255);not null;default:''"`
+	Headers              map[string]string      `json:"headers" gorm:"type:text;serializer:json"`
+	ScrapeInterval       int                    `json:"scrape_interval" gorm:"type:int;not null;default:0"` // unit: s
+	ScrapeTimeout        int                    `json:"scrape_timeout" gorm:"type:int;not null;default:0"`  // unit: s
+	HonorLabels          bool                   `json:"honor_labels" gorm:"not null;default:false"`
+	Labels               map[string]string      `json:"labels" gorm:"type:text;serializer:json"`
+	RelabelConfigs       []*pconf.RelabelConfig `json:"relabel_configs" gorm:"type:text;serializer:json"`        // 作用于 target 标签
+	MetricRelabelConfigs []*pconf.RelabelConfig `json:"metric_relabel_configs" gorm:"type:text;serializer:json"` // 作用于抓取到的每条 series
+	Note                 string                 `json:"note" gorm:"type:varchar(1024);not null;default:''"`
+	CreateAt             int64                  `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	CreateBy             string                 `json:"create_by" gorm:"type:varchar(64);not null;default:''"`
+	UpdateAt             int64                  `json:"update_at" gorm:"type:bigint;not null;default:0"`
+	UpdateBy             string                 `json:"update_by" gorm:"type:varchar(64);not null;default:''"`
+	UpdateByNickname     string                 `json:"update_by_nickname" gorm:"-"`
+}
+
+func (s *ScrapeJob) TableName() string {
+	return "scrape_job"
+}
+
+func (s *ScrapeJob) Verify() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return errors.New("name is blank")
+	}
+
+	switch s.SdType {
+	case ScrapeSdStatic:
+		if len(s.Targets) == 0 {
+			return errors.New("targets is blank")
+		}
+		for _, t := range s.Targets {
+			if strings.Contains(t, "/") || strings.TrimSpace(t) == "" {
+				return fmt.Errorf("target(%s) invalid, should be host:port", t)
+			}
+		}
+	case ScrapeSdFile:
+		if s.SdFile == "" {
+			return errors.New("sd_file is blank")
+		}
+		// 只能读取告警引擎 SdFileDir 目录下的文件，不允许绝对路径和 ..
+		if !filepath.IsLocal(s.SdFile) {
+			return fmt.Errorf("sd_file(%s) invalid, should be a relative path under the sd file dir of alert engine", s.SdFile)
+		}
+	case ScrapeSdHttp:
+		u, err := url.Parse(s.SdUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("sd_url(%s) invalid", s.SdUrl)
+		}
+	case ScrapeSdTarget:
+		if s.TargetPort <= 0 || s.TargetPort > 65535 {
+			return fmt.Errorf("target_port(%d) invalid", s.TargetPort)
+		}
+	default:
+		return fmt.Errorf("sd_type(%s) invalid", s.SdType)
+	}
+
+	if s.Scheme == "" {
+		s.Scheme = "http"
+	}
+	if s.Scheme != "http" && s.Scheme != "https" {
+		return fmt.Errorf("scheme(%s) invalid", s.Scheme)
+	}
+
+	if s.MetricsPath == "" {
+		s.MetricsPath = "/metrics"
+	}
+
+	if s.ScrapeInterval <= 0 {
+		s.ScrapeInterval = 15
+	}
+
+	if s.ScrapeTimeout <= 0 {
+		s.ScrapeTimeout = 10
+	}
+
+	if s.ScrapeTimeout > s.ScrapeInterval {
+		s.ScrapeTimeout = s.ScrapeInterval
+	}
+
+	for k := range s.Labels {
+		if !model.LabelNameRE.MatchString(k) || strings.HasPrefix(k, "__") {
+			return fmt.Errorf("label(%s) invalid", k)
+		}
+	}
+
+	for _, cfgs := range [][]*pconf.RelabelConfig{s.RelabelConfigs, s.MetricRelabelConfigs} {
+		for _, cfg := range cfgs {
+			if cfg == nil {
+				return errors.New("relabel config is null")
+			}
+			if _, err := regexp.Compile(cfg.Regex); err != nil {
+				return fmt.Errorf("relabel regex(%s) invalid: %v", cfg.Regex, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *ScrapeJob) Add(ctx *ctx.Context) error {
+	if err := s.Verify(); err != nil {
+		return err
+	}
+
+	exists, err := ScrapeJobExists(ctx, 0, s.GroupId, s.Name)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return errors.New("scrape job already exists")
+	}
+
+	now := time.Now().Unix()
+	s.CreateAt = now
+	s.UpdateAt = now
+
+	return Insert(ctx, s)
+}
+
+func (s *ScrapeJob) Update(ctx *ctx.Context, ref ScrapeJob) error {
+	if s.Name != ref.Name {
+		exists, err := ScrapeJobExists(ctx, s.Id, s.GroupId, ref.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errors.New("scrape job already exists")
+		}
+	}
+
+	ref.Id = s.Id
+	ref.GroupId = s.GroupId
+	ref.CreateAt = s.CreateAt
+	ref.CreateBy = s.CreateBy
+	ref.UpdateAt = time.Now().Unix()
+	if err := ref.Verify(); err != nil {
+		return err
+	}
+
+	return DB(ctx).Model(s).Select("*").Updates(ref).Error
+}
+
+func ScrapeJobExists(ctx *ctx.Context, id, groupId int64, name string) (bool, error) {
+	return Exists(DB(ctx).Model(&ScrapeJob{}).Where("id <> ? and group_id = ? and name = ?", id, groupId, name))
+}
+
+func ScrapeJobDels(ctx *ctx.Context, ids []int64, groupId int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return DB(ctx).Where("id in ? and group_id = ?", ids, groupId).Delete(&ScrapeJob{}).Error
+}
+
+func ScrapeJobGets(ctx *ctx.Context, groupId int64) ([]ScrapeJob, error) {
+	var lst []ScrapeJob
+	err := DB(ctx).Where("group_id = ?", groupId).Order("name").Find(&lst).Error
+	return lst, err
+}
+
+func ScrapeJobGetById(ctx *ctx.Context, id int64) (*ScrapeJob, error) {
+	var lst []*ScrapeJob
+	err := DB(ctx).Where("id = ?", id).Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lst) == 0 {
+		return nil, nil
+	}
+
+	return lst[0], nil
+}
+
+// ScrapeJobEnabledGets 告警引擎同步抓取任务，边缘模式下从中心端获取
+func ScrapeJobEnabledGets(ctx *ctx.Context) ([]*ScrapeJob, error) {
+	if !ctx.IsCenter {
+		return poster.GetByUrls[[]*ScrapeJob](ctx, "/v1/n9e/scrape-jobs")
+	}
+
+	var lst []*ScrapeJob
+	err := DB(ctx).Where("disabled = ?", 0).Find(&lst).Error
+	return lst, err
+}
+
+func ScrapeJobStatistics(ctx *ctx.Context) (*Statistics, error) {
+	if !ctx.IsCenter {
+		return poster.GetByUrls[*Statistics](ctx, "/v1/n9e/statistic?name=scrape_job")
+	}
+
+	return StatisticsGet(ctx, &ScrapeJob{})
+}
@@ -38,6 +38,7 @@ type Target struct {
 	EngineName   string            `json:"engine_name"`
 	OS           string            `json:"os" gorm:"column:os"`
 	HostTags     []string          `json:"host_tags" gorm:"serializer:json"`
+	StaleSince   int64             `json:"stale_since"` // 生命周期策略判定失联的起始时间，0 表示正常
 
 	BeatTime   int64    `json:"beat_time" gorm:"-"` // 实时心跳时间，从 Redis 获取
 	UnixTime   int64    `json:"unixtime" gorm:"-"`
@@ -615,6 +616,18 @@ func (t *Target) FillMeta(meta *HostMeta) {
 	t.RemoteAddr = meta.RemoteAddr
 }
 
+// TargetMarkStale 标记机器失联。多个 center 实例并发巡检时只有一个能标记成功，返回 false 表示已被标记
+func TargetMarkStale(ctx *ctx.Context, ident string, since int64) (bool, error) {
+	res := DB(ctx).Model(&Target{}).Where("ident = ? and stale_since = 0", ident).Update("stale_since", since)
+	return res.RowsAffected > 0, res.Error
+}
+
+// TargetClearStale 机器恢复心跳后清除失联标记，返回 false 表示未被标记
+func TargetClearStale(ctx *ctx.Context, ident string) (bool, error) {
+	res := DB(ctx).Model(&Target{}).Where("ident = ? and stale_since > 0", ident).Update("stale_since", 0)
+	return res.RowsAffected > 0, res.Error
+}
+
 // FetchBeatTimesFromRedis 从 Redis 批量获取心跳时间，返回 ident -> updateTime 的映射
 func FetchBeatTimesFromRedis(redis storage.Redis, idents []string) map[string]int64 {
 	result := make(map[string]int64, len(idents))
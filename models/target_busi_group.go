@@ -65,7 +65,12 @@ func TargetGroupIdsGetByIdents(ctx *ctx.Context, idents []string) ([]int64, erro
 	return groupIds, nil
 }
 
+// TargetBindBgids 把 idents 加入业务组 bgids。心跳、CMDB 同步和页面操作都走这里，租户配额也在这里统一检查
 func TargetBindBgids(ctx *ctx.Context, idents []string, bgids []int64, tags []string) error {
+	if err := TenantCheckTargetQuotaByBgids(ctx, bgids, idents); err != nil {
+		return err
+	}
+
 	lst := make([]TargetBusiGroup, 0, len(bgids)*len(idents))
 	updateAt := time.Now().Unix()
 	for _, bgid := range bgids {
@@ -130,6 +135,10 @@ func TargetDeleteBgids(tx *gorm.DB, idents []string) error {
 }
 
 func TargetOverrideBgids(ctx *ctx.Context, idents []string, bgids []int64, tags []string) error {
+	if err := TenantCheckTargetQuotaByBgids(ctx, bgids, idents); err != nil {
+		return err
+	}
+
 	return DB(ctx).Transaction(func(tx *gorm.DB) error {
 		// 先删除旧的关联
 		if err := tx.Where("target_ident IN ?", idents).Delete(&TargetBusiGroup{}).Error; err != nil {
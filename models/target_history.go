@@ -0,0 +1,142 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TargetHistoryFieldHostIp       = "host_ip"
+	TargetHistoryFieldOS           = "os"
+	TargetHistoryFieldAgentVersion = "agent_version"
+	TargetHistoryFieldEngineName   = "engine_name"
+	TargetHistoryFieldHostTags     = "host_tags"
+	TargetHistoryFieldBusiGroup    = "busi_group"
+	TargetHistoryFieldLifecycle    = "lifecycle"
+	TargetHistoryFieldTags         = "tags"
+	TargetHistoryFieldNote         = "note"
+
+	// 生命周期事件，记录在 lifecycle 字段的 new_value 中
+	TargetLifecycleStale     = "stale"
+	TargetLifecycleRecovered = "recovered"
+	TargetLifecycleDeleted   = "deleted"
+	TargetLifecycleArchived  = "archived"
+
+	// 非用户触发的变更
+	TargetOperatorHeartbeat = "heartbeat"
+	TargetOperatorLifecycle = "lifecycle"
+	TargetOperatorCmdb      = "cmdb"
+)
+
+// TargetHistory 机器变更历史：心跳上报的元信息变化、业务组和标签调整以及生命周期事件
+type TargetHistory struct {
+	Id       int64  `json:"id" gorm:"primaryKey;type:bigint;autoIncrement"`
+	Ident    string `json:"ident" gorm:"type:varchar(191);not null;default:'';index:idx_target_history_ident"`
+	Field    string `json:"field" gorm:"type:varchar(64);not null;default:''"`
+	OldValue string `json:"old_value" gorm:"type:text"`
+	NewValue string `json:"new_value" gorm:"type:text"`
+	Operator string `json:"operator" gorm:"type:varchar(64);not null;default:''"` // 用户名，或 heartbeat / lifecycle / cmdb
+	CreateAt int64  `json:"create_at" gorm:"type:bigint;not null;default:0;index:idx_target_history_create_at"`
+}
+
+func (h *TargetHistory) TableName() string {
+	return "target_history"
+}
+
+func NewTargetHistory(ident, field, oldValue, newValue, operator string) *TargetHistory {
+	return &TargetHistory{
+		Ident:    ident,
+		Field:    field,
+		OldValue: oldValue,
+		NewValue: newValue,
+		Operator: operator,
+		CreateAt: time.Now().Unix(),
+	}
+}
+
+// TargetHistoryAddedHook 变更历史写入后调用。调整机器业务组的地方都会写 busi_group 历史，
+// center 据此发布机器加入、移出业务组的事件
+var TargetHistoryAddedHook func(lst []*TargetHistory)
+
+func TargetHistoryAdd(ctx *ctx.Context, lst []*TargetHistory) error {
+	if len(lst) == 0 {
+		return nil
+	}
+
+	if err := DB(ctx).CreateInBatches(lst, 100).Error; err != nil {
+		return err
+	}
+
+	if TargetHistoryAddedHook != nil {
+		TargetHistoryAddedHook(lst)
+	}
+	return nil
+}
+
+func TargetHistoryTotal(ctx *ctx.Context, ident, field string) (int64, error) {
+	return Count(targetHistoryWhere(ctx, ident, field))
+}
+
+func TargetHistoryGets(ctx *ctx.Context, ident, field string, limit, offset int) ([]*TargetHistory, error) {
+	var lst []*TargetHistory
+	err := targetHistoryWhere(ctx, ident, field).Order("id desc").Limit(limit).Offset(offset).Find(&lst).Error
+	return lst, err
+}
+
+func targetHistoryWhere(ctx *ctx.Context, ident, field string) *gorm.DB {
+	session := DB(ctx).Model(&TargetHistory{}).Where("ident = ?", ident)
+	if field != "" {
+		session = session.Where("field = ?", field)
+	}
+	return session
+}
+
+// TargetHistoryOfBgids 比较机器调整前后的业务组，每台有变化的机器生成一条记录
+func TargetHistoryOfBgids(before, after map[string][]int64, idents []string, operator string) []*TargetHistory {
+	var lst []*TargetHistory
+	for _, ident := range idents {
+		oldValue := joinInt64s(before[ident])
+		newValue := joinInt64s(after[ident])
+		if oldValue != newValue {
+			lst = append(lst, NewTargetHistory(ident, TargetHistoryFieldBusiGroup, oldValue, newValue, operator))
+		}
+	}
+	return lst
+}
+
+func joinInt64s(ids []int64) string {
+	cp := make([]int64, len(ids))
+	copy(cp, ids)
+	sort.Slice(cp, func(i, j int) bool { return cp[i] < cp[j] })
+
+	arr := make([]string, 0, len(cp))
+	for _, id := range cp {
+		arr = append(arr, fmt.Sprint(id))
+	}
+	return strings.Join(arr, ",")
+}
+
+// TargetGroupIdsMapByIdents 批量查询机器所属的业务组，key: ident
+func TargetGroupIdsMapByIdents(ctx *ctx.Context, idents []string) (map[string][]int64, error) {
+	ret := make(map[string][]int64, len(idents))
+	if len(idents) == 0 {
+		return ret, nil
+	}
+
+	var lst []*TargetBusiGroup
+	err := DB(ctx).Where("target_ident in ?", idents).Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tg := range lst {
+		ret[tg.TargetIdent] = append(ret[tg.TargetIdent], tg.GroupId)
+	}
+	return ret, nil
+}
@@ -0,0 +1,24 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetHistoryOfBgids(t *testing.T) {
+	before := map[string][]int64{"a": {2, 1}, "b": {1}}
+	after := map[string][]int64{"a": {1, 2}, "b": {1, 3}, "c": {5}}
+
+	lst := models.TargetHistoryOfBgids(before, after, []string{"a", "b", "c"}, "root")
+	require.Len(t, lst, 2)
+	assert.Equal(t, "b", lst[0].Ident)
+	assert.Equal(t, "1", lst[0].OldValue)
+	assert.Equal(t, "1,3", lst[0].NewValue)
+	assert.Equal(t, "c", lst[1].Ident)
+	assert.Equal(t, "", lst[1].OldValue)
+	assert.Equal(t, "root", lst[1].Operator)
+}
@@ -0,0 +1,324 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/pkg/errors"
+	"github.com/toolkits/pkg/str"
+	"gorm.io/gorm"
+)
+
+// Tenant 租户，位于业务组之上。用户、团队、业务组、数据源、通知规则、通知媒介和消息模板都归属于某个租户，
+// tenant_id 为 0 表示平台自身。租户用户只能看到本租户的资源，tenant_id 为 0 的管理员是超级管理员，可以跨租户管理。
+// 通知媒介和消息模板中 tenant_id 为 0 的记录是公共资源，所有租户可读，只有超级管理员可以修改
+type Tenant struct {
+	Id          int64  `json:"id" gorm:"primaryKey"`
+	Name        string `json:"name" gorm:"type:varchar(191);not null;default:'';uniqueIndex"`
+	Note        string `json:"note" gorm:"type:varchar(1024);not null;default:''"`
+	RuleQuota   int64  `json:"rule_quota" gorm:"type:bigint;not null;default:0"`   // 告警规则数量上限，0 表示不限制
+	TargetQuota int64  `json:"target_quota" gorm:"type:bigint;not null;default:0"` // 监控对象数量上限，0 表示不限制
+	CreateAt    int64  `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	CreateBy    string `json:"create_by" gorm:"type:varchar(64);not null;default:''"`
+	UpdateAt    int64  `json:"update_at" gorm:"type:bigint;not null;default:0"`
+	UpdateBy    string `json:"update_by" gorm:"type:varchar(64);not null;default:''"`
+
+	RuleCount   int64 `json:"rule_count" gorm:"-"`
+	TargetCount int64 `json:"target_count" gorm:"-"`
+}
+
+func (t *Tenant) TableName() string {
+	return "tenant"
+}
+
+func (t *Tenant) Verify() error {
+	if t.Name == "" {
+		return errors.New("name is blank")
+	}
+
+	if str.Dangerous(t.Name) {
+		return errors.New("Name has invalid characters")
+	}
+
+	if str.Dangerous(t.Note) {
+		return errors.New("Note has invalid characters")
+	}
+
+	if t.RuleQuota < 0 || t.TargetQuota < 0 {
+		return errors.New("quota must not be negative")
+	}
+
+	return nil
+}
+
+func (t *Tenant) Add(ctx *ctx.Context) error {
+	if err := t.Verify(); err != nil {
+		return err
+	}
+
+	num, err := Count(DB(ctx).Model(&Tenant{}).Where("name = ?", t.Name))
+	if err != nil {
+		return errors.WithMessage(err, "failed to count tenant")
+	}
+
+	if num > 0 {
+		return errors.New("Tenant already exists")
+	}
+
+	now := time.Now().Unix()
+	t.CreateAt = now
+	t.UpdateAt = now
+	return Insert(ctx, t)
+}
+
+func (t *Tenant) Update(ctx *ctx.Context, ref Tenant) error {
+	if err := ref.Verify(); err != nil {
+		return err
+	}
+
+	if ref.Name != t.Name {
+		num, err := Count(DB(ctx).Model(&Tenant{}).Where("name = ? and id <> ?", ref.Name, t.Id))
+		if err != nil {
+			return errors.WithMessage(err, "failed to count tenant")
+		}
+
+		if num > 0 {
+			return errors.New("Tenant already exists")
+		}
+	}
+
+	t.Name = ref.Name
+	t.Note = ref.Note
+	t.RuleQuota = ref.RuleQuota
+	t.TargetQuota = ref.TargetQuota
+	t.UpdateAt = time.Now().Unix()
+	t.UpdateBy = ref.UpdateBy
+	return DB(ctx).Model(t).Select("name", "note", "rule_quota", "target_quota", "update_at", "update_by").Updates(t).Error
+}
+
+// tenantResource 带 tenant_id 列的表。updateAt 是更新时间的列名，
+// 移动资源时要一起更新，各个 memsto 缓存据此判断是否需要重新同步
+type tenantResource struct {
+	model    interface{}
+	updateAt string
+}
+
+var tenantResources = map[string]tenantResource{
+	"users":             {&User{}, "update_at"},
+	"user_groups":       {&UserGroup{}, "update_at"},
+	"busi_groups":       {&BusiGroup{}, "update_at"},
+	"datasources":       {&Datasource{}, "updated_at"},
+	"notify_rules":      {&NotifyRule{}, "update_at"},
+	"notify_channels":   {&NotifyChannelConfig{}, "update_at"},
+	"message_templates": {&MessageTemplate{}, "update_at"},
+}
+
+// Del 租户下还有资源时不允许删除
+func (t *Tenant) Del(ctx *ctx.Context) error {
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, res := range tenantResources {
+			num, err := Count(tx.Model(res.model).Where("tenant_id = ?", t.Id))
+			if err != nil {
+				return err
+			}
+
+			if num > 0 {
+				return errors.New("Some resources still belong to this tenant")
+			}
+		}
+
+		return tx.Where("id = ?", t.Id).Delete(&Tenant{}).Error
+	})
+}
+
+func TenantGet(ctx *ctx.Context, where string, args ...interface{}) (*Tenant, error) {
+	var lst []*Tenant
+	err := DB(ctx).Where(where, args...).Find(&lst).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lst) == 0 {
+		return nil, nil
+	}
+
+	return lst[0], nil
+}
+
+func TenantGetById(ctx *ctx.Context, id int64) (*Tenant, error) {
+	return TenantGet(ctx, "id = ?", id)
+}
+
+func TenantGets(ctx *ctx.Context, query string) ([]*Tenant, error) {
+	session := DB(ctx).Order("name")
+	if query != "" {
+		session = session.Where("name like ?", "%"+query+"%")
+	}
+
+	var lst []*Tenant
+	err := session.Find(&lst).Error
+	return lst, err
+}
+
+// TenantExists tid 为 0 表示平台自身，总是存在
+func TenantExists(ctx *ctx.Context, tid int64) (bool, error) {
+	if tid == 0 {
+		return true, nil
+	}
+
+	num, err := Count(DB(ctx).Model(&Tenant{}).Where("id = ?", tid))
+	return num > 0, err
+}
+
+// TenantBusiGroupIds 返回租户下的业务组
+func TenantBusiGroupIds(ctx *ctx.Context, tid int64) ([]int64, error) {
+	ids := make([]int64, 0)
+	err := DB(ctx).Model(&BusiGroup{}).Where("tenant_id = ?", tid).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// TenantRuleCount 租户下所有业务组里的告警规则数量
+func TenantRuleCount(ctx *ctx.Context, tid int64) (int64, error) {
+	sub := DB(ctx).Model(&BusiGroup{}).Select("id").Where("tenant_id = ?", tid)
+	return Count(DB(ctx).Model(&AlertRule{}).Where("group_id in (?)", sub))
+}
+
+// TenantTargetCount 租户下所有业务组里的监控对象数量，同一个对象属于多个业务组时只算一次
+func TenantTargetCount(ctx *ctx.Context, tid int64) (int64, error) {
+	sub := DB(ctx).Model(&BusiGroup{}).Select("id").Where("tenant_id = ?", tid)
+	var num int64
+	err := DB(ctx).Model(&TargetBusiGroup{}).Where("group_id in (?)", sub).Distinct("target_ident").Count(&num).Error
+	return num, err
+}
+
+// FillCount 填充租户当前的告警规则和监控对象数量
+func (t *Tenant) FillCount(ctx *ctx.Context) error {
+	var err error
+	if t.RuleCount, err = TenantRuleCount(ctx, t.Id); err != nil {
+		return err
+	}
+
+	t.TargetCount, err = TenantTargetCount(ctx, t.Id)
+	return err
+}
+
+// TenantCheckRuleQuota 检查租户再增加 n 条告警规则是否超过配额。tid 为 0 不限制
+func TenantCheckRuleQuota(ctx *ctx.Context, tid int64, n int) error {
+	if tid == 0 || n <= 0 {
+		return nil
+	}
+
+	t, err := TenantGetById(ctx, tid)
+	if err != nil || t == nil || t.RuleQuota == 0 {
+		return err
+	}
+
+	num, err := TenantRuleCount(ctx, tid)
+	if err != nil {
+		return err
+	}
+
+	if num+int64(n) > t.RuleQuota {
+		return errors.Errorf("alert rule quota of tenant %s exceeded: %d/%d", t.Name, num, t.RuleQuota)
+	}
+	return nil
+}
+
+// TenantCheckTargetQuota 检查把 idents 加入租户的业务组之后是否超过监控对象配额，已经在租户里的对象不重复计算
+func TenantCheckTargetQuota(ctx *ctx.Context, tid int64, idents []string) error {
+	if tid == 0 || len(idents) == 0 {
+		return nil
+	}
+
+	t, err := TenantGetById(ctx, tid)
+	if err != nil || t == nil || t.TargetQuota == 0 {
+		return err
+	}
+
+	sub := DB(ctx).Model(&BusiGroup{}).Select("id").Where("tenant_id = ?", tid)
+	var exists int64
+	err = DB(ctx).Model(&TargetBusiGroup{}).Where("group_id in (?) and target_ident in ?", sub, idents).
+		Distinct("target_ident").Count(&exists).Error
+	if err != nil {
+		return err
+	}
+
+	num, err := TenantTargetCount(ctx, tid)
+	if err != nil {
+		return err
+	}
+
+	if num+int64(len(idents))-exists > t.TargetQuota {
+		return errors.Errorf("target quota of tenant %s exceeded: %d/%d", t.Name, num, t.TargetQuota)
+	}
+	return nil
+}
+
+// TenantCheckTargetQuotaByBgids 把 idents 加入业务组 bgids 之前，逐个检查这些业务组所属租户的监控对象配额
+func TenantCheckTargetQuotaByBgids(ctx *ctx.Context, bgids []int64, idents []string) error {
+	if len(bgids) == 0 || len(idents) == 0 {
+		return nil
+	}
+
+	bgs, err := BusiGroupGetByIds(ctx, bgids)
+	if err != nil {
+		return err
+	}
+
+	checked := make(map[int64]struct{})
+	for _, bg := range bgs {
+		if _, has := checked[bg.TenantId]; has {
+			continue
+		}
+		checked[bg.TenantId] = struct{}{}
+
+		if err := TenantCheckTargetQuota(ctx, bg.TenantId, idents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TenantCheckOwner 确认 ids 对应的记录都属于租户 tid，用于团队成员、业务组授权这类不能跨租户的关联
+func TenantCheckOwner(ctx *ctx.Context, model interface{}, tid int64, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	num, err := Count(DB(ctx).Model(model).Where("id in ? and tenant_id <> ?", ids, tid))
+	if err != nil {
+		return err
+	}
+
+	if num > 0 {
+		return errors.New("cannot reference resources of another tenant")
+	}
+	return nil
+}
+
+// TenantAssign 把资源移动到租户 tid 下，tid 为 0 表示移回平台。resource 是 tenantResources 的 key
+func TenantAssign(ctx *ctx.Context, tid int64, resource string, ids []int64) error {
+	res, has := tenantResources[resource]
+	if !has {
+		return errors.Errorf("invalid resource: %s", resource)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	exists, err := TenantExists(ctx, tid)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return errors.New("No such tenant")
+	}
+
+	return DB(ctx).Model(res.model).Where("id in ?", ids).Updates(map[string]interface{}{
+		"tenant_id":  tid,
+		res.updateAt: time.Now().Unix(),
+	}).Error
+}
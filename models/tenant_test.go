@@ -0,0 +1,125 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTenantTestCtx(t *testing.T) *ctx.Context {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Tenant{}, &models.User{}, &models.UserGroup{}, &models.BusiGroup{},
+		&models.BusiGroupMember{}, &models.Datasource{}, &models.NotifyRule{}, &models.NotifyChannelConfig{},
+		&models.MessageTemplate{}, &models.AlertRule{}, &models.TargetBusiGroup{}, &models.Target{}))
+	return &ctx.Context{DB: db, IsCenter: true}
+}
+
+func TestTenantAddAndDel(t *testing.T) {
+	c := newTenantTestCtx(t)
+
+	tenant := &models.Tenant{Name: "sub-a", RuleQuota: 2}
+	require.NoError(t, tenant.Add(c))
+	assert.Error(t, (&models.Tenant{Name: "sub-a"}).Add(c))
+	assert.Error(t, (&models.Tenant{Name: "sub-b", TargetQuota: -1}).Add(c))
+
+	require.NoError(t, c.DB.Create(&models.BusiGroup{Name: "bg-a", TenantId: tenant.Id}).Error)
+	assert.Error(t, tenant.Del(c), "tenant with resources must not be deleted")
+
+	// 把业务组移回平台之后就可以删除了
+	var bg models.BusiGroup
+	require.NoError(t, c.DB.Where("name = ?", "bg-a").First(&bg).Error)
+	require.NoError(t, models.TenantAssign(c, 0, "busi_groups", []int64{bg.Id}))
+	require.NoError(t, tenant.Del(c))
+
+	assert.Error(t, models.TenantAssign(c, tenant.Id, "busi_groups", []int64{bg.Id}), "tenant is gone")
+	assert.Error(t, models.TenantAssign(c, 0, "boards", []int64{1}))
+}
+
+func TestTenantQuota(t *testing.T) {
+	c := newTenantTestCtx(t)
+
+	tenant := &models.Tenant{Name: "sub-a", RuleQuota: 2, TargetQuota: 2}
+	require.NoError(t, tenant.Add(c))
+
+	bg := &models.BusiGroup{Name: "bg-a", TenantId: tenant.Id}
+	require.NoError(t, c.DB.Create(bg).Error)
+	require.NoError(t, c.DB.Create(&models.BusiGroup{Name: "bg-platform"}).Error)
+
+	require.NoError(t, c.DB.Create(&models.AlertRule{GroupId: bg.Id, Name: "r1"}).Error)
+	require.NoError(t, models.TenantCheckRuleQuota(c, tenant.Id, 1))
+	assert.Error(t, models.TenantCheckRuleQuota(c, tenant.Id, 2))
+	// 平台不限制
+	assert.NoError(t, models.TenantCheckRuleQuota(c, 0, 100))
+
+	require.NoError(t, c.DB.Create(&models.TargetBusiGroup{TargetIdent: "host-1", GroupId: bg.Id}).Error)
+	require.NoError(t, models.TenantCheckTargetQuota(c, tenant.Id, []string{"host-2"}))
+	// 已经在租户里的对象不重复计算
+	require.NoError(t, models.TenantCheckTargetQuota(c, tenant.Id, []string{"host-1", "host-2"}))
+	assert.Error(t, models.TenantCheckTargetQuota(c, tenant.Id, []string{"host-2", "host-3"}))
+
+	require.NoError(t, tenant.FillCount(c))
+	assert.Equal(t, int64(1), tenant.RuleCount)
+	assert.Equal(t, int64(1), tenant.TargetCount)
+
+	// 心跳、CMDB 同步写业务组关联时同样受配额限制
+	var platform models.BusiGroup
+	require.NoError(t, c.DB.Where("name = ?", "bg-platform").First(&platform).Error)
+	assert.Error(t, models.TargetBindBgids(c, []string{"host-2", "host-3"}, []int64{bg.Id}, nil))
+	assert.Error(t, models.TargetOverrideBgids(c, []string{"host-2", "host-3"}, []int64{platform.Id, bg.Id}, nil))
+	require.NoError(t, models.TargetBindBgids(c, []string{"host-2", "host-3"}, []int64{platform.Id}, nil))
+	require.NoError(t, models.TargetBindBgids(c, []string{"host-2"}, []int64{bg.Id}, nil))
+	require.NoError(t, tenant.FillCount(c))
+	assert.Equal(t, int64(2), tenant.TargetCount)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	c := newTenantTestCtx(t)
+
+	bgA := &models.BusiGroup{Name: "bg-a", TenantId: 1}
+	bgB := &models.BusiGroup{Name: "bg-b", TenantId: 2}
+	require.NoError(t, c.DB.Create(bgA).Error)
+	require.NoError(t, c.DB.Create(bgB).Error)
+
+	adminA := &models.User{Username: "admin-a", RolesLst: []string{models.AdminRole}, TenantId: 1}
+	root := &models.User{Username: "root", RolesLst: []string{models.AdminRole}}
+
+	can, err := adminA.CanDoBusiGroup(c, bgA, "rw")
+	require.NoError(t, err)
+	assert.True(t, can)
+
+	can, err = adminA.CanDoBusiGroup(c, bgB)
+	require.NoError(t, err)
+	assert.False(t, can, "tenant admin must not reach another tenant")
+
+	can, err = root.CanDoBusiGroup(c, bgB, "rw")
+	require.NoError(t, err)
+	assert.True(t, can)
+
+	ids, err := adminA.VisibleBusiGroupIds(c)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{bgA.Id}, ids)
+
+	// 共用的通知媒介、消息模板对所有租户可见
+	assert.True(t, adminA.TenantShared(0))
+	assert.False(t, adminA.TenantVisible(0))
+	assert.False(t, adminA.TenantShared(2))
+
+	ugA := &models.UserGroup{Name: "ug-a", TenantId: 1}
+	require.NoError(t, c.DB.Create(ugA).Error)
+	require.NoError(t, c.DB.Create(&models.User{Username: "user-b", TenantId: 2}).Error)
+	var userB models.User
+	require.NoError(t, c.DB.Where("username = ?", "user-b").First(&userB).Error)
+
+	assert.NoError(t, models.TenantCheckOwner(c, &models.UserGroup{}, 1, []int64{ugA.Id}))
+	assert.Error(t, models.TenantCheckOwner(c, &models.User{}, 1, []int64{userB.Id}))
+}
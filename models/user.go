@@ -95,6 +95,13 @@ type User struct {
 	UserGroupsRes  []*UserGroupRes `json:"user_groups" gorm:"-"`
 	BusiGroupsRes  []*BusiGroupRes `json:"busi_groups" gorm:"-"`
 	LastActiveTime int64           `json:"last_active_time"`
+	Disabled       int             `json:"disabled"` // 1 表示已停用，不能登录和调用接口
+	// PasswordUpdateAt 最近一次修改密码的时间，为 0 时按创建时间计算密码有效期
+	PasswordUpdateAt int64 `json:"password_update_at"`
+	TenantId         int64 `json:"tenant_id"` // 所属租户，0 表示平台用户
+	// ScimExternalId IdP 下发的 externalId，IdP 没有提供时为 userName。非空表示用户由 SCIM 管理，
+	// 不复用 belong，SSO 登录会把 belong 改成 saml、oidc 等
+	ScimExternalId string `json:"scim_external_id"`
 }
 
 type UserGroupRes struct {
@@ -129,6 +136,51 @@ func (u *User) IsAdmin() bool {
 	return false
 }
 
+// IsSuperAdmin 平台的管理员，不属于任何租户，可以跨租户管理
+func (u *User) IsSuperAdmin() bool {
+	return u.TenantId == 0 && u.IsAdmin()
+}
+
+// TenantVisible 归属租户 tid 的资源对用户是否可见，平台用户不受租户限制
+func (u *User) TenantVisible(tid int64) bool {
+	return u.TenantId == 0 || u.TenantId == tid
+}
+
+// TenantShared 通知媒介、消息模板这类公共资源，tid 为 0 时所有租户都可见
+func (u *User) TenantShared(tid int64) bool {
+	return tid == 0 || u.TenantVisible(tid)
+}
+
+// TenantScope 作为 gorm scope 使用，租户用户只能查到本租户的数据
+func (u *User) TenantScope(db *gorm.DB) *gorm.DB {
+	if u.TenantId == 0 {
+		return db
+	}
+	return db.Where("tenant_id = ?", u.TenantId)
+}
+
+// VisibleBusiGroupIds 租户管理员返回本租户的全部业务组，其他用户返回所在团队有权限的业务组。
+// 超级管理员不限制业务组，调用方需要自行判断
+func (u *User) VisibleBusiGroupIds(ctx *ctx.Context) ([]int64, error) {
+	if u.TenantId == 0 {
+		return MyBusiGroupIds(ctx, u.Id)
+	}
+
+	if u.IsAdmin() {
+		return TenantBusiGroupIds(ctx, u.TenantId)
+	}
+
+	ids, err := MyBusiGroupIds(ctx, u.Id)
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+
+	// 防止历史数据里团队被授权了其他租户的业务组
+	ret := make([]int64, 0, len(ids))
+	err = DB(ctx).Model(&BusiGroup{}).Where("tenant_id = ? and id in ?", u.TenantId, ids).Pluck("id", &ret).Error
+	return ret, err
+}
+
 // has group permission
 func (u *User) CheckGroupPermission(ctx *ctx.Context, groupIds []int64) error {
 	if !u.IsAdmin() {
@@ -309,12 +361,30 @@ func (u *User) UpdateAllFields(ctx *ctx.Context) error {
 	return DB(ctx).Model(u).Select("*").Updates(u).Error
 }
 
+// UpdatePassword 修改密码，旧密码记入历史，用于检查密码重复使用
 func (u *User) UpdatePassword(ctx *ctx.Context, password, updateBy string) error {
-	return DB(ctx).Model(u).Updates(map[string]interface{}{
-		"password":  password,
-		"update_at": time.Now().Unix(),
-		"update_by": updateBy,
-	}).Error
+	now := time.Now().Unix()
+	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+		if u.Password != "" && u.Password != "******" && u.Password != password {
+			if err := addPasswordHistory(tx, u.Id, u.Password, now); err != nil {
+				return err
+			}
+		}
+
+		err := tx.Model(u).Updates(map[string]interface{}{
+			"password":           password,
+			"password_update_at": now,
+			"update_at":          now,
+			"update_by":          updateBy,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		u.Password = password
+		u.PasswordUpdateAt = now
+		return nil
+	})
 }
 
 func (u *User) AddToUserGroups(ctx *ctx.Context, userGroupIds []int64) error {
@@ -336,8 +406,11 @@ func UpdateUserLastActiveTime(ctx *ctx.Context, userId int64, lastActiveTime int
 	}).Error
 }
 
+// UserRevokeHook 用户被删除或停用之后调用，center 用它让用户已登录的会话立即失效
+var UserRevokeHook = func(ctx *ctx.Context, userIds []int64) {}
+
 func (u *User) Del(ctx *ctx.Context) error {
-	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+	err := DB(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Where("user_id=?", u.Id).Delete(&UserGroupMember{}).Error; err != nil {
 			return err
 		}
@@ -350,8 +423,58 @@ func (u *User) Del(ctx *ctx.Context) error {
 			return err
 		}
 
+		if err := tx.Where("user_id=?", u.Id).Delete(&UserTotp{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id=?", u.Id).Delete(&UserPasswordHistory{}).Error; err != nil {
+			return err
+		}
+
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	UserRevokeHook(ctx, []int64{u.Id})
+	return nil
+}
+
+// SetDisabled 停用用户时同时把用户移出所有团队，不再收到告警通知、不再参与值班
+func (u *User) SetDisabled(ctx *ctx.Context, disabled bool, updateBy string) error {
+	val := 0
+	if disabled {
+		val = 1
+	}
+
+	err := DB(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", u.Id).Updates(map[string]interface{}{
+			"disabled":  val,
+			"update_at": time.Now().Unix(),
+			"update_by": updateBy,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if disabled {
+			if err := tx.Where("user_id=?", u.Id).Delete(&UserGroupMember{}).Error; err != nil {
+				return err
+			}
+		}
+
+		u.Disabled = val
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		UserRevokeHook(ctx, []int64{u.Id})
+	}
+	return nil
 }
 
 func (u *User) ChangePassword(ctx *ctx.Context, oldpass, newpass string) error {
@@ -411,6 +534,29 @@ func UsersGet(ctx *ctx.Context, where string, args ...interface{}) ([]*User, err
 	return lst, nil
 }
 
+// UsersGetByPage 按条件分页查询用户，按 id 排序，同时返回总数
+func UsersGetByPage(ctx *ctx.Context, limit, offset int, where string, args ...interface{}) ([]*User, int64, error) {
+	var total int64
+	err := DB(ctx).Model(&User{}).Where(where, args...).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var lst []*User
+	err = DB(ctx).Where(where, args...).Order("id").Limit(limit).Offset(offset).Find(&lst).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, user := range lst {
+		user.RolesLst = strings.Fields(user.Roles)
+		user.Admin = user.IsAdmin()
+		user.DecryptPhone() // 解密手机号
+	}
+
+	return lst, total, nil
+}
+
 func UserMapGet(ctx *ctx.Context, where string, args ...interface{}) map[string]*User {
 	lst, err := UsersGet(ctx, where, args...)
 	if err != nil {
@@ -687,12 +833,21 @@ func PassLogin(ctx *ctx.Context, redis storage.Redis, username, pass string) (*U
 		return nil, fmt.Errorf("Username or password invalid")
 	}
 
+	if user.Disabled == 1 {
+		return nil, fmt.Errorf("User is disabled")
+	}
+
 	return user, nil
 }
 
-func UserTotal(ctx *ctx.Context, query string, stime, etime int64) (num int64, err error) {
+// UserTotal tenantId 大于 0 时只统计该租户的用户
+func UserTotal(ctx *ctx.Context, query string, stime, etime, tenantId int64) (num int64, err error) {
 	db := DB(ctx).Model(&User{})
 
+	if tenantId > 0 {
+		db = db.Where("tenant_id = ?", tenantId)
+	}
+
 	if stime != 0 && etime != 0 {
 		db = db.Where("last_active_time between ? and ?", stime, etime)
 	}
@@ -753,10 +908,14 @@ func validateOrderField(order string, defaultField string) string {
 }
 
 func UserGets(ctx *ctx.Context, query string, limit, offset int, stime, etime int64,
-	order string, desc bool, usernames, phones, emails []string) ([]User, error) {
+	order string, desc bool, usernames, phones, emails []string, tenantId int64) ([]User, error) {
 
 	session := DB(ctx)
 
+	if tenantId > 0 {
+		session = session.Where("tenant_id = ?", tenantId)
+	}
+
 	if stime != 0 && etime != 0 {
 		session = session.Where("last_active_time between ? and ?", stime, etime)
 	}
@@ -882,7 +1041,7 @@ func UserGetsBySso(ctx *ctx.Context, sso string) (map[string]*User, error) {
 }
 
 func UserDelByIds(ctx *ctx.Context, userIds []int64) error {
-	return DB(ctx).Transaction(func(tx *gorm.DB) error {
+	err := DB(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Where("user_id in ?", userIds).Delete(&UserGroupMember{}).Error; err != nil {
 			return err
 		}
@@ -891,11 +1050,26 @@ func UserDelByIds(ctx *ctx.Context, userIds []int64) error {
 			return err
 		}
 
+		if err := tx.Where("user_id in ?", userIds).Delete(&UserPasswordHistory{}).Error; err != nil {
+			return err
+		}
+
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	UserRevokeHook(ctx, userIds)
+	return nil
 }
 
 func (u *User) CanModifyUserGroup(ctx *ctx.Context, ug *UserGroup) (bool, error) {
+	// 其他租户的团队，管理员也不行
+	if !u.TenantVisible(ug.TenantId) {
+		return false, nil
+	}
+
 	// 我是管理员，自然可以
 	if u.IsAdmin() {
 		return true, nil
@@ -916,6 +1090,10 @@ func (u *User) CanModifyUserGroup(ctx *ctx.Context, ug *UserGroup) (bool, error)
 }
 
 func (u *User) CanDoBusiGroup(ctx *ctx.Context, bg *BusiGroup, permFlag ...string) (bool, error) {
+	if !u.TenantVisible(bg.TenantId) {
+		return false, nil
+	}
+
 	if u.IsAdmin() {
 		return true, nil
 	}
@@ -960,22 +1138,35 @@ func UserStatistics(ctx *ctx.Context) (*Statistics, error) {
 }
 
 func (u *User) NopriIdents(ctx *ctx.Context, idents []string) ([]string, error) {
-	if u.IsAdmin() {
+	if u.IsSuperAdmin() {
 		return []string{}, nil
 	}
 
-	ugids, err := MyGroupIds(ctx, u.Id)
-	if err != nil {
-		return []string{}, err
-	}
+	var (
+		bgids []int64
+		err   error
+	)
+	if u.IsAdmin() {
+		// 租户管理员可以操作本租户业务组里的对象
+		bgids, err = TenantBusiGroupIds(ctx, u.TenantId)
+		if err != nil {
+			return []string{}, err
+		}
+	} else {
+		var ugids []int64
+		ugids, err = MyGroupIds(ctx, u.Id)
+		if err != nil {
+			return []string{}, err
+		}
 
-	if len(ugids) == 0 {
-		return idents, nil
-	}
+		if len(ugids) == 0 {
+			return idents, nil
+		}
 
-	bgids, err := BusiGroupIds(ctx, ugids, "rw")
-	if err != nil {
-		return []string{}, err
+		bgids, err = BusiGroupIds(ctx, ugids, "rw")
+		if err != nil {
+			return []string{}, err
+		}
 	}
 
 	if len(bgids) == 0 {
@@ -997,7 +1188,7 @@ func (u *User) NopriIdents(ctx *ctx.Context, idents []string) ([]string, error)
 // 我是管理员，返回所有
 // 或者我是成员
 func (u *User) BusiGroups(ctx *ctx.Context, limit int, query string, all ...bool) ([]BusiGroup, error) {
-	session := DB(ctx).Order("name").Limit(limit)
+	session := DB(ctx).Scopes(u.TenantScope).Order("name").Limit(limit)
 
 	var lst []BusiGroup
 	if u.IsAdmin() || (len(all) > 0 && all[0]) {
@@ -1022,7 +1213,7 @@ func (u *User) BusiGroups(ctx *ctx.Context, limit int, query string, all ...bool
 			if err != nil {
 				return nil, err
 			}
-			err = DB(ctx).Order("name").Limit(limit).Where("id in ?", t.GroupIds).Find(&lst).Error
+			err = DB(ctx).Scopes(u.TenantScope).Order("name").Limit(limit).Where("id in ?", t.GroupIds).Find(&lst).Error
 		}
 
 		return lst, err
@@ -1071,7 +1262,7 @@ func (u *User) BusiGroups(ctx *ctx.Context, limit int, query string, all ...bool
 }
 
 func (u *User) UserGroups(ctx *ctx.Context, limit int, query string) ([]UserGroup, error) {
-	session := DB(ctx).Order("name").Limit(limit)
+	session := DB(ctx).Scopes(u.TenantScope).Order("name").Limit(limit)
 
 	var lst []UserGroup
 	if u.IsAdmin() {
@@ -1084,7 +1275,7 @@ func (u *User) UserGroups(ctx *ctx.Context, limit int, query string) ([]UserGrou
 		if len(lst) == 0 && len(query) > 0 {
 			// 隐藏功能，一般人不告诉，哈哈。query可能是给的用户名，所以上面的sql没有查到，当做user来查一下试试
 			user, err = UserGetByUsername(ctx, query)
-			if user == nil {
+			if user == nil || !u.TenantVisible(user.TenantId) {
 				return lst, err
 			}
 			var ids []int64
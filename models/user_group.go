@@ -15,6 +15,7 @@ type UserGroup struct {
 	Id               int64        `json:"id" gorm:"primaryKey"`
 	Name             string       `json:"name"`
 	Note             string       `json:"note"`
+	TenantId         int64        `json:"tenant_id"`
 	CreateAt         int64        `json:"create_at"`
 	CreateBy         string       `json:"create_by"`
 	UpdateAt         int64        `json:"update_at"`
@@ -128,6 +129,19 @@ func UserGroupIdAndNameMap(ctx *ctx.Context, ids []int64) (map[int64]string, err
 	return m, nil
 }
 
+// UserGroupGetsByPage 按条件分页查询团队，按 id 排序，同时返回总数
+func UserGroupGetsByPage(ctx *ctx.Context, limit, offset int, where string, args ...interface{}) ([]*UserGroup, int64, error) {
+	var total int64
+	err := DB(ctx).Model(&UserGroup{}).Where(where, args...).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var lst []*UserGroup
+	err = DB(ctx).Where(where, args...).Order("id").Limit(limit).Offset(offset).Find(&lst).Error
+	return lst, total, err
+}
+
 func UserGroupGetAll(ctx *ctx.Context) ([]*UserGroup, error) {
 	if !ctx.IsCenter {
 		lst, err := poster.GetByUrls[[]*UserGroup](ctx, "/v1/n9e/user-groups")
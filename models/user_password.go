@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"time"
+	"unicode"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"gorm.io/gorm"
+)
+
+// 每个用户最多保留的历史密码条数，超过配置的 HistoryCount 也没有意义
+const passwordHistoryKeep = 24
+
+// UserPasswordHistory 用户用过的密码，只存 CryptoPass 之后的值
+type UserPasswordHistory struct {
+	Id       int64  `json:"id" gorm:"primaryKey"`
+	UserId   int64  `json:"user_id" gorm:"type:bigint;not null;default:0;index"`
+	Password string `json:"-" gorm:"type:varchar(128);not null;default:''"`
+	CreateAt int64  `json:"create_at" gorm:"type:bigint;not null;default:0"`
+}
+
+func (h *UserPasswordHistory) TableName() string {
+	return "user_password_history"
+}
+
+func addPasswordHistory(tx *gorm.DB, userId int64, password string, now int64) error {
+	if err := tx.Create(&UserPasswordHistory{UserId: userId, Password: password, CreateAt: now}).Error; err != nil {
+		return err
+	}
+
+	var ids []int64
+	err := tx.Model(&UserPasswordHistory{}).Where("user_id = ?", userId).
+		Order("id desc").Offset(passwordHistoryKeep).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	return tx.Where("id in ?", ids).Delete(&UserPasswordHistory{}).Error
+}
+
+// PasswordCheck 检查明文密码的长度和包含的字符种类：大写字母、小写字母、数字、其他字符
+func PasswordCheck(plain string, minLength, minClasses int) error {
+	if minLength > 0 && len([]rune(plain)) < minLength {
+		return fmt.Errorf("password must be at least %d characters", minLength)
+	}
+
+	if minClasses <= 0 {
+		return nil
+	}
+
+	var upper, lower, digit, other int
+	for _, r := range plain {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	if upper+lower+digit+other < minClasses {
+		return fmt.Errorf("password must contain at least %d of uppercase letters, lowercase letters, digits and symbols", minClasses)
+	}
+	return nil
+}
+
+// PasswordReused 明文密码是否和当前密码或最近 count-1 次用过的密码相同，count <= 0 不检查
+func (u *User) PasswordReused(ctx *ctx.Context, plain string, count int) (bool, error) {
+	if count <= 0 {
+		return false, nil
+	}
+
+	pass, err := CryptoPass(ctx, plain)
+	if err != nil {
+		return false, err
+	}
+
+	if pass == u.Password {
+		return true, nil
+	}
+
+	if count == 1 {
+		return false, nil
+	}
+
+	var lst []string
+	err = DB(ctx).Model(&UserPasswordHistory{}).Where("user_id = ?", u.Id).
+		Order("id desc").Limit(count-1).Pluck("password", &lst).Error
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range lst {
+		if p == pass {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PasswordExpired 本地密码超过 maxAgeDays 天没有修改，maxAgeDays <= 0 表示永不过期
+func (u *User) PasswordExpired(maxAgeDays int) bool {
+	if maxAgeDays <= 0 || u.Password == "" || u.Password == "******" {
+		return false
+	}
+
+	since := u.PasswordUpdateAt
+	if since == 0 {
+		since = u.CreateAt
+	}
+	return time.Now().Unix()-since > int64(maxAgeDays)*86400
+}
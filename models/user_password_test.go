@@ -0,0 +1,85 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestPasswordCheck(t *testing.T) {
+	assert.NoError(t, models.PasswordCheck("a", 0, 0))
+	assert.Error(t, models.PasswordCheck("abc123", 8, 0))
+	assert.NoError(t, models.PasswordCheck("abcd1234", 8, 2))
+	assert.Error(t, models.PasswordCheck("abcd1234", 8, 3))
+	assert.NoError(t, models.PasswordCheck("Abcd1234", 8, 3))
+	assert.NoError(t, models.PasswordCheck("Abcd-1234", 8, 4))
+	// 按字符数而不是字节数计算长度
+	assert.Error(t, models.PasswordCheck("密码密码", 5, 0))
+}
+
+func TestPasswordExpired(t *testing.T) {
+	now := time.Now().Unix()
+	u := &models.User{Password: "x", CreateAt: now - 100*86400}
+	assert.False(t, u.PasswordExpired(0))
+	assert.True(t, u.PasswordExpired(90))
+
+	u.PasswordUpdateAt = now - 86400
+	assert.False(t, u.PasswordExpired(90))
+
+	// SSO 用户没有本地密码
+	sso := &models.User{Password: "******", Belong: "oidc", CreateAt: now - 100*86400}
+	assert.False(t, sso.PasswordExpired(90))
+}
+
+func TestPasswordHistory(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.UserPasswordHistory{}, &models.Configs{},
+		&models.UserGroupMember{}, &models.UserToken{}, &models.UserTotp{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	crypto := func(s string) string {
+		p, err := models.CryptoPass(c, s)
+		require.NoError(t, err)
+		return p
+	}
+
+	u := &models.User{Id: 1, Username: "alice", Password: crypto("pass-0"), Roles: "Standard", Contacts: []byte("{}")}
+	require.NoError(t, db.Create(u).Error)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, u.UpdatePassword(c, crypto(fmt.Sprintf("pass-%d", i)), "alice"))
+	}
+	assert.NotZero(t, u.PasswordUpdateAt)
+
+	reused, err := u.PasswordReused(c, "pass-3", 1)
+	require.NoError(t, err)
+	assert.True(t, reused, "current password")
+
+	reused, err = u.PasswordReused(c, "pass-1", 2)
+	require.NoError(t, err)
+	assert.False(t, reused, "only the current and the previous password are checked")
+
+	reused, err = u.PasswordReused(c, "pass-1", 3)
+	require.NoError(t, err)
+	assert.True(t, reused)
+
+	reused, err = u.PasswordReused(c, "pass-0", 0)
+	require.NoError(t, err)
+	assert.False(t, reused)
+
+	// 删除用户时一起删除历史密码
+	require.NoError(t, u.Del(c))
+	var n int64
+	require.NoError(t, db.Model(&models.UserPasswordHistory{}).Count(&n).Error)
+	assert.Zero(t, n)
+}
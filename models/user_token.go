@@ -1,18 +1,34 @@
 package models
 
 import (
+	"net"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"gorm.io/gorm"
 )
 
+// UserToken 用户的 API token。ExpireAt、IpAllowlist、Scopes、BusiGroupIds、ReadOnly
+// 用来签发最小权限的 token 给 CI 和机器人使用，都为空时 token 拥有用户的全部权限
 type UserToken struct {
-	Id        int64  `json:"id" gorm:"primaryKey"`
-	Username  string `json:"username" gorm:"type:varchar(255); not null; default ''"`
-	TokenName string `json:"token_name" gorm:"type:varchar(255); not null; default ''"`
-	Token     string `json:"token" gorm:"type:varchar(255); not null; default ''"`
-	CreateAt  int64  `json:"create_at" gorm:"type:bigint; not null; default 0"`
-	LastUsed  int64  `json:"last_used" gorm:"type:bigint; not null; default 0"`
+	Id           int64    `json:"id" gorm:"primaryKey"`
+	Username     string   `json:"username" gorm:"type:varchar(255); not null; default ''"`
+	TokenName    string   `json:"token_name" gorm:"type:varchar(255); not null; default ''"`
+	Token        string   `json:"token" gorm:"type:varchar(255); not null; default ''"`
+	CreateAt     int64    `json:"create_at" gorm:"type:bigint; not null; default 0"`
+	LastUsed     int64    `json:"last_used" gorm:"type:bigint; not null; default 0"`
+	ExpireAt     int64    `json:"expire_at" gorm:"type:bigint;not null;default:0"`          // 0 表示永不过期
+	IpAllowlist  []string `json:"ip_allowlist" gorm:"type:text;serializer:json"`            // IP 或 CIDR
+	Scopes       []string `json:"scopes" gorm:"type:text;serializer:json"`                  // 允许的权限点，取自 ops.yaml
+	BusiGroupIds []int64  `json:"busi_group_ids" gorm:"type:text;serializer:json"`          // 允许访问的业务组
+	ReadOnly     int      `json:"read_only" gorm:"type:int;not null;default:0"`             // 1 表示只能调用查询类接口
+	UseCount     int64    `json:"use_count" gorm:"type:bigint;not null;default:0"`          // 累计调用次数
+	DeniedCount  int64    `json:"denied_count" gorm:"type:bigint;not null;default:0"`       // 因过期、IP、只读限制被拒绝的次数
+	LastUsedIp   string   `json:"last_used_ip" gorm:"type:varchar(64);not null;default:''"` // 最后一次调用的来源 IP
+	UpdateAt     int64    `json:"update_at" gorm:"type:bigint;not null;default:0"`
 }
 
 func (UserToken) TableName() string {
@@ -26,15 +42,77 @@ func CountToken(ctx *ctx.Context, username string) (int64, error) {
 }
 
 func AddToken(ctx *ctx.Context, username, token, tokenName string) (*UserToken, error) {
-	newToken := UserToken{
-		TokenName: tokenName,
-		Username:  username,
-		Token:     token,
-		CreateAt:  time.Now().Unix(),
+	return AddTokenWithLimits(ctx, &UserToken{Username: username, Token: token, TokenName: tokenName})
+}
+
+// AddTokenWithLimits 创建带过期时间、IP 白名单或权限范围的 token
+func AddTokenWithLimits(ctx *ctx.Context, t *UserToken) (*UserToken, error) {
+	now := time.Now().Unix()
+	t.CreateAt = now
+	t.UpdateAt = now
+
+	err := Insert(ctx, t)
+	return t, err
+}
+
+// UpdateLimits 只更新限制条件，token 本身不变
+func (t *UserToken) UpdateLimits(ctx *ctx.Context) error {
+	t.UpdateAt = time.Now().Unix()
+	return DB(ctx).Model(t).Select("token_name", "expire_at", "ip_allowlist", "scopes", "busi_group_ids",
+		"read_only", "update_at").Updates(t).Error
+}
+
+func UserTokenGetById(ctx *ctx.Context, id int64) (*UserToken, error) {
+	var lst []*UserToken
+	err := DB(ctx).Where("id = ?", id).Find(&lst).Error
+	if err != nil || len(lst) == 0 {
+		return nil, err
+	}
+	return lst[0], nil
+}
+
+func (t *UserToken) Expired(now int64) bool {
+	return t.ExpireAt > 0 && now >= t.ExpireAt
+}
+
+// Restricted 有任何一项限制的 token 都不能用来管理 token，避免用受限 token 签发出不受限的 token
+func (t *UserToken) Restricted() bool {
+	return t.ExpireAt > 0 || len(t.IpAllowlist) > 0 || len(t.Scopes) > 0 || len(t.BusiGroupIds) > 0 || t.ReadOnly == 1
+}
+
+// IpAllowed 白名单为空时不限制来源
+func (t *UserToken) IpAllowed(ip string) bool {
+	if len(t.IpAllowlist) == 0 {
+		return true
 	}
 
-	err := Insert(ctx, &newToken)
-	return &newToken, err
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, item := range t.IpAllowlist {
+		if strings.Contains(item, "/") {
+			if _, cidr, err := net.ParseCIDR(item); err == nil && cidr.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if allowed := net.ParseIP(item); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeAllowed Scopes 为空时不限制权限点
+func (t *UserToken) ScopeAllowed(operation string) bool {
+	return len(t.Scopes) == 0 || slices.Contains(t.Scopes, operation)
+}
+
+// BusiGroupAllowed BusiGroupIds 为空时不限制业务组
+func (t *UserToken) BusiGroupAllowed(bgid int64) bool {
+	return len(t.BusiGroupIds) == 0 || slices.Contains(t.BusiGroupIds, bgid)
 }
 
 func DeleteToken(ctx *ctx.Context, id int64) error {
@@ -48,6 +126,21 @@ func GetTokensByUsername(ctx *ctx.Context, username string) ([]UserToken, error)
 	return tokens, err
 }
 
+// UserTokenGetUnrestricted 返回用户第一个不受限的 token，供需要代表用户调用接口的内部功能使用
+func UserTokenGetUnrestricted(ctx *ctx.Context, username string) (*UserToken, error) {
+	tokens, err := GetTokensByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		if !tokens[i].Restricted() {
+			return &tokens[i], nil
+		}
+	}
+	return nil, nil
+}
+
 func UserTokenGetAll(ctx *ctx.Context) ([]*UserToken, error) {
 	var lst []*UserToken
 	err := DB(ctx).Find(&lst).Error
@@ -60,6 +153,19 @@ func UserTokenTotal(ctx *ctx.Context) (int64, error) {
 	return total, err
 }
 
-func UserTokenUpdateLastUsedTime(ctx *ctx.Context, token string, lastUsedTime int64) error {
-	return DB(ctx).Model(&UserToken{}).Where("token = ?", token).Update("last_used", lastUsedTime).Error
+func UserTokenStatistics(ctx *ctx.Context) (*Statistics, error) {
+	return StatisticsGet(ctx, &UserToken{})
+}
+
+// UserTokenUpdateUsage 累加调用次数，记录最后一次调用的时间和来源
+func UserTokenUpdateUsage(ctx *ctx.Context, token string, lastUsed int64, lastUsedIp string, used, denied int64) error {
+	fields := map[string]interface{}{
+		"use_count":    gorm.Expr("use_count + ?", used),
+		"denied_count": gorm.Expr("denied_count + ?", denied),
+	}
+	if lastUsed > 0 {
+		fields["last_used"] = lastUsed
+		fields["last_used_ip"] = lastUsedIp
+	}
+	return DB(ctx).Model(&UserToken{}).Where("token = ?", token).Updates(fields).Error
 }
@@ -0,0 +1,89 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestUserTokenLimits(t *testing.T) {
+	ut := &models.UserToken{}
+	assert.False(t, ut.Restricted())
+	assert.False(t, ut.Expired(time.Now().Unix()))
+	assert.True(t, ut.IpAllowed("10.0.0.1"))
+	assert.True(t, ut.ScopeAllowed("/alert-rules/put"))
+	assert.True(t, ut.BusiGroupAllowed(1))
+
+	ut = &models.UserToken{
+		ExpireAt:     100,
+		IpAllowlist:  []string{"10.0.0.0/24", "192.168.1.1"},
+		Scopes:       []string{"/alert-rules"},
+		BusiGroupIds: []int64{1, 2},
+	}
+	assert.True(t, ut.Restricted())
+	assert.False(t, ut.Expired(99))
+	assert.True(t, ut.Expired(100))
+
+	assert.True(t, ut.IpAllowed("10.0.0.8"))
+	assert.True(t, ut.IpAllowed("192.168.1.1"))
+	assert.False(t, ut.IpAllowed("192.168.1.2"))
+	assert.False(t, ut.IpAllowed("not-an-ip"))
+
+	assert.True(t, ut.ScopeAllowed("/alert-rules"))
+	assert.False(t, ut.ScopeAllowed("/alert-rules/put"))
+
+	assert.True(t, ut.BusiGroupAllowed(2))
+	assert.False(t, ut.BusiGroupAllowed(3))
+
+	assert.True(t, (&models.UserToken{ReadOnly: 1}).Restricted())
+}
+
+func TestUserTokenStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.UserToken{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	restricted, err := models.AddTokenWithLimits(c, &models.UserToken{
+		Username: "root", Token: "t1", TokenName: "ci", ReadOnly: 1, Scopes: []string{"/dashboards"},
+	})
+	require.NoError(t, err)
+
+	// 受限 token 不能被内部功能拿去代表用户调用
+	got, err := models.UserTokenGetUnrestricted(c, "root")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = models.AddToken(c, "root", "t2", "full")
+	require.NoError(t, err)
+	got, err = models.UserTokenGetUnrestricted(c, "root")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "t2", got.Token)
+
+	restricted.Scopes = nil
+	restricted.IpAllowlist = []string{"10.0.0.1"}
+	require.NoError(t, restricted.UpdateLimits(c))
+
+	require.NoError(t, models.UserTokenUpdateUsage(c, "t1", 1000, "10.0.0.1", 3, 1))
+	require.NoError(t, models.UserTokenUpdateUsage(c, "t1", 0, "", 0, 2))
+
+	got, err = models.UserTokenGetById(c, restricted.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "t1", got.Token)
+	assert.Empty(t, got.Scopes)
+	assert.Equal(t, []string{"10.0.0.1"}, got.IpAllowlist)
+	assert.Equal(t, 1, got.ReadOnly)
+	assert.Equal(t, int64(3), got.UseCount)
+	assert.Equal(t, int64(3), got.DeniedCount)
+	assert.Equal(t, int64(1000), got.LastUsed)
+	assert.Equal(t, "10.0.0.1", got.LastUsedIp)
+}
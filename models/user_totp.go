@@ -0,0 +1,210 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/totp"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+// UserTotp 用户的 TOTP 两步验证。Enabled 为 0 时表示已生成密钥但还没有用验证码确认过
+type UserTotp struct {
+	UserId        int64  `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Secret        string `json:"-" gorm:"type:varchar(64);not null;default:''"`
+	Enabled       int    `json:"enabled" gorm:"type:int;not null;default:0"`
+	RecoveryCodes string `json:"-" gorm:"type:text"`                      // sha256 后逗号分隔，用过即删
+	LastStep      int64  `json:"-" gorm:"type:bigint;not null;default:0"` // 最后一次通过校验的时间片，防止验证码重放
+	CreateAt      int64  `json:"create_at" gorm:"type:bigint;not null;default:0"`
+	UpdateAt      int64  `json:"update_at" gorm:"type:bigint;not null;default:0"`
+}
+
+func (t *UserTotp) TableName() string {
+	return "user_totp"
+}
+
+func UserTotpGet(ctx *ctx.Context, userId int64) (*UserTotp, error) {
+	var lst []*UserTotp
+	err := DB(ctx).Where("user_id = ?", userId).Find(&lst).Error
+	if err != nil || len(lst) == 0 {
+		return nil, err
+	}
+	return lst[0], nil
+}
+
+// UserTotpEnroll 为用户生成新的密钥，需要再调用 Activate 确认后才生效。已启用的不能重新生成
+func UserTotpEnroll(ctx *ctx.Context, userId int64) (*UserTotp, error) {
+	t, err := UserTotpGet(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil && t.Enabled == 1 {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	if t == nil {
+		t = &UserTotp{UserId: userId, CreateAt: now}
+	}
+	t.Secret = secret
+	t.RecoveryCodes = ""
+	t.LastStep = 0
+	t.UpdateAt = now
+	return t, DB(ctx).Save(t).Error
+}
+
+func UserTotpDel(ctx *ctx.Context, userId int64) error {
+	return DB(ctx).Where("user_id = ?", userId).Delete(&UserTotp{}).Error
+}
+
+// Activate 用验证码确认密钥，启用两步验证并返回新的恢复码
+func (t *UserTotp) Activate(ctx *ctx.Context, code string) ([]string, error) {
+	if t.Enabled == 1 {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	ok, err := t.checkCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid verification code")
+	}
+
+	t.Enabled = 1
+	return t.ResetRecoveryCodes(ctx)
+}
+
+// ResetRecoveryCodes 生成新的恢复码，旧的全部作废。明文只在这里返回一次
+func (t *UserTotp) ResetRecoveryCodes(ctx *ctx.Context) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(buf)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	t.RecoveryCodes = strings.Join(hashes, ",")
+	t.UpdateAt = time.Now().Unix()
+	err := DB(ctx).Model(t).Select("enabled", "recovery_codes", "update_at").Updates(t).Error
+	return codes, err
+}
+
+func (t *UserTotp) RecoveryCodesLeft() int {
+	if t.RecoveryCodes == "" {
+		return 0
+	}
+	return len(strings.Split(t.RecoveryCodes, ","))
+}
+
+// Verify 校验验证码或恢复码，只对已启用的用户有效
+func (t *UserTotp) Verify(ctx *ctx.Context, code string) (bool, error) {
+	if t.Enabled != 1 {
+		return false, nil
+	}
+
+	ok, err := t.checkCode(ctx, code)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return t.useRecoveryCode(ctx, code)
+}
+
+// checkCode 校验 TOTP 验证码，同一时间片的验证码只能用一次
+func (t *UserTotp) checkCode(ctx *ctx.Context, code string) (bool, error) {
+	step, ok := totp.Validate(t.Secret, code, time.Now(), 1)
+	if !ok {
+		return false, nil
+	}
+
+	ret := DB(ctx).Model(&UserTotp{}).Where("user_id = ? and last_step < ?", t.UserId, step).Update("last_step", step)
+	if ret.Error != nil {
+		return false, ret.Error
+	}
+	if ret.RowsAffected == 0 {
+		return false, nil
+	}
+
+	t.LastStep = step
+	return true, nil
+}
+
+func (t *UserTotp) useRecoveryCode(ctx *ctx.Context, code string) (bool, error) {
+	hash := hashRecoveryCode(code)
+
+	var used bool
+	err := DB(ctx).Transaction(func(tx *gorm.DB) error {
+		var cur UserTotp
+		if err := tx.Where("user_id = ?", t.UserId).First(&cur).Error; err != nil {
+			return err
+		}
+
+		var left []string
+		for _, h := range strings.Split(cur.RecoveryCodes, ",") {
+			if h == hash && !used {
+				used = true
+				continue
+			}
+			if h != "" {
+				left = append(left, h)
+			}
+		}
+		if !used {
+			return nil
+		}
+
+		// 带上旧值做条件，避免并发请求重复使用同一个恢复码
+		ret := tx.Model(&UserTotp{}).Where("user_id = ? and recovery_codes = ?", t.UserId, cur.RecoveryCodes).
+			Update("recovery_codes", strings.Join(left, ","))
+		if ret.Error != nil {
+			return ret.Error
+		}
+		if ret.RowsAffected == 0 {
+			used = false
+			return nil
+		}
+		t.RecoveryCodes = strings.Join(left, ",")
+		return nil
+	})
+	return used, err
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// TotpRequiredByRoles 用户的角色中只要有一个要求两步验证，用户就必须启用
+func TotpRequiredByRoles(ctx *ctx.Context, roles []string) (bool, error) {
+	if len(roles) == 0 {
+		return false, nil
+	}
+
+	num, err := RoleCount(ctx, "name in ? and totp_required = 1", roles)
+	if err != nil {
+		return false, fmt.Errorf("failed to query roles: %v", err)
+	}
+	return num > 0, nil
+}
@@ -0,0 +1,100 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ctx"
+	"github.com/ccfos/nightingale/v6/pkg/totp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestUserTotp(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.UserTotp{}, &models.Role{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	ut, err := models.UserTotpEnroll(c, 1)
+	require.NoError(t, err)
+
+	// 未确认的密钥不能用于登录
+	code, err := totp.CodeAt(ut.Secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	ok, err := ut.Verify(c, code)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = ut.Activate(c, "000000")
+	assert.Error(t, err)
+
+	codes, err := ut.Activate(c, code)
+	require.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	ut, err = models.UserTotpGet(c, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ut.Enabled)
+	assert.Equal(t, 10, ut.RecoveryCodesLeft())
+
+	// 同一个验证码不能再用一次
+	ok, err = ut.Verify(c, code)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// 恢复码不区分大小写和连字符，只能用一次
+	ok, err = ut.Verify(c, codes[0])
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 9, ut.RecoveryCodesLeft())
+	ok, err = ut.Verify(c, codes[0])
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	stale, err := models.UserTotpGet(c, 1)
+	require.NoError(t, err)
+	stale.RecoveryCodes = ut.RecoveryCodes
+	ok, err = ut.Verify(c, codes[1][:5]+codes[1][6:])
+	require.NoError(t, err)
+	assert.True(t, ok)
+	// 并发请求持有的旧记录不能再用同一个恢复码
+	ok, err = stale.Verify(c, codes[1])
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = models.UserTotpEnroll(c, 1)
+	assert.Error(t, err, "enabled user can not enroll again")
+
+	require.NoError(t, models.UserTotpDel(c, 1))
+	ut, err = models.UserTotpGet(c, 1)
+	require.NoError(t, err)
+	assert.Nil(t, ut)
+}
+
+func TestTotpRequiredByRoles(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Role{}))
+	c := &ctx.Context{DB: db, IsCenter: true}
+
+	require.NoError(t, db.Create(&models.Role{Name: "Admin", TotpRequired: 1}).Error)
+	require.NoError(t, db.Create(&models.Role{Name: "Standard"}).Error)
+
+	required, err := models.TotpRequiredByRoles(c, []string{"Standard", "Admin"})
+	require.NoError(t, err)
+	assert.True(t, required)
+
+	required, err = models.TotpRequiredByRoles(c, []string{"Standard"})
+	require.NoError(t, err)
+	assert.False(t, required)
+
+	required, err = models.TotpRequiredByRoles(c, nil)
+	require.NoError(t, err)
+	assert.False(t, required)
+}
@@ -16,8 +16,15 @@ type WorkflowNode struct {
 	RetryOnFail    bool `json:"retry_on_fail,omitempty"`
 	MaxRetries     int  `json:"max_retries,omitempty"`
 	RetryInterval  int  `json:"retry_interval,omitempty"` // 秒
+	Timeout        int  `json:"timeout,omitempty"`        // 单次执行超时，秒，0 表示不限制
 }
 
+// 引擎需要特殊调度的节点类型
+const (
+	NodeTypeForEach = "logic.foreach" // 输出 0 连接的子分支按元素逐个执行
+	NodeTypeMerge   = "logic.merge"   // 等待所有上游分支结束后执行
+)
+
 // Connections 节点连接关系 map[源节点ID]NodeConnections
 type Connections map[string]NodeConnections
 
@@ -52,6 +59,36 @@ type NodeOutput struct {
 	// 流式输出支持
 	Stream     bool              `json:"stream,omitempty"` // 是否流式输出
 	StreamChan chan *StreamChunk `json:"-"`                // 流式数据通道（不序列化）
+
+	// foreach 节点返回，引擎对输出 0 连接的子分支逐个元素执行
+	ForEach *ForEachSpec `json:"-"`
+}
+
+// ForEachSpec foreach 节点的迭代描述
+type ForEachSpec struct {
+	Items       []interface{} // 待迭代的元素
+	ItemVar     string        // 当前元素写入子分支 Vars 的 key，下标写入 ItemVar + "_index"
+	Concurrency int           // 同时执行的元素个数
+	OutputVar   string        // 所有元素的执行结果（[]*ForEachItemResult）写入 Vars 的 key
+}
+
+// ForEachItemResult foreach 子分支中单个元素的执行结果
+type ForEachItemResult struct {
+	Index       int                    `json:"index"`
+	Item        interface{}            `json:"item"`
+	Status      string                 `json:"status"` // success, failed, terminated
+	Error       string                 `json:"error,omitempty"`
+	Vars        map[string]interface{} `json:"vars,omitempty"`        // 子分支执行结束时的 Vars
+	Annotations map[string]string      `json:"annotations,omitempty"` // 子分支执行结束时事件的注解
+}
+
+// MergeInput merge 节点的一路输入
+type MergeInput struct {
+	NodeID   string               `json:"node_id"`
+	NodeName string               `json:"node_name"`
+	Status   string               `json:"status"`
+	Message  string               `json:"message"`
+	Items    []*ForEachItemResult `json:"items,omitempty"` // 输入来自 foreach 子分支时，各元素的执行结果
 }
 
 // WorkflowResult 工作流执行结果
@@ -132,6 +169,14 @@ type WorkflowContext struct {
 	ParentCtx context.Context `json:"-"`
 }
 
+// Context 返回处理器发起查询、请求时使用的 context，节点超时后会被取消
+func (w *WorkflowContext) Context() context.Context {
+	if w.ParentCtx != nil {
+		return w.ParentCtx
+	}
+	return context.Background()
+}
+
 // StreamChunk 类型常量
 const (
 	StreamTypeThinking   = "thinking"    // AI 思考过程
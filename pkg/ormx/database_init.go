@@ -12,22 +12,25 @@ import (
 )
 
 type InitUser struct {
-	ID             uint64         `gorm:"primaryKey;autoIncrement"`
-	Username       string         `gorm:"size:64;not null;unique;comment:login name, cannot rename;uniqueIndex"`
-	Nickname       string         `gorm:"size:64;not null;comment:display name, chinese name"`
-	Password       string         `gorm:"size:128;not null;default:''"`
-	Phone          string         `gorm:"size:16;not null;default:''"`
-	Email          string         `gorm:"size:64;not null;default:''"`
-	Portrait       string         `gorm:"size:255;not null;default:'';comment:portrait image url"`
-	Roles          string         `gorm:"size:255;not null;comment:Admin | Standard | Guest, split by space"`
-	Contacts       sql.NullString `gorm:"size:1024;default null;comment:json e.g. {wecom:xx, dingtalk_robot_token:yy}"`
-	Maintainer     bool           `gorm:"type:tinyint(1);not null;default:0"`
-	Belong         string         `gorm:"size:16;not null;default:'';comment:belong"`
-	LastActiveTime int64          `gorm:"not null;default:0"`
-	CreateAt       int64          `gorm:"not null;default:0"`
-	CreateBy       string         `gorm:"size:64;not null;default:''"`
-	UpdateAt       int64          `gorm:"not null;default:0"`
-	UpdateBy       string         `gorm:"size:64;not null;default:''"`
+	ID               uint64         `gorm:"primaryKey;autoIncrement"`
+	Username         string         `gorm:"size:64;not null;unique;comment:login name, cannot rename;uniqueIndex"`
+	Nickname         string         `gorm:"size:64;not null;comment:display name, chinese name"`
+	Password         string         `gorm:"size:128;not null;default:''"`
+	Phone            string         `gorm:"size:16;not null;default:''"`
+	Email            string         `gorm:"size:64;not null;default:''"`
+	Portrait         string         `gorm:"size:255;not null;default:'';comment:portrait image url"`
+	Roles            string         `gorm:"size:255;not null;comment:Admin | Standard | Guest, split by space"`
+	Contacts         sql.NullString `gorm:"size:1024;default null;comment:json e.g. {wecom:xx, dingtalk_robot_token:yy}"`
+	Maintainer       bool           `gorm:"type:tinyint(1);not null;default:0"`
+	Belong           string         `gorm:"size:16;not null;default:'';comment:belong"`
+	LastActiveTime   int64          `gorm:"not null;default:0"`
+	Disabled         int            `gorm:"not null;default:0;comment:1 means disabled"`
+	PasswordUpdateAt int64          `gorm:"not null;default:0;comment:last time the password was changed"`
+	TenantId         int64          `gorm:"not null;default:0;comment:tenant id, 0 means platform"`
+	CreateAt         int64          `gorm:"not null;default:0"`
+	CreateBy         string         `gorm:"size:64;not null;default:''"`
+	UpdateAt         int64          `gorm:"not null;default:0"`
+	UpdateBy         string         `gorm:"size:64;not null;default:''"`
 }
 
 func (InitUser) TableName() string {
@@ -51,6 +54,7 @@ type InitPostgresUser struct {
 	Maintainer     int16          `gorm:"type:smallint;not null;default:0"`
 	Belong         string         `gorm:"size:16;not null;default:'';comment:belong"`
 	LastActiveTime int64          `gorm:"not null;default:0"`
+	Disabled       int            `gorm:"not null;default:0;comment:1 means disabled"`
 	CreateAt       int64          `gorm:"not null;default:0"`
 	CreateBy       string         `gorm:"size:64;not null;default:''"`
 	UpdateAt       int64          `gorm:"not null;default:0"`
@@ -65,6 +69,7 @@ type InitUserGroup struct {
 	ID       uint64 `gorm:"primaryKey;autoIncrement"`
 	Name     string `gorm:"size:128;not null;default:''"`
 	Note     string `gorm:"size:255;not null;default:''"`
+	TenantId int64  `gorm:"not null;default:0;comment:tenant id, 0 means platform"`
 	CreateAt int64  `gorm:"not null;default:0;index"`
 	CreateBy string `gorm:"size:64;not null;default:''"`
 	UpdateAt int64  `gorm:"not null;default:0;index"`
@@ -132,9 +137,10 @@ func (InitPostgresConfig) TableName() string {
 }
 
 type InitRole struct {
-	ID   uint64 `gorm:"primaryKey;autoIncrement"`
-	Name string `gorm:"size:191;not null;default:'';uniqueIdx"`
-	Note string `gorm:"size:255;not null;default:''"`
+	ID           uint64 `gorm:"primaryKey;autoIncrement"`
+	Name         string `gorm:"size:191;not null;default:'';uniqueIdx"`
+	Note         string `gorm:"size:255;not null;default:''"`
+	TotpRequired int    `gorm:"not null;default:0;comment:1 means two-factor authentication is required"`
 }
 
 func (InitRole) TableName() string {
@@ -164,6 +170,7 @@ type InitBusiGroup struct {
 	Name        string `gorm:"size:191;not null;uniqueIndex"`
 	LabelEnable bool   `gorm:"type:tinyint(1);not null;default:0"`
 	LabelValue  string `gorm:"size:191;not null;default:'';comment:if label_enable: label_value can not be blank"`
+	TenantId    int64  `gorm:"not null;default:0;comment:tenant id, 0 means platform"`
 	CreateAt    int64  `gorm:"not null;default:0"`
 	CreateBy    string `gorm:"size:64;not null;default:''"`
 	UpdateAt    int64  `gorm:"not null;default:0"`
@@ -1086,6 +1093,7 @@ type InitDatasource struct {
 	HTTP           string `gorm:"size:4096;not null;default:''"`
 	Auth           string `gorm:"size:8192;not null;default:''"`
 	IsDefault      bool   `gorm:"type:tinyint(1);not null;default:0"`
+	TenantId       int64  `gorm:"not null;default:0;comment:tenant id, 0 means platform"`
 	CreatedAt      int64  `gorm:"not null;default:0"`
 	CreatedBy      string `gorm:"size:64;not null;default:''"`
 	UpdatedAt      int64  `gorm:"not null;default:0"`
@@ -1570,6 +1578,14 @@ func sqliteDataBaseInit(db *gorm.DB) error {
 		{RoleName: "Standard", Operation: "/recording-rules/add"},
 		{RoleName: "Standard", Operation: "/recording-rules/put"},
 		{RoleName: "Standard", Operation: "/recording-rules/del"},
+		{RoleName: "Standard", Operation: "/scrape-jobs"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/add"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/put"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/del"},
+		{RoleName: "Standard", Operation: "/ingest-tokens"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/add"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/put"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/del"},
 	}
 
 	entries := []struct {
@@ -1762,6 +1778,14 @@ func mysqlDataBaseInit(db *gorm.DB) error {
 		{RoleName: "Standard", Operation: "/recording-rules/add"},
 		{RoleName: "Standard", Operation: "/recording-rules/put"},
 		{RoleName: "Standard", Operation: "/recording-rules/del"},
+		{RoleName: "Standard", Operation: "/scrape-jobs"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/add"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/put"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/del"},
+		{RoleName: "Standard", Operation: "/ingest-tokens"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/add"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/put"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/del"},
 	}
 
 	entries := []struct {
@@ -1955,6 +1979,14 @@ func postgresDataBaseInit(db *gorm.DB) error {
 		{RoleName: "Standard", Operation: "/recording-rules/add"},
 		{RoleName: "Standard", Operation: "/recording-rules/put"},
 		{RoleName: "Standard", Operation: "/recording-rules/del"},
+		{RoleName: "Standard", Operation: "/scrape-jobs"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/add"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/put"},
+		{RoleName: "Standard", Operation: "/scrape-jobs/del"},
+		{RoleName: "Standard", Operation: "/ingest-tokens"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/add"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/put"},
+		{RoleName: "Standard", Operation: "/ingest-tokens/del"},
 	}
 
 	entries := []struct {
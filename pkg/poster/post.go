@@ -502,6 +502,11 @@ func PostJSON(url string, timeout time.Duration, v interface{}, retries ...int)
 		return
 	}
 
+	return PostJSONBytes(url, timeout, bs, nil, retries...)
+}
+
+// PostJSONBytes 发送已经序列化好的 json，可附加请求头，用于需要对请求体签名的场景
+func PostJSONBytes(url string, timeout time.Duration, bs []byte, headers map[string]string, retries ...int) (response []byte, code int, err error) {
 	client := http.Client{
 		Timeout: timeout,
 	}
@@ -516,6 +521,9 @@ func PostJSON(url string, timeout time.Duration, v interface{}, retries ...int)
 			return nil, err
 		}
 		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
 		return req, nil
 	}
 
@@ -0,0 +1,60 @@
+package samlx
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const nsDsig = "http://www.w3.org/2000/09/xmldsig#"
+
+var errNotSigned = errors.New("not signed")
+
+// verifySignature checks the enveloped signature that is a direct child of el
+// and covers el as a whole, and returns the verified copy of el with the
+// signature removed. Only content of the returned element is covered by the
+// signature. Verification itself is done by goxmldsig, this only makes sure
+// the signature has the shape a SAML message is expected to have.
+func verifySignature(el *etree.Element, certs []*x509.Certificate, now time.Time) (*etree.Element, error) {
+	sigs := childrenNamed(el, nsDsig, "Signature")
+	if len(sigs) == 0 {
+		return nil, errNotSigned
+	}
+	if len(sigs) > 1 {
+		return nil, errors.New("more than one signature")
+	}
+
+	id := el.SelectAttrValue("ID", "")
+	if id == "" {
+		return nil, errors.New("signed element has no ID")
+	}
+	var refs []*etree.Element
+	if signedInfo := child(sigs[0], nsDsig, "SignedInfo"); signedInfo != nil {
+		refs = childrenNamed(signedInfo, nsDsig, "Reference")
+	}
+	if len(refs) != 1 {
+		return nil, errors.New("signature must have exactly one reference")
+	}
+	if refs[0].SelectAttrValue("URI", "") != "#"+id {
+		return nil, errors.New("signature reference does not point to the signed element")
+	}
+
+	// goxmldsig works on a copy of el, declare the namespaces inherited from
+	// the ancestors on it so that the copy canonicalizes the same way
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, el)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+	ctx.Clock = dsig.NewFakeClockAt(now)
+	return ctx.Validate(detached)
+}
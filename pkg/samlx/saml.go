@@ -0,0 +1,854 @@
+package samlx
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/storage"
+
+	"github.com/beevik/etree"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/toolkits/pkg/logger"
+)
+
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	bindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	bindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+
+	statusSuccess       = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	nameIdUnspecified   = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	signatureRSASHA256  = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	defaultClockSkewSec = 180
+
+	// 登录状态在 redis 中的有效期
+	requestTTL = 300 * time.Second
+	loginTTL   = 60 * time.Second
+)
+
+type Config struct {
+	Enable      bool
+	DisplayName string
+	// 前端完成登录的页面，ACS 校验通过后带上一次性 code 跳转过去
+	RedirectURL string
+	// SP 的 entity id 和 Assertion Consumer Service 地址，需要与 IdP 上登记的一致
+	EntityId string
+	AcsURL   string
+	// 配置了 IdpMetadataURL 时从中读取 IdP 的 entity id、登录地址和签名证书，下面显式配置的值优先
+	IdpMetadataURL string
+	IdpEntityId    string
+	IdpSsoURL      string
+	IdpCertificate string // PEM，证书轮换期间可以放多个
+	// SP 证书和私钥用于签名 AuthnRequest，证书会出现在 SP metadata 中
+	SpCertificate    string
+	SpPrivateKey     string
+	SignAuthnRequest bool
+	NameIdFormat     string
+	AllowedClockSkew int64 // 单位秒
+	SsoLogoutAddr    string
+	SkipTlsVerify    bool
+	CoverAttributes  bool
+	CoverRoles       bool
+	CoverTeams       bool
+	Attributes       struct {
+		Username string // 为空时使用 NameID
+		Nickname string
+		Phone    string
+		Email    string
+		Groups   string
+	}
+	DefaultRoles    []string
+	DefaultTeams    []int64
+	RoleTeamMapping []RoleTeamMapping
+}
+
+// RoleTeamMapping 按 Groups 属性中的值映射角色和团队
+type RoleTeamMapping struct {
+	Group string
+	Roles []string
+	Teams []int64
+}
+
+type SsoClient struct {
+	Enable          bool
+	DisplayName     string
+	SsoLogoutAddr   string
+	CoverAttributes bool
+	CoverRoles      bool
+	CoverTeams      bool
+	DefaultRoles    []string
+	DefaultTeams    []int64
+
+	config   Config
+	idpCerts []*x509.Certificate
+	spCert   *x509.Certificate
+	spKey    *rsa.PrivateKey
+	mappings map[string]RoleTeamMapping
+	sync.RWMutex
+}
+
+func New(cf Config) (*SsoClient, error) {
+	var s = &SsoClient{}
+	if !cf.Enable {
+		return s, nil
+	}
+	err := s.Reload(cf)
+	return s, err
+}
+
+func (s *SsoClient) Reload(cf Config) error {
+	if !cf.Enable {
+		s.Lock()
+		s.Enable = false
+		s.Unlock()
+		return nil
+	}
+
+	var idpCerts []*x509.Certificate
+	if cf.IdpMetadataURL != "" {
+		md, err := fetchIdpMetadata(cf.IdpMetadataURL, cf.SkipTlsVerify)
+		if err != nil {
+			return fmt.Errorf("failed to load idp metadata: %v", err)
+		}
+		if cf.IdpEntityId == "" {
+			cf.IdpEntityId = md.EntityId
+		}
+		if cf.IdpSsoURL == "" {
+			cf.IdpSsoURL = md.ssoURL()
+		}
+		certs, err := md.certificates()
+		if err != nil {
+			return err
+		}
+		idpCerts = append(idpCerts, certs...)
+	}
+
+	if cf.IdpCertificate != "" {
+		certs, err := parseCertificates(cf.IdpCertificate)
+		if err != nil {
+			return fmt.Errorf("invalid IdpCertificate: %v", err)
+		}
+		idpCerts = append(idpCerts, certs...)
+	}
+
+	if len(idpCerts) == 0 {
+		return errors.New("no idp certificate, IdpCertificate or IdpMetadataURL is required")
+	}
+	if cf.IdpSsoURL == "" {
+		return errors.New("IdpSsoURL is required")
+	}
+	if cf.EntityId == "" || cf.AcsURL == "" {
+		return errors.New("EntityId and AcsURL are required")
+	}
+
+	var spCert *x509.Certificate
+	if cf.SpCertificate != "" {
+		certs, err := parseCertificates(cf.SpCertificate)
+		if err != nil {
+			return fmt.Errorf("invalid SpCertificate: %v", err)
+		}
+		spCert = certs[0]
+	}
+
+	var spKey *rsa.PrivateKey
+	if cf.SpPrivateKey != "" {
+		key, err := parsePrivateKey(cf.SpPrivateKey)
+		if err != nil {
+			return fmt.Errorf("invalid SpPrivateKey: %v", err)
+		}
+		spKey = key
+	}
+	if cf.SignAuthnRequest && spKey == nil {
+		return errors.New("SpPrivateKey is required when SignAuthnRequest is true")
+	}
+
+	if cf.NameIdFormat == "" {
+		cf.NameIdFormat = nameIdUnspecified
+	}
+	if cf.AllowedClockSkew <= 0 {
+		cf.AllowedClockSkew = defaultClockSkewSec
+	}
+
+	mappings := make(map[string]RoleTeamMapping, len(cf.RoleTeamMapping))
+	for _, m := range cf.RoleTeamMapping {
+		mappings[m.Group] = m
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.Enable = true
+	s.DisplayName = cf.DisplayName
+	s.SsoLogoutAddr = cf.SsoLogoutAddr
+	s.CoverAttributes = cf.CoverAttributes
+	s.CoverRoles = cf.CoverRoles
+	s.CoverTeams = cf.CoverTeams
+	s.DefaultRoles = cf.DefaultRoles
+	s.DefaultTeams = cf.DefaultTeams
+	s.config = cf
+	s.idpCerts = idpCerts
+	s.spCert = spCert
+	s.spKey = spKey
+	s.mappings = mappings
+	return nil
+}
+
+func (s *SsoClient) GetDisplayName() string {
+	s.RLock()
+	defer s.RUnlock()
+	if !s.Enable {
+		return ""
+	}
+
+	return s.DisplayName
+}
+
+func (s *SsoClient) GetSsoLogoutAddr() string {
+	s.RLock()
+	defer s.RUnlock()
+	if !s.Enable {
+		return ""
+	}
+
+	return s.SsoLogoutAddr
+}
+
+type spMetadata struct {
+	XMLName       xml.Name `xml:"md:EntityDescriptor"`
+	XmlnsMd       string   `xml:"xmlns:md,attr"`
+	EntityId      string   `xml:"entityID,attr"`
+	SPSSODescptor struct {
+		AuthnRequestsSigned        bool             `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool             `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string           `xml:"protocolSupportEnumeration,attr"`
+		KeyDescriptor              *spKeyDescriptor `xml:"md:KeyDescriptor,omitempty"`
+		NameIdFormat               string           `xml:"md:NameIDFormat"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		} `xml:"md:AssertionConsumerService"`
+	} `xml:"md:SPSSODescriptor"`
+}
+
+type spKeyDescriptor struct {
+	Use     string `xml:"use,attr"`
+	KeyInfo struct {
+		XmlnsDs     string `xml:"xmlns:ds,attr"`
+		Certificate string `xml:"ds:X509Data>ds:X509Certificate"`
+	} `xml:"ds:KeyInfo"`
+}
+
+// Metadata 生成 SP metadata，导入到 IdP 中即可完成 SP 的登记
+func (s *SsoClient) Metadata() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if !s.Enable {
+		return nil, errors.New("saml is not enabled")
+	}
+
+	md := spMetadata{XmlnsMd: nsMetadata, EntityId: s.config.EntityId}
+	sp := &md.SPSSODescptor
+	sp.AuthnRequestsSigned = s.config.SignAuthnRequest
+	sp.WantAssertionsSigned = true
+	sp.ProtocolSupportEnumeration = nsProtocol
+	sp.NameIdFormat = s.config.NameIdFormat
+	sp.AssertionConsumerService.Binding = bindingHTTPPost
+	sp.AssertionConsumerService.Location = s.config.AcsURL
+	sp.AssertionConsumerService.Index = 1
+
+	if s.spCert != nil {
+		sp.KeyDescriptor = &spKeyDescriptor{Use: "signing"}
+		sp.KeyDescriptor.KeyInfo.XmlnsDs = nsDsig
+		sp.KeyDescriptor.KeyInfo.Certificate = base64.StdEncoding.EncodeToString(s.spCert.Raw)
+	}
+
+	bs, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), bs...), nil
+}
+
+type authnRequest struct {
+	XMLName                     xml.Name `xml:"samlp:AuthnRequest"`
+	XmlnsSamlp                  string   `xml:"xmlns:samlp,attr"`
+	XmlnsSaml                   string   `xml:"xmlns:saml,attr"`
+	Id                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      string   `xml:"saml:Issuer"`
+	NameIdPolicy                struct {
+		Format      string `xml:"Format,attr"`
+		AllowCreate bool   `xml:"AllowCreate,attr"`
+	} `xml:"samlp:NameIDPolicy"`
+}
+
+func wrapStateKey(key string) string {
+	return "n9e_saml_" + key
+}
+
+// Authorize 生成 HTTP-Redirect binding 的登录地址，开启 SignAuthnRequest 时按
+// binding 的要求对查询参数签名
+func (s *SsoClient) Authorize(redis storage.Redis, redirect string) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	req := authnRequest{
+		XmlnsSamlp:                  nsProtocol,
+		XmlnsSaml:                   nsAssertion,
+		Id:                          "_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Version:                     "2.0",
+		IssueInstant:                time.Now().UTC().Format(time.RFC3339),
+		Destination:                 s.config.IdpSsoURL,
+		AssertionConsumerServiceURL: s.config.AcsURL,
+		ProtocolBinding:             bindingHTTPPost,
+		Issuer:                      s.config.EntityId,
+	}
+	req.NameIdPolicy.Format = s.config.NameIdFormat
+	req.NameIdPolicy.AllowCreate = true
+
+	bs, err := xml.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	w.Write(bs)
+	w.Close()
+
+	query := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if s.config.SignAuthnRequest {
+		query += "&SigAlg=" + url.QueryEscape(signatureRSASHA256)
+		hashed := sha256.Sum256([]byte(query))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, s.spKey, crypto.SHA256, hashed[:])
+		if err != nil {
+			return "", err
+		}
+		query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(sig))
+	}
+
+	err = redis.Set(context.Background(), wrapStateKey(req.Id), redirect, requestTTL).Err()
+	if err != nil {
+		return "", err
+	}
+
+	sep := "?"
+	if strings.Contains(s.config.IdpSsoURL, "?") {
+		sep = "&"
+	}
+	return s.config.IdpSsoURL + sep + query, nil
+}
+
+type CallbackOutput struct {
+	Redirect string   `json:"redirect"`
+	Username string   `json:"username"`
+	Nickname string   `json:"nickname"`
+	Phone    string   `json:"phone"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles"`
+	Teams    []int64  `json:"teams"`
+}
+
+// Callback 校验 IdP POST 到 ACS 的 SAMLResponse，成功后把登录结果暂存到 redis，
+// 返回一次性 code，由前端通过 Exchange 换取
+func (s *SsoClient) Callback(redis storage.Redis, ctx context.Context, samlResponse string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(removeWhitespace(samlResponse))
+	if err != nil {
+		return "", fmt.Errorf("invalid SAMLResponse: %v", err)
+	}
+
+	a, err := s.validate(raw, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	// 只接受本实例发起的登录请求，请求只能用一次
+	redirect, err := redis.GetDel(ctx, wrapStateKey(a.inResponseTo)).Result()
+	if err != nil {
+		return "", fmt.Errorf("unknown or expired authn request %s: %v", a.inResponseTo, err)
+	}
+
+	// 防止同一个断言被重放
+	ttl := time.Until(a.expireAt) + time.Duration(s.clockSkew())*time.Second
+	if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	ok, err := redis.SetNX(ctx, wrapStateKey("assertion_"+a.id), 1, ttl).Result()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("assertion %s has already been used", a.id)
+	}
+
+	out := s.output(a)
+	out.Redirect = redirect
+
+	bs, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+
+	code := uuid.New().String()
+	if err := redis.Set(ctx, wrapStateKey("login_"+code), string(bs), loginTTL).Err(); err != nil {
+		return "", err
+	}
+
+	logger.Infof("saml login: username=%s, assertion=%s", out.Username, a.id)
+	return code, nil
+}
+
+// Exchange 用 Callback 返回的一次性 code 换取登录用户信息
+func (s *SsoClient) Exchange(rds storage.Redis, ctx context.Context, code string) (*CallbackOutput, error) {
+	val, err := rds.GetDel(ctx, wrapStateKey("login_"+code)).Result()
+	if err == redis.Nil {
+		return nil, errors.New("invalid or expired code")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out CallbackOutput
+	err = json.Unmarshal([]byte(val), &out)
+	return &out, err
+}
+
+// RedirectURL 前端完成登录的页面地址，附带 code 或 error 参数
+func (s *SsoClient) RedirectURL(key, value string) string {
+	s.RLock()
+	defer s.RUnlock()
+
+	u, err := url.Parse(s.config.RedirectURL)
+	if err != nil {
+		return s.config.RedirectURL
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (s *SsoClient) clockSkew() int64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.config.AllowedClockSkew
+}
+
+func (s *SsoClient) output(a *assertion) *CallbackOutput {
+	s.RLock()
+	defer s.RUnlock()
+
+	attrs := s.config.Attributes
+	out := &CallbackOutput{
+		Username: a.nameId,
+		Nickname: a.first(attrs.Nickname),
+		Phone:    a.first(attrs.Phone),
+		Email:    a.first(attrs.Email),
+	}
+	if attrs.Username != "" {
+		out.Username = a.first(attrs.Username)
+	}
+
+	roles := make(map[string]struct{})
+	teams := make(map[int64]struct{})
+	if attrs.Groups != "" {
+		for _, g := range a.attributes[attrs.Groups] {
+			m, has := s.mappings[g]
+			if !has {
+				continue
+			}
+			for _, r := range m.Roles {
+				if _, has := roles[r]; !has {
+					roles[r] = struct{}{}
+					out.Roles = append(out.Roles, r)
+				}
+			}
+			for _, t := range m.Teams {
+				if _, has := teams[t]; !has {
+					teams[t] = struct{}{}
+					out.Teams = append(out.Teams, t)
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+type assertion struct {
+	id           string
+	inResponseTo string
+	nameId       string
+	expireAt     time.Time
+	attributes   map[string][]string
+}
+
+func (a *assertion) first(name string) string {
+	if name == "" || len(a.attributes[name]) == 0 {
+		return ""
+	}
+	return a.attributes[name][0]
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+}
+
+// validate 校验 Response 和其中唯一的 Assertion。签名可以在 Response 上也可以在
+// Assertion 上，但只读取被签名覆盖的那个 Assertion 的内容，避免签名包装攻击
+func (s *SsoClient) validate(raw []byte, now time.Time) (*assertion, error) {
+	s.RLock()
+	cf := s.config
+	certs := s.idpCerts
+	enable := s.Enable
+	s.RUnlock()
+
+	if !enable {
+		return nil, errors.New("saml is not enabled")
+	}
+
+	root, err := parseXML(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse: %v", err)
+	}
+	if !is(root, nsProtocol, "Response") {
+		return nil, errors.New("not a SAML response")
+	}
+
+	ids := make(map[string]struct{})
+	var dup string
+	walk(root, func(e *etree.Element) {
+		if id := attr(e, "ID"); id != "" {
+			if _, has := ids[id]; has {
+				dup = id
+			}
+			ids[id] = struct{}{}
+		}
+	})
+	if dup != "" {
+		return nil, fmt.Errorf("duplicate ID %s", dup)
+	}
+
+	if d := attr(root, "Destination"); d != "" && d != cf.AcsURL {
+		return nil, fmt.Errorf("unexpected destination %s", d)
+	}
+	if issuer := child(root, nsAssertion, "Issuer"); issuer != nil && cf.IdpEntityId != "" &&
+		strings.TrimSpace(text(issuer)) != cf.IdpEntityId {
+		return nil, fmt.Errorf("unexpected issuer %s", strings.TrimSpace(text(issuer)))
+	}
+
+	status := child(root, nsProtocol, "Status")
+	if status == nil {
+		return nil, errors.New("missing status")
+	}
+	code := child(status, nsProtocol, "StatusCode")
+	if code == nil || attr(code, "Value") != statusSuccess {
+		msg := "unknown"
+		if code != nil {
+			msg = attr(code, "Value")
+			if sub := child(code, nsProtocol, "StatusCode"); sub != nil {
+				msg += " " + attr(sub, "Value")
+			}
+		}
+		if m := child(status, nsProtocol, "StatusMessage"); m != nil {
+			msg += ": " + strings.TrimSpace(text(m))
+		}
+		return nil, fmt.Errorf("idp returned status %s", msg)
+	}
+
+	if len(childrenNamed(root, nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported, disable assertion encryption on the idp")
+	}
+	assertions := childrenNamed(root, nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("expected exactly one assertion, got %d", len(assertions))
+	}
+	el := assertions[0]
+
+	// 之后只读取验签返回的副本，其中的内容都在签名覆盖范围内
+	responseSigned := false
+	if verified, err := verifySignature(root, certs, now); err == nil {
+		responseSigned = true
+		root = verified
+		el = child(root, nsAssertion, "Assertion")
+	} else if err != errNotSigned {
+		return nil, fmt.Errorf("invalid response signature: %v", err)
+	}
+	if verified, err := verifySignature(el, certs, now); err == errNotSigned {
+		if !responseSigned {
+			return nil, errors.New("neither the response nor the assertion is signed")
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid assertion signature: %v", err)
+	} else {
+		el = verified
+	}
+
+	a := &assertion{id: attr(el, "ID"), attributes: make(map[string][]string)}
+
+	issuer := child(el, nsAssertion, "Issuer")
+	if issuer == nil {
+		return nil, errors.New("missing assertion issuer")
+	}
+	if cf.IdpEntityId != "" && strings.TrimSpace(text(issuer)) != cf.IdpEntityId {
+		return nil, fmt.Errorf("unexpected assertion issuer %s", strings.TrimSpace(text(issuer)))
+	}
+
+	skew := time.Duration(cf.AllowedClockSkew) * time.Second
+	cond := child(el, nsAssertion, "Conditions")
+	if cond == nil {
+		return nil, errors.New("missing conditions")
+	}
+	if v := attr(cond, "NotBefore"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NotBefore: %v", err)
+		}
+		if now.Add(skew).Before(t) {
+			return nil, errors.New("assertion is not yet valid")
+		}
+	}
+	if v := attr(cond, "NotOnOrAfter"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NotOnOrAfter: %v", err)
+		}
+		if !now.Add(-skew).Before(t) {
+			return nil, errors.New("assertion has expired")
+		}
+		a.expireAt = t
+	}
+
+	restrictions := childrenNamed(cond, nsAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return nil, errors.New("missing audience restriction")
+	}
+	for _, r := range restrictions {
+		matched := false
+		for _, aud := range childrenNamed(r, nsAssertion, "Audience") {
+			if strings.TrimSpace(text(aud)) == cf.EntityId {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, errors.New("assertion is not intended for this service provider")
+		}
+	}
+
+	subject := child(el, nsAssertion, "Subject")
+	if subject == nil {
+		return nil, errors.New("missing subject")
+	}
+	if nameId := child(subject, nsAssertion, "NameID"); nameId != nil {
+		a.nameId = strings.TrimSpace(text(nameId))
+	}
+
+	confirmed := false
+	for _, sc := range childrenNamed(subject, nsAssertion, "SubjectConfirmation") {
+		if attr(sc, "Method") != confirmationBearer {
+			continue
+		}
+		data := child(sc, nsAssertion, "SubjectConfirmationData")
+		if data == nil || attr(data, "Recipient") != cf.AcsURL {
+			continue
+		}
+		t, err := parseTime(attr(data, "NotOnOrAfter"))
+		if err != nil || !now.Add(-skew).Before(t) {
+			continue
+		}
+
+		a.inResponseTo = attr(data, "InResponseTo")
+		if a.expireAt.IsZero() || t.Before(a.expireAt) {
+			a.expireAt = t
+		}
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return nil, errors.New("no valid bearer subject confirmation")
+	}
+	if a.inResponseTo == "" {
+		return nil, errors.New("idp initiated login is not supported")
+	}
+	if v := attr(root, "InResponseTo"); v != "" && v != a.inResponseTo {
+		return nil, errors.New("InResponseTo of response and assertion do not match")
+	}
+
+	for _, st := range childrenNamed(el, nsAssertion, "AttributeStatement") {
+		for _, at := range childrenNamed(st, nsAssertion, "Attribute") {
+			name := attr(at, "Name")
+			for _, v := range childrenNamed(at, nsAssertion, "AttributeValue") {
+				a.attributes[name] = append(a.attributes[name], strings.TrimSpace(text(v)))
+			}
+		}
+	}
+
+	return a, nil
+}
+
+type idpMetadata struct {
+	EntityId         string `xml:"entityID,attr"`
+	IDPSSODescriptor struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"SingleSignOnService"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+func (md *idpMetadata) ssoURL() string {
+	for _, sso := range md.IDPSSODescriptor.SingleSignOnServices {
+		if sso.Binding == bindingHTTPRedirect {
+			return sso.Location
+		}
+	}
+	return ""
+}
+
+func (md *idpMetadata) certificates() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, kd := range md.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use == "encryption" {
+			continue
+		}
+		for _, c := range kd.Certificates {
+			der, err := base64.StdEncoding.DecodeString(removeWhitespace(c))
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in idp metadata: %v", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in idp metadata: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+func fetchIdpMetadata(addr string, skipTlsVerify bool) (*idpMetadata, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if skipTlsVerify {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	resp, err := client.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	var md idpMetadata
+	if err := xml.Unmarshal(bs, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+func parseCertificates(s string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(strings.TrimSpace(s))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	// 兼容只填写了 base64 内容、没有 PEM 头尾的证书
+	if len(certs) == 0 {
+		der, err := base64.StdEncoding.DecodeString(removeWhitespace(s))
+		if err != nil {
+			return nil, errors.New("no certificate found")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func removeWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func parsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(s)))
+	if block == nil {
+		return nil, errors.New("no private key found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("only rsa private keys are supported")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,396 @@
+package samlx
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/beevik/etree"
+	"github.com/redis/go-redis/v9"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	testEntityId = "https://n9e.example.com/api/n9e/auth/saml/metadata"
+	testAcsURL   = "https://n9e.example.com/api/n9e/auth/callback/saml"
+	testIdp      = "https://idp.example.com"
+)
+
+type testIdP struct {
+	key     *rsa.PrivateKey
+	der     []byte
+	certPEM string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	return &testIdP{key: key, der: der, certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+}
+
+func newTestClient(t *testing.T, idp *testIdP) *SsoClient {
+	t.Helper()
+
+	cf := Config{
+		Enable:         true,
+		RedirectURL:    "https://n9e.example.com/callback/saml",
+		EntityId:       testEntityId,
+		AcsURL:         testAcsURL,
+		IdpEntityId:    testIdp,
+		IdpSsoURL:      testIdp + "/sso",
+		IdpCertificate: idp.certPEM,
+		RoleTeamMapping: []RoleTeamMapping{
+			{Group: "ops", Roles: []string{"Admin"}, Teams: []int64{1, 2}},
+			{Group: "dev", Roles: []string{"Standard"}, Teams: []int64{2}},
+		},
+	}
+	cf.Attributes.Email = "mail"
+	cf.Attributes.Groups = "groups"
+
+	s, err := New(cf)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	return s
+}
+
+type testResponse struct {
+	InResponseTo string
+	Audience     string
+	Recipient    string
+	NameId       string
+	NotOnOrAfter time.Time
+}
+
+func defaultResponse(inResponseTo string) testResponse {
+	return testResponse{
+		InResponseTo: inResponseTo,
+		Audience:     testEntityId,
+		Recipient:    testAcsURL,
+		NameId:       "alice",
+		NotOnOrAfter: time.Now().Add(5 * time.Minute),
+	}
+}
+
+// build 生成 Response，<!--sig:ID--> 是签名插入的位置
+func (r testResponse) build() string {
+	now := time.Now().UTC()
+	exp := r.NotOnOrAfter.UTC().Format(time.RFC3339)
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_resp" Version="2.0" IssueInstant="%s" Destination="%s" InResponseTo="%s">
+  <saml:Issuer>%s</saml:Issuer><!--sig:_resp-->
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+  <saml:Assertion ID="_assertion" Version="2.0" IssueInstant="%s">
+    <saml:Issuer>%s</saml:Issuer><!--sig:_assertion-->
+    <saml:Subject>
+      <saml:NameID>%s</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData InResponseTo="%s" Recipient="%s" NotOnOrAfter="%s"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="%s" NotOnOrAfter="%s">
+      <saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="mail"><saml:AttributeValue>alice@example.com</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="groups"><saml:AttributeValue>ops</saml:AttributeValue><saml:AttributeValue>dev</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`,
+		now.Format(time.RFC3339), testAcsURL, r.InResponseTo, testIdp, now.Format(time.RFC3339), testIdp,
+		r.NameId, r.InResponseTo, r.Recipient, exp, now.Add(-time.Minute).Format(time.RFC3339), exp, r.Audience)
+}
+
+// sign 用 idp 的密钥对 ID 为 id 的元素做 enveloped 签名，签名放在 <!--sig:id--> 处
+func sign(t *testing.T, idp *testIdP, doc, id string) string {
+	t.Helper()
+
+	root, err := parseXML([]byte(doc))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var el *etree.Element
+	walk(root, func(e *etree.Element) {
+		if attr(e, "ID") == id {
+			el = e
+		}
+	})
+	if el == nil {
+		t.Fatalf("element %s not found", id)
+	}
+
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		t.Fatalf("namespace context: %v", err)
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, el)
+	if err != nil {
+		t.Fatalf("detach: %v", err)
+	}
+	ctx, err := dsig.NewSigningContext(idp.key, [][]byte{idp.der})
+	if err != nil {
+		t.Fatalf("signing context: %v", err)
+	}
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	sig, err := ctx.ConstructSignature(detached, true)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	for i, tok := range el.Child {
+		if c, ok := tok.(*etree.Comment); ok && c.Data == "sig:"+id {
+			el.RemoveChildAt(i)
+			el.InsertChildAt(i, sig)
+			break
+		}
+	}
+	out := etree.NewDocument()
+	out.SetRoot(root)
+	str, err := out.WriteToString()
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return str
+}
+
+func requestId(t *testing.T, loginURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("parse login url: %v", err)
+	}
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatalf("decode SAMLRequest: %v", err)
+	}
+	bs, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("inflate SAMLRequest: %v", err)
+	}
+	m := regexp.MustCompile(` ID="([^"]+)"`).FindStringSubmatch(string(bs))
+	if m == nil {
+		t.Fatalf("no ID in AuthnRequest: %s", bs)
+	}
+	return m[1]
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newTestIdP(t)
+	s := newTestClient(t, idp)
+
+	mr := miniredis.RunT(t)
+	rds := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	loginURL, err := s.Authorize(rds, "/dashboards")
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if !strings.HasPrefix(loginURL, testIdp+"/sso?SAMLRequest=") {
+		t.Fatalf("unexpected login url: %s", loginURL)
+	}
+	reqId := requestId(t, loginURL)
+
+	doc := sign(t, idp, defaultResponse(reqId).build(), "_assertion")
+	samlResponse := base64.StdEncoding.EncodeToString([]byte(doc))
+
+	code, err := s.Callback(rds, ctx, samlResponse)
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+
+	// 同一个响应不能再次使用
+	if _, err := s.Callback(rds, ctx, samlResponse); err == nil {
+		t.Fatalf("replayed response should be rejected")
+	}
+
+	out, err := s.Exchange(rds, ctx, code)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if out.Username != "alice" || out.Email != "alice@example.com" || out.Redirect != "/dashboards" {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+	if strings.Join(out.Roles, ",") != "Admin,Standard" || fmt.Sprint(out.Teams) != "[1 2]" {
+		t.Fatalf("unexpected roles or teams: %v %v", out.Roles, out.Teams)
+	}
+
+	if _, err := s.Exchange(rds, ctx, code); err == nil {
+		t.Fatalf("code should only be used once")
+	}
+
+	md, err := s.Metadata()
+	if err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	if !bytes.Contains(md, []byte(`entityID="`+testEntityId+`"`)) || !bytes.Contains(md, []byte(`Location="`+testAcsURL+`"`)) {
+		t.Fatalf("unexpected metadata: %s", md)
+	}
+}
+
+func TestValidateSignedResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	s := newTestClient(t, idp)
+
+	doc := sign(t, idp, defaultResponse("_req").build(), "_resp")
+	a, err := s.validate([]byte(doc), time.Now())
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if a.nameId != "alice" || a.inResponseTo != "_req" {
+		t.Fatalf("unexpected assertion: %+v", a)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	idp := newTestIdP(t)
+	other := newTestIdP(t)
+	s := newTestClient(t, idp)
+
+	signed := func(r testResponse) string {
+		return sign(t, idp, r.build(), "_assertion")
+	}
+
+	cases := []struct {
+		name string
+		doc  func() string
+		now  time.Time
+	}{
+		{
+			name: "unsigned",
+			doc:  func() string { return defaultResponse("_req").build() },
+		},
+		{
+			name: "untrusted certificate",
+			doc:  func() string { return sign(t, other, defaultResponse("_req").build(), "_assertion") },
+		},
+		{
+			name: "tampered name id",
+			doc: func() string {
+				return strings.Replace(signed(defaultResponse("_req")), "<saml:NameID>alice<", "<saml:NameID>admin<", 1)
+			},
+		},
+		{
+			name: "wrong audience",
+			doc: func() string {
+				r := defaultResponse("_req")
+				r.Audience = "https://other.example.com"
+				return signed(r)
+			},
+		},
+		{
+			name: "wrong recipient",
+			doc: func() string {
+				r := defaultResponse("_req")
+				r.Recipient = "https://other.example.com/acs"
+				return signed(r)
+			},
+		},
+		{
+			name: "expired",
+			doc:  func() string { return signed(defaultResponse("_req")) },
+			now:  time.Now().Add(time.Hour),
+		},
+		{
+			name: "unsolicited",
+			doc:  func() string { return signed(defaultResponse("")) },
+		},
+		{
+			// 签名覆盖的 Assertion 之外再塞一个未签名的 Assertion
+			name: "injected assertion",
+			doc: func() string {
+				doc := signed(defaultResponse("_req"))
+				return strings.Replace(doc, "</samlp:Response>",
+					`<saml:Assertion ID="_evil"><saml:Subject><saml:NameID>admin</saml:NameID></saml:Subject></saml:Assertion></samlp:Response>`, 1)
+			},
+		},
+		{
+			name: "doctype",
+			doc: func() string {
+				return `<!DOCTYPE foo [<!ENTITY x "admin">]>` + signed(defaultResponse("_req"))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		now := c.now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		if _, err := s.validate([]byte(c.doc()), now); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
+
+// 签名使用 exclusive c14n，命名空间声明的位置和属性的写法变化不影响验签
+func TestValidateCanonicalization(t *testing.T) {
+	idp := newTestIdP(t)
+	s := newTestClient(t, idp)
+
+	doc := sign(t, idp, defaultResponse("_req").build(), "_assertion")
+	doc = strings.Replace(doc, `<saml:Assertion `,
+		`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:unused="urn:unused" `, 1)
+	doc = strings.Replace(doc, `Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"`, `Method='urn:oasis:names:tc:SAML:2.0:cm:bearer'`, 1)
+	a, err := s.validate([]byte(doc), time.Now())
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if a.nameId != "alice" {
+		t.Fatalf("unexpected assertion: %+v", a)
+	}
+
+	// 注释不能截断签名覆盖的值
+	doc = sign(t, idp, strings.Replace(defaultResponse("_req").build(), "<saml:NameID>alice<", "<saml:NameID>ali<!---->ce<", 1), "_assertion")
+	a, err = s.validate([]byte(doc), time.Now())
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if a.nameId != "alice" {
+		t.Fatalf("comment truncated name id: %q", a.nameId)
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	s := newTestClient(t, newTestIdP(t))
+
+	if err := s.Reload(Config{Enable: true, EntityId: testEntityId, AcsURL: testAcsURL, IdpSsoURL: testIdp + "/sso",
+		IdpCertificate: "not a certificate"}); err == nil {
+		t.Fatal("reload with a bad certificate should fail")
+	}
+
+	// a failed reload must leave the working config in place
+	if !s.Enable || s.clockSkew() != defaultClockSkewSec || len(s.idpCerts) != 1 {
+		t.Fatalf("config changed by a failed reload: enable=%v certs=%d", s.Enable, len(s.idpCerts))
+	}
+}
@@ -0,0 +1,83 @@
+package samlx
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// parseXML parses a SAML message into an etree document and returns its root
+// element.
+func parseXML(data []byte) (*etree.Element, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+
+	var root *etree.Element
+	for _, tok := range doc.Child {
+		switch t := tok.(type) {
+		case *etree.Directive:
+			// DTDs can define entities that change the signed content, never accept them
+			return nil, errors.New("xml directives are not allowed")
+		case *etree.Element:
+			if root != nil {
+				return nil, errors.New("multiple root elements")
+			}
+			root = t
+		}
+	}
+	if root == nil {
+		return nil, errors.New("incomplete xml document")
+	}
+	return root, nil
+}
+
+func is(e *etree.Element, ns, local string) bool {
+	return e.Tag == local && e.NamespaceURI() == ns
+}
+
+func childrenNamed(e *etree.Element, ns, local string) []*etree.Element {
+	var ret []*etree.Element
+	for _, el := range e.ChildElements() {
+		if is(el, ns, local) {
+			ret = append(ret, el)
+		}
+	}
+	return ret
+}
+
+func child(e *etree.Element, ns, local string) *etree.Element {
+	if lst := childrenNamed(e, ns, local); len(lst) > 0 {
+		return lst[0]
+	}
+	return nil
+}
+
+// text returns all character data inside e. etree's Text only returns the
+// data before the first child token, which a comment in the middle of a
+// value would truncate.
+func text(e *etree.Element) string {
+	var sb strings.Builder
+	for _, tok := range e.Child {
+		switch t := tok.(type) {
+		case *etree.CharData:
+			sb.WriteString(t.Data)
+		case *etree.Element:
+			sb.WriteString(text(t))
+		}
+	}
+	return sb.String()
+}
+
+func walk(e *etree.Element, fn func(*etree.Element)) {
+	fn(e)
+	for _, el := range e.ChildElements() {
+		walk(el, fn)
+	}
+}
+
+func attr(e *etree.Element, key string) string {
+	return e.SelectAttrValue(key, "")
+}
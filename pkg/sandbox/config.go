@@ -74,6 +74,11 @@ type Config struct {
 	// picks the strongest engine each host can actually provide.
 	RequireIsolation bool
 
+	// AllowUnsafePipelineScript lets the event pipeline script processor run on
+	// unsafe-exec. Pipeline scripts run unattended on every matching alert event,
+	// so unlike skills they refuse the fail-open floor unless explicitly allowed.
+	AllowUnsafePipelineScript bool
+
 	// ContainerAsBoundary lets auto degrade to the container-confined engine
 	// when userns is unavailable — an explicit operator acknowledgement that the
 	// outer container is the host boundary (§5.3 / 档 0.5). Without it, auto
@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodeAt(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for ts, want := range cases {
+		got, err := CodeAt(secret, Step(time.Unix(ts, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("time %d: got %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	prev, _ := CodeAt(secret, Step(now)-1)
+	if step, ok := Validate(secret, prev, now, 1); !ok || step != Step(now)-1 {
+		t.Fatalf("code of previous step should be accepted with skew 1: step=%d ok=%v", step, ok)
+	}
+	if _, ok := Validate(secret, prev, now, 0); ok {
+		t.Fatal("code of previous step should be rejected with skew 0")
+	}
+
+	cur, _ := CodeAt(secret, Step(now))
+	if _, ok := Validate(strings.ToLower(secret), cur[:3]+" "+cur[3:], now, 0); !ok {
+		t.Fatal("lower-case secret and spaced code should be accepted")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Fatal("short code should be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Night Ingale", "alice@example.com", "ABC")
+	want := "otpauth://totp/Night%20Ingale:alice@example.com?algorithm=SHA1&digits=6&issuer=Night+Ingale&period=30&secret=ABC"
+	if uri != want {
+		t.Fatalf("got %s", uri)
+	}
+}
@@ -0,0 +1,334 @@
+package aggr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/spaolacci/murmur3"
+	"github.com/toolkits/pkg/logger"
+)
+
+type PushFunc func(ts prompb.TimeSeries)
+
+// Aggregator 流式聚合：在写入 TSDB 之前按规则预聚合，替代反复查询 TSDB 的记录规则
+type Aggregator struct {
+	rules []*rule
+}
+
+func New(rules []*pconf.StreamAggrRule, push PushFunc) *Aggregator {
+	a := &Aggregator{}
+	for _, cfg := range rules {
+		a.rules = append(a.rules, newRule(cfg, push))
+	}
+	return a
+}
+
+// Start 每条规则按自己的窗口定时输出聚合结果
+func (a *Aggregator) Start() {
+	if a == nil {
+		return
+	}
+
+	for _, r := range a.rules {
+		go r.loop()
+	}
+}
+
+// Push 把序列交给匹配的规则，返回 true 表示原始序列不再写入
+func (a *Aggregator) Push(ts *prompb.TimeSeries) bool {
+	if a == nil || len(a.rules) == 0 {
+		return false
+	}
+
+	drop := false
+	for _, r := range a.rules {
+		if r.match(ts) {
+			r.push(ts)
+			drop = drop || r.cfg.DropInput
+		}
+	}
+	return drop
+}
+
+// input 一个输入序列在当前窗口的状态
+type input struct {
+	last     float64 // 窗口内最新值
+	prev     float64 // 上一个样本值，用于计算 counter 增量
+	hasPrev  bool
+	increase float64 // 窗口内的增量
+	seen     bool    // 本窗口是否出现
+	idle     int     // 连续没有出现的窗口数
+}
+
+// group 一条输出序列，对应一组保留下来的标签
+type group struct {
+	metric string
+	labels []prompb.Label
+	inputs map[uint64]*input
+}
+
+type rule struct {
+	sync.Mutex
+	cfg    *pconf.StreamAggrRule
+	suffix string
+	groups map[uint64]*group
+	emit   PushFunc
+	now    func() time.Time
+}
+
+func newRule(cfg *pconf.StreamAggrRule, emit PushFunc) *rule {
+	suffix := ":" + formatInterval(cfg.Interval)
+	if len(cfg.By) > 0 {
+		suffix += "_by_" + strings.Join(cfg.By, "_")
+	} else if len(cfg.Without) > 0 {
+		suffix += "_without_" + strings.Join(cfg.Without, "_")
+	}
+
+	return &rule{
+		cfg:    cfg,
+		suffix: suffix + "_",
+		groups: make(map[uint64]*group),
+		emit:   emit,
+		now:    time.Now,
+	}
+}
+
+func formatInterval(seconds int64) string {
+	switch {
+	case seconds%3600 == 0:
+		return fmt.Sprintf("%dh", seconds/3600)
+	case seconds%60 == 0:
+		return fmt.Sprintf("%dm", seconds/60)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
+
+func (r *rule) loop() {
+	for {
+		time.Sleep(time.Duration(r.cfg.Interval) * time.Second)
+		r.flush()
+	}
+}
+
+func (r *rule) match(ts *prompb.TimeSeries) bool {
+	for _, m := range r.cfg.Matchers {
+		var value string
+		for i := range ts.Labels {
+			if ts.Labels[i].Name == m.Name {
+				value = ts.Labels[i].Value
+				break
+			}
+		}
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// outputLabels 按 By/Without 取输出序列的标签，按标签名排序
+func (r *rule) outputLabels(ts *prompb.TimeSeries) []prompb.Label {
+	lbs := make([]prompb.Label, 0, len(ts.Labels))
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			continue
+		}
+		if len(r.cfg.By) > 0 {
+			if contains(r.cfg.By, l.Name) {
+				lbs = append(lbs, l)
+			}
+		} else if len(r.cfg.Without) > 0 {
+			if !contains(r.cfg.Without, l.Name) {
+				lbs = append(lbs, l)
+			}
+		}
+	}
+	sort.Slice(lbs, func(i, j int) bool { return lbs[i].Name < lbs[j].Name })
+	return lbs
+}
+
+func contains(lst []string, s string) bool {
+	for _, item := range lst {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) push(ts *prompb.TimeSeries) {
+	var metric string
+	var seriesHash uint64
+	for _, l := range ts.Labels {
+		if l.Name == "__name__" {
+			metric = l.Value
+		}
+		// 求和与标签顺序无关
+		seriesHash += murmur3.Sum64([]byte(l.Name + "\xff" + l.Value))
+	}
+
+	lbs := r.outputLabels(ts)
+	var builder strings.Builder
+	builder.WriteString(metric)
+	for _, l := range lbs {
+		builder.WriteString("\xff")
+		builder.WriteString(l.Name)
+		builder.WriteString("\xff")
+		builder.WriteString(l.Value)
+	}
+	groupHash := murmur3.Sum64([]byte(builder.String()))
+
+	r.Lock()
+	defer r.Unlock()
+
+	g, has := r.groups[groupHash]
+	if !has {
+		g = &group{metric: metric, labels: lbs, inputs: make(map[uint64]*input)}
+		r.groups[groupHash] = g
+	}
+
+	in, has := g.inputs[seriesHash]
+	if !has {
+		in = &input{}
+		g.inputs[seriesHash] = in
+	}
+
+	for _, sample := range ts.Samples {
+		// 跳过 stale marker
+		if math.IsNaN(sample.Value) {
+			continue
+		}
+		if in.hasPrev {
+			delta := sample.Value - in.prev
+			if delta < 0 {
+				// 计数器重置
+				delta = sample.Value
+			}
+			in.increase += delta
+		}
+		in.prev = sample.Value
+		in.hasPrev = true
+		in.last = sample.Value
+		in.seen = true
+	}
+}
+
+// flush 输出当前窗口的聚合结果，连续两个窗口没有出现的输入序列被清理
+func (r *rule) flush() {
+	timestamp := r.now().UnixMilli()
+	var out []prompb.TimeSeries
+
+	r.Lock()
+	for key, g := range r.groups {
+		values := make([]float64, 0, len(g.inputs))
+		var increase float64
+		for hash, in := range g.inputs {
+			if !in.seen {
+				in.idle++
+				if in.idle >= 2 {
+					delete(g.inputs, hash)
+				}
+				continue
+			}
+			values = append(values, in.last)
+			increase += in.increase
+			in.increase = 0
+			in.seen = false
+			in.idle = 0
+		}
+
+		if len(g.inputs) == 0 {
+			delete(r.groups, key)
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		for _, output := range r.cfg.Outputs {
+			name := g.metric + r.suffix + output
+			switch output {
+			case "quantiles":
+				sort.Float64s(values)
+				for _, phi := range r.cfg.Quantiles {
+					out = append(out, newSeries(name, g.labels, timestamp, quantile(phi, values),
+						prompb.Label{Name: "quantile", Value: fmt.Sprint(phi)}))
+				}
+			case "increase":
+				out = append(out, newSeries(name, g.labels, timestamp, increase))
+			case "rate":
+				out = append(out, newSeries(name, g.labels, timestamp, increase/float64(r.cfg.Interval)))
+			default:
+				out = append(out, newSeries(name, g.labels, timestamp, aggregate(output, values)))
+			}
+		}
+	}
+	r.Unlock()
+
+	logger.Debugf("stream aggr %s: emit %d series", r.cfg.Match, len(out))
+	for _, ts := range out {
+		r.emit(ts)
+	}
+}
+
+func newSeries(name string, lbs []prompb.Label, timestamp int64, value float64, extra ...prompb.Label) prompb.TimeSeries {
+	ts := prompb.TimeSeries{
+		Labels:  make([]prompb.Label, 0, len(lbs)+1+len(extra)),
+		Samples: []prompb.Sample{{Timestamp: timestamp, Value: value}},
+	}
+	ts.Labels = append(ts.Labels, prompb.Label{Name: "__name__", Value: name})
+	ts.Labels = append(ts.Labels, lbs...)
+	ts.Labels = append(ts.Labels, extra...)
+	return ts
+}
+
+func aggregate(output string, values []float64) float64 {
+	switch output {
+	case "count":
+		return float64(len(values))
+	case "min":
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+		return result
+	case "max":
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+		return result
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	if output == "avg" {
+		return sum / float64(len(values))
+	}
+	return sum
+}
+
+// quantile 与 PromQL quantile 相同的线性插值，values 已排序
+func quantile(phi float64, values []float64) float64 {
+	if phi < 0 {
+		return math.Inf(-1)
+	}
+	if phi > 1 {
+		return math.Inf(1)
+	}
+
+	rank := phi * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return values[lower]*(1-weight) + values[upper]*weight
+}
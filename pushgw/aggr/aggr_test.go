@@ -0,0 +1,119 @@
+package aggr
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+)
+
+func sample(value float64, lbs ...string) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{Samples: []prompb.Sample{{Value: value}}}
+	for i := 0; i+1 < len(lbs); i += 2 {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: lbs[i], Value: lbs[i+1]})
+	}
+	return ts
+}
+
+func newTestAggregator(cfg *pconf.StreamAggrRule) (*Aggregator, *[]prompb.TimeSeries) {
+	var out []prompb.TimeSeries
+	a := New([]*pconf.StreamAggrRule{cfg}, func(ts prompb.TimeSeries) { out = append(out, ts) })
+	a.rules[0].now = func() time.Time { return time.Unix(1700000000, 0) }
+	return a, &out
+}
+
+func result(out []prompb.TimeSeries) map[string]float64 {
+	m := make(map[string]float64)
+	for _, ts := range out {
+		key := ""
+		for _, l := range ts.Labels {
+			key += l.Name + "=" + l.Value + ","
+		}
+		m[key] = ts.Samples[0].Value
+	}
+	return m
+}
+
+func TestGaugeAggregation(t *testing.T) {
+	a, out := newTestAggregator(&pconf.StreamAggrRule{
+		Interval: 60,
+		By:       []string{"service"},
+		Outputs:  []string{"sum", "count", "max", "avg"},
+		Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "mem_used")},
+	})
+
+	assert.False(t, a.Push(sample(1, "__name__", "mem_used", "service", "api", "host", "a")))
+	a.Push(sample(3, "__name__", "mem_used", "service", "api", "host", "a")) // 同一序列取最新值
+	a.Push(sample(5, "__name__", "mem_used", "service", "api", "host", "b"))
+	a.Push(sample(7, "__name__", "mem_used", "service", "db", "host", "c"))
+	a.Push(sample(9, "__name__", "cpu_usage", "service", "db", "host", "c")) // 不匹配
+
+	a.rules[0].flush()
+	assert.Equal(t, map[string]float64{
+		"__name__=mem_used:1m_by_service_sum,service=api,":   8,
+		"__name__=mem_used:1m_by_service_count,service=api,": 2,
+		"__name__=mem_used:1m_by_service_max,service=api,":   5,
+		"__name__=mem_used:1m_by_service_avg,service=api,":   4,
+		"__name__=mem_used:1m_by_service_sum,service=db,":    7,
+		"__name__=mem_used:1m_by_service_count,service=db,":  1,
+		"__name__=mem_used:1m_by_service_max,service=db,":    7,
+		"__name__=mem_used:1m_by_service_avg,service=db,":    7,
+	}, result(*out))
+}
+
+func TestCounterAggregation(t *testing.T) {
+	a, out := newTestAggregator(&pconf.StreamAggrRule{
+		Interval:  60,
+		Without:   []string{"instance"},
+		Outputs:   []string{"increase", "rate"},
+		DropInput: true,
+		Matchers:  []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "requests_total")},
+	})
+
+	assert.True(t, a.Push(sample(100, "__name__", "requests_total", "instance", "a", "code", "200")))
+	a.Push(sample(160, "__name__", "requests_total", "instance", "a", "code", "200"))
+	a.Push(sample(10, "__name__", "requests_total", "instance", "b", "code", "200"))
+	a.Push(sample(30, "__name__", "requests_total", "instance", "b", "code", "200"))
+	a.rules[0].flush()
+	assert.Equal(t, map[string]float64{
+		"__name__=requests_total:1m_without_instance_increase,code=200,": 80,
+		"__name__=requests_total:1m_without_instance_rate,code=200,":     80.0 / 60,
+	}, result(*out))
+
+	// 计数器重置：增量为重置后的值
+	*out = nil
+	a.Push(sample(5, "__name__", "requests_total", "instance", "a", "code", "200"))
+	a.rules[0].flush()
+	assert.Equal(t, float64(5), result(*out)["__name__=requests_total:1m_without_instance_increase,code=200,"])
+
+	// 连续两个窗口没有样本的序列被清理
+	a.rules[0].flush()
+	a.rules[0].flush()
+	assert.Empty(t, a.rules[0].groups)
+}
+
+func TestQuantiles(t *testing.T) {
+	a, out := newTestAggregator(&pconf.StreamAggrRule{
+		Interval:  30,
+		Outputs:   []string{"quantiles"},
+		Quantiles: []float64{0.5, 0.9},
+		Matchers:  []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "latency")},
+	})
+
+	for i, v := range []float64{4, 1, 3, 2, 5} {
+		a.Push(sample(v, "__name__", "latency", "host", string(rune('a'+i))))
+	}
+	a.rules[0].flush()
+
+	values := make([]float64, 0)
+	for _, ts := range *out {
+		assert.Equal(t, "latency:30s_quantiles", ts.Labels[0].Value)
+		values = append(values, ts.Samples[0].Value)
+	}
+	sort.Float64s(values)
+	assert.Equal(t, []float64{3, 4.6}, values)
+}
@@ -0,0 +1,298 @@
+package cardinality
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+	"github.com/ccfos/nightingale/v6/pushgw/pstat"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	ScopeMetric = "metric"
+	ScopeIdent  = "ident"
+	ScopeTenant = "tenant"
+)
+
+var scopes = []string{ScopeMetric, ScopeIdent, ScopeTenant}
+
+// generation 一个统计窗口内的基数草图和丢弃计数
+type generation struct {
+	total   *hll
+	metrics map[string]*hll
+	labels  map[string]*hll
+	idents  map[string]*hll
+	tenants map[string]*hll
+	dropped map[string]map[string]uint64 // scope -> key -> 被丢弃的样本数
+}
+
+func newGeneration() *generation {
+	g := &generation{
+		total:   newHLL(),
+		metrics: make(map[string]*hll),
+		labels:  make(map[string]*hll),
+		idents:  make(map[string]*hll),
+		tenants: make(map[string]*hll),
+		dropped: make(map[string]map[string]uint64),
+	}
+	for _, scope := range scopes {
+		g.dropped[scope] = make(map[string]uint64)
+	}
+	return g
+}
+
+func observe(sketches map[string]*hll, key string, hash uint64) {
+	h, has := sketches[key]
+	if !has {
+		h = newHLL()
+		sketches[key] = h
+	}
+	h.add(hash)
+}
+
+// Tracker 估算活跃序列数并执行序列数限制。
+// 统计用 HyperLogLog，按窗口轮换，报告合并当前和上一个窗口；
+// 限制需要判断序列是否已存在，所以对配置了限制的维度精确记录序列哈希，内存随限制值线性增长
+type Tracker struct {
+	sync.Mutex
+	cfg       pconf.Cardinality
+	tenantKey string
+
+	cur  *generation
+	prev *generation
+
+	// scope -> key -> 序列哈希 -> 最近一次出现的时间
+	series map[string]map[string]map[uint64]int64
+
+	now func() time.Time
+}
+
+func New(cfg pconf.Cardinality, tenantKey string) *Tracker {
+	t := &Tracker{
+		cfg:       cfg,
+		tenantKey: tenantKey,
+		cur:       newGeneration(),
+		prev:      newGeneration(),
+		series:    make(map[string]map[string]map[uint64]int64),
+		now:       time.Now,
+	}
+	for _, scope := range scopes {
+		t.series[scope] = make(map[string]map[uint64]int64)
+	}
+	return t
+}
+
+func (t *Tracker) Enabled() bool {
+	return t != nil && t.cfg.Enable
+}
+
+// Loop 每个窗口轮换一次草图，并清理窗口内没有再出现的序列
+func (t *Tracker) Loop() {
+	if !t.Enabled() {
+		return
+	}
+
+	for {
+		time.Sleep(time.Duration(t.cfg.Window) * time.Second)
+		t.Rotate()
+	}
+}
+
+func (t *Tracker) Rotate() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.prev = t.cur
+	t.cur = newGeneration()
+
+	expire := t.now().Unix() - t.cfg.Window
+	for _, keys := range t.series {
+		for key, set := range keys {
+			for hash, lastSeen := range set {
+				if lastSeen < expire {
+					delete(set, hash)
+				}
+			}
+			if len(set) == 0 {
+				delete(keys, key)
+			}
+		}
+	}
+}
+
+// Limit 返回某个维度的序列数限制，0 表示不限制
+func (t *Tracker) Limit(scope, key string) int {
+	var limit int
+	var overrides map[string]int
+	switch scope {
+	case ScopeMetric:
+		limit, overrides = t.cfg.MaxSeriesPerMetric, t.cfg.Metrics
+	case ScopeIdent:
+		limit, overrides = t.cfg.MaxSeriesPerIdent, t.cfg.Idents
+	case ScopeTenant:
+		limit, overrides = t.cfg.MaxSeriesPerTenant, t.cfg.Tenants
+	}
+	if v, has := overrides[key]; has {
+		return v
+	}
+	return limit
+}
+
+// Admit 记录一条序列，返回 false 表示它是新序列且某个维度已达到限制，应当丢弃
+func (t *Tracker) Admit(ts *prompb.TimeSeries) bool {
+	if !t.Enabled() {
+		return true
+	}
+
+	var seriesHash uint64
+	var metric, ident, tenant string
+	labelHashes := make([]uint64, len(ts.Labels))
+	for i := range ts.Labels {
+		name, value := ts.Labels[i].Name, ts.Labels[i].Value
+		labelHashes[i] = murmur3.Sum64([]byte(name + "\xff" + value))
+		// 求和与标签顺序无关，AppendLabels 之后标签不一定有序
+		seriesHash += labelHashes[i]
+		switch name {
+		case "__name__":
+			metric = value
+		case "ident":
+			ident = value
+		case t.tenantKey:
+			tenant = value
+		}
+	}
+
+	keys := map[string]string{ScopeMetric: metric, ScopeIdent: ident, ScopeTenant: tenant}
+	now := t.now().Unix()
+
+	t.Lock()
+	defer t.Unlock()
+
+	// 被丢弃的序列也计入草图，便于在报告里找到基数暴涨的来源
+	t.cur.total.add(seriesHash)
+	for i := range ts.Labels {
+		if ts.Labels[i].Name != "__name__" {
+			observe(t.cur.labels, ts.Labels[i].Name, labelHashes[i])
+		}
+	}
+	if metric != "" {
+		observe(t.cur.metrics, metric, seriesHash)
+	}
+	if ident != "" {
+		observe(t.cur.idents, ident, seriesHash)
+	}
+	if tenant != "" {
+		observe(t.cur.tenants, tenant, seriesHash)
+	}
+
+	// 先检查所有维度，都通过后再记录，避免被丢弃的序列占用其他维度的名额
+	for _, scope := range scopes {
+		key := keys[scope]
+		limit := t.Limit(scope, key)
+		if key == "" || limit <= 0 {
+			continue
+		}
+		set := t.series[scope][key]
+		if _, has := set[seriesHash]; has || len(set) < limit {
+			continue
+		}
+		t.cur.dropped[scope][key]++
+		pstat.CounterCardinalityLimitTotal.WithLabelValues(scope).Inc()
+		return false
+	}
+
+	for _, scope := range scopes {
+		key := keys[scope]
+		if key == "" || t.Limit(scope, key) <= 0 {
+			continue
+		}
+		set, has := t.series[scope][key]
+		if !has {
+			set = make(map[uint64]int64)
+			t.series[scope][key] = set
+		}
+		set[seriesHash] = now
+	}
+	return true
+}
+
+type Item struct {
+	Name        string `json:"name"`
+	Cardinality uint64 `json:"cardinality"`       // 活跃序列数，标签维度为不同取值的个数
+	Limit       int    `json:"limit,omitempty"`   // 序列数限制
+	Dropped     uint64 `json:"dropped,omitempty"` // 超限被丢弃的样本数
+}
+
+type Report struct {
+	Window  int64  `json:"window"`
+	Series  uint64 `json:"series"`
+	Metrics []Item `json:"metrics"`
+	Labels  []Item `json:"labels"`
+	Idents  []Item `json:"idents"`
+	Tenants []Item `json:"tenants"`
+}
+
+// Report 返回最近一到两个窗口内基数最高的 topk 个指标、标签、机器和业务组
+func (t *Tracker) Report(topk int) Report {
+	report := Report{
+		Metrics: []Item{},
+		Labels:  []Item{},
+		Idents:  []Item{},
+		Tenants: []Item{},
+	}
+	if !t.Enabled() {
+		return report
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	total := newHLL()
+	total.merge(t.prev.total)
+	total.merge(t.cur.total)
+
+	report.Window = t.cfg.Window
+	report.Series = total.count()
+	report.Metrics = t.top(ScopeMetric, t.prev.metrics, t.cur.metrics, topk)
+	report.Labels = t.top("", t.prev.labels, t.cur.labels, topk)
+	report.Idents = t.top(ScopeIdent, t.prev.idents, t.cur.idents, topk)
+	report.Tenants = t.top(ScopeTenant, t.prev.tenants, t.cur.tenants, topk)
+	return report
+}
+
+func (t *Tracker) top(scope string, prev, cur map[string]*hll, topk int) []Item {
+	merged := make(map[string]*hll, len(cur))
+	for _, sketches := range []map[string]*hll{prev, cur} {
+		for key, h := range sketches {
+			if _, has := merged[key]; !has {
+				merged[key] = newHLL()
+			}
+			merged[key].merge(h)
+		}
+	}
+
+	items := make([]Item, 0, len(merged))
+	for key, h := range merged {
+		item := Item{Name: key, Cardinality: h.count()}
+		if scope != "" {
+			item.Limit = t.Limit(scope, key)
+			item.Dropped = t.prev.dropped[scope][key] + t.cur.dropped[scope][key]
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Cardinality != items[j].Cardinality {
+			return items[i].Cardinality > items[j].Cardinality
+		}
+		return items[i].Name < items[j].Name
+	})
+	if topk > 0 && len(items) > topk {
+		items = items[:topk]
+	}
+	return items
+}
@@ -0,0 +1,68 @@
+package cardinality
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/spaolacci/murmur3"
+	"github.com/stretchr/testify/assert"
+)
+
+func series(name, ident string, labels ...string) *prompb.TimeSeries {
+	ts := &prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: name}, {Name: "ident", Value: ident}}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: labels[i], Value: labels[i+1]})
+	}
+	return ts
+}
+
+func TestHLLCount(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		h := newHLL()
+		for i := 0; i < n; i++ {
+			h.add(murmur3.Sum64([]byte(fmt.Sprint(i))))
+		}
+		assert.InDelta(t, float64(n), float64(h.count()), float64(n)*0.1, "n=%d", n)
+	}
+}
+
+func TestAdmitIdentLimit(t *testing.T) {
+	tracker := New(pconf.Cardinality{Enable: true, Window: 3600, MaxSeriesPerIdent: 2}, "busigroup")
+
+	assert.True(t, tracker.Admit(series("cpu", "host1", "cpu", "0")))
+	assert.True(t, tracker.Admit(series("cpu", "host1", "cpu", "1")))
+	// 新序列超限被丢弃，已有序列和其他机器不受影响
+	assert.False(t, tracker.Admit(series("cpu", "host1", "cpu", "2")))
+	assert.True(t, tracker.Admit(series("cpu", "host1", "cpu", "0")))
+	assert.True(t, tracker.Admit(series("cpu", "host2", "cpu", "2")))
+
+	report := tracker.Report(10)
+	assert.Equal(t, "host1", report.Idents[0].Name)
+	assert.Equal(t, uint64(3), report.Idents[0].Cardinality)
+	assert.Equal(t, uint64(1), report.Idents[0].Dropped)
+	assert.Equal(t, 2, report.Idents[0].Limit)
+	assert.Equal(t, uint64(4), report.Series)
+}
+
+func TestRotateExpiresSeries(t *testing.T) {
+	tracker := New(pconf.Cardinality{Enable: true, Window: 60, Metrics: map[string]int{"req": 1}}, "busigroup")
+	now := time.Unix(1700000000, 0)
+	tracker.now = func() time.Time { return now }
+
+	assert.True(t, tracker.Admit(series("req", "host1", "request_id", "a")))
+	assert.False(t, tracker.Admit(series("req", "host1", "request_id", "b")))
+	assert.True(t, tracker.Admit(series("other", "host1", "request_id", "b")))
+
+	// 窗口内没有再出现的序列不再占用名额
+	now = now.Add(2 * time.Minute)
+	tracker.Rotate()
+	assert.True(t, tracker.Admit(series("req", "host1", "request_id", "b")))
+
+	report := tracker.Report(1)
+	assert.Len(t, report.Labels, 1)
+	assert.Equal(t, "request_id", report.Labels[0].Name)
+	assert.Equal(t, uint64(2), report.Labels[0].Cardinality)
+}
@@ -0,0 +1,61 @@
+package cardinality
+
+import (
+	"math"
+	"math/bits"
+)
+
+// hllPrecision 2^10 个寄存器，每个草图 1KB，标准误差约 3.25%
+const (
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+)
+
+// hll HyperLogLog 基数估算，输入为 64 位哈希
+type hll struct {
+	registers [hllRegisters]uint8
+}
+
+func newHLL() *hll {
+	return &hll{}
+}
+
+func (h *hll) add(hash uint64) {
+	index := hash >> (64 - hllPrecision)
+	// 剩余位的前导零个数 + 1，最后补一个哨兵位避免全零时越界
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hll) merge(other *hll) {
+	if other == nil {
+		return
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *hll) count() uint64 {
+	m := float64(hllRegisters)
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// 小基数时用线性计数修正
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
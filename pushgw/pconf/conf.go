@@ -11,6 +11,8 @@ import (
 	"github.com/ccfos/nightingale/v6/pkg/tlsx"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 )
 
 type Pushgw struct {
@@ -40,6 +42,15 @@ type Pushgw struct {
 	// 降到 max(latency)，同时缩短 in-flight slot 持有时间、缓解慢 writer 拖累健康 writer。
 	ProxyConcurrentForward bool
 
+	// IngestTokenRequired 为 true 时写入接口只接受业务组写入令牌，全局 basic auth 不再放行写入
+	IngestTokenRequired bool
+
+	// Cardinality 活跃序列数统计与限制
+	Cardinality Cardinality
+
+	// StreamAggr 流式聚合规则，在写入前按窗口预聚合
+	StreamAggr []*StreamAggrRule
+
 	LabelRewrite     bool
 	ForceUseServerTS bool
 	DebugSample      map[string]string
@@ -49,6 +60,39 @@ type Pushgw struct {
 	KafkaWriters     []KafkaWriterOptions
 }
 
+// Cardinality 用 HyperLogLog 估算每个指标名、标签名、机器（ident 标签）和业务组（BusiGroupLabelKey 标签）
+// 的活跃序列数；达到限制后丢弃新序列，已有序列继续写入
+type Cardinality struct {
+	Enable             bool
+	Window             int64          // 活跃序列的统计窗口，秒，默认 3600
+	MaxSeriesPerMetric int            // 单个指标名的序列数上限，0 表示不限制
+	MaxSeriesPerIdent  int            // 单个机器的序列数上限
+	MaxSeriesPerTenant int            // 单个业务组的序列数上限
+	Metrics            map[string]int // 按指标名单独设置上限
+	Idents             map[string]int // 按机器单独设置上限
+	Tenants            map[string]int // 按业务组单独设置上限
+}
+
+// StreamAggrRule 流式聚合规则：匹配的序列按 Interval 窗口聚合，结果作为新序列写入 writers，
+// 序列名为 <原指标名>:<窗口>_by_<标签>_<输出>，例如 http_requests_total:1m_by_service_rate。
+// sum/count/min/max/avg/quantiles 基于每个输入序列在窗口内的最新值计算，
+// increase/rate 用于 counter，按每个输入序列的增量求和，会处理计数器重置
+type StreamAggrRule struct {
+	Match     string    // 序列选择器，如 http_requests_total{env="prod"}
+	Interval  int64     // 聚合窗口，秒，默认 60
+	By        []string  // 只保留这些标签，和 Without 都不配置时聚合成一条序列
+	Without   []string  // 去掉这些标签
+	Outputs   []string  // sum count min max avg quantiles increase rate
+	Quantiles []float64 // Outputs 包含 quantiles 时计算的分位数，默认 0.5 0.9 0.99
+	DropInput bool      // 丢弃匹配的原始序列，只写入聚合结果
+
+	Matchers []*labels.Matcher `json:"-"`
+}
+
+var streamAggrOutputs = map[string]struct{}{
+	"sum": {}, "count": {}, "min": {}, "max": {}, "avg": {}, "quantiles": {}, "increase": {}, "rate": {},
+}
+
 type WriterGlobalOpt struct {
 	QueueMaxSize            int
 	QueuePopSize            int
@@ -199,6 +243,40 @@ func (p *Pushgw) PreCheck() {
 		p.IdentDropThreshold = 5000000
 	}
 
+	if p.Cardinality.Window <= 0 {
+		p.Cardinality.Window = 3600
+	}
+
+	for _, rule := range p.StreamAggr {
+		matchers, err := parser.ParseMetricSelector(rule.Match)
+		if err != nil {
+			log.Fatalln("failed to parse stream aggr match:", rule.Match, "error:", err)
+		}
+		rule.Matchers = matchers
+
+		if len(rule.By) > 0 && len(rule.Without) > 0 {
+			log.Fatalln("stream aggr rule", rule.Match, "can not set both By and Without")
+		}
+
+		if len(rule.Outputs) == 0 {
+			log.Fatalln("stream aggr rule", rule.Match, "has no outputs")
+		}
+
+		for _, output := range rule.Outputs {
+			if _, has := streamAggrOutputs[output]; !has {
+				log.Fatalln("stream aggr rule", rule.Match, "unknown output:", output)
+			}
+		}
+
+		if rule.Interval <= 0 {
+			rule.Interval = 60
+		}
+
+		if len(rule.Quantiles) == 0 {
+			rule.Quantiles = []float64{0.5, 0.9, 0.99}
+		}
+	}
+
 	if p.ProxyInflightMax <= 0 {
 		p.ProxyInflightMax = 1000
 	}
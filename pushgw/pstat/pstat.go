@@ -22,6 +22,27 @@ var (
 		Help:      "Number of drop sample.",
 	})
 
+	CounterCardinalityLimitTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "cardinality_limit_total",
+		Help:      "Number of samples dropped because a new series exceeded the max series limit.",
+	}, []string{"scope"})
+
+	CounterIngestTokenSampleTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "ingest_token_samples_total",
+		Help:      "Number of series accepted by ingest token.",
+	}, []string{"token_id", "busi_group_id"})
+
+	CounterIngestTokenDropTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "ingest_token_drop_total",
+		Help:      "Number of series dropped by ingest token restrictions.",
+	}, []string{"token_id", "reason"})
+
 	CounterSampleReceivedByIdent = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
 		Subsystem: subsystem,
@@ -163,7 +184,10 @@ func init() {
 	prometheus.MustRegister(
 		CounterSampleTotal,
 		CounterDropSampleTotal,
+		CounterCardinalityLimitTotal,
 		CounterSampleReceivedByIdent,
+		CounterIngestTokenSampleTotal,
+		CounterIngestTokenDropTotal,
 		RequestDuration,
 		ForwardDuration,
 		ForwardKafkaDuration,
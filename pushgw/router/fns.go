@@ -166,6 +166,14 @@ func (rt *Router) ForwardToQueue(clientIP string, queueid string, v *prompb.Time
 		return nil
 	}
 
+	if rt.Aggregator.Push(v) {
+		return nil
+	}
+
+	if !rt.Cardinality.Admit(v) {
+		return nil
+	}
+
 	return rt.Writers.PushSample(queueid, *v)
 }
 
@@ -0,0 +1,204 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+	"github.com/ccfos/nightingale/v6/pushgw/pstat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const ingestTokenKey = "ingest_token"
+
+// ingestTokenFromRequest 优先取 Authorization: Bearer <token>，
+// 其次取 basic auth 的密码（用户名任意），兼容只支持 basic auth 的采集器
+func ingestTokenFromRequest(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	return ""
+}
+
+// agentAuth 写入接口的认证：携带有效的业务组写入令牌时按令牌的限制写入，
+// 否则回退到全局 basic auth；IngestTokenRequired 为 true 时不再回退
+func (rt *Router) agentAuth(accounts ginx.Accounts) gin.HandlerFunc {
+	var basicAuth gin.HandlerFunc
+	if len(accounts) > 0 {
+		basicAuth = ginx.BasicAuth(accounts)
+	}
+
+	return func(c *gin.Context) {
+		if rt.IngestTokenCache != nil {
+			if token := ingestTokenFromRequest(c.Request); token != "" {
+				if tk := rt.IngestTokenCache.Get(token); tk != nil {
+					if tk.IsExpired() {
+						c.String(http.StatusUnauthorized, "ingest token expired")
+						c.Abort()
+						return
+					}
+
+					c.Set(ingestTokenKey, tk)
+					return
+				}
+			}
+		}
+
+		if rt.Pushgw.IngestTokenRequired {
+			c.String(http.StatusUnauthorized, "ingest token required")
+			c.Abort()
+			return
+		}
+
+		if basicAuth != nil {
+			basicAuth(c)
+		}
+	}
+}
+
+// ingestGuard 一次写入请求内按令牌执行的限制，统计在请求结束时一次性上报
+type ingestGuard struct {
+	token    *models.IngestToken
+	labelKey string
+	labelVal string
+	bucket   *tokenBucket
+
+	accepted int
+	dropped  map[string]int
+}
+
+// ingestGuard 请求未携带写入令牌时返回 nil，不做任何限制
+func (rt *Router) ingestGuard(c *gin.Context) (*ingestGuard, error) {
+	v, has := c.Get(ingestTokenKey)
+	if !has {
+		return nil, nil
+	}
+
+	tk := v.(*models.IngestToken)
+	bg := rt.BusiGroupCache.GetByBusiGroupId(tk.GroupId)
+	if bg == nil {
+		return nil, fmt.Errorf("busi group of ingest token not found")
+	}
+
+	// 业务组开启了标签时使用配置的标签值，否则使用业务组名称
+	labelVal := bg.Name
+	if bg.LabelEnable == 1 && bg.LabelValue != "" {
+		labelVal = bg.LabelValue
+	}
+
+	return &ingestGuard{
+		token:    tk,
+		labelKey: rt.Pushgw.BusiGroupLabelKey,
+		labelVal: labelVal,
+		bucket:   rt.ingestBucket(tk),
+		dropped:  make(map[string]int),
+	}, nil
+}
+
+// admit 校验指标名前缀和速率，通过后强制覆盖业务组标签，返回 false 表示丢弃该 series
+func (g *ingestGuard) admit(pt *prompb.TimeSeries, now time.Time) bool {
+	if !g.token.MetricAllowed(extractMetricFromTimeSeries(pt)) {
+		g.dropped["metric_prefix"]++
+		return false
+	}
+
+	if g.bucket != nil && !g.bucket.allow(len(pt.Samples)+len(pt.Histograms), now) {
+		g.dropped["rate_limit"]++
+		return false
+	}
+
+	if g.labelKey != "" {
+		found := false
+		for i := range pt.Labels {
+			if pt.Labels[i].Name == g.labelKey {
+				pt.Labels[i].Value = g.labelVal
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			pt.Labels = append(pt.Labels, prompb.Label{Name: g.labelKey, Value: g.labelVal})
+		}
+	}
+
+	g.accepted++
+	return true
+}
+
+func (g *ingestGuard) report() {
+	id := fmt.Sprint(g.token.Id)
+	if g.accepted > 0 {
+		pstat.CounterIngestTokenSampleTotal.WithLabelValues(id, fmt.Sprint(g.token.GroupId)).Add(float64(g.accepted))
+	}
+
+	for reason, n := range g.dropped {
+		pstat.CounterIngestTokenDropTotal.WithLabelValues(id, reason).Add(float64(n))
+	}
+}
+
+// ingestBucket 每个令牌一个令牌桶，速率变更后重建
+func (rt *Router) ingestBucket(tk *models.IngestToken) *tokenBucket {
+	if tk.RateLimit <= 0 {
+		return nil
+	}
+
+	if v, has := rt.ingestBuckets.Load(tk.Id); has {
+		b := v.(*tokenBucket)
+		if b.rate == float64(tk.RateLimit) {
+			return b
+		}
+	}
+
+	b := newTokenBucket(tk.RateLimit)
+	rt.ingestBuckets.Store(tk.Id, b)
+	return b
+}
+
+// tokenBucket 按样本数计的令牌桶，桶容量为一秒的配额
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) allow(n int, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+
+	if b.tokens < float64(n) {
+		return false
+	}
+
+	b.tokens -= float64(n)
+	return true
+}
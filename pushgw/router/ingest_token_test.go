@@ -0,0 +1,161 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/memsto"
+	"github.com/ccfos/nightingale/v6/models"
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+	"github.com/ccfos/nightingale/v6/pushgw/pconf"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func newIngestTokenRouter(tokens ...*models.IngestToken) *Router {
+	bgCache := &memsto.BusiGroupCacheType{}
+	bgCache.Set(map[int64]*models.BusiGroup{
+		1: {Id: 1, Name: "team-a", LabelEnable: 1, LabelValue: "a"},
+		2: {Id: 2, Name: "team-b"},
+	}, 2, 0)
+
+	m := make(map[string]*models.IngestToken)
+	for _, tk := range tokens {
+		m[tk.Token] = tk
+	}
+	tokenCache := &memsto.IngestTokenCacheType{}
+	tokenCache.Set(m, int64(len(m)), 0)
+
+	return &Router{
+		Pushgw:           pconf.Pushgw{BusiGroupLabelKey: "busigroup"},
+		BusiGroupCache:   bgCache,
+		IngestTokenCache: tokenCache,
+	}
+}
+
+func runAgentAuth(rt *Router, accounts ginx.Accounts, setup func(r *http.Request)) (int, *models.IngestToken) {
+	gin.SetMode(gin.TestMode)
+
+	var token *models.IngestToken
+	r := gin.New()
+	r.POST("/write", rt.agentAuth(accounts), func(c *gin.Context) {
+		if v, has := c.Get(ingestTokenKey); has {
+			token = v.(*models.IngestToken)
+		}
+		c.String(http.StatusOK, "")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/write", nil)
+	setup(req)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code, token
+}
+
+func TestAgentAuth(t *testing.T) {
+	valid := &models.IngestToken{Id: 1, GroupId: 1, Token: "valid"}
+	expired := &models.IngestToken{Id: 2, GroupId: 1, Token: "expired", ExpireAt: time.Now().Unix() - 10}
+	rt := newIngestTokenRouter(valid, expired)
+	accounts := ginx.Accounts{{User: "agent", Password: "secret"}}
+
+	code, tk := runAgentAuth(rt, accounts, func(r *http.Request) { r.Header.Set("Authorization", "Bearer valid") })
+	if code != http.StatusOK || tk != valid {
+		t.Fatalf("bearer token: code=%d token=%v", code, tk)
+	}
+
+	code, tk = runAgentAuth(rt, accounts, func(r *http.Request) { r.SetBasicAuth("anyone", "valid") })
+	if code != http.StatusOK || tk != valid {
+		t.Fatalf("token as basic auth password: code=%d token=%v", code, tk)
+	}
+
+	code, _ = runAgentAuth(rt, accounts, func(r *http.Request) { r.Header.Set("Authorization", "Bearer expired") })
+	if code != http.StatusUnauthorized {
+		t.Fatalf("expired token: code=%d", code)
+	}
+
+	code, tk = runAgentAuth(rt, accounts, func(r *http.Request) { r.SetBasicAuth("agent", "secret") })
+	if code != http.StatusOK || tk != nil {
+		t.Fatalf("global basic auth: code=%d token=%v", code, tk)
+	}
+
+	code, _ = runAgentAuth(rt, accounts, func(r *http.Request) { r.SetBasicAuth("agent", "wrong") })
+	if code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: code=%d", code)
+	}
+
+	rt.Pushgw.IngestTokenRequired = true
+	code, _ = runAgentAuth(rt, accounts, func(r *http.Request) { r.SetBasicAuth("agent", "secret") })
+	if code != http.StatusUnauthorized {
+		t.Fatalf("token required: code=%d", code)
+	}
+}
+
+func TestIngestGuardAdmit(t *testing.T) {
+	tk := &models.IngestToken{Id: 1, GroupId: 1, Token: "valid", MetricPrefixes: []string{"app_"}, RateLimit: 2}
+	rt := newIngestTokenRouter(tk)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(ingestTokenKey, tk)
+
+	guard, err := rt.ingestGuard(c)
+	if err != nil || guard == nil {
+		t.Fatalf("ingestGuard: guard=%v err=%v", guard, err)
+	}
+
+	series := func(name string, labels ...prompb.Label) *prompb.TimeSeries {
+		return &prompb.TimeSeries{
+			Labels:  append([]prompb.Label{{Name: "__name__", Value: name}}, labels...),
+			Samples: []prompb.Sample{{Value: 1}},
+		}
+	}
+
+	now := time.Now()
+	pt := series("app_requests", prompb.Label{Name: "busigroup", Value: "someone-else"})
+	if !guard.admit(pt, now) {
+		t.Fatalf("app_requests should be admitted")
+	}
+	if pt.Labels[1].Value != "a" {
+		t.Fatalf("busigroup label should be overwritten, got %v", pt.Labels)
+	}
+
+	if guard.admit(series("node_load1"), now) {
+		t.Fatalf("node_load1 should be dropped by metric prefix")
+	}
+
+	pt = series("app_errors")
+	if !guard.admit(pt, now) {
+		t.Fatalf("app_errors should be admitted")
+	}
+	if len(pt.Labels) != 2 || pt.Labels[1].Name != "busigroup" || pt.Labels[1].Value != "a" {
+		t.Fatalf("busigroup label should be appended, got %v", pt.Labels)
+	}
+
+	if guard.admit(series("app_latency"), now) {
+		t.Fatalf("app_latency should be dropped by rate limit")
+	}
+
+	if !guard.admit(series("app_latency"), now.Add(time.Second)) {
+		t.Fatalf("app_latency should be admitted after refill")
+	}
+
+	if guard.accepted != 3 || guard.dropped["metric_prefix"] != 1 || guard.dropped["rate_limit"] != 1 {
+		t.Fatalf("unexpected stats: accepted=%d dropped=%v", guard.accepted, guard.dropped)
+	}
+
+	// 业务组未开启标签时使用业务组名称
+	c.Set(ingestTokenKey, &models.IngestToken{Id: 3, GroupId: 2, Token: "b"})
+	guard, _ = rt.ingestGuard(c)
+	pt = series("anything")
+	guard.admit(pt, now)
+	if pt.Labels[1].Value != "team-b" {
+		t.Fatalf("busigroup label should fall back to group name, got %v", pt.Labels)
+	}
+
+	c.Set(ingestTokenKey, &models.IngestToken{Id: 4, GroupId: 99, Token: "orphan"})
+	if _, err := rt.ingestGuard(c); err == nil {
+		t.Fatalf("token of a deleted busi group should be rejected")
+	}
+}
@@ -2,6 +2,8 @@ package router
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +15,8 @@ import (
 	"github.com/ccfos/nightingale/v6/memsto"
 	"github.com/ccfos/nightingale/v6/pkg/ctx"
 	"github.com/ccfos/nightingale/v6/pkg/httpx"
+	"github.com/ccfos/nightingale/v6/pushgw/aggr"
+	"github.com/ccfos/nightingale/v6/pushgw/cardinality"
 	"github.com/ccfos/nightingale/v6/pushgw/idents"
 	"github.com/ccfos/nightingale/v6/pushgw/pconf"
 	"github.com/ccfos/nightingale/v6/pushgw/pstat"
@@ -34,6 +38,12 @@ type Router struct {
 	Ctx            *ctx.Context
 	HandleTS       HandleTSFunc
 	HeartbeatApi   string
+	Cardinality    *cardinality.Tracker
+	Aggregator     *aggr.Aggregator
+
+	// IngestTokenCache 业务组写入令牌，为 nil 时不启用令牌认证
+	IngestTokenCache *memsto.IngestTokenCacheType
+	ingestBuckets    sync.Map // key: token id, value: *tokenBucket
 
 	// 预编译的 DropSample 过滤器
 	dropByNameOnly map[string]struct{} // 仅 __name__ 条件的快速匹配
@@ -67,7 +77,18 @@ func New(httpConfig httpx.Config, pushgw pconf.Pushgw, aconf aconf.Alert, tc *me
 		IdentSet:       idents,
 		MetaSet:        metas,
 		HandleTS:       func(pt *prompb.TimeSeries) *prompb.TimeSeries { return pt },
+		Cardinality:    cardinality.New(pushgw.Cardinality, pushgw.BusiGroupLabelKey),
 	}
+	go rt.Cardinality.Loop()
+
+	// 聚合结果直接写入 writers，不再经过 DropSample 和基数限制
+	rt.Aggregator = aggr.New(pushgw.StreamAggr, func(ts prompb.TimeSeries) {
+		queueid := fmt.Sprint(atomic.AddUint64(&globalCounter, 1) % uint64(rt.Pushgw.WriterOpt.QueueNumber))
+		if err := rt.Writers.PushSample(queueid, ts); err != nil {
+			logger.Warningf("failed to push stream aggr series: %v", err)
+		}
+	})
+	rt.Aggregator.Start()
 
 	// 预编译 DropSample 过滤器
 	rt.initDropSampleFilters()
@@ -108,6 +129,7 @@ func (rt *Router) Config(r *gin.Engine) {
 		service.Use(gin.BasicAuth(rt.HTTP.APIForService.BasicAuth))
 	}
 	service.POST("/target-update", rt.targetUpdate)
+	service.GET("/cardinality", rt.cardinalityReport)
 
 	if !rt.HTTP.APIForAgent.Enable {
 		return
@@ -122,9 +144,9 @@ func (rt *Router) Config(r *gin.Engine) {
 	r.POST("/datadog/api/v1/metadata", datadogMetadata)
 	r.POST("/datadog/intake/", datadogIntake)
 
+	accounts := make(ginx.Accounts, 0)
 	if len(rt.HTTP.APIForAgent.BasicAuth) > 0 {
 		// enable basic auth
-		accounts := make(ginx.Accounts, 0)
 		for username, password := range rt.HTTP.APIForAgent.BasicAuth {
 			accounts = append(accounts, ginx.Account{
 				User:     username,
@@ -138,27 +160,22 @@ func (rt *Router) Config(r *gin.Engine) {
 				Password: password,
 			})
 		}
+	}
 
-		auth := ginx.BasicAuth(accounts)
-		r.POST("/opentsdb/put", auth, rt.openTSDBPut)
-		r.POST("/openfalcon/push", auth, rt.falconPush)
-		r.POST("/prometheus/v1/write", auth, rt.remoteWrite)
-		r.POST("/proxy/v1/write", auth, rt.proxyRemoteWrite)
-		r.POST("/v1/n9e/edge/heartbeat", auth, rt.heartbeat)
+	// 写入接口额外接受业务组写入令牌
+	auth := rt.agentAuth(accounts)
+	r.POST("/opentsdb/put", auth, rt.openTSDBPut)
+	r.POST("/openfalcon/push", auth, rt.falconPush)
+	r.POST("/prometheus/v1/write", auth, rt.remoteWrite)
+	r.POST("/proxy/v1/write", auth, rt.proxyRemoteWrite)
 
-		if len(rt.Ctx.CenterApi.Addrs) > 0 {
-			r.POST("/v1/n9e/heartbeat", auth, rt.heartbeat)
-		}
-	} else {
-		// no need basic auth
-		r.POST("/opentsdb/put", rt.openTSDBPut)
-		r.POST("/openfalcon/push", rt.falconPush)
-		r.POST("/prometheus/v1/write", rt.remoteWrite)
-		r.POST("/proxy/v1/write", rt.proxyRemoteWrite)
-		r.POST("/v1/n9e/edge/heartbeat", rt.heartbeat)
-
-		if len(rt.Ctx.CenterApi.Addrs) > 0 {
-			r.POST("/v1/n9e/heartbeat", rt.heartbeat)
-		}
+	heartbeat := []gin.HandlerFunc{rt.heartbeat}
+	if len(accounts) > 0 {
+		heartbeat = []gin.HandlerFunc{ginx.BasicAuth(accounts), rt.heartbeat}
+	}
+
+	r.POST("/v1/n9e/edge/heartbeat", heartbeat...)
+	if len(rt.Ctx.CenterApi.Addrs) > 0 {
+		r.POST("/v1/n9e/heartbeat", heartbeat...)
 	}
 }
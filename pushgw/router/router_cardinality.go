@@ -0,0 +1,13 @@
+package router
+
+import (
+	"github.com/ccfos/nightingale/v6/pkg/ginx"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cardinalityReport 基数报告：活跃序列数最高的指标、不同取值最多的标签、序列数最多的机器和业务组
+// GET /v1/n9e/cardinality?topk=20
+func (rt *Router) cardinalityReport(c *gin.Context) {
+	ginx.NewRender(c).Data(rt.Cardinality.Report(ginx.QueryInt(c, "topk", 20)), nil)
+}
@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -177,6 +178,15 @@ func (rt *Router) falconPush(c *gin.Context) {
 		return
 	}
 
+	guard, err := rt.ingestGuard(c)
+	if err != nil {
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
+	if guard != nil {
+		defer guard.report()
+	}
+
 	queueid := fmt.Sprint(atomic.AddUint64(&globalCounter, 1) % uint64(rt.Pushgw.WriterOpt.QueueNumber))
 
 	var (
@@ -214,6 +224,11 @@ func (rt *Router) falconPush(c *gin.Context) {
 			pstat.CounterSampleReceivedByIdent.WithLabelValues(ident).Inc()
 		}
 
+		if guard != nil && !guard.admit(pt, time.Now()) {
+			fail++
+			continue
+		}
+
 		err = rt.ForwardToQueue(c.ClientIP(), queueid, pt)
 		if err != nil {
 			c.String(rt.Pushgw.WriterOpt.OverLimitStatusCode, err.Error())
@@ -45,6 +45,12 @@ const proxyDrainOnRejectBytes = 64 * 1024
 func (rt *Router) proxyRemoteWrite(c *gin.Context) {
 	pstat.CounterProxyRemoteWriteTotal.Inc()
 
+	// 透传模式不解析 body，无法执行写入令牌的标签和速率限制
+	if _, has := c.Get(ingestTokenKey); has {
+		c.String(http.StatusForbidden, "ingest token is not supported by proxy write, use /prometheus/v1/write")
+		return
+	}
+
 	// 背压：CAS 抢占一个 in-flight slot。
 	// 用 CAS 而不是 Add-then-check，被拒请求不会短暂把计数推到 max+N，gauge 不会出现毛刺。
 	max := int64(rt.Pushgw.ProxyInflightMax)
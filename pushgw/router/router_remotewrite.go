@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ccfos/nightingale/v6/pkg/ginx"
 	"github.com/ccfos/nightingale/v6/pushgw/pstat"
@@ -131,6 +132,15 @@ func duplicateLabelKey(series *prompb.TimeSeries) bool {
 }
 
 func (rt *Router) remoteWrite(c *gin.Context) {
+	guard, err := rt.ingestGuard(c)
+	if err != nil {
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
+	if guard != nil {
+		defer guard.report()
+	}
+
 	curLen := rt.Writers.AllQueueLen.Load().(int64)
 	if curLen > rt.Pushgw.WriterOpt.AllQueueMaxSize {
 		err := fmt.Errorf("write queue full, metric count over limit: %d", curLen)
@@ -159,6 +169,7 @@ func (rt *Router) remoteWrite(c *gin.Context) {
 		ignoreIdent = ginx.QueryBool(c, "ignore_ident", false)
 		ignoreHost  = ginx.QueryBool(c, "ignore_host", true) // 默认值改成 true，要不然答疑成本太高。发版的时候通知 telegraf 用户，让他们设置 ignore_host=false
 		ids         = make(map[string]struct{})
+		now         = time.Now()
 	)
 
 	for i := 0; i < count; i++ {
@@ -183,6 +194,10 @@ func (rt *Router) remoteWrite(c *gin.Context) {
 			ids[ident] = struct{}{}
 		}
 
+		if guard != nil && !guard.admit(&req.Timeseries[i], now) {
+			continue
+		}
+
 		err = rt.ForwardToQueue(c.ClientIP(), queueid, &req.Timeseries[i])
 		if err != nil {
 			c.String(rt.Pushgw.WriterOpt.OverLimitStatusCode, err.Error())
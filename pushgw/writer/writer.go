@@ -34,14 +34,16 @@ type WriterType struct {
 	RetryInterval    int64 // 单位秒
 }
 
-// forceSampleTS 在开启 forceUseServerTS 时将每条 TS 的首个 sample 时间戳重写为当前服务端时间。
+// forceSampleTS 在开启 forceUseServerTS 时将每条 TS 的首个 sample（或原生直方图）时间戳重写为当前服务端时间。
 func forceSampleTS(items []prompb.TimeSeries) {
 	ts := int64(fasttime.UnixTimestamp()) * 1000
 	for i := 0; i < len(items); i++ {
-		if len(items[i].Samples) == 0 {
-			continue
+		if len(items[i].Samples) > 0 {
+			items[i].Samples[0].Timestamp = ts
+		}
+		if len(items[i].Histograms) > 0 {
+			items[i].Histograms[0].Timestamp = ts
 		}
-		items[i].Samples[0].Timestamp = ts
 	}
 }
 
@@ -523,6 +525,18 @@ func (ws *WritersType) deepCopySeries(series []prompb.TimeSeries) []prompb.TimeS
 			copy(samples, series[i].Samples)
 			seriesCopy[i].Samples = samples
 		}
+
+		if len(series[i].Histograms) > 0 {
+			histograms := make([]prompb.Histogram, len(series[i].Histograms))
+			copy(histograms, series[i].Histograms)
+			seriesCopy[i].Histograms = histograms
+		}
+
+		if len(series[i].Exemplars) > 0 {
+			exemplars := make([]prompb.Exemplar, len(series[i].Exemplars))
+			copy(exemplars, series[i].Exemplars)
+			seriesCopy[i].Exemplars = exemplars
+		}
 	}
 
 	return seriesCopy
@@ -75,3 +75,13 @@ func TestForceSampleTS(t *testing.T) {
 		t.Fatalf("items[2].Samples[1] timestamp should remain 3, got %d", items[2].Samples[1].Timestamp)
 	}
 }
+
+func TestForceSampleTSHistogram(t *testing.T) {
+	items := []prompb.TimeSeries{
+		{Histograms: []prompb.Histogram{{Sum: 1, Timestamp: 1}}},
+	}
+	forceSampleTS(items)
+	if items[0].Histograms[0].Timestamp == 1 {
+		t.Fatalf("items[0].Histograms[0] timestamp not overwritten")
+	}
+}
@@ -0,0 +1,519 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ccfos/nightingale/v6/tsdb/tconf"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+	"github.com/toolkits/pkg/logger"
+
+	promtsdb "github.com/prometheus/prometheus/tsdb"
+)
+
+// Downsampled data lives in one prometheus tsdb per tier under
+// <Dir>/downsample/<resolution>. Every raw series becomes one series per
+// aggregate, told apart by the aggrLabel label, Thanos-style:
+//
+//	min, max, sum, count  of the raw samples in the window
+//	counter               last raw value of the window, rate() still sees
+//	                      resets between windows
+//
+// Every aggregate sample is stamped with the time of the last raw sample of
+// its window. Tiers are all computed from raw data once a window is final
+// (older than OutOfOrderTimeWindow), so raw retention only needs to cover
+// the processing lag.
+const (
+	aggrLabel = "__aggr__"
+
+	aggrMin     = "min"
+	aggrMax     = "max"
+	aggrSum     = "sum"
+	aggrCount   = "count"
+	aggrCounter = "counter"
+	// avg is computed at query time from sum/count
+	aggrAvg = "avg"
+
+	downsampleDir = "downsample"
+	watermarkFile = "watermark"
+
+	// downsampleBatch bounds how much raw data one pass reads at a time
+	downsampleBatch = 2 * time.Hour
+	// commitEvery bounds the size of a single tier append transaction
+	commitEvery = 10000
+)
+
+var storedAggrs = []string{aggrMin, aggrMax, aggrSum, aggrCount, aggrCounter}
+
+type tier struct {
+	cfg tconf.DownsampleTier
+	dir string
+	db  *promtsdb.DB
+	// watermark: raw data before it (ms) has been downsampled into this tier
+	watermark atomic.Int64
+}
+
+func openTiers(cfg tconf.EmbeddedTSDB) ([]*tier, error) {
+	var tiers []*tier
+	for _, tc := range cfg.Downsample {
+		dir := filepath.Join(cfg.Dir, downsampleDir, tc.Resolution)
+
+		opts := promtsdb.DefaultOptions()
+		opts.RetentionDuration = tc.RetentionValue.Milliseconds()
+		// the first pass backfills everything the raw tsdb still holds, older
+		// than what the head would accept in order
+		opts.OutOfOrderTimeWindow = cfg.RetentionDurationValue.Milliseconds()
+		if maxBlock := opts.RetentionDuration / 10; maxBlock > opts.MaxBlockDuration {
+			opts.MaxBlockDuration = maxBlock
+		}
+
+		db, err := promtsdb.Open(dir, kitLogger{}, nil, opts, nil)
+		if err != nil {
+			closeTiers(tiers)
+			return nil, fmt.Errorf("failed to open downsample tier dir: %s error: %v", dir, err)
+		}
+
+		t := &tier{cfg: tc, dir: dir, db: db}
+		if data, err := os.ReadFile(filepath.Join(dir, watermarkFile)); err == nil {
+			if wm, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+				t.watermark.Store(wm)
+			}
+		}
+
+		tiers = append(tiers, t)
+		logger.Infof("embedded tsdb downsample tier opened, resolution: %s retention: %s", tc.Resolution, tc.Retention)
+	}
+	return tiers, nil
+}
+
+func closeTiers(tiers []*tier) {
+	for _, t := range tiers {
+		if err := t.db.Close(); err != nil {
+			logger.Errorf("failed to close downsample tier %s: %v", t.cfg.Resolution, err)
+		}
+	}
+}
+
+func (t *tier) setWatermark(wm int64) error {
+	t.watermark.Store(wm)
+	return os.WriteFile(filepath.Join(t.dir, watermarkFile), []byte(strconv.FormatInt(wm, 10)), 0o644)
+}
+
+// DownsampleLoop downsamples newly finished windows every minute. No-op
+// without tiers.
+func (in *Instance) DownsampleLoop() {
+	if len(in.tiers) == 0 {
+		return
+	}
+
+	for {
+		time.Sleep(time.Minute)
+		if err := in.Downsample(); err != nil {
+			logger.Errorf("embedded tsdb downsample failed: %v", err)
+		}
+	}
+}
+
+// Downsample runs one pass over all tiers.
+func (in *Instance) Downsample() error {
+	for _, t := range in.tiers {
+		if err := in.downsampleTier(t); err != nil {
+			return fmt.Errorf("tier %s: %v", t.cfg.Resolution, err)
+		}
+	}
+	return nil
+}
+
+func (in *Instance) downsampleTier(t *tier) error {
+	res := t.cfg.ResolutionValue.Milliseconds()
+	now := time.Now()
+
+	// windows ending before this are final, no more out-of-order samples
+	end := now.Add(-in.Cfg.OutOfOrderTimeWindowValue).UnixMilli() / res * res
+
+	start := t.watermark.Load()
+	if start == 0 {
+		start = in.rawMinTime()
+		if start == math.MaxInt64 {
+			// nothing written yet
+			return nil
+		}
+	}
+	if oldest := now.Add(-t.cfg.RetentionValue).UnixMilli(); start < oldest {
+		start = oldest
+	}
+	start = start / res * res
+
+	batch := downsampleBatch.Milliseconds() / res * res
+	if batch < res {
+		batch = res
+	}
+
+	for start < end {
+		batchEnd := start + batch
+		if batchEnd > end {
+			batchEnd = end
+		}
+
+		if err := in.downsampleRange(t, start, batchEnd); err != nil {
+			return err
+		}
+		if err := t.setWatermark(batchEnd); err != nil {
+			return err
+		}
+		start = batchEnd
+	}
+	return nil
+}
+
+func (in *Instance) rawMinTime() int64 {
+	mint := in.DB.Head().MinTime()
+	if blocks := in.DB.Blocks(); len(blocks) > 0 && blocks[0].Meta().MinTime < mint {
+		mint = blocks[0].Meta().MinTime
+	}
+	return mint
+}
+
+// window accumulates the raw samples of one series in one window
+type window struct {
+	min, max, sum, count float64
+	last                 float64
+	lastT                int64
+}
+
+// downsampleRange aggregates the raw samples in [mint, maxt) into the tier.
+// Re-running a range (crash before the watermark was saved) appends the same
+// samples again, which the tsdb accepts as duplicates.
+func (in *Instance) downsampleRange(t *tier, mint, maxt int64) error {
+	ctx := context.Background()
+	res := t.cfg.ResolutionValue.Milliseconds()
+
+	q, err := in.DB.Querier(mint, maxt-1)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	app := t.db.Appender(ctx)
+	pending := 0
+
+	set := q.Select(ctx, false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for set.Next() {
+		series := set.At()
+
+		var windows []*window
+		var cur *window
+		var curStart int64 = math.MinInt64
+
+		it := series.Iterator(nil)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			// native histograms are not downsampled
+			if vt != chunkenc.ValFloat {
+				continue
+			}
+			ts, v := it.At()
+			if value.IsStaleNaN(v) {
+				continue
+			}
+
+			if ws := ts / res * res; cur == nil || ws != curStart {
+				cur = &window{min: v, max: v}
+				curStart = ws
+				windows = append(windows, cur)
+			}
+			cur.min = math.Min(cur.min, v)
+			cur.max = math.Max(cur.max, v)
+			cur.sum += v
+			cur.count++
+			cur.last = v
+			cur.lastT = ts
+		}
+		if err := it.Err(); err != nil {
+			app.Rollback()
+			return err
+		}
+		if len(windows) == 0 {
+			continue
+		}
+
+		lb := labels.NewBuilder(series.Labels())
+		for _, aggr := range storedAggrs {
+			lset := lb.Set(aggrLabel, aggr).Labels()
+
+			var ref storage.SeriesRef
+			for _, w := range windows {
+				if ref, err = app.Append(ref, lset, w.lastT, w.value(aggr)); err != nil {
+					app.Rollback()
+					return fmt.Errorf("failed to append %s: %v", lset, err)
+				}
+				pending++
+			}
+		}
+
+		if pending >= commitEvery {
+			if err := app.Commit(); err != nil {
+				return err
+			}
+			app = t.db.Appender(ctx)
+			pending = 0
+		}
+	}
+	if err := set.Err(); err != nil {
+		app.Rollback()
+		return err
+	}
+
+	return app.Commit()
+}
+
+func (w *window) value(aggr string) float64 {
+	switch aggr {
+	case aggrMin:
+		return w.min
+	case aggrMax:
+		return w.max
+	case aggrSum:
+		return w.sum
+	case aggrCount:
+		return w.count
+	default:
+		return w.last
+	}
+}
+
+// RangeQueryable picks the storage for a range query: the coarsest tier
+// whose resolution is at most step/5 (the Thanos auto-downsampling rule), or
+// a coarser one when start is older than what the finer tiers and raw data
+// still hold. The returned opts widen the lookback delta to the tier
+// resolution so instant selectors still find a sample. Raw data and nil opts
+// are returned when no tier fits.
+func (in *Instance) RangeQueryable(start time.Time, step time.Duration) (storage.Queryable, promql.QueryOpts) {
+	var picked *tier
+	for _, t := range in.tiers {
+		if t.cfg.ResolutionValue <= step/5 {
+			picked = t
+		}
+	}
+
+	if start.Before(time.Now().Add(-in.Cfg.RetentionDurationValue)) {
+		for _, t := range in.tiers {
+			if picked != nil && t.cfg.ResolutionValue <= picked.cfg.ResolutionValue {
+				continue
+			}
+			// finest tier covering start, otherwise the longest kept one
+			picked = t
+			if !start.Before(time.Now().Add(-t.cfg.RetentionValue)) {
+				break
+			}
+		}
+	}
+
+	if picked == nil {
+		return in.DB, nil
+	}
+
+	lookback := in.Cfg.LookbackDeltaValue + picked.cfg.ResolutionValue
+	return &tierQueryable{raw: in.DB, tier: picked}, promql.NewPrometheusQueryOpts(false, lookback)
+}
+
+// tierQueryable serves [mint, watermark) from the tier and the rest, not
+// downsampled yet, from raw data.
+type tierQueryable struct {
+	raw  *promtsdb.DB
+	tier *tier
+}
+
+func (tq *tierQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	wm := tq.tier.watermark.Load()
+
+	var queriers []storage.Querier
+	if mint < wm {
+		q, err := tq.tier.db.Querier(mint, min(maxt, wm-1))
+		if err != nil {
+			return nil, err
+		}
+		queriers = append(queriers, &aggrQuerier{Querier: q})
+	}
+
+	if maxt >= wm {
+		q, err := tq.raw.Querier(max(mint, wm), maxt)
+		if err != nil {
+			for _, q := range queriers {
+				q.Close()
+			}
+			return nil, err
+		}
+		queriers = append(queriers, q)
+	}
+
+	return storage.NewMergeQuerier(queriers, nil, storage.ChainedSeriesMerge), nil
+}
+
+// aggrFor maps the function wrapping a selector (SelectHints.Func) to the
+// aggregate that answers it, same mapping as Thanos.
+func aggrFor(hints *storage.SelectHints) string {
+	if hints == nil {
+		return aggrAvg
+	}
+
+	switch hints.Func {
+	case "rate", "irate", "increase", "resets":
+		return aggrCounter
+	case "min_over_time":
+		return aggrMin
+	case "max_over_time":
+		return aggrMax
+	case "sum_over_time":
+		return aggrSum
+	case "count_over_time":
+		return aggrCount
+	default:
+		return aggrAvg
+	}
+}
+
+// aggrQuerier reads one aggregate of a tier and exposes it with the labels
+// of the raw series, so it merges with raw data and queries stay unchanged.
+type aggrQuerier struct {
+	storage.Querier
+}
+
+func (q *aggrQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	aggr := aggrFor(hints)
+	if aggr == aggrAvg {
+		return q.selectAvg(ctx, hints, matchers)
+	}
+	return &stripAggrSet{SeriesSet: q.Querier.Select(ctx, sortSeries, hints, withAggr(matchers, aggr)...)}
+}
+
+func (q *aggrQuerier) LabelNames(ctx context.Context, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	names, warnings, err := q.Querier.LabelNames(ctx, matchers...)
+	result := names[:0]
+	for _, name := range names {
+		if name != aggrLabel {
+			result = append(result, name)
+		}
+	}
+	return result, warnings, err
+}
+
+// selectAvg joins the sum and count series (both sorted by labels) into
+// sum/count samples.
+func (q *aggrQuerier) selectAvg(ctx context.Context, hints *storage.SelectHints, matchers []*labels.Matcher) storage.SeriesSet {
+	counts := make(map[string][]chunks.Sample)
+	countSet := &stripAggrSet{SeriesSet: q.Querier.Select(ctx, true, hints, withAggr(matchers, aggrCount)...)}
+	for countSet.Next() {
+		series := countSet.At()
+		samples, err := floatSamples(series)
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		counts[series.Labels().String()] = samples
+	}
+	if err := countSet.Err(); err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	var result []storage.Series
+	sumSet := &stripAggrSet{SeriesSet: q.Querier.Select(ctx, true, hints, withAggr(matchers, aggrSum)...)}
+	for sumSet.Next() {
+		series := sumSet.At()
+		sums, err := floatSamples(series)
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+
+		cnts := counts[series.Labels().String()]
+		avgs := make([]chunks.Sample, 0, len(sums))
+		for i, j := 0, 0; i < len(sums) && j < len(cnts); {
+			switch {
+			case sums[i].T() < cnts[j].T():
+				i++
+			case sums[i].T() > cnts[j].T():
+				j++
+			default:
+				if cnts[j].F() > 0 {
+					avgs = append(avgs, fSample{t: sums[i].T(), f: sums[i].F() / cnts[j].F()})
+				}
+				i++
+				j++
+			}
+		}
+		if len(avgs) > 0 {
+			result = append(result, storage.NewListSeries(series.Labels(), avgs))
+		}
+	}
+	if err := sumSet.Err(); err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	return &listSeriesSet{series: result, idx: -1}
+}
+
+func withAggr(matchers []*labels.Matcher, aggr string) []*labels.Matcher {
+	result := make([]*labels.Matcher, 0, len(matchers)+1)
+	result = append(result, matchers...)
+	return append(result, labels.MustNewMatcher(labels.MatchEqual, aggrLabel, aggr))
+}
+
+func floatSamples(series storage.Series) ([]chunks.Sample, error) {
+	var samples []chunks.Sample
+	it := series.Iterator(nil)
+	for it.Next() == chunkenc.ValFloat {
+		t, f := it.At()
+		samples = append(samples, fSample{t: t, f: f})
+	}
+	return samples, it.Err()
+}
+
+type stripAggrSet struct {
+	storage.SeriesSet
+}
+
+func (s *stripAggrSet) At() storage.Series {
+	series := s.SeriesSet.At()
+	return &strippedSeries{Series: series, lset: labels.NewBuilder(series.Labels()).Del(aggrLabel).Labels()}
+}
+
+type strippedSeries struct {
+	storage.Series
+	lset labels.Labels
+}
+
+func (s *strippedSeries) Labels() labels.Labels { return s.lset }
+
+type listSeriesSet struct {
+	series []storage.Series
+	idx    int
+}
+
+func (s *listSeriesSet) Next() bool                        { s.idx++; return s.idx < len(s.series) }
+func (s *listSeriesSet) At() storage.Series                { return s.series[s.idx] }
+func (s *listSeriesSet) Err() error                        { return nil }
+func (s *listSeriesSet) Warnings() annotations.Annotations { return nil }
+
+type fSample struct {
+	t int64
+	f float64
+}
+
+func (s fSample) T() int64                      { return s.t }
+func (s fSample) F() float64                    { return s.f }
+func (s fSample) H() *histogram.Histogram       { return nil }
+func (s fSample) FH() *histogram.FloatHistogram { return nil }
+func (s fSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
@@ -26,6 +26,9 @@ type Instance struct {
 	DB     *promtsdb.DB
 	Engine *promql.Engine
 	Cfg    tconf.EmbeddedTSDB
+
+	meta  metadataStore
+	tiers []*tier
 }
 
 func Open(cfg tconf.EmbeddedTSDB) (*Instance, error) {
@@ -38,6 +41,9 @@ func Open(cfg tconf.EmbeddedTSDB) (*Instance, error) {
 	opts.RetentionDuration = cfg.RetentionDurationValue.Milliseconds()
 	opts.MaxBytes = cfg.MaxBytesValue
 	opts.OutOfOrderTimeWindow = cfg.OutOfOrderTimeWindowValue.Milliseconds()
+	opts.EnableNativeHistograms = true
+	opts.EnableExemplarStorage = true
+	opts.MaxExemplars = int64(cfg.MaxExemplars)
 
 	// same algorithm as cmd/prometheus: allow compacting up to
 	// min(retention/10, 31d) so old 2h blocks get merged instead of piling up
@@ -68,13 +74,20 @@ func Open(cfg tconf.EmbeddedTSDB) (*Instance, error) {
 		ActiveQueryTracker: promql.NewActiveQueryTracker(cfg.Dir, cfg.QueryMaxConcurrency, kl),
 	})
 
+	tiers, err := openTiers(cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	logger.Infof("embedded tsdb opened, dir: %s retention: %s maxBytes: %d", cfg.Dir, cfg.RetentionDuration, cfg.MaxBytesValue)
 
-	return &Instance{DB: db, Engine: engine, Cfg: cfg}, nil
+	return &Instance{DB: db, Engine: engine, Cfg: cfg, tiers: tiers}, nil
 }
 
 func (in *Instance) Close() error {
 	logger.Info("embedded tsdb closing...")
+	closeTiers(in.tiers)
 	return in.DB.Close()
 }
 
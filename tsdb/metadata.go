@@ -0,0 +1,91 @@
+package tsdb
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Metadata is the per-metric metadata served by /api/v1/metadata, in the
+// same shape as prometheus.
+type Metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// metadataStore keeps the metadata that remote-write clients send along with
+// samples (prometheus agent does, the pushgw writer does not). It lives in
+// memory only: clients resend metadata periodically, so it is rebuilt shortly
+// after a restart.
+type metadataStore struct {
+	sync.RWMutex
+	items map[string]Metadata
+}
+
+// AppendMetadata records the metadata carried by a remote write request.
+func (in *Instance) AppendMetadata(items []prompb.MetricMetadata) {
+	if len(items) == 0 {
+		return
+	}
+
+	in.meta.Lock()
+	defer in.meta.Unlock()
+
+	if in.meta.items == nil {
+		in.meta.items = make(map[string]Metadata)
+	}
+
+	for _, m := range items {
+		if m.MetricFamilyName == "" {
+			continue
+		}
+		in.meta.items[m.MetricFamilyName] = Metadata{
+			Type: strings.ToLower(m.Type.String()),
+			Help: m.Help,
+			Unit: m.Unit,
+		}
+	}
+}
+
+// Metadata returns metadata of the given metric names. Names without
+// metadata sent by the client get a type guessed from the naming
+// conventions, so the query editor can still tell counters apart. metric
+// filters to a single name, limit caps the number of metrics (<= 0 means no
+// limit).
+func (in *Instance) Metadata(names []string, metric string, limit int) map[string][]Metadata {
+	in.meta.RLock()
+	defer in.meta.RUnlock()
+
+	sort.Strings(names)
+
+	result := make(map[string][]Metadata)
+	for _, name := range names {
+		if metric != "" && name != metric {
+			continue
+		}
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+
+		if m, has := in.meta.items[name]; has {
+			result[name] = []Metadata{m}
+			continue
+		}
+		result[name] = []Metadata{{Type: guessMetricType(name)}}
+	}
+	return result
+}
+
+func guessMetricType(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_total"):
+		return "counter"
+	case strings.HasSuffix(name, "_bucket"):
+		return "histogram"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// exemplarResult is the prometheus wire format of /api/v1/query_exemplars:
+// value as string, timestamp in float seconds.
+type exemplarResult struct {
+	SeriesLabels labels.Labels `json:"seriesLabels"`
+	Exemplars    []exemplar    `json:"exemplars"`
+}
+
+type exemplar struct {
+	Labels    labels.Labels `json:"labels"`
+	Value     string        `json:"value"`
+	Timestamp float64       `json:"timestamp"`
+}
+
+// queryExemplars returns the exemplars of every series selected by the
+// query, e.g. the trace ids attached to the buckets of a latency histogram.
+func (rt *Router) queryExemplars(c *gin.Context) {
+	qs := c.Request.FormValue("query")
+	if qs == "" {
+		respondError(c, http.StatusBadRequest, "bad_data", "query parameter is required")
+		return
+	}
+
+	start, end := minTime, maxTime
+	if v := c.Request.FormValue("start"); v != "" {
+		var err error
+		if start, err = parseTime(v); err != nil {
+			respondError(c, http.StatusBadRequest, "bad_data", "invalid parameter start: "+err.Error())
+			return
+		}
+	}
+	if v := c.Request.FormValue("end"); v != "" {
+		var err error
+		if end, err = parseTime(v); err != nil {
+			respondError(c, http.StatusBadRequest, "bad_data", "invalid parameter end: "+err.Error())
+			return
+		}
+	}
+	if end.Before(start) {
+		respondError(c, http.StatusBadRequest, "bad_data", "end timestamp must not be before start time")
+		return
+	}
+
+	expr, err := parser.ParseExpr(qs)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+
+	eq, err := rt.inst.DB.ExemplarQuerier(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	results, err := eq.Select(timestamp.FromTime(start), timestamp.FromTime(end), parser.ExtractSelectors(expr)...)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	data := make([]exemplarResult, 0, len(results))
+	for _, r := range results {
+		item := exemplarResult{SeriesLabels: r.SeriesLabels, Exemplars: make([]exemplar, 0, len(r.Exemplars))}
+		for _, e := range r.Exemplars {
+			item.Exemplars = append(item.Exemplars, exemplar{
+				Labels:    e.Labels,
+				Value:     strconv.FormatFloat(e.Value, 'f', -1, 64),
+				Timestamp: float64(e.Ts) / float64(time.Second/time.Millisecond),
+			})
+		}
+		data = append(data, item)
+	}
+
+	respondOK(c, data)
+}
@@ -0,0 +1,518 @@
+package router
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/index"
+)
+
+// maxBytesInFrame is the frame size of streamed remote read responses, same
+// as prometheus' default --storage.remote.read-max-bytes-in-frame.
+const maxBytesInFrame = 1024 * 1024
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// remoteRead serves the prometheus remote read protocol, so an external
+// prometheus/thanos sidecar can use the embedded tsdb as remote_read
+// backend. STREAMED_XOR_CHUNKS is answered with raw chunks straight from the
+// block/head chunk readers; SAMPLES (the protocol default) is capped by
+// QueryMaxSamples like promql queries.
+func (rt *Router) remoteRead(c *gin.Context) {
+	data, ok := readSnappyBody(c)
+	if !ok {
+		return
+	}
+
+	var req prompb.ReadRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_data", "failed to unmarshal read request: "+err.Error())
+		return
+	}
+
+	// first accepted type we support; an empty list means SAMPLES for
+	// backward compatibility
+	responseType := prompb.ReadRequest_SAMPLES
+	if len(req.AcceptedResponseTypes) > 0 {
+		supported := false
+		for _, t := range req.AcceptedResponseTypes {
+			if t == prompb.ReadRequest_SAMPLES || t == prompb.ReadRequest_STREAMED_XOR_CHUNKS {
+				responseType, supported = t, true
+				break
+			}
+		}
+		if !supported {
+			respondError(c, http.StatusBadRequest, "bad_data", fmt.Sprintf("server does not support any of the requested response types: %v", req.AcceptedResponseTypes))
+			return
+		}
+	}
+
+	if responseType == prompb.ReadRequest_STREAMED_XOR_CHUNKS {
+		rt.remoteReadStreamed(c, &req)
+		return
+	}
+	rt.remoteReadSamples(c, &req)
+}
+
+func (rt *Router) remoteReadSamples(c *gin.Context, req *prompb.ReadRequest) {
+	resp := &prompb.ReadResponse{
+		Results: make([]*prompb.QueryResult, len(req.Queries)),
+	}
+
+	samples := 0
+	for i, query := range req.Queries {
+		matchers, err := fromLabelMatchers(query.Matchers)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "bad_data", err.Error())
+			return
+		}
+
+		q, err := rt.inst.DB.Querier(query.StartTimestampMs, query.EndTimestampMs)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+
+		result := &prompb.QueryResult{}
+		set := q.Select(c.Request.Context(), false, readHints(query), matchers...)
+		for set.Next() {
+			series := set.At()
+			ts := &prompb.TimeSeries{Labels: toLabelPairs(series.Labels())}
+
+			it := series.Iterator(nil)
+			for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+				// native histograms are only served through streamed chunks
+				if vt != chunkenc.ValFloat {
+					continue
+				}
+				t, v := it.At()
+				ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+			}
+			if err := it.Err(); err != nil {
+				q.Close()
+				respondError(c, http.StatusInternalServerError, "internal", err.Error())
+				return
+			}
+
+			samples += len(ts.Samples)
+			if limit := rt.inst.Cfg.QueryMaxSamples; limit > 0 && samples > limit {
+				q.Close()
+				respondError(c, http.StatusBadRequest, "bad_data", fmt.Sprintf("exceeded sample limit (%d)", limit))
+				return
+			}
+			result.Timeseries = append(result.Timeseries, ts)
+		}
+		err = set.Err()
+		q.Close()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		resp.Results[i] = result
+	}
+
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	c.Header("Content-Encoding", "snappy")
+	c.Data(http.StatusOK, "application/x-protobuf", snappy.Encode(nil, data))
+}
+
+func (rt *Router) remoteReadStreamed(c *gin.Context, req *prompb.ReadRequest) {
+	c.Header("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+
+	for i, query := range req.Queries {
+		matchers, err := fromLabelMatchers(query.Matchers)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "bad_data", err.Error())
+			return
+		}
+
+		q, err := rt.inst.DB.ChunkQuerier(query.StartTimestampMs, query.EndTimestampMs)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+
+		// series must come sorted, the client merges the streams of several
+		// remote-read endpoints
+		err = streamChunks(c.Writer, int64(i), q.Select(c.Request.Context(), true, readHints(query), matchers...))
+		q.Close()
+		if err != nil {
+			// frames may already have been written, the status code can't be
+			// changed anymore; the client sees a truncated stream
+			c.Error(err)
+			return
+		}
+	}
+}
+
+// streamChunks writes every series as one or more ChunkedReadResponse
+// frames of at most maxBytesInFrame chunk data.
+func streamChunks(w gin.ResponseWriter, queryIndex int64, set storage.ChunkSeriesSet) error {
+	for set.Next() {
+		series := set.At()
+		lbs := toLabelPairs(series.Labels())
+
+		var (
+			chks      []prompb.Chunk
+			frameSize int
+		)
+		it := series.Iterator(nil)
+		for it.Next() {
+			meta := it.At()
+			chks = append(chks, prompb.Chunk{
+				MinTimeMs: meta.MinTime,
+				MaxTimeMs: meta.MaxTime,
+				// chunkenc.Encoding and prompb.Chunk_Encoding share values
+				Type: prompb.Chunk_Encoding(meta.Chunk.Encoding()),
+				Data: meta.Chunk.Bytes(),
+			})
+			frameSize += len(meta.Chunk.Bytes())
+
+			if frameSize >= maxBytesInFrame {
+				if err := writeFrame(w, queryIndex, lbs, chks); err != nil {
+					return err
+				}
+				chks, frameSize = nil, 0
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+
+		if len(chks) > 0 {
+			if err := writeFrame(w, queryIndex, lbs, chks); err != nil {
+				return err
+			}
+		}
+	}
+	return set.Err()
+}
+
+// writeFrame writes one frame of the streamed remote read protocol:
+// uvarint length, big endian crc32 (castagnoli) of the message, message.
+func writeFrame(w gin.ResponseWriter, queryIndex int64, lbs []prompb.Label, chks []prompb.Chunk) error {
+	data, err := proto.Marshal(&prompb.ChunkedReadResponse{
+		ChunkedSeries: []*prompb.ChunkedSeries{{Labels: lbs, Chunks: chks}},
+		QueryIndex:    queryIndex,
+	})
+	if err != nil {
+		return err
+	}
+
+	var header [binary.MaxVarintLen64 + 4]byte
+	n := binary.PutUvarint(header[:], uint64(len(data)))
+	binary.BigEndian.PutUint32(header[n:], crc32.Checksum(data, castagnoliTable))
+
+	if _, err := w.Write(header[:n+4]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+func fromLabelMatchers(matchers []*prompb.LabelMatcher) ([]*labels.Matcher, error) {
+	result := make([]*labels.Matcher, 0, len(matchers))
+	for _, m := range matchers {
+		var mt labels.MatchType
+		switch m.Type {
+		case prompb.LabelMatcher_EQ:
+			mt = labels.MatchEqual
+		case prompb.LabelMatcher_NEQ:
+			mt = labels.MatchNotEqual
+		case prompb.LabelMatcher_RE:
+			mt = labels.MatchRegexp
+		case prompb.LabelMatcher_NRE:
+			mt = labels.MatchNotRegexp
+		default:
+			return nil, fmt.Errorf("invalid matcher type: %v", m.Type)
+		}
+
+		matcher, err := labels.NewMatcher(mt, m.Name, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, matcher)
+	}
+	return result, nil
+}
+
+func toLabelPairs(lset labels.Labels) []prompb.Label {
+	result := make([]prompb.Label, 0, lset.Len())
+	lset.Range(func(l labels.Label) {
+		result = append(result, prompb.Label{Name: l.Name, Value: l.Value})
+	})
+	return result
+}
+
+func readHints(query *prompb.Query) *storage.SelectHints {
+	hints := &storage.SelectHints{
+		Start: query.StartTimestampMs,
+		End:   query.EndTimestampMs,
+	}
+	if query.Hints != nil {
+		hints.Step = query.Hints.StepMs
+		hints.Func = query.Hints.Func
+		hints.Grouping = query.Hints.Grouping
+		hints.By = query.Hints.By
+		hints.Range = query.Hints.RangeMs
+	}
+	return hints
+}
+
+// federate serves /federate like prometheus: the latest sample (within the
+// lookback delta) of every series matched by match[], in the text or
+// protobuf exposition format negotiated from the Accept header. Series are
+// exposed untyped, the tsdb doesn't keep metric types.
+func (rt *Router) federate(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_data", "failed to parse form: "+err.Error())
+		return
+	}
+
+	selectors := c.Request.Form["match[]"]
+	if len(selectors) == 0 {
+		respondError(c, http.StatusBadRequest, "bad_data", "no match[] parameter provided")
+		return
+	}
+
+	var matcherSets [][]*labels.Matcher
+	for _, sel := range selectors {
+		matchers, err := parser.ParseMetricSelector(sel)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "bad_data", "invalid parameter match[]: "+err.Error())
+			return
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+
+	now := time.Now()
+	mint := timestamp.FromTime(now.Add(-rt.inst.Cfg.LookbackDeltaValue))
+	maxt := timestamp.FromTime(now)
+
+	q, err := rt.inst.DB.Querier(mint, maxt)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	defer q.Close()
+
+	hints := &storage.SelectHints{Start: mint, End: maxt}
+
+	families := make(map[string]*dto.MetricFamily)
+	seen := make(map[string]struct{})
+	for _, matchers := range matcherSets {
+		set := q.Select(c.Request.Context(), false, hints, matchers...)
+		for set.Next() {
+			series := set.At()
+			lset := series.Labels()
+
+			key := lset.String()
+			if _, has := seen[key]; has {
+				continue
+			}
+
+			t, v, ok := lastSample(series.Iterator(nil))
+			if !ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			name := lset.Get(labels.MetricName)
+			mf, has := families[name]
+			if !has {
+				mf = &dto.MetricFamily{
+					Name: proto.String(name),
+					Type: dto.MetricType_UNTYPED.Enum(),
+				}
+				families[name] = mf
+			}
+
+			m := &dto.Metric{
+				Untyped:     &dto.Untyped{Value: proto.Float64(v)},
+				TimestampMs: proto.Int64(t),
+			}
+			lset.Range(func(l labels.Label) {
+				if l.Name == labels.MetricName {
+					return
+				}
+				m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+			})
+			mf.Metric = append(mf.Metric, m)
+		}
+		if err := set.Err(); err != nil {
+			respondError(c, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	format := expfmt.Negotiate(c.Request.Header)
+	c.Header("Content-Type", string(format))
+	enc := expfmt.NewEncoder(c.Writer, format)
+	for _, name := range names {
+		if err := enc.Encode(families[name]); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		closer.Close()
+	}
+}
+
+// lastSample returns the newest float sample of the series, skipping
+// histograms and stale markers.
+func lastSample(it chunkenc.Iterator) (int64, float64, bool) {
+	var (
+		t  int64
+		v  float64
+		ok bool
+	)
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		if vt != chunkenc.ValFloat {
+			continue
+		}
+		st, sv := it.At()
+		if value.IsStaleNaN(sv) {
+			ok = false
+			continue
+		}
+		t, v, ok = st, sv, true
+	}
+	return t, v, ok && it.Err() == nil
+}
+
+// metadata serves /api/v1/metadata from the metadata sent by remote write
+// clients, falling back to a type guessed from the metric name.
+func (rt *Router) metadata(c *gin.Context) {
+	limit := -1
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "bad_data", "invalid parameter limit: "+err.Error())
+			return
+		}
+		limit = n
+	}
+
+	q, err := rt.inst.DB.Querier(timestamp.FromTime(minTime), timestamp.FromTime(maxTime))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	defer q.Close()
+
+	names, _, err := q.LabelValues(c.Request.Context(), labels.MetricName)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	respondOK(c, rt.inst.Metadata(names, c.Query("metric"), limit))
+}
+
+type tsdbStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+type headStats struct {
+	NumSeries     uint64 `json:"numSeries"`
+	NumLabelPairs int    `json:"numLabelPairs"`
+	ChunkCount    int64  `json:"chunkCount"`
+	MinTime       int64  `json:"minTime"`
+	MaxTime       int64  `json:"maxTime"`
+}
+
+type tsdbStatus struct {
+	HeadStats                   headStats  `json:"headStats"`
+	SeriesCountByMetricName     []tsdbStat `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []tsdbStat `json:"labelValueCountByLabelName"`
+	MemoryInBytesByLabelName    []tsdbStat `json:"memoryInBytesByLabelName"`
+	SeriesCountByLabelValuePair []tsdbStat `json:"seriesCountByLabelValuePair"`
+}
+
+// tsdbStatus serves /api/v1/status/tsdb in the prometheus response shape:
+// head cardinality and the top-N (limit, default 10) metrics, label names
+// and label pairs by series count.
+func (rt *Router) tsdbStatus(c *gin.Context) {
+	limit := 10
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(c, http.StatusBadRequest, "bad_data", "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+
+	s := rt.inst.DB.Head().Stats(labels.MetricName, limit)
+	respondOK(c, tsdbStatus{
+		HeadStats: headStats{
+			NumSeries:     s.NumSeries,
+			NumLabelPairs: s.IndexPostingStats.NumLabelPairs,
+			ChunkCount:    headChunks(),
+			MinTime:       s.MinTime,
+			MaxTime:       s.MaxTime,
+		},
+		SeriesCountByMetricName:     toTSDBStats(s.IndexPostingStats.CardinalityMetricsStats),
+		LabelValueCountByLabelName:  toTSDBStats(s.IndexPostingStats.CardinalityLabelStats),
+		MemoryInBytesByLabelName:    toTSDBStats(s.IndexPostingStats.LabelValueStats),
+		SeriesCountByLabelValuePair: toTSDBStats(s.IndexPostingStats.LabelValuePairsStats),
+	})
+}
+
+func toTSDBStats(stats []index.Stat) []tsdbStat {
+	result := make([]tsdbStat, 0, len(stats))
+	for _, item := range stats {
+		result = append(result, tsdbStat{Name: item.Name, Value: item.Count})
+	}
+	return result
+}
+
+// headChunks reads prometheus_tsdb_head_chunks, the head doesn't expose the
+// chunk count otherwise. It's only registered by the first Open in the
+// process (see tsdb.registerOnce), 0 when missing.
+func headChunks() int64 {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return 0
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "prometheus_tsdb_head_chunks" && len(mf.Metric) > 0 {
+			return int64(mf.Metric[0].GetGauge().GetValue())
+		}
+	}
+	return 0
+}
@@ -1,5 +1,6 @@
 // Package router exposes prometheus compatible query endpoints backed by the
-// embedded tsdb, mounted at /prometheus/api/v1/* so the auto-registered
+// embedded tsdb (plus remote read and /federate for an external prometheus
+// to pull from), mounted at /prometheus/api/v1/* so the auto-registered
 // datasource url http(s)://<host>:<port>/prometheus works with both the
 // frontend datasource proxy and the alert engine prometheus client. By
 // default only requests from the n9e host itself are accepted; configuring
@@ -129,7 +130,16 @@ func (rt *Router) Config(r *gin.Engine) {
 	g.GET("/api/v1/labels", rt.limitScan, rt.labelNames)
 	g.POST("/api/v1/labels", rt.limitScan, rt.labelNames)
 	g.GET("/api/v1/label/:name/values", rt.limitScan, rt.labelValues)
+	g.GET("/api/v1/query_exemplars", rt.limitScan, rt.queryExemplars)
+	g.POST("/api/v1/query_exemplars", rt.limitScan, rt.queryExemplars)
 	g.GET("/api/v1/status/buildinfo", rt.buildInfo)
+	g.GET("/api/v1/status/tsdb", rt.limitScan, rt.tsdbStatus)
+	g.GET("/api/v1/metadata", rt.limitScan, rt.metadata)
+
+	// pull endpoints for an external prometheus/thanos: remote read
+	// (samples or streamed chunks) and federation
+	g.POST("/api/v1/read", rt.limitScan, rt.remoteRead)
+	g.GET("/federate", rt.limitScan, rt.federate)
 
 	// destructive admin endpoints, off by default like prometheus
 	// --web.enable-admin-api; when off they are not registered at all
@@ -138,6 +148,14 @@ func (rt *Router) Config(r *gin.Engine) {
 		g.PUT("/api/v1/admin/tsdb/delete_series", rt.limitScan, rt.deleteSeries)
 		g.POST("/api/v1/admin/tsdb/clean_tombstones", rt.cleanTombstones)
 		g.PUT("/api/v1/admin/tsdb/clean_tombstones", rt.cleanTombstones)
+
+		// snapshot is the prometheus compatible endpoint, the rest manage
+		// the snapshots for backup (see tsdb.Restore / n9e tsdb-restore)
+		g.POST("/api/v1/admin/tsdb/snapshot", rt.snapshot)
+		g.PUT("/api/v1/admin/tsdb/snapshot", rt.snapshot)
+		g.GET("/api/v1/admin/tsdb/snapshots", rt.snapshotGets)
+		g.GET("/api/v1/admin/tsdb/snapshots/:name", rt.snapshotDownload)
+		g.DELETE("/api/v1/admin/tsdb/snapshots/:name", rt.snapshotDel)
 	}
 }
 
@@ -182,16 +200,40 @@ func (rt *Router) limit(c *gin.Context, sem chan struct{}, kind string) {
 }
 
 func (rt *Router) remoteWrite(c *gin.Context) {
+	data, ok := readSnappyBody(c)
+	if !ok {
+		return
+	}
+
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_data", "failed to unmarshal write request: "+err.Error())
+		return
+	}
+
+	if err := rt.inst.AppendTimeSeries(req.Timeseries); err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	rt.inst.AppendMetadata(req.Metadata)
+
+	c.Status(http.StatusNoContent)
+}
+
+// readSnappyBody reads and decodes the snappy compressed protobuf body shared
+// by remote write and remote read, both capped by maxWriteBodyBytes. It writes
+// the error response itself and returns ok=false on invalid input.
+func readSnappyBody(c *gin.Context) ([]byte, bool) {
 	compressed, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWriteBodyBytes))
 	if err != nil {
 		var tooLarge *http.MaxBytesError
 		if errors.As(err, &tooLarge) {
 			respondError(c, http.StatusRequestEntityTooLarge, "bad_data",
 				fmt.Sprintf("request body exceeds the %d bytes limit", maxWriteBodyBytes))
-			return
+			return nil, false
 		}
 		respondError(c, http.StatusBadRequest, "bad_data", "failed to read body: "+err.Error())
-		return
+		return nil, false
 	}
 
 	// check the length declared in the snappy header before decoding, otherwise
@@ -199,33 +241,22 @@ func (rt *Router) remoteWrite(c *gin.Context) {
 	decodedLen, err := snappy.DecodedLen(compressed)
 	if err != nil {
 		respondError(c, http.StatusBadRequest, "bad_data", "failed to read snappy header: "+err.Error())
-		return
+		return nil, false
 	}
 
 	if decodedLen > maxWriteBodyBytes {
 		respondError(c, http.StatusRequestEntityTooLarge, "bad_data",
 			fmt.Sprintf("decoded body size %d exceeds the %d bytes limit", decodedLen, maxWriteBodyBytes))
-		return
+		return nil, false
 	}
 
 	data, err := snappy.Decode(nil, compressed)
 	if err != nil {
 		respondError(c, http.StatusBadRequest, "bad_data", "failed to snappy decode body: "+err.Error())
-		return
-	}
-
-	var req prompb.WriteRequest
-	if err := proto.Unmarshal(data, &req); err != nil {
-		respondError(c, http.StatusBadRequest, "bad_data", "failed to unmarshal write request: "+err.Error())
-		return
+		return nil, false
 	}
 
-	if err := rt.inst.AppendTimeSeries(req.Timeseries); err != nil {
-		respondError(c, http.StatusInternalServerError, "internal", err.Error())
-		return
-	}
-
-	c.Status(http.StatusNoContent)
+	return data, true
 }
 
 func respondOK(c *gin.Context, data interface{}) {
@@ -308,7 +339,10 @@ func (rt *Router) queryRange(c *gin.Context) {
 		return
 	}
 
-	qry, err := rt.inst.Engine.NewRangeQuery(c.Request.Context(), rt.inst.DB, nil, qs, start, end, step)
+	// long range/large step queries are served by a downsample tier when
+	// configured
+	queryable, opts := rt.inst.RangeQueryable(start, step)
+	qry, err := rt.inst.Engine.NewRangeQuery(c.Request.Context(), queryable, opts, qs, start, end, step)
 	if err != nil {
 		respondError(c, http.StatusBadRequest, "bad_data", err.Error())
 		return
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (rt *Router) snapshot(c *gin.Context) {
+	skipHead, _ := strconv.ParseBool(c.Request.FormValue("skip_head"))
+
+	name, err := rt.inst.Snapshot(skipHead)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	respondOK(c, gin.H{"name": name})
+}
+
+func (rt *Router) snapshotGets(c *gin.Context) {
+	snapshots, err := rt.inst.ListSnapshots()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	respondOK(c, snapshots)
+}
+
+// snapshotDownload streams the snapshot as a tar archive, the input of
+// n9e tsdb-restore.
+func (rt *Router) snapshotDownload(c *gin.Context) {
+	name := c.Param("name")
+	snapshots, err := rt.inst.ListSnapshots()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	found := false
+	for _, s := range snapshots {
+		if s.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "not_found", "snapshot not found: "+name)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`.tar"`)
+	if err := rt.inst.WriteSnapshotTar(name, c.Writer); err != nil {
+		// headers are gone once the archive started, the client gets a
+		// truncated tar that tsdb-restore refuses
+		c.Error(err)
+	}
+}
+
+func (rt *Router) snapshotDel(c *gin.Context) {
+	if err := rt.inst.DeleteSnapshot(c.Param("name")); err != nil {
+		respondError(c, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,414 @@
+package tsdb
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/toolkits/pkg/logger"
+
+	promtsdb "github.com/prometheus/prometheus/tsdb"
+)
+
+// snapshotsDir is where snapshots live, inside the data dir like prometheus
+// (<data>/snapshots); the tsdb ignores directories that aren't blocks.
+const snapshotsDir = "snapshots"
+
+var snapshotNameRe = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+type SnapshotInfo struct {
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"created_at"`
+	Blocks    int    `json:"blocks"`
+	Size      int64  `json:"size"`
+}
+
+// Snapshot writes a consistent copy of all blocks (and the head, unless
+// skipHead) into a new snapshot directory and returns its name. Blocks are
+// hard-linked, so snapshots are cheap until compaction deletes the originals.
+func (in *Instance) Snapshot(skipHead bool) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	// same naming as prometheus, sorts by creation time
+	name := fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z0700"), hex.EncodeToString(suffix))
+	dir := filepath.Join(in.Cfg.Dir, snapshotsDir, name)
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return "", fmt.Errorf("failed to create snapshot dir: %v", err)
+	}
+
+	if err := in.DB.Snapshot(dir, !skipHead); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	logger.Infof("embedded tsdb snapshot created: %s", dir)
+	return name, nil
+}
+
+// ListSnapshots returns the snapshots, oldest first.
+func (in *Instance) ListSnapshots() ([]SnapshotInfo, error) {
+	root := filepath.Join(in.Cfg.Dir, snapshotsDir)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SnapshotInfo{}, nil
+		}
+		return nil, err
+	}
+
+	result := make([]SnapshotInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		dir := filepath.Join(root, entry.Name())
+		size, err := fileutil.DirSize(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks, err := blockDirs(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, SnapshotInfo{
+			Name:      entry.Name(),
+			CreatedAt: info.ModTime().Unix(),
+			Blocks:    len(blocks),
+			Size:      size,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// snapshotDir resolves a snapshot name, rejecting anything that could
+// escape the snapshots directory.
+func (in *Instance) snapshotDir(name string) (string, error) {
+	if !snapshotNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name: %s", name)
+	}
+
+	dir := filepath.Join(in.Cfg.Dir, snapshotsDir, name)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("snapshot not found: %s", name)
+		}
+		return "", err
+	}
+	return dir, nil
+}
+
+func (in *Instance) DeleteSnapshot(name string) error {
+	dir, err := in.snapshotDir(name)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("embedded tsdb snapshot deleted: %s", dir)
+	return os.RemoveAll(dir)
+}
+
+// WriteSnapshotTar streams the snapshot as a tar archive, paths relative to
+// the snapshot dir (<block ulid>/meta.json ...), the format Restore accepts.
+func (in *Instance) WriteSnapshotTar(name string, w io.Writer) error {
+	dir, err := in.snapshotDir(name)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// SnapshotLoop takes a snapshot every SnapshotInterval and keeps the newest
+// SnapshotRetention ones. No-op when SnapshotInterval is not configured.
+func (in *Instance) SnapshotLoop() {
+	if in.Cfg.SnapshotIntervalValue <= 0 {
+		return
+	}
+
+	for {
+		time.Sleep(in.Cfg.SnapshotIntervalValue)
+
+		if _, err := in.Snapshot(false); err != nil {
+			logger.Errorf("embedded tsdb periodic snapshot failed: %v", err)
+			continue
+		}
+
+		if err := in.pruneSnapshots(); err != nil {
+			logger.Errorf("embedded tsdb failed to prune snapshots: %v", err)
+		}
+	}
+}
+
+func (in *Instance) pruneSnapshots() error {
+	snapshots, err := in.ListSnapshots()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(snapshots)-in.Cfg.SnapshotRetention; i++ {
+		if err := in.DeleteSnapshot(snapshots[i].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockDirs returns the block directories (those holding a meta.json) right
+// under dir.
+func blockDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), "meta.json")); err == nil {
+			result = append(result, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return result, nil
+}
+
+// VerifyBlocks opens every block under dir and reads all of its series and
+// samples, so a truncated or corrupted index/chunk file is reported before it
+// replaces live data. Returns the number of blocks.
+func VerifyBlocks(dir string) (int, error) {
+	blocks, err := blockDirs(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	all := labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")
+	for _, path := range blocks {
+		if err := verifyBlock(path, all); err != nil {
+			return 0, fmt.Errorf("block %s is broken: %v", filepath.Base(path), err)
+		}
+	}
+	return len(blocks), nil
+}
+
+func verifyBlock(path string, matcher *labels.Matcher) error {
+	b, err := promtsdb.OpenBlock(kitLogger{}, path, nil)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	q, err := promtsdb.NewBlockQuerier(b, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	set := q.Select(context.Background(), false, nil, matcher)
+	for set.Next() {
+		it := set.At().Iterator(nil)
+		for it.Next() != chunkenc.ValNone {
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return set.Err()
+}
+
+// Restore replaces the data in dir with the blocks of a snapshot, either a
+// snapshot directory or a tar archive from the snapshot download API. It
+// must run while n9e is stopped: the tsdb lock file of dir is taken first
+// and Restore fails if a running instance holds it. All blocks are verified
+// before anything is touched; the previous data is kept as
+// <dir>.bak-<timestamp> (snapshots and downsample tiers are moved back
+// into dir).
+func Restore(src, dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return 0, err
+	}
+
+	lock, _, err := fileutil.Flock(filepath.Join(dir, "lock"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock %s, is n9e still running? %v", dir, err)
+	}
+
+	staging := strings.TrimRight(dir, string(os.PathSeparator)) + ".restore"
+	if err := os.RemoveAll(staging); err != nil {
+		lock.Release()
+		return 0, err
+	}
+
+	count, err := stageSnapshot(src, staging)
+	if err != nil {
+		lock.Release()
+		os.RemoveAll(staging)
+		return 0, err
+	}
+
+	// the lock file belongs to dir, release before moving it away
+	lock.Release()
+
+	backup := fmt.Sprintf("%s.bak-%s", strings.TrimRight(dir, string(os.PathSeparator)), time.Now().Format("20060102150405"))
+	if err := os.Rename(dir, backup); err != nil {
+		os.RemoveAll(staging)
+		return 0, fmt.Errorf("failed to move current data to %s: %v", backup, err)
+	}
+
+	if err := os.Rename(staging, dir); err != nil {
+		return 0, fmt.Errorf("failed to move restored data into place, previous data kept at %s: %v", backup, err)
+	}
+
+	// keep the existing snapshots and downsample tiers (long-term history the
+	// snapshot doesn't hold) after the restore
+	for _, sub := range []string{snapshotsDir, downsampleDir} {
+		if _, err := os.Stat(filepath.Join(backup, sub)); err != nil {
+			continue
+		}
+		if err := os.Rename(filepath.Join(backup, sub), filepath.Join(dir, sub)); err != nil {
+			logger.Warningf("failed to move %s back from %s: %v", sub, backup, err)
+		}
+	}
+
+	logger.Infof("embedded tsdb restored %d blocks from %s into %s, previous data kept at %s", count, src, dir, backup)
+	return count, nil
+}
+
+// stageSnapshot copies (or extracts) the snapshot blocks into staging and
+// verifies them.
+func stageSnapshot(src, staging string) (int, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.IsDir() {
+		blocks, err := blockDirs(src)
+		if err != nil {
+			return 0, err
+		}
+		for _, block := range blocks {
+			if err := fileutil.CopyDirs(block, filepath.Join(staging, filepath.Base(block))); err != nil {
+				return 0, fmt.Errorf("failed to copy block %s: %v", block, err)
+			}
+		}
+	} else if err := extractTar(src, staging); err != nil {
+		return 0, fmt.Errorf("failed to extract %s: %v", src, err)
+	}
+
+	count, err := VerifyBlocks(staging)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("no blocks found in %s", src)
+	}
+	return count, nil
+}
+
+func extractTar(src, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		target := filepath.Join(dest, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o777); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
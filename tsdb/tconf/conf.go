@@ -2,6 +2,7 @@ package tconf
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/alecthomas/units"
@@ -22,6 +23,9 @@ type EmbeddedTSDB struct {
 	QueryMaxSamples      int
 	QueryMaxConcurrency  int
 	LookbackDelta        string
+	// MaxExemplars is the size of the in-memory exemplar ring buffer shared
+	// by all series, same as prometheus --storage.exemplars.max-exemplars.
+	MaxExemplars int
 	// BasicAuthUser/Pass protect the /prometheus/api/v1/* endpoints. When
 	// empty, those endpoints only accept requests from the n9e host itself
 	// (see tsdb/router.Router.localOnly); setting them allows authenticated
@@ -38,6 +42,17 @@ type EmbeddedTSDB struct {
 	// what queriers should use. Setting it also lifts the local-only
 	// restriction of the /prometheus/api/v1/* endpoints.
 	DatasourceUrl string
+	// SnapshotInterval enables periodic snapshots (<Dir>/snapshots, same
+	// layout as the admin snapshot API) when set, e.g. 1d. SnapshotRetention
+	// is how many of them are kept, older ones are deleted after each run;
+	// snapshots taken through the API count too.
+	SnapshotInterval  string
+	SnapshotRetention int
+	// Downsample tiers keep min/max/sum/count/counter aggregates of the raw
+	// samples at a lower resolution for longer than RetentionDuration, e.g.
+	// 5m for 90d and 1h for 2y. query_range picks a tier by step, see
+	// tsdb.Instance.RangeQueryable.
+	Downsample []DownsampleTier
 
 	// parsed values, filled by PreCheck
 	RetentionDurationValue    time.Duration `toml:"-" json:"-"`
@@ -45,6 +60,15 @@ type EmbeddedTSDB struct {
 	OutOfOrderTimeWindowValue time.Duration `toml:"-" json:"-"`
 	QueryTimeoutValue         time.Duration `toml:"-" json:"-"`
 	LookbackDeltaValue        time.Duration `toml:"-" json:"-"`
+	SnapshotIntervalValue     time.Duration `toml:"-" json:"-"`
+}
+
+type DownsampleTier struct {
+	Resolution string
+	Retention  string
+
+	ResolutionValue time.Duration `toml:"-" json:"-"`
+	RetentionValue  time.Duration `toml:"-" json:"-"`
 }
 
 func (c *EmbeddedTSDB) PreCheck() error {
@@ -77,6 +101,10 @@ func (c *EmbeddedTSDB) PreCheck() error {
 		c.QueryMaxConcurrency = 20
 	}
 
+	if c.MaxExemplars <= 0 {
+		c.MaxExemplars = 100000
+	}
+
 	if c.LookbackDelta == "" {
 		c.LookbackDelta = "5m"
 	}
@@ -98,6 +126,41 @@ func (c *EmbeddedTSDB) PreCheck() error {
 		return err
 	}
 
+	if c.SnapshotInterval != "" {
+		if c.SnapshotIntervalValue, err = parseDuration("SnapshotInterval", c.SnapshotInterval); err != nil {
+			return err
+		}
+	}
+
+	if c.SnapshotRetention <= 0 {
+		c.SnapshotRetention = 7
+	}
+
+	for i := range c.Downsample {
+		tier := &c.Downsample[i]
+		if tier.ResolutionValue, err = parseDuration("Downsample.Resolution", tier.Resolution); err != nil {
+			return err
+		}
+		if tier.RetentionValue, err = parseDuration("Downsample.Retention", tier.Retention); err != nil {
+			return err
+		}
+		if tier.ResolutionValue < time.Minute {
+			return fmt.Errorf("EmbeddedTSDB.Downsample.Resolution %s too small, at least 1m", tier.Resolution)
+		}
+		if tier.RetentionValue <= tier.ResolutionValue {
+			return fmt.Errorf("EmbeddedTSDB.Downsample.Retention %s must be larger than the resolution", tier.Retention)
+		}
+	}
+
+	sort.Slice(c.Downsample, func(i, j int) bool {
+		return c.Downsample[i].ResolutionValue < c.Downsample[j].ResolutionValue
+	})
+	for i := 1; i < len(c.Downsample); i++ {
+		if c.Downsample[i].ResolutionValue == c.Downsample[i-1].ResolutionValue {
+			return fmt.Errorf("EmbeddedTSDB.Downsample.Resolution %s duplicated", c.Downsample[i].Resolution)
+		}
+	}
+
 	if c.MaxBytes != "" && c.MaxBytes != "0" {
 		bytes, err := units.ParseBase2Bytes(c.MaxBytes)
 		if err != nil {
@@ -8,6 +8,8 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -367,8 +369,369 @@ func TestPreCheckDefaults(t *testing.T) {
 		t.Fatal("half basic auth should fail")
 	}
 
+	tiers := tconf.EmbeddedTSDB{Enable: true, Downsample: []tconf.DownsampleTier{
+		{Resolution: "1h", Retention: "2y"}, {Resolution: "5m", Retention: "90d"},
+	}}
+	if err := tiers.PreCheck(); err != nil || tiers.Downsample[0].ResolutionValue != 5*time.Minute {
+		t.Fatalf("tiers should be sorted by resolution: %v %+v", err, tiers.Downsample)
+	}
+
+	dupTier := tconf.EmbeddedTSDB{Enable: true, Downsample: []tconf.DownsampleTier{
+		{Resolution: "5m", Retention: "90d"}, {Resolution: "5m", Retention: "1y"},
+	}}
+	if err := dupTier.PreCheck(); err == nil {
+		t.Fatal("duplicated tier resolution should fail")
+	}
+
 	disabled := tconf.EmbeddedTSDB{}
 	if err := disabled.PreCheck(); err != nil {
 		t.Fatalf("disabled config should not be validated: %v", err)
 	}
 }
+
+func TestPullEndpoints(t *testing.T) {
+	_, r := newTestRouter(t, tconf.EmbeddedTSDB{Enable: true, Dir: t.TempDir()}, "")
+
+	now := time.Now().UnixMilli()
+	items := []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "ident", Value: "host1"}},
+			Samples: []prompb.Sample{{Timestamp: now - 60000, Value: 10}, {Timestamp: now, Value: 12}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "ident", Value: "host2"}},
+			Samples: []prompb.Sample{{Timestamp: now, Value: 3}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "cpu_usage"}, {Name: "ident", Value: "host1"}},
+			Samples: []prompb.Sample{{Timestamp: now, Value: 0.5}},
+		},
+	}
+	if code := remoteWrite(t, r, items); code != http.StatusNoContent {
+		t.Fatalf("remote write status: %d", code)
+	}
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, localReq("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	// federate: latest sample of the matched series only
+	code, body := get("/prometheus/federate?match[]=http_requests_total")
+	if code != http.StatusOK {
+		t.Fatalf("federate status: %d body: %s", code, body)
+	}
+	if !strings.Contains(body, `http_requests_total{ident="host1"} 12 `) || !strings.Contains(body, `http_requests_total{ident="host2"} 3 `) ||
+		strings.Contains(body, "cpu_usage") || strings.Contains(body, " 10 ") {
+		t.Fatalf("unexpected federate body: %s", body)
+	}
+
+	code, body = get("/prometheus/federate")
+	if code != http.StatusBadRequest {
+		t.Fatalf("federate without match[] status: %d body: %s", code, body)
+	}
+
+	// tsdb status
+	code, body = get("/prometheus/api/v1/status/tsdb?limit=1")
+	if code != http.StatusOK {
+		t.Fatalf("status/tsdb status: %d body: %s", code, body)
+	}
+	var status struct {
+		Data struct {
+			HeadStats struct {
+				NumSeries uint64 `json:"numSeries"`
+			} `json:"headStats"`
+			SeriesCountByMetricName []struct {
+				Name  string `json:"name"`
+				Value uint64 `json:"value"`
+			} `json:"seriesCountByMetricName"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatalf("unmarshal fail: %v body: %s", err, body)
+	}
+	top := status.Data.SeriesCountByMetricName
+	if status.Data.HeadStats.NumSeries != 3 || len(top) != 1 || top[0].Name != "http_requests_total" || top[0].Value != 2 {
+		t.Fatalf("unexpected status/tsdb body: %s", body)
+	}
+
+	// metadata: sent by the client wins, otherwise guessed from the name
+	data, _ := proto.Marshal(&prompb.WriteRequest{Metadata: []prompb.MetricMetadata{
+		{MetricFamilyName: "cpu_usage", Type: prompb.MetricMetadata_GAUGE, Help: "cpu usage ratio"},
+	}})
+	req := localReq("POST", "/prometheus/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("metadata write status: %d", rec.Code)
+	}
+
+	code, body = get("/prometheus/api/v1/metadata")
+	if code != http.StatusOK || !strings.Contains(body, `"cpu_usage":[{"type":"gauge","help":"cpu usage ratio","unit":""}]`) ||
+		!strings.Contains(body, `"http_requests_total":[{"type":"counter"`) {
+		t.Fatalf("unexpected metadata body: %s", body)
+	}
+
+	// remote read, samples response
+	readReq := &prompb.ReadRequest{Queries: []*prompb.Query{{
+		StartTimestampMs: now - 120000,
+		EndTimestampMs:   now,
+		Matchers: []*prompb.LabelMatcher{
+			{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "http_requests_total"},
+			{Type: prompb.LabelMatcher_EQ, Name: "ident", Value: "host1"},
+		},
+	}}}
+	data, _ = proto.Marshal(readReq)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, localReq("POST", "/prometheus/api/v1/read", bytes.NewReader(snappy.Encode(nil, data))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remote read status: %d body: %s", rec.Code, rec.Body.String())
+	}
+	decoded, err := snappy.Decode(nil, rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("snappy decode fail: %v", err)
+	}
+	var readResp prompb.ReadResponse
+	if err := proto.Unmarshal(decoded, &readResp); err != nil {
+		t.Fatalf("unmarshal fail: %v", err)
+	}
+	if len(readResp.Results) != 1 || len(readResp.Results[0].Timeseries) != 1 || len(readResp.Results[0].Timeseries[0].Samples) != 2 {
+		t.Fatalf("unexpected remote read resp: %v", readResp.String())
+	}
+
+	// remote read, streamed chunks: one frame for the single matched series
+	readReq.AcceptedResponseTypes = []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS}
+	data, _ = proto.Marshal(readReq)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, localReq("POST", "/prometheus/api/v1/read", bytes.NewReader(snappy.Encode(nil, data))))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/x-streamed-protobuf") {
+		t.Fatalf("streamed remote read status: %d header: %v", rec.Code, rec.Header())
+	}
+	frame := rec.Body.Bytes()
+	size, n := binary.Uvarint(frame)
+	if n <= 0 || len(frame) != n+4+int(size) {
+		t.Fatalf("unexpected frame, size: %d len: %d", size, len(frame))
+	}
+	var chunked prompb.ChunkedReadResponse
+	if err := proto.Unmarshal(frame[n+4:], &chunked); err != nil {
+		t.Fatalf("unmarshal fail: %v", err)
+	}
+	if len(chunked.ChunkedSeries) != 1 || len(chunked.ChunkedSeries[0].Chunks) == 0 || chunked.ChunkedSeries[0].Chunks[0].Type != prompb.Chunk_XOR {
+		t.Fatalf("unexpected chunked resp: %v", chunked.String())
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	cfg := tconf.EmbeddedTSDB{Enable: true, Dir: t.TempDir(), EnableAdminAPI: true}
+	inst, r := newTestRouter(t, cfg, "")
+
+	if code := remoteWrite(t, r, []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "backup_metric"}, {Name: "ident", Value: "host1"}},
+		Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 7}},
+	}}); code != http.StatusNoContent {
+		t.Fatalf("remote write status: %d", code)
+	}
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, localReq(method, path, nil))
+		return rec
+	}
+
+	rec := do("POST", "/prometheus/api/v1/admin/tsdb/snapshot")
+	var created struct {
+		Data struct {
+			Name string `json:"name"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); rec.Code != http.StatusOK || err != nil || created.Data.Name == "" {
+		t.Fatalf("snapshot status: %d body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do("GET", "/prometheus/api/v1/admin/tsdb/snapshots")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"`+created.Data.Name+`","created_at"`) ||
+		!strings.Contains(rec.Body.String(), `"blocks":1`) {
+		t.Fatalf("snapshot list status: %d body: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = do("GET", "/prometheus/api/v1/admin/tsdb/snapshots/"+created.Data.Name)
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Fatalf("snapshot download status: %d", rec.Code)
+	}
+	archive := filepath.Join(t.TempDir(), "snapshot.tar")
+	if err := os.WriteFile(archive, rec.Body.Bytes(), 0o644); err != nil {
+		t.Fatalf("write archive fail: %v", err)
+	}
+
+	if rec = do("GET", "/prometheus/api/v1/admin/tsdb/snapshots/..%2F..%2Fetc"); rec.Code != http.StatusNotFound {
+		t.Fatalf("traversal download status: %d", rec.Code)
+	}
+
+	// restoring over a running instance must fail on the lock
+	if _, err := tsdb.Restore(archive, cfg.Dir); err == nil {
+		t.Fatal("restore into a locked dir should fail")
+	}
+
+	if rec = do("DELETE", "/prometheus/api/v1/admin/tsdb/snapshots/"+created.Data.Name); rec.Code != http.StatusNoContent {
+		t.Fatalf("snapshot delete status: %d body: %s", rec.Code, rec.Body.String())
+	}
+	if snapshots, err := inst.ListSnapshots(); err != nil || len(snapshots) != 0 {
+		t.Fatalf("snapshots after delete: %v %v", snapshots, err)
+	}
+
+	// a broken archive is refused before the target is touched
+	target := filepath.Join(t.TempDir(), "tsdb")
+	broken := filepath.Join(t.TempDir(), "broken.tar")
+	if err := os.WriteFile(broken, nil, 0o644); err != nil {
+		t.Fatalf("write archive fail: %v", err)
+	}
+	if _, err := tsdb.Restore(broken, target); err == nil {
+		t.Fatal("restore of an empty archive should fail")
+	}
+
+	count, err := tsdb.Restore(archive, target)
+	if err != nil || count != 1 {
+		t.Fatalf("restore fail: %d %v", count, err)
+	}
+
+	_, restored := newTestRouter(t, tconf.EmbeddedTSDB{Enable: true, Dir: target}, "")
+	rec = httptest.NewRecorder()
+	restored.ServeHTTP(rec, localReq("GET", "/prometheus/api/v1/query?query=backup_metric", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"7"`) {
+		t.Fatalf("query restored data status: %d body: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	cfg := tconf.EmbeddedTSDB{
+		Enable:     true,
+		Dir:        t.TempDir(),
+		Downsample: []tconf.DownsampleTier{{Resolution: "1m", Retention: "30d"}},
+	}
+	inst, r := newTestRouter(t, cfg, "")
+
+	// one hour of a gauge at 15s interval, values 0..239: each 1m window
+	// holds 4k..4k+3
+	base := time.Now().Add(-3 * time.Hour).Truncate(time.Hour)
+	var samples []prompb.Sample
+	for i := 0; i < 240; i++ {
+		samples = append(samples, prompb.Sample{Timestamp: base.Add(time.Duration(i) * 15 * time.Second).UnixMilli(), Value: float64(i)})
+	}
+	if code := remoteWrite(t, r, []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "ds_metric"}, {Name: "ident", Value: "host1"}},
+		Samples: samples,
+	}}); code != http.StatusNoContent {
+		t.Fatalf("remote write status: %d", code)
+	}
+
+	if err := inst.Downsample(); err != nil {
+		t.Fatalf("downsample fail: %v", err)
+	}
+
+	queryRange := func(query string, step time.Duration) string {
+		at := float64(base.Add(10*time.Minute).UnixMilli()) / 1000
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, localReq("GET", fmt.Sprintf("/prometheus/api/v1/query_range?query=%s&start=%f&end=%f&step=%d",
+			query, at, at, int(step.Seconds())), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("query_range %s status: %d body: %s", query, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	// small step: raw sample at +10m
+	if body := queryRange("ds_metric", 15*time.Second); !strings.Contains(body, `"40"`) {
+		t.Fatalf("unexpected raw result: %s", body)
+	}
+
+	// step 5m picks the 1m tier: avg of the window +9m (36..39), max of it,
+	// and the raw labels without the aggregate label
+	if body := queryRange("ds_metric", 5*time.Minute); !strings.Contains(body, `"37.5"`) || strings.Contains(body, "__aggr__") {
+		t.Fatalf("unexpected downsampled avg result: %s", body)
+	}
+	if body := queryRange("max_over_time(ds_metric[1m])", 5*time.Minute); !strings.Contains(body, `"39"`) {
+		t.Fatalf("unexpected downsampled max result: %s", body)
+	}
+
+	// a second pass has nothing left to do and must not fail on duplicates
+	if err := inst.Downsample(); err != nil {
+		t.Fatalf("second downsample fail: %v", err)
+	}
+}
+
+func TestExemplarsAndNativeHistograms(t *testing.T) {
+	_, r := newTestRouter(t, tconf.EmbeddedTSDB{Enable: true, Dir: t.TempDir()}, "")
+
+	now := time.Now().UnixMilli()
+	items := []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "rpc_duration_seconds_bucket"}, {Name: "le", Value: "0.5"}},
+			Samples: []prompb.Sample{{Timestamp: now, Value: 7}},
+			Exemplars: []prompb.Exemplar{{
+				Labels:    []prompb.Label{{Name: "trace_id", Value: "abc123"}},
+				Value:     0.42,
+				Timestamp: now,
+			}},
+		},
+		{
+			Labels: []prompb.Label{{Name: "__name__", Value: "rpc_latency"}},
+			Histograms: []prompb.Histogram{{
+				Count:          &prompb.Histogram_CountInt{CountInt: 5},
+				Sum:            12.5,
+				Schema:         0,
+				ZeroThreshold:  1e-128,
+				ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: 1},
+				PositiveSpans:  []prompb.BucketSpan{{Offset: 0, Length: 2}},
+				PositiveDeltas: []int64{3, -2},
+				Timestamp:      now,
+			}},
+		},
+	}
+	if code := remoteWrite(t, r, items); code != http.StatusNoContent {
+		t.Fatalf("remote write status: %d", code)
+	}
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, localReq("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := get("/prometheus/api/v1/query_exemplars?query=rpc_duration_seconds_bucket")
+	if code != http.StatusOK {
+		t.Fatalf("query_exemplars status: %d body: %s", code, body)
+	}
+	var exemplars struct {
+		Data []struct {
+			SeriesLabels map[string]string `json:"seriesLabels"`
+			Exemplars    []struct {
+				Labels map[string]string `json:"labels"`
+				Value  string            `json:"value"`
+			} `json:"exemplars"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &exemplars); err != nil {
+		t.Fatalf("unmarshal fail: %v body: %s", err, body)
+	}
+	if len(exemplars.Data) != 1 || len(exemplars.Data[0].Exemplars) != 1 ||
+		exemplars.Data[0].SeriesLabels["le"] != "0.5" ||
+		exemplars.Data[0].Exemplars[0].Labels["trace_id"] != "abc123" || exemplars.Data[0].Exemplars[0].Value != "0.42" {
+		t.Fatalf("unexpected exemplars: %s", body)
+	}
+
+	code, body = get("/prometheus/api/v1/query_exemplars")
+	if code != http.StatusBadRequest {
+		t.Fatalf("query_exemplars without query status: %d body: %s", code, body)
+	}
+
+	code, body = get("/prometheus/api/v1/query?query=histogram_count(rpc_latency)")
+	if code != http.StatusOK || !strings.Contains(body, `"5"`) {
+		t.Fatalf("histogram_count status: %d body: %s", code, body)
+	}
+
+	code, body = get("/prometheus/api/v1/query?query=rpc_latency")
+	if code != http.StatusOK || !strings.Contains(body, `"histogram"`) {
+		t.Fatalf("native histogram query status: %d body: %s", code, body)
+	}
+}
@@ -3,15 +3,19 @@ package tsdb
 import (
 	"context"
 
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/toolkits/pkg/logger"
 )
 
 // AppendTimeSeries appends remote-write series into the local storage, used
-// by the /prometheus/api/v1/write endpoint. Per-sample append errors (mostly
-// out-of-order/duplicate samples) are counted and skipped so one bad agent
-// cannot fail the whole batch; only a commit failure is returned.
+// by the /prometheus/api/v1/write endpoint: float samples, native histograms
+// and exemplars. Per-sample append errors (mostly out-of-order/duplicate
+// samples) are counted and skipped so one bad agent cannot fail the whole
+// batch; only a commit failure is returned.
 func (in *Instance) AppendTimeSeries(items []prompb.TimeSeries) error {
 	if len(items) == 0 {
 		return nil
@@ -19,8 +23,8 @@ func (in *Instance) AppendTimeSeries(items []prompb.TimeSeries) error {
 
 	app := in.DB.Appender(context.Background())
 
-	var errCount int
-	var lastErr error
+	var errCount, exemplarErrCount int
+	var lastErr, lastExemplarErr error
 	var builder labels.ScratchBuilder
 
 	for i := range items {
@@ -31,10 +35,34 @@ func (in *Instance) AppendTimeSeries(items []prompb.TimeSeries) error {
 		builder.Sort()
 		lset := builder.Labels()
 
+		var ref storage.SeriesRef
 		for _, s := range items[i].Samples {
-			if _, err := app.Append(0, lset, s.Timestamp, s.Value); err != nil {
+			r, err := app.Append(ref, lset, s.Timestamp, s.Value)
+			if err != nil {
 				errCount++
 				lastErr = err
+				continue
+			}
+			ref = r
+		}
+
+		for _, hp := range items[i].Histograms {
+			h, fh := histogramFromProto(hp)
+			r, err := app.AppendHistogram(ref, lset, hp.Timestamp, h, fh)
+			if err != nil {
+				errCount++
+				lastErr = err
+				continue
+			}
+			ref = r
+		}
+
+		// the head looks the series up by labels when ref is 0 (exemplars
+		// only batch); exemplars of series that never got a sample fail
+		for _, ep := range items[i].Exemplars {
+			if _, err := app.AppendExemplar(ref, lset, exemplarFromProto(ep, &builder)); err != nil {
+				exemplarErrCount++
+				lastExemplarErr = err
 			}
 		}
 	}
@@ -47,5 +75,69 @@ func (in *Instance) AppendTimeSeries(items []prompb.TimeSeries) error {
 		logger.Warningf("embedded tsdb append fail, dropped samples: %d, last error: %v", errCount, lastErr)
 	}
 
+	if exemplarErrCount > 0 {
+		logger.Debugf("embedded tsdb append exemplar fail, dropped exemplars: %d, last error: %v", exemplarErrCount, lastExemplarErr)
+	}
+
 	return nil
 }
+
+func exemplarFromProto(ep prompb.Exemplar, builder *labels.ScratchBuilder) exemplar.Exemplar {
+	builder.Reset()
+	for _, l := range ep.Labels {
+		builder.Add(l.Name, l.Value)
+	}
+	builder.Sort()
+
+	return exemplar.Exemplar{
+		Labels: builder.Labels(),
+		Value:  ep.Value,
+		Ts:     ep.Timestamp,
+		HasTs:  ep.Timestamp != 0,
+	}
+}
+
+// histogramFromProto converts a remote-write histogram into either an
+// integer or a float histogram, exactly one of the results is set (same as
+// prometheus storage/remote, not imported for its dependency footprint).
+func histogramFromProto(hp prompb.Histogram) (*histogram.Histogram, *histogram.FloatHistogram) {
+	if hp.IsFloatHistogram() {
+		return nil, &histogram.FloatHistogram{
+			CounterResetHint: histogram.CounterResetHint(hp.ResetHint),
+			Schema:           hp.Schema,
+			ZeroThreshold:    hp.ZeroThreshold,
+			ZeroCount:        hp.GetZeroCountFloat(),
+			Count:            hp.GetCountFloat(),
+			Sum:              hp.Sum,
+			PositiveSpans:    spansFromProto(hp.PositiveSpans),
+			PositiveBuckets:  hp.PositiveCounts,
+			NegativeSpans:    spansFromProto(hp.NegativeSpans),
+			NegativeBuckets:  hp.NegativeCounts,
+		}
+	}
+
+	return &histogram.Histogram{
+		CounterResetHint: histogram.CounterResetHint(hp.ResetHint),
+		Schema:           hp.Schema,
+		ZeroThreshold:    hp.ZeroThreshold,
+		ZeroCount:        hp.GetZeroCountInt(),
+		Count:            hp.GetCountInt(),
+		Sum:              hp.Sum,
+		PositiveSpans:    spansFromProto(hp.PositiveSpans),
+		PositiveBuckets:  hp.PositiveDeltas,
+		NegativeSpans:    spansFromProto(hp.NegativeSpans),
+		NegativeBuckets:  hp.NegativeDeltas,
+	}, nil
+}
+
+func spansFromProto(spans []prompb.BucketSpan) []histogram.Span {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	result := make([]histogram.Span, len(spans))
+	for i, s := range spans {
+		result[i] = histogram.Span{Offset: s.Offset, Length: s.Length}
+	}
+	return result
+}